@@ -1,10 +1,21 @@
 package main
 
 import (
+	"flag"
+
 	"github.com/VetheonGames/FileZap/Client/pkg/ui"
 )
 
 func main() {
-	app := ui.NewFileZapUI()
+	// Start on login passes -background to keep the window in the tray
+	background := flag.Bool(ui.BackgroundFlag, false, "start hidden in the system tray")
+	configFile := flag.String("config", "", "config file (.yaml, .toml or .json); send SIGHUP to reload it")
+	flag.Parse()
+
+	app := ui.NewFileZapUI(*configFile)
+	if *background {
+		app.RunInBackground()
+		return
+	}
 	app.Run()
 }
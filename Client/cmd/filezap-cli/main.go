@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/bench"
+	"github.com/VetheonGames/FileZap/Client/pkg/control"
+)
+
+const usage = `Usage: filezap-cli [flags] <command> [args]
+
+Commands:
+  upload <file>               Add a file to the network
+  download <zap> <output>     Reassemble the file described by a .zap file
+  status                      Show the node ID and storage stats
+  peers                       List connected peers
+  pin <zap>                   Keep a file's chunks stored on the node
+  unpin <zap>                 Stop keeping a file's chunks
+  wallet                      Show the node's account and balance
+  topup [tx-id]               Credit a chain deposit to the account, or
+                              claim from the faucet on a test network
+  usage                       Show this period's downloads and free allowance
+  bench [-size MiB] [-chunks KiB,...]
+                              Measure split/join, encryption and transfer
+                              throughput on this machine; needs no node
+
+Flags:
+`
+
+func main() {
+	// Command line flags
+	addr := flag.String("addr", control.DefaultAddr, "Address of the node's control API")
+	token := flag.String("token", os.Getenv("FILEZAP_TOKEN"), "Control API token (default $FILEZAP_TOKEN)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Benchmarks run locally, without a node
+	if args[0] == "bench" {
+		if err := runBench(args[1:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	c := control.NewClient(*addr, *token)
+	if err := run(c, args[0], args[1:]); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(c *control.Client, command string, args []string) error {
+	switch command {
+	case "upload":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: upload <file>")
+		}
+		// The node resolves paths itself, so send an absolute one
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		if err := c.Upload(path); err != nil {
+			return err
+		}
+		fmt.Printf("Uploaded %s\n", args[0])
+
+	case "download":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: download <zap> <output>")
+		}
+		zapPath, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		outputPath, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+		if err := c.Download(zapPath, outputPath); err != nil {
+			return err
+		}
+		fmt.Printf("Downloaded to %s\n", args[1])
+
+	case "status":
+		id, err := c.NodeID()
+		if err != nil {
+			return err
+		}
+		stats, err := c.Storage()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Node ID: %s\n", id)
+		fmt.Printf("Used Space: %d MB / %d MB\n", stats.UsedSpace/(1024*1024), stats.MaxSpace/(1024*1024))
+		fmt.Printf("Chunks Stored: %d\n", stats.ChunkCount)
+		fmt.Printf("Storage Requests: %d\n", stats.RequestCount)
+		fmt.Printf("Uptime: %.2f%%\n", stats.Uptime)
+
+	case "peers":
+		peers, err := c.Peers()
+		if err != nil {
+			return err
+		}
+		for _, peer := range peers {
+			fmt.Println(peer)
+		}
+
+	case "pin", "unpin":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <zap>", command)
+		}
+		zapPath, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		pinned := command == "pin"
+		if err := c.Pin(zapPath, pinned); err != nil {
+			return err
+		}
+		if pinned {
+			fmt.Printf("Pinned %s\n", args[0])
+		} else {
+			fmt.Printf("Unpinned %s\n", args[0])
+		}
+
+	case "wallet":
+		wallet, err := c.Wallet()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Account: %s\n", wallet.Account)
+		fmt.Printf("Balance: %d\n", wallet.Balance)
+
+	case "topup":
+		if len(args) > 1 {
+			return fmt.Errorf("usage: topup [tx-id]")
+		}
+		var txID string
+		if len(args) == 1 {
+			txID = args[0]
+		}
+		wallet, err := c.TopUp(txID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Balance: %d\n", wallet.Balance)
+
+	case "usage":
+		usage, err := c.Usage()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Period: %s to %s\n", time.Unix(usage.PeriodStart, 0).Format(time.DateOnly), time.Unix(usage.PeriodEnd, 0).Format(time.DateOnly))
+		fmt.Printf("Downloaded: %d MB\n", usage.Bytes/(1024*1024))
+		fmt.Printf("Free Left: %d MB / %d MB\n", usage.FreeLeft/(1024*1024), usage.FreeBytes/(1024*1024))
+
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+	return nil
+}
+
+// runBench measures the work the node does on each file and prints the
+// results as they come in
+func runBench(args []string) error {
+	defaults := bench.DefaultOptions()
+	var chunkList []string
+	for _, size := range defaults.ChunkSizes {
+		chunkList = append(chunkList, strconv.FormatInt(size/1024, 10))
+	}
+
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fileSize := flags.Int64("size", defaults.FileSize/(1024*1024), "Size of the file to split and join, in MiB")
+	chunks := flags.String("chunks", strings.Join(chunkList, ","), "Chunk sizes to compare, in KiB")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := bench.Options{FileSize: *fileSize * 1024 * 1024}
+	for _, field := range strings.Split(*chunks, ",") {
+		kib, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil || kib <= 0 {
+			return fmt.Errorf("invalid chunk size %q", field)
+		}
+		opts.ChunkSizes = append(opts.ChunkSizes, kib*1024)
+	}
+
+	fmt.Printf("%-36s %8s %14s %10s\n", "BENCHMARK", "RUNS", "TIME/OP", "MB/S")
+	return bench.Run(opts, func(r bench.Result) {
+		fmt.Printf("%-36s %8d %14s %10.2f\n", r.Name, r.N, r.PerOp(), r.Throughput())
+	})
+}
@@ -0,0 +1,37 @@
+package autostart
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The Client can start when the user logs in, through the platform's own
+// mechanism: an XDG autostart entry on Linux, a launch agent on macOS, and
+// the Run registry key on Windows.
+
+// Name identifies the Client's login entry
+const Name = "FileZap"
+
+// ErrUnsupported is returned on platforms without a login startup
+// mechanism
+var ErrUnsupported = errors.New("start on login is not supported on this platform")
+
+// Enable starts the running executable with args when the user logs in
+func Enable(args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %v", err)
+	}
+	return enable(exe, args)
+}
+
+// Disable stops starting the Client on login
+func Disable() error {
+	return disable()
+}
+
+// Enabled reports whether the Client starts on login
+func Enabled() (bool, error) {
+	return enabled()
+}
@@ -0,0 +1,80 @@
+//go:build darwin
+
+package autostart
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const agentLabel = "io.filezap.client"
+
+// agentPath returns the launch agent of the Client
+func agentPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %v", err)
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", agentLabel+".plist"), nil
+}
+
+func enable(exe string, args []string) error {
+	path, err := agentPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create launch agents directory: %v", err)
+	}
+
+	var program strings.Builder
+	for _, arg := range append([]string{exe}, args...) {
+		program.WriteString("\t\t<string>")
+		xml.EscapeText(&program, []byte(arg))
+		program.WriteString("</string>\n")
+	}
+	agent := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + agentLabel + `</string>
+	<key>ProgramArguments</key>
+	<array>
+` + program.String() + `	</array>
+	<key>RunAtLoad</key>
+	<true/>
+</dict>
+</plist>
+`
+	if err := os.WriteFile(path, []byte(agent), 0644); err != nil {
+		return fmt.Errorf("failed to write launch agent: %v", err)
+	}
+	return nil
+}
+
+func disable() error {
+	path, err := agentPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove launch agent: %v", err)
+	}
+	return nil
+}
+
+func enabled() (bool, error) {
+	path, err := agentPath()
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,75 @@
+//go:build linux
+
+package autostart
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// entryPath returns the XDG autostart entry of the Client
+func entryPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %v", err)
+	}
+	return filepath.Join(dir, "autostart", "filezap.desktop"), nil
+}
+
+func enable(exe string, args []string) error {
+	path, err := entryPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create autostart directory: %v", err)
+	}
+
+	command := []string{quoteExec(exe)}
+	for _, arg := range args {
+		command = append(command, quoteExec(arg))
+	}
+	entry := "[Desktop Entry]\n" +
+		"Type=Application\n" +
+		"Name=" + Name + "\n" +
+		"Exec=" + strings.Join(command, " ") + "\n" +
+		"X-GNOME-Autostart-enabled=true\n"
+	if err := os.WriteFile(path, []byte(entry), 0644); err != nil {
+		return fmt.Errorf("failed to write autostart entry: %v", err)
+	}
+	return nil
+}
+
+// quoteExec quotes an Exec key argument as the desktop entry spec requires
+func quoteExec(arg string) string {
+	if !strings.ContainsAny(arg, " \t\"'\\`$") {
+		return arg
+	}
+	replacer := strings.NewReplacer(`\`, `\\\\`, `"`, `\\"`, "`", "\\\\`", "$", `\\$`)
+	return `"` + replacer.Replace(arg) + `"`
+}
+
+func disable() error {
+	path, err := entryPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove autostart entry: %v", err)
+	}
+	return nil
+}
+
+func enabled() (bool, error) {
+	path, err := entryPath()
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package autostart
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnableAndDisable(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	enabled, err := Enabled()
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	require.NoError(t, enable("/opt/File Zap/client", []string{"-background"}))
+	enabled, err = Enabled()
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	entry, err := os.ReadFile(filepath.Join(dir, "autostart", "filezap.desktop"))
+	require.NoError(t, err)
+	assert.Contains(t, string(entry), `Exec="/opt/File Zap/client" -background`)
+
+	require.NoError(t, Disable())
+	require.NoError(t, Disable())
+	enabled, err = Enabled()
+	require.NoError(t, err)
+	assert.False(t, enabled)
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+
+package autostart
+
+func enable(exe string, args []string) error {
+	return ErrUnsupported
+}
+
+func disable() error {
+	return ErrUnsupported
+}
+
+func enabled() (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package autostart
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+const runKey = `Software\Microsoft\Windows\CurrentVersion\Run`
+
+func enable(exe string, args []string) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, runKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open Run key: %v", err)
+	}
+	defer key.Close()
+
+	command := []string{windows.EscapeArg(exe)}
+	for _, arg := range args {
+		command = append(command, windows.EscapeArg(arg))
+	}
+	if err := key.SetStringValue(Name, strings.Join(command, " ")); err != nil {
+		return fmt.Errorf("failed to write Run key: %v", err)
+	}
+	return nil
+}
+
+func disable() error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, runKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open Run key: %v", err)
+	}
+	defer key.Close()
+
+	if err := key.DeleteValue(Name); err != nil && !errors.Is(err, registry.ErrNotExist) {
+		return fmt.Errorf("failed to remove Run key: %v", err)
+	}
+	return nil
+}
+
+func enabled() (bool, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, runKey, registry.QUERY_VALUE)
+	if err != nil {
+		return false, fmt.Errorf("failed to open Run key: %v", err)
+	}
+	defer key.Close()
+
+	_, _, err = key.GetStringValue(Name)
+	if errors.Is(err, registry.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
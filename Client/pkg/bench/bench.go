@@ -0,0 +1,277 @@
+// Package bench measures the throughput of the work done on every file
+// shared through FileZap: splitting it into chunks and joining them again,
+// encrypting the chunks and moving them between peers. It backs the
+// filezap-cli bench command. The Divider and Network Core modules carry the
+// same measurements as go test benchmarks.
+package bench
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Divider/pkg/chunking"
+	"github.com/VetheonGames/FileZap/Divider/pkg/encryption"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Result is the outcome of one measurement
+type Result struct {
+	Name    string
+	N       int           // Times the operation ran
+	Bytes   int64         // Bytes processed per run
+	Elapsed time.Duration // Total time of all runs
+}
+
+// Throughput returns the bytes processed per second, in MB/s
+func (r Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) * float64(r.N) / 1e6 / r.Elapsed.Seconds()
+}
+
+// PerOp returns the time one run took
+func (r Result) PerOp() time.Duration {
+	if r.N == 0 {
+		return 0
+	}
+	return r.Elapsed / time.Duration(r.N)
+}
+
+// Options selects what is measured
+type Options struct {
+	FileSize   int64   // Size of the file split and joined
+	ChunkSizes []int64 // Chunk sizes to compare
+}
+
+// DefaultOptions splits a 16 MiB file into chunks from 64 KiB to 4 MiB
+func DefaultOptions() Options {
+	return Options{
+		FileSize:   16 * 1024 * 1024,
+		ChunkSizes: []int64{64 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024},
+	}
+}
+
+// ciphers are the AES-GCM key sizes the Divider accepts
+var ciphers = []struct {
+	name    string
+	keySize int
+}{
+	{"AES-128-GCM", 16},
+	{"AES-192-GCM", 24},
+	{"AES-256-GCM", 32},
+}
+
+// Run measures splitting, joining, encryption and transfers, calling report
+// with each result as it is ready
+func Run(opts Options, report func(Result)) error {
+	if opts.FileSize <= 0 || len(opts.ChunkSizes) == 0 {
+		return fmt.Errorf("a file size and at least one chunk size are required")
+	}
+	for _, run := range []func(Options, func(Result)) error{runSplitJoin, runCiphers, runTransfers} {
+		if err := run(opts, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// measure runs op the way go test runs a benchmark, scaling the number of
+// runs until the measurement is stable
+func measure(name string, bytes int64, op func() error) (Result, error) {
+	var opErr error
+	res := testing.Benchmark(func(b *testing.B) {
+		b.SetBytes(bytes)
+		for i := 0; i < b.N; i++ {
+			if err := op(); err != nil {
+				opErr = err
+				b.SkipNow()
+			}
+		}
+	})
+	if opErr != nil {
+		return Result{}, fmt.Errorf("%s failed: %w", name, opErr)
+	}
+	return Result{Name: name, N: res.N, Bytes: bytes, Elapsed: res.T}, nil
+}
+
+// runSplitJoin splits a random file into chunks and joins them again
+func runSplitJoin(opts Options, report func(Result)) error {
+	dir, err := os.MkdirTemp("", "filezap-bench-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.dat")
+	data := make([]byte, opts.FileSize)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		return fmt.Errorf("failed to write test file: %w", err)
+	}
+
+	// The Divider refuses rooted output paths
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	for _, chunkSize := range opts.ChunkSizes {
+		chunkDir := filepath.Join(dir, fmt.Sprintf("chunks-%d", chunkSize))
+		if err := os.MkdirAll(chunkDir, 0755); err != nil {
+			return fmt.Errorf("failed to create chunk directory: %w", err)
+		}
+		relChunkDir, err := filepath.Rel(wd, chunkDir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve chunk directory: %w", err)
+		}
+
+		var chunks []chunking.ChunkInfo
+		res, err := measure(fmt.Sprintf("split/chunk=%s", size(chunkSize)), opts.FileSize, func() error {
+			chunks, err = chunking.SplitFile(input, chunkSize, relChunkDir)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		report(res)
+
+		output := filepath.Join(dir, "output.dat")
+		res, err = measure(fmt.Sprintf("join/chunk=%s", size(chunkSize)), opts.FileSize, func() error {
+			return chunking.ReassembleFile(chunks, output)
+		})
+		if err != nil {
+			return err
+		}
+		report(res)
+	}
+	return nil
+}
+
+// runCiphers encrypts and decrypts chunks with each cipher
+func runCiphers(opts Options, report func(Result)) error {
+	for _, c := range ciphers {
+		rawKey := make([]byte, c.keySize)
+		if _, err := rand.Read(rawKey); err != nil {
+			return err
+		}
+		key := hex.EncodeToString(rawKey)
+
+		for _, chunkSize := range opts.ChunkSizes {
+			data := make([]byte, chunkSize)
+			res, err := measure(fmt.Sprintf("encrypt/%s/chunk=%s", c.name, size(chunkSize)), chunkSize, func() error {
+				_, err := encryption.Encrypt(data, key)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			report(res)
+
+			encrypted, err := encryption.Encrypt(data, key)
+			if err != nil {
+				return err
+			}
+			res, err = measure(fmt.Sprintf("decrypt/%s/chunk=%s", c.name, size(chunkSize)), chunkSize, func() error {
+				_, err := encryption.Decrypt(encrypted, key)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			report(res)
+		}
+	}
+	return nil
+}
+
+// runTransfers moves chunks between two peers over loopback
+func runTransfers(opts Options, report func(Result)) error {
+	server, err := newLoopbackHost()
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	client, err := newLoopbackHost()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx, peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}); err != nil {
+		return fmt.Errorf("failed to connect peers: %w", err)
+	}
+
+	store := network.NewChunkStore(server)
+	transfers := network.NewTransferManager(client)
+
+	for _, chunkSize := range opts.ChunkSizes {
+		data := make([]byte, chunkSize)
+		if _, err := rand.Read(data); err != nil {
+			return err
+		}
+		hash := fmt.Sprintf("bench-%d", chunkSize)
+		if !store.Store(hash, data) {
+			return fmt.Errorf("failed to store chunk of %s", size(chunkSize))
+		}
+
+		res, err := measure(fmt.Sprintf("download/chunk=%s", size(chunkSize)), chunkSize, func() error {
+			_, err := transfers.Download(server.ID(), hash)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		report(res)
+
+		res, err = measure(fmt.Sprintf("upload/chunk=%s", size(chunkSize)), chunkSize, func() error {
+			// A request uploaded again is a retry the receiver acknowledges
+			// without queueing, so every run sends a new one
+			req := &network.StorageRequest{ChunkHash: hash, Data: data, Size: chunkSize}
+			if err := transfers.Upload(server.ID(), req); err != nil {
+				return err
+			}
+			// Keep the receiver's queue from filling up
+			_, err := store.GetPendingRequest()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		report(res)
+	}
+	return nil
+}
+
+// newLoopbackHost creates a host listening on loopback only
+func newLoopbackHost() (host.Host, error) {
+	h, err := libp2p.New(
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.DefaultTransports,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host: %w", err)
+	}
+	return h, nil
+}
+
+// size formats a byte count in KiB or MiB
+func size(n int64) string {
+	if n >= 1024*1024 && n%(1024*1024) == 0 {
+		return fmt.Sprintf("%dMiB", n/(1024*1024))
+	}
+	return fmt.Sprintf("%dKiB", n/1024)
+}
@@ -0,0 +1,49 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunRequiresSizes(t *testing.T) {
+	assert.Error(t, Run(Options{}, func(Result) {}))
+	assert.Error(t, Run(Options{FileSize: 1024}, func(Result) {}))
+}
+
+func TestRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs every measurement")
+	}
+	var results []Result
+	err := Run(Options{FileSize: 256 * 1024, ChunkSizes: []int64{64 * 1024}}, func(r Result) {
+		results = append(results, r)
+	})
+	require.NoError(t, err)
+
+	// Split and join, encrypt and decrypt with three ciphers, download and
+	// upload
+	require.Len(t, results, 10)
+	assert.Equal(t, "split/chunk=64KiB", results[0].Name)
+	assert.Equal(t, "upload/chunk=64KiB", results[9].Name)
+	for _, r := range results {
+		assert.Positive(t, r.N, r.Name)
+		assert.Positive(t, r.Throughput(), r.Name)
+	}
+}
+
+func TestResult(t *testing.T) {
+	r := Result{N: 4, Bytes: 1e6, Elapsed: 2 * time.Second}
+	assert.Equal(t, 2.0, r.Throughput())
+	assert.Equal(t, 500*time.Millisecond, r.PerOp())
+	assert.Zero(t, Result{}.Throughput())
+	assert.Zero(t, Result{}.PerOp())
+}
+
+func TestSize(t *testing.T) {
+	assert.Equal(t, "64KiB", size(64*1024))
+	assert.Equal(t, "4MiB", size(4*1024*1024))
+	assert.Equal(t, "1536KiB", size(1536*1024))
+}
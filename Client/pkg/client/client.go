@@ -3,10 +3,20 @@ package client
 import (
     "context"
     "fmt"
+    "log"
+    "sync"
 
     "github.com/libp2p/go-libp2p/core/peer"
     ma "github.com/multiformats/go-multiaddr"
 
+    "github.com/VetheonGames/FileZap/Client/pkg/events"
+    "github.com/VetheonGames/FileZap/Client/pkg/gateway"
+    "github.com/VetheonGames/FileZap/Client/pkg/keystore"
+    "github.com/VetheonGames/FileZap/Client/pkg/library"
+    "github.com/VetheonGames/FileZap/Client/pkg/transfers"
+    "github.com/VetheonGames/FileZap/Client/pkg/zapsync"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/config"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
     "github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
     "github.com/VetheonGames/FileZap/NetworkCore/pkg/vpn"
 )
@@ -17,17 +27,56 @@ type Client struct {
     cancel     context.CancelFunc
     engine     *network.NetworkEngine
     vpnManager *vpn.VPNManager
+    transfers  *transfers.Manager
+    library    *library.Library
+    keystore   *keystore.Store
+    events     *events.Bus
+    syncer     *zapsync.Syncer // Set once sync is enabled
+    reloader   *config.Reloader
+    identity   *identity.Identity
     config     *Config
+    mu         sync.RWMutex // Guards the settings in config changed while running
 }
 
-// Config holds the client configuration
+// Config holds the client configuration. It is read from a config file and
+// FILEZAP_* environment variables by LoadConfig; the keys are the json tags.
 type Config struct {
-    NetworkCIDR   string
-    StorageDir    string
-    MetadataDir   string
-    ListenPort    int
-    EnableVPN     bool
-    VPNConfig     *VPNConfig
+    NetworkCIDR   string     `json:"network_cidr"`
+    StorageDir    string     `json:"storage_dir"`
+    MetadataDir   string     `json:"metadata_dir"`
+    ListenPort    int        `json:"listen_port"`
+    EnableVPN     bool       `json:"enable_vpn"`
+    VPNConfig     *VPNConfig `json:"vpn"`
+
+    // Transfer limits; rates are in bytes per second, zero is unlimited
+    MaxUploadRate        int64 `json:"max_upload_rate"`
+    MaxDownloadRate      int64 `json:"max_download_rate"`
+    MaxParallelTransfers int   `json:"max_parallel_transfers"`
+
+    // Free space in the storage directory below which a low disk event is
+    // published; zero disables the check
+    MinFreeSpace int64 `json:"min_free_space"`
+
+    // Bytes of other peers' chunks this node stores and the replicas placed
+    // for its uploads; zero uses the network defaults
+    StorageQuota    int64 `json:"storage_quota"`
+    ReplicationGoal int   `json:"replication_goal"`
+
+    // Passphrase the keystore is unlocked with on start; if empty the
+    // operating system's key store is used where there is one
+    KeystorePassphrase string `json:"keystore_passphrase"`
+
+    // Passphrase sealing the node key, which keeps the peer ID across
+    // restarts; if empty the key file is only protected by its permissions
+    IdentityPassphrase string `json:"identity_passphrase"`
+
+    // Address of the HTTP gateway serving library files to browsers, empty
+    // to disable it, and the IDs of the files it serves without a key
+    GatewayAddr   string   `json:"gateway_addr"`
+    GatewayPublic []string `json:"gateway_public"`
+
+    // File the settings were loaded from, read again by ReloadConfig
+    ConfigFile string `json:"-"`
 }
 
 // DefaultConfig returns default client settings
@@ -39,11 +88,19 @@ func DefaultConfig() *Config {
         ListenPort:    6001,
         EnableVPN:     false,
         VPNConfig:     DefaultVPNConfig(),
+
+        MaxParallelTransfers: 3,
+        MinFreeSpace:         1024 * 1024 * 1024, // 1GB
     }
 }
 
 // NewClient creates a new FileZap client
 func NewClient(ctx context.Context, cfg *Config) (*Client, error) {
+    id, err := identity.LoadOrCreate(cfg.identityPath(), cfg.IdentityPassphrase)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load identity: %w", err)
+    }
+
     ctx, cancel := context.WithCancel(ctx)
 
     // Create network engine config
@@ -51,6 +108,9 @@ func NewClient(ctx context.Context, cfg *Config) (*Client, error) {
     engineCfg.ChunkCacheDir = cfg.StorageDir
     engineCfg.MetadataStore = cfg.MetadataDir
     engineCfg.Transport.ListenPort = cfg.ListenPort
+    engineCfg.Storage = cfg.storageOffer()
+    engineCfg.ReplicationGoal = cfg.replicationGoal()
+    engineCfg.Identity = id
 
     // Configure VPN if enabled
     if cfg.EnableVPN {
@@ -69,12 +129,61 @@ func NewClient(ctx context.Context, cfg *Config) (*Client, error) {
         return nil, fmt.Errorf("failed to create network engine: %w", err)
     }
 
+    // Load interrupted uploads and downloads
+    tm, err := transfers.Open(cfg.MetadataDir)
+    if err != nil {
+        engine.Close()
+        cancel()
+        return nil, fmt.Errorf("failed to open transfers: %w", err)
+    }
+    tm.SetLimits(transfers.Limits{
+        MaxUploadRate:        cfg.MaxUploadRate,
+        MaxDownloadRate:      cfg.MaxDownloadRate,
+        MaxParallelTransfers: cfg.MaxParallelTransfers,
+    })
+
+    lib, err := library.Open(cfg.MetadataDir)
+    if err != nil {
+        tm.Close()
+        engine.Close()
+        cancel()
+        return nil, fmt.Errorf("failed to open library: %w", err)
+    }
+
+    keys, err := keystore.Open(cfg.MetadataDir)
+    if err != nil {
+        lib.Close()
+        tm.Close()
+        engine.Close()
+        cancel()
+        return nil, fmt.Errorf("failed to open keystore: %w", err)
+    }
+
     client := &Client{
         ctx:        ctx,
         cancel:     cancel,
         engine:     engine,
+        transfers:  tm,
+        library:    lib,
+        keystore:   keys,
+        events:     events.NewBus(events.DefaultHistory),
+        identity:   id,
         config:     cfg,
     }
+    tm.SetStateHook(client.transferEvent)
+    client.reloader = config.NewReloader(cfg.source(), func() interface{} {
+        reloaded := DefaultConfig()
+        reloaded.ConfigFile = cfg.ConfigFile
+        return reloaded
+    }, client.applyConfig)
+    go client.reloader.Watch(ctx)
+    go client.monitorDisk()
+    if cfg.GatewayAddr != "" {
+        go client.serveGateway(cfg.GatewayAddr, cfg.GatewayPublic)
+    }
+    if err := client.unlockOnStart(); err != nil {
+        log.Printf("Failed to unlock keystore: %v", err)
+    }
 
     // Store VPN manager reference if enabled
     if cfg.EnableVPN {
@@ -86,6 +195,14 @@ func NewClient(ctx context.Context, cfg *Config) (*Client, error) {
     return client, nil
 }
 
+// serveGateway serves the library's files over HTTP until the client closes
+func (c *Client) serveGateway(addr string, public []string) {
+    gw := gateway.New(c.library, c.keystore, public)
+    if err := gw.ListenAndServe(c.ctx, addr); err != nil {
+        log.Printf("Failed to serve gateway: %v", err)
+    }
+}
+
 // Connect connects to a FileZap peer
 func (c *Client) Connect(addr ma.Multiaddr) error {
     return c.engine.Connect(addr)
@@ -115,6 +232,18 @@ func (c *Client) Bootstrap(addrs []string) error {
 // Close shuts down the client
 func (c *Client) Close() error {
     c.cancel()
+    if c.syncer != nil {
+        c.syncer.Close()
+    }
+    if err := c.library.Close(); err != nil {
+        c.transfers.Close()
+        c.engine.Close()
+        return fmt.Errorf("failed to close library: %w", err)
+    }
+    if err := c.transfers.Close(); err != nil {
+        c.engine.Close()
+        return fmt.Errorf("failed to close transfers: %w", err)
+    }
     return c.engine.Close()
 }
 
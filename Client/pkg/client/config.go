@@ -0,0 +1,95 @@
+package client
+
+import (
+    "fmt"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/config"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
+)
+
+// The client's settings come from an optional config file overridden by
+// environment variables such as FILEZAP_MAX_UPLOAD_RATE. Transfer limits,
+// the low disk threshold, the storage quota and the replication goal are
+// reloaded while running, on SIGHUP or from the UI; the other settings
+// take effect on restart.
+
+// EnvPrefix prefixes the environment variables overriding the config file
+const EnvPrefix = "FILEZAP"
+
+// LoadConfig returns the default settings overridden by the config file at
+// path, if not empty, and the environment
+func LoadConfig(path string) (*Config, error) {
+    cfg := DefaultConfig()
+    cfg.ConfigFile = path
+    if err := cfg.source().Load(cfg); err != nil {
+        return nil, fmt.Errorf("failed to load config: %w", err)
+    }
+    return cfg, nil
+}
+
+// source returns where the settings are loaded from
+func (cfg *Config) source() config.Source {
+    return config.Source{Path: cfg.ConfigFile, EnvPrefix: EnvPrefix}
+}
+
+// Validate implements config.Validator
+func (cfg *Config) Validate() error {
+    if cfg.ListenPort < 0 || cfg.ListenPort > 65534 {
+        return fmt.Errorf("listen_port must be between 0 and 65534")
+    }
+    if cfg.MaxUploadRate < 0 || cfg.MaxDownloadRate < 0 || cfg.MaxParallelTransfers < 0 {
+        return fmt.Errorf("transfer limits must not be negative")
+    }
+    if cfg.MinFreeSpace < 0 || cfg.StorageQuota < 0 || cfg.ReplicationGoal < 0 {
+        return fmt.Errorf("min_free_space, storage_quota and replication_goal must not be negative")
+    }
+    if cfg.EnableVPN && cfg.VPNConfig == nil {
+        return fmt.Errorf("vpn settings are required when enable_vpn is set")
+    }
+    return nil
+}
+
+// storageOffer returns the storage offer for the configured quota
+func (cfg *Config) storageOffer() network.StorageConfig {
+    offer := network.DefaultStorageConfig()
+    if cfg.StorageQuota > 0 {
+        offer.Quota = cfg.StorageQuota
+    }
+    return offer
+}
+
+// replicationGoal returns the configured replication goal
+func (cfg *Config) replicationGoal() int {
+    if cfg.ReplicationGoal > 0 {
+        return cfg.ReplicationGoal
+    }
+    return network.DefaultReplicationGoal
+}
+
+// ReloadConfig reads the config file and environment again and applies the
+// settings that can change while running. Invalid settings are refused and
+// the running ones kept.
+func (c *Client) ReloadConfig() error {
+    return c.reloader.Reload()
+}
+
+// applyConfig puts the reloadable settings into effect
+func (c *Client) applyConfig(settings interface{}) error {
+    cfg := settings.(*Config)
+    if err := c.engine.SetStorageConfig(cfg.storageOffer()); err != nil {
+        return fmt.Errorf("failed to apply storage quota: %w", err)
+    }
+    if err := c.engine.SetReplicationGoal(cfg.replicationGoal()); err != nil {
+        return fmt.Errorf("failed to apply replication goal: %w", err)
+    }
+    if err := c.SetTransferLimits(cfg.MaxUploadRate, cfg.MaxDownloadRate, cfg.MaxParallelTransfers); err != nil {
+        return err
+    }
+
+    c.mu.Lock()
+    c.config.MinFreeSpace = cfg.MinFreeSpace
+    c.config.StorageQuota = cfg.StorageQuota
+    c.config.ReplicationGoal = cfg.ReplicationGoal
+    c.mu.Unlock()
+    return nil
+}
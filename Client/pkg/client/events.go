@@ -0,0 +1,112 @@
+package client
+
+import (
+    "fmt"
+    "log"
+    "path/filepath"
+    "time"
+
+    "github.com/VetheonGames/FileZap/Client/pkg/events"
+    "github.com/VetheonGames/FileZap/Client/pkg/transfers"
+)
+
+// diskCheckInterval is how often free space in the storage directory is
+// checked
+const diskCheckInterval = time.Minute
+
+// Events returns the bus background work is announced on
+func (c *Client) Events() *events.Bus {
+    return c.events
+}
+
+// transferEvent announces finished and failed transfers
+func (c *Client) transferEvent(t *transfers.Transfer) {
+    name := filepath.Base(t.Source)
+    data := map[string]string{"transfer_id": t.ID, "kind": t.Kind, "source": t.Source}
+
+    switch t.State {
+    case transfers.StateCompleted:
+        c.events.Publish(events.Event{
+            Kind:    events.TransferComplete,
+            Title:   fmt.Sprintf("%s complete", kindTitle(t.Kind)),
+            Message: fmt.Sprintf("%s finished", name),
+            Data:    data,
+        })
+    case transfers.StateFailed:
+        c.events.Publish(events.Event{
+            Kind:    events.TransferFailed,
+            Title:   fmt.Sprintf("%s failed", kindTitle(t.Kind)),
+            Message: fmt.Sprintf("%s: %s", name, t.Error),
+            Data:    data,
+        })
+    }
+}
+
+func kindTitle(kind string) string {
+    if kind == transfers.Upload {
+        return "Upload"
+    }
+    return "Download"
+}
+
+// ReportReplication records how many replicas of a library file exist,
+// announcing the file when it drops below its replication goal
+func (c *Client) ReportReplication(id string, replicas, goal int) error {
+    before, err := c.library.Get(id)
+    if err != nil {
+        return err
+    }
+    if err := c.library.UpdateHealth(id, replicas, goal); err != nil {
+        return err
+    }
+
+    wasHealthy := before.HealthChecked == 0 || before.Healthy()
+    if replicas < goal && wasHealthy {
+        c.events.Publish(events.Event{
+            Kind:    events.ReplicationDegraded,
+            Title:   "Replication degraded",
+            Message: fmt.Sprintf("%s has %d of %d replicas", before.Name, replicas, goal),
+            Data:    map[string]string{"file_id": id},
+        })
+    }
+    return nil
+}
+
+// monitorDisk announces when free space in the storage directory falls
+// below the configured minimum, once each time it does. The minimum is read
+// on every check so that reloaded settings apply.
+func (c *Client) monitorDisk() {
+    ticker := time.NewTicker(diskCheckInterval)
+    defer ticker.Stop()
+
+    low := false
+    for {
+        c.mu.RLock()
+        minFree := c.config.MinFreeSpace
+        c.mu.RUnlock()
+
+        if minFree <= 0 {
+            low = false
+        } else if free, err := freeSpace(c.config.StorageDir); err != nil {
+            log.Printf("Failed to check free space in %s: %v", c.config.StorageDir, err)
+        } else if free < uint64(minFree) {
+            if !low {
+                c.events.Publish(events.Event{
+                    Kind:    events.LowDisk,
+                    Title:   "Low disk space",
+                    Message: fmt.Sprintf("%d MB free in %s", free/(1024*1024), c.config.StorageDir),
+                    Data:    map[string]string{"path": c.config.StorageDir},
+                })
+            }
+            low = true
+        } else {
+            low = false
+        }
+
+        select {
+        case <-c.ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
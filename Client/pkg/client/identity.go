@@ -0,0 +1,44 @@
+package client
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
+)
+
+// The node key lives in the metadata directory, so the client keeps its
+// peer ID, and the reputation other peers hold for it, across restarts.
+// Exporting and importing it moves the node to another machine.
+
+// identityPath returns where the node key is kept
+func (cfg *Config) identityPath() string {
+    return filepath.Join(cfg.MetadataDir, identity.FileName)
+}
+
+// ExportIdentity writes the node key to path, sealed with passphrase
+func (c *Client) ExportIdentity(path, passphrase string) error {
+    data, err := c.identity.Export(passphrase)
+    if err != nil {
+        return err
+    }
+    if err := os.WriteFile(path, data, 0600); err != nil {
+        return fmt.Errorf("failed to write export: %w", err)
+    }
+    return nil
+}
+
+// ImportIdentity replaces the node key with the one exported to path. The
+// client runs with the imported peer ID from its next start.
+func (c *Client) ImportIdentity(path, passphrase string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return fmt.Errorf("failed to read export: %w", err)
+    }
+    id, err := identity.Import(data, passphrase)
+    if err != nil {
+        return err
+    }
+    return id.Save(c.config.identityPath(), c.config.IdentityPassphrase)
+}
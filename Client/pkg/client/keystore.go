@@ -0,0 +1,91 @@
+package client
+
+import (
+    "errors"
+    "fmt"
+    "log"
+
+    "github.com/VetheonGames/FileZap/Client/pkg/keystore"
+)
+
+// unlockOnStart unlocks the keystore with the configured passphrase, or
+// with the operating system's key store if no passphrase is set. The
+// keystore stays locked if neither is available, until UnlockKeystore.
+func (c *Client) unlockOnStart() error {
+    if c.config.KeystorePassphrase != "" {
+        return c.UnlockKeystore(c.config.KeystorePassphrase)
+    }
+
+    system, err := keystore.System()
+    if errors.Is(err, keystore.ErrNoSystemProtection) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if c.keystore.Initialized() && c.keystore.Protection() != system.Name() {
+        return nil
+    }
+    if err := c.keystore.Unlock(system); err != nil {
+        return err
+    }
+    c.protectLibraryKeys()
+    return nil
+}
+
+// UnlockKeystore unlocks the keystore with a passphrase. The first unlock
+// creates the keystore protected by that passphrase.
+func (c *Client) UnlockKeystore(passphrase string) error {
+    if err := c.keystore.Unlock(keystore.Passphrase(passphrase)); err != nil {
+        return err
+    }
+    c.protectLibraryKeys()
+    return nil
+}
+
+// KeystoreLocked reports whether file keys are unavailable until
+// UnlockKeystore
+func (c *Client) KeystoreLocked() bool {
+    return c.keystore.Locked()
+}
+
+// ChangeKeystorePassphrase protects the keystore with a new passphrase
+func (c *Client) ChangeKeystorePassphrase(passphrase string) error {
+    return c.keystore.ChangeProtection(keystore.Passphrase(passphrase))
+}
+
+// Keystore returns the store file keys are kept in, for the file
+// operations to use
+func (c *Client) Keystore() *keystore.Store {
+    return c.keystore
+}
+
+// protectManifest moves the plaintext key of a .zap manifest into the
+// keystore before the manifest is added to the library
+func (c *Client) protectManifest(zapPath string) error {
+    if _, err := c.keystore.ImportManifest(zapPath); err != nil {
+        return fmt.Errorf("failed to protect key of %s: %w", zapPath, err)
+    }
+    return nil
+}
+
+// protectLibraryKeys moves the keys the library recorded from manifests
+// before the keystore existed into the keystore
+func (c *Client) protectLibraryKeys() {
+    for _, entry := range c.library.Search("") {
+        if entry.Key == "" {
+            continue
+        }
+        if err := c.keystore.PutFileKey(entry.ID, []byte(entry.Key)); err != nil {
+            log.Printf("Failed to protect key of %s: %v", entry.Name, err)
+            continue
+        }
+        entry.Key = ""
+        if err := c.library.Add(entry); err != nil {
+            log.Printf("Failed to update library entry %s: %v", entry.Name, err)
+        }
+        if err := c.protectManifest(entry.Manifest); err != nil {
+            log.Printf("%v", err)
+        }
+    }
+}
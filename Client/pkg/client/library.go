@@ -0,0 +1,142 @@
+package client
+
+import (
+    "errors"
+    "fmt"
+    "log"
+
+    "github.com/VetheonGames/FileZap/Client/pkg/keystore"
+    "github.com/VetheonGames/FileZap/Client/pkg/library"
+    "github.com/VetheonGames/FileZap/Client/pkg/sharelink"
+)
+
+// SearchLibrary returns the library files whose name contains query, most
+// recently added first
+func (c *Client) SearchLibrary(query string) []*library.Entry {
+    return c.library.Search(query)
+}
+
+// AddToLibrary records a .zap file the user uploaded or downloaded
+func (c *Client) AddToLibrary(zapPath, origin string) (*library.Entry, error) {
+    if err := c.protectManifest(zapPath); err != nil {
+        return nil, err
+    }
+    return c.library.AddManifest(zapPath, origin)
+}
+
+// RecordDownload records a completed download of a .zap file in the
+// library, adding the file if it is new
+func (c *Client) RecordDownload(zapPath, outputPath string) error {
+    if err := c.protectManifest(zapPath); err != nil {
+        return err
+    }
+    entry, err := c.library.AddManifest(zapPath, library.OriginDownloaded)
+    if err != nil {
+        return err
+    }
+    return c.library.RecordDownload(entry.ID, outputPath)
+}
+
+// PinLibraryFile marks whether a library file's chunks are kept locally
+func (c *Client) PinLibraryFile(id string, pinned bool) error {
+    return c.library.SetPinned(id, pinned)
+}
+
+// ReshareLibraryFile copies a library file's manifest into dir to share it
+func (c *Client) ReshareLibraryFile(id, dir string) (string, error) {
+    return c.library.Reshare(id, dir)
+}
+
+// RemoveLibraryFile removes a file from the library and deletes its manifest
+func (c *Client) RemoveLibraryFile(id string) error {
+    return c.library.Remove(id, true)
+}
+
+// ShareLink returns a filezap:// link to a library file. If passphrase is
+// set the file's key is included, sealed with the passphrase.
+func (c *Client) ShareLink(id, passphrase string) (string, error) {
+    entry, err := c.library.Get(id)
+    if err != nil {
+        return "", err
+    }
+
+    link := &sharelink.Link{FileID: entry.ID, Name: entry.Name}
+    if passphrase != "" {
+        key, err := c.keystore.FileKey(entry.ID)
+        if errors.Is(err, keystore.ErrNotFound) {
+            return "", fmt.Errorf("no key is known for %s", entry.Name)
+        }
+        if err != nil {
+            return "", err
+        }
+        if link.WrappedKey, err = sharelink.WrapKey(key, passphrase); err != nil {
+            return "", err
+        }
+    }
+    return link.String(), nil
+}
+
+// RecordImport adds a file downloaded from a share link to the library,
+// storing its key in the keystore if the link carried one
+func (c *Client) RecordImport(link, passphrase, zapPath, outputPath string) error {
+    parsed, err := sharelink.Parse(link)
+    if err != nil {
+        return err
+    }
+
+    entry, err := c.library.AddManifest(zapPath, library.OriginDownloaded)
+    if err != nil {
+        return err
+    }
+    if entry.ID != parsed.FileID {
+        return fmt.Errorf("manifest %s is not the shared file", zapPath)
+    }
+    if len(parsed.WrappedKey) > 0 {
+        key, err := sharelink.UnwrapKey(parsed.WrappedKey, passphrase)
+        if err != nil {
+            return err
+        }
+        if err := c.keystore.PutFileKey(entry.ID, key); err != nil {
+            return err
+        }
+    }
+    return c.library.RecordDownload(entry.ID, outputPath)
+}
+
+// librarySyncHook records the uploads of Zap sync in the library. A new
+// version of a file replaces the previous one, which is kept as a snapshot.
+func (c *Client) librarySyncHook(path, manifest, previous string) {
+    if previous != "" {
+        if err := c.library.MoveManifest(manifest, previous); err != nil {
+            log.Printf("Failed to record previous version of %s: %v", path, err)
+        }
+    }
+    if err := c.protectManifest(manifest); err != nil {
+        log.Printf("Failed to add %s to library: %v", path, err)
+        return
+    }
+    if _, err := c.library.AddVersion(manifest, library.OriginUploaded, previous); err != nil {
+        log.Printf("Failed to add %s to library: %v", path, err)
+    }
+}
+
+// LibraryVersions returns the earlier versions of a library file, in the
+// order they were replaced
+func (c *Client) LibraryVersions(id string) ([]library.Snapshot, error) {
+    return c.library.Snapshots(id)
+}
+
+// RestoreLibraryVersion makes an earlier version of a library file its
+// current one, once it has been downloaded from the network to outputPath
+// with its snapshot's manifest. The download is recorded in the file's
+// history.
+func (c *Client) RestoreLibraryVersion(id, snapshotID, outputPath string) (*library.Entry, error) {
+    entry, err := c.library.RestoreSnapshot(id, snapshotID)
+    if err != nil {
+        return nil, err
+    }
+    if err := c.library.RecordDownload(entry.ID, outputPath); err != nil {
+        return nil, err
+    }
+    return c.library.Get(entry.ID)
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+    "fmt"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+    ma "github.com/multiformats/go-multiaddr"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
+)
+
+// PeerStats returns the live statistics of the peers the client knows
+func (c *Client) PeerStats() []network.PeerStats {
+    return c.engine.PeerStats()
+}
+
+// ConnectPeer connects to a peer by its p2p address, e.g.
+// /ip4/1.2.3.4/tcp/6001/p2p/<peer ID>
+func (c *Client) ConnectPeer(addr string) error {
+    maddr, err := ma.NewMultiaddr(addr)
+    if err != nil {
+        return fmt.Errorf("invalid multiaddr %s: %w", addr, err)
+    }
+    return c.engine.ConnectPeer(maddr)
+}
+
+// DisconnectPeer closes the connections to a peer
+func (c *Client) DisconnectPeer(id string) error {
+    peerID, err := peer.Decode(id)
+    if err != nil {
+        return fmt.Errorf("invalid peer ID %s: %w", id, err)
+    }
+    return c.engine.DisconnectPeer(peerID)
+}
+
+// ReportPeer reports a peer for misbehavior, opening a quorum vote on
+// removing it
+func (c *Client) ReportPeer(id, reason string) (*network.PeerReport, error) {
+    peerID, err := peer.Decode(id)
+    if err != nil {
+        return nil, fmt.Errorf("invalid peer ID %s: %w", id, err)
+    }
+    return c.engine.ReportPeer(peerID, reason)
+}
+
+// ReportOutcome returns the outcome of the vote a report opened
+func (c *Client) ReportOutcome(voteID string) (string, error) {
+    return c.engine.ReportOutcome(voteID)
+}
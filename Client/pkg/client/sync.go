@@ -0,0 +1,48 @@
+package client
+
+import (
+    "fmt"
+
+    "github.com/VetheonGames/FileZap/Client/pkg/zapsync"
+)
+
+// EnableSync starts uploading the files of watched folders with upload.
+// Folders watched before the last shutdown are resumed.
+func (c *Client) EnableSync(upload zapsync.Uploader) error {
+    if c.syncer != nil {
+        return fmt.Errorf("sync is already enabled")
+    }
+
+    syncer, err := zapsync.Open(c.config.MetadataDir, upload)
+    if err != nil {
+        return fmt.Errorf("failed to start sync: %w", err)
+    }
+    syncer.SetUploadHook(c.librarySyncHook)
+    c.syncer = syncer
+    go syncer.Run(c.ctx)
+    return nil
+}
+
+// AddSyncFolder watches a folder and uploads its new and modified files
+func (c *Client) AddSyncFolder(folder string) error {
+    if c.syncer == nil {
+        return fmt.Errorf("sync is not enabled")
+    }
+    return c.syncer.AddFolder(folder)
+}
+
+// RemoveSyncFolder stops watching a folder
+func (c *Client) RemoveSyncFolder(folder string) error {
+    if c.syncer == nil {
+        return fmt.Errorf("sync is not enabled")
+    }
+    return c.syncer.RemoveFolder(folder)
+}
+
+// SyncFolders returns the watched folders
+func (c *Client) SyncFolders() []string {
+    if c.syncer == nil {
+        return nil
+    }
+    return c.syncer.Folders()
+}
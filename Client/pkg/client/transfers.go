@@ -0,0 +1,52 @@
+package client
+
+import (
+    "fmt"
+
+    "github.com/VetheonGames/FileZap/Client/pkg/transfers"
+)
+
+// Transfers returns the manager recording upload and download progress.
+// Transfers still active after a restart are listed by its Pending method.
+func (c *Client) Transfers() *transfers.Manager {
+    return c.transfers
+}
+
+// ListTransfers returns all uploads and downloads, oldest first
+func (c *Client) ListTransfers() []*transfers.Transfer {
+    return c.transfers.List()
+}
+
+// PauseTransfer pauses an active transfer after its current chunk
+func (c *Client) PauseTransfer(id string) error {
+    return c.transfers.Pause(id)
+}
+
+// ResumeTransfer reactivates a paused or failed transfer
+func (c *Client) ResumeTransfer(id string) error {
+    return c.transfers.Resume(id)
+}
+
+// RemoveTransfer forgets a transfer and its recorded progress
+func (c *Client) RemoveTransfer(id string) error {
+    return c.transfers.Remove(id)
+}
+
+// SetTransferLimits changes the bandwidth and concurrency limits of
+// transfers, including those already running
+func (c *Client) SetTransferLimits(uploadRate, downloadRate int64, parallel int) error {
+    if uploadRate < 0 || downloadRate < 0 || parallel < 0 {
+        return fmt.Errorf("transfer limits must not be negative")
+    }
+    c.mu.Lock()
+    c.config.MaxUploadRate = uploadRate
+    c.config.MaxDownloadRate = downloadRate
+    c.config.MaxParallelTransfers = parallel
+    c.mu.Unlock()
+    c.transfers.SetLimits(transfers.Limits{
+        MaxUploadRate:        uploadRate,
+        MaxDownloadRate:      downloadRate,
+        MaxParallelTransfers: parallel,
+    })
+    return nil
+}
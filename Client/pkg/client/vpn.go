@@ -10,10 +10,10 @@ import (
 
 // VPNConfig holds the client-side VPN configuration
 type VPNConfig struct {
-    Enabled       bool   // Whether to enable VPN functionality
-    NetworkCIDR   string // Network CIDR for VPN (e.g., "10.42.0.0/16")
-    InterfaceName string // Name for TUN interface (e.g., "tun0")
-    NetworkKey    string // Shared key for the VPN network
+    Enabled       bool   `json:"enabled"`        // Whether to enable VPN functionality
+    NetworkCIDR   string `json:"network_cidr"`   // Network CIDR for VPN (e.g., "10.42.0.0/16")
+    InterfaceName string `json:"interface_name"` // Name for TUN interface (e.g., "tun0")
+    NetworkKey    string `json:"network_key"`    // Shared key for the VPN network
 }
 
 // DefaultVPNConfig returns default VPN settings
@@ -45,6 +45,7 @@ func (c *Client) ConnectVPN(cfg *VPNConfig) error {
         InterfaceName: cfg.InterfaceName,
         NetworkKey:    cfg.NetworkKey,
     }
+    engineCfg.Identity = c.identity
 
     // Create network engine with VPN support
     engine, err := network.NewNetworkEngine(c.ctx, engineCfg)
@@ -0,0 +1,341 @@
+package contacts
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Contacts are peers a user sends files to directly. Each node has an
+// X25519 identity key; users exchange contact codes out of band, e.g. in a
+// chat message:
+//
+//	filezap-contact:<peer ID>:<public key>
+//
+// A contact's key is pinned when it is first added, and a code for the same
+// peer with a different key is refused, so a key swapped in transit after
+// the first exchange is noticed. A file sent to a contact carries its key
+// sealed to the contact's public key, which only the contact can open.
+const (
+	CodePrefix   = "filezap-contact:"
+	contactsFile = "contacts.json"
+	identityFile = "contact.key"
+)
+
+var (
+	ErrNotFound       = errors.New("contact not found")
+	ErrKeyMismatch    = errors.New("contact key does not match the pinned key")
+	ErrUnknownContact = errors.New("sender is not a contact")
+)
+
+// Contact is a peer with a pinned public key
+type Contact struct {
+	Name      string    `json:"name"`
+	PeerID    string    `json:"peer_id"`
+	PublicKey []byte    `json:"public_key"`
+	Added     time.Time `json:"added"`
+}
+
+// Delivery is a file sent to this node by a contact
+type Delivery struct {
+	FileID    string    `json:"file_id"`
+	Name      string    `json:"name"`
+	From      string    `json:"from"`       // Sender's peer ID
+	SealedKey []byte    `json:"sealed_key"` // File key sealed to our public key
+	Received  time.Time `json:"received"`
+}
+
+// state is what the book persists between runs
+type state struct {
+	Contacts map[string]*Contact `json:"contacts"` // By peer ID
+	Inbox    []*Delivery         `json:"inbox"`
+}
+
+// Book holds the node's identity key, its contacts, and the files contacts
+// have sent it
+type Book struct {
+	dir        string
+	publicKey  [32]byte
+	privateKey [32]byte
+	state      state
+	mu         sync.RWMutex
+}
+
+// Open loads the contacts stored in dataDir, creating the node's identity
+// key on first use
+func Open(dataDir string) (*Book, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create contacts directory: %v", err)
+	}
+
+	b := &Book{
+		dir:   dataDir,
+		state: state{Contacts: make(map[string]*Contact)},
+	}
+	if err := b.loadIdentity(); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dataDir, contactsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read contacts: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &b.state); err != nil {
+			return nil, fmt.Errorf("failed to parse contacts: %v", err)
+		}
+		if b.state.Contacts == nil {
+			b.state.Contacts = make(map[string]*Contact)
+		}
+	}
+	return b, nil
+}
+
+// loadIdentity reads the identity private key, generating it if missing
+func (b *Book) loadIdentity() error {
+	path := filepath.Join(b.dir, identityFile)
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if len(data) != 32 {
+			return fmt.Errorf("invalid identity key in %s", path)
+		}
+		copy(b.privateKey[:], data)
+	case os.IsNotExist(err):
+		if _, err := rand.Read(b.privateKey[:]); err != nil {
+			return fmt.Errorf("failed to generate identity key: %v", err)
+		}
+		if err := os.WriteFile(path, b.privateKey[:], 0600); err != nil {
+			return fmt.Errorf("failed to save identity key: %v", err)
+		}
+	default:
+		return fmt.Errorf("failed to read identity key: %v", err)
+	}
+
+	public, err := curve25519.X25519(b.privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return fmt.Errorf("failed to derive public key: %v", err)
+	}
+	copy(b.publicKey[:], public)
+	return nil
+}
+
+// IdentityKey returns the node's X25519 private key, to move it into a
+// keystore
+func (b *Book) IdentityKey() []byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]byte(nil), b.privateKey[:]...)
+}
+
+// SetIdentity replaces the identity key with one kept elsewhere, such as a
+// keystore, and deletes the plaintext key file
+func (b *Book) SetIdentity(privateKey []byte) error {
+	if len(privateKey) != 32 {
+		return fmt.Errorf("invalid identity key length: %d", len(privateKey))
+	}
+	public, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return fmt.Errorf("failed to derive public key: %v", err)
+	}
+
+	b.mu.Lock()
+	copy(b.privateKey[:], privateKey)
+	copy(b.publicKey[:], public)
+	b.mu.Unlock()
+
+	if err := os.Remove(filepath.Join(b.dir, identityFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove identity key file: %v", err)
+	}
+	return nil
+}
+
+// PublicKey returns the node's X25519 public key
+func (b *Book) PublicKey() []byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]byte(nil), b.publicKey[:]...)
+}
+
+// Code returns the contact code to give to others for peerID, this node's
+// peer ID
+func (b *Book) Code(peerID string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return CodePrefix + peerID + ":" + base64.RawURLEncoding.EncodeToString(b.publicKey[:])
+}
+
+// ParseCode decodes a contact code into a peer ID and public key
+func ParseCode(code string) (string, []byte, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(code), CodePrefix)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid contact code: must start with %s", CodePrefix)
+	}
+	i := strings.LastIndex(rest, ":")
+	if i <= 0 {
+		return "", nil, fmt.Errorf("invalid contact code: missing peer ID")
+	}
+	key, err := base64.RawURLEncoding.DecodeString(rest[i+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid contact code key: %v", err)
+	}
+	if len(key) != 32 {
+		return "", nil, fmt.Errorf("invalid contact code key length: %d", len(key))
+	}
+	return rest[:i], key, nil
+}
+
+// Add adds the peer named by a contact code. Adding a known peer again
+// renames it, but only if the key matches the pinned one.
+func (b *Book) Add(name, code string) (*Contact, error) {
+	peerID, key, err := ParseCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = peerID
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if existing, ok := b.state.Contacts[peerID]; ok {
+		if string(existing.PublicKey) != string(key) {
+			return nil, ErrKeyMismatch
+		}
+		existing.Name = name
+		return existing, b.saveLocked()
+	}
+
+	contact := &Contact{Name: name, PeerID: peerID, PublicKey: key, Added: time.Now()}
+	b.state.Contacts[peerID] = contact
+	return contact, b.saveLocked()
+}
+
+// Remove forgets a contact and its pinned key
+func (b *Book) Remove(peerID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.state.Contacts[peerID]; !ok {
+		return ErrNotFound
+	}
+	delete(b.state.Contacts, peerID)
+	return b.saveLocked()
+}
+
+// Get returns a contact by peer ID
+func (b *Book) Get(peerID string) (*Contact, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	contact, ok := b.state.Contacts[peerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return contact, nil
+}
+
+// List returns the contacts sorted by name
+func (b *Book) List() []*Contact {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	list := make([]*Contact, 0, len(b.state.Contacts))
+	for _, contact := range b.state.Contacts {
+		list = append(list, contact)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// SealFor seals a file key to a contact's pinned public key
+func (b *Book) SealFor(peerID string, fileKey []byte) ([]byte, error) {
+	contact, err := b.Get(peerID)
+	if err != nil {
+		return nil, err
+	}
+	return keymanager.SealKey(fileKey, contact.PublicKey)
+}
+
+// Receive records a file sent by a contact. Files from peers that are not
+// contacts are refused.
+func (b *Book) Receive(d *Delivery) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.state.Contacts[d.From]; !ok {
+		return ErrUnknownContact
+	}
+	if d.Received.IsZero() {
+		d.Received = time.Now()
+	}
+
+	// A file sent again replaces the earlier delivery
+	for i, existing := range b.state.Inbox {
+		if existing.FileID == d.FileID && existing.From == d.From {
+			b.state.Inbox[i] = d
+			return b.saveLocked()
+		}
+	}
+	b.state.Inbox = append(b.state.Inbox, d)
+	return b.saveLocked()
+}
+
+// Inbox returns the files sent by contacts, oldest first
+func (b *Book) Inbox() []*Delivery {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return append([]*Delivery(nil), b.state.Inbox...)
+}
+
+// OpenKey opens the file key of a delivery
+func (b *Book) OpenKey(d *Delivery) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return keymanager.OpenKey(d.SealedKey, &b.publicKey, &b.privateKey)
+}
+
+// Dismiss removes a delivery from the inbox
+func (b *Book) Dismiss(fileID, from string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, d := range b.state.Inbox {
+		if d.FileID == fileID && d.From == from {
+			b.state.Inbox = append(b.state.Inbox[:i], b.state.Inbox[i+1:]...)
+			return b.saveLocked()
+		}
+	}
+	return ErrNotFound
+}
+
+// saveLocked atomically writes the contacts. b.mu must be held.
+func (b *Book) saveLocked() error {
+	data, err := json.Marshal(&b.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal contacts: %v", err)
+	}
+	path := filepath.Join(b.dir, contactsFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save contacts: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save contacts: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package contacts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExchangeAndSend(t *testing.T) {
+	alice, err := Open(t.TempDir())
+	require.NoError(t, err)
+	bob, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = alice.Add("Bob", bob.Code("bob"))
+	require.NoError(t, err)
+	_, err = bob.Add("Alice", alice.Code("alice"))
+	require.NoError(t, err)
+
+	sealed, err := alice.SealFor("bob", []byte("file key"))
+	require.NoError(t, err)
+	require.NoError(t, bob.Receive(&Delivery{FileID: "file1", Name: "a.txt", From: "alice", SealedKey: sealed}))
+
+	inbox := bob.Inbox()
+	require.Len(t, inbox, 1)
+	key, err := bob.OpenKey(inbox[0])
+	require.NoError(t, err)
+	assert.Equal(t, []byte("file key"), key)
+
+	// Only the recipient can open it
+	_, err = alice.OpenKey(inbox[0])
+	assert.Error(t, err)
+
+	// Files from strangers are refused
+	assert.ErrorIs(t, alice.Receive(&Delivery{FileID: "file2", From: "mallory"}), ErrUnknownContact)
+
+	require.NoError(t, bob.Dismiss("file1", "alice"))
+	assert.Empty(t, bob.Inbox())
+}
+
+func TestPinnedKeySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	book, err := Open(dir)
+	require.NoError(t, err)
+	peer, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = book.Add("Peer", peer.Code("peer1"))
+	require.NoError(t, err)
+
+	reopened, err := Open(dir)
+	require.NoError(t, err)
+	assert.Equal(t, book.PublicKey(), reopened.PublicKey())
+
+	contact, err := reopened.Get("peer1")
+	require.NoError(t, err)
+	assert.Equal(t, peer.PublicKey(), contact.PublicKey)
+
+	// Renaming keeps the pinned key; a different key is refused
+	_, err = reopened.Add("Renamed", peer.Code("peer1"))
+	require.NoError(t, err)
+	impostor, err := Open(t.TempDir())
+	require.NoError(t, err)
+	_, err = reopened.Add("Peer", impostor.Code("peer1"))
+	assert.ErrorIs(t, err, ErrKeyMismatch)
+	assert.Equal(t, "Renamed", reopened.List()[0].Name)
+
+	require.NoError(t, reopened.Remove("peer1"))
+	assert.ErrorIs(t, reopened.Remove("peer1"), ErrNotFound)
+}
+
+func TestParseCodeRejectsInvalidCodes(t *testing.T) {
+	for _, code := range []string{
+		"peer1:AAAA",
+		"filezap-contact:",
+		"filezap-contact::AAAA",
+		"filezap-contact:peer1:!!!",
+		"filezap-contact:peer1:AAAA",
+	} {
+		_, _, err := ParseCode(code)
+		assert.Error(t, err, code)
+	}
+}
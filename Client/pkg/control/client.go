@@ -0,0 +1,154 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Client calls a node's control API
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient creates a client for the control API at addr
+func NewClient(addr, token string) *Client {
+	return &Client{
+		baseURL: "http://" + addr,
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Minute}, // Uploads and downloads can be slow
+	}
+}
+
+// NodeID returns the node's peer ID
+func (c *Client) NodeID() (string, error) {
+	var resp struct {
+		NodeID string `json:"node_id"`
+	}
+	err := c.do(http.MethodGet, "/v1/node", nil, &resp)
+	return resp.NodeID, err
+}
+
+// Peers returns the node's connected peers
+func (c *Client) Peers() ([]string, error) {
+	var resp struct {
+		Peers []string `json:"peers"`
+	}
+	err := c.do(http.MethodGet, "/v1/peers", nil, &resp)
+	return resp.Peers, err
+}
+
+// Storage returns the node's storage stats
+func (c *Client) Storage() (*StorageStats, error) {
+	var stats StorageStats
+	if err := c.do(http.MethodGet, "/v1/storage", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Config returns the node's configuration
+func (c *Client) Config() (*Config, error) {
+	var cfg Config
+	if err := c.do(http.MethodGet, "/v1/config", nil, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// UpdateConfig replaces the node's configuration
+func (c *Client) UpdateConfig(cfg *Config) error {
+	return c.do(http.MethodPut, "/v1/config", cfg, nil)
+}
+
+// Upload adds a file to the network. The path is read by the node.
+func (c *Client) Upload(path string) error {
+	return c.do(http.MethodPost, "/v1/upload", map[string]string{"path": path}, nil)
+}
+
+// Download reassembles the file described by a .zap file into outputPath
+func (c *Client) Download(zapPath, outputPath string) error {
+	return c.do(http.MethodPost, "/v1/download", map[string]string{"zap_path": zapPath, "output_path": outputPath}, nil)
+}
+
+// Pin keeps, or stops keeping, a file's chunks stored on the node
+func (c *Client) Pin(zapPath string, pinned bool) error {
+	return c.do(http.MethodPost, "/v1/pin", map[string]interface{}{"zap_path": zapPath, "pinned": pinned}, nil)
+}
+
+// Wallet returns the node's account and its balance with the validators
+func (c *Client) Wallet() (*Wallet, error) {
+	var wallet Wallet
+	if err := c.do(http.MethodGet, "/v1/wallet", nil, &wallet); err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// TopUp credits a chain deposit to the node's account, or claims from the
+// validators' faucet if txID is empty
+func (c *Client) TopUp(txID string) (*Wallet, error) {
+	var wallet Wallet
+	if err := c.do(http.MethodPost, "/v1/wallet/topup", map[string]string{"tx_id": txID}, &wallet); err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// Usage returns what the node downloaded this period and its free allowance
+func (c *Client) Usage() (*Usage, error) {
+	var usage Usage
+	if err := c.do(http.MethodGet, "/v1/usage", nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// do sends a request and decodes the response into out, if not nil
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach node: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
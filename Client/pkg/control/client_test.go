@@ -0,0 +1,58 @@
+package control
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	node := &fakeNode{config: Config{StorageDirectory: "storage"}}
+	srv := httptest.NewServer(NewServer(node, "secret"))
+	defer srv.Close()
+	c := NewClient(strings.TrimPrefix(srv.URL, "http://"), "secret")
+
+	id, err := c.NodeID()
+	require.NoError(t, err)
+	assert.Equal(t, "node1", id)
+
+	peers, err := c.Peers()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"peer1", "peer2"}, peers)
+
+	stats, err := c.Storage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), stats.MaxSpace)
+
+	require.NoError(t, c.Upload("a.txt"))
+	assert.EqualError(t, c.Upload("missing"), "file not found: missing")
+	require.NoError(t, c.Download("a.zap", "out"))
+	require.NoError(t, c.Pin("a.zap", true))
+	assert.True(t, node.pinned["a.zap"])
+
+	wallet, err := c.TopUp("")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), wallet.Balance)
+	_, err = c.TopUp("tx1")
+	require.NoError(t, err)
+	wallet, err = c.Wallet()
+	require.NoError(t, err)
+	assert.Equal(t, Wallet{Account: "node1", Balance: 35}, *wallet)
+	_, err = c.TopUp("tx2")
+	assert.EqualError(t, err, "unknown transaction")
+	usage, err := c.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(700), usage.FreeLeft)
+
+	cfg, err := c.Config()
+	require.NoError(t, err)
+	cfg.MaxStorageSize = 2048
+	require.NoError(t, c.UpdateConfig(cfg))
+	assert.Equal(t, int64(2048), node.config.MaxStorageSize)
+
+	_, err = NewClient(strings.TrimPrefix(srv.URL, "http://"), "wrong").NodeID()
+	assert.EqualError(t, err, "invalid token")
+}
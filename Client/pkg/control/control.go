@@ -0,0 +1,280 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// The control API lets scripts, daemons and other front ends drive a node
+// without the GUI. It is plain JSON over HTTP and only listens on loopback
+// addresses. If a token is set, requests must carry it as a bearer token.
+const (
+	DefaultAddr     = "127.0.0.1:6080"
+	shutdownTimeout = 5 * time.Second
+)
+
+// StorageStats describes the storage a node offers
+type StorageStats struct {
+	UsedSpace    int64   `json:"used_space"`
+	MaxSpace     int64   `json:"max_space"`
+	ChunkCount   int     `json:"chunk_count"`
+	RequestCount int     `json:"request_count"`
+	Uptime       float64 `json:"uptime"` // Percent
+}
+
+// Config is the part of a node's configuration the API can change
+type Config struct {
+	StorageDirectory string `json:"storage_directory"`
+	MaxStorageSize   int64  `json:"max_storage_size"` // Bytes
+	MinFreeSpace     int64  `json:"min_free_space"`   // Bytes
+}
+
+// Wallet is the node's account with the validators
+type Wallet struct {
+	Account string `json:"account"`
+	Balance int64  `json:"balance"`
+}
+
+// Usage is what the node downloaded this period and its free allowance
+type Usage struct {
+	PeriodStart int64 `json:"period_start"` // Unix time
+	PeriodEnd   int64 `json:"period_end"`
+	Bytes       int64 `json:"bytes"`      // Downloaded this period
+	FreeBytes   int64 `json:"free_bytes"` // Allowance per period
+	FreeLeft    int64 `json:"free_left"`
+}
+
+// Node is the client functionality exposed by the API
+type Node interface {
+	GetNodeID() string
+	UploadFile(path string) error
+	DownloadFile(zapPath, outputPath string) error
+	PinFile(zapPath string, pinned bool) error // Keep a file's chunks stored locally
+	GetPeers() []string
+	GetStorageStats() StorageStats
+	GetConfig() Config
+	UpdateConfig(cfg Config) error
+	GetBalance() (int64, error)
+	TopUp(txID string) (int64, error) // Credits a chain deposit, or claims from the faucet if txID is empty
+	GetUsage() (Usage, error)
+}
+
+// Server serves the control API for a node
+type Server struct {
+	node  Node
+	token string
+	mux   *http.ServeMux
+}
+
+// NewServer creates a control API for node. An empty token disables
+// authentication.
+func NewServer(node Node, token string) *Server {
+	s := &Server{
+		node:  node,
+		token: token,
+		mux:   http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/v1/node", s.only(http.MethodGet, s.handleNode))
+	s.mux.HandleFunc("/v1/peers", s.only(http.MethodGet, s.handlePeers))
+	s.mux.HandleFunc("/v1/storage", s.only(http.MethodGet, s.handleStorage))
+	s.mux.HandleFunc("/v1/config", s.handleConfig)
+	s.mux.HandleFunc("/v1/upload", s.only(http.MethodPost, s.handleUpload))
+	s.mux.HandleFunc("/v1/download", s.only(http.MethodPost, s.handleDownload))
+	s.mux.HandleFunc("/v1/pin", s.only(http.MethodPost, s.handlePin))
+	s.mux.HandleFunc("/v1/wallet", s.only(http.MethodGet, s.handleWallet))
+	s.mux.HandleFunc("/v1/wallet/topup", s.only(http.MethodPost, s.handleTopUp))
+	s.mux.HandleFunc("/v1/usage", s.only(http.MethodGet, s.handleUsage))
+	return s
+}
+
+// ServeHTTP authenticates a request and dispatches it
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := []byte(r.Header.Get("Authorization"))
+	if s.token != "" && subtle.ConstantTimeCompare(auth, []byte("Bearer "+s.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on a loopback address until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid control address: %v", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("control API must listen on a loopback address, not %s", host)
+	}
+
+	srv := &http.Server{Addr: addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down control API: %v", err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("control API failed: %v", err)
+	}
+	return nil
+}
+
+// only restricts a handler to one method
+func (s *Server) only(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) handleNode(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"node_id": s.node.GetNodeID()})
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, _ *http.Request) {
+	peers := s.node.GetPeers()
+	if peers == nil {
+		peers = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"peers": peers})
+}
+
+func (s *Server) handleStorage(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.node.GetStorageStats())
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.node.GetConfig())
+
+	case http.MethodPut:
+		cfg := s.node.GetConfig()
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if err := s.node.UpdateConfig(cfg); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, s.node.GetConfig())
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.node.UploadFile(req.Path); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ZapPath    string `json:"zap_path"`
+		OutputPath string `json:"output_path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ZapPath == "" || req.OutputPath == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.node.DownloadFile(req.ZapPath, req.OutputPath); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+func (s *Server) handlePin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ZapPath string `json:"zap_path"`
+		Pinned  bool   `json:"pinned"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ZapPath == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.node.PinFile(req.ZapPath, req.Pinned); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+func (s *Server) handleWallet(w http.ResponseWriter, _ *http.Request) {
+	balance, err := s.node.GetBalance()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, Wallet{Account: s.node.GetNodeID(), Balance: balance})
+}
+
+func (s *Server) handleTopUp(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TxID string `json:"tx_id"` // Empty to claim from the faucet
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	balance, err := s.node.TopUp(req.TxID)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, Wallet{Account: s.node.GetNodeID(), Balance: balance})
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, _ *http.Request) {
+	usage, err := s.node.GetUsage()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write control API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
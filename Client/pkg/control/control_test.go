@@ -0,0 +1,143 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNode records the calls made through the API
+type fakeNode struct {
+	uploads   []string
+	downloads [][2]string
+	pinned    map[string]bool
+	config    Config
+	balance   int64
+}
+
+func (n *fakeNode) GetNodeID() string { return "node1" }
+
+func (n *fakeNode) UploadFile(path string) error {
+	if path == "missing" {
+		return fmt.Errorf("file not found: %s", path)
+	}
+	n.uploads = append(n.uploads, path)
+	return nil
+}
+
+func (n *fakeNode) DownloadFile(zapPath, outputPath string) error {
+	n.downloads = append(n.downloads, [2]string{zapPath, outputPath})
+	return nil
+}
+
+func (n *fakeNode) PinFile(zapPath string, pinned bool) error {
+	if n.pinned == nil {
+		n.pinned = make(map[string]bool)
+	}
+	n.pinned[zapPath] = pinned
+	return nil
+}
+
+func (n *fakeNode) GetPeers() []string { return []string{"peer1", "peer2"} }
+
+func (n *fakeNode) GetStorageStats() StorageStats {
+	return StorageStats{UsedSpace: 10, MaxSpace: 100, ChunkCount: 2}
+}
+
+func (n *fakeNode) GetConfig() Config { return n.config }
+
+func (n *fakeNode) UpdateConfig(cfg Config) error {
+	if cfg.MaxStorageSize < 0 {
+		return fmt.Errorf("invalid max storage size")
+	}
+	n.config = cfg
+	return nil
+}
+
+func (n *fakeNode) GetBalance() (int64, error) { return n.balance, nil }
+
+func (n *fakeNode) TopUp(txID string) (int64, error) {
+	switch txID {
+	case "":
+		n.balance += 10
+	case "tx1":
+		n.balance += 25
+	default:
+		return 0, fmt.Errorf("unknown transaction")
+	}
+	return n.balance, nil
+}
+
+func (n *fakeNode) GetUsage() (Usage, error) {
+	return Usage{Bytes: 300, FreeBytes: 1000, FreeLeft: 700}, nil
+}
+
+func do(t *testing.T, s *Server, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestControlAPI(t *testing.T) {
+	node := &fakeNode{config: Config{StorageDirectory: "storage", MaxStorageSize: 1024}}
+	s := NewServer(node, "secret")
+
+	rec := do(t, s, http.MethodGet, "/v1/peers", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"peers":["peer1","peer2"]}`, rec.Body.String())
+
+	rec = do(t, s, http.MethodGet, "/v1/storage", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats StorageStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, 2, stats.ChunkCount)
+
+	rec = do(t, s, http.MethodPost, "/v1/upload", `{"path":"a.txt"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"a.txt"}, node.uploads)
+	assert.Equal(t, http.StatusInternalServerError, do(t, s, http.MethodPost, "/v1/upload", `{"path":"missing"}`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do(t, s, http.MethodGet, "/v1/upload", "").Code)
+
+	rec = do(t, s, http.MethodPost, "/v1/download", `{"zap_path":"a.zap","output_path":"out"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, [][2]string{{"a.zap", "out"}}, node.downloads)
+	assert.Equal(t, http.StatusBadRequest, do(t, s, http.MethodPost, "/v1/download", `{"zap_path":"a.zap"}`).Code)
+
+	// Config updates are partial
+	rec = do(t, s, http.MethodPut, "/v1/config", `{"min_free_space":512}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, Config{StorageDirectory: "storage", MaxStorageSize: 1024, MinFreeSpace: 512}, node.config)
+	assert.Equal(t, http.StatusBadRequest, do(t, s, http.MethodPut, "/v1/config", `{"max_storage_size":-1}`).Code)
+}
+
+func TestControlAPIRequiresToken(t *testing.T) {
+	s := NewServer(&fakeNode{}, "secret")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/node", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = do(t, s, http.MethodGet, "/v1/node", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"node_id":"node1"}`, rec.Body.String())
+}
+
+func TestControlAPIListensOnLoopbackOnly(t *testing.T) {
+	s := NewServer(&fakeNode{}, "")
+	err := s.ListenAndServe(context.Background(), "0.0.0.0:0")
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, s.ListenAndServe(ctx, "127.0.0.1:0"))
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// The event bus tells the UI what happens in the background. Publishing
+// never blocks: a subscriber that falls behind misses events rather than
+// stalling the publisher. The most recent events are kept so a notification
+// center opened later can still show them.
+const (
+	DefaultHistory   = 100
+	subscriberBuffer = 32
+)
+
+// Event kinds
+const (
+	TransferComplete    = "transfer_complete"
+	TransferFailed      = "transfer_failed"
+	ReplicationDegraded = "replication_degraded"
+	VoteRequest         = "vote_request"
+	LowDisk             = "low_disk"
+	Info                = "info" // Anything else worth telling the user
+)
+
+// Event is something that happened in the background
+type Event struct {
+	Kind    string
+	Title   string
+	Message string
+	Time    time.Time
+	Data    map[string]string // Kind-specific details, e.g. a file ID
+}
+
+// Bus delivers events to subscribers
+type Bus struct {
+	subscribers map[int]chan Event
+	nextID      int
+	history     []Event // Oldest first
+	maxHistory  int
+	mu          sync.Mutex
+}
+
+// NewBus creates a bus keeping the last history events
+func NewBus(history int) *Bus {
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	return &Bus{
+		subscribers: make(map[int]chan Event),
+		maxHistory:  history,
+	}
+}
+
+// Publish delivers an event to every subscriber
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.maxHistory {
+		b.history = append([]Event(nil), b.history[len(b.history)-b.maxHistory:]...)
+	}
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving published events and a function
+// that ends the subscription and closes the channel
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, id)
+			close(ch)
+		})
+	}
+}
+
+// Recent returns the kept events, newest first
+func (b *Bus) Recent() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recent := make([]Event, len(b.history))
+	for i, event := range b.history {
+		recent[len(recent)-1-i] = event
+	}
+	return recent
+}
+
+// Clear forgets the kept events
+func (b *Bus) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.history = nil
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishAndSubscribe(t *testing.T) {
+	bus := NewBus(2)
+	ch, cancel := bus.Subscribe()
+
+	bus.Publish(Event{Kind: TransferComplete, Title: "a"})
+	bus.Publish(Event{Kind: LowDisk, Title: "b"})
+	bus.Publish(Event{Kind: VoteRequest, Title: "c"})
+
+	for _, title := range []string{"a", "b", "c"} {
+		event := <-ch
+		assert.Equal(t, title, event.Title)
+		assert.False(t, event.Time.IsZero())
+	}
+
+	// Only the last two are kept, newest first
+	recent := bus.Recent()
+	require.Len(t, recent, 2)
+	assert.Equal(t, "c", recent[0].Title)
+	assert.Equal(t, "b", recent[1].Title)
+
+	cancel()
+	cancel()
+	_, open := <-ch
+	assert.False(t, open)
+
+	bus.Clear()
+	assert.Empty(t, bus.Recent())
+}
+
+func TestSlowSubscriberDoesNotBlock(t *testing.T) {
+	bus := NewBus(0)
+	ch, cancel := bus.Subscribe()
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer*2; i++ {
+		bus.Publish(Event{Kind: Info})
+	}
+	assert.Len(t, ch, subscriberBuffer)
+	assert.Len(t, bus.Recent(), subscriberBuffer*2)
+}
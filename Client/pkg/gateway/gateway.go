@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/library"
+	"github.com/VetheonGames/FileZap/Divider/pkg/zap"
+)
+
+// The gateway lets browsers download files from a node without installing
+// the client. GET /zap/<file ID> streams the reassembled file, decrypting
+// its chunks as they are sent, and honours range requests so downloads can
+// resume and media can seek. Only files in the node's library whose chunks
+// it holds are served. A request must carry the file's key, hex encoded,
+// in the X-FileZap-Key header or the key query parameter, unless the node
+// publishes the file; a wrong key is refused before anything is sent.
+const (
+	DefaultAddr     = "127.0.0.1:6081"
+	KeyHeader       = "X-FileZap-Key"
+	shutdownTimeout = 5 * time.Second
+)
+
+// Library finds the .zap manifests of files
+type Library interface {
+	Get(id string) (*library.Entry, error)
+}
+
+// KeyStore holds the keys of published files
+type KeyStore interface {
+	FileKey(fileID string) ([]byte, error)
+}
+
+// Gateway serves files over HTTP
+type Gateway struct {
+	library Library
+	keys    KeyStore
+	public  map[string]bool
+}
+
+// New creates a gateway serving the files in lib. The files named in
+// public are served to anyone, using their key from keys.
+func New(lib Library, keys KeyStore, public []string) *Gateway {
+	g := &Gateway{
+		library: lib,
+		keys:    keys,
+		public:  make(map[string]bool),
+	}
+	for _, id := range public {
+		g.public[id] = true
+	}
+	return g
+}
+
+// ServeHTTP serves GET and HEAD requests for /zap/<file ID>
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fileID := strings.TrimPrefix(r.URL.Path, "/zap/")
+	if fileID == r.URL.Path || fileID == "" || strings.Contains(fileID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metadata, chunksDir, err := g.manifest(fileID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	key, err := g.fileKey(r, fileID, metadata)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", KeyHeader)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	file, err := newFileReader(metadata, chunksDir, key)
+	if err == nil {
+		err = file.check()
+	}
+	switch {
+	case errors.Is(err, errWrongKey):
+		http.Error(w, "wrong key", http.StatusForbidden)
+		return
+	case err != nil:
+		log.Printf("Failed to serve %s from gateway: %v", fileID, err)
+		http.Error(w, "file unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	name := filepath.Base(metadata.OriginalName)
+	if name == "." || name == string(filepath.Separator) {
+		name = fileID
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("Cache-Control", "private, no-transform")
+	http.ServeContent(w, r, name, time.Time{}, file)
+}
+
+// manifest loads a file's .zap manifest and the directory of its chunks,
+// which the divider keeps next to the manifest
+func (g *Gateway) manifest(fileID string) (*zap.FileMetadata, string, error) {
+	entry, err := g.library.Get(fileID)
+	if err != nil {
+		return nil, "", err
+	}
+	metadata, err := zap.ReadZapFile(entry.Manifest)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load manifest: %v", err)
+	}
+	return metadata, filepath.Join(filepath.Dir(entry.Manifest), "chunks"), nil
+}
+
+// fileKey returns the key a request carries, or else the key of a
+// published file
+func (g *Gateway) fileKey(r *http.Request, fileID string, metadata *zap.FileMetadata) (string, error) {
+	key := r.Header.Get(KeyHeader)
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	if key != "" {
+		if _, err := hex.DecodeString(key); err != nil {
+			return "", fmt.Errorf("key must be hex encoded")
+		}
+		return key, nil
+	}
+
+	if !g.public[fileID] {
+		return "", fmt.Errorf("key required")
+	}
+	if metadata.EncryptionKey != "" {
+		return metadata.EncryptionKey, nil
+	}
+	if g.keys != nil {
+		if stored, err := g.keys.FileKey(fileID); err == nil {
+			return string(stored), nil
+		}
+	}
+	return "", fmt.Errorf("key required")
+}
+
+// ListenAndServe serves the gateway on addr until ctx is done
+func (g *Gateway) ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/zap/", g)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down gateway: %v", err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("gateway failed: %v", err)
+	}
+	return nil
+}
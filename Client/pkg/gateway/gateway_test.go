@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/library"
+	"github.com/VetheonGames/FileZap/Divider/pkg/encryption"
+	"github.com/VetheonGames/FileZap/Divider/pkg/zap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLibrary holds manifests by file ID
+type fakeLibrary map[string]string
+
+func (l fakeLibrary) Get(id string) (*library.Entry, error) {
+	path, ok := l[id]
+	if !ok {
+		return nil, library.ErrNotFound
+	}
+	return &library.Entry{ID: id, Manifest: path}, nil
+}
+
+// fakeKeys holds file keys by file ID
+type fakeKeys map[string]string
+
+func (k fakeKeys) FileKey(fileID string) ([]byte, error) {
+	key, ok := k[fileID]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(key), nil
+}
+
+// divide encrypts data in chunks of chunkSize next to a .zap manifest, as
+// the divider does, and returns the manifest's path and the key
+func divide(t *testing.T, id string, data []byte, chunkSize int) (string, string) {
+	dir := t.TempDir()
+	chunksDir := filepath.Join(dir, "chunks")
+	require.NoError(t, os.MkdirAll(chunksDir, 0755))
+	key, err := encryption.GenerateKey()
+	require.NoError(t, err)
+
+	metadata := &zap.FileMetadata{ID: id, OriginalName: "movie.txt", TotalSize: int64(len(data))}
+	for start := 0; start < len(data); start += chunkSize {
+		end := start + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		plain := data[start:end]
+		encrypted, err := encryption.Encrypt(plain, key)
+		require.NoError(t, err)
+		sum := sha256.Sum256(plain)
+		chunk := zap.ChunkMetadata{Index: len(metadata.Chunks), Hash: hex.EncodeToString(sum[:]), Size: int64(len(plain))}
+		require.NoError(t, chunk.UpdateEncryptedHash(encrypted))
+		require.NoError(t, os.WriteFile(filepath.Join(chunksDir, chunk.EncryptedHash), encrypted, 0644))
+		metadata.Chunks = append(metadata.Chunks, chunk)
+	}
+	metadata.ChunkCount = len(metadata.Chunks)
+
+	manifest, err := json.Marshal(metadata)
+	require.NoError(t, err)
+	zapPath := filepath.Join(dir, id+".zap")
+	require.NoError(t, os.WriteFile(zapPath, manifest, 0644))
+	return zapPath, key
+}
+
+func get(t *testing.T, h http.Handler, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		req.Header.Set(name, values[0])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGatewayServesFiles(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	privatePath, privateKey := divide(t, "private", data, 8)
+	publicPath, publicKey := divide(t, "public", data, 8)
+	gw := New(fakeLibrary{"private": privatePath, "public": publicPath}, fakeKeys{"public": publicKey}, []string{"public"})
+
+	t.Run("whole file", func(t *testing.T) {
+		rec := get(t, gw, "/zap/private", http.Header{KeyHeader: {privateKey}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, data, rec.Body.Bytes())
+		assert.Equal(t, "36", rec.Header().Get("Content-Length"))
+		assert.Contains(t, rec.Header().Get("Content-Disposition"), `filename=movie.txt`)
+		assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	})
+
+	t.Run("range across chunks", func(t *testing.T) {
+		rec := get(t, gw, "/zap/private?key="+privateKey, http.Header{"Range": {"bytes=5-20"}})
+		require.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, data[5:21], rec.Body.Bytes())
+		assert.Equal(t, "bytes 5-20/36", rec.Header().Get("Content-Range"))
+	})
+
+	t.Run("suffix range", func(t *testing.T) {
+		rec := get(t, gw, "/zap/private", http.Header{KeyHeader: {privateKey}, "Range": {"bytes=-4"}})
+		require.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, data[32:], rec.Body.Bytes())
+	})
+
+	t.Run("public file", func(t *testing.T) {
+		rec := get(t, gw, "/zap/public", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, data, rec.Body.Bytes())
+	})
+
+	t.Run("key required", func(t *testing.T) {
+		rec := get(t, gw, "/zap/private", nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		rec := get(t, gw, "/zap/private", http.Header{KeyHeader: {publicKey}})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.NotContains(t, rec.Body.String(), string(data[:8]))
+	})
+
+	t.Run("unknown file", func(t *testing.T) {
+		rec := get(t, gw, "/zap/missing", http.Header{KeyHeader: {privateKey}})
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/zap/public", nil)
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+func TestGatewayRejectsBadManifests(t *testing.T) {
+	zapPath, key := divide(t, "file", []byte("some file contents"), 4)
+	metadata, err := zap.ReadZapFile(zapPath)
+	require.NoError(t, err)
+	metadata.Chunks[1].EncryptedHash = "../../etc/passwd"
+	manifest, err := json.Marshal(metadata)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(zapPath, manifest, 0644))
+
+	gw := New(fakeLibrary{"file": zapPath}, nil, nil)
+	rec := get(t, gw, "/zap/file", http.Header{KeyHeader: {key}})
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestFileReaderVerifiesChunks(t *testing.T) {
+	data := []byte("some file contents")
+	zapPath, key := divide(t, "file", data, 4)
+	metadata, err := zap.ReadZapFile(zapPath)
+	require.NoError(t, err)
+	chunksDir := filepath.Join(filepath.Dir(zapPath), "chunks")
+
+	// Replace a chunk with another correctly encrypted one
+	other, err := encryption.Encrypt([]byte("evil"), key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(chunksDir, metadata.Chunks[2].EncryptedHash), other, 0644))
+
+	f, err := newFileReader(metadata, chunksDir, key)
+	require.NoError(t, err)
+	_, err = io.ReadAll(f)
+	assert.ErrorContains(t, err, "does not match the manifest")
+}
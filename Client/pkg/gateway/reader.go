@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/VetheonGames/FileZap/Divider/pkg/chunking"
+	"github.com/VetheonGames/FileZap/Divider/pkg/encryption"
+	"github.com/VetheonGames/FileZap/Divider/pkg/zap"
+)
+
+// errWrongKey is returned for chunks that do not decrypt with the key
+var errWrongKey = errors.New("chunk does not decrypt with the key")
+
+// fileReader reads the reassembled file from its encrypted chunks. Only
+// the chunk being read is held decrypted, so seeking costs at most one
+// chunk's decryption.
+type fileReader struct {
+	dir     string
+	key     string
+	hash    chunking.HashAlgorithm
+	chunks  []zap.ChunkMetadata // In file order
+	offsets []int64             // Offset of each chunk in the file
+	size    int64
+	pos     int64
+	current int // Index in chunks of the decrypted chunk, -1 if none
+	plain   []byte
+}
+
+// newFileReader checks a manifest's chunk list and lays out the file
+func newFileReader(metadata *zap.FileMetadata, dir, key string) (*fileReader, error) {
+	chunks := append([]zap.ChunkMetadata(nil), metadata.Chunks...)
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+
+	f := &fileReader{dir: dir, key: key, hash: metadata.HashAlgorithm, chunks: chunks, current: -1}
+	for i, chunk := range chunks {
+		if chunk.Index != i {
+			return nil, fmt.Errorf("manifest is missing chunk %d", i)
+		}
+		if chunk.Size < 0 {
+			return nil, fmt.Errorf("chunk %d has a negative size", i)
+		}
+		// Chunk names come from the manifest and must not leave dir
+		if chunk.EncryptedHash == "" || filepath.Base(chunk.EncryptedHash) != chunk.EncryptedHash || chunk.EncryptedHash == ".." {
+			return nil, fmt.Errorf("chunk %d has an invalid name", i)
+		}
+		f.offsets = append(f.offsets, f.size)
+		f.size += chunk.Size
+	}
+	return f, nil
+}
+
+// check decrypts the first chunk, so a wrong key is found before the
+// response starts
+func (f *fileReader) check() error {
+	if len(f.chunks) == 0 {
+		return nil
+	}
+	return f.load(0)
+}
+
+// Read implements io.Reader
+func (f *fileReader) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(f.offsets), func(i int) bool { return f.offsets[i] > f.pos }) - 1
+	if err := f.load(i); err != nil {
+		return 0, err
+	}
+	n := copy(p, f.plain[f.pos-f.offsets[i]:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker
+func (f *fileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// load decrypts chunk i unless it is already decrypted, checking it
+// against its hash in the manifest
+func (f *fileReader) load(i int) error {
+	if f.current == i {
+		return nil
+	}
+	chunk := f.chunks[i]
+	data, err := os.ReadFile(filepath.Join(f.dir, chunk.EncryptedHash))
+	if err != nil {
+		return fmt.Errorf("failed to read chunk %d: %v", chunk.Index, err)
+	}
+	plain, err := encryption.Decrypt(data, f.key)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", errWrongKey, chunk.Index)
+	}
+	if int64(len(plain)) != chunk.Size || !f.hash.Verify(plain, chunk.Hash) {
+		return fmt.Errorf("chunk %d does not match the manifest", chunk.Index)
+	}
+	f.current = i
+	f.plain = plain
+	return nil
+}
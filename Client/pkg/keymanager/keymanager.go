@@ -1,95 +1,268 @@
 package keymanager
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // KeyShare represents a portion of a decryption key
 type KeyShare struct {
 	PeerID    string
+	Index     byte // x coordinate of the share, from 1
 	ShareData []byte
 }
 
+// ShareCommitments lets share holders and the party recombining shares check
+// them without revealing them
+type ShareCommitments struct {
+	Key    []byte          // SHA-256 of the file ID and key
+	Shares map[byte][]byte // SHA-256 of the file ID and each share, by index
+}
+
+// Key request states. A request starts pending and ends in one of the
+// others.
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestDenied   = "denied"
+	RequestExpired  = "expired"
+)
+
 // KeyRequest represents a client's request for a decryption key
 type KeyRequest struct {
+	ID          string
 	FileID      string
 	ClientID    string
 	PublicKey   []byte
 	RequestTime int64
+	State       string
+	UpdateTime  int64 // When State last changed
 }
 
 // KeyManager handles secure key distribution
 type KeyManager struct {
-	shares    map[string][]KeyShare // map[fileID][]KeyShare
-	requests  map[string]*KeyRequest
-	threshold int // minimum shares needed for key reconstruction
-	mu        sync.RWMutex
+	shares      map[string][]KeyShare // map[fileID][]KeyShare
+	commitments map[string]*ShareCommitments
+	revoked     map[string][][]byte    // map[fileID]commitments to revoked keys
+	requests    map[string]*KeyRequest // map[requestID]KeyRequest
+	requestIDs  map[string]string      // map[fileID:clientID]requestID
+	threshold   int                    // minimum shares needed for key reconstruction
+	mu          sync.RWMutex
+
+	requestsPath string // Where requests are saved, empty if they are not
 }
 
 // NewKeyManager creates a new key manager instance
 func NewKeyManager(threshold int) *KeyManager {
 	return &KeyManager{
-		shares:    make(map[string][]KeyShare),
-		requests:  make(map[string]*KeyRequest),
-		threshold: threshold,
+		shares:      make(map[string][]KeyShare),
+		commitments: make(map[string]*ShareCommitments),
+		revoked:     make(map[string][][]byte),
+		requestIDs:  make(map[string]string),
+		requests:    make(map[string]*KeyRequest),
+		threshold:   threshold,
 	}
 }
 
-// GenerateKeyShares splits a decryption key into shares
-func (km *KeyManager) GenerateKeyShares(fileID string, key []byte, peerCount int) ([]KeyShare, error) {
-	if peerCount < km.threshold {
+// GenerateKeyShares splits a decryption key into one Shamir share per peer,
+// any threshold of which reconstruct it, and commits to the key and shares.
+// A file whose key is already registered is refused with ErrKeyExists; only
+// RotateKey replaces it.
+func (km *KeyManager) GenerateKeyShares(fileID string, key []byte, peerIDs []string) ([]KeyShare, error) {
+	return km.splitKey(fileID, key, peerIDs, false)
+}
+
+// splitKey deals a key's shares among peerIDs, replacing the file's
+// current shares only if replace is set
+func (km *KeyManager) splitKey(fileID string, key []byte, peerIDs []string, replace bool) ([]KeyShare, error) {
+	if len(peerIDs) < km.threshold {
 		return nil, fmt.Errorf("peer count must be >= threshold")
 	}
+	if km.isRevoked(fileID, key) {
+		return nil, ErrKeyRevoked
+	}
 
-	// Generate random shares that XOR together to form the key
-	shares := make([]KeyShare, peerCount)
-	finalShare := make([]byte, len(key))
-	copy(finalShare, key)
-
-	// Generate random shares except for the last one
-	for i := 0; i < peerCount-1; i++ {
-		shareData := make([]byte, len(key))
-		if _, err := rand.Read(shareData); err != nil {
-			return nil, fmt.Errorf("failed to generate random share: %v", err)
-		}
+	data, err := splitSecret(key, len(peerIDs), km.threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split key: %v", err)
+	}
 
+	shares := make([]KeyShare, len(peerIDs))
+	commitments := &ShareCommitments{
+		Key:    commit(fileID, 0, key),
+		Shares: make(map[byte][]byte),
+	}
+	for i, peerID := range peerIDs {
 		shares[i] = KeyShare{
-			ShareData: shareData,
-		}
-
-		// XOR this share with the running total
-		for j := range finalShare {
-			finalShare[j] ^= shareData[j]
+			PeerID:    peerID,
+			Index:     byte(i + 1),
+			ShareData: data[i],
 		}
-	}
-
-	// Last share is what's needed to reconstruct the key
-	shares[peerCount-1] = KeyShare{
-		ShareData: finalShare,
+		commitments.Shares[shares[i].Index] = commit(fileID, shares[i].Index, data[i])
 	}
 
 	km.mu.Lock()
+	defer km.mu.Unlock()
+	if _, exists := km.commitments[fileID]; exists && !replace {
+		return nil, ErrKeyExists
+	}
 	km.shares[fileID] = shares
-	km.mu.Unlock()
+	km.commitments[fileID] = commitments
 
 	return shares, nil
 }
 
-// RegisterKeyRequest registers a client's request for a decryption key
+// commit hashes a share, or the key for index 0, bound to its file
+func commit(fileID string, index byte, data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(fileID))
+	h.Write([]byte{0, index})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// GetCommitments returns the commitments to a file's key and shares
+func (km *KeyManager) GetCommitments(fileID string) (*ShareCommitments, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	commitments, exists := km.commitments[fileID]
+	if !exists {
+		return nil, fmt.Errorf("no commitments found for file")
+	}
+	return commitments, nil
+}
+
+// VerifyShare checks a share against the file's commitments
+func (km *KeyManager) VerifyShare(fileID string, share *KeyShare) error {
+	commitments, err := km.GetCommitments(fileID)
+	if err != nil {
+		return err
+	}
+
+	expected, exists := commitments.Shares[share.Index]
+	if !exists || !bytes.Equal(expected, commit(fileID, share.Index, share.ShareData)) {
+		return fmt.Errorf("share %d does not match its commitment", share.Index)
+	}
+	return nil
+}
+
+// RegisterKeyRequest registers a client's request for a decryption key,
+// assigning it an ID. A new request from a client replaces its previous one
+// for the file.
 func (km *KeyManager) RegisterKeyRequest(req *KeyRequest) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("failed to generate request ID: %v", err)
+	}
+
 	km.mu.Lock()
 	defer km.mu.Unlock()
 
+	key := requestKey(req.FileID, req.ClientID)
+	if oldID, exists := km.requestIDs[key]; exists {
+		delete(km.requests, oldID)
+	}
+
 	// Store the request
-	km.requests[req.FileID] = req
+	req.ID = hex.EncodeToString(id)
+	req.State = RequestPending
+	req.UpdateTime = req.RequestTime
+	km.requests[req.ID] = req
+	km.requestIDs[key] = req.ID
+	km.saveRequestsLocked()
 	return nil
 }
 
+// GetKeyRequest returns a client's current request for a file's key
+func (km *KeyManager) GetKeyRequest(fileID, clientID string) (*KeyRequest, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	req, exists := km.requests[km.requestIDs[requestKey(fileID, clientID)]]
+	if !exists {
+		return nil, fmt.Errorf("no key request found")
+	}
+	copied := *req
+	return &copied, nil
+}
+
+// GetKeyRequestByID returns a key request by its ID
+func (km *KeyManager) GetKeyRequestByID(id string) (*KeyRequest, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	req, exists := km.requests[id]
+	if !exists {
+		return nil, fmt.Errorf("no key request found")
+	}
+	copied := *req
+	return &copied, nil
+}
+
+// SetRequestState moves a pending request to a final state, reporting
+// whether it changed
+func (km *KeyManager) SetRequestState(id, state string) (bool, error) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	req, exists := km.requests[id]
+	if !exists {
+		return false, fmt.Errorf("no key request found")
+	}
+	if req.State != RequestPending || state == RequestPending {
+		return false, nil
+	}
+	req.State = state
+	req.UpdateTime = time.Now().Unix()
+	km.saveRequestsLocked()
+	return true, nil
+}
+
+// ExpireRequests marks requests pending for longer than timeout as expired
+// and returns them. Finished requests are forgotten after retention.
+func (km *KeyManager) ExpireRequests(timeout, retention time.Duration) []*KeyRequest {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	now := time.Now()
+	var expired []*KeyRequest
+	changed := false
+	for id, req := range km.requests {
+		switch {
+		case req.State == RequestPending && now.Sub(time.Unix(req.RequestTime, 0)) > timeout:
+			req.State = RequestExpired
+			req.UpdateTime = now.Unix()
+			copied := *req
+			expired = append(expired, &copied)
+			changed = true
+
+		case req.State != RequestPending && now.Sub(time.Unix(req.UpdateTime, 0)) > retention:
+			delete(km.requests, id)
+			key := requestKey(req.FileID, req.ClientID)
+			if km.requestIDs[key] == id {
+				delete(km.requestIDs, key)
+			}
+			changed = true
+		}
+	}
+	if changed {
+		km.saveRequestsLocked()
+	}
+	return expired
+}
+
+func requestKey(fileID, clientID string) string {
+	return fmt.Sprintf("%s:%s", fileID, clientID)
+}
+
 // GetKeyShare returns a peer's key share for a file
 func (km *KeyManager) GetKeyShare(fileID, peerID string) (*KeyShare, error) {
 	km.mu.RLock()
@@ -110,26 +283,54 @@ func (km *KeyManager) GetKeyShare(fileID, peerID string) (*KeyShare, error) {
 	return nil, fmt.Errorf("no share found for peer")
 }
 
-// RecombineKeyShares reconstructs the original key from shares
+// RecombineKeyShares reconstructs the original key from at least threshold
+// shares, rejecting shares and results that do not match the commitments
 func (km *KeyManager) RecombineKeyShares(fileID string, shares []KeyShare) ([]byte, error) {
 	if len(shares) < km.threshold {
 		return nil, fmt.Errorf("insufficient shares for key reconstruction")
 	}
 
-	// Verify all shares belong to the same file
-	key := make([]byte, len(shares[0].ShareData))
-	copy(key, shares[0].ShareData)
+	commitments, err := km.GetCommitments(fileID)
+	if err != nil {
+		return nil, err
+	}
 
-	// XOR all shares together
-	for i := 1; i < len(shares); i++ {
-		for j := range key {
-			key[j] ^= shares[i].ShareData[j]
+	xs := make([]byte, len(shares))
+	data := make([][]byte, len(shares))
+	for i := range shares {
+		if err := km.VerifyShare(fileID, &shares[i]); err != nil {
+			return nil, err
 		}
+		xs[i] = shares[i].Index
+		data[i] = shares[i].ShareData
+	}
+
+	key, err := combineShares(xs, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to combine shares: %v", err)
+	}
+	if !bytes.Equal(commitments.Key, commit(fileID, 0, key)) {
+		return nil, fmt.Errorf("reconstructed key does not match its commitment")
 	}
 
 	return key, nil
 }
 
+// ReconstructKey recombines a file's key from the shares held for it
+func (km *KeyManager) ReconstructKey(fileID string) ([]byte, error) {
+	km.mu.RLock()
+	shares := km.shares[fileID]
+	if len(shares) > km.threshold {
+		shares = shares[:km.threshold]
+	}
+	km.mu.RUnlock()
+
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares found for file")
+	}
+	return km.RecombineKeyShares(fileID, shares)
+}
+
 // EncryptKeyShare encrypts a key share for a specific client
 func (km *KeyManager) EncryptKeyShare(share []byte, publicKey []byte) ([]byte, error) {
 	// Parse the public key
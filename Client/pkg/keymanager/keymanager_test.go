@@ -0,0 +1,230 @@
+package keymanager
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestGFArithmetic(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			p := gfMul(byte(a), byte(b))
+			require.Equal(t, gfMulSlow(byte(a), byte(b)), p)
+			require.Equal(t, byte(a), gfDiv(p, byte(b)))
+		}
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	require.NoError(t, err)
+
+	shares, err := splitSecret(secret, 5, 3)
+	require.NoError(t, err)
+
+	// Every subset of threshold shares recovers the secret
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				got, err := combineShares(
+					[]byte{byte(i + 1), byte(j + 1), byte(k + 1)},
+					[][]byte{shares[i], shares[j], shares[k]},
+				)
+				require.NoError(t, err)
+				assert.Equal(t, secret, got)
+			}
+		}
+	}
+
+	// Fewer shares do not
+	got, err := combineShares([]byte{1, 2}, [][]byte{shares[0], shares[1]})
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, got)
+
+	_, err = combineShares([]byte{1, 1}, [][]byte{shares[0], shares[0]})
+	assert.Error(t, err)
+}
+
+func TestRecombineKeyShares(t *testing.T) {
+	km := NewKeyManager(3)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	_, err := km.GenerateKeyShares("file1", key, []string{"v1", "v2"})
+	assert.Error(t, err, "fewer peers than the threshold")
+
+	shares, err := km.GenerateKeyShares("file1", key, []string{"v1", "v2", "v3", "v4"})
+	require.NoError(t, err)
+
+	share, err := km.GetKeyShare("file1", "v3")
+	require.NoError(t, err)
+	assert.Equal(t, byte(3), share.Index)
+	assert.NoError(t, km.VerifyShare("file1", share))
+
+	got, err := km.RecombineKeyShares("file1", []KeyShare{shares[3], shares[0], shares[2]})
+	require.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	_, err = km.RecombineKeyShares("file1", shares[:2])
+	assert.Error(t, err, "fewer shares than the threshold")
+
+	// A registered key cannot be replaced by registering another
+	_, err = km.GenerateKeyShares("file1", []byte("fedcba9876543210fedcba9876543210"), []string{"v1", "v2", "v3"})
+	assert.ErrorIs(t, err, ErrKeyExists)
+	share, err = km.GetKeyShare("file1", "v3")
+	require.NoError(t, err)
+	assert.Equal(t, shares[2], *share)
+
+	// A tampered share is caught by its commitment
+	tampered := append([]KeyShare(nil), shares[:3]...)
+	tampered[1].ShareData = append([]byte(nil), tampered[1].ShareData...)
+	tampered[1].ShareData[0] ^= 1
+	_, err = km.RecombineKeyShares("file1", tampered)
+	assert.Error(t, err)
+
+	// Shares are bound to their file
+	_, err = km.GenerateKeyShares("file2", key, []string{"v1", "v2", "v3"})
+	require.NoError(t, err)
+	_, err = km.RecombineKeyShares("file2", shares[:3])
+	assert.Error(t, err)
+}
+
+func TestSealKey(t *testing.T) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	sealed, err := SealKey(key, pub[:])
+	require.NoError(t, err)
+	opened, err := OpenKey(sealed, pub, priv)
+	require.NoError(t, err)
+	assert.Equal(t, key, opened)
+
+	otherPub, otherPriv, err := box.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = OpenKey(sealed, otherPub, otherPriv)
+	assert.Error(t, err)
+
+	_, err = SealKey(key, []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestPeerSealKey(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	// A key sealed to a peer's Ed25519 key opens with its private key
+	pub, err := PeerSealKey(edPub)
+	require.NoError(t, err)
+	priv := PeerOpenKey(edPriv)
+	derived, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	require.NoError(t, err)
+	assert.Equal(t, derived, pub)
+
+	sealed, err := SealKey(key, pub)
+	require.NoError(t, err)
+	var recipient [32]byte
+	copy(recipient[:], pub)
+	opened, err := OpenKey(sealed, &recipient, priv)
+	require.NoError(t, err)
+	assert.Equal(t, key, opened)
+
+	_, err = PeerSealKey(edPub[:16])
+	assert.Error(t, err)
+}
+
+func TestKeyRequestLifecycle(t *testing.T) {
+	km := NewKeyManager(3)
+
+	old := &KeyRequest{FileID: "file1", ClientID: "client", RequestTime: time.Now().Add(-time.Hour).Unix()}
+	require.NoError(t, km.RegisterKeyRequest(old))
+	assert.NotEmpty(t, old.ID)
+
+	// Requests past the timeout expire once
+	expired := km.ExpireRequests(time.Minute, 2*time.Hour)
+	require.Len(t, expired, 1)
+	assert.Equal(t, RequestExpired, expired[0].State)
+	assert.Empty(t, km.ExpireRequests(time.Minute, 2*time.Hour))
+
+	// Final states do not change
+	changed, err := km.SetRequestState(old.ID, RequestApproved)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// A new request replaces the old one
+	req := &KeyRequest{FileID: "file1", ClientID: "client", RequestTime: time.Now().Unix()}
+	require.NoError(t, km.RegisterKeyRequest(req))
+	_, err = km.GetKeyRequestByID(old.ID)
+	assert.Error(t, err)
+
+	changed, err = km.SetRequestState(req.ID, RequestApproved)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	got, err := km.GetKeyRequest("file1", "client")
+	require.NoError(t, err)
+	assert.Equal(t, RequestApproved, got.State)
+
+	// Finished requests are forgotten after the retention period
+	km.ExpireRequests(time.Minute, -time.Second)
+	_, err = km.GetKeyRequestByID(req.ID)
+	assert.Error(t, err)
+}
+
+func TestRotateKey(t *testing.T) {
+	km := NewKeyManager(2)
+	peers := []string{"v1", "v2", "v3"}
+	oldKey := []byte("old file key")
+	newKey := []byte("new file key")
+
+	_, err := km.RotateKey("file1", oldKey, newKey, peers)
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	_, err = km.GenerateKeyShares("file1", oldKey, peers)
+	require.NoError(t, err)
+	pending := &KeyRequest{FileID: "file1", ClientID: "pending", RequestTime: time.Now().Unix()}
+	require.NoError(t, km.RegisterKeyRequest(pending))
+	approved := &KeyRequest{FileID: "file1", ClientID: "approved", RequestTime: time.Now().Unix()}
+	require.NoError(t, km.RegisterKeyRequest(approved))
+	_, err = km.SetRequestState(approved.ID, RequestApproved)
+	require.NoError(t, err)
+	other := &KeyRequest{FileID: "file2", ClientID: "pending", RequestTime: time.Now().Unix()}
+	require.NoError(t, km.RegisterKeyRequest(other))
+
+	// Only the holder of the current key may rotate it
+	_, err = km.RotateKey("file1", []byte("guess"), newKey, peers)
+	assert.ErrorIs(t, err, ErrKeyMismatch)
+
+	closed, err := km.RotateKey("file1", oldKey, newKey, peers)
+	require.NoError(t, err)
+	require.Len(t, closed, 2)
+	states := map[string]string{}
+	for _, req := range closed {
+		states[req.ClientID] = req.State
+	}
+	assert.Equal(t, map[string]string{"pending": RequestPending, "approved": RequestApproved}, states)
+
+	key, err := km.ReconstructKey("file1")
+	require.NoError(t, err)
+	assert.Equal(t, newKey, key)
+	got, err := km.GetKeyRequest("file1", "approved")
+	require.NoError(t, err)
+	assert.Equal(t, RequestExpired, got.State)
+	got, err = km.GetKeyRequest("file2", "pending")
+	require.NoError(t, err)
+	assert.Equal(t, RequestPending, got.State)
+
+	// Retries succeed without closing anything, and the old key stays revoked
+	closed, err = km.RotateKey("file1", oldKey, newKey, peers)
+	require.NoError(t, err)
+	assert.Empty(t, closed)
+	_, err = km.GenerateKeyShares("file1", oldKey, peers)
+	assert.ErrorIs(t, err, ErrKeyRevoked)
+}
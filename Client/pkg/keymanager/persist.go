@@ -0,0 +1,91 @@
+package keymanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// RequestsFile is the file in the data directory holding key requests
+const RequestsFile = "key_requests.json"
+
+// EnablePersistence saves key requests to dir whenever they change and
+// restores the requests saved by a previous run, so clients can still poll
+// them, and pay for them, after a restart
+func (km *KeyManager) EnablePersistence(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create key request directory: %v", err)
+	}
+	path := filepath.Join(dir, RequestsFile)
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.requestsPath = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read key requests: %v", err)
+	}
+
+	var requests []*KeyRequest
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return fmt.Errorf("failed to parse key requests: %v", err)
+	}
+	for _, req := range requests {
+		if req.ID == "" {
+			continue
+		}
+		km.requests[req.ID] = req
+		// The latest request of a client for a file is its current one
+		key := requestKey(req.FileID, req.ClientID)
+		if current, exists := km.requests[km.requestIDs[key]]; !exists || current.RequestTime <= req.RequestTime {
+			km.requestIDs[key] = req.ID
+		}
+	}
+	return nil
+}
+
+// PendingRequests returns copies of the requests awaiting a decision
+func (km *KeyManager) PendingRequests() []*KeyRequest {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	var pending []*KeyRequest
+	for _, req := range km.requests {
+		if req.State == RequestPending {
+			copied := *req
+			pending = append(pending, &copied)
+		}
+	}
+	return pending
+}
+
+// saveRequestsLocked writes the key requests to disk, if persistence is
+// enabled. Callers must hold km.mu.
+func (km *KeyManager) saveRequestsLocked() {
+	if km.requestsPath == "" {
+		return
+	}
+
+	requests := make([]*KeyRequest, 0, len(km.requests))
+	for _, req := range km.requests {
+		requests = append(requests, req)
+	}
+	data, err := json.Marshal(requests)
+	if err != nil {
+		return
+	}
+	tmp := km.requestsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Failed to save key requests: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, km.requestsPath); err != nil {
+		log.Printf("Failed to save key requests: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package keymanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	km := NewKeyManager(3)
+	require.NoError(t, km.EnablePersistence(dir))
+
+	now := time.Now().Unix()
+	first := &KeyRequest{FileID: "file1", ClientID: "client", RequestTime: now}
+	require.NoError(t, km.RegisterKeyRequest(first))
+	other := &KeyRequest{FileID: "file2", ClientID: "client", RequestTime: now}
+	require.NoError(t, km.RegisterKeyRequest(other))
+	changed, err := km.SetRequestState(other.ID, RequestApproved)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	restarted := NewKeyManager(3)
+	require.NoError(t, restarted.EnablePersistence(dir))
+	req, err := restarted.GetKeyRequest("file1", "client")
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, req.ID)
+	req, err = restarted.GetKeyRequestByID(other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RequestApproved, req.State)
+
+	pending := restarted.PendingRequests()
+	require.Len(t, pending, 1)
+	assert.Equal(t, first.ID, pending[0].ID)
+}
@@ -0,0 +1,83 @@
+package keymanager
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// A file's owner rotates its key after a suspected leak: the chunks are
+// re-encrypted under a new key, and the validators swap the old key's
+// shares for the new one's. The old key is revoked, so it cannot be
+// registered for the file again, and requests that could still be answered
+// with it are closed.
+
+var (
+	ErrNoKey       = errors.New("no key registered for file")
+	ErrKeyExists   = errors.New("key already registered for file")
+	ErrKeyMismatch = errors.New("key does not match the file's key")
+	ErrKeyRevoked  = errors.New("key has been revoked")
+)
+
+// RotateKey replaces a file's key with newKey, split among peerIDs. The
+// caller proves it may do so by giving the current key. Pending and
+// approved requests for the file are expired and returned as they were
+// before, so the caller can settle them; their clients must request the new
+// key. Rotating to the current key does nothing, so rotations can be
+// retried.
+func (km *KeyManager) RotateKey(fileID string, oldKey, newKey []byte, peerIDs []string) ([]*KeyRequest, error) {
+	km.mu.RLock()
+	commitments, exists := km.commitments[fileID]
+	km.mu.RUnlock()
+	if !exists {
+		return nil, ErrNoKey
+	}
+	if bytes.Equal(commitments.Key, commit(fileID, 0, newKey)) {
+		return nil, nil
+	}
+	if !bytes.Equal(commitments.Key, commit(fileID, 0, oldKey)) {
+		return nil, ErrKeyMismatch
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil, ErrKeyRevoked
+	}
+
+	if _, err := km.splitKey(fileID, newKey, peerIDs, true); err != nil {
+		return nil, err
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	km.revoked[fileID] = append(km.revoked[fileID], commitments.Key)
+
+	var closed []*KeyRequest
+	now := time.Now().Unix()
+	for _, req := range km.requests {
+		if req.FileID != fileID || (req.State != RequestPending && req.State != RequestApproved) {
+			continue
+		}
+		copied := *req
+		closed = append(closed, &copied)
+		req.State = RequestExpired
+		req.UpdateTime = now
+	}
+	if len(closed) > 0 {
+		km.saveRequestsLocked()
+	}
+	return closed, nil
+}
+
+// isRevoked reports whether a key has been revoked for a file
+func (km *KeyManager) isRevoked(fileID string, key []byte) bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	revoked := commit(fileID, 0, key)
+	for _, c := range km.revoked[fileID] {
+		if bytes.Equal(c, revoked) {
+			return true
+		}
+	}
+	return false
+}
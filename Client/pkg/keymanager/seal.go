@@ -0,0 +1,85 @@
+package keymanager
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// SealKey encrypts a key to a client's X25519 public key as an anonymous
+// sealed box, which only the holder of the private key can open
+func SealKey(key []byte, publicKey []byte) ([]byte, error) {
+	if len(publicKey) != 32 {
+		return nil, fmt.Errorf("invalid X25519 public key length: %d", len(publicKey))
+	}
+
+	var recipient [32]byte
+	copy(recipient[:], publicKey)
+	sealed, err := box.SealAnonymous(nil, key, &recipient, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal key: %v", err)
+	}
+	return sealed, nil
+}
+
+// OpenKey decrypts a key sealed with SealKey
+func OpenKey(sealed []byte, publicKey, privateKey *[32]byte) ([]byte, error) {
+	key, ok := box.OpenAnonymous(nil, sealed, publicKey, privateKey)
+	if !ok {
+		return nil, fmt.Errorf("failed to open sealed key")
+	}
+	return key, nil
+}
+
+// fieldPrime is the prime 2^255 - 19 both curve forms are defined over
+var fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// PeerSealKey returns the X25519 form of an Ed25519 public key, so a key
+// can be sealed to a peer by the key its ID embeds
+func PeerSealKey(publicKey ed25519.PublicKey) ([]byte, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key length: %d", len(publicKey))
+	}
+
+	// The key encodes y little-endian, with the sign of x in the top bit.
+	// The Montgomery u coordinate is (1 + y) / (1 - y).
+	encoded := make([]byte, len(publicKey))
+	for i, b := range publicKey {
+		encoded[len(encoded)-1-i] = b
+	}
+	encoded[0] &= 0x7f
+	y := new(big.Int).SetBytes(encoded)
+	if y.Cmp(fieldPrime) >= 0 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, fieldPrime)
+	if den.ModInverse(den, fieldPrime) == nil {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, den).Mod(u, fieldPrime)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// PeerOpenKey returns the X25519 private key matching PeerSealKey of an
+// Ed25519 key, for opening keys sealed to the peer
+func PeerOpenKey(privateKey ed25519.PrivateKey) *[32]byte {
+	h := sha512.Sum512(privateKey.Seed())
+	var key [32]byte
+	copy(key[:], h[:32])
+	key[0] &= 248
+	key[31] &= 127
+	key[31] |= 64
+	return &key
+}
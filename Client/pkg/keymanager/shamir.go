@@ -0,0 +1,140 @@
+package keymanager
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Shamir secret sharing over GF(256). Each byte of the secret is the
+// constant term of a random polynomial of degree threshold-1, and share x
+// holds the polynomials evaluated at x. Any threshold shares determine the
+// polynomials and so the secret; fewer reveal nothing about it.
+
+// Log and exp tables for GF(256) with the AES polynomial x^8+x^4+x^3+x+1,
+// using 3 as the generator
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfExp[i+255] = x
+		gfLog[x] = byte(i)
+		x = gfMulSlow(x, 3)
+	}
+}
+
+// gfMulSlow multiplies without the tables, to build them
+func gfMulSlow(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfDiv divides a by b, which must not be zero
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// splitSecret splits a secret into n shares of which any threshold recover
+// it. Share i is evaluated at x = i+1.
+func splitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 1 || n < threshold {
+		return nil, fmt.Errorf("invalid threshold %d for %d shares", threshold, n)
+	}
+	if n > 255 {
+		return nil, fmt.Errorf("too many shares: %d", n)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("empty secret")
+	}
+
+	// The random coefficients of each byte's polynomial, threshold-1 per byte
+	random := make([]byte, len(secret)*(threshold-1))
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate polynomial: %v", err)
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		x := byte(i + 1)
+		share := make([]byte, len(secret))
+		for b, s := range secret {
+			coeffs := random[b*(threshold-1) : (b+1)*(threshold-1)]
+
+			// Horner's method, highest degree first
+			var y byte
+			for c := len(coeffs) - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coeffs[c]
+			}
+			share[b] = gfMul(y, x) ^ s
+		}
+		shares[i] = share
+	}
+	return shares, nil
+}
+
+// combineShares recovers a secret from shares evaluated at the given x
+// coordinates by Lagrange interpolation at zero. It needs at least the
+// threshold used to split the secret; with fewer it returns garbage.
+func combineShares(xs []byte, shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 || len(xs) != len(shares) {
+		return nil, fmt.Errorf("no shares")
+	}
+
+	seen := make(map[byte]bool)
+	for i, x := range xs {
+		if x == 0 || seen[x] {
+			return nil, fmt.Errorf("invalid or duplicate share index %d", x)
+		}
+		seen[x] = true
+		if len(shares[i]) != len(shares[0]) {
+			return nil, fmt.Errorf("shares differ in length")
+		}
+	}
+
+	// basis[i] is the Lagrange basis polynomial of share i evaluated at zero
+	basis := make([]byte, len(xs))
+	for i, xi := range xs {
+		l := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				l = gfMul(l, gfDiv(xj, xj^xi))
+			}
+		}
+		basis[i] = l
+	}
+
+	secret := make([]byte, len(shares[0]))
+	for b := range secret {
+		var s byte
+		for i := range shares {
+			s ^= gfMul(shares[i][b], basis[i])
+		}
+		secret[b] = s
+	}
+	return secret, nil
+}
@@ -0,0 +1,252 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// The keystore keeps file keys and the node identity key encrypted at rest.
+// Entries are sealed with a random master key, and the master key is sealed
+// by a Protector: a passphrase, or the operating system's user key store
+// where there is one. The store starts locked; it is unlocked once per run
+// by opening the master key, which stays in memory until Lock.
+const (
+	storeFile     = "keystore.json"
+	masterKeySize = 32
+	nonceSize     = 24
+
+	identityEntry = "identity"
+	filePrefix    = "file/"
+)
+
+var (
+	ErrLocked          = errors.New("keystore is locked")
+	ErrNotFound        = errors.New("key not found")
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupt keystore")
+)
+
+// Protector seals the master key
+type Protector interface {
+	Name() string
+	Seal(masterKey []byte) ([]byte, error)
+	Open(sealed []byte) ([]byte, error)
+}
+
+// state is what the store persists
+type state struct {
+	Protection string            `json:"protection"` // Name of the protector
+	MasterKey  []byte            `json:"master_key"` // Sealed by the protector
+	Entries    map[string][]byte `json:"entries"`    // Nonce, then the box
+}
+
+// Store holds encrypted keys
+type Store struct {
+	path   string
+	state  state
+	master *[masterKeySize]byte // Nil while locked
+	mu     sync.RWMutex
+}
+
+// Open loads the keystore in dataDir. It starts locked.
+func Open(dataDir string) (*Store, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create keystore directory: %v", err)
+	}
+
+	s := &Store{
+		path:  filepath.Join(dataDir, storeFile),
+		state: state{Entries: make(map[string][]byte)},
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read keystore: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			return nil, fmt.Errorf("failed to parse keystore: %v", err)
+		}
+		if s.state.Entries == nil {
+			s.state.Entries = make(map[string][]byte)
+		}
+	}
+	return s, nil
+}
+
+// Initialized reports whether the store has a master key. An uninitialized
+// store is created by its first Unlock.
+func (s *Store) Initialized() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.state.MasterKey) > 0
+}
+
+// Protection returns the name of the protector sealing the master key
+func (s *Store) Protection() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.Protection
+}
+
+// Locked reports whether the store is locked
+func (s *Store) Locked() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.master == nil
+}
+
+// Unlock opens the master key with p. On first use a master key is created
+// and sealed with p.
+func (s *Store) Unlock(p Protector) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.state.MasterKey) == 0 {
+		var master [masterKeySize]byte
+		if _, err := rand.Read(master[:]); err != nil {
+			return fmt.Errorf("failed to generate master key: %v", err)
+		}
+		sealed, err := p.Seal(master[:])
+		if err != nil {
+			return err
+		}
+		s.state.Protection = p.Name()
+		s.state.MasterKey = sealed
+		if err := s.saveLocked(); err != nil {
+			return err
+		}
+		s.master = &master
+		return nil
+	}
+
+	if p.Name() != s.state.Protection {
+		return fmt.Errorf("keystore is protected by %s, not %s", s.state.Protection, p.Name())
+	}
+	opened, err := p.Open(s.state.MasterKey)
+	if err != nil {
+		return err
+	}
+	if len(opened) != masterKeySize {
+		return ErrWrongPassphrase
+	}
+	var master [masterKeySize]byte
+	copy(master[:], opened)
+	s.master = &master
+	return nil
+}
+
+// Lock forgets the master key until the next Unlock
+func (s *Store) Lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.master = nil
+}
+
+// ChangeProtection reseals the master key with p, e.g. for a new
+// passphrase. The store must be unlocked.
+func (s *Store) ChangeProtection(p Protector) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.master == nil {
+		return ErrLocked
+	}
+	sealed, err := p.Seal(s.master[:])
+	if err != nil {
+		return err
+	}
+	s.state.Protection = p.Name()
+	s.state.MasterKey = sealed
+	return s.saveLocked()
+}
+
+// PutFileKey stores a file's encryption key
+func (s *Store) PutFileKey(fileID string, key []byte) error {
+	return s.put(filePrefix+fileID, key)
+}
+
+// FileKey returns a file's encryption key
+func (s *Store) FileKey(fileID string) ([]byte, error) {
+	return s.get(filePrefix + fileID)
+}
+
+// DeleteFileKey removes a file's encryption key
+func (s *Store) DeleteFileKey(fileID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.Entries[filePrefix+fileID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.state.Entries, filePrefix+fileID)
+	return s.saveLocked()
+}
+
+// SetIdentity stores the node's identity private key
+func (s *Store) SetIdentity(privateKey []byte) error {
+	return s.put(identityEntry, privateKey)
+}
+
+// Identity returns the node's identity private key
+func (s *Store) Identity() ([]byte, error) {
+	return s.get(identityEntry)
+}
+
+func (s *Store) put(name string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.master == nil {
+		return ErrLocked
+	}
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	s.state.Entries[name] = secretbox.Seal(nonce[:], value, &nonce, s.master)
+	return s.saveLocked()
+}
+
+func (s *Store) get(name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.master == nil {
+		return nil, ErrLocked
+	}
+	sealed, ok := s.state.Entries[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if len(sealed) < nonceSize+secretbox.Overhead {
+		return nil, fmt.Errorf("corrupt keystore entry %s", name)
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	value, ok := secretbox.Open(nil, sealed[nonceSize:], &nonce, s.master)
+	if !ok {
+		return nil, fmt.Errorf("corrupt keystore entry %s", name)
+	}
+	return value, nil
+}
+
+// saveLocked atomically writes the store. s.mu must be held.
+func (s *Store) saveLocked() error {
+	data, err := json.Marshal(&s.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal keystore: %v", err)
+	}
+	if err := os.WriteFile(s.path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to save keystore: %v", err)
+	}
+	if err := os.Rename(s.path+".tmp", s.path); err != nil {
+		return fmt.Errorf("failed to save keystore: %v", err)
+	}
+	return nil
+}
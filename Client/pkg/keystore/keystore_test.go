@@ -0,0 +1,93 @@
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnlockAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+	assert.False(t, s.Initialized())
+	assert.ErrorIs(t, s.PutFileKey("file1", []byte("k")), ErrLocked)
+
+	require.NoError(t, s.Unlock(Passphrase("secret")))
+	require.NoError(t, s.PutFileKey("file1", []byte("file key")))
+	require.NoError(t, s.SetIdentity([]byte("node secret")))
+
+	// Nothing is stored in plaintext
+	data, err := os.ReadFile(filepath.Join(dir, storeFile))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "file key")
+	assert.NotContains(t, string(data), "node secret")
+
+	s, err = Open(dir)
+	require.NoError(t, err)
+	assert.True(t, s.Locked())
+	_, err = s.FileKey("file1")
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorIs(t, s.Unlock(Passphrase("wrong")), ErrWrongPassphrase)
+
+	require.NoError(t, s.Unlock(Passphrase("secret")))
+	key, err := s.FileKey("file1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("file key"), key)
+	identity, err := s.Identity()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("node secret"), identity)
+	_, err = s.FileKey("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A new passphrase keeps the keys
+	require.NoError(t, s.ChangeProtection(Passphrase("new secret")))
+	s.Lock()
+	assert.ErrorIs(t, s.Unlock(Passphrase("secret")), ErrWrongPassphrase)
+	require.NoError(t, s.Unlock(Passphrase("new secret")))
+	require.NoError(t, s.DeleteFileKey("file1"))
+	_, err = s.FileKey("file1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestImportManifest(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Unlock(Passphrase("secret")))
+
+	path := filepath.Join(dir, "b.zap")
+	require.NoError(t, os.WriteFile(path, []byte(`{"id":"file2","original_name":"b.txt","encryption_key":"k"}`), 0644))
+
+	fileID, err := s.ImportManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, "file2", fileID)
+	key, err := s.FileKey("file2")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("k"), key)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "encryption_key")
+	assert.Contains(t, string(data), "b.txt")
+
+	// Importing again keeps the stored key
+	_, err = s.ImportManifest(path)
+	require.NoError(t, err)
+	key, err = s.FileKey("file2")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("k"), key)
+
+	// The manifest is left alone while the store is locked
+	other := filepath.Join(dir, "c.zap")
+	require.NoError(t, os.WriteFile(other, []byte(`{"ID":"file3","encryption_key":"k"}`), 0644))
+	s.Lock()
+	_, err = s.ImportManifest(other)
+	assert.ErrorIs(t, err, ErrLocked)
+	data, err = os.ReadFile(other)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "encryption_key")
+}
@@ -0,0 +1,59 @@
+package keystore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ImportManifest moves the plaintext encryption key of a .zap manifest
+// into the store and rewrites the manifest without it. It returns the
+// manifest's file ID. Manifests without a key are left as they are.
+func (s *Store) ImportManifest(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	// Client manifests name the ID "ID", Divider manifests "id"
+	var fileID string
+	for _, name := range []string{"ID", "id"} {
+		if raw, ok := fields[name]; ok {
+			json.Unmarshal(raw, &fileID)
+		}
+	}
+	if fileID == "" {
+		return "", fmt.Errorf("manifest %s has no file ID", path)
+	}
+
+	var key string
+	if raw, ok := fields["encryption_key"]; ok {
+		json.Unmarshal(raw, &key)
+	}
+	if key == "" {
+		return fileID, nil
+	}
+	if err := s.PutFileKey(fileID, []byte(key)); err != nil {
+		return "", err
+	}
+
+	delete(fields, "encryption_key")
+	stripped, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, stripped, 0644); err != nil {
+		return "", fmt.Errorf("failed to rewrite manifest: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to rewrite manifest: %v", err)
+	}
+	return fileID, nil
+}
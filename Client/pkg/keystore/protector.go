@@ -0,0 +1,42 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/sharelink"
+)
+
+// ErrNoSystemProtection is returned by System where the operating system
+// has no user key store FileZap can use
+var ErrNoSystemProtection = errors.New("no system key protection on this platform")
+
+// passphraseProtector seals the master key with a key derived from a
+// passphrase, the same way share links seal file keys
+type passphraseProtector struct {
+	passphrase string
+}
+
+// Passphrase returns a protector that seals with a passphrase
+func Passphrase(passphrase string) Protector {
+	return &passphraseProtector{passphrase: passphrase}
+}
+
+func (p *passphraseProtector) Name() string {
+	return "passphrase"
+}
+
+func (p *passphraseProtector) Seal(masterKey []byte) ([]byte, error) {
+	if p.passphrase == "" {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	return sharelink.WrapKey(masterKey, p.passphrase)
+}
+
+func (p *passphraseProtector) Open(sealed []byte) ([]byte, error) {
+	key, err := sharelink.UnwrapKey(sealed, p.passphrase)
+	if errors.Is(err, sharelink.ErrWrongPassphrase) {
+		return nil, ErrWrongPassphrase
+	}
+	return key, err
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package keystore
+
+// System returns the protector backed by the operating system's user key
+// store. Only Windows (DPAPI) is supported; elsewhere the keystore is
+// protected by a passphrase.
+func System() (Protector, error) {
+	return nil, ErrNoSystemProtection
+}
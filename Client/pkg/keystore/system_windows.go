@@ -0,0 +1,53 @@
+//go:build windows
+
+package keystore
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapiProtector seals the master key with DPAPI, so only the same Windows
+// user can open it and no passphrase is needed
+type dpapiProtector struct{}
+
+// System returns the protector backed by the operating system's user key
+// store
+func System() (Protector, error) {
+	return dpapiProtector{}, nil
+}
+
+func (dpapiProtector) Name() string {
+	return "dpapi"
+}
+
+func (dpapiProtector) Seal(masterKey []byte) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(newBlob(masterKey), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, fmt.Errorf("failed to protect master key: %v", err)
+	}
+	return takeBlob(&out), nil
+}
+
+func (dpapiProtector) Open(sealed []byte) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(newBlob(sealed), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return takeBlob(&out), nil
+}
+
+func newBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeBlob copies a blob allocated by DPAPI and frees it
+func takeBlob(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	return append([]byte(nil), unsafe.Slice(blob.Data, blob.Size)...)
+}
@@ -0,0 +1,180 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An escrow is an account holding a payer's funds until they are released
+// to payees or refunded. Holding, releasing and refunding are ordinary
+// transactions with idempotency keys derived from the escrow ID, so the
+// journal alone records every escrow and its outcome.
+const (
+	escrowPrefix = "escrow:"
+	holdPrefix   = "hold:"
+	settlePrefix = "settle:"
+)
+
+var (
+	// ErrEscrowNotFound is returned for escrows that were never held
+	ErrEscrowNotFound = errors.New("escrow not found")
+
+	// ErrEscrowSettled is returned for escrows already released or refunded
+	ErrEscrowSettled = errors.New("escrow already settled")
+)
+
+// Escrow describes funds held in escrow
+type Escrow struct {
+	ID      string
+	Payer   string
+	Amount  int64
+	Created int64  // Unix time the funds were held
+	Memo    string // Memo of the hold
+}
+
+// EscrowAccount returns the account holding an escrow's funds
+func EscrowAccount(escrowID string) string {
+	return escrowPrefix + escrowID
+}
+
+// Hold moves amount from the payer into a new escrow, failing with
+// ErrInsufficientFunds if the payer cannot cover it
+func (l *Ledger) Hold(escrowID, payer string, amount int64, memo string) (*Transaction, error) {
+	return l.Charge(holdPrefix+escrowID, payer, EscrowAccount(escrowID), amount, memo)
+}
+
+// Release pays an escrow out to payees in proportion to their weights. Any
+// remainder from rounding goes to the payee with the largest weight.
+func (l *Ledger) Release(escrowID string, weights map[string]int64, memo string) (*Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	escrow, err := l.escrowLocked(escrowID)
+	if err != nil {
+		return nil, err
+	}
+
+	payees := make([]string, 0, len(weights))
+	var total int64
+	for payee, weight := range weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %d", payee, weight)
+		}
+		payees = append(payees, payee)
+		total += weight
+	}
+	if len(payees) == 0 {
+		return nil, fmt.Errorf("no payees")
+	}
+
+	// Largest weight first, so it collects the remainder
+	sort.Slice(payees, func(i, j int) bool {
+		if weights[payees[i]] != weights[payees[j]] {
+			return weights[payees[i]] > weights[payees[j]]
+		}
+		return payees[i] < payees[j]
+	})
+
+	postings := []Posting{{Account: EscrowAccount(escrowID), Amount: -escrow.Amount}}
+	paid := int64(0)
+	for _, payee := range payees {
+		share := escrow.Amount * weights[payee] / total
+		postings = append(postings, Posting{Account: payee, Amount: share})
+		paid += share
+	}
+	postings[1].Amount += escrow.Amount - paid
+
+	return l.recordLocked(settlePrefix+escrowID, memo, postings, nil)
+}
+
+// Pay settles an escrow by paying amount of it to payee and returning the
+// rest to the payer
+func (l *Ledger) Pay(escrowID, payee string, amount int64, memo string) (*Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	escrow, err := l.escrowLocked(escrowID)
+	if err != nil {
+		return nil, err
+	}
+	if amount < 0 || amount > escrow.Amount {
+		return nil, fmt.Errorf("invalid amount: %d of %d held", amount, escrow.Amount)
+	}
+
+	postings := []Posting{{Account: EscrowAccount(escrowID), Amount: -escrow.Amount}}
+	if amount > 0 {
+		postings = append(postings, Posting{Account: payee, Amount: amount})
+	}
+	if amount < escrow.Amount {
+		postings = append(postings, Posting{Account: escrow.Payer, Amount: escrow.Amount - amount})
+	}
+	return l.recordLocked(settlePrefix+escrowID, memo, postings, nil)
+}
+
+// Refund returns an escrow's funds to its payer
+func (l *Ledger) Refund(escrowID, memo string) (*Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	escrow, err := l.escrowLocked(escrowID)
+	if err != nil {
+		return nil, err
+	}
+
+	postings := []Posting{
+		{Account: EscrowAccount(escrowID), Amount: -escrow.Amount},
+		{Account: escrow.Payer, Amount: escrow.Amount},
+	}
+	return l.recordLocked(settlePrefix+escrowID, memo, postings, nil)
+}
+
+// GetEscrow returns an escrow that is still held
+func (l *Ledger) GetEscrow(escrowID string) (*Escrow, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.escrowLocked(escrowID)
+}
+
+// escrowLocked returns a held escrow. l.mu must be held.
+func (l *Ledger) escrowLocked(escrowID string) (*Escrow, error) {
+	hold, exists := l.byKey[holdPrefix+escrowID]
+	if !exists {
+		return nil, ErrEscrowNotFound
+	}
+	if _, settled := l.byKey[settlePrefix+escrowID]; settled {
+		return nil, ErrEscrowSettled
+	}
+	return escrowFromHold(escrowID, hold), nil
+}
+
+// HeldEscrows returns all escrows that are still held
+func (l *Ledger) HeldEscrows() []*Escrow {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var escrows []*Escrow
+	for key, hold := range l.byKey {
+		if !strings.HasPrefix(key, holdPrefix) {
+			continue
+		}
+		escrowID := strings.TrimPrefix(key, holdPrefix)
+		if _, settled := l.byKey[settlePrefix+escrowID]; !settled {
+			escrows = append(escrows, escrowFromHold(escrowID, hold))
+		}
+	}
+	return escrows
+}
+
+// escrowFromHold describes an escrow from the transaction that funded it
+func escrowFromHold(escrowID string, hold *Transaction) *Escrow {
+	escrow := &Escrow{ID: escrowID, Created: hold.Time, Memo: hold.Memo}
+	for _, p := range hold.Postings {
+		if p.Amount < 0 {
+			escrow.Payer = p.Account
+			escrow.Amount = -p.Amount
+		}
+	}
+	return escrow
+}
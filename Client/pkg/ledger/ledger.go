@@ -0,0 +1,278 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+)
+
+// The ledger is a journal of transactions, ledger.log in the data
+// directory. Each transaction is appended and synced before it takes
+// effect; balances are rebuilt by replaying the journal on open.
+const journalFile = "ledger.log"
+
+var (
+	// ErrUnbalanced is returned for transactions whose postings do not sum
+	// to zero
+	ErrUnbalanced = errors.New("transaction does not balance")
+
+	// ErrInsufficientFunds is returned for charges exceeding the balance
+	ErrInsufficientFunds = errors.New("insufficient funds")
+
+	// ErrIdempotencyConflict is returned when an idempotency key is reused
+	// for a different transaction
+	ErrIdempotencyConflict = errors.New("idempotency key reused for a different transaction")
+)
+
+// Posting moves an amount into (positive) or out of (negative) an account
+type Posting struct {
+	Account string `json:"account"`
+	Amount  int64  `json:"amount"`
+}
+
+// Transaction is a balanced set of postings
+type Transaction struct {
+	ID             string    `json:"id"`
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	Time           int64     `json:"time"`
+	Memo           string    `json:"memo,omitempty"`
+	Postings       []Posting `json:"postings"`
+}
+
+// Ledger is a persistent double-entry ledger
+type Ledger struct {
+	journal  *os.File
+	txs      []*Transaction
+	balances map[string]int64
+	byKey    map[string]*Transaction // map[idempotencyKey]Transaction
+	history  map[string][]int        // map[account]indexes into txs
+	mu       sync.RWMutex
+}
+
+// Open opens the ledger stored in dataDir, creating it if needed
+func Open(dataDir string) (*Ledger, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	journal, err := os.OpenFile(filepath.Join(dataDir, journalFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %v", err)
+	}
+
+	l := &Ledger{
+		journal:  journal,
+		balances: make(map[string]int64),
+		byKey:    make(map[string]*Transaction),
+		history:  make(map[string][]int),
+	}
+
+	valid, err := l.replay(journal)
+	if err != nil {
+		journal.Close()
+		return nil, err
+	}
+
+	// Drop a partial transaction left by a crash mid-write
+	if err := journal.Truncate(valid); err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("failed to truncate ledger: %v", err)
+	}
+	if _, err := journal.Seek(valid, io.SeekStart); err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("failed to seek ledger: %v", err)
+	}
+	return l, nil
+}
+
+// replay applies the journal and returns the length of its complete entries
+func (l *Ledger) replay(journal io.Reader) (int64, error) {
+	reader := bufio.NewReader(journal)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read ledger: %v", err)
+		}
+
+		var tx Transaction
+		if err := json.Unmarshal(bytes.TrimSpace(line), &tx); err != nil {
+			return 0, fmt.Errorf("corrupt ledger at offset %d: %v", valid, err)
+		}
+		l.apply(&tx)
+		valid += int64(len(line))
+	}
+}
+
+// apply adds a transaction to the in-memory state. l.mu must be held.
+func (l *Ledger) apply(tx *Transaction) {
+	index := len(l.txs)
+	l.txs = append(l.txs, tx)
+	if tx.IdempotencyKey != "" {
+		l.byKey[tx.IdempotencyKey] = tx
+	}
+	for _, p := range tx.Postings {
+		l.balances[p.Account] += p.Amount
+		if h := l.history[p.Account]; len(h) == 0 || h[len(h)-1] != index {
+			l.history[p.Account] = append(h, index)
+		}
+	}
+}
+
+// Record durably adds a balanced transaction. If idempotencyKey was already
+// used for the same postings the earlier transaction is returned instead.
+func (l *Ledger) Record(idempotencyKey, memo string, postings ...Posting) (*Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.recordLocked(idempotencyKey, memo, postings, nil)
+}
+
+// Transfer moves amount from one account to another. Accounts may go
+// negative, e.g. the account rewards are issued from.
+func (l *Ledger) Transfer(idempotencyKey, from, to string, amount int64, memo string) (*Transaction, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid amount: %d", amount)
+	}
+	return l.Record(idempotencyKey, memo, Posting{Account: from, Amount: -amount}, Posting{Account: to, Amount: amount})
+}
+
+// Charge moves amount from an account to another, failing with
+// ErrInsufficientFunds if the account cannot cover it. Retrying a charge
+// with the same idempotency key never charges twice.
+func (l *Ledger) Charge(idempotencyKey, account, to string, amount int64, memo string) (*Transaction, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid amount: %d", amount)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	postings := []Posting{{Account: account, Amount: -amount}, {Account: to, Amount: amount}}
+	return l.recordLocked(idempotencyKey, memo, postings, func() error {
+		if l.balances[account] < amount {
+			return ErrInsufficientFunds
+		}
+		return nil
+	})
+}
+
+// recordLocked validates and appends a transaction, running check before
+// writing it. l.mu must be held.
+func (l *Ledger) recordLocked(idempotencyKey, memo string, postings []Posting, check func() error) (*Transaction, error) {
+	if len(postings) < 2 {
+		return nil, fmt.Errorf("%w: need at least two postings", ErrUnbalanced)
+	}
+	var sum int64
+	for _, p := range postings {
+		if p.Account == "" {
+			return nil, fmt.Errorf("posting without account")
+		}
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return nil, ErrUnbalanced
+	}
+
+	if idempotencyKey != "" {
+		if tx, exists := l.byKey[idempotencyKey]; exists {
+			if !samePostings(tx.Postings, postings) {
+				return nil, ErrIdempotencyConflict
+			}
+			return tx, nil
+		}
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return nil, err
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate transaction ID: %v", err)
+	}
+	tx := &Transaction{
+		ID:             hex.EncodeToString(id),
+		IdempotencyKey: idempotencyKey,
+		Time:           time.Now().Unix(),
+		Memo:           memo,
+		Postings:       postings,
+	}
+
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transaction: %v", err)
+	}
+	if _, err := l.journal.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write ledger: %v", err)
+	}
+	if err := l.journal.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync ledger: %v", err)
+	}
+
+	l.apply(tx)
+	return tx, nil
+}
+
+func samePostings(a, b []Posting) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Balance returns an account's balance
+func (l *Ledger) Balance(account string) int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.balances[account]
+}
+
+// Lookup returns the transaction recorded with an idempotency key
+func (l *Ledger) Lookup(idempotencyKey string) (*Transaction, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	tx, exists := l.byKey[idempotencyKey]
+	return tx, exists && idempotencyKey != ""
+}
+
+// History returns a page of the transactions touching an account, newest
+// first, and their total number
+func (l *Ledger) History(account string, opts types.ListOptions) ([]*Transaction, int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	indexes := l.history[account]
+	start, end, _ := opts.Page(len(indexes))
+	txs := make([]*Transaction, 0, end-start)
+	for i := start; i < end; i++ {
+		txs = append(txs, l.txs[indexes[len(indexes)-1-i]])
+	}
+	return txs, len(indexes)
+}
+
+// Close closes the ledger's journal
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.journal.Close()
+}
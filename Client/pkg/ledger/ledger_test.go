@@ -0,0 +1,250 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedgerPersists(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir)
+	require.NoError(t, err)
+	_, err = l.Transfer("reward-1", "rewards", "validator", 100, "validation reward")
+	require.NoError(t, err)
+	_, err = l.Charge("download-1", "validator", "storer", 30, "chunk download")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, int64(70), l.Balance("validator"))
+	assert.Equal(t, int64(30), l.Balance("storer"))
+	assert.Equal(t, int64(-100), l.Balance("rewards"))
+
+	// Idempotency keys survive restarts
+	_, err = l.Charge("download-1", "validator", "storer", 30, "chunk download")
+	require.NoError(t, err)
+	assert.Equal(t, int64(70), l.Balance("validator"))
+	tx, exists := l.Lookup("reward-1")
+	require.True(t, exists)
+	assert.Equal(t, "validation reward", tx.Memo)
+	_, exists = l.Lookup("reward-2")
+	assert.False(t, exists)
+}
+
+func TestLedgerRejectsInvalidTransactions(t *testing.T) {
+	l, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.Record("", "", Posting{Account: "a", Amount: 5}, Posting{Account: "b", Amount: -4})
+	assert.ErrorIs(t, err, ErrUnbalanced)
+
+	_, err = l.Charge("charge-1", "a", "b", 10, "")
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	_, err = l.Transfer("t-1", "rewards", "a", 10, "")
+	require.NoError(t, err)
+	_, err = l.Transfer("t-1", "rewards", "a", 20, "")
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+
+	_, err = l.Transfer("", "rewards", "a", 0, "")
+	assert.Error(t, err)
+	assert.Equal(t, int64(10), l.Balance("a"))
+}
+
+func TestLedgerHistory(t *testing.T) {
+	l, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer l.Close()
+
+	for _, key := range []string{"t1", "t2", "t3"} {
+		_, err := l.Transfer(key, "rewards", "a", 1, key)
+		require.NoError(t, err)
+	}
+	_, err = l.Transfer("other", "rewards", "b", 1, "")
+	require.NoError(t, err)
+
+	txs, total := l.History("a", types.ListOptions{Limit: 2})
+	assert.Equal(t, 3, total)
+	require.Len(t, txs, 2)
+	assert.Equal(t, "t3", txs[0].Memo)
+	assert.Equal(t, "t2", txs[1].Memo)
+
+	txs, _ = l.History("a", types.ListOptions{Offset: 2})
+	require.Len(t, txs, 1)
+	assert.Equal(t, "t1", txs[0].Memo)
+}
+
+func TestLedgerIgnoresTornTransaction(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir)
+	require.NoError(t, err)
+	_, err = l.Transfer("t1", "rewards", "a", 5, "")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"id":"x","postings":[{"account":"a","amo`)
+	require.NoError(t, err)
+	f.Close()
+
+	l, err = Open(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), l.Balance("a"))
+	_, err = l.Transfer("t2", "rewards", "a", 1, "")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, int64(6), l.Balance("a"))
+}
+
+func TestEscrow(t *testing.T) {
+	l, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.Transfer("fund", "rewards", "client", 10, "")
+	require.NoError(t, err)
+
+	_, err = l.Hold("req1", "client", 20, "")
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	_, err = l.Hold("req1", "client", 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), l.Balance("client"))
+	escrow, err := l.GetEscrow("req1")
+	require.NoError(t, err)
+	assert.Equal(t, "client", escrow.Payer)
+	assert.Len(t, l.HeldEscrows(), 1)
+
+	// Payees are paid by weight, the largest taking the remainder
+	_, err = l.Release("req1", map[string]int64{"s1": 2, "s2": 1}, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), l.Balance("s1"))
+	assert.Equal(t, int64(3), l.Balance("s2"))
+	assert.Equal(t, int64(0), l.Balance(EscrowAccount("req1")))
+	assert.Empty(t, l.HeldEscrows())
+
+	_, err = l.Refund("req1", "")
+	assert.ErrorIs(t, err, ErrEscrowSettled)
+	_, err = l.Refund("req2", "")
+	assert.ErrorIs(t, err, ErrEscrowNotFound)
+}
+
+func TestEscrowPay(t *testing.T) {
+	l, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.Transfer("fund", "rewards", "client", 10, "")
+	require.NoError(t, err)
+	_, err = l.Hold("ch1", "client", 8, "channel to s1")
+	require.NoError(t, err)
+	escrow, err := l.GetEscrow("ch1")
+	require.NoError(t, err)
+	assert.Equal(t, "channel to s1", escrow.Memo)
+
+	_, err = l.Pay("ch1", "s1", 9, "")
+	assert.Error(t, err)
+	_, err = l.Pay("ch1", "s1", 5, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), l.Balance("s1"))
+	assert.Equal(t, int64(5), l.Balance("client"))
+	assert.Equal(t, int64(0), l.Balance(EscrowAccount("ch1")))
+
+	_, err = l.Pay("ch1", "s1", 5, "")
+	assert.ErrorIs(t, err, ErrEscrowSettled)
+}
+
+func TestEscrowRefund(t *testing.T) {
+	l, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.Transfer("fund", "rewards", "client", 10, "")
+	require.NoError(t, err)
+	_, err = l.Hold("req1", "client", 4, "")
+	require.NoError(t, err)
+	_, err = l.Refund("req1", "timed out")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), l.Balance("client"))
+
+	_, err = l.Release("req1", map[string]int64{"s1": 1}, "")
+	assert.ErrorIs(t, err, ErrEscrowSettled)
+}
+
+func TestStake(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(t, err)
+
+	_, err = l.Transfer("fund", "rewards", "v1", 100, "")
+	require.NoError(t, err)
+	_, err = l.Stake("stake-1", "v1", 150)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	_, err = l.Stake("stake-1", "v1", 80)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), l.Balance("v1"))
+	assert.Equal(t, int64(80), l.StakeOf("v1"))
+
+	_, err = l.Unstake("unstake-1", "v1", 100)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	_, err = l.Unstake("unstake-1", "v1", 20)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"v1": 60}, l.Stakes())
+
+	// Stakes survive restarts
+	require.NoError(t, l.Close())
+	l, err = Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, int64(60), l.StakeOf("v1"))
+	assert.Equal(t, int64(40), l.Balance("v1"))
+}
+
+func TestSlash(t *testing.T) {
+	l, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.Slash("slash-1", "v1", 50, "")
+	assert.ErrorIs(t, err, ErrNoStake)
+
+	_, err = l.Transfer("fund", "rewards", "v1", 100, "")
+	require.NoError(t, err)
+	_, err = l.Stake("stake-1", "v1", 60)
+	require.NoError(t, err)
+	_, err = l.Slash("slash-1", "v1", 150, "")
+	assert.Error(t, err)
+
+	_, err = l.Slash("slash-1", "v1", 50, "conflicting votes")
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), l.StakeOf("v1"))
+	assert.Equal(t, int64(30), l.Balance(SlashedAccount))
+
+	// A retried slash is applied once
+	_, err = l.Slash("slash-1", "v1", 50, "conflicting votes")
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), l.StakeOf("v1"))
+
+	// Small stakes still lose a unit
+	_, err = l.Unstake("unstake-1", "v1", 29)
+	require.NoError(t, err)
+	_, err = l.Slash("slash-2", "v1", 10, "")
+	require.NoError(t, err)
+	assert.Zero(t, l.StakeOf("v1"))
+	assert.Empty(t, l.Stakes())
+}
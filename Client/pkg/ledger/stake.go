@@ -0,0 +1,84 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A validator's stake is locked in an account of its own, out of reach of
+// its balance until it is unstaked. Slashing takes part of a stake and moves
+// it to SlashedAccount, out of circulation.
+const (
+	stakePrefix    = "stake:"
+	SlashedAccount = "slashed"
+)
+
+// ErrNoStake is returned for slashing a validator with nothing staked
+var ErrNoStake = errors.New("validator has no stake")
+
+// StakeAccount returns the account holding a validator's stake
+func StakeAccount(validatorID string) string {
+	return stakePrefix + validatorID
+}
+
+// Stake locks amount of a validator's balance as stake, failing with
+// ErrInsufficientFunds if the balance cannot cover it
+func (l *Ledger) Stake(idempotencyKey, validatorID string, amount int64) (*Transaction, error) {
+	return l.Charge(idempotencyKey, validatorID, StakeAccount(validatorID), amount, "stake")
+}
+
+// Unstake returns amount of a validator's stake to its balance, failing
+// with ErrInsufficientFunds if more than is staked is asked for
+func (l *Ledger) Unstake(idempotencyKey, validatorID string, amount int64) (*Transaction, error) {
+	return l.Charge(idempotencyKey, StakeAccount(validatorID), validatorID, amount, "unstake")
+}
+
+// Slash takes percent of a validator's stake, and at least one unit of it.
+// Retrying a slash with the same idempotency key returns the first slash,
+// whatever the stake has become since.
+func (l *Ledger) Slash(idempotencyKey, validatorID string, percent int64, memo string) (*Transaction, error) {
+	if percent <= 0 || percent > 100 {
+		return nil, fmt.Errorf("invalid slash percentage: %d", percent)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if tx, exists := l.byKey[idempotencyKey]; exists && idempotencyKey != "" {
+		return tx, nil
+	}
+	stake := l.balances[StakeAccount(validatorID)]
+	if stake <= 0 {
+		return nil, ErrNoStake
+	}
+	amount := stake * percent / 100
+	if amount == 0 {
+		amount = 1
+	}
+
+	postings := []Posting{
+		{Account: StakeAccount(validatorID), Amount: -amount},
+		{Account: SlashedAccount, Amount: amount},
+	}
+	return l.recordLocked(idempotencyKey, memo, postings, nil)
+}
+
+// StakeOf returns a validator's stake
+func (l *Ledger) StakeOf(validatorID string) int64 {
+	return l.Balance(StakeAccount(validatorID))
+}
+
+// Stakes returns the stake of every validator with something staked
+func (l *Ledger) Stakes() map[string]int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stakes := make(map[string]int64)
+	for account, balance := range l.balances {
+		if strings.HasPrefix(account, stakePrefix) && balance > 0 {
+			stakes[strings.TrimPrefix(account, stakePrefix)] = balance
+		}
+	}
+	return stakes
+}
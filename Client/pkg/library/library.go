@@ -0,0 +1,281 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Entry origins
+const (
+	OriginUploaded   = "uploaded"
+	OriginDownloaded = "downloaded"
+)
+
+// ErrNotFound is returned for files not in the library
+var ErrNotFound = errors.New("file not in library")
+
+// Entry is a .zap file the user uploaded or downloaded
+type Entry struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Manifest string `json:"manifest"` // Path of the .zap file
+	Key      string `json:"key,omitempty"`
+	Size     int64  `json:"size"`
+	Origin   string `json:"origin"`
+	Pinned   bool   `json:"pinned,omitempty"`
+	Added    int64  `json:"added"`
+
+	// Replication health, as last reported
+	Replicas        int   `json:"replicas"`
+	ReplicationGoal int   `json:"replication_goal"`
+	HealthChecked   int64 `json:"health_checked,omitempty"`
+
+	Downloads []Download `json:"downloads,omitempty"`
+
+	// Earlier versions of the file, oldest first
+	Snapshots []Snapshot `json:"snapshots,omitempty"`
+}
+
+// Download records one download of a file
+type Download struct {
+	Time   int64  `json:"time"`
+	Output string `json:"output"`
+}
+
+// Healthy reports whether the file met its replication goal when last
+// checked
+func (e *Entry) Healthy() bool {
+	return e.HealthChecked != 0 && e.Replicas >= e.ReplicationGoal
+}
+
+// Library is the local record of the user's .zap files
+type Library struct {
+	dataDir    string
+	entries    map[string]*Entry // map[fileID]Entry
+	log        *os.File
+	logEntries int
+	mu         sync.RWMutex
+}
+
+// Open loads the library stored in dataDir
+func Open(dataDir string) (*Library, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	l := &Library{
+		dataDir: dataDir,
+		entries: make(map[string]*Entry),
+	}
+	if err := l.load(); err != nil {
+		return nil, fmt.Errorf("failed to load library: %v", err)
+	}
+	return l, nil
+}
+
+// manifestFields are the fields read from a .zap manifest. Manifests
+// written by the Client and by the Divider name them differently.
+type manifestFields struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	OriginalName  string `json:"original_name"`
+	TotalSize     int64  `json:"totalsize"`
+	TotalSizeAlt  int64  `json:"total_size"`
+	EncryptionKey string `json:"encryption_key"`
+}
+
+// AddManifest adds the file described by the .zap manifest at path. A file
+// already in the library keeps its history, pin and health.
+func (l *Library) AddManifest(path, origin string) (*Entry, error) {
+	entry, err := manifestEntry(path, origin)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.Add(entry); err != nil {
+		return nil, err
+	}
+	return l.Get(entry.ID)
+}
+
+// manifestEntry reads the entry for the .zap manifest at path
+func manifestEntry(path, origin string) (*Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var fields manifestFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if fields.ID == "" {
+		return nil, fmt.Errorf("invalid manifest: missing file ID")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest path: %v", err)
+	}
+
+	entry := &Entry{
+		ID:       fields.ID,
+		Name:     fields.Name,
+		Manifest: abs,
+		Key:      fields.EncryptionKey,
+		Size:     fields.TotalSize,
+		Origin:   origin,
+	}
+	if entry.Name == "" {
+		entry.Name = fields.OriginalName
+	}
+	if entry.Size == 0 {
+		entry.Size = fields.TotalSizeAlt
+	}
+	return entry, nil
+}
+
+// Add adds or updates a file. A file already in the library keeps its
+// history, pin and health.
+func (l *Library) Add(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.commit(&logEntry{Op: opAdd, Entry: entry})
+}
+
+// Get returns a copy of a file's entry
+func (l *Library) Get(id string) (*Entry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, exists := l.entries[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return entry.copy(), nil
+}
+
+// Search returns the files whose name contains query, ignoring case, most
+// recently added first. An empty query matches every file.
+func (l *Library) Search(query string) []*Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	query = strings.ToLower(query)
+	var found []*Entry
+	for _, entry := range l.entries {
+		if strings.Contains(strings.ToLower(entry.Name), query) {
+			found = append(found, entry.copy())
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Added != found[j].Added {
+			return found[i].Added > found[j].Added
+		}
+		return found[i].ID < found[j].ID
+	})
+	return found
+}
+
+// SetPinned marks whether a file's chunks are kept stored locally
+func (l *Library) SetPinned(id string, pinned bool) error {
+	return l.update(&logEntry{Op: opPin, ID: id, Pinned: pinned})
+}
+
+// UpdateHealth records how many replicas of a file were found
+func (l *Library) UpdateHealth(id string, replicas, goal int) error {
+	return l.update(&logEntry{Op: opHealth, ID: id, Replicas: replicas, Goal: goal})
+}
+
+// RecordDownload adds a download of a file to its history
+func (l *Library) RecordDownload(id, output string) error {
+	return l.update(&logEntry{Op: opDownload, ID: id, Output: output})
+}
+
+// MoveManifest updates the entry whose manifest was moved from one path to
+// another. It is not an error if no entry has the old path.
+func (l *Library) MoveManifest(from, to string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range l.entries {
+		if entry.Manifest == from {
+			return l.commit(&logEntry{Op: opMove, ID: entry.ID, Manifest: to})
+		}
+	}
+	return nil
+}
+
+// Reshare copies a file's manifest into dir, to give to others
+func (l *Library) Reshare(id, dir string) (string, error) {
+	entry, err := l.Get(id)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(entry.Manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to open manifest: %v", err)
+	}
+	defer src.Close()
+
+	path := filepath.Join(dir, entry.Name+".zap")
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("failed to copy manifest: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		return "", fmt.Errorf("failed to copy manifest: %v", err)
+	}
+	return path, nil
+}
+
+// Remove forgets a file. If deleteManifest is set its .zap file is deleted
+// too, along with those of its snapshots.
+func (l *Library) Remove(id string, deleteManifest bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, exists := l.entries[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if deleteManifest {
+		if err := os.Remove(entry.Manifest); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete manifest: %v", err)
+		}
+		for _, snapshot := range entry.Snapshots {
+			if err := os.Remove(snapshot.Manifest); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to delete manifest: %v", err)
+			}
+		}
+	}
+	return l.commit(&logEntry{Op: opRemove, ID: id})
+}
+
+// update commits a change to an existing file
+func (l *Library) update(change *logEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, exists := l.entries[change.ID]; !exists {
+		return ErrNotFound
+	}
+	return l.commit(change)
+}
+
+func (e *Entry) copy() *Entry {
+	c := *e
+	c.Downloads = append([]Download(nil), e.Downloads...)
+	c.Snapshots = append([]Snapshot(nil), e.Snapshots...)
+	return &c
+}
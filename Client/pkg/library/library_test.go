@@ -0,0 +1,155 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeManifest(t *testing.T, dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	return path
+}
+
+func TestAddManifestFormats(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+
+	// Written by the Client's file operations
+	client := writeManifest(t, dir, "a.zap", `{"ID":"file1","Name":"a.txt","TotalSize":10}`)
+	entry, err := l.AddManifest(client, OriginUploaded)
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt", entry.Name)
+	assert.Equal(t, int64(10), entry.Size)
+	assert.NotZero(t, entry.Added)
+
+	// Written by the Divider
+	divider := writeManifest(t, dir, "b.zap", `{"id":"file2","original_name":"b.txt","total_size":20,"encryption_key":"k"}`)
+	entry, err = l.AddManifest(divider, OriginDownloaded)
+	require.NoError(t, err)
+	assert.Equal(t, "b.txt", entry.Name)
+	assert.Equal(t, int64(20), entry.Size)
+	assert.Equal(t, "k", entry.Key)
+
+	_, err = l.AddManifest(writeManifest(t, dir, "c.zap", `{"name":"c.txt"}`), OriginUploaded)
+	assert.Error(t, err)
+}
+
+func TestLibrarySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(t, err)
+
+	manifest := writeManifest(t, dir, "a.zap", `{"ID":"file1","Name":"Holiday.mp4"}`)
+	_, err = l.AddManifest(manifest, OriginUploaded)
+	require.NoError(t, err)
+	require.NoError(t, l.SetPinned("file1", true))
+	require.NoError(t, l.UpdateHealth("file1", 2, 3))
+	require.NoError(t, l.RecordDownload("file1", "/tmp/out"))
+
+	// Adding it again keeps its pin, health and history
+	_, err = l.AddManifest(manifest, OriginUploaded)
+	require.NoError(t, err)
+
+	// Reopen without Close, as after a crash
+	l.log.Close()
+	l, err = Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+
+	entry, err := l.Get("file1")
+	require.NoError(t, err)
+	assert.True(t, entry.Pinned)
+	assert.Equal(t, 2, entry.Replicas)
+	assert.False(t, entry.Healthy())
+	require.Len(t, entry.Downloads, 1)
+	assert.Equal(t, "/tmp/out", entry.Downloads[0].Output)
+
+	assert.ErrorIs(t, l.SetPinned("missing", true), ErrNotFound)
+}
+
+func TestSearchReshareAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+
+	require.NoError(t, l.Add(&Entry{ID: "1", Name: "Report.pdf", Manifest: writeManifest(t, dir, "1.zap", `{}`)}))
+	require.NoError(t, l.Add(&Entry{ID: "2", Name: "photo.jpg", Manifest: writeManifest(t, dir, "2.zap", `{}`)}))
+
+	found := l.Search("REPORT")
+	require.Len(t, found, 1)
+	assert.Equal(t, "1", found[0].ID)
+	assert.Len(t, l.Search(""), 2)
+
+	shared, err := l.Reshare("1", t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, "Report.pdf.zap", filepath.Base(shared))
+	assert.FileExists(t, shared)
+
+	require.NoError(t, l.Remove("1", true))
+	assert.NoFileExists(t, filepath.Join(dir, "1.zap"))
+	require.NoError(t, l.Remove("2", false))
+	assert.FileExists(t, filepath.Join(dir, "2.zap"))
+	assert.Empty(t, l.Search(""))
+}
+
+func TestSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(t, err)
+
+	current := writeManifest(t, dir, "notes.zap", `{"ID":"v1","Name":"notes.txt","TotalSize":10}`)
+	_, err = l.AddManifest(current, OriginUploaded)
+	require.NoError(t, err)
+	require.NoError(t, l.SetPinned("v1", true))
+
+	// Zap sync keeps the previous manifest beside the new one
+	previous := filepath.Join(dir, "notes.1.zap")
+	require.NoError(t, os.Rename(current, previous))
+	require.NoError(t, l.MoveManifest(current, previous))
+	writeManifest(t, dir, "notes.zap", `{"ID":"v2","Name":"notes.txt","TotalSize":20}`)
+	entry, err := l.AddVersion(current, OriginUploaded, previous)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", entry.ID)
+	assert.True(t, entry.Pinned)
+	require.Len(t, entry.Snapshots, 1)
+	assert.Equal(t, "v1", entry.Snapshots[0].ID)
+	assert.Equal(t, previous, entry.Snapshots[0].Manifest)
+	assert.Equal(t, int64(10), entry.Snapshots[0].Size)
+	assert.Len(t, l.Search("notes"), 1)
+	_, err = l.Get("v1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Restoring swaps the versions
+	_, err = l.RestoreSnapshot("v2", "missing")
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+	entry, err = l.RestoreSnapshot("v2", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", entry.ID)
+	assert.Equal(t, previous, entry.Manifest)
+	require.Len(t, entry.Snapshots, 1)
+	assert.Equal(t, "v2", entry.Snapshots[0].ID)
+
+	// Reopen without Close, as after a crash
+	l.log.Close()
+	l, err = Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+
+	snapshots, err := l.Snapshots("v1")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, current, snapshots[0].Manifest)
+
+	// Removing the file deletes every version's manifest
+	require.NoError(t, l.Remove("v1", true))
+	assert.NoFileExists(t, current)
+	assert.NoFileExists(t, previous)
+}
@@ -0,0 +1,158 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// When Zap sync uploads an edited file again, the entry of the previous
+// version becomes a snapshot of the new one rather than a file of its own,
+// so the library lists each watched file once and keeps every version it
+// uploaded. Restoring a snapshot makes it the current version and
+// snapshots the version it replaces, so no version is lost.
+
+// ErrSnapshotNotFound is returned for versions a file does not have
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is an earlier version of a library file
+type Snapshot struct {
+	ID       string `json:"id"`
+	Manifest string `json:"manifest"` // Path of the version's .zap file
+	Key      string `json:"key,omitempty"`
+	Size     int64  `json:"size"`
+	Added    int64  `json:"added"`    // When the version was added
+	Replaced int64  `json:"replaced"` // When another version replaced it
+}
+
+// AddVersion adds the file described by the .zap manifest at path as the
+// newest version of the file whose manifest is at previous. The previous
+// version becomes a snapshot of the new one, which keeps its pin and
+// download history. If no file has the manifest previous, the file is
+// added as by AddManifest.
+func (l *Library) AddVersion(path, origin, previous string) (*Entry, error) {
+	entry, err := manifestEntry(path, origin)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(previous); err == nil {
+		previous = abs
+	}
+
+	l.mu.Lock()
+	change := &logEntry{Op: opAdd, Entry: entry}
+	for _, old := range l.entries {
+		if old.Manifest == previous && old.ID != entry.ID {
+			change = &logEntry{Op: opVersion, Entry: entry, ID: old.ID}
+			break
+		}
+	}
+	err = l.commit(change)
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return l.Get(entry.ID)
+}
+
+// Snapshots returns the earlier versions of a file, in the order they were
+// replaced
+func (l *Library) Snapshots(id string) ([]Snapshot, error) {
+	entry, err := l.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Snapshots, nil
+}
+
+// RestoreSnapshot makes an earlier version of a file its current one. The
+// version it replaces becomes a snapshot in turn. The file's entry, which
+// takes the restored version's ID, is returned.
+func (l *Library) RestoreSnapshot(id, snapshotID string) (*Entry, error) {
+	l.mu.Lock()
+	entry, exists := l.entries[id]
+	if !exists {
+		l.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	if entry.findSnapshot(snapshotID) < 0 {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s has no version %s", ErrSnapshotNotFound, entry.Name, snapshotID)
+	}
+	err := l.commit(&logEntry{Op: opRestore, ID: id, Snapshot: snapshotID})
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return l.Get(snapshotID)
+}
+
+// applyVersion replaces a file with a new version. l.mu must be held.
+func (l *Library) applyVersion(change *logEntry) {
+	if change.Entry == nil {
+		return
+	}
+	old, exists := l.entries[change.ID]
+	if !exists {
+		l.apply(&logEntry{Op: opAdd, Entry: change.Entry, Time: change.Time})
+		return
+	}
+
+	added := change.Entry.copy()
+	added.Added = change.Time
+	added.Pinned = old.Pinned
+	added.Downloads = old.Downloads
+	added.Snapshots = append(old.Snapshots, old.snapshot(change.Time))
+	delete(l.entries, old.ID)
+	l.entries[added.ID] = added
+}
+
+// applyRestore swaps a file's current version for one of its snapshots.
+// l.mu must be held.
+func (l *Library) applyRestore(entry *Entry, change *logEntry) {
+	i := entry.findSnapshot(change.Snapshot)
+	if i < 0 {
+		return
+	}
+	restored := entry.Snapshots[i]
+	snapshots := append([]Snapshot(nil), entry.Snapshots[:i]...)
+	snapshots = append(snapshots, entry.Snapshots[i+1:]...)
+	snapshots = append(snapshots, entry.snapshot(change.Time))
+
+	entry.ID = restored.ID
+	entry.Manifest = restored.Manifest
+	entry.Key = restored.Key
+	entry.Size = restored.Size
+	entry.Added = restored.Added
+	entry.Snapshots = snapshots
+
+	// The restored version's replicas have not been counted
+	entry.Replicas = 0
+	entry.ReplicationGoal = 0
+	entry.HealthChecked = 0
+
+	delete(l.entries, change.ID)
+	l.entries[entry.ID] = entry
+}
+
+// snapshot returns the file's current version as a snapshot
+func (e *Entry) snapshot(replaced int64) Snapshot {
+	return Snapshot{
+		ID:       e.ID,
+		Manifest: e.Manifest,
+		Key:      e.Key,
+		Size:     e.Size,
+		Added:    e.Added,
+		Replaced: replaced,
+	}
+}
+
+// findSnapshot returns the index of a snapshot, or -1
+func (e *Entry) findSnapshot(id string) int {
+	for i, snapshot := range e.Snapshots {
+		if snapshot.ID == id {
+			return i
+		}
+	}
+	return -1
+}
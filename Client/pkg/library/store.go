@@ -0,0 +1,257 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Like the registry, the library is stored as a snapshot, library.json,
+// plus an append-only log of the changes made since, library.log. Every
+// change is synced to the log before it is applied, and the log is folded
+// into a new snapshot once it passes compactThreshold entries.
+const (
+	snapshotFile     = "library.json"
+	logFile          = "library.log"
+	compactThreshold = 500
+)
+
+// Log operations
+const (
+	opAdd      = "add"
+	opPin      = "pin"
+	opHealth   = "health"
+	opDownload = "download"
+	opMove     = "move"
+	opRemove   = "remove"
+	opVersion  = "version"
+	opRestore  = "restore"
+)
+
+// logEntry is one change in the library log
+type logEntry struct {
+	Op       string `json:"op"`
+	Entry    *Entry `json:"entry,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Pinned   bool   `json:"pinned,omitempty"`
+	Replicas int    `json:"replicas,omitempty"`
+	Goal     int    `json:"goal,omitempty"`
+	Output   string `json:"output,omitempty"`
+	Manifest string `json:"manifest,omitempty"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Time     int64  `json:"time"`
+}
+
+// commit durably logs a change and applies it. l.mu must be held.
+func (l *Library) commit(entry *logEntry) error {
+	entry.Time = time.Now().Unix()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal library change: %v", err)
+	}
+	if _, err := l.log.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write library log: %v", err)
+	}
+	if err := l.log.Sync(); err != nil {
+		return fmt.Errorf("failed to sync library log: %v", err)
+	}
+
+	l.apply(entry)
+	l.logEntries++
+	if l.logEntries >= compactThreshold {
+		if err := l.compact(); err != nil {
+			// The change is already durable in the log
+			log.Printf("Failed to compact library: %v", err)
+		}
+	}
+	return nil
+}
+
+// apply makes a logged change to the in-memory state. l.mu must be held.
+func (l *Library) apply(change *logEntry) {
+	if change.Op == opAdd {
+		if change.Entry == nil {
+			return
+		}
+		added := change.Entry.copy()
+		if old, exists := l.entries[added.ID]; exists {
+			added.Pinned = old.Pinned
+			added.Added = old.Added
+			added.Replicas = old.Replicas
+			added.ReplicationGoal = old.ReplicationGoal
+			added.HealthChecked = old.HealthChecked
+			added.Downloads = old.Downloads
+			if added.Key == "" {
+				added.Key = old.Key
+			}
+		} else {
+			added.Added = change.Time
+		}
+		l.entries[added.ID] = added
+		return
+	}
+	if change.Op == opVersion {
+		l.applyVersion(change)
+		return
+	}
+
+	entry, exists := l.entries[change.ID]
+	if !exists {
+		return
+	}
+	switch change.Op {
+	case opPin:
+		entry.Pinned = change.Pinned
+
+	case opHealth:
+		entry.Replicas = change.Replicas
+		entry.ReplicationGoal = change.Goal
+		entry.HealthChecked = change.Time
+
+	case opDownload:
+		entry.Downloads = append(entry.Downloads, Download{Time: change.Time, Output: change.Output})
+
+	case opMove:
+		entry.Manifest = change.Manifest
+
+	case opRemove:
+		delete(l.entries, change.ID)
+
+	case opRestore:
+		l.applyRestore(entry, change)
+	}
+}
+
+// compact writes the current state as a new snapshot and empties the log.
+// l.mu must be held.
+func (l *Library) compact() error {
+	if err := l.saveSnapshot(); err != nil {
+		return err
+	}
+	if err := l.log.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate library log: %v", err)
+	}
+	if _, err := l.log.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind library log: %v", err)
+	}
+	l.logEntries = 0
+	return nil
+}
+
+// saveSnapshot atomically writes the current state as the snapshot
+func (l *Library) saveSnapshot() error {
+	data, err := json.MarshalIndent(l.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal library: %v", err)
+	}
+
+	path := filepath.Join(l.dataDir, snapshotFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save library: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to save library: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to save library: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to save library: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save library: %v", err)
+	}
+	return nil
+}
+
+// load reads the snapshot, replays the log over it and opens the log for
+// appending
+func (l *Library) load() error {
+	data, err := os.ReadFile(filepath.Join(l.dataDir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read library: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &l.entries); err != nil {
+			return fmt.Errorf("failed to parse library: %v", err)
+		}
+		if l.entries == nil {
+			l.entries = make(map[string]*Entry)
+		}
+	}
+
+	logf, err := os.OpenFile(filepath.Join(l.dataDir, logFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open library log: %v", err)
+	}
+	valid, err := l.replay(logf)
+	if err != nil {
+		logf.Close()
+		return err
+	}
+
+	// Drop a partial entry left by a crash mid-write, then append after
+	// the last complete one
+	if err := logf.Truncate(valid); err != nil {
+		logf.Close()
+		return fmt.Errorf("failed to truncate library log: %v", err)
+	}
+	if _, err := logf.Seek(valid, io.SeekStart); err != nil {
+		logf.Close()
+		return fmt.Errorf("failed to seek library log: %v", err)
+	}
+	l.log = logf
+	return nil
+}
+
+// replay applies the entries in the log and returns the length of its
+// complete entries
+func (l *Library) replay(r io.Reader) (int64, error) {
+	reader := bufio.NewReader(r)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A trailing line without a newline was never acknowledged
+			return valid, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read library log: %v", err)
+		}
+
+		var entry logEntry
+		if err := json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil {
+			return 0, fmt.Errorf("corrupt library log at offset %d: %v", valid, err)
+		}
+		l.apply(&entry)
+		l.logEntries++
+		valid += int64(len(line))
+	}
+}
+
+// Close compacts the library and closes its log
+func (l *Library) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.log == nil {
+		return nil
+	}
+	err := l.compact()
+	if closeErr := l.log.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close library log: %v", closeErr)
+	}
+	l.log = nil
+	return err
+}
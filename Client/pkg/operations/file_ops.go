@@ -19,13 +19,28 @@ const defaultBufferSize = 32 * 1024 // 32KB buffer
 // ServerInterface defines the methods that FileOperations needs from a server
 type ServerInterface interface {
 GetPeersWithFile(fileID string) []string
+GetPeersWithChunk(chunkID string) []string // Peers announcing the chunk in the registry
 RegisterFile(info *server.FileInfo) error
-FetchChunks(fileInfo *server.FileInfo, peerID string) error
+FetchChunk(chunk server.ChunkInfo, peerID string) ([]byte, error)
+ReportChunkResult(peerID string, valid bool) // Feeds the peer's reputation
+ResolveFile(fileID string) (*server.FileInfo, error)
+RotateFileKey(fileID string, oldKey, newKey []byte) error // Revokes oldKey with the validators
+FundDownload(info *server.FileInfo) error // Makes sure the node's account can pay for the file
+SettleChannels() // Pays the storage nodes for the chunks fetched
+}
+
+// KeyStore holds file encryption keys outside the .zap manifests
+type KeyStore interface {
+	FileKey(fileID string) ([]byte, error)
+	PutFileKey(fileID string, key []byte) error
+	DeleteFileKey(fileID string) error
+	ImportManifest(path string) (string, error) // Moves a manifest's key into the store
 }
 
 // FileOperations handles file splitting and joining operations
 type FileOperations struct {
 server ServerInterface
+keys   KeyStore // Nil if keys are not managed
 }
 
 // NewFileOperations creates a new FileOperations instance
@@ -108,22 +123,58 @@ return fmt.Errorf("invalid chunk size: must be greater than 0")
 		chunkIndex++
 	}
 
+	// The registration carries the manifest, so the file can be resolved
+	// from its ID alone
+	metadata, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	info.Metadata = metadata
+
 	// Register with server
 	if err := f.server.RegisterFile(info); err != nil {
 		return fmt.Errorf("failed to register file: %v", err)
 	}
 
 	// Save manifest
+	manifest := *info
+	manifest.Metadata = nil
 	manifestPath := filepath.Join(outputPath, info.Name+".zap")
-	if err := saveManifest(manifestPath, info); err != nil {
+	if err := saveManifest(manifestPath, &manifest); err != nil {
 		return fmt.Errorf("failed to save manifest: %v", err)
 	}
 
 	return nil
 }
 
+// SetKeyStore sets the store file keys are kept in. A key found in a
+// manifest handled afterwards is moved into the store.
+func (f *FileOperations) SetKeyStore(keys KeyStore) {
+	f.keys = keys
+}
+
+// FileKey returns the encryption key of the file a .zap manifest describes,
+// moving it into the key store first if the manifest still holds it
+func (f *FileOperations) FileKey(zapPath string) ([]byte, error) {
+	if f.keys == nil {
+		return nil, fmt.Errorf("no key store is set")
+	}
+	fileID, err := f.keys.ImportManifest(zapPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to protect manifest key: %v", err)
+	}
+	return f.keys.FileKey(fileID)
+}
+
 // JoinFile joins chunks back into the original file using a .zap file
 func (f *FileOperations) JoinFile(zapPath, outputPath string) error {
+	// Keys do not stay on disk in plaintext
+	if f.keys != nil {
+		if _, err := f.keys.ImportManifest(zapPath); err != nil {
+			return fmt.Errorf("failed to protect manifest key: %v", err)
+		}
+	}
+
 	// Load manifest
 	info, err := loadManifest(zapPath)
 	if err != nil {
@@ -135,12 +186,9 @@ func (f *FileOperations) JoinFile(zapPath, outputPath string) error {
 		return fmt.Errorf("failed to create output directory: %v", err)
 	}
 
-	// Get chunks from network if needed
-	peers := f.server.GetPeersWithFile(info.ID)
-	if len(peers) > 0 {
-		if err := f.server.FetchChunks(info, peers[0]); err != nil {
-			return fmt.Errorf("failed to fetch chunks: %v", err)
-		}
+	// Get missing or corrupt chunks from the network
+	if err := f.fetchChunks(info); err != nil {
+		return err
 	}
 
 	// Create output file
@@ -158,6 +206,9 @@ func (f *FileOperations) JoinFile(zapPath, outputPath string) error {
 		if err != nil {
 			return fmt.Errorf("failed to read chunk %s: %v", info.Chunks[i].ID, err)
 		}
+		if !chunkValid(info.Chunks[i], chunkData) {
+			return fmt.Errorf("chunk %s changed on disk", info.Chunks[i].ID)
+		}
 
 		if _, err := out.Write(chunkData); err != nil {
 			return fmt.Errorf("failed to write chunk data: %v", err)
@@ -167,8 +218,108 @@ func (f *FileOperations) JoinFile(zapPath, outputPath string) error {
 	return nil
 }
 
+// ImportFile resolves a shared file by ID, saves its manifest in
+// outputPath and downloads the file there. It returns the manifest's path.
+func (f *FileOperations) ImportFile(fileID, outputPath string) (string, error) {
+	info, err := f.server.ResolveFile(fileID)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve file: %v", err)
+	}
+
+	// Chunks are fetched next to the manifest rather than into the
+	// sharer's chunk directory
+	chunkDir := filepath.Join(outputPath, "."+info.ID)
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create chunk directory: %v", err)
+	}
+	info.ChunkDir = chunkDir
+	info.Metadata = nil
+
+	zapPath := filepath.Join(outputPath, filepath.Base(info.Name)+".zap")
+	if err := saveManifest(zapPath, info); err != nil {
+		return "", fmt.Errorf("failed to save manifest: %v", err)
+	}
+	if err := f.JoinFile(zapPath, outputPath); err != nil {
+		return "", err
+	}
+	return zapPath, nil
+}
+
+// fetchChunks downloads every chunk not already stored locally with the
+// hash in the manifest, rarest first, once the download is funded. Each
+// chunk is tried from the peers holding the file and the manifest's mirrors
+// in turn until one returns data matching its hash; peers are told apart in
+// the reputation system by whether their chunks verified. Successive chunks
+// start at successive sources, spreading the download over them, but peers
+// known to hold a chunk are tried before the others and sources that sent
+// bad data earlier in the download are tried last. The storage nodes are
+// paid for the chunks they sent at the end.
+func (f *FileOperations) fetchChunks(info *server.FileInfo) error {
+	var missing []server.ChunkInfo
+	for _, chunk := range info.Chunks {
+		chunkPath := filepath.Join(info.ChunkDir, chunk.ID)
+		if data, err := os.ReadFile(chunkPath); err == nil && chunkValid(chunk, data) {
+			continue
+		}
+		missing = append(missing, chunk)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := f.server.FundDownload(info); err != nil {
+		return fmt.Errorf("failed to fund download: %v", err)
+	}
+	defer f.server.SettleChannels()
+
+	filePeers := f.server.GetPeersWithFile(info.ID)
+	providers := f.rarestFirst(missing)
+	failures := make(map[chunkSource]int) // map[source]bad chunks in this download
+
+	for n, chunk := range missing {
+		sources := chunkSources(mergePeers(providers[chunk.ID], filePeers), info.Mirrors)
+		if len(sources) == 0 {
+			return fmt.Errorf("failed to fetch chunk %s: no peers or mirrors have the file", chunk.ID)
+		}
+
+		var lastErr error
+		fetched := false
+		for _, source := range mixSources(sources, failures, providers[chunk.ID], n) {
+			data, err := f.fetchFrom(source, chunk)
+			if err == nil && !chunkValid(chunk, data) {
+				err = fmt.Errorf("hash mismatch from %s", source)
+			}
+			if err != nil {
+				failures[source]++
+				if source.peer != "" {
+					f.server.ReportChunkResult(source.peer, false)
+				}
+				lastErr = err
+				continue
+			}
+			if source.peer != "" {
+				f.server.ReportChunkResult(source.peer, true)
+			}
+
+			if err := os.WriteFile(filepath.Join(info.ChunkDir, chunk.ID), data, 0644); err != nil {
+				return fmt.Errorf("failed to save chunk %s: %v", chunk.ID, err)
+			}
+			fetched = true
+			break
+		}
+		if !fetched {
+			return fmt.Errorf("failed to fetch chunk %s from %d sources: %v", chunk.ID, len(sources), lastErr)
+		}
+	}
+	return nil
+}
+
 // Helper functions
 
+// chunkValid reports whether data matches the chunk's hash in the manifest
+func chunkValid(chunk server.ChunkInfo, data []byte) bool {
+	return generateChunkID(data) == chunk.Hash
+}
+
 func generateFileID(path string) string {
 	data := []byte(path + strconv.FormatInt(time.Now().UnixNano(), 10))
 	hash := sha256.Sum256(data)
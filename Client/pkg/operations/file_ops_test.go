@@ -8,7 +8,10 @@ import (
 "testing"
 "time"
 
+"github.com/VetheonGames/FileZap/Client/pkg/keystore"
 "github.com/VetheonGames/FileZap/Client/pkg/server"
+"github.com/VetheonGames/FileZap/Divider/pkg/encryption"
+"github.com/VetheonGames/FileZap/Divider/pkg/zap"
 "github.com/stretchr/testify/assert"
 "github.com/stretchr/testify/require"
 )
@@ -17,6 +20,15 @@ import (
 type mockServer struct {
 files     map[string]*server.FileInfo
 failFetch bool
+peers     []string                     // Peers holding every file, if set
+chunkPeers map[string][]string         // map[chunkID]peers announcing it
+served    map[string]map[string][]byte // map[peerID]map[chunkID]data
+reports   map[string][]bool            // map[peerID]chunk results
+rotated   map[string][]byte            // map[fileID]key rotated to
+failRotate bool
+unfunded  bool     // Downloads cannot be paid for
+funded    []string // Files downloads were funded for
+settled   int      // Downloads the storage nodes were paid for
 }
 
 func newMockServer() ServerInterface {
@@ -27,24 +39,77 @@ failFetch: false,
 }
 
 func (m *mockServer) GetPeersWithFile(fileID string) []string {
+if m.peers != nil {
+return m.peers
+}
 if _, exists := m.files[fileID]; exists {
 return []string{"mock-peer-1"}
 }
 return nil
 }
 
+func (m *mockServer) GetPeersWithChunk(chunkID string) []string {
+return m.chunkPeers[chunkID]
+}
+
 func (m *mockServer) RegisterFile(info *server.FileInfo) error {
 m.files[info.ID] = info
 return nil
 }
 
-func (m *mockServer) FetchChunks(fileInfo *server.FileInfo, peerID string) error {
+func (m *mockServer) FetchChunk(chunk server.ChunkInfo, peerID string) ([]byte, error) {
 if m.failFetch {
+return nil, assert.AnError
+}
+data, ok := m.served[peerID][chunk.ID]
+if !ok {
+return nil, assert.AnError
+}
+return data, nil
+}
+
+func (m *mockServer) ResolveFile(fileID string) (*server.FileInfo, error) {
+info, exists := m.files[fileID]
+if !exists {
+return nil, assert.AnError
+}
+var manifest server.FileInfo
+if err := json.Unmarshal(info.Metadata, &manifest); err != nil {
+return nil, err
+}
+return &manifest, nil
+}
+
+func (m *mockServer) RotateFileKey(fileID string, oldKey, newKey []byte) error {
+if m.failRotate {
+return assert.AnError
+}
+if m.rotated == nil {
+m.rotated = make(map[string][]byte)
+}
+m.rotated[fileID] = newKey
+return nil
+}
+
+func (m *mockServer) FundDownload(info *server.FileInfo) error {
+if m.unfunded {
 return assert.AnError
 }
+m.funded = append(m.funded, info.ID)
 return nil
 }
 
+func (m *mockServer) SettleChannels() {
+m.settled++
+}
+
+func (m *mockServer) ReportChunkResult(peerID string, valid bool) {
+if m.reports == nil {
+m.reports = make(map[string][]bool)
+}
+m.reports[peerID] = append(m.reports[peerID], valid)
+}
+
 func TestFileOperations_SplitFile(t *testing.T) {
 // Create temporary directories for testing
 testDir, err := os.MkdirTemp("", "filezap-test-*")
@@ -135,11 +200,60 @@ joinedData, err := os.ReadFile(joinedFile)
 require.NoError(t, err)
 assert.Equal(t, testData, string(joinedData))
 
-// Test network failure case
+// Chunks stored locally are used without the network
 mockSrv.failFetch = true
+require.NoError(t, fileOps.JoinFile(manifestPath, outputDir))
+
+// Test network failure case
+info, err := loadManifest(manifestPath)
+require.NoError(t, err)
+require.NoError(t, os.Remove(filepath.Join(chunkDir, info.Chunks[0].ID)))
 err = fileOps.JoinFile(manifestPath, outputDir)
 assert.Error(t, err)
-assert.Contains(t, err.Error(), "failed to fetch chunks")
+assert.Contains(t, err.Error(), "failed to fetch chunk")
+}
+
+func TestFileOperations_JoinFileRefetchesBadChunks(t *testing.T) {
+testDir := t.TempDir()
+chunkDir := filepath.Join(testDir, "chunks")
+outputDir := filepath.Join(testDir, "output")
+
+testFile := filepath.Join(testDir, "test.txt")
+testData := "This is test data for FileZap testing. Some peers will serve corrupt chunks."
+require.NoError(t, os.WriteFile(testFile, []byte(testData), 0644))
+
+mockSrv := &mockServer{files: make(map[string]*server.FileInfo)}
+fileOps := NewFileOperations(mockSrv)
+require.NoError(t, fileOps.SplitFile(testFile, chunkDir, "16"))
+manifestPath := filepath.Join(chunkDir, "test.txt.zap")
+info, err := loadManifest(manifestPath)
+require.NoError(t, err)
+
+// Lose one chunk and corrupt another; the first peer serves garbage and
+// the second the real data
+good := make(map[string][]byte)
+bad := make(map[string][]byte)
+for _, chunk := range info.Chunks {
+data, err := os.ReadFile(filepath.Join(chunkDir, chunk.ID))
+require.NoError(t, err)
+good[chunk.ID] = data
+bad[chunk.ID] = []byte("corrupt")
+}
+require.NoError(t, os.Remove(filepath.Join(chunkDir, info.Chunks[0].ID)))
+require.NoError(t, os.WriteFile(filepath.Join(chunkDir, info.Chunks[1].ID), []byte("corrupt"), 0644))
+
+mockSrv.peers = []string{"bad-peer", "good-peer"}
+mockSrv.served = map[string]map[string][]byte{"bad-peer": bad, "good-peer": good}
+
+require.NoError(t, fileOps.JoinFile(manifestPath, outputDir))
+joinedData, err := os.ReadFile(filepath.Join(outputDir, "test.txt"))
+require.NoError(t, err)
+assert.Equal(t, testData, string(joinedData))
+
+// Both peers were reported, and after its first bad chunk the bad peer
+// was only tried after the good one
+assert.Equal(t, []bool{false}, mockSrv.reports["bad-peer"])
+assert.Equal(t, []bool{true, true}, mockSrv.reports["good-peer"])
 }
 
 func TestFileOperations_ErrorHandling(t *testing.T) {
@@ -297,3 +411,168 @@ assert.True(t, isValidHexString(id1), "ChunkID should be a valid hex string")
 func isValidHexString(s string) bool {
 return len(s) == 64 && strings.Trim(s, "0123456789abcdef") == ""
 }
+
+func TestFileOperations_ImportFile(t *testing.T) {
+testDir := t.TempDir()
+chunkDir := filepath.Join(testDir, "chunks")
+importDir := filepath.Join(testDir, "import")
+
+testFile := filepath.Join(testDir, "shared.txt")
+testData := "This is test data for FileZap testing. It is shared by ID."
+require.NoError(t, os.WriteFile(testFile, []byte(testData), 0644))
+
+mockSrv := &mockServer{files: make(map[string]*server.FileInfo)}
+fileOps := NewFileOperations(mockSrv)
+require.NoError(t, fileOps.SplitFile(testFile, chunkDir, "16"))
+info, err := loadManifest(filepath.Join(chunkDir, "shared.txt.zap"))
+require.NoError(t, err)
+
+// The importer fetches every chunk from the sharer
+served := make(map[string][]byte)
+for _, chunk := range info.Chunks {
+data, err := os.ReadFile(filepath.Join(chunkDir, chunk.ID))
+require.NoError(t, err)
+served[chunk.ID] = data
+}
+mockSrv.peers = []string{"sharer"}
+mockSrv.served = map[string]map[string][]byte{"sharer": served}
+
+zapPath, err := fileOps.ImportFile(info.ID, importDir)
+require.NoError(t, err)
+assert.Equal(t, filepath.Join(importDir, "shared.txt.zap"), zapPath)
+joinedData, err := os.ReadFile(filepath.Join(importDir, "shared.txt"))
+require.NoError(t, err)
+assert.Equal(t, testData, string(joinedData))
+assert.Len(t, mockSrv.reports["sharer"], len(info.Chunks))
+
+assert.Equal(t, []string{info.ID}, mockSrv.funded)
+assert.Equal(t, 1, mockSrv.settled)
+
+_, err = fileOps.ImportFile("unknown", importDir)
+assert.Error(t, err)
+
+// Nothing is fetched for a download that cannot be paid for
+mockSrv.unfunded = true
+mockSrv.reports = nil
+_, err = fileOps.ImportFile(info.ID, filepath.Join(testDir, "unpaid"))
+assert.ErrorContains(t, err, "fund")
+assert.Empty(t, mockSrv.reports)
+assert.Equal(t, 1, mockSrv.settled)
+}
+
+func TestFileOperations_KeysMoveToKeyStore(t *testing.T) {
+testDir := t.TempDir()
+chunkDir := filepath.Join(testDir, "chunks")
+
+testFile := filepath.Join(testDir, "secret.txt")
+require.NoError(t, os.WriteFile(testFile, []byte("This is test data for FileZap testing."), 0644))
+
+fileOps := NewFileOperations(&mockServer{files: make(map[string]*server.FileInfo)})
+require.NoError(t, fileOps.SplitFile(testFile, chunkDir, "16"))
+
+// Give the manifest a plaintext key, as older manifests have
+zapPath := filepath.Join(chunkDir, "secret.txt.zap")
+var manifest map[string]interface{}
+data, err := os.ReadFile(zapPath)
+require.NoError(t, err)
+require.NoError(t, json.Unmarshal(data, &manifest))
+manifest["encryption_key"] = "file key"
+data, err = json.Marshal(manifest)
+require.NoError(t, err)
+require.NoError(t, os.WriteFile(zapPath, data, 0644))
+
+_, err = fileOps.FileKey(zapPath)
+assert.Error(t, err, "no key store set")
+
+keys, err := keystore.Open(filepath.Join(testDir, "keys"))
+require.NoError(t, err)
+require.NoError(t, keys.Unlock(keystore.Passphrase("secret")))
+fileOps.SetKeyStore(keys)
+
+require.NoError(t, fileOps.JoinFile(zapPath, filepath.Join(testDir, "out")))
+data, err = os.ReadFile(zapPath)
+require.NoError(t, err)
+assert.NotContains(t, string(data), "file key")
+
+key, err := fileOps.FileKey(zapPath)
+require.NoError(t, err)
+assert.Equal(t, []byte("file key"), key)
+
+// The key is unavailable while the store is locked
+keys.Lock()
+_, err = fileOps.FileKey(zapPath)
+assert.ErrorIs(t, err, keystore.ErrLocked)
+}
+
+func TestFileOperations_RotateKey(t *testing.T) {
+testDir := t.TempDir()
+chunksDir := filepath.Join(testDir, "chunks")
+require.NoError(t, os.MkdirAll(chunksDir, 0755))
+
+// A manifest as the Divider writes it
+oldKey, err := encryption.GenerateKey()
+require.NoError(t, err)
+parts := []string{"first chunk", "second chunk", "third"}
+metadata := &zap.FileMetadata{ID: "file1", OriginalName: "secret.txt", ChunkCount: len(parts), EncryptionKey: oldKey}
+for i, part := range parts {
+encrypted, err := encryption.Encrypt([]byte(part), oldKey)
+require.NoError(t, err)
+chunk := zap.ChunkMetadata{Index: i, Size: int64(len(part))}
+require.NoError(t, chunk.UpdateEncryptedHash(encrypted))
+require.NoError(t, os.WriteFile(filepath.Join(chunksDir, chunk.EncryptedHash), encrypted, 0644))
+metadata.Chunks = append(metadata.Chunks, chunk)
+}
+data, err := json.Marshal(metadata)
+require.NoError(t, err)
+zapPath := filepath.Join(testDir, "file1.zap")
+require.NoError(t, os.WriteFile(zapPath, data, 0644))
+
+keys, err := keystore.Open(filepath.Join(testDir, "keys"))
+require.NoError(t, err)
+require.NoError(t, keys.Unlock(keystore.Passphrase("secret")))
+mockSrv := &mockServer{files: make(map[string]*server.FileInfo), failRotate: true}
+fileOps := NewFileOperations(mockSrv)
+fileOps.SetKeyStore(keys)
+
+// The validators refuse, so the old key and chunks stay in use
+require.Error(t, fileOps.RotateKey(zapPath))
+key, err := keys.FileKey("file1")
+require.NoError(t, err)
+assert.Equal(t, oldKey, string(key))
+entries, err := os.ReadDir(chunksDir)
+require.NoError(t, err)
+assert.Len(t, entries, 2*len(parts), "old and re-encrypted chunks")
+
+// A retry finishes the rotation
+mockSrv.failRotate = false
+require.NoError(t, fileOps.RotateKey(zapPath))
+newKey, err := keys.FileKey("file1")
+require.NoError(t, err)
+assert.NotEqual(t, oldKey, string(newKey))
+assert.Equal(t, newKey, mockSrv.rotated["file1"])
+_, err = keys.FileKey("file1" + rotationSuffix)
+assert.ErrorIs(t, err, keystore.ErrNotFound)
+
+rotated, err := zap.ReadZapFile(zapPath)
+require.NoError(t, err)
+assert.Empty(t, rotated.EncryptionKey)
+for i, chunk := range rotated.Chunks {
+assert.NotEqual(t, metadata.Chunks[i].EncryptedHash, chunk.EncryptedHash)
+encrypted, err := os.ReadFile(filepath.Join(chunksDir, chunk.EncryptedHash))
+require.NoError(t, err)
+plain, err := encryption.Decrypt(encrypted, string(newKey))
+require.NoError(t, err)
+assert.Equal(t, parts[i], string(plain))
+}
+entries, err = os.ReadDir(chunksDir)
+require.NoError(t, err)
+assert.Len(t, entries, len(parts), "old chunks removed")
+_, err = os.Stat(filepath.Join(testDir, ".file1.zap.rotate"))
+assert.True(t, os.IsNotExist(err))
+
+// The updated manifest is registered
+registered, err := mockSrv.ResolveFile("file1")
+require.NoError(t, err)
+require.Len(t, registered.Chunks, len(parts))
+assert.Equal(t, rotated.Chunks[0].EncryptedHash, registered.Chunks[0].ID)
+}
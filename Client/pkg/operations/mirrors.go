@@ -0,0 +1,147 @@
+package operations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/server"
+)
+
+// A manifest can list HTTP(S) mirrors, web servers serving the file's
+// chunks by ID under a base URL, the way BitTorrent web seeds do; serving
+// the chunk directory with any web server is enough. Downloads use mirrors
+// as sources next to the peers holding the file, so a publisher can make a
+// file available from a web server before enough peers replicate it.
+// Mirrors are checked like peers, chunk by chunk, but are not rated.
+
+// mirrorTimeout bounds each chunk request to a mirror
+const mirrorTimeout = 30 * time.Second
+
+var mirrorClient = &http.Client{Timeout: mirrorTimeout}
+
+// chunkSource is a peer or a mirror chunks are fetched from
+type chunkSource struct {
+	peer   string
+	mirror string
+}
+
+func (s chunkSource) String() string {
+	if s.peer != "" {
+		return "peer " + s.peer
+	}
+	return "mirror " + s.mirror
+}
+
+// chunkSources lists the peers and then the mirrors of a file
+func chunkSources(peers, mirrors []string) []chunkSource {
+	sources := make([]chunkSource, 0, len(peers)+len(mirrors))
+	for _, p := range peers {
+		sources = append(sources, chunkSource{peer: p})
+	}
+	for _, m := range mirrors {
+		if validateMirror(m) == nil {
+			sources = append(sources, chunkSource{mirror: m})
+		}
+	}
+	return sources
+}
+
+// mixSources orders the sources to try for the n-th fetched chunk: those
+// with the fewest failures first and, among them, the mirrors and the
+// peers known to hold the chunk, starting from the n-th source. With no
+// known holders every peer is a candidate.
+func mixSources(sources []chunkSource, failures map[chunkSource]int, holders []string, n int) []chunkSource {
+	holds := make(map[string]bool, len(holders))
+	for _, p := range holders {
+		holds[p] = true
+	}
+	known := func(s chunkSource) bool {
+		return s.mirror != "" || len(holds) == 0 || holds[s.peer]
+	}
+
+	ordered := make([]chunkSource, len(sources))
+	for i := range sources {
+		ordered[i] = sources[(n+i)%len(sources)]
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if fi, fj := failures[ordered[i]], failures[ordered[j]]; fi != fj {
+			return fi < fj
+		}
+		return known(ordered[i]) && !known(ordered[j])
+	})
+	return ordered
+}
+
+// fetchFrom downloads a chunk from a source
+func (f *FileOperations) fetchFrom(source chunkSource, chunk server.ChunkInfo) ([]byte, error) {
+	if source.peer != "" {
+		return f.server.FetchChunk(chunk, source.peer)
+	}
+	return fetchMirror(source.mirror, chunk)
+}
+
+// fetchMirror downloads a chunk from a mirror
+func fetchMirror(mirror string, chunk server.ChunkInfo) ([]byte, error) {
+	resp, err := mirrorClient.Get(strings.TrimRight(mirror, "/") + "/" + url.PathEscape(chunk.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mirror %s returned status %d", mirror, resp.StatusCode)
+	}
+
+	// A chunk longer than the manifest says is wrong, however long
+	var body io.Reader = resp.Body
+	if chunk.Size > 0 {
+		body = io.LimitReader(resp.Body, chunk.Size+1)
+	}
+	return io.ReadAll(body)
+}
+
+// validateMirror checks that a mirror is an HTTP(S) URL
+func validateMirror(mirror string) error {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return fmt.Errorf("invalid mirror %q: %v", mirror, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid mirror %q: must be an http or https URL", mirror)
+	}
+	return nil
+}
+
+// SetMirrors sets the mirrors a .zap manifest lists and registers the
+// updated manifest, so files resolved by ID list them too
+func (f *FileOperations) SetMirrors(zapPath string, mirrors []string) error {
+	for _, m := range mirrors {
+		if err := validateMirror(m); err != nil {
+			return err
+		}
+	}
+
+	info, err := loadManifest(zapPath)
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %v", err)
+	}
+	info.Mirrors = mirrors
+	info.Metadata = nil
+	if err := saveManifest(zapPath, info); err != nil {
+		return fmt.Errorf("failed to save manifest: %v", err)
+	}
+
+	registration := *info
+	if registration.Metadata, err = json.Marshal(info); err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err := f.server.RegisterFile(&registration); err != nil {
+		return fmt.Errorf("failed to register file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package operations
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileOperations_ImportFromMirror(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	importDir := filepath.Join(testDir, "import")
+
+	testFile := filepath.Join(testDir, "published.txt")
+	testData := "This is test data for FileZap testing. It is published on a web server."
+	require.NoError(t, os.WriteFile(testFile, []byte(testData), 0644))
+
+	mockSrv := &mockServer{files: make(map[string]*server.FileInfo)}
+	fileOps := NewFileOperations(mockSrv)
+	require.NoError(t, fileOps.SplitFile(testFile, chunkDir, "16"))
+	zapPath := filepath.Join(chunkDir, "published.txt.zap")
+
+	// The publisher serves its chunk directory; no peer holds the file yet
+	var requests int32
+	files := http.FileServer(http.Dir(chunkDir))
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		files.ServeHTTP(w, r)
+	}))
+	defer mirror.Close()
+
+	assert.Error(t, fileOps.SetMirrors(zapPath, []string{"ftp://example.com/chunks"}))
+	require.NoError(t, fileOps.SetMirrors(zapPath, []string{mirror.URL + "/"}))
+	info, err := loadManifest(zapPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{mirror.URL + "/"}, info.Mirrors)
+
+	mockSrv.peers = []string{}
+	_, err = fileOps.ImportFile(info.ID, importDir)
+	require.NoError(t, err)
+	joinedData, err := os.ReadFile(filepath.Join(importDir, "published.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, testData, string(joinedData))
+	assert.Equal(t, int32(len(info.Chunks)), atomic.LoadInt32(&requests))
+}
+
+func TestFileOperations_MixesMirrorsAndPeers(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	importDir := filepath.Join(testDir, "import")
+
+	testFile := filepath.Join(testDir, "published.txt")
+	testData := "This is test data for FileZap testing. Peers and a mirror share the load."
+	require.NoError(t, os.WriteFile(testFile, []byte(testData), 0644))
+
+	mockSrv := &mockServer{files: make(map[string]*server.FileInfo)}
+	fileOps := NewFileOperations(mockSrv)
+	require.NoError(t, fileOps.SplitFile(testFile, chunkDir, "8"))
+	zapPath := filepath.Join(chunkDir, "published.txt.zap")
+
+	var requests int32
+	files := http.FileServer(http.Dir(chunkDir))
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		files.ServeHTTP(w, r)
+	}))
+	defer mirror.Close()
+	require.NoError(t, fileOps.SetMirrors(zapPath, []string{mirror.URL}))
+	info, err := loadManifest(zapPath)
+	require.NoError(t, err)
+
+	served := make(map[string][]byte)
+	for _, chunk := range info.Chunks {
+		data, err := os.ReadFile(filepath.Join(chunkDir, chunk.ID))
+		require.NoError(t, err)
+		served[chunk.ID] = data
+	}
+	mockSrv.peers = []string{"seeder"}
+	mockSrv.served = map[string]map[string][]byte{"seeder": served}
+
+	_, err = fileOps.ImportFile(info.ID, importDir)
+	require.NoError(t, err)
+	joinedData, err := os.ReadFile(filepath.Join(importDir, "published.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, testData, string(joinedData))
+
+	// Chunks alternate between the peer and the mirror
+	peerChunks := len(mockSrv.reports["seeder"])
+	mirrorChunks := int(atomic.LoadInt32(&requests))
+	assert.Equal(t, len(info.Chunks), peerChunks+mirrorChunks)
+	assert.InDelta(t, peerChunks, mirrorChunks, 1)
+}
+
+func TestMixSourcesDemotesFailures(t *testing.T) {
+	sources := chunkSources([]string{"a", "b"}, []string{"https://mirror.example/chunks", "not a url"})
+	require.Len(t, sources, 3)
+
+	failures := map[chunkSource]int{{peer: "a"}: 1}
+	assert.Equal(t, []chunkSource{{peer: "b"}, {mirror: "https://mirror.example/chunks"}, {peer: "a"}}, mixSources(sources, failures, nil, 0))
+	assert.Equal(t, []chunkSource{{mirror: "https://mirror.example/chunks"}, {peer: "b"}, {peer: "a"}}, mixSources(sources, failures, nil, 2))
+
+	// Peers known to hold the chunk come before the others
+	assert.Equal(t, []chunkSource{{peer: "b"}, {mirror: "https://mirror.example/chunks"}, {peer: "a"}}, mixSources(sources, nil, []string{"b"}, 0))
+	assert.Equal(t, []chunkSource{{mirror: "https://mirror.example/chunks"}, {peer: "b"}, {peer: "a"}}, mixSources(sources, nil, []string{"b"}, 2))
+}
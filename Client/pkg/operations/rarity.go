@@ -0,0 +1,42 @@
+package operations
+
+import (
+	"sort"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/server"
+)
+
+// Downloads fetch the chunks with the fewest providers in the registry
+// first, as BitTorrent fetches the rarest pieces first. The rare chunks are
+// the ones a departing node is most likely to take with it, and every
+// download of them adds a copy the network can serve on. Chunks no peer
+// announces are fetched first of all, from the peers holding the file.
+
+// rarestFirst sorts chunks by how many peers announce them, fewest first,
+// keeping file order among equally rare chunks. It returns the announcing
+// peers of each chunk.
+func (f *FileOperations) rarestFirst(chunks []server.ChunkInfo) map[string][]string {
+	providers := make(map[string][]string, len(chunks))
+	for _, chunk := range chunks {
+		providers[chunk.ID] = f.server.GetPeersWithChunk(chunk.ID)
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return len(providers[chunks[i].ID]) < len(providers[chunks[j].ID])
+	})
+	return providers
+}
+
+// mergePeers lists the peers in first and then the others in second
+func mergePeers(first, second []string) []string {
+	seen := make(map[string]bool, len(first)+len(second))
+	var merged []string
+	for _, list := range [][]string{first, second} {
+		for _, p := range list {
+			if !seen[p] {
+				seen[p] = true
+				merged = append(merged, p)
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,71 @@
+package operations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orderServer records the order chunks are fetched in
+type orderServer struct {
+	*mockServer
+	fetched []string
+}
+
+func (s *orderServer) FetchChunk(chunk server.ChunkInfo, peerID string) ([]byte, error) {
+	s.fetched = append(s.fetched, chunk.ID)
+	return s.mockServer.FetchChunk(chunk, peerID)
+}
+
+func TestFileOperations_FetchesRarestChunksFirst(t *testing.T) {
+	testDir := t.TempDir()
+	chunkDir := filepath.Join(testDir, "chunks")
+	importDir := filepath.Join(testDir, "import")
+
+	testFile := filepath.Join(testDir, "shared.txt")
+	testData := "aaaaaaaabbbbbbbbccccccccdddddddd"
+	require.NoError(t, os.WriteFile(testFile, []byte(testData), 0644))
+
+	mock := &mockServer{files: make(map[string]*server.FileInfo)}
+	srv := &orderServer{mockServer: mock}
+	fileOps := NewFileOperations(srv)
+	require.NoError(t, fileOps.SplitFile(testFile, chunkDir, "8"))
+	info, err := loadManifest(filepath.Join(chunkDir, "shared.txt.zap"))
+	require.NoError(t, err)
+	require.Len(t, info.Chunks, 4)
+
+	// Every peer serves what it announces; chunk 2 is on one peer only,
+	// chunk 0 on two and the others on three
+	a, b, c := make(map[string][]byte), make(map[string][]byte), make(map[string][]byte)
+	announce := map[int][]string{0: {"a", "b"}, 1: {"a", "b", "c"}, 2: {"c"}, 3: {"a", "b", "c"}}
+	mock.chunkPeers = make(map[string][]string)
+	for i, chunk := range info.Chunks {
+		data, err := os.ReadFile(filepath.Join(chunkDir, chunk.ID))
+		require.NoError(t, err)
+		for _, p := range announce[i] {
+			map[string]map[string][]byte{"a": a, "b": b, "c": c}[p][chunk.ID] = data
+		}
+		mock.chunkPeers[chunk.ID] = announce[i]
+	}
+	mock.peers = []string{"a", "b", "c"}
+	mock.served = map[string]map[string][]byte{"a": a, "b": b, "c": c}
+
+	_, err = fileOps.ImportFile(info.ID, importDir)
+	require.NoError(t, err)
+	joinedData, err := os.ReadFile(filepath.Join(importDir, "shared.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, testData, string(joinedData))
+
+	// Rarest first, each from a peer announcing it without a failed try
+	ids := info.GetChunkIDs()
+	assert.Equal(t, []string{ids[2], ids[0], ids[1], ids[3]}, srv.fetched)
+	for _, reports := range mock.reports {
+		for _, ok := range reports {
+			assert.True(t, ok)
+		}
+	}
+}
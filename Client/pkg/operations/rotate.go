@@ -0,0 +1,220 @@
+package operations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keystore"
+	"github.com/VetheonGames/FileZap/Client/pkg/server"
+	"github.com/VetheonGames/FileZap/Divider/pkg/encryption"
+	"github.com/VetheonGames/FileZap/Divider/pkg/zap"
+)
+
+// Rotating a file's key after a suspected leak re-encrypts its chunks one
+// at a time under a new key. Progress is kept in a journal next to the
+// manifest and the new key in the key store, so an interrupted rotation
+// resumes where it stopped. Once every chunk is re-encrypted the
+// validators swap the old key for the new one, revoking it, and the updated
+// manifest is saved and registered. The old chunks are deleted last.
+
+// rotationSuffix names the key store entry of a rotation's new key
+const rotationSuffix = ".rotating"
+
+// rotationJournal records the chunks a rotation has re-encrypted
+type rotationJournal struct {
+	Chunks map[int]rotatedChunk `json:"chunks"` // By chunk index
+}
+
+// rotatedChunk names a chunk's encrypted file under the old and new keys
+type rotatedChunk struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// RotateKey replaces the key of the file a Divider .zap manifest describes,
+// re-encrypting its chunks, which are kept in the chunks directory next to
+// the manifest. It can be called again to finish a rotation that failed.
+func (f *FileOperations) RotateKey(zapPath string) error {
+	oldKey, err := f.FileKey(zapPath)
+	if err != nil {
+		return fmt.Errorf("failed to get file key: %v", err)
+	}
+	metadata, err := zap.ReadZapFile(zapPath)
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %v", err)
+	}
+	chunksDir := filepath.Join(filepath.Dir(zapPath), "chunks")
+
+	newKey, err := f.rotationKey(metadata.ID)
+	if err != nil {
+		return err
+	}
+
+	journalPath := filepath.Join(filepath.Dir(zapPath), "."+filepath.Base(zapPath)+".rotate")
+	journal, err := loadJournal(journalPath)
+	if err != nil {
+		return fmt.Errorf("failed to load rotation journal: %v", err)
+	}
+
+	// Re-encrypt the chunks not done by an earlier attempt
+	for i, chunk := range metadata.Chunks {
+		if _, done := journal.Chunks[chunk.Index]; done {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(chunksDir, chunk.EncryptedHash))
+		if err != nil {
+			return fmt.Errorf("failed to read chunk %d: %v", chunk.Index, err)
+		}
+		plain, err := encryption.Decrypt(data, string(oldKey))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt chunk %d: %v", chunk.Index, err)
+		}
+		encrypted, err := encryption.Encrypt(plain, string(newKey))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt chunk %d: %v", chunk.Index, err)
+		}
+
+		rotated := metadata.Chunks[i]
+		if err := rotated.UpdateEncryptedHash(encrypted); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(chunksDir, rotated.EncryptedHash), encrypted, 0644); err != nil {
+			return fmt.Errorf("failed to write chunk %d: %v", chunk.Index, err)
+		}
+		journal.Chunks[chunk.Index] = rotatedChunk{Old: chunk.EncryptedHash, New: rotated.EncryptedHash}
+		if err := saveJournal(journalPath, journal); err != nil {
+			return fmt.Errorf("failed to save rotation journal: %v", err)
+		}
+	}
+
+	if err := f.server.RotateFileKey(metadata.ID, oldKey, newKey); err != nil {
+		return fmt.Errorf("failed to revoke old key: %v", err)
+	}
+
+	// Switch the manifest and key store over to the new key
+	for i, chunk := range metadata.Chunks {
+		metadata.Chunks[i].EncryptedHash = journal.Chunks[chunk.Index].New
+	}
+	metadata.EncryptionKey = ""
+	if err := writeZapFile(zapPath, metadata); err != nil {
+		return fmt.Errorf("failed to save manifest: %v", err)
+	}
+	if err := f.keys.PutFileKey(metadata.ID, newKey); err != nil {
+		return fmt.Errorf("failed to store new key: %v", err)
+	}
+	if err := f.keys.DeleteFileKey(metadata.ID + rotationSuffix); err != nil && !errors.Is(err, keystore.ErrNotFound) {
+		return fmt.Errorf("failed to remove rotation key: %v", err)
+	}
+
+	if err := f.publishRotated(metadata, chunksDir); err != nil {
+		return fmt.Errorf("failed to register file: %v", err)
+	}
+
+	for _, chunk := range journal.Chunks {
+		if err := os.Remove(filepath.Join(chunksDir, chunk.Old)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old chunk %s: %v", chunk.Old, err)
+		}
+	}
+	return os.Remove(journalPath)
+}
+
+// rotationKey returns the new key of a file's rotation, generating it if
+// the rotation is starting
+func (f *FileOperations) rotationKey(fileID string) ([]byte, error) {
+	key, err := f.keys.FileKey(fileID + rotationSuffix)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, keystore.ErrNotFound) {
+		return nil, fmt.Errorf("failed to get rotation key: %v", err)
+	}
+
+	generated, err := encryption.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	if err := f.keys.PutFileKey(fileID+rotationSuffix, []byte(generated)); err != nil {
+		return nil, fmt.Errorf("failed to store rotation key: %v", err)
+	}
+	return []byte(generated), nil
+}
+
+// publishRotated registers a rotated file, so peers resolving it fetch the
+// chunks encrypted under the new key
+func (f *FileOperations) publishRotated(metadata *zap.FileMetadata, chunksDir string) error {
+	info := &server.FileInfo{
+		ID:        metadata.ID,
+		Name:      metadata.OriginalName,
+		ChunkDir:  chunksDir,
+		TotalSize: metadata.TotalSize,
+	}
+	for _, chunk := range metadata.Chunks {
+		data, err := os.ReadFile(filepath.Join(chunksDir, chunk.EncryptedHash))
+		if err != nil {
+			return err
+		}
+		info.Chunks = append(info.Chunks, server.ChunkInfo{
+			ID:    chunk.EncryptedHash,
+			Size:  int64(len(data)),
+			Hash:  generateChunkID(data),
+			Index: chunk.Index,
+		})
+	}
+
+	manifest, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	info.Metadata = manifest
+	return f.server.RegisterFile(info)
+}
+
+func loadJournal(path string) (*rotationJournal, error) {
+	journal := &rotationJournal{Chunks: make(map[int]rotatedChunk)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, err
+	}
+	if journal.Chunks == nil {
+		journal.Chunks = make(map[int]rotatedChunk)
+	}
+	return journal, nil
+}
+
+func saveJournal(path string, journal *rotationJournal) error {
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, data)
+}
+
+// writeZapFile replaces a Divider manifest
+func writeZapFile(path string, metadata *zap.FileMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, data)
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
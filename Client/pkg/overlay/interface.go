@@ -9,6 +9,8 @@ type Request struct {
 	Method string
 	Path   string
 	Body   []byte
+	PeerID string // Sender, known only once a signed request is verified
+	Signed []byte // The verified signed request, as the sender sent it
 }
 
 // Response represents an overlay network response
@@ -6,6 +6,23 @@ import (
 	"time"
 )
 
+// Chunks a peer serves adjust its reputation: a chunk that verifies raises
+// it slightly, one that fails or does not match its hash lowers it sharply.
+// Reputation is kept apart from the peer list so it outlives stale peers.
+const (
+	MinReputation = -50
+	MaxReputation = 100
+	chunkReward   = 1
+	chunkPenalty  = -10
+)
+
+// ChunkStats counts the chunks fetched from a peer
+type ChunkStats struct {
+	Served     int // Chunks that verified
+	Failed     int // Chunks that failed to transfer or verify
+	Reputation int
+}
+
 // Peer represents a node in the network
 type Peer struct {
 	ID            string    // Unique identifier for the peer
@@ -17,6 +34,7 @@ type Peer struct {
 // Manager handles peer connections and state
 type Manager struct {
 	peers   map[string]*Peer // map[peerID]Peer
+	stats   map[string]*ChunkStats
 	timeout time.Duration
 	mu      sync.RWMutex
 }
@@ -25,6 +43,7 @@ type Manager struct {
 func NewManager(timeoutSecs int64) *Manager {
 	return &Manager{
 		peers:   make(map[string]*Peer),
+		stats:   make(map[string]*ChunkStats),
 		timeout: time.Duration(timeoutSecs) * time.Second,
 	}
 }
@@ -127,3 +146,49 @@ func (m *Manager) GetPeersWithZap(zapID string) []*Peer {
 	}
 	return peers
 }
+
+// RecordChunkSuccess records a chunk from a peer that verified
+func (m *Manager) RecordChunkSuccess(id string) {
+	m.recordChunk(id, true)
+}
+
+// RecordChunkFailure records a chunk from a peer that failed to transfer or
+// did not match its hash
+func (m *Manager) RecordChunkFailure(id string) {
+	m.recordChunk(id, false)
+}
+
+func (m *Manager) recordChunk(id string, valid bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, exists := m.stats[id]
+	if !exists {
+		stats = &ChunkStats{}
+		m.stats[id] = stats
+	}
+	if valid {
+		stats.Served++
+		stats.Reputation += chunkReward
+	} else {
+		stats.Failed++
+		stats.Reputation += chunkPenalty
+	}
+	if stats.Reputation > MaxReputation {
+		stats.Reputation = MaxReputation
+	}
+	if stats.Reputation < MinReputation {
+		stats.Reputation = MinReputation
+	}
+}
+
+// GetChunkStats returns the chunk statistics of a peer
+func (m *Manager) GetChunkStats(id string) ChunkStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if stats, exists := m.stats[id]; exists {
+		return *stats
+	}
+	return ChunkStats{}
+}
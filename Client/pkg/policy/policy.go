@@ -0,0 +1,239 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Validators let each client download a free allowance of bytes per period
+// before its downloads are charged. A download is free up to what is left
+// of the allowance and charged for the rest. Periods are fixed windows of
+// Period from the Unix epoch, and usage starts afresh with each.
+const (
+	// DefaultPeriod is the allowance period unless set otherwise
+	DefaultPeriod = 30 * 24 * time.Hour
+
+	// UsageFile is the file in the data directory holding client usage
+	UsageFile = "usage.json"
+)
+
+// Policy is what a validator gives away
+type Policy struct {
+	FreeBytes int64         `json:"free_bytes"` // Free to each client per period, zero for none
+	Period    time.Duration `json:"period"`
+}
+
+// Usage is a client's downloads in the current period
+type Usage struct {
+	ClientID    string `json:"client_id"`
+	PeriodStart int64  `json:"period_start"` // Unix time
+	PeriodEnd   int64  `json:"period_end"`
+	Bytes       int64  `json:"bytes"`      // Downloaded this period
+	FreeBytes   int64  `json:"free_bytes"` // Allowance per period
+	FreeLeft    int64  `json:"free_left"`
+}
+
+// Grant is how a download splits between the allowance and charges
+type Grant struct {
+	Free    int64 `json:"free"`    // Bytes taken from the allowance
+	Charged int64 `json:"charged"` // Bytes to charge for
+	Period  int64 `json:"period"`  // Start of the period the download counted against
+}
+
+// record is a client's usage as saved to disk
+type record struct {
+	PeriodStart int64 `json:"period_start"`
+	Bytes       int64 `json:"bytes"`
+	Free        int64 `json:"free"` // Part of Bytes the allowance covered
+}
+
+// Engine applies a policy and tracks usage per client ID
+type Engine struct {
+	policy Policy
+	usage  map[string]*record
+	path   string // Empty if usage is not saved
+	mu     sync.Mutex
+}
+
+// New creates an engine applying policy
+func New(policy Policy) *Engine {
+	e := &Engine{usage: make(map[string]*record)}
+	e.SetPolicy(policy)
+	return e
+}
+
+// SetPolicy changes the policy. Usage so far counts against the new
+// allowance. A period under a second is DefaultPeriod.
+func (e *Engine) SetPolicy(policy Policy) {
+	if policy.Period < time.Second {
+		policy.Period = DefaultPeriod
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policy = policy
+}
+
+// Policy returns the policy applied
+func (e *Engine) Policy() Policy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.policy
+}
+
+// EnablePersistence saves usage to dir whenever it changes, and restores
+// the usage saved by a previous run
+func (e *Engine) EnablePersistence(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create usage directory: %v", err)
+	}
+	path := filepath.Join(dir, UsageFile)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read usage: %v", err)
+	}
+	var usage map[string]*record
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return fmt.Errorf("failed to parse usage: %v", err)
+	}
+	for clientID, r := range usage {
+		if _, exists := e.usage[clientID]; !exists {
+			e.usage[clientID] = r
+		}
+	}
+	return nil
+}
+
+// periodLocked returns the start of the period containing t. Callers must
+// hold e.mu.
+func (e *Engine) periodLocked(t time.Time) int64 {
+	secs := int64(e.policy.Period / time.Second)
+	return t.Unix() / secs * secs
+}
+
+// recordLocked returns a client's usage in the period starting at period,
+// nil if the client has none. Callers must hold e.mu.
+func (e *Engine) recordLocked(clientID string, period int64) *record {
+	r := e.usage[clientID]
+	if r == nil || r.PeriodStart != period {
+		return nil
+	}
+	return r
+}
+
+// Use counts a download of size bytes against a client's allowance,
+// returning how much of it is free and how much to charge for
+func (e *Engine) Use(clientID string, size int64) Grant {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	period := e.periodLocked(time.Now())
+	r := e.recordLocked(clientID, period)
+	if r == nil {
+		r = &record{PeriodStart: period}
+		e.usage[clientID] = r
+	}
+
+	grant := Grant{Period: period}
+	if left := e.policy.FreeBytes - r.Free; left > 0 {
+		grant.Free = min(size, left)
+	}
+	grant.Charged = size - grant.Free
+	r.Bytes += size
+	r.Free += grant.Free
+	e.saveLocked()
+	return grant
+}
+
+// Cancel takes back a download counted by Use, returning the allowance it
+// took
+func (e *Engine) Cancel(clientID string, grant Grant) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r := e.recordLocked(clientID, grant.Period)
+	if r == nil {
+		return
+	}
+	r.Bytes = max(r.Bytes-grant.Free-grant.Charged, 0)
+	r.Free = max(r.Free-grant.Free, 0)
+	e.saveLocked()
+}
+
+// Record counts a download granted by another validator, so clients do not
+// get an allowance from each. Only downloads in the current period are kept.
+func (e *Engine) Record(clientID string, grant Grant) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if grant.Period != e.periodLocked(time.Now()) {
+		return
+	}
+	r := e.recordLocked(clientID, grant.Period)
+	if r == nil {
+		r = &record{PeriodStart: grant.Period}
+		e.usage[clientID] = r
+	}
+	r.Bytes += grant.Free + grant.Charged
+	r.Free += grant.Free
+	e.saveLocked()
+}
+
+// Usage returns a client's usage in the current period
+func (e *Engine) Usage(clientID string) *Usage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	period := e.periodLocked(time.Now())
+	usage := &Usage{
+		ClientID:    clientID,
+		PeriodStart: period,
+		PeriodEnd:   period + int64(e.policy.Period/time.Second),
+		FreeBytes:   e.policy.FreeBytes,
+		FreeLeft:    e.policy.FreeBytes,
+	}
+	if r := e.recordLocked(clientID, period); r != nil {
+		usage.Bytes = r.Bytes
+		usage.FreeLeft = max(e.policy.FreeBytes-r.Free, 0)
+	}
+	return usage
+}
+
+// saveLocked writes usage in the current period to disk, dropping that of
+// earlier periods. Callers must hold e.mu.
+func (e *Engine) saveLocked() {
+	period := e.periodLocked(time.Now())
+	for clientID, r := range e.usage {
+		if r.PeriodStart != period {
+			delete(e.usage, clientID)
+		}
+	}
+	if e.path == "" {
+		return
+	}
+
+	data, err := json.Marshal(e.usage)
+	if err != nil {
+		return
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Failed to save usage: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		log.Printf("Failed to save usage: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowance(t *testing.T) {
+	e := New(Policy{FreeBytes: 100})
+	assert.Equal(t, DefaultPeriod, e.Policy().Period)
+
+	// Downloads are free until the allowance runs out, then charged
+	assert.Equal(t, int64(60), e.Use("client1", 60).Free)
+	grant := e.Use("client1", 60)
+	assert.Equal(t, int64(40), grant.Free)
+	assert.Equal(t, int64(20), grant.Charged)
+	assert.Equal(t, int64(50), e.Use("client1", 50).Charged)
+
+	usage := e.Usage("client1")
+	assert.Equal(t, int64(170), usage.Bytes)
+	assert.Equal(t, int64(0), usage.FreeLeft)
+	assert.Equal(t, int64(DefaultPeriod/time.Second), usage.PeriodEnd-usage.PeriodStart)
+
+	// Allowances are per client
+	assert.Equal(t, int64(100), e.Usage("client2").FreeLeft)
+
+	// A cancelled download gives its allowance back
+	e.Cancel("client1", grant)
+	assert.Equal(t, int64(40), e.Usage("client1").FreeLeft)
+	assert.Equal(t, int64(110), e.Usage("client1").Bytes)
+
+	// Downloads counted elsewhere take from the same allowance
+	e.Record("client2", Grant{Free: 30, Period: usage.PeriodStart})
+	assert.Equal(t, int64(70), e.Usage("client2").FreeLeft)
+	e.Record("client2", Grant{Free: 30, Period: usage.PeriodStart - 1})
+	assert.Equal(t, int64(70), e.Usage("client2").FreeLeft)
+}
+
+func TestNoAllowance(t *testing.T) {
+	e := New(Policy{})
+	grant := e.Use("client1", 10)
+	assert.Equal(t, int64(0), grant.Free)
+	assert.Equal(t, int64(10), grant.Charged)
+	assert.Equal(t, int64(10), e.Usage("client1").Bytes)
+}
+
+func TestUsagePersistence(t *testing.T) {
+	dir := t.TempDir()
+	e := New(Policy{FreeBytes: 100})
+	require.NoError(t, e.EnablePersistence(dir))
+	e.Use("client1", 30)
+
+	restored := New(Policy{FreeBytes: 100})
+	require.NoError(t, restored.EnablePersistence(dir))
+	assert.Equal(t, int64(70), restored.Usage("client1").FreeLeft)
+	assert.Equal(t, int64(30), restored.Usage("client1").Bytes)
+}
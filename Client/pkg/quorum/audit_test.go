@@ -0,0 +1,80 @@
+package quorum
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogRecordsVoting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AuditFile)
+	audit, err := OpenAuditLog(path)
+	require.NoError(t, err)
+
+	qm := NewQuorumManager(300, 2)
+	qm.SetAuditLog(audit)
+	qm.RegisterValidator("v1")
+	qm.RegisterValidator("v2")
+
+	require.NoError(t, qm.CreateVoteSession("file1", "client"))
+	require.NoError(t, qm.SubmitVote("file1", "client", "v1", true))
+	require.NoError(t, qm.SubmitVote("file1", "client", "v2", false))
+	qm.RecordDecision("file1", "client", SessionDenied)
+	require.NoError(t, qm.CreateVoteSession("file2", "client"))
+
+	entries, total := audit.Entries("", "", types.ListOptions{})
+	require.Equal(t, 5, total)
+	require.NoError(t, VerifyAuditChain(entries))
+	assert.Equal(t, AuditDecision, entries[3].Kind)
+	assert.Equal(t, 1, entries[3].Approvals)
+	assert.Equal(t, 1, entries[3].Rejections)
+
+	files, total := audit.Entries("file1", "", types.ListOptions{})
+	assert.Equal(t, 4, total)
+	assert.NoError(t, VerifyAuditChain(files))
+	require.NoError(t, audit.Close())
+
+	// The chain continues after a restart
+	audit, err = OpenAuditLog(path)
+	require.NoError(t, err)
+	defer audit.Close()
+	entry, err := audit.Append(AuditEntry{Kind: AuditSession, FileID: "file3", ClientID: "client"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), entry.Seq)
+	entries, _ = audit.Entries("", "", types.ListOptions{})
+	assert.NoError(t, VerifyAuditChain(entries))
+}
+
+func TestAuditChainDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AuditFile)
+	audit, err := OpenAuditLog(path)
+	require.NoError(t, err)
+	for _, validator := range []string{"v1", "v2", "v3"} {
+		_, err := audit.Append(AuditEntry{Kind: AuditVote, FileID: "file1", ClientID: "client", ValidatorID: validator, Approved: true})
+		require.NoError(t, err)
+	}
+	entries, _ := audit.Entries("", "", types.ListOptions{})
+	require.NoError(t, audit.Close())
+
+	// Changing a ballot breaks its hash
+	forged := *entries[1]
+	forged.Approved = false
+	assert.Error(t, VerifyAuditChain([]*AuditEntry{entries[0], &forged, entries[2]}))
+
+	// Rehashing it breaks the link to the next entry
+	forged.Hash, err = forged.computeHash()
+	require.NoError(t, err)
+	assert.Error(t, VerifyAuditChain([]*AuditEntry{entries[0], &forged, entries[2]}))
+
+	// A rewritten log fails to open
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data = []byte(string(data[:len(data)/2]) + "x" + string(data[len(data)/2+1:]))
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	_, err = OpenAuditLog(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package quorum
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// With committees enabled a session is decided by a committee of validators
+// rather than by all of them. The committee is drawn when the session opens,
+// from the hash of the file ID and the current epoch with each validator's
+// ID: the validators with the lowest hashes sit on it. Every validator
+// computes the same committee without coordinating, committees differ from
+// file to file, and they rotate every epoch. Validators joining or leaving
+// move few seats.
+
+// DefaultEpoch is how long committees sit unless set otherwise
+const DefaultEpoch = time.Hour
+
+// SetCommittees has sessions opened from now on decided by committees of
+// size validators, drawn afresh every epoch, or every DefaultEpoch if epoch
+// is under a second. A size of zero turns committees off.
+func (qm *QuorumManager) SetCommittees(size int, epoch time.Duration) {
+	if epoch < time.Second {
+		epoch = DefaultEpoch
+	}
+
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.committeeSize = size
+	qm.epochSecs = int64(epoch / time.Second)
+}
+
+// Epoch returns the current committee epoch, zero if committees are off
+func (qm *QuorumManager) Epoch() int64 {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	if qm.committeeSize == 0 {
+		return 0
+	}
+	return time.Now().Unix() / qm.epochSecs
+}
+
+// drawCommitteeLocked sets the committee of a new session and caps its
+// required votes at the committee's size. Callers must hold qm.mu.
+func (qm *QuorumManager) drawCommitteeLocked(session *VoteSession) {
+	if qm.committeeSize == 0 {
+		return
+	}
+	validators := make([]string, 0, len(qm.validators))
+	for id := range qm.validators {
+		validators = append(validators, id)
+	}
+	session.Committee = SelectCommittee(validators, session.FileID, session.StartTime/qm.epochSecs, qm.committeeSize)
+	if session.RequiredVotes > len(session.Committee) {
+		session.RequiredVotes = len(session.Committee)
+	}
+}
+
+// SelectCommittee returns the size validators that decide sessions on a
+// file during an epoch, sorted, or all of them if there are no more than
+// size
+func SelectCommittee(validators []string, fileID string, epoch int64, size int) []string {
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], uint64(epoch))
+
+	type seat struct {
+		id   string
+		rank [sha256.Size]byte
+	}
+	seats := make([]seat, len(validators))
+	for i, id := range validators {
+		h := sha256.New()
+		h.Write([]byte(fileID))
+		h.Write(seed[:])
+		h.Write([]byte(id))
+		seats[i].id = id
+		copy(seats[i].rank[:], h.Sum(nil))
+	}
+	sort.Slice(seats, func(i, j int) bool {
+		return string(seats[i].rank[:]) < string(seats[j].rank[:])
+	})
+	if len(seats) > size {
+		seats = seats[:size]
+	}
+
+	committee := make([]string, len(seats))
+	for i, s := range seats {
+		committee[i] = s.id
+	}
+	sort.Strings(committee)
+	return committee
+}
+
+// OnCommittee reports whether a validator may vote in a session. Sessions
+// opened without a committee take votes from every validator.
+func (s *VoteSession) OnCommittee(validatorID string) bool {
+	if s.Committee == nil {
+		return true
+	}
+	for _, id := range s.Committee {
+		if id == validatorID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package quorum
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectCommittee(t *testing.T) {
+	var validators []string
+	for i := 0; i < 20; i++ {
+		validators = append(validators, fmt.Sprintf("v%d", i))
+	}
+
+	committee := SelectCommittee(validators, "file1", 7, 5)
+	require.Len(t, committee, 5)
+
+	// Every validator draws the same committee, whatever order it knows
+	// the validators in
+	reversed := make([]string, len(validators))
+	for i, id := range validators {
+		reversed[len(validators)-1-i] = id
+	}
+	assert.Equal(t, committee, SelectCommittee(reversed, "file1", 7, 5))
+
+	// Committees rotate between epochs and differ between files
+	rotated, differs := false, false
+	for epoch := int64(8); epoch < 18; epoch++ {
+		if fmt.Sprint(SelectCommittee(validators, "file1", epoch, 5)) != fmt.Sprint(committee) {
+			rotated = true
+		}
+		if fmt.Sprint(SelectCommittee(validators, fmt.Sprintf("file%d", epoch), 7, 5)) != fmt.Sprint(committee) {
+			differs = true
+		}
+	}
+	assert.True(t, rotated)
+	assert.True(t, differs)
+
+	// A validator leaving frees at most its own seat
+	var remaining []string
+	for _, id := range validators {
+		if id != committee[0] {
+			remaining = append(remaining, id)
+		}
+	}
+	kept := 0
+	for _, id := range SelectCommittee(remaining, "file1", 7, 5) {
+		for _, seated := range committee {
+			if id == seated {
+				kept++
+			}
+		}
+	}
+	assert.Equal(t, 4, kept)
+
+	assert.Len(t, SelectCommittee(validators[:3], "file1", 7, 5), 3)
+}
+
+func TestCommitteeDecidesSession(t *testing.T) {
+	qm := NewQuorumManager(300, 3)
+	var validators []string
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("v%d", i)
+		validators = append(validators, id)
+		qm.RegisterValidator(id)
+	}
+	qm.SetCommittees(4, time.Hour)
+	assert.Equal(t, time.Now().Unix()/3600, qm.Epoch())
+
+	require.NoError(t, qm.CreateVoteSession("file1", "client"))
+	session, err := qm.GetVoteSession("file1", "client")
+	require.NoError(t, err)
+	require.Len(t, session.Committee, 4)
+	assert.Equal(t, SelectCommittee(validators, "file1", session.StartTime/3600, 4), session.Committee)
+
+	// Only the committee votes
+	for _, id := range validators {
+		if !session.OnCommittee(id) {
+			assert.Error(t, qm.SubmitVote("file1", "client", id, true))
+		}
+	}
+	for _, id := range session.Committee[:2] {
+		require.NoError(t, qm.SubmitVote("file1", "client", id, false))
+	}
+
+	// Two of four rejecting leaves too few to approve, however many
+	// validators sit outside the committee
+	status, err := qm.SessionStatus("file1", "client")
+	require.NoError(t, err)
+	assert.Equal(t, SessionDenied, status)
+
+	// Committees smaller than the required votes need all their members
+	qm.SetCommittees(2, time.Hour)
+	require.NoError(t, qm.CreateVoteSession("file2", "client"))
+	session, err = qm.GetVoteSession("file2", "client")
+	require.NoError(t, err)
+	assert.Equal(t, 2, session.RequiredVotes)
+	for _, id := range session.Committee {
+		require.NoError(t, qm.SubmitVote("file2", "client", id, true))
+	}
+	approved, err := qm.CheckQuorum("file2", "client")
+	require.NoError(t, err)
+	assert.True(t, approved)
+
+	// Sessions keep their committee across restarts
+	dir := t.TempDir()
+	require.NoError(t, qm.EnablePersistence(dir))
+	qm.save()
+	restarted := NewQuorumManager(300, 3)
+	require.NoError(t, restarted.EnablePersistence(dir))
+	restored, err := restarted.GetVoteSession("file2", "client")
+	require.NoError(t, err)
+	assert.Equal(t, session.Committee, restored.Committee)
+}
@@ -0,0 +1,122 @@
+package quorum
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// SessionsFile is the file in the data directory holding vote sessions
+const SessionsFile = "vote_sessions.json"
+
+// sessionRecord is a vote session as saved to disk
+type sessionRecord struct {
+	FileID        string   `json:"file_id"`
+	ClientID      string   `json:"client_id"`
+	Votes         []Vote   `json:"votes"`
+	StartTime     int64    `json:"start_time"`
+	TimeoutSecs   int64    `json:"timeout_secs"`
+	RequiredVotes int      `json:"required_votes"`
+	Committee     []string `json:"committee,omitempty"`
+}
+
+// EnablePersistence saves vote sessions and the votes cast in them to dir
+// whenever they change, and restores the sessions saved by a previous run.
+// Sessions that expired while the node was down are restored too, so
+// SessionStatus reports them expired until they are cleaned up and their
+// requests can be settled.
+func (qm *QuorumManager) EnablePersistence(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create vote session directory: %v", err)
+	}
+	path := filepath.Join(dir, SessionsFile)
+
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.sessionsPath = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read vote sessions: %v", err)
+	}
+
+	var records []sessionRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to parse vote sessions: %v", err)
+	}
+	for _, record := range records {
+		sessionKey := fmt.Sprintf("%s:%s", record.FileID, record.ClientID)
+		if _, exists := qm.sessions[sessionKey]; exists {
+			continue
+		}
+		session := &VoteSession{
+			FileID:        record.FileID,
+			ClientID:      record.ClientID,
+			Votes:         make(map[string]Vote),
+			StartTime:     record.StartTime,
+			TimeoutSecs:   record.TimeoutSecs,
+			RequiredVotes: record.RequiredVotes,
+			Committee:     record.Committee,
+		}
+		approvals := 0
+		for _, vote := range record.Votes {
+			session.Votes[vote.ValidatorID] = vote
+			if vote.Approved {
+				approvals++
+			}
+		}
+		session.pending = approvals < session.RequiredVotes
+		qm.sessions[sessionKey] = session
+	}
+	return nil
+}
+
+// save writes the sessions to disk, if persistence is enabled
+func (qm *QuorumManager) save() {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.saveLocked()
+}
+
+// saveLocked writes the sessions to disk. Callers must hold qm.mu.
+func (qm *QuorumManager) saveLocked() {
+	if qm.sessionsPath == "" {
+		return
+	}
+
+	records := make([]sessionRecord, 0, len(qm.sessions))
+	for _, session := range qm.sessions {
+		session.mu.RLock()
+		record := sessionRecord{
+			FileID:        session.FileID,
+			ClientID:      session.ClientID,
+			StartTime:     session.StartTime,
+			TimeoutSecs:   session.TimeoutSecs,
+			RequiredVotes: session.RequiredVotes,
+			Committee:     session.Committee,
+		}
+		for _, vote := range session.Votes {
+			record.Votes = append(record.Votes, vote)
+		}
+		session.mu.RUnlock()
+		records = append(records, record)
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return
+	}
+	tmp := qm.sessionsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Failed to save vote sessions: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, qm.sessionsPath); err != nil {
+		log.Printf("Failed to save vote sessions: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package quorum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	qm := NewQuorumManager(300, 2)
+	require.NoError(t, qm.EnablePersistence(dir))
+	qm.RegisterValidator("v1")
+	qm.RegisterValidator("v2")
+
+	require.NoError(t, qm.CreateVoteSession("file1", "client"))
+	require.NoError(t, qm.SubmitVote("file1", "client", "v1", true))
+	require.NoError(t, qm.CreateVoteSession("file2", "client"))
+	require.NoError(t, qm.SubmitVote("file2", "client", "v1", true))
+	require.NoError(t, qm.SubmitVote("file2", "client", "v2", true))
+	started, err := qm.GetVoteSession("file1", "client")
+	require.NoError(t, err)
+
+	// Sessions and their votes are saved as they change, without a
+	// shutdown
+	restarted := NewQuorumManager(300, 2)
+	require.NoError(t, restarted.EnablePersistence(dir))
+	session, err := restarted.GetVoteSession("file1", "client")
+	require.NoError(t, err)
+	assert.Equal(t, started.StartTime, session.StartTime)
+	require.Len(t, session.GetVotes(), 1)
+	assert.Equal(t, "v1", session.GetVotes()[0].ValidatorID)
+
+	// Decided sessions are no longer pending
+	pending := restarted.GetPendingSessions()
+	require.Len(t, pending, 1)
+	assert.Equal(t, "file1", pending[0].FileID)
+	status, err := restarted.SessionStatus("file2", "client")
+	require.NoError(t, err)
+	assert.Equal(t, SessionApproved, status)
+}
+
+func TestExpiredSessionsRestored(t *testing.T) {
+	dir := t.TempDir()
+	qm := NewQuorumManager(1, 2)
+	require.NoError(t, qm.EnablePersistence(dir))
+	qm.RegisterValidator("v1")
+	qm.RegisterValidator("v2")
+	require.NoError(t, qm.CreateVoteSession("file1", "client"))
+
+	// A session that expired while the node was down is reported expired,
+	// so its request can be settled
+	session, err := qm.GetVoteSession("file1", "client")
+	require.NoError(t, err)
+	session.StartTime = time.Now().Unix() - 10
+	qm.save()
+
+	restarted := NewQuorumManager(300, 2)
+	require.NoError(t, restarted.EnablePersistence(dir))
+	restarted.RegisterValidator("v1")
+	restarted.RegisterValidator("v2")
+	status, err := restarted.SessionStatus("file1", "client")
+	require.NoError(t, err)
+	assert.Equal(t, SessionExpired, status)
+	assert.Empty(t, restarted.GetPendingSessions())
+}
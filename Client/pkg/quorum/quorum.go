@@ -2,6 +2,7 @@ package quorum
 
 import (
 	"fmt"
+	"log"
 	"sync"
 	"time"
 )
@@ -13,6 +14,14 @@ type Vote struct {
 	Timestamp   int64
 }
 
+// Voting session outcomes
+const (
+	SessionPending  = "pending"
+	SessionApproved = "approved"
+	SessionDenied   = "denied"
+	SessionExpired  = "expired"
+)
+
 // VoteSession represents an active voting session for a key request
 type VoteSession struct {
 	FileID        string
@@ -21,6 +30,7 @@ type VoteSession struct {
 	StartTime     int64
 	TimeoutSecs   int64
 	RequiredVotes int
+	Committee     []string // Validators deciding the session, nil for all of them
 	mu            sync.RWMutex
 	pending       bool
 }
@@ -31,6 +41,11 @@ type QuorumManager struct {
 	validators    map[string]bool         // map[validatorID]isActive
 	voteTimeout   int64                   // seconds
 	requiredVotes int
+	audit         *AuditLog                      // Optional record of sessions, votes and decisions
+	sessionsPath  string                         // Where sessions are saved, empty if they are not
+	stake         func(validatorID string) int64 // Vote weights, nil for one vote each
+	committeeSize int                            // Validators deciding each session, zero for all
+	epochSecs     int64                          // How long a committee sits
 	mu            sync.RWMutex
 }
 
@@ -46,6 +61,27 @@ func NewQuorumManager(voteTimeoutSecs, requiredVotes int64) *QuorumManager {
 	return qm
 }
 
+// SetAuditLog makes the manager record voting activity in an audit log
+func (qm *QuorumManager) SetAuditLog(audit *AuditLog) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.audit = audit
+}
+
+// record appends an entry to the audit log, if one is set
+func (qm *QuorumManager) record(entry AuditEntry) {
+	qm.mu.RLock()
+	audit := qm.audit
+	qm.mu.RUnlock()
+
+	if audit == nil {
+		return
+	}
+	if _, err := audit.Append(entry); err != nil {
+		log.Printf("Failed to audit %s for %s:%s: %v", entry.Kind, entry.FileID, entry.ClientID, err)
+	}
+}
+
 // RegisterValidator adds a validator to the quorum
 func (qm *QuorumManager) RegisterValidator(validatorID string) {
 	qm.mu.Lock()
@@ -60,13 +96,32 @@ func (qm *QuorumManager) RemoveValidator(validatorID string) {
 	delete(qm.validators, validatorID)
 }
 
+// IsValidator reports whether a validator is part of the quorum
+func (qm *QuorumManager) IsValidator(validatorID string) bool {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	return qm.validators[validatorID]
+}
+
+// Validators returns the IDs of all validators in the quorum
+func (qm *QuorumManager) Validators() []string {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+
+	validators := make([]string, 0, len(qm.validators))
+	for id := range qm.validators {
+		validators = append(validators, id)
+	}
+	return validators
+}
+
 // CreateVoteSession starts a new voting session for a key request
 func (qm *QuorumManager) CreateVoteSession(fileID, clientID string) error {
 	qm.mu.Lock()
-	defer qm.mu.Unlock()
 
 	sessionKey := fmt.Sprintf("%s:%s", fileID, clientID)
 	if _, exists := qm.sessions[sessionKey]; exists {
+		qm.mu.Unlock()
 		return fmt.Errorf("vote session already exists")
 	}
 
@@ -79,8 +134,46 @@ func (qm *QuorumManager) CreateVoteSession(fileID, clientID string) error {
 		RequiredVotes: qm.requiredVotes,
 		pending:       true,
 	}
+	qm.drawCommitteeLocked(session)
 
 	qm.sessions[sessionKey] = session
+	qm.saveLocked()
+	qm.mu.Unlock()
+
+	qm.record(AuditEntry{Kind: AuditSession, FileID: fileID, ClientID: clientID, Time: session.StartTime})
+	return nil
+}
+
+// RestoreVoteSession recreates a session saved by an earlier run, keeping
+// its start time and votes. Sessions that have since expired are dropped.
+func (qm *QuorumManager) RestoreVoteSession(fileID, clientID string, startTime int64, votes []Vote) error {
+	if time.Now().Unix() > startTime+qm.voteTimeout {
+		return fmt.Errorf("vote session has expired")
+	}
+
+	session := &VoteSession{
+		FileID:        fileID,
+		ClientID:      clientID,
+		Votes:         make(map[string]Vote),
+		StartTime:     startTime,
+		TimeoutSecs:   qm.voteTimeout,
+		RequiredVotes: qm.requiredVotes,
+		pending:       true,
+	}
+	for _, vote := range votes {
+		session.Votes[vote.ValidatorID] = vote
+	}
+
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	sessionKey := fmt.Sprintf("%s:%s", fileID, clientID)
+	if _, exists := qm.sessions[sessionKey]; exists {
+		return fmt.Errorf("vote session already exists")
+	}
+	qm.drawCommitteeLocked(session)
+	qm.sessions[sessionKey] = session
+	qm.saveLocked()
 	return nil
 }
 
@@ -91,6 +184,10 @@ func (qm *QuorumManager) SubmitVote(fileID, clientID, validatorID string, approv
 		qm.mu.RUnlock()
 		return fmt.Errorf("invalid validator")
 	}
+	if qm.stake != nil && qm.stake(validatorID) <= 0 {
+		qm.mu.RUnlock()
+		return fmt.Errorf("validator has no stake")
+	}
 	qm.mu.RUnlock()
 
 	sessionKey := fmt.Sprintf("%s:%s", fileID, clientID)
@@ -102,25 +199,55 @@ func (qm *QuorumManager) SubmitVote(fileID, clientID, validatorID string, approv
 	if !exists {
 		return fmt.Errorf("vote session not found")
 	}
+	if !session.OnCommittee(validatorID) {
+		return fmt.Errorf("validator is not on the session's committee")
+	}
 
 	session.mu.Lock()
-	defer session.mu.Unlock()
 
 	// Check if session has expired
 	if time.Now().Unix() > session.StartTime+session.TimeoutSecs {
+		session.mu.Unlock()
 		return fmt.Errorf("vote session has expired")
 	}
 
 	// Record the vote
-	session.Votes[validatorID] = Vote{
+	vote := Vote{
 		ValidatorID: validatorID,
 		Approved:    approved,
 		Timestamp:   time.Now().Unix(),
 	}
-
+	session.Votes[validatorID] = vote
+	session.mu.Unlock()
+	qm.save()
+
+	qm.record(AuditEntry{
+		Kind:        AuditVote,
+		FileID:      fileID,
+		ClientID:    clientID,
+		ValidatorID: validatorID,
+		Approved:    approved,
+		Time:        vote.Timestamp,
+	})
 	return nil
 }
 
+// RecordDecision audits the outcome of a voting session along with its
+// final tally
+func (qm *QuorumManager) RecordDecision(fileID, clientID, outcome string) {
+	entry := AuditEntry{Kind: AuditDecision, FileID: fileID, ClientID: clientID, Outcome: outcome}
+	if session, err := qm.GetVoteSession(fileID, clientID); err == nil {
+		for _, vote := range session.GetVotes() {
+			if vote.Approved {
+				entry.Approvals++
+			} else {
+				entry.Rejections++
+			}
+		}
+	}
+	qm.record(entry)
+}
+
 // CheckQuorum checks if a voting session has reached consensus
 func (qm *QuorumManager) CheckQuorum(fileID, clientID string) (bool, error) {
 	sessionKey := fmt.Sprintf("%s:%s", fileID, clientID)
@@ -132,6 +259,7 @@ func (qm *QuorumManager) CheckQuorum(fileID, clientID string) (bool, error) {
 	if !exists {
 		return false, fmt.Errorf("vote session not found")
 	}
+	weights, validators := qm.weights(session)
 
 	session.mu.RLock()
 	defer session.mu.RUnlock()
@@ -141,22 +269,45 @@ func (qm *QuorumManager) CheckQuorum(fileID, clientID string) (bool, error) {
 		return false, fmt.Errorf("vote session has expired")
 	}
 
-	// Count approved votes
-	approvedCount := 0
-	for _, vote := range session.Votes {
-		if vote.Approved {
-			approvedCount++
-		}
-	}
-
 	// Check if we have enough votes for quorum
-	approved := approvedCount >= session.RequiredVotes
+	approved := countVotes(session.Votes, weights, validators).approved(session.RequiredVotes)
 	if approved {
 		session.pending = false
 	}
 	return approved, nil
 }
 
+// SessionStatus reports the outcome of a voting session. A session is
+// denied once too few validators, or too little stake, remain that have not
+// rejected it to reach the required votes.
+func (qm *QuorumManager) SessionStatus(fileID, clientID string) (string, error) {
+	sessionKey := fmt.Sprintf("%s:%s", fileID, clientID)
+
+	qm.mu.RLock()
+	session, exists := qm.sessions[sessionKey]
+	qm.mu.RUnlock()
+
+	if !exists {
+		return "", fmt.Errorf("vote session not found")
+	}
+	weights, validators := qm.weights(session)
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	t := countVotes(session.Votes, weights, validators)
+	switch {
+	case t.approved(session.RequiredVotes):
+		return SessionApproved, nil
+	case t.denied(session.RequiredVotes):
+		return SessionDenied, nil
+	case time.Now().Unix() > session.StartTime+session.TimeoutSecs:
+		return SessionExpired, nil
+	default:
+		return SessionPending, nil
+	}
+}
+
 // cleanupExpiredSessions periodically removes expired voting sessions
 func (qm *QuorumManager) cleanupExpiredSessions() {
 	ticker := time.NewTicker(5 * time.Minute)
@@ -164,13 +315,18 @@ func (qm *QuorumManager) cleanupExpiredSessions() {
 		qm.mu.Lock()
 		now := time.Now().Unix()
 
+		removed := false
 		for key, session := range qm.sessions {
 			session.mu.RLock()
 			if now > session.StartTime+session.TimeoutSecs {
 				delete(qm.sessions, key)
+				removed = true
 			}
 			session.mu.RUnlock()
 		}
+		if removed {
+			qm.saveLocked()
+		}
 
 		qm.mu.Unlock()
 	}
@@ -210,3 +366,22 @@ func (qm *QuorumManager) GetPendingSessions() []*VoteSession {
 
 	return pending
 }
+
+// GetVotes returns a copy of the votes cast in a session
+func (s *VoteSession) GetVotes() []Vote {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	votes := make([]Vote, 0, len(s.Votes))
+	for _, vote := range s.Votes {
+		votes = append(votes, vote)
+	}
+	return votes
+}
+
+// RequiredVotes returns the number of votes a session needs to be decided
+func (qm *QuorumManager) RequiredVotes() int {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	return qm.requiredVotes
+}
@@ -0,0 +1,100 @@
+package quorum
+
+// By default every validator has one vote and a session is decided by
+// RequiredVotes of them. With stake weights each vote counts for the
+// validator's stake instead, and a session is approved once validators
+// holding the share RequiredVotes/validators of all stake approve it, only
+// validators with stake counting. With equal stakes the two agree.
+
+// SetStakeWeights weighs each validator's vote by the stake that stake
+// reports for it. Validators without stake can no longer vote.
+func (qm *QuorumManager) SetStakeWeights(stake func(validatorID string) int64) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.stake = stake
+}
+
+// StakeWeighted reports whether votes are weighted by stake
+func (qm *QuorumManager) StakeWeighted() bool {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	return qm.stake != nil
+}
+
+// weights returns the vote weight of every validator deciding a session,
+// or nil if votes are not weighted, and the number of those validators.
+// Validators without stake are left out of both when weighted.
+func (qm *QuorumManager) weights(session *VoteSession) (map[string]int64, int) {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+
+	members := session.Committee
+	if members == nil {
+		members = make([]string, 0, len(qm.validators))
+		for id := range qm.validators {
+			members = append(members, id)
+		}
+	}
+	if qm.stake == nil {
+		return nil, len(members)
+	}
+	weights := make(map[string]int64, len(members))
+	for _, id := range members {
+		if stake := qm.stake(id); stake > 0 {
+			weights[id] = stake
+		}
+	}
+	return weights, len(weights)
+}
+
+// tally is the weight of the votes cast in a session
+type tally struct {
+	approvals  int64
+	rejections int64
+	total      int64 // Weight of all validators
+	validators int64
+	weighted   bool
+}
+
+// countVotes tallies votes with the given weights, or one vote each if
+// weights is nil. Votes of validators no longer in the quorum carry no
+// weight when weighted, nor do votes from outside a session's committee.
+func countVotes(votes map[string]Vote, weights map[string]int64, validators int) tally {
+	t := tally{total: int64(validators), validators: int64(validators), weighted: weights != nil}
+	if t.weighted {
+		t.total = 0
+		for _, weight := range weights {
+			t.total += weight
+		}
+	}
+	for id, vote := range votes {
+		weight := int64(1)
+		if t.weighted {
+			weight = weights[id]
+		}
+		if vote.Approved {
+			t.approvals += weight
+		} else {
+			t.rejections += weight
+		}
+	}
+	return t
+}
+
+// approved reports whether a session with the tally reaches the required
+// votes, or their share of all stake
+func (t tally) approved(required int) bool {
+	if !t.weighted {
+		return t.approvals >= int64(required)
+	}
+	return t.total > 0 && t.approvals*t.validators >= int64(required)*t.total
+}
+
+// denied reports whether too few votes, or too little stake, remain that
+// have not rejected a session to approve it
+func (t tally) denied(required int) bool {
+	if !t.weighted {
+		return t.validators-t.rejections < int64(required)
+	}
+	return (t.total-t.rejections)*t.validators < int64(required)*t.total
+}
@@ -0,0 +1,77 @@
+package quorum
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStakeWeightedVotes(t *testing.T) {
+	stakes := map[string]int64{"v1": 60, "v2": 20, "v3": 20}
+	qm := NewQuorumManager(300, 2)
+	for _, id := range []string{"v1", "v2", "v3", "v4"} {
+		qm.RegisterValidator(id)
+	}
+	qm.SetStakeWeights(func(id string) int64 { return stakes[id] })
+	assert.True(t, qm.StakeWeighted())
+
+	// Approval takes two thirds of the stake, whoever holds it
+	require.NoError(t, qm.CreateVoteSession("file1", "client"))
+	require.NoError(t, qm.SubmitVote("file1", "client", "v1", true))
+	status, err := qm.SessionStatus("file1", "client")
+	require.NoError(t, err)
+	assert.Equal(t, SessionPending, status)
+	require.NoError(t, qm.SubmitVote("file1", "client", "v2", true))
+	approved, err := qm.CheckQuorum("file1", "client")
+	require.NoError(t, err)
+	assert.True(t, approved)
+
+	// The largest stake alone can block approval
+	require.NoError(t, qm.CreateVoteSession("file2", "client"))
+	require.NoError(t, qm.SubmitVote("file2", "client", "v1", false))
+	status, err = qm.SessionStatus("file2", "client")
+	require.NoError(t, err)
+	assert.Equal(t, SessionDenied, status)
+
+	// Validators without stake cannot vote
+	assert.Error(t, qm.SubmitVote("file2", "client", "v4", true))
+
+	// Slashed stake weighs less
+	stakes["v1"] = 10
+	require.NoError(t, qm.CreateVoteSession("file3", "client"))
+	require.NoError(t, qm.SubmitVote("file3", "client", "v2", true))
+	require.NoError(t, qm.SubmitVote("file3", "client", "v3", true))
+	approved, err = qm.CheckQuorum("file3", "client")
+	require.NoError(t, err)
+	assert.True(t, approved)
+}
+
+func TestEqualStakesCountVotes(t *testing.T) {
+	for _, weighted := range []bool{false, true} {
+		qm := NewQuorumManager(300, 2)
+		for _, id := range []string{"v1", "v2", "v3"} {
+			qm.RegisterValidator(id)
+		}
+		if weighted {
+			qm.SetStakeWeights(func(string) int64 { return 5 })
+		}
+
+		require.NoError(t, qm.CreateVoteSession("file1", "client"))
+		require.NoError(t, qm.SubmitVote("file1", "client", "v1", true))
+		approved, err := qm.CheckQuorum("file1", "client")
+		require.NoError(t, err)
+		assert.False(t, approved, "weighted %v", weighted)
+		require.NoError(t, qm.SubmitVote("file1", "client", "v2", true))
+		approved, err = qm.CheckQuorum("file1", "client")
+		require.NoError(t, err)
+		assert.True(t, approved, "weighted %v", weighted)
+
+		require.NoError(t, qm.CreateVoteSession("file2", "client"))
+		require.NoError(t, qm.SubmitVote("file2", "client", "v1", false))
+		require.NoError(t, qm.SubmitVote("file2", "client", "v2", false))
+		status, err := qm.SessionStatus("file2", "client")
+		require.NoError(t, err)
+		assert.Equal(t, SessionDenied, status, "weighted %v", weighted)
+	}
+}
@@ -1,10 +1,11 @@
 package registry
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +21,8 @@ type FileInfo struct {
 	TotalSize       int64    `json:"total_size"`
 	ZapMetadata     []byte   `json:"zap_metadata"`
 	ReplicationGoal int      `json:"replication_goal"`
+	// Clients allowed to request the key, nil for everyone
+	ACL *types.AccessList `json:"acl,omitempty"`
 }
 
 // ChunkPeerInfo stores information about peers hosting chunks
@@ -28,6 +31,18 @@ type ChunkPeerInfo struct {
 	LastSeen int64               `json:"last_seen"`
 }
 
+// ErrResyncRequired is returned for a chunk announcement that does not
+// follow on from the registry's view of the peer. The peer should announce
+// its full chunk list.
+var ErrResyncRequired = errors.New("peer chunk set out of sync")
+
+// peerState tracks the chunk announcements of a peer
+type peerState struct {
+	Seq      uint64              `json:"seq"` // Last announcement applied
+	LastSeen int64               `json:"last_seen"`
+	chunks   map[string]struct{} // Rebuilt from peerChunks on load
+}
+
 // Registry manages .zap file registrations and peer associations
 type Registry struct {
 	files       map[string]*FileInfo // map[fileID]FileInfo
@@ -35,9 +50,14 @@ type Registry struct {
 	dataDir     string
 	mu          sync.RWMutex
 	peerChunks  map[string]map[string]*ChunkPeerInfo // map[chunkID]map[peerID]ChunkPeerInfo
+	peers       map[string]*peerState                // map[peerID]peerState
+	blacklist   map[string]int64                     // map[fileID]time removed
+	log         *os.File                             // Changes since the last snapshot
+	logEntries  int
 }
 
-// NewRegistry creates a new .zap file registry
+// NewRegistry creates a new .zap file registry, restoring any state stored
+// in dataDir. Close it to compact the stored state.
 func NewRegistry(dataDir string) (*Registry, error) {
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %v", err)
@@ -48,6 +68,8 @@ func NewRegistry(dataDir string) (*Registry, error) {
 		filesByName: make(map[string]*FileInfo),
 		dataDir:     dataDir,
 		peerChunks:  make(map[string]map[string]*ChunkPeerInfo),
+		peers:       make(map[string]*peerState),
+		blacklist:   make(map[string]int64),
 	}
 
 	// Load existing registry data
@@ -58,14 +80,36 @@ func NewRegistry(dataDir string) (*Registry, error) {
 	return r, nil
 }
 
-// RegisterFile adds or updates a .zap file registration
+// RegisterFile adds or updates a .zap file registration. Blacklisted files
+// cannot be registered again.
 func (r *Registry) RegisterFile(file *FileInfo) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.files[file.ID] = file
-	r.filesByName[file.Name] = file
-	return r.saveRegistry()
+	if _, blacklisted := r.blacklist[file.ID]; blacklisted {
+		return fmt.Errorf("file is blacklisted: %s", file.ID)
+	}
+	return r.commit(&logEntry{Op: opRegisterFile, File: file})
+}
+
+// RemoveFile drops a file's registration and blacklists its ID
+func (r *Registry) RemoveFile(fileID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, blacklisted := r.blacklist[fileID]; blacklisted {
+		return nil
+	}
+	return r.commit(&logEntry{Op: opRemoveFile, FileID: fileID})
+}
+
+// IsBlacklisted reports whether a file was removed from the network
+func (r *Registry) IsBlacklisted(fileID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, blacklisted := r.blacklist[fileID]
+	return blacklisted
 }
 
 // RegisterPeerChunks registers which chunks a peer has available
@@ -73,29 +117,135 @@ func (r *Registry) RegisterPeerChunks(peerID string, address string, chunkIDs []
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// Create ChunkPeerInfo with types.PeerChunkInfo
-	info := &ChunkPeerInfo{
-		Info: types.PeerChunkInfo{
-			PeerID:    peerID,
-			Address:   address,
-			ChunkIDs:  chunkIDs,
-			Available: true,
-		},
-		LastSeen: time.Now().Unix(),
+	entry := &logEntry{
+		Op:       opPeerChunks,
+		PeerID:   peerID,
+		Address:  address,
+		ChunkIDs: chunkIDs,
+	}
+	if err := r.commit(entry); err != nil {
+		fmt.Printf("failed to save registry: %v\n", err)
+	}
+}
+
+// SyncPeerChunks replaces the chunks a peer hosts with its full list as of
+// announcement seq. Lists older than the peer's last announcement are
+// ignored.
+func (r *Registry) SyncPeerChunks(peerID, address string, seq uint64, chunkIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seq < r.peerSeq(peerID) {
+		return nil
+	}
+	return r.commit(&logEntry{
+		Op:       opSyncChunks,
+		PeerID:   peerID,
+		Address:  address,
+		ChunkIDs: chunkIDs,
+		Seq:      seq,
+	})
+}
+
+// ApplyChunkDelta records the chunks a peer gained and lost. A delta that
+// was already applied is ignored; one that does not apply on top of the
+// peer's last announcement returns ErrResyncRequired.
+func (r *Registry) ApplyChunkDelta(delta *types.ChunkDelta) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if delta.Seq < delta.BaseSeq {
+		return fmt.Errorf("invalid delta: sequence %d precedes base %d", delta.Seq, delta.BaseSeq)
+	}
+	current := r.peerSeq(delta.PeerID)
+	switch {
+	case current == delta.BaseSeq:
+	case current >= delta.Seq:
+		return nil
+	default:
+		return fmt.Errorf("%w: have sequence %d, delta applies to %d", ErrResyncRequired, current, delta.BaseSeq)
+	}
+	return r.commitDelta(delta)
+}
+
+// MergeChunkDelta records the chunks a peer gained and lost even if
+// announcements before it were missed, as when deltas replicated between
+// validators arrive out of order. The peer's next full sync corrects
+// anything missed. Deltas older than the peer's last announcement are
+// ignored.
+func (r *Registry) MergeChunkDelta(delta *types.ChunkDelta) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if delta.Seq < r.peerSeq(delta.PeerID) {
+		return nil
+	}
+	return r.commitDelta(delta)
+}
+
+// commitDelta logs and applies a delta. r.mu must be held.
+func (r *Registry) commitDelta(delta *types.ChunkDelta) error {
+	return r.commit(&logEntry{
+		Op:       opChunkDelta,
+		PeerID:   delta.PeerID,
+		Address:  delta.Address,
+		ChunkIDs: delta.Added,
+		Removed:  delta.Removed,
+		Seq:      delta.Seq,
+	})
+}
+
+// ReconcilePeerChunks checks the registry's view of a peer against a
+// summary of the chunks it hosts. Registered chunks the summary certainly
+// lacks are dropped and returned. ErrResyncRequired is returned when the
+// sequences differ, or when the peer hosts chunks the registry is missing.
+func (r *Registry) ReconcilePeerChunks(summary *types.ChunkSetSummary) ([]string, error) {
+	if err := summary.Filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, exists := r.peers[summary.PeerID]
+	if !exists || state.Seq != summary.Seq {
+		return nil, fmt.Errorf("%w: have sequence %d, summary is of %d", ErrResyncRequired, r.peerSeq(summary.PeerID), summary.Seq)
 	}
 
-	// Update chunk availability mapping
-	for _, chunkID := range chunkIDs {
-		if r.peerChunks[chunkID] == nil {
-			r.peerChunks[chunkID] = make(map[string]*ChunkPeerInfo)
+	var removed []string
+	for chunkID := range state.chunks {
+		if !summary.Filter.Test(chunkID) {
+			removed = append(removed, chunkID)
 		}
-		r.peerChunks[chunkID][peerID] = info
 	}
+	sort.Strings(removed)
 
-	// Save changes
-	if err := r.saveRegistry(); err != nil {
-		fmt.Printf("failed to save registry: %v\n", err)
+	// Logged even when nothing is removed, as it shows the peer is alive
+	entry := &logEntry{Op: opChunkDelta, PeerID: summary.PeerID, Removed: removed, Seq: state.Seq}
+	if err := r.commit(entry); err != nil {
+		return nil, err
+	}
+	if len(state.chunks) != summary.Count {
+		return removed, fmt.Errorf("%w: have %d chunks, peer has %d", ErrResyncRequired, len(state.chunks), summary.Count)
 	}
+	return removed, nil
+}
+
+// PeerSeq returns the last chunk announcement applied for a peer, zero if
+// there was none
+func (r *Registry) PeerSeq(peerID string) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.peerSeq(peerID)
+}
+
+// peerSeq returns a peer's last announcement. r.mu must be held.
+func (r *Registry) peerSeq(peerID string) uint64 {
+	if state, exists := r.peers[peerID]; exists {
+		return state.Seq
+	}
+	return 0
 }
 
 // GetPeersForChunk returns all peers that have a specific chunk
@@ -119,21 +269,7 @@ func (r *Registry) CleanupStaleChunks(maxAge time.Duration) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	now := time.Now().Unix()
-	for chunkID, peerMap := range r.peerChunks {
-		for peerID, info := range peerMap {
-			if now-info.LastSeen > int64(maxAge.Seconds()) {
-				delete(peerMap, peerID)
-			}
-		}
-		// Remove empty chunk entries
-		if len(peerMap) == 0 {
-			delete(r.peerChunks, chunkID)
-		}
-	}
-
-	// Save changes
-	if err := r.saveRegistry(); err != nil {
+	if err := r.commit(&logEntry{Op: opCleanup, MaxAge: int64(maxAge.Seconds())}); err != nil {
 		fmt.Printf("failed to save registry: %v\n", err)
 	}
 }
@@ -173,8 +309,7 @@ func (r *Registry) AddPeerToFile(fileID, peerID string) error {
 		}
 	}
 
-	file.PeerIDs = append(file.PeerIDs, peerID)
-	return r.saveRegistry()
+	return r.commit(&logEntry{Op: opAddPeer, FileID: fileID, PeerID: peerID})
 }
 
 // RemovePeerFromFile removes a peer association from a .zap file
@@ -182,20 +317,23 @@ func (r *Registry) RemovePeerFromFile(fileID, peerID string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	file, exists := r.files[fileID]
-	if !exists {
+	if _, exists := r.files[fileID]; !exists {
 		return fmt.Errorf("file not found: %s", fileID)
 	}
 
-	// Remove peer ID
-	for i, id := range file.PeerIDs {
-		if id == peerID {
-			file.PeerIDs = append(file.PeerIDs[:i], file.PeerIDs[i+1:]...)
-			break
-		}
-	}
+	return r.commit(&logEntry{Op: opRemovePeer, FileID: fileID, PeerID: peerID})
+}
 
-	return r.saveRegistry()
+// Blacklist returns the IDs of all removed files
+func (r *Registry) Blacklist() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.blacklist))
+	for id := range r.blacklist {
+		ids = append(ids, id)
+	}
+	return ids
 }
 
 // GetPeerFiles returns all files associated with a peer
@@ -215,71 +353,111 @@ func (r *Registry) GetPeerFiles(peerID string) []*FileInfo {
 	return files
 }
 
-// saveRegistry persists the registry to disk
-func (r *Registry) saveRegistry() error {
-	data := struct {
-		Files      map[string]*FileInfo                 `json:"files"`
-		PeerChunks map[string]map[string]*ChunkPeerInfo `json:"peer_chunks"`
-	}{
-		Files:      r.files,
-		PeerChunks: r.peerChunks,
-	}
-
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal registry: %v", err)
-	}
+// GetAllFiles returns all registered files
+func (r *Registry) GetAllFiles() []*FileInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	path := filepath.Join(r.dataDir, "registry.json")
-	if err := os.WriteFile(path, jsonData, 0644); err != nil {
-		return fmt.Errorf("failed to save registry: %v", err)
+	files := make([]*FileInfo, 0, len(r.files))
+	for _, file := range r.files {
+		files = append(files, file)
 	}
-
-	return nil
+	return files
 }
 
-// loadRegistry loads the registry from disk
-func (r *Registry) loadRegistry() error {
-	path := filepath.Join(r.dataDir, "registry.json")
-
-	data, err := os.ReadFile(path)
-	if os.IsNotExist(err) {
-		return nil // New registry
-	}
-	if err != nil {
-		return fmt.Errorf("failed to read registry: %v", err)
-	}
+// GetAllPeerChunks returns the available chunks of every peer
+func (r *Registry) GetAllPeerChunks() []types.PeerChunkInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	var loaded struct {
-		Files      map[string]*FileInfo                 `json:"files"`
-		PeerChunks map[string]map[string]*ChunkPeerInfo `json:"peer_chunks"`
+	byPeer := make(map[string]*types.PeerChunkInfo)
+	for chunkID, peerMap := range r.peerChunks {
+		for peerID, info := range peerMap {
+			if !info.Info.Available {
+				continue
+			}
+			peer, exists := byPeer[peerID]
+			if !exists {
+				peer = &types.PeerChunkInfo{PeerID: peerID, Address: info.Info.Address, Available: true}
+				if state, ok := r.peers[peerID]; ok {
+					peer.Seq = state.Seq
+				}
+				byPeer[peerID] = peer
+			}
+			peer.ChunkIDs = append(peer.ChunkIDs, chunkID)
+		}
 	}
 
-	if err := json.Unmarshal(data, &loaded); err != nil {
-		return fmt.Errorf("failed to parse registry: %v", err)
+	peers := make([]types.PeerChunkInfo, 0, len(byPeer))
+	for _, peer := range byPeer {
+		peers = append(peers, *peer)
 	}
+	return peers
+}
 
-	r.files = loaded.Files
-	r.peerChunks = loaded.PeerChunks
-
-	// Rebuild the filesByName index
+// ListFiles returns a page of the files whose names start with opts.Prefix,
+// sorted by opts.Sort ("name", "size" or "chunks"), and the number of
+// matching files
+func (r *Registry) ListFiles(opts types.ListOptions) ([]*FileInfo, int) {
+	r.mu.RLock()
+	files := make([]*FileInfo, 0, len(r.files))
 	for _, file := range r.files {
-		r.filesByName[file.Name] = file
+		if strings.HasPrefix(file.Name, opts.Prefix) {
+			files = append(files, file)
+		}
 	}
+	r.mu.RUnlock()
 
-	return nil
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if opts.Desc {
+			a, b = b, a
+		}
+		switch {
+		case opts.Sort == "size" && a.TotalSize != b.TotalSize:
+			return a.TotalSize < b.TotalSize
+		case opts.Sort == "chunks" && a.ChunkCount != b.ChunkCount:
+			return a.ChunkCount < b.ChunkCount
+		}
+		return a.Name < b.Name
+	})
+
+	start, end, _ := opts.Page(len(files))
+	return files[start:end], len(files)
 }
 
-// GetAllFiles returns all registered files
-func (r *Registry) GetAllFiles() []*FileInfo {
+// ListChunks returns a page of the chunks whose IDs start with opts.Prefix,
+// sorted by opts.Sort ("id" or "peers"), and the number of matching chunks
+func (r *Registry) ListChunks(opts types.ListOptions) ([]types.ChunkSummary, int) {
 	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	files := make([]*FileInfo, 0, len(r.files))
-	for _, file := range r.files {
-		files = append(files, file)
+	chunks := make([]types.ChunkSummary, 0, len(r.peerChunks))
+	for chunkID, peerMap := range r.peerChunks {
+		if !strings.HasPrefix(chunkID, opts.Prefix) {
+			continue
+		}
+		summary := types.ChunkSummary{ID: chunkID}
+		for _, info := range peerMap {
+			if info.Info.Available {
+				summary.Peers++
+			}
+		}
+		chunks = append(chunks, summary)
 	}
-	return files
+	r.mu.RUnlock()
+
+	sort.Slice(chunks, func(i, j int) bool {
+		a, b := chunks[i], chunks[j]
+		if opts.Desc {
+			a, b = b, a
+		}
+		if opts.Sort == "peers" && a.Peers != b.Peers {
+			return a.Peers < b.Peers
+		}
+		return a.ID < b.ID
+	})
+
+	start, end, _ := opts.Page(len(chunks))
+	return chunks[start:end], len(chunks)
 }
 
 // GetPeersForFile returns all peers that have a specific file
@@ -0,0 +1,278 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/bloom"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewRegistry(dir)
+	require.NoError(t, err)
+	require.NoError(t, r.RegisterFile(&FileInfo{ID: "file1", Name: "a.zap", ChunkCount: 2}))
+	require.NoError(t, r.AddPeerToFile("file1", "peer1"))
+	require.NoError(t, r.AddPeerToFile("file1", "peer2"))
+	require.NoError(t, r.RemovePeerFromFile("file1", "peer1"))
+	r.RegisterPeerChunks("peer2", "addr2", []string{"chunk1", "chunk2"})
+
+	// Reopen without Close, as after a crash: the log alone must restore
+	// the state
+	r.log.Close()
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	defer r.Close()
+
+	file, ok := r.GetFileByName("a.zap")
+	require.True(t, ok)
+	assert.Equal(t, []string{"peer2"}, file.PeerIDs)
+	assert.Equal(t, []string{"peer2"}, r.GetPeersForChunk("chunk1"))
+	assert.Equal(t, []string{"peer2"}, r.GetPeersForChunk("chunk2"))
+}
+
+func TestRegistryCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewRegistry(dir)
+	require.NoError(t, err)
+	for i := 0; i < compactThreshold; i++ {
+		r.RegisterPeerChunks("peer1", "addr1", []string{"chunk1"})
+	}
+	require.NoError(t, r.RegisterFile(&FileInfo{ID: "file1", Name: "a.zap"}))
+
+	// The threshold folded the log into the snapshot
+	assert.Equal(t, 1, r.logEntries)
+	require.NoError(t, r.Close())
+
+	info, err := os.Stat(filepath.Join(dir, logFile))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	defer r.Close()
+	_, ok := r.GetFileByID("file1")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"peer1"}, r.GetPeersForChunk("chunk1"))
+}
+
+func TestRegistryIgnoresTornEntry(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewRegistry(dir)
+	require.NoError(t, err)
+	require.NoError(t, r.RegisterFile(&FileInfo{ID: "file1", Name: "a.zap"}))
+	r.log.Close()
+
+	// A crash mid-append leaves a partial final line
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"register_file","file":{"id":"fi`)
+	require.NoError(t, err)
+	f.Close()
+
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	defer r.Close()
+	_, ok := r.GetFileByID("file1")
+	assert.True(t, ok)
+
+	// New changes append after the last complete entry
+	require.NoError(t, r.RegisterFile(&FileInfo{ID: "file2", Name: "b.zap"}))
+	r.log.Close()
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	_, ok = r.GetFileByID("file2")
+	assert.True(t, ok)
+}
+
+func TestCleanupStaleChunksPersists(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewRegistry(dir)
+	require.NoError(t, err)
+	r.RegisterPeerChunks("peer1", "addr1", []string{"chunk1"})
+	r.peerChunks["chunk1"]["peer1"].LastSeen = time.Now().Add(-time.Hour).Unix()
+	r.CleanupStaleChunks(time.Minute)
+	assert.Empty(t, r.GetPeersForChunk("chunk1"))
+	require.NoError(t, r.Close())
+
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Empty(t, r.GetPeersForChunk("chunk1"))
+}
+
+func TestListFiles(t *testing.T) {
+	r, err := NewRegistry(t.TempDir())
+	require.NoError(t, err)
+	defer r.Close()
+
+	for i, name := range []string{"b.zap", "a.zap", "c.zap", "movies/d.zap"} {
+		require.NoError(t, r.RegisterFile(&FileInfo{ID: name, Name: name, TotalSize: int64(10 - i)}))
+	}
+	names := func(files []*FileInfo) []string {
+		var out []string
+		for _, file := range files {
+			out = append(out, file.Name)
+		}
+		return out
+	}
+
+	files, total := r.ListFiles(types.ListOptions{Limit: 2})
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []string{"a.zap", "b.zap"}, names(files))
+
+	files, _ = r.ListFiles(types.ListOptions{Offset: 2, Limit: 2})
+	assert.Equal(t, []string{"c.zap", "movies/d.zap"}, names(files))
+
+	files, total = r.ListFiles(types.ListOptions{Prefix: "movies/"})
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []string{"movies/d.zap"}, names(files))
+
+	files, _ = r.ListFiles(types.ListOptions{Sort: "size", Desc: true})
+	assert.Equal(t, []string{"b.zap", "a.zap", "c.zap", "movies/d.zap"}, names(files))
+}
+
+func TestListChunks(t *testing.T) {
+	r, err := NewRegistry(t.TempDir())
+	require.NoError(t, err)
+	defer r.Close()
+
+	r.RegisterPeerChunks("peer1", "addr1", []string{"chunk1", "chunk2"})
+	r.RegisterPeerChunks("peer2", "addr2", []string{"chunk2"})
+
+	chunks, total := r.ListChunks(types.ListOptions{Sort: "peers", Desc: true})
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []types.ChunkSummary{{ID: "chunk2", Peers: 2}, {ID: "chunk1", Peers: 1}}, chunks)
+}
+
+func TestRemoveFileBlacklists(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewRegistry(dir)
+	require.NoError(t, err)
+	require.NoError(t, r.RegisterFile(&FileInfo{ID: "file1", Name: "a.zap"}))
+	require.NoError(t, r.RemoveFile("file1"))
+
+	_, ok := r.GetFileByName("a.zap")
+	assert.False(t, ok)
+	assert.True(t, r.IsBlacklisted("file1"))
+	assert.Error(t, r.RegisterFile(&FileInfo{ID: "file1", Name: "b.zap"}))
+
+	// The blacklist survives both replay and compaction
+	r.log.Close()
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	assert.True(t, r.IsBlacklisted("file1"))
+	require.NoError(t, r.Close())
+
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.True(t, r.IsBlacklisted("file1"))
+	_, ok = r.GetFileByID("file1")
+	assert.False(t, ok)
+}
+
+func TestChunkDeltas(t *testing.T) {
+	dir := t.TempDir()
+
+	r, err := NewRegistry(dir)
+	require.NoError(t, err)
+	require.NoError(t, r.SyncPeerChunks("peer1", "addr1", 1, []string{"chunk1", "chunk2"}))
+	require.NoError(t, r.ApplyChunkDelta(&types.ChunkDelta{PeerID: "peer1", BaseSeq: 1, Seq: 2, Added: []string{"chunk3"}, Removed: []string{"chunk1"}}))
+
+	// Redelivered deltas are ignored, ones from the future need a resync
+	require.NoError(t, r.ApplyChunkDelta(&types.ChunkDelta{PeerID: "peer1", BaseSeq: 1, Seq: 2, Added: []string{"chunk1"}}))
+	err = r.ApplyChunkDelta(&types.ChunkDelta{PeerID: "peer1", BaseSeq: 5, Seq: 6, Added: []string{"chunk4"}})
+	assert.ErrorIs(t, err, ErrResyncRequired)
+
+	assert.Empty(t, r.GetPeersForChunk("chunk1"))
+	assert.Equal(t, []string{"peer1"}, r.GetPeersForChunk("chunk3"))
+	assert.Empty(t, r.GetPeersForChunk("chunk4"))
+	assert.Equal(t, uint64(2), r.PeerSeq("peer1"))
+
+	// Sequences survive replay and compaction
+	r.log.Close()
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), r.PeerSeq("peer1"))
+	require.NoError(t, r.Close())
+	r, err = NewRegistry(dir)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, uint64(2), r.PeerSeq("peer1"))
+
+	// A full sync replaces the set
+	require.NoError(t, r.SyncPeerChunks("peer1", "addr1", 7, []string{"chunk4"}))
+	assert.Empty(t, r.GetPeersForChunk("chunk2"))
+	assert.Empty(t, r.GetPeersForChunk("chunk3"))
+	assert.Equal(t, []string{"peer1"}, r.GetPeersForChunk("chunk4"))
+	assert.Equal(t, uint64(7), r.PeerSeq("peer1"))
+
+	// Older lists are ignored, and merged deltas skip the sequence check
+	require.NoError(t, r.SyncPeerChunks("peer1", "addr1", 6, []string{"chunk1"}))
+	assert.Empty(t, r.GetPeersForChunk("chunk1"))
+	require.NoError(t, r.MergeChunkDelta(&types.ChunkDelta{PeerID: "peer1", BaseSeq: 8, Seq: 9, Added: []string{"chunk5"}}))
+	assert.Equal(t, []string{"peer1"}, r.GetPeersForChunk("chunk5"))
+	assert.Equal(t, uint64(9), r.PeerSeq("peer1"))
+}
+
+func TestReconcilePeerChunks(t *testing.T) {
+	r, err := NewRegistry(t.TempDir())
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.NoError(t, r.SyncPeerChunks("peer1", "addr1", 3, []string{"chunk1", "chunk2", "chunk3"}))
+
+	// The peer lost chunk2 without the registry hearing of it
+	summary := &types.ChunkSetSummary{
+		PeerID: "peer1",
+		Seq:    3,
+		Count:  2,
+		Filter: bloom.FromItems([]string{"chunk1", "chunk3"}, types.BloomFalsePositive),
+	}
+	removed, err := r.ReconcilePeerChunks(summary)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunk2"}, removed)
+	assert.Empty(t, r.GetPeersForChunk("chunk2"))
+
+	// Chunks the registry never heard of can only be fixed by a full sync
+	summary.Count = 3
+	summary.Filter = bloom.FromItems([]string{"chunk1", "chunk3", "chunk4"}, types.BloomFalsePositive)
+	_, err = r.ReconcilePeerChunks(summary)
+	assert.ErrorIs(t, err, ErrResyncRequired)
+
+	summary.Seq = 4
+	_, err = r.ReconcilePeerChunks(summary)
+	assert.ErrorIs(t, err, ErrResyncRequired)
+}
+
+func TestDeltaPeersSurviveCleanup(t *testing.T) {
+	r, err := NewRegistry(t.TempDir())
+	require.NoError(t, err)
+	defer r.Close()
+
+	// Chunks announced long ago stay while the peer keeps announcing
+	require.NoError(t, r.SyncPeerChunks("peer1", "addr1", 1, []string{"chunk1"}))
+	r.peerChunks["chunk1"]["peer1"].LastSeen = time.Now().Add(-time.Hour).Unix()
+	require.NoError(t, r.ApplyChunkDelta(&types.ChunkDelta{PeerID: "peer1", BaseSeq: 1, Seq: 2, Added: []string{"chunk2"}}))
+	r.CleanupStaleChunks(time.Minute)
+	assert.Equal(t, []string{"peer1"}, r.GetPeersForChunk("chunk1"))
+
+	// A silent peer loses its chunks and must sync again
+	r.peers["peer1"].LastSeen = time.Now().Add(-time.Hour).Unix()
+	r.CleanupStaleChunks(time.Minute)
+	assert.Empty(t, r.GetPeersForChunk("chunk1"))
+	assert.Empty(t, r.GetPeersForChunk("chunk2"))
+	err = r.ApplyChunkDelta(&types.ChunkDelta{PeerID: "peer1", BaseSeq: 2, Seq: 3, Added: []string{"chunk3"}})
+	assert.ErrorIs(t, err, ErrResyncRequired)
+}
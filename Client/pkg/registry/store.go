@@ -0,0 +1,384 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+)
+
+// The registry is stored as a snapshot, registry.json, plus an append-only
+// log of the changes made since, registry.log. Every change is appended and
+// synced before it is applied, so a restart replays exactly what was
+// acknowledged. Once the log grows past compactThreshold entries it is
+// folded into a new snapshot and truncated.
+//
+// Replaying an entry twice has the same effect as replaying it once, so a
+// crash between writing a snapshot and truncating the log is harmless.
+const (
+	snapshotFile     = "registry.json"
+	logFile          = "registry.log"
+	compactThreshold = 1000
+)
+
+// Log operations
+const (
+	opRegisterFile = "register_file"
+	opPeerChunks   = "peer_chunks"
+	opSyncChunks   = "sync_chunks"
+	opChunkDelta   = "chunk_delta"
+	opAddPeer      = "add_peer"
+	opRemovePeer   = "remove_peer"
+	opCleanup      = "cleanup"
+	opRemoveFile   = "remove_file"
+)
+
+// logEntry is one change in the registry log
+type logEntry struct {
+	Op       string    `json:"op"`
+	File     *FileInfo `json:"file,omitempty"`
+	FileID   string    `json:"file_id,omitempty"`
+	PeerID   string    `json:"peer_id,omitempty"`
+	Address  string    `json:"address,omitempty"`
+	ChunkIDs []string  `json:"chunk_ids,omitempty"` // Chunks announced or added
+	Removed  []string  `json:"removed,omitempty"`   // Chunks a peer no longer hosts
+	Seq      uint64    `json:"seq,omitempty"`       // Peer announcement sequence
+	Time     int64     `json:"time"`
+	MaxAge   int64     `json:"max_age,omitempty"` // Seconds, for cleanup
+}
+
+// snapshot is the on-disk form of the registry state
+type snapshot struct {
+	Files      map[string]*FileInfo                 `json:"files"`
+	PeerChunks map[string]map[string]*ChunkPeerInfo `json:"peer_chunks"`
+	Peers      map[string]*peerState                `json:"peers,omitempty"`
+	Blacklist  map[string]int64                     `json:"blacklist,omitempty"`
+}
+
+// commit durably logs a change and applies it. r.mu must be held.
+func (r *Registry) commit(entry *logEntry) error {
+	entry.Time = time.Now().Unix()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal registry change: %v", err)
+	}
+	if _, err := r.log.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write registry log: %v", err)
+	}
+	if err := r.log.Sync(); err != nil {
+		return fmt.Errorf("failed to sync registry log: %v", err)
+	}
+
+	r.apply(entry)
+	r.logEntries++
+	if r.logEntries >= compactThreshold {
+		if err := r.compact(); err != nil {
+			// The change is already durable in the log
+			fmt.Printf("failed to compact registry: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// apply makes a logged change to the in-memory state. r.mu must be held.
+func (r *Registry) apply(entry *logEntry) {
+	switch entry.Op {
+	case opRegisterFile:
+		if entry.File == nil {
+			return
+		}
+		if old, exists := r.files[entry.File.ID]; exists && old.Name != entry.File.Name {
+			delete(r.filesByName, old.Name)
+		}
+		r.files[entry.File.ID] = entry.File
+		r.filesByName[entry.File.Name] = entry.File
+
+	case opPeerChunks:
+		r.addPeerChunks(entry, entry.ChunkIDs)
+
+	case opSyncChunks:
+		state := r.peerState(entry.PeerID)
+		keep := make(map[string]bool, len(entry.ChunkIDs))
+		for _, chunkID := range entry.ChunkIDs {
+			keep[chunkID] = true
+		}
+		for chunkID := range state.chunks {
+			if !keep[chunkID] {
+				r.removePeerChunk(entry.PeerID, chunkID)
+			}
+		}
+		r.addPeerChunks(entry, entry.ChunkIDs)
+		state.Seq = entry.Seq
+
+	case opChunkDelta:
+		for _, chunkID := range entry.Removed {
+			r.removePeerChunk(entry.PeerID, chunkID)
+		}
+		r.addPeerChunks(entry, entry.ChunkIDs)
+		r.peerState(entry.PeerID).Seq = entry.Seq
+
+	case opAddPeer:
+		file, exists := r.files[entry.FileID]
+		if !exists {
+			return
+		}
+		for _, id := range file.PeerIDs {
+			if id == entry.PeerID {
+				return
+			}
+		}
+		file.PeerIDs = append(file.PeerIDs, entry.PeerID)
+
+	case opRemovePeer:
+		file, exists := r.files[entry.FileID]
+		if !exists {
+			return
+		}
+		for i, id := range file.PeerIDs {
+			if id == entry.PeerID {
+				file.PeerIDs = append(file.PeerIDs[:i], file.PeerIDs[i+1:]...)
+				break
+			}
+		}
+
+	case opRemoveFile:
+		if file, exists := r.files[entry.FileID]; exists {
+			delete(r.filesByName, file.Name)
+			delete(r.files, entry.FileID)
+		}
+		r.blacklist[entry.FileID] = entry.Time
+
+	case opCleanup:
+		// Peers announcing deltas do not repeat unchanged chunks, so their
+		// last announcement keeps all their chunks alive. Peers that only
+		// send full lists age out chunk by chunk.
+		for peerID, state := range r.peers {
+			for chunkID := range state.chunks {
+				lastSeen := state.LastSeen
+				if state.Seq == 0 {
+					lastSeen = r.peerChunks[chunkID][peerID].LastSeen
+				}
+				if entry.Time-lastSeen > entry.MaxAge {
+					r.removePeerChunk(peerID, chunkID)
+				}
+			}
+			if entry.Time-state.LastSeen > entry.MaxAge {
+				delete(r.peers, peerID)
+			}
+		}
+	}
+}
+
+// peerState returns a peer's announcement state, creating it if needed.
+// r.mu must be held.
+func (r *Registry) peerState(peerID string) *peerState {
+	state, exists := r.peers[peerID]
+	if !exists {
+		state = &peerState{}
+		r.peers[peerID] = state
+	}
+	if state.chunks == nil {
+		state.chunks = make(map[string]struct{})
+	}
+	return state
+}
+
+// addPeerChunks records chunks announced by an entry's peer and marks the
+// peer seen. r.mu must be held.
+func (r *Registry) addPeerChunks(entry *logEntry, chunkIDs []string) {
+	state := r.peerState(entry.PeerID)
+	state.LastSeen = entry.Time
+
+	// The chunk is the map key, so the snapshot does not need the peer's
+	// whole list repeated under every chunk
+	info := &ChunkPeerInfo{
+		Info: types.PeerChunkInfo{
+			PeerID:    entry.PeerID,
+			Address:   entry.Address,
+			Available: true,
+		},
+		LastSeen: entry.Time,
+	}
+	for _, chunkID := range chunkIDs {
+		if r.peerChunks[chunkID] == nil {
+			r.peerChunks[chunkID] = make(map[string]*ChunkPeerInfo)
+		}
+		r.peerChunks[chunkID][entry.PeerID] = info
+		state.chunks[chunkID] = struct{}{}
+	}
+}
+
+// removePeerChunk records that a peer no longer hosts a chunk. r.mu must be
+// held.
+func (r *Registry) removePeerChunk(peerID, chunkID string) {
+	if peerMap, exists := r.peerChunks[chunkID]; exists {
+		delete(peerMap, peerID)
+		if len(peerMap) == 0 {
+			delete(r.peerChunks, chunkID)
+		}
+	}
+	if state, exists := r.peers[peerID]; exists {
+		delete(state.chunks, chunkID)
+	}
+}
+
+// compact writes the current state as a new snapshot and empties the log.
+// r.mu must be held.
+func (r *Registry) compact() error {
+	if err := r.saveRegistry(); err != nil {
+		return err
+	}
+	if err := r.log.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate registry log: %v", err)
+	}
+	if _, err := r.log.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind registry log: %v", err)
+	}
+	r.logEntries = 0
+	return nil
+}
+
+// saveRegistry atomically writes the current state as the snapshot
+func (r *Registry) saveRegistry() error {
+	jsonData, err := json.MarshalIndent(snapshot{Files: r.files, PeerChunks: r.peerChunks, Peers: r.peers, Blacklist: r.blacklist}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal registry: %v", err)
+	}
+
+	path := filepath.Join(r.dataDir, snapshotFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save registry: %v", err)
+	}
+	if _, err := f.Write(jsonData); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to save registry: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to save registry: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to save registry: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save registry: %v", err)
+	}
+	return nil
+}
+
+// loadRegistry loads the snapshot, replays the log over it and opens the
+// log for appending
+func (r *Registry) loadRegistry() error {
+	data, err := os.ReadFile(filepath.Join(r.dataDir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read registry: %v", err)
+	}
+	if err == nil {
+		var loaded snapshot
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return fmt.Errorf("failed to parse registry: %v", err)
+		}
+		if loaded.Files != nil {
+			r.files = loaded.Files
+		}
+		if loaded.PeerChunks != nil {
+			r.peerChunks = loaded.PeerChunks
+		}
+		if loaded.Peers != nil {
+			r.peers = loaded.Peers
+		}
+		if loaded.Blacklist != nil {
+			r.blacklist = loaded.Blacklist
+		}
+	}
+
+	// Rebuild each peer's chunk set. Snapshots taken before peers were
+	// tracked only record when each chunk was announced.
+	for chunkID, peerMap := range r.peerChunks {
+		for peerID, info := range peerMap {
+			state := r.peerState(peerID)
+			state.chunks[chunkID] = struct{}{}
+			if info.LastSeen > state.LastSeen {
+				state.LastSeen = info.LastSeen
+			}
+		}
+	}
+
+	// Rebuild the filesByName index
+	for _, file := range r.files {
+		r.filesByName[file.Name] = file
+	}
+
+	log, err := os.OpenFile(filepath.Join(r.dataDir, logFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open registry log: %v", err)
+	}
+	valid, err := r.replay(log)
+	if err != nil {
+		log.Close()
+		return err
+	}
+
+	// Drop a partial entry left by a crash mid-write, then append after
+	// the last complete one
+	if err := log.Truncate(valid); err != nil {
+		log.Close()
+		return fmt.Errorf("failed to truncate registry log: %v", err)
+	}
+	if _, err := log.Seek(valid, io.SeekStart); err != nil {
+		log.Close()
+		return fmt.Errorf("failed to seek registry log: %v", err)
+	}
+	r.log = log
+	return nil
+}
+
+// replay applies the entries in the log and returns the length of its
+// complete entries
+func (r *Registry) replay(log io.Reader) (int64, error) {
+	reader := bufio.NewReader(log)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A trailing line without a newline was never acknowledged
+			return valid, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read registry log: %v", err)
+		}
+
+		var entry logEntry
+		if err := json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil {
+			return 0, fmt.Errorf("corrupt registry log at offset %d: %v", valid, err)
+		}
+		r.apply(&entry)
+		r.logEntries++
+		valid += int64(len(line))
+	}
+}
+
+// Close compacts the registry and closes its log
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.log == nil {
+		return nil
+	}
+	err := r.compact()
+	if closeErr := r.log.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close registry log: %v", closeErr)
+	}
+	r.log = nil
+	return err
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A node joins the quorum of a validator only by proving it holds the key
+// of the peer ID it registers, and that the ID cost it something, so IDs
+// cannot be minted to flood the quorum. It fetches a challenge from the
+// validator, signs the challenge and its ID with its peer key, and either
+// finds a nonce whose hash with them has enough leading zero bits or holds
+// enough stake in the ledger. Challenges are single use and expire.
+const (
+	challengePath    = "/peer/challenge"
+	challengeTTL     = time.Minute
+	maxChallenges    = 10000
+	registrationTag  = "filezap-register/"
+	maxAdmissionBits = 32
+
+	// DefaultAdmissionBits is the proof of work asked of new validators
+	DefaultAdmissionBits = 16
+)
+
+// ErrNoPeerKey is returned for joining validation without a peer key to
+// prove the node's ID with
+var ErrNoPeerKey = errors.New("no peer key to register with")
+
+// Challenge is issued to a node before it registers as a validator
+type Challenge struct {
+	Challenge string `json:"challenge"`
+	Bits      int    `json:"bits"`            // Leading zero bits the proof of work needs
+	Stake     int64  `json:"stake,omitempty"` // Stake that stands in for the work, if any does
+	Expires   int64  `json:"expires"`
+}
+
+// Registration is a node's request to join the quorum
+type Registration struct {
+	ValidatorID string `json:"validator_id"`
+	Challenge   string `json:"challenge"`
+	Nonce       uint64 `json:"nonce"`
+	Signature   []byte `json:"signature"`
+}
+
+// signedBytes returns the registration content covered by the signature
+func (r *Registration) signedBytes() []byte {
+	return []byte(registrationTag + r.Challenge + "/" + r.ValidatorID)
+}
+
+// NewRegistration answers a challenge for the validator whose peer key is
+// key, doing the work asked for unless the validator holds stake instead
+func NewRegistration(c *Challenge, key crypto.PrivKey, staked bool) (*Registration, error) {
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer key: %v", err)
+	}
+	if c.Bits > maxAdmissionBits {
+		return nil, fmt.Errorf("challenge asks for too much work: %d bits", c.Bits)
+	}
+
+	r := &Registration{ValidatorID: id.String(), Challenge: c.Challenge}
+	r.Signature, err = key.Sign(r.signedBytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign registration: %v", err)
+	}
+	if !staked {
+		for !workDone(r, c.Bits) {
+			r.Nonce++
+		}
+	}
+	return r, nil
+}
+
+// workDone reports whether a registration's nonce has the leading zero bits
+func workDone(r *Registration, zeros int) bool {
+	var nonce [8]byte
+	binary.BigEndian.PutUint64(nonce[:], r.Nonce)
+	h := sha256.New()
+	h.Write(r.signedBytes())
+	h.Write(nonce[:])
+	sum := h.Sum(nil)
+
+	for _, b := range sum {
+		if zeros <= 0 {
+			return true
+		}
+		if zeros < 8 {
+			return bits.LeadingZeros8(b) >= zeros
+		}
+		if b != 0 {
+			return false
+		}
+		zeros -= 8
+	}
+	return true
+}
+
+// SetPeerKey sets the key of the node's peer ID, which it proves its ID
+// with when joining validation
+func (s *IntegratedServer) SetPeerKey(key crypto.PrivKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peerKey = key
+}
+
+// SetAdmission sets the proof of work asked of new validators, and the
+// stake that admits them without it, zero for none
+func (s *IntegratedServer) SetAdmission(bits int, stake int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.admissionBits = bits
+	s.admissionStake = stake
+}
+
+// issueChallenge returns a new challenge, dropping expired ones
+func (s *IntegratedServer) issueChallenge() (*Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %v", err)
+	}
+
+	s.mu.RLock()
+	c := &Challenge{
+		Challenge: hex.EncodeToString(nonce),
+		Bits:      s.admissionBits,
+		Stake:     s.admissionStake,
+		Expires:   time.Now().Add(challengeTTL).Unix(),
+	}
+	s.mu.RUnlock()
+
+	s.challengeMu.Lock()
+	defer s.challengeMu.Unlock()
+	now := time.Now().Unix()
+	for challenge, expires := range s.challenges {
+		if now > expires {
+			delete(s.challenges, challenge)
+		}
+	}
+	if len(s.challenges) >= maxChallenges {
+		return nil, fmt.Errorf("too many open challenges")
+	}
+	s.challenges[c.Challenge] = c.Expires
+	return c, nil
+}
+
+// takeChallenge consumes a challenge, reporting whether it was issued here
+// and has not expired
+func (s *IntegratedServer) takeChallenge(challenge string) bool {
+	s.challengeMu.Lock()
+	defer s.challengeMu.Unlock()
+
+	expires, exists := s.challenges[challenge]
+	delete(s.challenges, challenge)
+	return exists && time.Now().Unix() <= expires
+}
+
+// checkRegistration verifies a registration's signature and its work or
+// stake
+func (s *IntegratedServer) checkRegistration(r *Registration) error {
+	id, err := peer.Decode(r.ValidatorID)
+	if err != nil {
+		return fmt.Errorf("invalid validator ID: %v", err)
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("validator ID does not embed its key: %v", err)
+	}
+	ok, err := pub.Verify(r.signedBytes(), r.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid registration signature")
+	}
+
+	s.mu.RLock()
+	zeros, stake := s.admissionBits, s.admissionStake
+	s.mu.RUnlock()
+	if stake > 0 && s.ledger.StakeOf(r.ValidatorID) >= stake {
+		return nil
+	}
+	if !workDone(r, zeros) {
+		return fmt.Errorf("insufficient proof of work")
+	}
+	return nil
+}
+
+func (s *IntegratedServer) handlePeerChallenge(_ *overlay.Request) (*overlay.Response, error) {
+	c, err := s.issueChallenge()
+	if err != nil {
+		return &overlay.Response{
+			StatusCode: 503,
+			Body:       []byte(`{"error":"Failed to issue challenge"}`),
+		}, nil
+	}
+
+	resp, err := overlay.MarshalJSON(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// answerChallenge fetches a challenge from a peer and answers it with key
+func (s *IntegratedServer) answerChallenge(ctx context.Context, peerID string, key crypto.PrivKey) (*Registration, error) {
+	resp, err := s.overlay.SendMessage(ctx, peerID, &overlay.Request{
+		Method: "GET",
+		Path:   challengePath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("challenge refused: status %d", resp.StatusCode)
+	}
+	var c Challenge
+	if err := json.Unmarshal(resp.Body, &c); err != nil {
+		return nil, fmt.Errorf("invalid challenge: %v", err)
+	}
+
+	staked := c.Stake > 0 && s.ledger.StakeOf(s.nodeID) >= c.Stake
+	return NewRegistration(&c, key, staked)
+}
+
+// registerWith answers a peer's challenge to join its quorum
+func (s *IntegratedServer) registerWith(ctx context.Context, peerID string, key crypto.PrivKey) error {
+	registration, err := s.answerChallenge(ctx, peerID, key)
+	if err != nil {
+		return err
+	}
+	body, err := overlay.MarshalJSON(registration)
+	if err != nil {
+		return err
+	}
+	resp, err := s.overlay.SendMessage(ctx, peerID, &overlay.Request{
+		Method: "POST",
+		Path:   "/peer/register",
+		Body:   body,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("registration refused: status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fetchChallenge(t *testing.T, s *IntegratedServer) *Challenge {
+	t.Helper()
+	resp, err := s.overlay.HandleRequest(&overlay.Request{Method: "GET", Path: challengePath})
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	var c Challenge
+	require.NoError(t, json.Unmarshal(resp.Body, &c))
+	return &c
+}
+
+func TestPeerRegistrationNeedsProof(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.SetAdmission(8, 0)
+	key, id := newValidatorKey(t)
+	otherKey, _ := newValidatorKey(t)
+
+	// Bare IDs are no longer admitted
+	assert.Equal(t, 401, postJSON(t, s, "/peer/register", map[string]string{"validator_id": "sybil"}).StatusCode)
+
+	c := fetchChallenge(t, s)
+	assert.Equal(t, 8, c.Bits)
+	registration, err := NewRegistration(c, key, false)
+	require.NoError(t, err)
+	assert.Equal(t, id, registration.ValidatorID)
+
+	// The ID must be the registering key's
+	forged := *registration
+	forged.Signature, err = otherKey.Sign(forged.signedBytes())
+	require.NoError(t, err)
+	assert.Equal(t, 403, postJSON(t, s, "/peer/register", &forged).StatusCode)
+
+	// and the work must be done
+	c = fetchChallenge(t, s)
+	lazy, err := NewRegistration(c, key, true)
+	require.NoError(t, err)
+	for workDone(lazy, 8) {
+		lazy.Nonce++
+	}
+	assert.Equal(t, 403, postJSON(t, s, "/peer/register", lazy).StatusCode)
+	assert.False(t, s.quorumManager.IsValidator(id))
+
+	c = fetchChallenge(t, s)
+	registration, err = NewRegistration(c, key, false)
+	require.NoError(t, err)
+	require.Equal(t, 200, postJSON(t, s, "/peer/register", registration).StatusCode)
+	assert.True(t, s.quorumManager.IsValidator(id))
+
+	// Challenges are single use
+	assert.Equal(t, 401, postJSON(t, s, "/peer/register", registration).StatusCode)
+}
+
+func TestPeerRegistrationByStake(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.SetAdmission(maxAdmissionBits, 10)
+	key, id := newValidatorKey(t)
+
+	// Stake stands in for work no validator could do
+	c := fetchChallenge(t, s)
+	assert.Equal(t, int64(10), c.Stake)
+	registration, err := NewRegistration(c, key, true)
+	require.NoError(t, err)
+	assert.Equal(t, 403, postJSON(t, s, "/peer/register", registration).StatusCode)
+
+	_, err = s.ledger.Transfer("fund", "rewards", id, 10, "")
+	require.NoError(t, err)
+	_, err = s.ledger.Stake("stake", id, 10)
+	require.NoError(t, err)
+	c = fetchChallenge(t, s)
+	registration, err = NewRegistration(c, key, true)
+	require.NoError(t, err)
+	require.Equal(t, 200, postJSON(t, s, "/peer/register", registration).StatusCode)
+	assert.True(t, s.quorumManager.IsValidator(id))
+}
+
+func TestWorkDone(t *testing.T) {
+	r := &Registration{ValidatorID: "v1", Challenge: "c"}
+	assert.True(t, workDone(r, 0))
+	for !workDone(r, 12) {
+		r.Nonce++
+	}
+	sum := 0
+	for zeros := 0; zeros <= 12; zeros++ {
+		if workDone(r, zeros) {
+			sum++
+		}
+	}
+	assert.Equal(t, 13, sum)
+}
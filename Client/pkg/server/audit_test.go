@@ -0,0 +1,121 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func postJSON(t *testing.T, s *IntegratedServer, path string, body interface{}) *overlay.Response {
+	t.Helper()
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	resp, err := s.overlay.HandleRequest(&overlay.Request{Method: "POST", Path: path, Body: data})
+	require.NoError(t, err)
+	return resp
+}
+
+// postSigned posts a body signed by the holder of key, or by a new peer if
+// key is nil
+func postSigned(t *testing.T, s *IntegratedServer, key crypto.PrivKey, path string, body interface{}) *overlay.Response {
+	t.Helper()
+	if key == nil {
+		key, _ = newValidatorKey(t)
+	}
+	req, err := NewSignedRequest(key, "POST", path, body)
+	require.NoError(t, err)
+	resp, err := s.overlay.HandleRequest(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestKeyDelivery(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 3)
+	clientKey, client := newValidatorKey(t)
+
+	fileKey := []byte("0123456789abcdef0123456789abcdef")
+	resp := postJSON(t, s, "/key/register", map[string]interface{}{"file_id": "file1", "key": fileKey})
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	resp = postJSON(t, s, "/key/register", map[string]interface{}{"file_id": "file1", "key": []byte("fedcba9876543210fedcba9876543210")})
+	assert.Equal(t, 409, resp.StatusCode, "key replaced by registering another")
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	request := map[string]interface{}{"file_id": "file1", "public_key": pub[:]}
+	require.Equal(t, 202, postSigned(t, s, clientKey, "/key/request", request).StatusCode)
+
+	deliver := map[string]string{"file_id": "file1"}
+	assert.Equal(t, 403, postSigned(t, s, clientKey, "/key/deliver", deliver).StatusCode, "delivered before approval")
+
+	for _, v := range voters {
+		require.Equal(t, 200, postVote(t, s, v, "/key/vote", "file1", client, true).StatusCode)
+	}
+
+	assert.Equal(t, 404, postSigned(t, s, nil, "/key/deliver", deliver).StatusCode, "delivered to another peer")
+	resp = postSigned(t, s, clientKey, "/key/deliver", deliver)
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	var delivered struct {
+		SealedKey []byte `json:"sealed_key"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &delivered))
+	key, err := keymanager.OpenKey(delivered.SealedKey, pub, priv)
+	require.NoError(t, err)
+	assert.Equal(t, fileKey, key)
+
+	// The delivery was audited
+	data, err := os.ReadFile(s.audit.file.Name())
+	require.NoError(t, err)
+	var record deliveryRecord
+	require.NoError(t, json.Unmarshal(data, &record))
+	assert.Equal(t, client, record.ClientID)
+	assert.ElementsMatch(t, []string{voters[0].id, voters[1].id, voters[2].id}, record.Approvals)
+}
+
+func TestVoteAudit(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 3)
+	clientKey, client := newValidatorKey(t)
+
+	resp, err := s.overlay.HandleRequest(&overlay.Request{Method: "GET", Path: "/audit/head"})
+	require.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	require.Equal(t, 202, postSigned(t, s, clientKey, "/key/request", request).StatusCode)
+	// One rejection leaves too few validators to approve
+	require.Equal(t, 200, postVote(t, s, voters[0], "/key/vote", "file1", client, false).StatusCode)
+
+	resp, err = s.overlay.HandleRequest(&overlay.Request{Method: "GET", Path: "/audit/votes"})
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	var page struct {
+		Entries []*quorum.AuditEntry `json:"entries"`
+		Total   int                  `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &page))
+	assert.Equal(t, 3, page.Total)
+	require.NoError(t, quorum.VerifyAuditChain(page.Entries))
+	decision := page.Entries[2]
+	assert.Equal(t, quorum.AuditDecision, decision.Kind)
+	assert.Equal(t, quorum.SessionDenied, decision.Outcome)
+
+	resp, err = s.overlay.HandleRequest(&overlay.Request{Method: "GET", Path: "/audit/head"})
+	require.NoError(t, err)
+	var head quorum.AuditEntry
+	require.NoError(t, json.Unmarshal(resp.Body, &head))
+	assert.Equal(t, decision.Hash, head.Hash)
+}
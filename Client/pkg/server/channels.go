@@ -0,0 +1,566 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/ledger"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Chunks fetched from a storage node are paid through a payment channel
+// rather than charged one by one. The downloader opens the channel with the
+// validators, who hold its funds in escrow, and as chunks arrive sends the
+// storage node signed updates of how much of the channel it is owed, one per
+// channelBatch chunks. Only the latest update counts, so none is settled
+// until the end: when the downloader closes the channel, or it goes idle,
+// the storage node countersigns the last update and has the validators pay
+// it and return the rest to the downloader. Only the payee can settle, so the
+// downloader cannot settle an earlier update. Channels never settled are
+// refunded.
+const (
+	channelPrefix    = "channel-"            // Prefixes the escrow IDs of channels
+	channelMemo      = "payment channel to " // Memo prefix of channel holds, followed by the payee
+	channelPayMemo   = "channel payment"
+	channelBatch     = 16  // Chunks paid for by each update
+	channelChunks    = 256 // Chunks a channel is opened for
+	channelTimeout   = 24 * time.Hour
+	channelIdle      = 10 * time.Minute // Payees settle channels without updates this long
+	channelRetryWait = time.Minute      // Before opening a channel to a payee again after failing
+)
+
+// ChannelOpen is a downloader's signed request to open a channel to a
+// storage node
+type ChannelOpen struct {
+	ChannelID string `json:"channel_id"`
+	Payer     string `json:"payer"`
+	Payee     string `json:"payee"`
+	Amount    int64  `json:"amount"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// ChannelUpdate is a downloader's signed promise of Paid of a channel's
+// funds to its payee. Each update replaces the one before.
+type ChannelUpdate struct {
+	ChannelID string `json:"channel_id"`
+	Payer     string `json:"payer"`
+	Payee     string `json:"payee"`
+	Paid      int64  `json:"paid"`
+	Final     bool   `json:"final,omitempty"` // The payer is done with the channel
+	Signature []byte `json:"signature,omitempty"`
+
+	// The payee's countersignature, settling the channel
+	PayeeSignature []byte `json:"payee_signature,omitempty"`
+}
+
+// outChannel is a channel this node pays a storage node through
+type outChannel struct {
+	open   *ChannelOpen // Nil if opening failed
+	chunks int64        // Chunks received over the channel
+	paid   int64        // Promised in the last update sent
+	failed time.Time    // When opening failed
+}
+
+// inChannel is the latest update of a channel paying this node
+type inChannel struct {
+	update   ChannelUpdate
+	received time.Time
+}
+
+// signedBytes returns the request content covered by the signature
+func (o *ChannelOpen) signedBytes() ([]byte, error) {
+	unsigned := *o
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// signedBytes returns the update content covered by the signatures
+func (u *ChannelUpdate) signedBytes() ([]byte, error) {
+	unsigned := *u
+	unsigned.Signature = nil
+	unsigned.PayeeSignature = nil
+	return json.Marshal(&unsigned)
+}
+
+// signChannel signs a channel message with a party's key
+func signChannel(data []byte, key crypto.PrivKey) ([]byte, error) {
+	sig, err := key.Sign(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign channel message: %v", err)
+	}
+	return sig, nil
+}
+
+// verifySigner checks a signature against the key embedded in the signing
+// party's peer ID
+func verifySigner(signer string, data, sig []byte) error {
+	id, err := peer.Decode(signer)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID: %v", err)
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("peer ID does not embed its key: %v", err)
+	}
+	ok, err := pub.Verify(data, sig)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// verifyUpdate checks an update's payer signature, and its payee
+// countersignature if settled
+func verifyUpdate(u *ChannelUpdate, settled bool) error {
+	data, err := u.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode update: %v", err)
+	}
+	if err := verifySigner(u.Payer, data, u.Signature); err != nil {
+		return err
+	}
+	if settled {
+		return verifySigner(u.Payee, data, u.PayeeSignature)
+	}
+	return nil
+}
+
+// Errors refusing the opening or settlement of a channel
+var (
+	errInvalidOpen     = errors.New("invalid channel opening")
+	errChannelSettled  = errors.New("channel already settled")
+	errChannelNotFound = errors.New("channel not found")
+	errChannelMismatch = errors.New("update does not match the channel")
+	errChannelOverpaid = errors.New("update pays more than the channel holds")
+)
+
+// channelOpening returns the hold a payer's signed opening of a channel
+// asks for
+func channelOpening(open *ChannelOpen) (*replicationEvent, error) {
+	if !strings.HasPrefix(open.ChannelID, channelPrefix) || open.Payee == "" || open.Amount <= 0 {
+		return nil, errInvalidOpen
+	}
+	data, err := open.signedBytes()
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySigner(open.Payer, data, open.Signature); err != nil {
+		return nil, err
+	}
+
+	return &replicationEvent{
+		Kind:     eventChannelOpen,
+		ClientID: open.Payer,
+		PeerID:   open.Payee,
+		Amount:   open.Amount,
+		Key:      open.ChannelID,
+		Open:     open,
+	}, nil
+}
+
+// channelSettlement returns the payment a countersigned update settling a
+// channel asks for, checked against the channel's escrow
+func (s *IntegratedServer) channelSettlement(update *ChannelUpdate) (*replicationEvent, error) {
+	if err := verifyUpdate(update, true); err != nil {
+		return nil, err
+	}
+
+	escrow, err := s.ledger.GetEscrow(update.ChannelID)
+	switch {
+	case errors.Is(err, ledger.ErrEscrowSettled):
+		return nil, errChannelSettled
+	case err != nil || !strings.HasPrefix(update.ChannelID, channelPrefix):
+		return nil, errChannelNotFound
+	}
+	if escrow.Payer != update.Payer || escrow.Memo != channelMemo+update.Payee {
+		return nil, errChannelMismatch
+	}
+	if update.Paid < 0 || update.Paid > escrow.Amount {
+		return nil, errChannelOverpaid
+	}
+
+	return &replicationEvent{
+		Kind:   eventChannelSettle,
+		PeerID: update.Payee,
+		Amount: update.Paid,
+		Key:    update.ChannelID,
+		Update: update,
+	}, nil
+}
+
+// checkChannel applies a replicated opening or settlement of a channel,
+// rebuilt from the signed opening or update it carries
+func (s *IntegratedServer) checkChannel(event *replicationEvent) error {
+	var change *replicationEvent
+	var err error
+	switch {
+	case event.Kind == eventChannelOpen && event.Open != nil:
+		change, err = channelOpening(event.Open)
+	case event.Kind == eventChannelSettle && event.Update != nil:
+		change, err = s.channelSettlement(event.Update)
+		if errors.Is(err, errChannelSettled) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("channel event missing its signed opening or update")
+	}
+	if err != nil {
+		return err
+	}
+	return s.applyChannel(change)
+}
+
+// applyChannel applies the opening or settlement of a channel to the
+// ledger. Replays have no further effect.
+func (s *IntegratedServer) applyChannel(event *replicationEvent) error {
+	if event.Kind == eventChannelOpen {
+		_, err := s.ledger.Hold(event.Key, event.ClientID, event.Amount, channelMemo+event.PeerID)
+		return err
+	}
+	_, err := s.ledger.Pay(event.Key, event.PeerID, event.Amount, channelPayMemo)
+	if errors.Is(err, ledger.ErrEscrowSettled) {
+		return nil
+	}
+	return err
+}
+
+func (s *IntegratedServer) handleChannelOpen(r *overlay.Request) (*overlay.Response, error) {
+	var open ChannelOpen
+	if err := r.UnmarshalJSON(&open); err != nil {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	event, err := channelOpening(&open)
+	switch {
+	case errors.Is(err, errInvalidOpen):
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	case err != nil:
+		return &overlay.Response{
+			StatusCode: 401,
+			Body:       []byte(`{"error":"Invalid channel signature"}`),
+		}, nil
+	}
+
+	// Opening a channel again is a no-op, so a replayed request cannot
+	// charge the payer twice
+	if err := s.applyChannel(event); err != nil {
+		switch {
+		case errors.Is(err, ledger.ErrInsufficientFunds):
+			return &overlay.Response{
+				StatusCode: 402,
+				Body:       []byte(`{"error":"Insufficient funds"}`),
+			}, nil
+		case errors.Is(err, ledger.ErrIdempotencyConflict):
+			return &overlay.Response{
+				StatusCode: 409,
+				Body:       []byte(`{"error":"Channel already exists"}`),
+			}, nil
+		}
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to open channel"}`),
+		}, nil
+	}
+	s.publish(event)
+
+	resp, err := overlay.MarshalJSON(&open)
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+func (s *IntegratedServer) handleChannelSettle(r *overlay.Request) (*overlay.Response, error) {
+	var update ChannelUpdate
+	if err := r.UnmarshalJSON(&update); err != nil {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	event, err := s.channelSettlement(&update)
+	switch {
+	case errors.Is(err, errChannelSettled):
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Channel already settled"}`),
+		}, nil
+	case errors.Is(err, errChannelNotFound):
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"Channel not found"}`),
+		}, nil
+	case errors.Is(err, errChannelMismatch):
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Update does not match the channel"}`),
+		}, nil
+	case errors.Is(err, errChannelOverpaid):
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Update pays more than the channel holds"}`),
+		}, nil
+	case err != nil:
+		return &overlay.Response{
+			StatusCode: 401,
+			Body:       []byte(`{"error":"Invalid channel signature"}`),
+		}, nil
+	}
+
+	if _, err := s.ledger.Pay(event.Key, event.PeerID, event.Amount, channelPayMemo); err != nil {
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to settle channel"}`),
+		}, nil
+	}
+	s.publish(event)
+
+	return s.balanceResponse(update.Payee)
+}
+
+// payForChunk counts a verified chunk from a storage node against the
+// channel to it, opening one if there is none, and sends the node an update
+// every channelBatch chunks. Nodes without a peer key do not pay through
+// channels.
+func (s *IntegratedServer) payForChunk(payee string) {
+	s.mu.RLock()
+	key := s.peerKey
+	s.mu.RUnlock()
+	if key == nil {
+		return
+	}
+
+	s.channelMu.Lock()
+	ch := s.channelsOut[payee]
+	if ch != nil && ch.open == nil && time.Since(ch.failed) < channelRetryWait {
+		s.channelMu.Unlock()
+		return
+	}
+	if ch == nil || ch.open == nil || ch.paid >= ch.open.Amount {
+		s.channelMu.Unlock()
+		open, err := s.openChannel(payee, channelChunks*chunkPrice, key)
+		s.channelMu.Lock()
+		if err != nil {
+			log.Printf("Failed to open payment channel to %s: %v", payee, err)
+			s.channelsOut[payee] = &outChannel{failed: time.Now()}
+			s.channelMu.Unlock()
+			return
+		}
+		// An exhausted channel was promised in full; its payee settles it
+		ch = &outChannel{open: open}
+		s.channelsOut[payee] = ch
+	}
+
+	ch.chunks++
+	owed := ch.chunks * chunkPrice
+	if owed > ch.open.Amount {
+		owed = ch.open.Amount
+	}
+	if owed-ch.paid < channelBatch*chunkPrice && owed < ch.open.Amount {
+		s.channelMu.Unlock()
+		return
+	}
+	ch.paid = owed
+	update := &ChannelUpdate{ChannelID: ch.open.ChannelID, Payer: s.nodeID, Payee: payee, Paid: owed}
+	s.channelMu.Unlock()
+
+	if err := s.sendUpdate(update, key); err != nil {
+		log.Printf("Failed to send channel update to %s: %v", payee, err)
+	}
+}
+
+// openChannel has the validators open a channel to payee holding amount of
+// this node's funds
+func (s *IntegratedServer) openChannel(payee string, amount int64, key crypto.PrivKey) (*ChannelOpen, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate channel ID: %v", err)
+	}
+	open := &ChannelOpen{
+		ChannelID: channelPrefix + hex.EncodeToString(id),
+		Payer:     s.nodeID,
+		Payee:     payee,
+		Amount:    amount,
+	}
+	data, err := open.signedBytes()
+	if err != nil {
+		return nil, err
+	}
+	if open.Signature, err = signChannel(data, key); err != nil {
+		return nil, err
+	}
+
+	var resp struct{}
+	if err := s.askValidators("/channel/open", open, &resp); err != nil {
+		return nil, err
+	}
+	return open, nil
+}
+
+// sendUpdate signs a channel update and sends it to the payee
+func (s *IntegratedServer) sendUpdate(update *ChannelUpdate, key crypto.PrivKey) error {
+	data, err := update.signedBytes()
+	if err != nil {
+		return err
+	}
+	if update.Signature, err = signChannel(data, key); err != nil {
+		return err
+	}
+	body, err := overlay.MarshalJSON(update)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.overlay.SendMessage(s.ctx, update.Payee, &overlay.Request{
+		Method: "POST",
+		Path:   "/channel/update",
+		Body:   body,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("update refused: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SettleChannels closes the channels this node pays storage nodes through,
+// sending each payee a final update for every chunk received, which it
+// settles with the validators
+func (s *IntegratedServer) SettleChannels() {
+	s.mu.RLock()
+	key := s.peerKey
+	s.mu.RUnlock()
+
+	s.channelMu.Lock()
+	var updates []*ChannelUpdate
+	for payee, ch := range s.channelsOut {
+		if ch.open == nil {
+			continue
+		}
+		owed := ch.chunks * chunkPrice
+		if owed > ch.open.Amount {
+			owed = ch.open.Amount
+		}
+		updates = append(updates, &ChannelUpdate{ChannelID: ch.open.ChannelID, Payer: s.nodeID, Payee: payee, Paid: owed, Final: true})
+	}
+	s.channelsOut = make(map[string]*outChannel)
+	s.channelMu.Unlock()
+
+	for _, update := range updates {
+		if err := s.sendUpdate(update, key); err != nil {
+			log.Printf("Failed to close payment channel to %s: %v", update.Payee, err)
+		}
+	}
+}
+
+// handleChannelUpdate keeps the latest update of a channel paying this node,
+// settling the channel once the payer closes it
+func (s *IntegratedServer) handleChannelUpdate(r *overlay.Request) (*overlay.Response, error) {
+	var update ChannelUpdate
+	if err := r.UnmarshalJSON(&update); err != nil || update.Payee != s.nodeID {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	if err := verifyUpdate(&update, false); err != nil {
+		return &overlay.Response{
+			StatusCode: 401,
+			Body:       []byte(`{"error":"Invalid channel signature"}`),
+		}, nil
+	}
+
+	s.channelMu.Lock()
+	if last, exists := s.channelsIn[update.ChannelID]; !exists || update.Paid >= last.update.Paid {
+		s.channelsIn[update.ChannelID] = &inChannel{update: update, received: time.Now()}
+	}
+	s.channelMu.Unlock()
+
+	if update.Final {
+		go s.settleChannel(update.ChannelID)
+	}
+	return &overlay.Response{StatusCode: 200}, nil
+}
+
+// settleChannel has the validators pay this node a channel's latest update.
+// Failed settlements are retried by settleIdleChannels until the validators
+// would have refunded the channel.
+func (s *IntegratedServer) settleChannel(channelID string) {
+	s.channelMu.Lock()
+	ch, exists := s.channelsIn[channelID]
+	s.channelMu.Unlock()
+	if !exists {
+		return
+	}
+
+	s.mu.RLock()
+	key := s.peerKey
+	s.mu.RUnlock()
+	update := ch.update
+	data, err := update.signedBytes()
+	if err == nil && key == nil {
+		err = fmt.Errorf("no peer key to countersign with")
+	}
+	if err == nil {
+		update.PayeeSignature, err = signChannel(data, key)
+	}
+	if err == nil {
+		var resp struct{}
+		err = s.askValidators("/channel/settle", &update, &resp)
+	}
+	if err != nil {
+		log.Printf("Failed to settle payment channel %s: %v", channelID, err)
+		if time.Since(ch.received) < channelTimeout {
+			return
+		}
+	}
+	s.channelMu.Lock()
+	delete(s.channelsIn, channelID)
+	s.channelMu.Unlock()
+}
+
+// settleIdleChannels periodically settles the channels paying this node
+// whose payers stopped sending updates
+func (s *IntegratedServer) settleIdleChannels() {
+	ticker := time.NewTicker(escrowSweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			var idle []string
+			s.channelMu.Lock()
+			for id, ch := range s.channelsIn {
+				if time.Since(ch.received) > channelIdle {
+					idle = append(idle, id)
+				}
+			}
+			s.channelMu.Unlock()
+			for _, id := range idle {
+				s.settleChannel(id)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newChannelNode returns a node of a mesh that pays or is paid through
+// channels opened with validator v1
+func newChannelNode(t *testing.T, m *mesh) (*IntegratedServer, crypto.PrivKey) {
+	t.Helper()
+	key, id := newValidatorKey(t)
+	node := newMeshValidator(t, m, id)
+	node.isValidator = false
+	node.quorumManager.RegisterValidator("v1")
+	node.SetPeerKey(key)
+	return node, key
+}
+
+func TestPaymentChannel(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	validator := newMeshValidator(t, m, "v1")
+	downloader, downloaderKey := newChannelNode(t, m)
+	storer, _ := newChannelNode(t, m)
+	_, err := validator.ledger.Transfer("fund", "rewards", downloader.nodeID, 300, "")
+	require.NoError(t, err)
+
+	// The first chunk opens a channel; the storage node is sent an update
+	// once a batch of chunks arrived
+	for i := 0; i < channelBatch+4; i++ {
+		downloader.ReportChunkResult(storer.nodeID, true)
+	}
+	downloader.ReportChunkResult(storer.nodeID, false)
+	assert.Equal(t, int64(300-channelChunks*chunkPrice), validator.ledger.Balance(downloader.nodeID))
+	storer.channelMu.Lock()
+	require.Len(t, storer.channelsIn, 1)
+	var latest ChannelUpdate
+	for _, ch := range storer.channelsIn {
+		latest = ch.update
+	}
+	storer.channelMu.Unlock()
+	assert.Equal(t, int64(channelBatch*chunkPrice), latest.Paid)
+
+	// The downloader cannot settle the channel itself
+	resp := postJSON(t, validator, "/channel/settle", latest)
+	assert.Equal(t, 401, resp.StatusCode)
+
+	// Closing the channel pays the storage node every chunk and refunds the
+	// rest
+	downloader.SettleChannels()
+	assert.Eventually(t, func() bool {
+		return validator.ledger.Balance(storer.nodeID) == (channelBatch+4)*chunkPrice
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(300-(channelBatch+4)*chunkPrice), validator.ledger.Balance(downloader.nodeID))
+	assert.Empty(t, validator.ledger.HeldEscrows())
+
+	// Updates are bound to the channel's payer and payee
+	forged := ChannelUpdate{ChannelID: latest.ChannelID, Payer: downloader.nodeID, Payee: "v1", Paid: 1}
+	data, err := forged.signedBytes()
+	require.NoError(t, err)
+	forged.Signature, err = downloaderKey.Sign(data)
+	require.NoError(t, err)
+	assert.Equal(t, 400, postJSON(t, storer, "/channel/update", forged).StatusCode)
+}
+
+func TestPaymentChannelUnfunded(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	validator := newMeshValidator(t, m, "v1")
+	downloader, _ := newChannelNode(t, m)
+	storer, _ := newChannelNode(t, m)
+
+	// Without funds no channel opens, and none is tried again at once
+	downloader.ReportChunkResult(storer.nodeID, true)
+	downloader.ReportChunkResult(storer.nodeID, true)
+	assert.Empty(t, validator.ledger.HeldEscrows())
+	downloader.channelMu.Lock()
+	assert.Nil(t, downloader.channelsOut[storer.nodeID].open)
+	downloader.channelMu.Unlock()
+
+	downloader.SettleChannels()
+	storer.channelMu.Lock()
+	assert.Empty(t, storer.channelsIn)
+	storer.channelMu.Unlock()
+}
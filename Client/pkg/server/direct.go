@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/contacts"
+	"github.com/VetheonGames/FileZap/Client/pkg/keystore"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+)
+
+// A file is sent to a contact by sealing its key to the contact's pinned
+// public key and telling the contact's node over the overlay. The file
+// itself is fetched like any other, by ID; only the contact can open the
+// key, and a node accepts sends only from its own contacts.
+
+// contactSend is the body of a send to a contact
+type contactSend struct {
+	From      string `json:"from"`
+	FileID    string `json:"file_id"`
+	Name      string `json:"name"`
+	SealedKey []byte `json:"sealed_key"`
+}
+
+func (s *IntegratedServer) handleContactSend(r *overlay.Request) (*overlay.Response, error) {
+	var req contactSend
+	if err := r.UnmarshalJSON(&req); err != nil || req.From == "" || req.FileID == "" || len(req.SealedKey) == 0 {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	err := s.contacts.Receive(&contacts.Delivery{
+		FileID:    req.FileID,
+		Name:      req.Name,
+		From:      req.From,
+		SealedKey: req.SealedKey,
+	})
+	if errors.Is(err, contacts.ErrUnknownContact) {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Sender is not a contact"}`),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       []byte(`{"status":"received"}`),
+	}, nil
+}
+
+// Contacts returns the node's contact book
+func (s *IntegratedServer) Contacts() *contacts.Book {
+	return s.contacts
+}
+
+// ContactCode returns the contact code others add to send files to this node
+func (s *IntegratedServer) ContactCode() string {
+	return s.contacts.Code(s.nodeID)
+}
+
+// SendToContact sends a file to a contact: its key is sealed to the
+// contact's pinned public key and delivered to the contact's node
+func (s *IntegratedServer) SendToContact(peerID, fileID, name string, fileKey []byte) error {
+	sealed, err := s.contacts.SealFor(peerID, fileKey)
+	if err != nil {
+		return err
+	}
+
+	body, err := overlay.MarshalJSON(&contactSend{
+		From:      s.nodeID,
+		FileID:    fileID,
+		Name:      name,
+		SealedKey: sealed,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := s.overlay.SendMessage(s.ctx, peerID, &overlay.Request{
+		Method: "POST",
+		Path:   "/contact/send",
+		Body:   body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send file to %s: %v", peerID, err)
+	}
+	if resp.StatusCode != 200 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(resp.Body, &failure)
+		return fmt.Errorf("failed to send file to %s: %s", peerID, failure.Error)
+	}
+	return nil
+}
+
+// ProtectIdentity moves the node's identity key into an unlocked keystore,
+// or loads it from there if it was moved before, so it is not kept on disk
+// in plaintext
+func (s *IntegratedServer) ProtectIdentity(keys *keystore.Store) error {
+	identity, err := keys.Identity()
+	if errors.Is(err, keystore.ErrNotFound) {
+		identity = s.contacts.IdentityKey()
+		err = keys.SetIdentity(identity)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to protect identity key: %v", err)
+	}
+	return s.contacts.SetIdentity(identity)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+)
+
+// Storage nodes earn the payouts of delivery receipts. Validators hold the
+// ledger, so a node asks them for an earnings report on its account: the
+// balance, the total earned, earnings per day and per file, and the current
+// price per chunk.
+const (
+	DefaultEarningsDays = 30
+	maxEarningsDays     = 365
+	day                 = 24 * time.Hour
+)
+
+// EarningsReport summarizes what an account earned serving chunks
+type EarningsReport struct {
+	Account    string          `json:"account"`
+	Balance    int64           `json:"balance"`
+	Total      int64           `json:"total"`       // Earned over all time
+	ChunkPrice int64           `json:"chunk_price"` // Paid per chunk delivered
+	Daily      []DailyEarnings `json:"daily"`       // Oldest first, one per day
+	Files      []FileEarnings  `json:"files"`       // Most earned first
+}
+
+// DailyEarnings is what an account earned on one UTC day
+type DailyEarnings struct {
+	Day    int64 `json:"day"` // Unix time of the day's start
+	Earned int64 `json:"earned"`
+}
+
+// FileEarnings is what an account earned serving one file
+type FileEarnings struct {
+	FileID     string `json:"file_id"`
+	Name       string `json:"name,omitempty"`
+	Earned     int64  `json:"earned"`
+	Deliveries int    `json:"deliveries"` // Paid downloads the account served chunks for
+	LastPaid   int64  `json:"last_paid"`
+}
+
+// Earnings reports an account's earnings from the local ledger, with daily
+// totals for the last days days
+func (s *IntegratedServer) Earnings(account string, days int) *EarningsReport {
+	if days <= 0 {
+		days = DefaultEarningsDays
+	}
+	if days > maxEarningsDays {
+		days = maxEarningsDays
+	}
+
+	report := &EarningsReport{
+		Account:    account,
+		Balance:    s.ledger.Balance(account),
+		ChunkPrice: chunkPrice,
+		Daily:      make([]DailyEarnings, days),
+	}
+	today := time.Now().UTC().Truncate(day)
+	first := today.Add(-time.Duration(days-1) * day)
+	for i := range report.Daily {
+		report.Daily[i].Day = first.Add(time.Duration(i) * day).Unix()
+	}
+
+	files := make(map[string]*FileEarnings)
+	opts := types.ListOptions{Limit: types.MaxListLimit}
+	for {
+		txs, total := s.ledger.History(account, opts)
+		for _, tx := range txs {
+			fileID, ok := strings.CutPrefix(tx.Memo, deliveryMemo)
+			if !ok {
+				continue
+			}
+			var earned int64
+			for _, posting := range tx.Postings {
+				if posting.Account == account && posting.Amount > 0 {
+					earned += posting.Amount
+				}
+			}
+			if earned == 0 {
+				continue
+			}
+
+			report.Total += earned
+			if i := int(time.Unix(tx.Time, 0).UTC().Sub(first) / day); i >= 0 && i < days {
+				report.Daily[i].Earned += earned
+			}
+
+			file, exists := files[fileID]
+			if !exists {
+				file = &FileEarnings{FileID: fileID}
+				if info, ok := s.registry.GetFileByID(fileID); ok {
+					file.Name = info.Name
+				}
+				files[fileID] = file
+			}
+			file.Earned += earned
+			file.Deliveries++
+			if tx.Time > file.LastPaid {
+				file.LastPaid = tx.Time
+			}
+		}
+		opts.Offset += len(txs)
+		if len(txs) == 0 || opts.Offset >= total {
+			break
+		}
+	}
+
+	report.Files = make([]FileEarnings, 0, len(files))
+	for _, file := range files {
+		report.Files = append(report.Files, *file)
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		if report.Files[i].Earned != report.Files[j].Earned {
+			return report.Files[i].Earned > report.Files[j].Earned
+		}
+		return report.Files[i].FileID < report.Files[j].FileID
+	})
+	return report
+}
+
+func (s *IntegratedServer) handleAccountEarnings(r *overlay.Request) (*overlay.Response, error) {
+	var req struct {
+		Account string `json:"account"`
+		Days    int    `json:"days"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil || req.Account == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	resp, err := overlay.MarshalJSON(s.Earnings(req.Account, req.Days))
+	if err != nil {
+		return nil, err
+	}
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// QueryEarnings reports this node's earnings. A validator reads its own
+// ledger; other nodes ask their peers, taking the first report a validator
+// returns.
+func (s *IntegratedServer) QueryEarnings(days int) (*EarningsReport, error) {
+	if s.isValidator {
+		return s.Earnings(s.nodeID, days), nil
+	}
+
+	body, err := overlay.MarshalJSON(map[string]interface{}{"account": s.nodeID, "days": days})
+	if err != nil {
+		return nil, err
+	}
+	req := &overlay.Request{
+		Method: "POST",
+		Path:   "/account/earnings",
+		Body:   body,
+	}
+	for _, peerID := range s.overlay.Peers() {
+		resp, err := s.overlay.SendMessage(s.ctx, peerID, req)
+		if err != nil || resp.StatusCode != 200 {
+			continue
+		}
+		var report EarningsReport
+		if err := json.Unmarshal(resp.Body, &report); err != nil || report.Account != s.nodeID {
+			continue
+		}
+		return &report, nil
+	}
+	return nil, fmt.Errorf("no validator returned an earnings report")
+}
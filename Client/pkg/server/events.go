@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/events"
+)
+
+// SetEventBus sets the bus the server announces vote requests on
+func (s *IntegratedServer) SetEventBus(bus *events.Bus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = bus
+}
+
+// createVoteSession opens a vote session and tells the user, and the vote
+// webhook, that this validator has a vote to cast
+func (s *IntegratedServer) createVoteSession(fileID, clientID string) error {
+	if err := s.quorumManager.CreateVoteSession(fileID, clientID); err != nil {
+		return err
+	}
+	s.postVoteWebhook(fileID, clientID)
+
+	s.mu.RLock()
+	bus := s.events
+	s.mu.RUnlock()
+	if bus == nil {
+		return nil
+	}
+
+	message := fmt.Sprintf("Client %s requested the key of file %s", clientID, fileID)
+	if clientID == moderationClient {
+		message = fmt.Sprintf("File %s was reported and is up for removal", fileID)
+	}
+	bus.Publish(events.Event{
+		Kind:    events.VoteRequest,
+		Title:   "Vote requested",
+		Message: message,
+		Data:    map[string]string{"file_id": fileID, "client_id": clientID},
+	})
+	return nil
+}
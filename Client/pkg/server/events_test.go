@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/events"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoteRequestsAnnounced(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	origin := newKeyedValidator(t, m)
+	replica := newKeyedValidator(t, m)
+	for _, v := range []*IntegratedServer{origin, replica} {
+		v.quorumManager.RegisterValidator(origin.nodeID)
+		v.quorumManager.RegisterValidator(replica.nodeID)
+	}
+
+	bus := events.NewBus(0)
+	replica.SetEventBus(bus)
+
+	// A session replicated from another validator is announced too
+	require.NoError(t, origin.createVoteSession("file1", "client1"))
+	origin.publish(&replicationEvent{Kind: eventVoteSession, FileID: "file1", ClientID: "client1"})
+	require.Eventually(t, func() bool {
+		return len(bus.Recent()) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, replica.openRemovalVote("file2"))
+
+	recent := bus.Recent()
+	require.Len(t, recent, 2)
+	assert.Equal(t, events.VoteRequest, recent[1].Kind)
+	assert.Equal(t, "file1", recent[1].Data["file_id"])
+	assert.Equal(t, "client1", recent[1].Data["client_id"])
+	assert.Equal(t, "file2", recent[0].Data["file_id"])
+	assert.Contains(t, recent[0].Message, "up for removal")
+}
+
+func TestVoteSessionsPushedToValidators(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	for _, id := range []string{"v1", "v2", "v3"} {
+		s.quorumManager.RegisterValidator(id)
+	}
+	require.NoError(t, s.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap", ChunkCount: 2}))
+	clientKey, client := newValidatorKey(t)
+	_, err := s.ledger.Transfer("fund-client", "rewards", client, 2, "")
+	require.NoError(t, err)
+
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	resp := postSigned(t, s, clientKey, "/key/request", request)
+	require.Equal(t, 202, resp.StatusCode)
+	var created struct {
+		RequestID string `json:"request_id"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &created))
+
+	// Every other validator learns of the session and what it decides
+	pushed := m.sent(voteSessionAction)
+	require.Len(t, pushed, 2)
+	peers := []string{pushed[0].peerID, pushed[1].peerID}
+	assert.ElementsMatch(t, []string{"v2", "v3"}, peers)
+	data := pushed[0].data
+	assert.Equal(t, VoteKindKeyRequest, data["kind"])
+	assert.Equal(t, "file1", data["file_id"])
+	assert.Equal(t, "a.zap", data["file_name"])
+	assert.Equal(t, client, data["client_id"])
+	assert.Equal(t, created.RequestID, data["request_id"])
+	assert.Equal(t, "2", data["chunk_count"])
+	assert.Equal(t, "2", data["price"])
+	assert.Equal(t, "3", data["required_votes"])
+	assert.Equal(t, "v1", data["validator"])
+	assert.NotEmpty(t, data["deadline"])
+
+	// Reports opening a removal vote are pushed with their reason
+	report := map[string]string{"file_id": "file1", "reporter_id": "client", "reason": "malware"}
+	require.Equal(t, 202, postJSON(t, s, "/file/report", report).StatusCode)
+	pushed = m.sent(voteSessionAction)
+	require.Len(t, pushed, 4)
+	assert.Equal(t, VoteKindRemoval, pushed[3].data["kind"])
+	assert.Equal(t, "malware", pushed[3].data["reason"])
+	assert.Equal(t, "1", pushed[3].data["reports"])
+	assert.Empty(t, pushed[3].data["client_id"])
+
+	// Further reports join the open vote without a new push
+	report["reporter_id"] = "client2"
+	require.Equal(t, 202, postJSON(t, s, "/file/report", report).StatusCode)
+	assert.Len(t, m.sent(voteSessionAction), 4)
+}
+
+func TestVoteSessionWebhook(t *testing.T) {
+	posted := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		posted <- r
+		bodies <- body
+	}))
+	defer hook.Close()
+
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	origin := newKeyedValidator(t, m)
+	replica := newKeyedValidator(t, m)
+	for _, v := range []*IntegratedServer{origin, replica} {
+		v.quorumManager.RegisterValidator(origin.nodeID)
+		v.quorumManager.RegisterValidator(replica.nodeID)
+	}
+	replica.SetVoteWebhook(hook.URL, "secret")
+
+	// Sessions replicated from other validators are posted too
+	require.NoError(t, origin.createVoteSession("file1", "client1"))
+	origin.publish(&replicationEvent{Kind: eventVoteSession, FileID: "file1", ClientID: "client1"})
+
+	var r *http.Request
+	select {
+	case r = <-posted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	body := <-bodies
+	assert.Equal(t, http.MethodPost, r.Method)
+	assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	assert.Equal(t, webhookSignature([]byte("secret"), body), r.Header.Get(webhookSignatureHeader))
+
+	var notice VoteSessionNotice
+	require.NoError(t, json.Unmarshal(body, &notice))
+	assert.Equal(t, VoteKindKeyRequest, notice.Kind)
+	assert.Equal(t, "file1", notice.FileID)
+	assert.Equal(t, "client1", notice.ClientID)
+	assert.Equal(t, replica.nodeID, notice.Validator)
+	assert.Equal(t, notice.Opened+300, notice.Deadline)
+}
+
+func TestVoteSessionsPushedToCommittee(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	for i := 1; i <= 8; i++ {
+		s.quorumManager.RegisterValidator(fmt.Sprintf("v%d", i))
+	}
+	s.EnableCommittees(3, time.Hour)
+
+	clientKey, client := newValidatorKey(t)
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	require.Equal(t, 202, postSigned(t, s, clientKey, "/key/request", request).StatusCode)
+	session, err := s.quorumManager.GetVoteSession("file1", client)
+	require.NoError(t, err)
+	require.Len(t, session.Committee, 3)
+
+	// Only the committee hears of the session
+	var notified []string
+	for _, n := range m.sent(voteSessionAction) {
+		notified = append(notified, n.peerID)
+		assert.Equal(t, strings.Join(session.Committee, ","), n.data["committee"])
+	}
+	var expected []string
+	for _, id := range session.Committee {
+		if id != "v1" {
+			expected = append(expected, id)
+		}
+	}
+	assert.ElementsMatch(t, expected, notified)
+
+	// and the session waits on this node only if it sits on the committee
+	status := s.ValidatorStatus()
+	assert.Equal(t, session.OnCommittee("v1"), len(status.Pending) == 1)
+	assert.NotZero(t, status.Epoch)
+}
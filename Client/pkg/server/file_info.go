@@ -16,6 +16,7 @@ type FileInfo struct {
 	Chunks    []ChunkInfo
 	Metadata  []byte
 	TotalSize int64
+	Mirrors   []string `json:",omitempty"` // Base URLs of HTTP(S) servers serving the chunks by ID
 }
 
 // GetChunkIDs returns the IDs of all chunks in the file
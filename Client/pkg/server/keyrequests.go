@@ -0,0 +1,150 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+)
+
+// Key request lifecycle. Clients poll GET /key/request/{id} or wait for a
+// key_request notification once the quorum decides.
+const (
+	keyRequestTimeout   = 5 * time.Minute // Matches the vote session timeout
+	keyRequestRetention = time.Hour       // How long finished requests can be polled
+	keyRequestSweep     = time.Minute
+	keyRequestAction    = "key_request"
+)
+
+func (s *IntegratedServer) handleKeyRequestStatus(r *overlay.Request) (*overlay.Response, error) {
+	req, err := s.keyManager.GetKeyRequestByID(r.PathParam("id"))
+	if err != nil {
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"Key request not found"}`),
+		}, nil
+	}
+
+	// Pick up a decision not seen yet
+	if req.State == keymanager.RequestPending {
+		s.updateKeyRequest(req.FileID, req.ClientID)
+		if req, err = s.keyManager.GetKeyRequestByID(req.ID); err != nil {
+			return &overlay.Response{
+				StatusCode: 404,
+				Body:       []byte(`{"error":"Key request not found"}`),
+			}, nil
+		}
+	}
+
+	resp, err := overlay.MarshalJSON(map[string]interface{}{
+		"request_id":   req.ID,
+		"file_id":      req.FileID,
+		"client_id":    req.ClientID,
+		"state":        req.State,
+		"request_time": req.RequestTime,
+		"update_time":  req.UpdateTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// updateKeyRequest moves a pending key request to the outcome of its vote
+// session, if decided, and notifies the client
+func (s *IntegratedServer) updateKeyRequest(fileID, clientID string) {
+	req, err := s.keyManager.GetKeyRequest(fileID, clientID)
+	if err != nil || req.State != keymanager.RequestPending {
+		return
+	}
+
+	status, err := s.quorumManager.SessionStatus(fileID, clientID)
+	if err != nil {
+		return
+	}
+
+	var state string
+	switch status {
+	case quorum.SessionApproved:
+		state = keymanager.RequestApproved
+	case quorum.SessionDenied:
+		state = keymanager.RequestDenied
+	case quorum.SessionExpired:
+		state = keymanager.RequestExpired
+	default:
+		return
+	}
+
+	changed, err := s.keyManager.SetRequestState(req.ID, state)
+	if err != nil || !changed {
+		return
+	}
+	req.State = state
+	s.quorumManager.RecordDecision(fileID, clientID, status)
+	if state != keymanager.RequestApproved {
+		s.refundPayment(req.ID, "key request "+state)
+	}
+	s.notifyKeyRequest(req)
+}
+
+// notifyKeyRequest tells a client its key request has been decided
+func (s *IntegratedServer) notifyKeyRequest(req *keymanager.KeyRequest) {
+	data := map[string]string{
+		"request_id": req.ID,
+		"file_id":    req.FileID,
+		"state":      req.State,
+	}
+	if err := s.overlay.NotifyPeer(req.ClientID, keyRequestAction, data); err != nil {
+		log.Printf("Failed to notify client %s of key request %s: %v", req.ClientID, req.ID, err)
+	}
+}
+
+// resumeKeyRequests settles the key requests left pending by the last run.
+// Requests whose vote session was decided, expired or lost are settled and
+// their clients notified; the rest carry on. Sessions are not denied here,
+// since the validators have not yet rejoined the quorum.
+func (s *IntegratedServer) resumeKeyRequests() {
+	for _, req := range s.keyManager.PendingRequests() {
+		status, err := s.quorumManager.SessionStatus(req.FileID, req.ClientID)
+		switch {
+		case err != nil:
+			// The session did not survive, so the request can never be
+			// decided
+			changed, err := s.keyManager.SetRequestState(req.ID, keymanager.RequestExpired)
+			if err != nil || !changed {
+				continue
+			}
+			req.State = keymanager.RequestExpired
+			s.refundPayment(req.ID, "vote session lost")
+			s.notifyKeyRequest(req)
+		case status == quorum.SessionApproved || status == quorum.SessionExpired:
+			s.updateKeyRequest(req.FileID, req.ClientID)
+		}
+	}
+}
+
+// expireKeyRequests periodically expires key requests the quorum did not
+// decide in time
+func (s *IntegratedServer) expireKeyRequests() {
+	ticker := time.NewTicker(keyRequestSweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, req := range s.keyManager.ExpireRequests(keyRequestTimeout, keyRequestRetention) {
+				s.quorumManager.RecordDecision(req.FileID, req.ClientID, quorum.SessionExpired)
+				s.refundPayment(req.ID, "key request expired")
+				s.notifyKeyRequest(req)
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRequestDecisionNotifiesClient(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 4)
+	clientKey, client := newValidatorKey(t)
+
+	resp := postSigned(t, s, clientKey, "/key/request", map[string]string{"file_id": "file1"})
+	require.Equal(t, 202, resp.StatusCode)
+	var created struct {
+		RequestID string `json:"request_id"`
+		State     string `json:"state"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &created))
+	assert.NotEmpty(t, created.RequestID)
+	assert.Equal(t, keymanager.RequestPending, created.State)
+
+	// Two of four validators rejecting leaves too few to approve
+	for _, v := range voters[:2] {
+		require.Equal(t, 200, postVote(t, s, v, "/key/vote", "file1", client, false).StatusCode)
+	}
+
+	req, err := s.keyManager.GetKeyRequestByID(created.RequestID)
+	require.NoError(t, err)
+	assert.Equal(t, keymanager.RequestDenied, req.State)
+
+	decided := m.sent(keyRequestAction)
+	require.Len(t, decided, 1)
+	assert.Equal(t, client, decided[0].peerID)
+	assert.Equal(t, keymanager.RequestDenied, decided[0].data["state"])
+
+	// Later approvals do not reopen the request
+	for _, v := range voters[2:] {
+		require.Equal(t, 200, postVote(t, s, v, "/key/vote", "file1", client, true).StatusCode)
+	}
+	assert.Equal(t, 403, postSigned(t, s, clientKey, "/key/deliver", map[string]string{"file_id": "file1"}).StatusCode)
+	assert.Len(t, m.sent(keyRequestAction), 1)
+}
+
+func TestKeyRequestAccessList(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	s.quorumManager.RegisterValidator("v1")
+
+	friendKey, friend := newValidatorKey(t)
+	acl := &types.AccessList{ClientIDs: []string{friend}, PublicKeys: [][]byte{[]byte("friend-key")}}
+	require.NoError(t, s.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap", ACL: acl}))
+
+	// Clients outside the list are turned away before a vote
+	strangerKey, stranger := newValidatorKey(t)
+	resp := postSigned(t, s, strangerKey, "/key/request", map[string]string{"file_id": "file1", "client_id": friend})
+	assert.Equal(t, 403, resp.StatusCode)
+	_, err := s.keyManager.GetKeyRequest("file1", stranger)
+	assert.Error(t, err)
+
+	// Clients listed by ID only have the key sealed to their own peer key
+	resp = postSigned(t, s, friendKey, "/key/request", map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)})
+	assert.Equal(t, 403, resp.StatusCode)
+	own, err := peerSealKey(friend)
+	require.NoError(t, err)
+	resp = postSigned(t, s, friendKey, "/key/request", map[string]interface{}{"file_id": "file1", "public_key": own})
+	assert.Equal(t, 202, resp.StatusCode)
+	approve, decided := s.verifyKeyRequest("file1", friend)
+	assert.True(t, decided)
+	assert.True(t, approve)
+
+	// and anyone may ask for it sealed to a listed key
+	otherKey, other := newValidatorKey(t)
+	resp = postSigned(t, s, otherKey, "/key/request", map[string]interface{}{"file_id": "file1", "public_key": []byte("friend-key")})
+	assert.Equal(t, 202, resp.StatusCode)
+	approve, decided = s.verifyKeyRequest("file1", other)
+	assert.True(t, decided)
+	assert.True(t, approve)
+
+	// Validators vote against requests the list no longer allows
+	acl.Expires = time.Now().Add(-time.Minute).Unix()
+	for _, client := range []string{friend, other} {
+		approve, decided = s.verifyKeyRequest("file1", client)
+		assert.True(t, decided)
+		assert.False(t, approve)
+	}
+}
+
+func TestKeyRequestsResumedAfterRestart(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 3)
+	require.NoError(t, s.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap", ChunkCount: 2}))
+
+	names := []string{"waiting", "approved", "expired", "lost"}
+	keys := make(map[string]crypto.PrivKey)
+	clients := make(map[string]string)
+	for _, name := range names {
+		keys[name], clients[name] = newValidatorKey(t)
+		_, err := s.ledger.Transfer("fund-"+name, "rewards", clients[name], 2, "")
+		require.NoError(t, err)
+	}
+	for _, name := range names[:3] {
+		request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+		require.Equal(t, 202, postSigned(t, s, keys[name], "/key/request", request).StatusCode)
+		assert.Zero(t, s.ledger.Balance(clients[name]))
+	}
+	require.Equal(t, 200, postVote(t, s, voters[1], "/key/vote", "file1", clients["waiting"], true).StatusCode)
+
+	// A paid request whose session was never saved
+	lost := &keymanager.KeyRequest{FileID: "file1", ClientID: clients["lost"], RequestTime: time.Now().Unix()}
+	require.NoError(t, s.keyManager.RegisterKeyRequest(lost))
+	require.NoError(t, s.holdPayment(lost, nil))
+
+	// Restart from the sessions and requests saved on disk. One session
+	// reached quorum just before the restart and one expired while the
+	// node was down.
+	s.quorumManager = quorum.NewQuorumManager(300, 3)
+	require.NoError(t, s.quorumManager.EnablePersistence(s.dataDir))
+	s.keyManager = keymanager.NewKeyManager(3)
+	require.NoError(t, s.keyManager.EnablePersistence(s.dataDir))
+	session, err := s.quorumManager.GetVoteSession("file1", clients["waiting"])
+	require.NoError(t, err)
+	assert.Len(t, session.GetVotes(), 1)
+	for _, id := range []string{"v1", "v2", "v3"} {
+		s.quorumManager.RegisterValidator(id)
+		require.NoError(t, s.quorumManager.SubmitVote("file1", clients["approved"], id, true))
+	}
+	session, err = s.quorumManager.GetVoteSession("file1", clients["expired"])
+	require.NoError(t, err)
+	session.StartTime -= 600
+	s.resumeKeyRequests()
+
+	states := make(map[string]string)
+	for _, name := range names {
+		req, err := s.keyManager.GetKeyRequest("file1", clients[name])
+		require.NoError(t, err)
+		states[name] = req.State
+	}
+	assert.Equal(t, map[string]string{
+		"waiting":  keymanager.RequestPending,
+		"approved": keymanager.RequestApproved,
+		"expired":  keymanager.RequestExpired,
+		"lost":     keymanager.RequestExpired,
+	}, states)
+
+	// Clients whose requests can no longer be decided get their payment back
+	assert.Zero(t, s.ledger.Balance(clients["waiting"]))
+	assert.Zero(t, s.ledger.Balance(clients["approved"]))
+	assert.Equal(t, int64(2), s.ledger.Balance(clients["expired"]))
+	assert.Equal(t, int64(2), s.ledger.Balance(clients["lost"]))
+	assert.Len(t, m.sent(keyRequestAction), 3)
+}
+
+func TestKeyShareOnlyToItsValidator(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 3)
+	resp := postJSON(t, s, "/key/register", map[string]interface{}{"file_id": "file1", "key": []byte("0123456789abcdef0123456789abcdef")})
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+
+	// Only the validator a share was dealt to may have it
+	ask := map[string]string{"file_id": "file1", "validator_id": voters[0].id}
+	assert.Equal(t, 400, postJSON(t, s, "/key/share", ask).StatusCode)
+	assert.Equal(t, 403, postSigned(t, s, nil, "/key/share", ask).StatusCode)
+	assert.Equal(t, 403, postSigned(t, s, voters[1].key, "/key/share", ask).StatusCode)
+
+	resp = postSigned(t, s, voters[0].key, "/key/share", ask)
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	var share keymanager.KeyShare
+	require.NoError(t, json.Unmarshal(resp.Body, &share))
+	assert.Equal(t, voters[0].id, share.PeerID)
+	assert.NotEmpty(t, share.ShareData)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+)
+
+// keyRotation is the body of a /key/rotate request. The owner proves it
+// may rotate the key by giving the current one.
+type keyRotation struct {
+	FileID string `json:"file_id"`
+	OldKey []byte `json:"old_key"`
+	Key    []byte `json:"key"`
+}
+
+func (s *IntegratedServer) handleKeyRotate(r *overlay.Request) (*overlay.Response, error) {
+	var req keyRotation
+	if err := r.UnmarshalJSON(&req); err != nil || req.FileID == "" || len(req.OldKey) == 0 || len(req.Key) == 0 {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	err := s.rotateKey(&req)
+	switch {
+	case errors.Is(err, keymanager.ErrNoKey):
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"No key registered for file"}`),
+		}, nil
+	case errors.Is(err, keymanager.ErrKeyMismatch):
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Key does not match the file's key"}`),
+		}, nil
+	case errors.Is(err, keymanager.ErrKeyRevoked):
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Key has been revoked"}`),
+		}, nil
+	case err != nil:
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Not enough validators to share key"}`),
+		}, nil
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       []byte(`{"status":"success"}`),
+	}, nil
+}
+
+// rotateKey replaces a file's key with the one in req and closes the
+// requests made for the old key. Payments held for pending requests are
+// refunded; approved ones may already have been delivered, so their
+// payments are left to the delivery receipts.
+func (s *IntegratedServer) rotateKey(req *keyRotation) error {
+	validators := s.quorumManager.Validators()
+	sort.Strings(validators)
+	closed, err := s.keyManager.RotateKey(req.FileID, req.OldKey, req.Key, validators)
+	if err != nil {
+		return err
+	}
+
+	for _, keyReq := range closed {
+		if keyReq.State == keymanager.RequestPending {
+			s.quorumManager.RecordDecision(keyReq.FileID, keyReq.ClientID, quorum.SessionExpired)
+			s.refundPayment(keyReq.ID, "file key rotated")
+		}
+		keyReq.State = keymanager.RequestExpired
+		s.notifyKeyRequest(keyReq)
+	}
+	return nil
+}
+
+// RotateFileKey replaces a file's key with newKey on every validator
+// holding shares of oldKey, revoking oldKey. Validators without the file's
+// key are skipped. It fails unless every validator holding the key rotated
+// it, and may be retried.
+func (s *IntegratedServer) RotateFileKey(fileID string, oldKey, newKey []byte) error {
+	req := &keyRotation{FileID: fileID, OldKey: oldKey, Key: newKey}
+	body, err := overlay.MarshalJSON(req)
+	if err != nil {
+		return err
+	}
+
+	rotated, failed := 0, 0
+	var lastErr error
+	for _, validatorID := range s.quorumManager.Validators() {
+		if validatorID == s.nodeID {
+			err = s.rotateKey(req)
+			if errors.Is(err, keymanager.ErrNoKey) {
+				continue
+			}
+		} else {
+			var resp *overlay.Response
+			resp, err = s.overlay.SendMessage(s.ctx, validatorID, &overlay.Request{
+				Method: "POST",
+				Path:   "/key/rotate",
+				Body:   body,
+			})
+			if err == nil && resp.StatusCode == 404 {
+				continue
+			}
+			if err == nil && resp.StatusCode != 200 {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+
+		if err != nil {
+			log.Printf("Failed to rotate key of %s with validator %s: %v", fileID, validatorID, err)
+			failed++
+			lastErr = err
+			continue
+		}
+		rotated++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to rotate key with %d of %d validators: %v", failed, failed+rotated, lastErr)
+	}
+	if rotated == 0 {
+		return fmt.Errorf("no validator holds the key of file %s", fileID)
+	}
+	return nil
+}
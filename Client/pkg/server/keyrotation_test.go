@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRotation(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	voters := make([]voter, 3)
+	for i := range voters {
+		voters[i].key, voters[i].id = newValidatorKey(t)
+	}
+	var validators []*IntegratedServer
+	for _, v := range voters {
+		s := newMeshValidator(t, m, v.id)
+		s.isValidator = false // No replication
+		for _, other := range voters {
+			s.quorumManager.RegisterValidator(other.id)
+		}
+		validators = append(validators, s)
+	}
+
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	for _, s := range validators[:2] {
+		resp := postJSON(t, s, "/key/register", map[string]interface{}{"file_id": "file1", "key": oldKey})
+		require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	}
+
+	// A client approved for the old key
+	clientKey, client := newValidatorKey(t)
+	v2 := validators[1]
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	require.Equal(t, 202, postSigned(t, v2, clientKey, "/key/request", request).StatusCode)
+	for _, v := range voters {
+		require.Equal(t, 200, postVote(t, v2, v, "/key/vote", "file1", client, true).StatusCode)
+	}
+
+	// Only the holder of the key may rotate it
+	rotate := map[string]interface{}{"file_id": "file1", "old_key": []byte("guess"), "key": newKey}
+	assert.Equal(t, 403, postJSON(t, v2, "/key/rotate", rotate).StatusCode)
+	assert.Error(t, validators[2].RotateFileKey("file1", []byte("guess"), newKey))
+
+	// The validator without the key is skipped
+	require.NoError(t, validators[2].RotateFileKey("file1", oldKey, newKey))
+	for _, s := range validators[:2] {
+		key, err := s.keyManager.ReconstructKey("file1")
+		require.NoError(t, err)
+		assert.Equal(t, newKey, key)
+	}
+	require.NoError(t, validators[2].RotateFileKey("file1", oldKey, newKey), "retry")
+
+	// Requests made for the old key are closed, and it cannot come back
+	req, err := v2.keyManager.GetKeyRequest("file1", client)
+	require.NoError(t, err)
+	assert.Equal(t, keymanager.RequestExpired, req.State)
+	deliver := map[string]string{"file_id": "file1"}
+	assert.Equal(t, 403, postSigned(t, v2, clientKey, "/key/deliver", deliver).StatusCode)
+	resp := postJSON(t, v2, "/key/register", map[string]interface{}{"file_id": "file1", "key": oldKey})
+	assert.Equal(t, 409, resp.StatusCode)
+}
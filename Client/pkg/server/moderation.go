@@ -0,0 +1,271 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+)
+
+// Reported files go to a moderation queue and a quorum vote on removing
+// them. Removal votes are ordinary vote sessions held under a reserved
+// client ID, so they share the validators, replication and audit log of
+// key requests. A passed vote blacklists the file in the registry and
+// tells the nodes hosting it to drop its chunks.
+const (
+	moderationClient = "moderation" // Client ID of removal vote sessions
+	dropFileAction   = "drop_file"
+)
+
+// Moderation case states
+const (
+	caseOpen      = "open"
+	caseRemoved   = "removed"
+	caseDismissed = "dismissed"
+)
+
+// fileReport is a report of a bad file
+type fileReport struct {
+	ReporterID string `json:"reporter_id"`
+	Reason     string `json:"reason"`
+	Time       int64  `json:"time"`
+}
+
+// moderationCase collects the reports of a file and the outcome of the
+// vote on removing it
+type moderationCase struct {
+	FileID  string       `json:"file_id"`
+	State   string       `json:"state"`
+	Reports []fileReport `json:"reports"`
+	Opened  int64        `json:"opened"`
+	Decided int64        `json:"decided,omitempty"`
+}
+
+// addReport files a report, opening a removal vote if the file has no case
+// under vote. It returns a copy of the file's case and whether a vote was
+// opened.
+func (s *IntegratedServer) addReport(fileID string, report fileReport) (moderationCase, bool) {
+	s.modMu.Lock()
+	defer s.modMu.Unlock()
+
+	c, exists := s.moderation[fileID]
+	if !exists {
+		c = &moderationCase{FileID: fileID, State: caseDismissed}
+		s.moderation[fileID] = c
+	}
+	for _, existing := range c.Reports {
+		if existing.ReporterID == report.ReporterID && existing.Time == report.Time {
+			// Already filed, e.g. replicated back to us
+			return *c, false
+		}
+	}
+	c.Reports = append(c.Reports, report)
+
+	// Reopen dismissed cases once their previous vote is gone
+	reopen := c.State == caseDismissed
+	if reopen {
+		if err := s.openRemovalVote(fileID); err != nil {
+			reopen = false
+		} else {
+			c.State = caseOpen
+			c.Opened = time.Now().Unix()
+			c.Decided = 0
+		}
+	}
+	return *c, reopen
+}
+
+// openRemovalVote starts a vote session on removing a file, or joins one
+// already replicated from another validator
+func (s *IntegratedServer) openRemovalVote(fileID string) error {
+	if status, err := s.quorumManager.SessionStatus(fileID, moderationClient); err == nil && status == quorum.SessionPending {
+		return nil
+	}
+	return s.createVoteSession(fileID, moderationClient)
+}
+
+// updateModeration applies the outcome of a file's removal vote, if decided
+func (s *IntegratedServer) updateModeration(fileID string) {
+	status, err := s.quorumManager.SessionStatus(fileID, moderationClient)
+	if err != nil || status == quorum.SessionPending {
+		return
+	}
+
+	s.modMu.Lock()
+	c, exists := s.moderation[fileID]
+	if !exists || c.State != caseOpen {
+		s.modMu.Unlock()
+		return
+	}
+	c.Decided = time.Now().Unix()
+	if status == quorum.SessionApproved {
+		c.State = caseRemoved
+	} else {
+		c.State = caseDismissed
+	}
+	s.modMu.Unlock()
+
+	s.quorumManager.RecordDecision(fileID, moderationClient, status)
+	if status == quorum.SessionApproved {
+		s.removeFile(fileID)
+	}
+}
+
+// removeFile blacklists a file and tells its hosts to drop its chunks
+func (s *IntegratedServer) removeFile(fileID string) {
+	hosts := append([]string(nil), s.registry.GetPeersForFile(fileID)...)
+	if err := s.registry.RemoveFile(fileID); err != nil {
+		log.Printf("Failed to blacklist file %s: %v", fileID, err)
+		return
+	}
+
+	for _, peerID := range hosts {
+		if err := s.overlay.NotifyPeer(peerID, dropFileAction, map[string]string{"file_id": fileID}); err != nil {
+			log.Printf("Failed to tell %s to drop file %s: %v", peerID, fileID, err)
+		}
+	}
+	log.Printf("Removed file %s by validator vote", fileID)
+}
+
+// handleFileReport files a report of a bad file. The file is put to a
+// removal vote unless one is already under way.
+func (s *IntegratedServer) handleFileReport(r *overlay.Request) (*overlay.Response, error) {
+	var req struct {
+		FileID     string `json:"file_id"`
+		ReporterID string `json:"reporter_id"`
+		Reason     string `json:"reason"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil || req.FileID == "" || req.ReporterID == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	if s.isDraining() {
+		return drainingResponse(), nil
+	}
+	if s.registry.IsBlacklisted(req.FileID) {
+		return &overlay.Response{
+			StatusCode: 410,
+			Body:       []byte(`{"error":"File has been removed"}`),
+		}, nil
+	}
+	if _, exists := s.registry.GetFileByID(req.FileID); !exists {
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"File not found"}`),
+		}, nil
+	}
+
+	report := fileReport{ReporterID: req.ReporterID, Reason: req.Reason, Time: time.Now().Unix()}
+	c, opened := s.addReport(req.FileID, report)
+	s.publish(&replicationEvent{
+		Kind:   eventFileReported,
+		FileID: req.FileID,
+		PeerID: req.ReporterID,
+		Reason: req.Reason,
+		Time:   report.Time,
+	})
+	if opened {
+		s.publish(&replicationEvent{Kind: eventVoteSession, FileID: req.FileID, ClientID: moderationClient})
+		s.pushVoteSession(req.FileID, moderationClient)
+	}
+
+	resp, err := overlay.MarshalJSON(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 202,
+		Body:       resp,
+	}, nil
+}
+
+// handleModerationQueue returns a page of the files under a removal vote,
+// sorted by when their case opened or by number of reports
+func (s *IntegratedServer) handleModerationQueue(r *overlay.Request) (*overlay.Response, error) {
+	opts, resp := listOptions(r, "opened", "reports")
+	if resp != nil {
+		return resp, nil
+	}
+
+	s.modMu.Lock()
+	var cases []moderationCase
+	for _, c := range s.moderation {
+		if c.State == caseOpen {
+			snapshot := *c
+			snapshot.Reports = append([]fileReport(nil), c.Reports...)
+			cases = append(cases, snapshot)
+		}
+	}
+	s.modMu.Unlock()
+
+	sort.Slice(cases, func(i, j int) bool {
+		a, b := cases[i], cases[j]
+		if opts.Desc {
+			a, b = b, a
+		}
+		if opts.Sort == "reports" && len(a.Reports) != len(b.Reports) {
+			return len(a.Reports) < len(b.Reports)
+		}
+		if a.Opened != b.Opened {
+			return a.Opened < b.Opened
+		}
+		return a.FileID < b.FileID
+	})
+
+	start, end, next := opts.Page(len(cases))
+	body, err := overlay.MarshalJSON(map[string]interface{}{
+		"cases":       cases[start:end],
+		"total":       len(cases),
+		"next_offset": next,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       body,
+	}, nil
+}
+
+// handleModerationVote records a validator's signed vote on removing a
+// file. Approving the vote removes the file.
+func (s *IntegratedServer) handleModerationVote(r *overlay.Request) (*overlay.Response, error) {
+	var vote SignedVote
+	if err := r.UnmarshalJSON(&vote); err != nil || vote.ClientID != moderationClient {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	if err := s.checkVote(&vote); err != nil {
+		return voteError(err), nil
+	}
+
+	if err := s.quorumManager.SubmitVote(vote.FileID, moderationClient, vote.ValidatorID, vote.Approved); err != nil {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Failed to submit vote"}`),
+		}, nil
+	}
+	s.publish(&replicationEvent{
+		Kind:     eventVote,
+		FileID:   vote.FileID,
+		ClientID: moderationClient,
+		PeerID:   vote.ValidatorID,
+		Approved: vote.Approved,
+		Vote:     &vote,
+	})
+	s.updateModeration(vote.FileID)
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       []byte(`{"status":"success"}`),
+	}, nil
+}
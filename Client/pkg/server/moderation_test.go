@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func moderationQueue(t *testing.T, s *IntegratedServer) []moderationCase {
+	t.Helper()
+	resp, err := s.overlay.HandleRequest(&overlay.Request{Method: "GET", Path: "/moderation/queue"})
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	var page struct {
+		Cases []moderationCase `json:"cases"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &page))
+	return page.Cases
+}
+
+func TestFileRemovalVote(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	validators := []*IntegratedServer{
+		newKeyedValidator(t, m),
+		newKeyedValidator(t, m),
+		newKeyedValidator(t, m),
+	}
+	voters := make([]voter, len(validators))
+	for i := range voters {
+		voters[i].key, voters[i].id = newValidatorKey(t)
+	}
+	for _, v := range validators {
+		for _, other := range validators {
+			v.quorumManager.RegisterValidator(other.nodeID)
+		}
+		for _, voter := range voters {
+			v.quorumManager.RegisterValidator(voter.id)
+		}
+		require.NoError(t, v.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap"}))
+		require.NoError(t, v.registry.AddPeerToFile("file1", "host"))
+	}
+	s := validators[0]
+
+	report := map[string]string{"file_id": "file1", "reporter_id": "client", "reason": "malware"}
+	assert.Equal(t, 404, postJSON(t, s, "/file/report", map[string]string{"file_id": "nope", "reporter_id": "client"}).StatusCode)
+	require.Equal(t, 202, postJSON(t, s, "/file/report", report).StatusCode)
+
+	// The report and its vote reach the other validators
+	assert.Eventually(t, func() bool {
+		queue := moderationQueue(t, validators[2])
+		_, err := validators[2].quorumManager.GetVoteSession("file1", moderationClient)
+		return len(queue) == 1 && len(queue[0].Reports) == 1 && err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Further reports join the open case
+	report["reporter_id"] = "client2"
+	require.Equal(t, 202, postJSON(t, s, "/file/report", report).StatusCode)
+	queue := moderationQueue(t, s)
+	require.Len(t, queue, 1)
+	assert.Len(t, queue[0].Reports, 2)
+
+	var outsider voter
+	outsider.key, outsider.id = newValidatorKey(t)
+	assert.Equal(t, 400, postVote(t, s, outsider, "/moderation/vote", "file1", moderationClient, true).StatusCode, "vote from outside the quorum")
+	assert.Equal(t, 400, postVote(t, s, voters[0], "/moderation/vote", "file1", "client", true).StatusCode, "vote in another session")
+	for _, v := range voters {
+		require.Equal(t, 200, postVote(t, s, v, "/moderation/vote", "file1", moderationClient, true).StatusCode)
+	}
+
+	for _, v := range validators {
+		v := v
+		assert.Eventually(t, func() bool {
+			return v.registry.IsBlacklisted("file1")
+		}, 2*time.Second, 10*time.Millisecond, "validator %s did not remove the file", v.nodeID)
+	}
+	assert.Empty(t, moderationQueue(t, s))
+
+	m.mu.RLock()
+	var dropped bool
+	for _, n := range m.notifications {
+		if n.peerID == "host" && n.action == dropFileAction && n.data["file_id"] == "file1" {
+			dropped = true
+		}
+	}
+	m.mu.RUnlock()
+	assert.True(t, dropped, "host was not told to drop the file")
+
+	// Removed files stay out of the network
+	assert.Equal(t, 410, postJSON(t, s, "/file/register", registry.FileInfo{ID: "file1", Name: "a.zap"}).StatusCode)
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	assert.Equal(t, 410, postSigned(t, s, nil, "/key/request", request).StatusCode)
+	assert.Equal(t, 410, postJSON(t, s, "/file/report", report).StatusCode)
+}
+
+func TestFileRemovalDismissed(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 3)
+	require.NoError(t, s.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap"}))
+
+	report := map[string]string{"file_id": "file1", "reporter_id": "client"}
+	require.Equal(t, 202, postJSON(t, s, "/file/report", report).StatusCode)
+
+	require.Equal(t, 200, postVote(t, s, voters[0], "/moderation/vote", "file1", moderationClient, false).StatusCode)
+
+	assert.Empty(t, moderationQueue(t, s))
+	assert.False(t, s.registry.IsBlacklisted("file1"))
+	_, ok := s.registry.GetFileByID("file1")
+	assert.True(t, ok)
+}
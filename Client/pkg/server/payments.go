@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/ledger"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Downloads are paid through escrow: a key request holds the price of the
+// file, which is released to the storage nodes once the client signs a
+// receipt for the chunks they delivered, or refunded if the request fails
+// or no receipt arrives in time.
+const (
+	chunkPrice    = 1              // Charged per chunk of a requested file
+	deliveryMemo  = "delivery of " // Memo prefix of payouts, followed by the file ID
+	escrowTimeout = time.Hour
+	escrowSweep   = time.Minute
+)
+
+// DeliveryReceipt is a client's signed confirmation of the chunks storage
+// nodes delivered to it
+type DeliveryReceipt struct {
+	EscrowID  string         `json:"escrow_id"` // ID of the key request that funded the escrow
+	FileID    string         `json:"file_id"`
+	ClientID  string         `json:"client_id"`
+	Chunks    map[string]int `json:"chunks"` // Chunks delivered, by storage node
+	Time      int64          `json:"time"`
+	Signature []byte         `json:"signature,omitempty"`
+}
+
+// signedBytes returns the receipt content covered by the signature
+func (r *DeliveryReceipt) signedBytes() ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// SignReceipt signs a receipt with the client's peer key. ClientID must be
+// the peer ID of that key.
+func SignReceipt(r *DeliveryReceipt, key crypto.PrivKey) error {
+	data, err := r.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode receipt: %v", err)
+	}
+	sig, err := key.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign receipt: %v", err)
+	}
+	r.Signature = sig
+	return nil
+}
+
+// verifyReceipt checks a receipt's signature against the key embedded in
+// the client's peer ID
+func verifyReceipt(r *DeliveryReceipt) error {
+	id, err := peer.Decode(r.ClientID)
+	if err != nil {
+		return fmt.Errorf("invalid client ID: %v", err)
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("client ID does not embed its key: %v", err)
+	}
+	data, err := r.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode receipt: %v", err)
+	}
+	ok, err := pub.Verify(data, r.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid receipt signature")
+	}
+	return nil
+}
+
+// holdPayment puts the price of a requested file in escrow, less what the
+// client's free allowance covers. Files unknown to the registry are not
+// charged. The download is counted with the validators under the client's
+// signed request for the key.
+func (s *IntegratedServer) holdPayment(req *keymanager.KeyRequest, signed json.RawMessage) error {
+	file, exists := s.registry.GetFileByID(req.FileID)
+	if !exists || file.ChunkCount == 0 {
+		return nil
+	}
+
+	amount, grant := s.downloadCharge(req.ClientID, file)
+	if amount > 0 {
+		if _, err := s.ledger.Hold(req.ID, req.ClientID, amount, fmt.Sprintf("key request for %s", req.FileID)); err != nil {
+			if grant != nil {
+				s.usage.Cancel(req.ClientID, *grant)
+			}
+			return err
+		}
+	}
+	if grant != nil {
+		s.publish(&replicationEvent{Kind: eventUsage, ClientID: req.ClientID, Grant: grant, Request: signed})
+	}
+	return nil
+}
+
+// refundPayment returns a key request's escrow to the client, if any is
+// still held
+func (s *IntegratedServer) refundPayment(requestID string, reason string) {
+	_, err := s.ledger.Refund(requestID, reason)
+	if err != nil && !errors.Is(err, ledger.ErrEscrowNotFound) && !errors.Is(err, ledger.ErrEscrowSettled) {
+		log.Printf("Failed to refund escrow %s: %v", requestID, err)
+	}
+}
+
+func (s *IntegratedServer) handlePaymentReceipt(r *overlay.Request) (*overlay.Response, error) {
+	var receipt DeliveryReceipt
+	if err := r.UnmarshalJSON(&receipt); err != nil {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	if err := verifyReceipt(&receipt); err != nil {
+		return &overlay.Response{
+			StatusCode: 401,
+			Body:       []byte(`{"error":"Invalid receipt signature"}`),
+		}, nil
+	}
+
+	keyReq, err := s.keyManager.GetKeyRequestByID(receipt.EscrowID)
+	if err != nil || keyReq.FileID != receipt.FileID || keyReq.ClientID != receipt.ClientID {
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"Key request not found"}`),
+		}, nil
+	}
+
+	// Only nodes hosting the file are paid
+	weights := make(map[string]int64)
+	hosts := s.registry.GetPeersForFile(receipt.FileID)
+	for storer, chunks := range receipt.Chunks {
+		if chunks <= 0 || !contains(hosts, storer) {
+			return &overlay.Response{
+				StatusCode: 400,
+				Body:       []byte(`{"error":"Receipt names a node not hosting the file"}`),
+			}, nil
+		}
+		weights[storer] = int64(chunks)
+	}
+
+	tx, err := s.ledger.Release(receipt.EscrowID, weights, deliveryMemo+receipt.FileID)
+	switch {
+	case errors.Is(err, ledger.ErrEscrowNotFound):
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"No payment held for request"}`),
+		}, nil
+	case errors.Is(err, ledger.ErrEscrowSettled):
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Payment already settled"}`),
+		}, nil
+	case err != nil:
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Failed to release payment"}`),
+		}, nil
+	}
+
+	resp, err := overlay.MarshalJSON(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// refundExpiredPayments periodically refunds escrows no receipt released
+// in time, and payment channels their payees did not settle
+func (s *IntegratedServer) refundExpiredPayments() {
+	ticker := time.NewTicker(escrowSweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := time.Now().Add(-escrowTimeout).Unix()
+			channelCutoff := time.Now().Add(-channelTimeout).Unix()
+			for _, escrow := range s.ledger.HeldEscrows() {
+				if strings.HasPrefix(escrow.ID, channelPrefix) {
+					if escrow.Created < channelCutoff {
+						s.refundPayment(escrow.ID, "payment channel not settled")
+					}
+					continue
+				}
+				if escrow.Created < cutoff {
+					s.refundPayment(escrow.ID, "no delivery receipt")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	libp2ppeer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscrowedPayment(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+
+	priv, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	clientID, err := libp2ppeer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+	client := clientID.String()
+
+	require.NoError(t, s.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap", ChunkCount: 3}))
+	require.NoError(t, s.registry.AddPeerToFile("file1", "s1"))
+	require.NoError(t, s.registry.AddPeerToFile("file1", "s2"))
+
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	assert.Equal(t, 402, postSigned(t, s, priv, "/key/request", request).StatusCode)
+
+	_, err = s.ledger.Transfer("fund", "rewards", client, 5, "")
+	require.NoError(t, err)
+	require.Equal(t, 202, postSigned(t, s, priv, "/key/request", request).StatusCode)
+	assert.Equal(t, int64(2), s.ledger.Balance(client))
+	keyReq, err := s.keyManager.GetKeyRequest("file1", client)
+	require.NoError(t, err)
+
+	receipt := &DeliveryReceipt{
+		EscrowID: keyReq.ID,
+		FileID:   "file1",
+		ClientID: client,
+		Chunks:   map[string]int{"s1": 2, "s2": 1},
+		Time:     time.Now().Unix(),
+	}
+	require.NoError(t, SignReceipt(receipt, priv))
+
+	forged := *receipt
+	forged.Chunks = map[string]int{"s1": 3}
+	assert.Equal(t, 401, postJSON(t, s, "/payment/receipt", &forged).StatusCode)
+
+	resp := postJSON(t, s, "/payment/receipt", receipt)
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	assert.Equal(t, int64(2), s.ledger.Balance("s1"))
+	assert.Equal(t, int64(1), s.ledger.Balance("s2"))
+	assert.Equal(t, 409, postJSON(t, s, "/payment/receipt", receipt).StatusCode)
+}
+
+func TestDeniedRequestRefunded(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 3)
+	clientKey, client := newValidatorKey(t)
+
+	require.NoError(t, s.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap", ChunkCount: 2}))
+	_, err := s.ledger.Transfer("fund", "rewards", client, 2, "")
+	require.NoError(t, err)
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	require.Equal(t, 202, postSigned(t, s, clientKey, "/key/request", request).StatusCode)
+	assert.Equal(t, int64(0), s.ledger.Balance(client))
+
+	for _, v := range voters[:2] {
+		require.Equal(t, 200, postVote(t, s, v, "/key/vote", "file1", client, false).StatusCode)
+	}
+	assert.Equal(t, int64(2), s.ledger.Balance(client))
+}
+
+func TestEarningsReport(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	v := newMeshValidator(t, m, "v1")
+	storer := newMeshValidator(t, m, "s1")
+	storer.isValidator = false
+
+	require.NoError(t, v.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap"}))
+	_, err := v.ledger.Transfer("pay1", "escrow:r1", "s1", 3, deliveryMemo+"file1")
+	require.NoError(t, err)
+	_, err = v.ledger.Transfer("pay2", "escrow:r2", "s1", 2, deliveryMemo+"file1")
+	require.NoError(t, err)
+	_, err = v.ledger.Transfer("pay3", "escrow:r3", "s1", 4, deliveryMemo+"file2")
+	require.NoError(t, err)
+	_, err = v.ledger.Transfer("gift", "rewards", "s1", 10, "not an earning")
+	require.NoError(t, err)
+
+	report, err := storer.QueryEarnings(7)
+	require.NoError(t, err)
+	assert.Equal(t, "s1", report.Account)
+	assert.Equal(t, int64(19), report.Balance)
+	assert.Equal(t, int64(9), report.Total)
+	assert.Equal(t, int64(chunkPrice), report.ChunkPrice)
+	require.Len(t, report.Daily, 7)
+	assert.Equal(t, int64(9), report.Daily[6].Earned)
+
+	require.Len(t, report.Files, 2)
+	assert.Equal(t, FileEarnings{FileID: "file1", Name: "a.zap", Earned: 5, Deliveries: 2, LastPaid: report.Files[0].LastPaid}, report.Files[0])
+	assert.NotZero(t, report.Files[0].LastPaid)
+	assert.Equal(t, "file2", report.Files[1].FileID)
+	assert.Equal(t, int64(4), report.Files[1].Earned)
+}
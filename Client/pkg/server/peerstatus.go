@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A peer announces the zaps it holds in a status update signed with its
+// peer key, so no one can claim files on another peer's behalf. Updates are
+// taken at most once per peerStatusInterval from each peer, and must be
+// newer than the last one taken and no older than peerStatusMaxAge, so a
+// captured update cannot be replayed.
+const (
+	peerStatusInterval = 10 * time.Second
+	peerStatusMaxAge   = 5 * time.Minute
+	maxStatusZaps      = 10000
+	maxStatusPeers     = 100000
+)
+
+// PeerStatus is a peer's signed announcement of the zaps it holds
+type PeerStatus struct {
+	PeerID        string   `json:"peer_id"`
+	AvailableZaps []string `json:"available_zaps"`
+	Time          int64    `json:"time"`
+	Signature     []byte   `json:"signature,omitempty"`
+}
+
+// peerStatusSeen is the last update taken from a peer
+type peerStatusSeen struct {
+	signed   int64     // Time the peer signed the update at
+	received time.Time // When it was taken
+}
+
+// signedBytes returns the status content covered by the signature
+func (st *PeerStatus) signedBytes() ([]byte, error) {
+	unsigned := *st
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// SignPeerStatus signs a status update with the peer's key. PeerID must be
+// the peer ID of that key.
+func SignPeerStatus(st *PeerStatus, key crypto.PrivKey) error {
+	data, err := st.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode status: %v", err)
+	}
+	sig, err := key.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign status: %v", err)
+	}
+	st.Signature = sig
+	return nil
+}
+
+// verifyPeerStatus checks a status update's signature against the key
+// embedded in the peer's ID, and that it is recent
+func verifyPeerStatus(st *PeerStatus) error {
+	id, err := peer.Decode(st.PeerID)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID: %v", err)
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("peer ID does not embed its key: %v", err)
+	}
+	data, err := st.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode status: %v", err)
+	}
+	ok, err := pub.Verify(data, st.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid status signature")
+	}
+
+	age := time.Since(time.Unix(st.Time, 0))
+	if age > peerStatusMaxAge || age < -peerStatusMaxAge {
+		return fmt.Errorf("status update is stale")
+	}
+	return nil
+}
+
+// takePeerStatus records a verified update from a peer, reporting false if
+// the peer sent one too recently or the update is not newer than the last
+func (s *IntegratedServer) takePeerStatus(st *PeerStatus) bool {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	now := time.Now()
+	if last, exists := s.peerStatus[st.PeerID]; exists {
+		if now.Sub(last.received) < peerStatusInterval || st.Time <= last.signed {
+			return false
+		}
+	}
+	if len(s.peerStatus) >= maxStatusPeers {
+		// Updates signed before the maximum age are refused as stale anyway
+		for id, last := range s.peerStatus {
+			if now.Sub(last.received) > 2*peerStatusMaxAge {
+				delete(s.peerStatus, id)
+			}
+		}
+		if len(s.peerStatus) >= maxStatusPeers {
+			return false
+		}
+	}
+	s.peerStatus[st.PeerID] = peerStatusSeen{signed: st.Time, received: now}
+	return true
+}
+
+func (s *IntegratedServer) handlePeerStatus(r *overlay.Request) (*overlay.Response, error) {
+	var st PeerStatus
+	if err := r.UnmarshalJSON(&st); err != nil || len(st.AvailableZaps) > maxStatusZaps {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	if err := verifyPeerStatus(&st); err != nil {
+		body, _ := overlay.MarshalJSON(map[string]string{"error": err.Error()})
+		return &overlay.Response{
+			StatusCode: 401,
+			Body:       body,
+		}, nil
+	}
+
+	if !s.takePeerStatus(&st) {
+		return &overlay.Response{
+			StatusCode: 429,
+			Body:       []byte(`{"error":"Too many status updates"}`),
+		}, nil
+	}
+
+	s.peerManager.UpdatePeer(st.PeerID, "", st.AvailableZaps)
+	for _, zapID := range st.AvailableZaps {
+		if err := s.registry.AddPeerToFile(zapID, st.PeerID); err != nil {
+			log.Printf("Failed to update peer-file association: %v", err)
+			continue
+		}
+		s.publish(&replicationEvent{Kind: eventPeerFile, FileID: zapID, PeerID: st.PeerID})
+	}
+
+	return &overlay.Response{StatusCode: 200}, nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedPeerStatus(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	require.NoError(t, s.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap"}))
+
+	key, peerID := newValidatorKey(t)
+	_, otherID := newValidatorKey(t)
+
+	status := &PeerStatus{PeerID: peerID, AvailableZaps: []string{"file1"}, Time: time.Now().Unix()}
+	assert.Equal(t, 401, postJSON(t, s, "/peer/status", status).StatusCode, "unsigned")
+
+	forged := &PeerStatus{PeerID: otherID, AvailableZaps: []string{"file1"}, Time: time.Now().Unix()}
+	require.NoError(t, SignPeerStatus(forged, key))
+	assert.Equal(t, 401, postJSON(t, s, "/peer/status", forged).StatusCode, "signed by another peer")
+
+	stale := &PeerStatus{PeerID: peerID, AvailableZaps: []string{"file1"}, Time: time.Now().Add(-time.Hour).Unix()}
+	require.NoError(t, SignPeerStatus(stale, key))
+	assert.Equal(t, 401, postJSON(t, s, "/peer/status", stale).StatusCode, "stale")
+	assert.Empty(t, s.registry.GetPeersForFile("file1"))
+
+	require.NoError(t, SignPeerStatus(status, key))
+	resp := postJSON(t, s, "/peer/status", status)
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	assert.Equal(t, []string{peerID}, s.registry.GetPeersForFile("file1"))
+	_, known := s.peerManager.GetPeer(peerID)
+	assert.True(t, known)
+
+	// A second update within the interval is refused, as is a replay after it
+	next := &PeerStatus{PeerID: peerID, AvailableZaps: []string{"file1"}, Time: time.Now().Unix() + 1}
+	require.NoError(t, SignPeerStatus(next, key))
+	assert.Equal(t, 429, postJSON(t, s, "/peer/status", next).StatusCode)
+
+	s.statusMu.Lock()
+	seen := s.peerStatus[peerID]
+	seen.received = seen.received.Add(-peerStatusInterval)
+	s.peerStatus[peerID] = seen
+	s.statusMu.Unlock()
+	assert.Equal(t, 429, postJSON(t, s, "/peer/status", status).StatusCode, "replayed")
+	assert.Equal(t, 200, postJSON(t, s, "/peer/status", next).StatusCode)
+}
@@ -0,0 +1,444 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/policy"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+)
+
+// Validators gossip every change to the registry and to vote sessions to the
+// validators they know, which apply it and pass it on. Events carry their
+// origin and a sequence number so each validator applies and forwards an
+// event once, and are signed with the origin's peer key so no one else can
+// forge them. Votes carry the voting validator's signed vote, and changes
+// to balances, stakes, channels and usage carry the signed client request
+// or claim they were made for, which each validator checks again rather
+// than trusting the origin. A validator joining the network first pulls a
+// snapshot of the state from a peer.
+const (
+	replicatePath      = "/validator/replicate"
+	statePath          = "/validator/state"
+	replicationTimeout = 10 * time.Second
+	maxSeenEvents      = 10000 // Event IDs remembered for deduplication
+)
+
+// Replicated event kinds
+const (
+	eventFileRegistered = "file_registered"
+	eventPeerChunks     = "peer_chunks"
+	eventChunkSync      = "chunk_sync"
+	eventChunkDelta     = "chunk_delta"
+	eventPeerFile       = "peer_file"
+	eventVoteSession    = "vote_session"
+	eventVote           = "vote"
+	eventFileReported   = "file_reported"
+	eventStake          = "stake"
+	eventUnstake        = "unstake"
+	eventEvidence       = "evidence"
+	eventFaucet         = "faucet"
+	eventDeposit        = "deposit"
+	eventChannelOpen    = "channel_open"
+	eventChannelSettle  = "channel_settle"
+	eventUsage          = "usage"
+)
+
+// replicationEvent is a state change gossiped among validators
+type replicationEvent struct {
+	ID       string             `json:"id"`
+	Origin   string             `json:"origin"` // Validator the change was made on
+	Kind     string             `json:"kind"`
+	File     *registry.FileInfo `json:"file,omitempty"`
+	FileID   string             `json:"file_id,omitempty"`
+	ClientID string             `json:"client_id,omitempty"`
+	PeerID   string             `json:"peer_id,omitempty"` // Chunk or file host, voting validator or reporter
+	Address  string             `json:"address,omitempty"`
+	ChunkIDs []string           `json:"chunk_ids,omitempty"` // Chunks announced or added
+	Removed  []string           `json:"removed,omitempty"`   // Chunks a peer no longer hosts
+	BaseSeq  uint64             `json:"base_seq,omitempty"`  // Announcement a delta follows on from
+	Seq      uint64             `json:"seq,omitempty"`       // Peer announcement sequence
+	Approved bool               `json:"approved,omitempty"`
+	Reason   string             `json:"reason,omitempty"` // Why a file was reported, or a credit's memo
+	Time     int64              `json:"time,omitempty"`   // When a file was reported
+	Amount   int64              `json:"amount,omitempty"` // Stake locked or returned, funds credited or channel funds
+	Key      string             `json:"key,omitempty"`    // Idempotency key of a ledger change
+	Evidence *Evidence          `json:"evidence,omitempty"`
+	Grant    *policy.Grant      `json:"grant,omitempty"`   // Download counted against a client's allowance
+	Vote     *SignedVote        `json:"vote,omitempty"`    // Signed vote of a vote event
+	Request  json.RawMessage    `json:"request,omitempty"` // Signed client request a change was made for
+	Open     *ChannelOpen       `json:"open,omitempty"`    // Signed opening of a channel
+	Update   *ChannelUpdate     `json:"update,omitempty"`  // Countersigned update settling a channel
+
+	Signature []byte `json:"signature,omitempty"` // Origin's signature
+}
+
+// signedBytes returns the event content covered by the signature
+func (e *replicationEvent) signedBytes() ([]byte, error) {
+	unsigned := *e
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// stateSnapshot is the replicated state of a validator
+type stateSnapshot struct {
+	Validators []string              `json:"validators"`
+	Files      []*registry.FileInfo  `json:"files"`
+	PeerChunks []types.PeerChunkInfo `json:"peer_chunks"`
+	Sessions   []sessionSnapshot     `json:"sessions"`
+	Blacklist  []string              `json:"blacklist,omitempty"`
+	Moderation []moderationCase      `json:"moderation,omitempty"` // Files under a removal vote
+	Slashing   []slashCase           `json:"slashing,omitempty"`   // Validators under a slashing vote
+}
+
+// sessionSnapshot is a pending vote session and the votes cast so far
+type sessionSnapshot struct {
+	FileID    string        `json:"file_id"`
+	ClientID  string        `json:"client_id"`
+	StartTime int64         `json:"start_time,omitempty"`
+	Votes     []quorum.Vote `json:"votes"`
+}
+
+// setupReplication registers the replication handlers
+func (s *IntegratedServer) setupReplication() {
+	s.handle("POST", replicatePath, s.handleReplicate)
+	s.handle("GET", statePath, s.handleState)
+}
+
+// publish gossips a change made on this node to the other validators
+func (s *IntegratedServer) publish(event *replicationEvent) {
+	s.mu.RLock()
+	isValidator, key := s.isValidator, s.peerKey
+	s.mu.RUnlock()
+	if !isValidator {
+		return
+	}
+	if key == nil {
+		log.Printf("Cannot replicate %s without a peer key to sign it", event.Kind)
+		return
+	}
+
+	event.Origin = s.nodeID
+	event.ID = fmt.Sprintf("%s/%d", s.nodeID, atomic.AddUint64(&s.replSeq, 1))
+	data, err := event.signedBytes()
+	if err == nil {
+		event.Signature, err = key.Sign(data)
+	}
+	if err != nil {
+		log.Printf("Failed to sign replication event: %v", err)
+		return
+	}
+	s.markSeen(event.ID)
+	s.gossip(event)
+}
+
+// gossip sends an event to every known validator except its origin. The
+// event is encoded before gossip returns, so it may share state with the
+// registry.
+func (s *IntegratedServer) gossip(event *replicationEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal replication event: %v", err)
+		return
+	}
+
+	go func() {
+		for _, validatorID := range s.quorumManager.Validators() {
+			if validatorID == s.nodeID || validatorID == event.Origin {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(s.ctx, replicationTimeout)
+			resp, err := s.overlay.SendMessage(ctx, validatorID, &overlay.Request{
+				Method: "POST",
+				Path:   replicatePath,
+				Body:   body,
+			})
+			cancel()
+			if err != nil {
+				log.Printf("Failed to replicate %s to validator %s: %v", event.Kind, validatorID, err)
+				continue
+			}
+			if resp.StatusCode != 200 {
+				log.Printf("Validator %s rejected %s: status %d", validatorID, event.Kind, resp.StatusCode)
+			}
+		}
+	}()
+}
+
+// markSeen records an event ID or chunk registration key, reporting
+// whether it was new
+func (s *IntegratedServer) markSeen(id string) bool {
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+
+	if s.seenEvents[id] {
+		return false
+	}
+	s.seenEvents[id] = true
+	s.seenOrder = append(s.seenOrder, id)
+	if len(s.seenOrder) > maxSeenEvents {
+		delete(s.seenEvents, s.seenOrder[0])
+		s.seenOrder = s.seenOrder[1:]
+	}
+	return true
+}
+
+func (s *IntegratedServer) handleReplicate(r *overlay.Request) (*overlay.Response, error) {
+	var event replicationEvent
+	if err := r.UnmarshalJSON(&event); err != nil || event.ID == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid replication event"}`),
+		}, nil
+	}
+	if !s.quorumManager.IsValidator(event.Origin) {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Unknown validator"}`),
+		}, nil
+	}
+	data, err := event.signedBytes()
+	if err == nil {
+		err = verifySigner(event.Origin, data, event.Signature)
+	}
+	if err != nil {
+		return &overlay.Response{
+			StatusCode: 401,
+			Body:       []byte(`{"error":"Invalid event signature"}`),
+		}, nil
+	}
+
+	// Already applied and forwarded
+	if !s.markSeen(event.ID) {
+		return &overlay.Response{StatusCode: 200}, nil
+	}
+
+	if err := s.applyEvent(&event); err != nil {
+		log.Printf("Failed to apply %s from validator %s: %v", event.Kind, event.Origin, err)
+	}
+	s.gossip(&event)
+
+	return &overlay.Response{StatusCode: 200}, nil
+}
+
+// applyEvent makes a replicated change to the local state. Applying an
+// event more than once has no further effect.
+func (s *IntegratedServer) applyEvent(event *replicationEvent) error {
+	switch event.Kind {
+	case eventFileRegistered:
+		if event.File == nil {
+			return fmt.Errorf("missing file")
+		}
+		return s.registry.RegisterFile(event.File)
+
+	case eventPeerChunks:
+		s.registry.RegisterPeerChunks(event.PeerID, event.Address, event.ChunkIDs)
+		return nil
+
+	case eventChunkSync:
+		return s.registry.SyncPeerChunks(event.PeerID, event.Address, event.Seq, event.ChunkIDs)
+
+	case eventChunkDelta:
+		return s.registry.MergeChunkDelta(&types.ChunkDelta{
+			PeerID:  event.PeerID,
+			Address: event.Address,
+			BaseSeq: event.BaseSeq,
+			Seq:     event.Seq,
+			Added:   event.ChunkIDs,
+			Removed: event.Removed,
+		})
+
+	case eventPeerFile:
+		return s.registry.AddPeerToFile(event.FileID, event.PeerID)
+
+	case eventVoteSession:
+		if _, err := s.quorumManager.GetVoteSession(event.FileID, event.ClientID); err == nil {
+			return nil
+		}
+		return s.createVoteSession(event.FileID, event.ClientID)
+
+	case eventVote:
+		if event.Vote == nil {
+			return fmt.Errorf("vote is not signed")
+		}
+		if event.Vote.FileID != event.FileID || event.Vote.ClientID != event.ClientID ||
+			event.Vote.ValidatorID != event.PeerID || event.Vote.Approved != event.Approved {
+			return fmt.Errorf("vote does not match its signed vote")
+		}
+		if err := s.checkVote(event.Vote); err != nil {
+			return err
+		}
+		if err := s.quorumManager.SubmitVote(event.FileID, event.ClientID, event.PeerID, event.Approved); err != nil {
+			return err
+		}
+		s.updateDecision(event.FileID, event.ClientID)
+		return nil
+
+	case eventFileReported:
+		s.addReport(event.FileID, fileReport{ReporterID: event.PeerID, Reason: event.Reason, Time: event.Time})
+		return nil
+
+	case eventStake, eventUnstake:
+		change, err := s.stakeChange(event.Request, event.Kind)
+		if err != nil {
+			return err
+		}
+		return s.applyStake(change)
+
+	case eventFaucet, eventDeposit:
+		credit, err := s.checkCredit(event)
+		if err != nil {
+			return err
+		}
+		return s.applyCredit(credit)
+
+	case eventChannelOpen, eventChannelSettle:
+		return s.checkChannel(event)
+
+	case eventUsage:
+		return s.applyUsage(event)
+
+	case eventEvidence:
+		if event.Evidence == nil {
+			return fmt.Errorf("evidence missing")
+		}
+		if err := s.checkEvidence(event.Evidence); err != nil {
+			return err
+		}
+		s.addEvidence(*event.Evidence)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown event kind: %s", event.Kind)
+	}
+}
+
+func (s *IntegratedServer) handleState(_ *overlay.Request) (*overlay.Response, error) {
+	state := stateSnapshot{
+		Validators: s.quorumManager.Validators(),
+		Files:      s.registry.GetAllFiles(),
+		PeerChunks: s.registry.GetAllPeerChunks(),
+		Blacklist:  s.registry.Blacklist(),
+	}
+	s.modMu.Lock()
+	for _, c := range s.moderation {
+		if c.State == caseOpen {
+			state.Moderation = append(state.Moderation, *c)
+		}
+	}
+	s.modMu.Unlock()
+	s.slashMu.Lock()
+	for _, c := range s.slashing {
+		if c.State == caseOpen {
+			state.Slashing = append(state.Slashing, *c)
+		}
+	}
+	s.slashMu.Unlock()
+	for _, session := range s.quorumManager.GetPendingSessions() {
+		state.Sessions = append(state.Sessions, sessionSnapshot{
+			FileID:   session.FileID,
+			ClientID: session.ClientID,
+			Votes:    session.GetVotes(),
+		})
+	}
+
+	resp, err := overlay.MarshalJSON(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// syncState catches up with the network by loading the state of the first
+// peer that provides it
+func (s *IntegratedServer) syncState() {
+	for _, peerID := range s.overlay.Peers() {
+		ctx, cancel := context.WithTimeout(s.ctx, replicationTimeout)
+		resp, err := s.overlay.SendMessage(ctx, peerID, &overlay.Request{
+			Method: "GET",
+			Path:   statePath,
+		})
+		cancel()
+		if err != nil || resp.StatusCode != 200 {
+			continue
+		}
+
+		var state stateSnapshot
+		if err := json.Unmarshal(resp.Body, &state); err != nil {
+			log.Printf("Invalid state from peer %s: %v", peerID, err)
+			continue
+		}
+		s.loadState(&state)
+		log.Printf("Synchronized validator state from peer %s", peerID)
+		return
+	}
+}
+
+// loadState merges a peer's state snapshot into the local state
+func (s *IntegratedServer) loadState(state *stateSnapshot) {
+	for _, validatorID := range state.Validators {
+		s.quorumManager.RegisterValidator(validatorID)
+	}
+	for _, file := range state.Files {
+		if err := s.registry.RegisterFile(file); err != nil {
+			log.Printf("Failed to load file %s: %v", file.ID, err)
+		}
+	}
+	for _, info := range state.PeerChunks {
+		if info.Seq == 0 {
+			s.registry.RegisterPeerChunks(info.PeerID, info.Address, info.ChunkIDs)
+			continue
+		}
+		if err := s.registry.SyncPeerChunks(info.PeerID, info.Address, info.Seq, info.ChunkIDs); err != nil {
+			log.Printf("Failed to load chunks of peer %s: %v", info.PeerID, err)
+		}
+	}
+	for _, fileID := range state.Blacklist {
+		if err := s.registry.RemoveFile(fileID); err != nil {
+			log.Printf("Failed to blacklist file %s: %v", fileID, err)
+		}
+	}
+	for _, session := range state.Sessions {
+		if _, err := s.quorumManager.GetVoteSession(session.FileID, session.ClientID); err != nil {
+			if err := s.quorumManager.CreateVoteSession(session.FileID, session.ClientID); err != nil {
+				log.Printf("Failed to load vote session for %s: %v", session.FileID, err)
+				continue
+			}
+		}
+		for _, vote := range session.Votes {
+			if err := s.quorumManager.SubmitVote(session.FileID, session.ClientID, vote.ValidatorID, vote.Approved); err != nil {
+				log.Printf("Failed to load vote of %s: %v", vote.ValidatorID, err)
+			}
+		}
+	}
+
+	s.modMu.Lock()
+	for _, c := range state.Moderation {
+		if _, exists := s.moderation[c.FileID]; !exists {
+			c := c
+			s.moderation[c.FileID] = &c
+		}
+	}
+	s.modMu.Unlock()
+
+	s.slashMu.Lock()
+	defer s.slashMu.Unlock()
+	for _, c := range state.Slashing {
+		if _, exists := s.slashing[c.ValidatorID]; !exists {
+			c := c
+			s.slashing[c.ValidatorID] = &c
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/contacts"
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/ledger"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/peer"
+	"github.com/VetheonGames/FileZap/Client/pkg/policy"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/bloom"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mesh connects in-memory adapters by node ID
+type mesh struct {
+	mu            sync.RWMutex
+	nodes         map[string]overlay.Adapter
+	notifications []notification
+}
+
+// notification is a NotifyPeer call made through a mesh
+type notification struct {
+	peerID string
+	action string
+	data   map[string]string
+}
+
+// meshAdapter delivers requests straight to the handlers of other nodes in
+// its mesh
+type meshAdapter struct {
+	overlay.Adapter
+	id   string
+	mesh *mesh
+}
+
+func (a *meshAdapter) GetNodeID() string { return a.id }
+
+func (a *meshAdapter) Peers() []string {
+	a.mesh.mu.RLock()
+	defer a.mesh.mu.RUnlock()
+
+	var peers []string
+	for id := range a.mesh.nodes {
+		if id != a.id {
+			peers = append(peers, id)
+		}
+	}
+	return peers
+}
+
+func (a *meshAdapter) SendMessage(_ context.Context, peerID string, req *overlay.Request) (*overlay.Response, error) {
+	a.mesh.mu.RLock()
+	node, ok := a.mesh.nodes[peerID]
+	a.mesh.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown peer %s", peerID)
+	}
+	return node.HandleRequest(req)
+}
+
+func (a *meshAdapter) NotifyPeer(peerID string, action string, data map[string]string) error {
+	a.mesh.mu.Lock()
+	defer a.mesh.mu.Unlock()
+	a.mesh.notifications = append(a.mesh.notifications, notification{peerID, action, data})
+	return nil
+}
+
+// sent returns the notifications made through the mesh with action
+func (m *mesh) sent(action string) []notification {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sent []notification
+	for _, n := range m.notifications {
+		if n.action == action {
+			sent = append(sent, n)
+		}
+	}
+	return sent
+}
+
+func newMeshValidator(t *testing.T, m *mesh, id string) *IntegratedServer {
+	t.Helper()
+
+	base, err := overlay.NewBasicAdapter(context.Background())
+	require.NoError(t, err)
+	dir := t.TempDir()
+	reg, err := registry.NewRegistry(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { reg.Close() })
+	audit, err := openAuditLog(filepath.Join(dir, auditFile))
+	require.NoError(t, err)
+	t.Cleanup(func() { audit.close() })
+	book, err := ledger.Open(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { book.Close() })
+	voteAudit, err := quorum.OpenAuditLog(filepath.Join(dir, quorum.AuditFile))
+	require.NoError(t, err)
+	t.Cleanup(func() { voteAudit.Close() })
+	quorumManager := quorum.NewQuorumManager(300, 3)
+	quorumManager.SetAuditLog(voteAudit)
+	require.NoError(t, quorumManager.EnablePersistence(dir))
+	keyManager := keymanager.NewKeyManager(3)
+	require.NoError(t, keyManager.EnablePersistence(dir))
+	contactBook, err := contacts.Open(dir)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := &IntegratedServer{
+		ctx:           ctx,
+		cancel:        cancel,
+		peerManager:   peer.NewManager(300),
+		registry:      reg,
+		audit:         audit,
+		ledger:        book,
+		usage:         policy.New(policy.Policy{}),
+		contacts:      contactBook,
+		keyManager:    keyManager,
+		voteAudit:     voteAudit,
+		quorumManager: quorumManager,
+		overlay:       &meshAdapter{Adapter: base, id: id, mesh: m},
+		nodeID:        id,
+		dataDir:       dir,
+		isValidator:   true,
+		seenEvents:    make(map[string]bool),
+		moderation:    make(map[string]*moderationCase),
+		slashing:      make(map[string]*slashCase),
+		challenges:    make(map[string]int64),
+		peerStatus:    make(map[string]peerStatusSeen),
+		seenRequests:  make(map[string]int64),
+		signedVotes:   make(map[string]SignedVote),
+		signedPaths:   make(map[string]bool),
+		limiter:       newLimiter(),
+		channelsOut:   make(map[string]*outChannel),
+		channelsIn:    make(map[string]*inChannel),
+		admissionBits: DefaultAdmissionBits,
+		drainTimeout:  DefaultDrainTimeout,
+	}
+	s.setupHandlers()
+
+	m.mu.Lock()
+	m.nodes[id] = s.overlay
+	m.mu.Unlock()
+	return s
+}
+
+// newKeyedValidator returns a mesh validator whose ID is the peer ID of its
+// key, so the events it publishes are signed
+func newKeyedValidator(t *testing.T, m *mesh) *IntegratedServer {
+	t.Helper()
+	key, id := newValidatorKey(t)
+	s := newMeshValidator(t, m, id)
+	s.peerKey = key
+	return s
+}
+
+func TestValidatorReplication(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	validators := []*IntegratedServer{
+		newKeyedValidator(t, m),
+		newKeyedValidator(t, m),
+		newKeyedValidator(t, m),
+	}
+	for _, v := range validators {
+		for _, other := range validators {
+			v.quorumManager.RegisterValidator(other.nodeID)
+		}
+	}
+
+	origin := validators[0]
+	require.NoError(t, origin.RegisterFile(&FileInfo{ID: "file1", Name: "a.zap"}))
+	origin.registry.RegisterPeerChunks("host", "addr", []string{"chunk1"})
+	origin.publish(&replicationEvent{Kind: eventPeerChunks, PeerID: "host", Address: "addr", ChunkIDs: []string{"chunk1"}})
+	require.NoError(t, origin.quorumManager.CreateVoteSession("file1", "client"))
+	origin.publish(&replicationEvent{Kind: eventVoteSession, FileID: "file1", ClientID: "client"})
+
+	for _, v := range validators[1:] {
+		v := v
+		assert.Eventually(t, func() bool {
+			_, hasFile := v.registry.GetFileByName("a.zap")
+			_, sessionErr := v.quorumManager.GetVoteSession("file1", "client")
+			return hasFile && len(v.registry.GetPeersForChunk("chunk1")) == 1 && sessionErr == nil
+		}, 2*time.Second, 10*time.Millisecond, "validator %s did not receive the changes", v.nodeID)
+	}
+
+	// Votes cast anywhere count everywhere
+	require.NoError(t, validators[1].CastVote("file1", "client", true))
+	assert.Eventually(t, func() bool {
+		session, err := validators[2].quorumManager.GetVoteSession("file1", "client")
+		return err == nil && len(session.GetVotes()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// A validator joining later catches up from a peer
+	late := newMeshValidator(t, m, "v4")
+	late.syncState()
+	_, ok := late.registry.GetFileByID("file1")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"host"}, late.registry.GetPeersForChunk("chunk1"))
+	assert.True(t, late.quorumManager.IsValidator(origin.nodeID))
+	session, err := late.quorumManager.GetVoteSession("file1", "client")
+	require.NoError(t, err)
+	assert.Len(t, session.GetVotes(), 1)
+}
+
+func TestReplicationRejectsUnknownOrigin(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	v := newMeshValidator(t, m, "v1")
+
+	resp, err := v.overlay.HandleRequest(&overlay.Request{
+		Method: "POST",
+		Path:   replicatePath,
+		Body:   []byte(`{"id":"x/1","origin":"x","kind":"file_registered","file":{"id":"f","name":"f.zap"}}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+	_, ok := v.registry.GetFileByID("f")
+	assert.False(t, ok)
+}
+
+func TestReplicationRejectsForgedEvents(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	v := newKeyedValidator(t, m)
+	v.EnableFaucet(10)
+	originKey, origin := newValidatorKey(t)
+	otherKey, _ := newValidatorKey(t)
+	validatorKey, validator := newValidatorKey(t)
+	for _, id := range []string{origin, validator} {
+		v.quorumManager.RegisterValidator(id)
+	}
+
+	// replicate sends v an event from origin signed with key
+	var seq int
+	replicate := func(key crypto.PrivKey, event *replicationEvent) int {
+		seq++
+		event.Origin = origin
+		event.ID = fmt.Sprintf("%s/%d", origin, seq)
+		data, err := event.signedBytes()
+		require.NoError(t, err)
+		event.Signature, err = key.Sign(data)
+		require.NoError(t, err)
+		body, err := json.Marshal(event)
+		require.NoError(t, err)
+		resp, err := v.overlay.HandleRequest(&overlay.Request{Method: "POST", Path: replicatePath, Body: body})
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	signed := func(key crypto.PrivKey, path string, body interface{}) json.RawMessage {
+		req, err := NewSignedRequest(key, "POST", path, body)
+		require.NoError(t, err)
+		return req.Body
+	}
+
+	// Only the origin signs its events
+	file := &registry.FileInfo{ID: "f", Name: "f.zap"}
+	assert.Equal(t, 401, replicate(otherKey, &replicationEvent{Kind: eventFileRegistered, File: file}))
+	_, ok := v.registry.GetFileByID("f")
+	assert.False(t, ok)
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventFileRegistered, File: file}))
+	_, ok = v.registry.GetFileByID("f")
+	assert.True(t, ok)
+
+	// Credits are made only for their account's claim, and for the amount
+	// the validator grants
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventFaucet, ClientID: validator, Amount: 1000, Key: "faucet/x"}))
+	forgedClaim := signed(otherKey, "/account/faucet", map[string]string{"account": validator})
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventFaucet, ClientID: validator, Amount: 1000, Request: forgedClaim}))
+	assert.Zero(t, v.ledger.Balance(validator))
+	claim := signed(validatorKey, "/account/faucet", map[string]string{"account": validator})
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventFaucet, ClientID: validator, Amount: 1000, Request: claim}))
+	assert.Equal(t, int64(10), v.ledger.Balance(validator))
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventDeposit, ClientID: validator, Amount: 1000, Key: "deposit/x"}))
+	assert.Equal(t, int64(10), v.ledger.Balance(validator))
+
+	// Stake moves only at its validator's request
+	stake := map[string]interface{}{"validator_id": validator, "amount": 5}
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventStake, Request: signed(validatorKey, "/validator/stake", stake)}))
+	assert.Equal(t, int64(5), v.ledger.StakeOf(validator))
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventUnstake, Request: signed(otherKey, "/validator/unstake", stake)}))
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventUnstake, Request: signed(validatorKey, "/validator/stake", stake)}))
+	assert.Equal(t, int64(5), v.ledger.StakeOf(validator))
+
+	// Channels hold funds only at their payer's signed opening
+	open := &ChannelOpen{ChannelID: channelPrefix + "1", Payer: validator, Payee: "host", Amount: 5}
+	data, err := open.signedBytes()
+	require.NoError(t, err)
+	open.Signature, err = otherKey.Sign(data)
+	require.NoError(t, err)
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventChannelOpen, ClientID: validator, PeerID: "host", Amount: 5, Key: open.ChannelID}))
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventChannelOpen, Open: open}))
+	assert.Equal(t, int64(5), v.ledger.Balance(validator))
+
+	// Votes carry their validator's signed vote
+	require.NoError(t, v.quorumManager.CreateVoteSession("f", "client"))
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventVote, FileID: "f", ClientID: "client", PeerID: validator, Approved: true}))
+	vote := signedVote(t, validatorKey, validator, "f", true)
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventVote, FileID: "f", ClientID: "client", PeerID: origin, Approved: true, Vote: &vote}))
+	session, err := v.quorumManager.GetVoteSession("f", "client")
+	require.NoError(t, err)
+	assert.Empty(t, session.GetVotes())
+	require.Equal(t, 200, replicate(originKey, &replicationEvent{Kind: eventVote, FileID: "f", ClientID: "client", PeerID: validator, Approved: true, Vote: &vote}))
+	assert.Len(t, session.GetVotes(), 1)
+}
+
+func TestChunkAnnouncements(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	v1 := newKeyedValidator(t, m)
+	v2 := newKeyedValidator(t, m)
+	for _, v := range []*IntegratedServer{v1, v2} {
+		v.quorumManager.RegisterValidator(v1.nodeID)
+		v.quorumManager.RegisterValidator(v2.nodeID)
+	}
+
+	hostKey, host := newValidatorKey(t)
+	send := func(path string, body interface{}) *overlay.Response {
+		return postSigned(t, v1, hostKey, path, body)
+	}
+
+	// Only the peer itself may announce its chunks
+	register := types.PeerChunkInfo{PeerID: host, Address: "addr", ChunkIDs: []string{"chunk1", "chunk2"}, Seq: 1}
+	assert.Equal(t, 401, postJSON(t, v1, "/chunks/register", register).StatusCode)
+	assert.Equal(t, 403, postSigned(t, v1, nil, "/chunks/register", register).StatusCode)
+	assert.Equal(t, 403, postSigned(t, v1, nil, "/chunks/delta", types.ChunkDelta{PeerID: host, BaseSeq: 1, Seq: 2, Removed: []string{"chunk1"}}).StatusCode)
+	assert.Equal(t, 403, postSigned(t, v1, nil, "/chunks/summary", types.ChunkSetSummary{PeerID: host, Seq: 1}).StatusCode)
+
+	resp := send("/chunks/register", register)
+	assert.Equal(t, 200, resp.StatusCode)
+	resp = send("/chunks/delta", types.ChunkDelta{PeerID: host, Address: "addr", BaseSeq: 1, Seq: 2, Added: []string{"chunk3"}, Removed: []string{"chunk1"}})
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Both validators follow the deltas
+	for _, v := range []*IntegratedServer{v1, v2} {
+		v := v
+		assert.Eventually(t, func() bool {
+			return v.registry.PeerSeq(host) == 2 &&
+				len(v.registry.GetPeersForChunk("chunk1")) == 0 &&
+				len(v.registry.GetPeersForChunk("chunk3")) == 1
+		}, 2*time.Second, 10*time.Millisecond, "validator %s did not apply the delta", v.nodeID)
+	}
+
+	// A delta after a lost one asks for a full sync
+	resp = send("/chunks/delta", types.ChunkDelta{PeerID: host, BaseSeq: 3, Seq: 4, Added: []string{"chunk4"}})
+	require.Equal(t, 409, resp.StatusCode)
+	var status types.SyncStatus
+	require.NoError(t, json.Unmarshal(resp.Body, &status))
+	assert.Equal(t, uint64(2), status.Seq)
+
+	// A summary drops chunks the peer no longer has
+	resp = send("/chunks/summary", types.ChunkSetSummary{
+		PeerID: host,
+		Seq:    2,
+		Count:  1,
+		Filter: bloom.FromItems([]string{"chunk3"}, types.BloomFalsePositive),
+	})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Empty(t, v1.registry.GetPeersForChunk("chunk2"))
+	assert.Eventually(t, func() bool {
+		return len(v2.registry.GetPeersForChunk("chunk2")) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	resp = send("/chunks/summary", types.ChunkSetSummary{PeerID: host, Seq: 2, Count: 1})
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestChunkRegistrationRetried(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	v := newMeshValidator(t, m, "v1")
+
+	hostKey, host := newValidatorKey(t)
+	otherKey, other := newValidatorKey(t)
+	send := func(key crypto.PrivKey, info types.PeerChunkInfo) *overlay.Response {
+		return postSigned(t, v, key, "/chunks/register", info)
+	}
+
+	resp := send(hostKey, types.PeerChunkInfo{PeerID: host, ChunkIDs: []string{"chunk1"}, IdempotencyKey: "k1"})
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Len(t, v.registry.GetPeersForChunk("chunk1"), 1)
+
+	// A redelivery under the same key is acknowledged but not applied
+	resp = send(hostKey, types.PeerChunkInfo{PeerID: host, ChunkIDs: []string{"chunk2"}, IdempotencyKey: "k1"})
+	require.Equal(t, 200, resp.StatusCode)
+	assert.JSONEq(t, `{"duplicate":true}`, string(resp.Body))
+	assert.Empty(t, v.registry.GetPeersForChunk("chunk2"))
+
+	// Keys are scoped to the registering peer
+	resp = send(otherKey, types.PeerChunkInfo{PeerID: other, ChunkIDs: []string{"chunk2"}, IdempotencyKey: "k1"})
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Len(t, v.registry.GetPeersForChunk("chunk2"), 1)
+}
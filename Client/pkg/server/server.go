@@ -1,19 +1,28 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/VetheonGames/FileZap/Client/pkg/contacts"
+	"github.com/VetheonGames/FileZap/Client/pkg/events"
 	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/ledger"
 	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
 	"github.com/VetheonGames/FileZap/Client/pkg/peer"
+	"github.com/VetheonGames/FileZap/Client/pkg/policy"
 	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
 	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	ncoverlay "github.com/VetheonGames/FileZap/NetworkCore/pkg/overlay"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+	"github.com/libp2p/go-libp2p/core/crypto"
 )
 
 // IntegratedServer represents a FileZap node that acts as both client and master node
@@ -25,10 +34,72 @@ type IntegratedServer struct {
 	keyManager    *keymanager.KeyManager
 	quorumManager *quorum.QuorumManager
 	overlay       overlay.Adapter
+	audit         *auditLog
+	voteAudit     *quorum.AuditLog
 	nodeID        string
-	isValidator   bool    // Whether this node participates in validation
-	balance       float64 // Node's balance for reward system
+	dataDir       string
+	isValidator   bool               // Whether this node participates in validation
+	stopDuties    context.CancelFunc // Ends the duties started on joining validation
+	ledger        *ledger.Ledger     // Balances for the reward system
+	usage         *policy.Engine     // Free download allowances and client usage
+	contacts      *contacts.Book     // Peers files are sent to directly
+	events        *events.Bus        // Nil if nobody listens
 	mu            sync.RWMutex
+
+	// Graceful shutdown
+	draining     bool
+	drainTimeout time.Duration
+	inFlight     sync.WaitGroup
+
+	// Validator state replication
+	replSeq    uint64
+	replMu     sync.Mutex
+	seenEvents map[string]bool
+	seenOrder  []string
+
+	// Reported files, by file ID
+	moderation map[string]*moderationCase
+	modMu      sync.Mutex
+
+	// Validators accused of misbehaving, by validator ID
+	slashing map[string]*slashCase
+	slashMu  sync.Mutex
+
+	// Admission of validators; the settings are guarded by mu
+	peerKey        crypto.PrivKey   // Proves this node's ID, nil if unknown
+	admissionBits  int              // Proof of work asked of new validators
+	admissionStake int64            // Stake admitting validators without work, zero for none
+	challenges     map[string]int64 // Open registration challenges, with their expiry
+	challengeMu    sync.Mutex
+
+	// Signed requests taken, by signature hash, with the time they were
+	// signed; the paths needing them; and the rate each sender may make them
+	seenRequests map[string]int64
+	signedPaths  map[string]bool
+	limiter      *ncoverlay.RateLimiter
+	requestMu    sync.Mutex
+
+	// Signed votes taken, by session and validator
+	signedVotes map[string]SignedVote
+	voteMu      sync.Mutex
+
+	// Last status update taken from each peer
+	peerStatus map[string]peerStatusSeen
+	statusMu   sync.Mutex
+
+	// Payment channels this node pays through, by payee, and is paid
+	// through, by channel ID
+	channelsOut map[string]*outChannel
+	channelsIn  map[string]*inChannel
+	channelMu   sync.Mutex
+
+	// Funding of accounts, guarded by mu
+	faucetAmount    int64           // Granted to each account daily, zero for no faucet
+	depositVerifier DepositVerifier // Confirms chain deposits, nil if none are accepted
+
+	// Where new vote sessions are posted, guarded by mu
+	webhookURL    string
+	webhookSecret []byte
 }
 
 // NewIntegratedServer creates a new integrated client/master node
@@ -42,16 +113,84 @@ func NewIntegratedServer(ctx context.Context, dataDir string, startAsValidator b
 		return nil, err
 	}
 
+	audit, err := openAuditLog(filepath.Join(dataDir, auditFile))
+	if err != nil {
+		cancel()
+		reg.Close()
+		return nil, err
+	}
+
+	accounts, err := ledger.Open(dataDir)
+	if err != nil {
+		cancel()
+		reg.Close()
+		audit.close()
+		return nil, err
+	}
+
+	voteAudit, err := quorum.OpenAuditLog(filepath.Join(dataDir, quorum.AuditFile))
+	if err != nil {
+		cancel()
+		reg.Close()
+		audit.close()
+		accounts.Close()
+		return nil, err
+	}
+	book, err := contacts.Open(dataDir)
+	if err != nil {
+		cancel()
+		reg.Close()
+		audit.close()
+		accounts.Close()
+		voteAudit.Close()
+		return nil, err
+	}
+
+	// Vote sessions and key requests are saved as they change, so clients
+	// that paid for a key are not stranded by a restart
+	quorumManager := quorum.NewQuorumManager(300, 3) // 5 minute timeout, require 3 votes
+	quorumManager.SetAuditLog(voteAudit)
+	keyManager := keymanager.NewKeyManager(3) // Require 3 shares for key reconstruction
+	usage := policy.New(policy.Policy{})
+	for _, restore := range []func(string) error{quorumManager.EnablePersistence, keyManager.EnablePersistence, usage.EnablePersistence} {
+		if err := restore(dataDir); err != nil {
+			cancel()
+			reg.Close()
+			audit.close()
+			accounts.Close()
+			voteAudit.Close()
+			return nil, err
+		}
+	}
+
 	server := &IntegratedServer{
 		ctx:           ctx,
 		cancel:        cancel,
 		peerManager:   peer.NewManager(300), // 5 minute timeout
 		registry:      reg,
-		keyManager:    keymanager.NewKeyManager(3),     // Require 3 shares for key reconstruction
-		quorumManager: quorum.NewQuorumManager(300, 3), // 5 minute timeout, require 3 votes
+		audit:         audit,
+		voteAudit:     voteAudit,
+		keyManager:    keyManager,
+		quorumManager: quorumManager,
 		nodeID:        "",
+		dataDir:       dataDir,
 		isValidator:   startAsValidator,
-		balance:       0.0, // Initial balance
+		ledger:        accounts,
+		usage:         usage,
+		contacts:      book,
+		seenEvents:    make(map[string]bool),
+		moderation:    make(map[string]*moderationCase),
+		slashing:      make(map[string]*slashCase),
+		challenges:    make(map[string]int64),
+		peerStatus:    make(map[string]peerStatusSeen),
+		seenRequests:  make(map[string]int64),
+		signedVotes:   make(map[string]SignedVote),
+		signedPaths:   make(map[string]bool),
+		limiter:       newLimiter(),
+		channelsOut:   make(map[string]*outChannel),
+		channelsIn:    make(map[string]*inChannel),
+		admissionBits: DefaultAdmissionBits,
+		drainTimeout:  DefaultDrainTimeout,
 	}
 
 	// Initialize overlay network
@@ -80,20 +219,27 @@ func (s *IntegratedServer) joinValidatorNetwork() error {
 	// Register as validator in quorum manager
 	s.quorumManager.RegisterValidator(s.nodeID)
 
-	// Start periodic validation duty checks
-	go s.runValidationDuties()
+	// Catch up with the other validators' state
+	go s.syncState()
+
+	// Start periodic validation duty checks, until the node leaves
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.mu.Lock()
+	s.stopDuties = cancel
+	s.mu.Unlock()
+	go s.runValidationDuties(ctx)
 
 	return nil
 }
 
 // runValidationDuties performs periodic validation tasks
-func (s *IntegratedServer) runValidationDuties() {
+func (s *IntegratedServer) runValidationDuties(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-s.ctx.Done():
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			s.checkPendingValidations()
@@ -114,32 +260,85 @@ func (s *IntegratedServer) checkPendingValidations() {
 	// Get pending requests from quorum manager
 	sessions := s.quorumManager.GetPendingSessions()
 	for _, session := range sessions {
+		// Removal and slashing votes are left to each validator's operator
+		if session.ClientID == moderationClient || session.ClientID == slashClient {
+			continue
+		}
+		if !session.OnCommittee(s.nodeID) {
+			continue
+		}
+
 		// Verify the request
-		if s.verifyKeyRequest(session.FileID, session.ClientID) {
-			if err := s.quorumManager.SubmitVote(session.FileID, session.ClientID, s.nodeID, true); err != nil {
-				log.Printf("Failed to submit vote for session %s: %v", session.FileID, err)
-			}
+		approve, decided := s.verifyKeyRequest(session.FileID, session.ClientID)
+		if !decided {
+			continue
 		}
+		if err := s.quorumManager.SubmitVote(session.FileID, session.ClientID, s.nodeID, approve); err != nil {
+			log.Printf("Failed to submit vote for session %s: %v", session.FileID, err)
+			continue
+		}
+		s.publish(&replicationEvent{
+			Kind:     eventVote,
+			FileID:   session.FileID,
+			ClientID: session.ClientID,
+			PeerID:   s.nodeID,
+			Approved: approve,
+			Vote:     s.signVote(session.FileID, session.ClientID, approve),
+		})
+		s.updateKeyRequest(session.FileID, session.ClientID)
 	}
 }
 
-// verifyKeyRequest validates a key request
-func (s *IntegratedServer) verifyKeyRequest(fileID string, _ string) bool {
+// verifyKeyRequest decides this validator's vote on a key request. decided
+// is false while the request cannot be decided yet.
+func (s *IntegratedServer) verifyKeyRequest(fileID, clientID string) (approve, decided bool) {
 	// Get file info
 	file, exists := s.registry.GetFileByID(fileID)
 	if !exists {
-		return false
+		return false, false
+	}
+
+	// Clients outside the file's access list are refused outright
+	if !s.allowedKeyRequest(file, clientID) {
+		return false, true
 	}
 
 	// Verify file exists and meets minimum replication
 	peers := s.registry.GetPeersForFile(file.ID)
 	if len(peers) < file.ReplicationGoal {
-		return false // File is not sufficiently replicated yet
+		return false, false // File is not sufficiently replicated yet
+	}
+
+	return true, true
+}
+
+// allowedKeyRequest reports whether a file's access list allows a client
+// its key, identified by its ID or the public key it requested it with
+func (s *IntegratedServer) allowedKeyRequest(file *registry.FileInfo, clientID string) bool {
+	if file.ACL == nil {
+		return true
 	}
+	var publicKey []byte
+	if req, err := s.keyManager.GetKeyRequest(file.ID, clientID); err == nil {
+		publicKey = req.PublicKey
+	}
+	return allowsKey(file.ACL, clientID, publicKey)
+}
 
-	// Check if client has proper permissions
-	// For now, implement basic verification. Can be expanded later.
-	return true
+// allowsKey reports whether an access list lets a client have a file's key
+// sealed to publicKey. Listed keys may always be used, while clients listed
+// by ID only have it sealed to their own peer key, so a listed client cannot
+// pass the key to someone outside the list.
+func allowsKey(acl *types.AccessList, clientID string, publicKey []byte) bool {
+	now := time.Now()
+	if acl.Allows("", publicKey, now) {
+		return true
+	}
+	if !acl.Allows(clientID, nil, now) {
+		return false
+	}
+	own, err := peerSealKey(clientID)
+	return err == nil && bytes.Equal(own, publicKey)
 }
 
 // maintainReplication ensures proper file replication
@@ -189,21 +388,66 @@ func contains(slice []string, str string) bool {
 // setupHandlers configures all the overlay network handlers
 func (s *IntegratedServer) setupHandlers() {
 	// Register basic peer management handlers
-	s.overlay.HandleFunc("POST", "/peer/register", s.handlePeerRegister)
-	s.overlay.HandleFunc("POST", "/peer/status", s.handlePeerStatus)
+	s.handle("GET", challengePath, s.handlePeerChallenge)
+	s.handle("POST", "/peer/register", s.handlePeerRegister)
+	s.handleSigned("POST", "/peer/unregister", s.handlePeerUnregister)
+	s.handle("POST", "/peer/status", s.handlePeerStatus)
 
 	// Register file operation handlers
-	s.overlay.HandleFunc("POST", "/file/register", s.handleFileRegister)
-	s.overlay.HandleFunc("GET", "/file/info/{name}", s.handleFileInfo)
+	s.handle("POST", "/file/register", s.handleFileRegister)
+	s.handle("GET", "/file/info/{name}", s.handleFileInfo)
+	s.handle("POST", "/file/resolve", s.handleFileResolve)
+	s.handle("GET", "/file/list", s.handleFileList)
+	s.handle("POST", "/file/report", s.handleFileReport)
+	s.handle("POST", "/contact/send", s.handleContactSend)
+
+	// Register moderation handlers
+	s.handle("GET", "/moderation/queue", s.handleModerationQueue)
+	s.handleSigned("POST", "/moderation/vote", s.handleModerationVote)
+
+	// Register staking handlers
+	s.handleSigned("POST", "/validator/stake", s.handleStake)
+	s.handleSigned("POST", "/validator/unstake", s.handleUnstake)
+	s.handle("POST", "/validator/evidence", s.handleEvidence)
+	s.handle("GET", "/validator/slashing", s.handleSlashingQueue)
+	s.handleSigned("POST", "/validator/slash/vote", s.handleSlashVote)
 
 	// Register key management handlers
-	s.overlay.HandleFunc("POST", "/key/request", s.handleKeyRequest)
-	s.overlay.HandleFunc("POST", "/key/vote", s.handleKeyVote)
-	s.overlay.HandleFunc("GET", "/key/share", s.handleKeyShare)
+	s.handle("POST", "/key/register", s.handleKeyRegister)
+	s.handle("POST", "/key/rotate", s.handleKeyRotate)
+	s.handleSigned("POST", "/key/request", s.handleKeyRequest)
+	s.handle("GET", "/key/request/{id}", s.handleKeyRequestStatus)
+	s.handleSigned("POST", "/key/vote", s.handleKeyVote)
+	s.handleSigned("POST", "/key/deliver", s.handleKeyDeliver)
+	s.handleSigned("POST", "/key/share", s.handleKeyShare)
+
+	// Register account handlers
+	s.handle("GET", "/account/history", s.handleAccountHistory)
+	s.handle("POST", "/payment/receipt", s.handlePaymentReceipt)
+	s.handle("POST", "/account/earnings", s.handleAccountEarnings)
+	s.handle("POST", "/account/balance", s.handleAccountBalance)
+	s.handleSigned("POST", "/account/faucet", s.handleFaucet)
+	s.handleSigned("POST", "/account/deposit", s.handleDeposit)
+	s.handle("POST", usagePath, s.handleUsage)
+
+	// Register payment channel handlers
+	s.handle("POST", "/channel/open", s.handleChannelOpen)
+	s.handle("POST", "/channel/settle", s.handleChannelSettle)
+	s.handle("POST", "/channel/update", s.handleChannelUpdate)
+
+	// Register vote audit handlers
+	s.handle("GET", "/audit/votes", s.handleVoteAudit)
+	s.handle("GET", "/audit/head", s.handleVoteAuditHead)
 
 	// Register chunk management handlers
-	s.overlay.HandleFunc("POST", "/chunks/register", s.handleChunksRegister)
-	s.overlay.HandleFunc("GET", "/chunks/peers/{id}", s.handleGetChunkPeers)
+	s.handleSigned("POST", "/chunks/register", s.handleChunksRegister)
+	s.handleSigned("POST", "/chunks/delta", s.handleChunksDelta)
+	s.handleSigned("POST", "/chunks/summary", s.handleChunksSummary)
+	s.handle("GET", "/chunks/peers/{id}", s.handleGetChunkPeers)
+	s.handle("GET", "/chunks/list", s.handleChunksList)
+
+	// Register validator replication handlers
+	s.setupReplication()
 }
 
 // Start begins the integrated server operations
@@ -221,13 +465,26 @@ func (s *IntegratedServer) Start() error {
 	// Start manifest replication monitoring
 	go s.monitorManifestReplication()
 
+	// Settle key requests decided or expired while the node was down, then
+	// start expiring undecided key requests and their payments
+	s.resumeKeyRequests()
+	go s.expireKeyRequests()
+	go s.refundExpiredPayments()
+	go s.settleIdleChannels()
+
 	return nil
 }
 
-// Stop gracefully shuts down the integrated server
+// Stop gracefully shuts down the integrated server, waiting up to its
+// drain timeout for requests in flight
 func (s *IntegratedServer) Stop() error {
-	s.cancel()
-	return s.overlay.Close()
+	s.mu.RLock()
+	timeout := s.drainTimeout
+	s.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(ctx)
 }
 
 // GetNodeID returns the node's peer ID
@@ -236,41 +493,33 @@ func (s *IntegratedServer) GetNodeID() string {
 }
 
 // Handler implementations
+
+// handlePeerRegister admits a validator to the quorum, given an answer to a
+// challenge from this node that proves its ID
 func (s *IntegratedServer) handlePeerRegister(r *overlay.Request) (*overlay.Response, error) {
-	var req struct {
-		ValidatorID string `json:"validator_id"`
-	}
-	if err := r.UnmarshalJSON(&req); err != nil {
+	var req Registration
+	if err := r.UnmarshalJSON(&req); err != nil || req.ValidatorID == "" {
 		return &overlay.Response{
 			StatusCode: 400,
 			Body:       []byte(`{"error":"Invalid request body"}`),
 		}, nil
 	}
-
-	// Register with quorum manager
-	s.quorumManager.RegisterValidator(req.ValidatorID)
-
-	return &overlay.Response{StatusCode: 200}, nil
-}
-
-func (s *IntegratedServer) handlePeerStatus(r *overlay.Request) (*overlay.Response, error) {
-	var req struct {
-		PeerID        string   `json:"peer_id"`
-		AvailableZaps []string `json:"available_zaps"`
+	if !s.takeChallenge(req.Challenge) {
+		return &overlay.Response{
+			StatusCode: 401,
+			Body:       []byte(`{"error":"Invalid or expired challenge"}`),
+		}, nil
 	}
-	if err := r.UnmarshalJSON(&req); err != nil {
+	if err := s.checkRegistration(&req); err != nil {
+		body, _ := overlay.MarshalJSON(map[string]string{"error": fmt.Sprintf("Registration refused: %v", err)})
 		return &overlay.Response{
-			StatusCode: 400,
-			Body:       []byte(`{"error":"Invalid request body"}`),
+			StatusCode: 403,
+			Body:       body,
 		}, nil
 	}
 
-	s.peerManager.UpdatePeer(req.PeerID, "", req.AvailableZaps)
-	for _, zapID := range req.AvailableZaps {
-		if err := s.registry.AddPeerToFile(zapID, req.PeerID); err != nil {
-			log.Printf("Failed to update peer-file association: %v", err)
-		}
-	}
+	// Register with quorum manager
+	s.quorumManager.RegisterValidator(req.ValidatorID)
 
 	return &overlay.Response{StatusCode: 200}, nil
 }
@@ -284,12 +533,20 @@ func (s *IntegratedServer) handleFileRegister(r *overlay.Request) (*overlay.Resp
 		}, nil
 	}
 
+	if s.registry.IsBlacklisted(fileInfo.ID) {
+		return &overlay.Response{
+			StatusCode: 410,
+			Body:       []byte(`{"error":"File has been removed"}`),
+		}, nil
+	}
+
 	if err := s.registry.RegisterFile(&fileInfo); err != nil {
 		return &overlay.Response{
 			StatusCode: 500,
 			Body:       []byte(`{"error":"Failed to register file"}`),
 		}, nil
 	}
+	s.publish(&replicationEvent{Kind: eventFileRegistered, File: &fileInfo})
 
 	availablePeers := s.peerManager.GetAllPeers()
 
@@ -338,10 +595,89 @@ func (s *IntegratedServer) handleFileInfo(r *overlay.Request) (*overlay.Response
 	}, nil
 }
 
+func (s *IntegratedServer) handleFileList(r *overlay.Request) (*overlay.Response, error) {
+	opts, resp := listOptions(r, "name", "size", "chunks")
+	if resp != nil {
+		return resp, nil
+	}
+
+	files, total := s.registry.ListFiles(opts)
+	_, _, next := opts.Page(total)
+
+	body, err := overlay.MarshalJSON(map[string]interface{}{
+		"files":       files,
+		"total":       total,
+		"next_offset": next,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       body,
+	}, nil
+}
+
+func (s *IntegratedServer) handleKeyRegister(r *overlay.Request) (*overlay.Response, error) {
+	var req struct {
+		FileID string `json:"file_id"`
+		Key    []byte `json:"key"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil || req.FileID == "" || len(req.Key) == 0 {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	// Give each validator a share of the key
+	validators := s.quorumManager.Validators()
+	sort.Strings(validators)
+	shares, err := s.keyManager.GenerateKeyShares(req.FileID, req.Key, validators)
+	if errors.Is(err, keymanager.ErrKeyRevoked) {
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Key has been revoked"}`),
+		}, nil
+	}
+	if errors.Is(err, keymanager.ErrKeyExists) {
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Key already registered"}`),
+		}, nil
+	}
+	if err != nil {
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Not enough validators to share key"}`),
+		}, nil
+	}
+
+	commitments, err := s.keyManager.GetCommitments(req.FileID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := overlay.MarshalJSON(map[string]interface{}{
+		"status":      "success",
+		"shares":      len(shares),
+		"commitments": commitments,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// handleKeyRequest opens a signed request for a file's key, charged to the
+// signer
 func (s *IntegratedServer) handleKeyRequest(r *overlay.Request) (*overlay.Response, error) {
 	var req struct {
 		FileID    string `json:"file_id"`
-		ClientID  string `json:"client_id"`
 		PublicKey []byte `json:"public_key"`
 	}
 	if err := r.UnmarshalJSON(&req); err != nil {
@@ -351,9 +687,25 @@ func (s *IntegratedServer) handleKeyRequest(r *overlay.Request) (*overlay.Respon
 		}, nil
 	}
 
+	if s.isDraining() {
+		return drainingResponse(), nil
+	}
+	if s.registry.IsBlacklisted(req.FileID) {
+		return &overlay.Response{
+			StatusCode: 410,
+			Body:       []byte(`{"error":"File has been removed"}`),
+		}, nil
+	}
+	if file, exists := s.registry.GetFileByID(req.FileID); exists && !allowsKey(file.ACL, r.PeerID, req.PublicKey) {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Client is not allowed to access this file"}`),
+		}, nil
+	}
+
 	keyReq := &keymanager.KeyRequest{
 		FileID:      req.FileID,
-		ClientID:    req.ClientID,
+		ClientID:    r.PeerID,
 		PublicKey:   req.PublicKey,
 		RequestTime: time.Now().Unix(),
 	}
@@ -365,29 +717,58 @@ func (s *IntegratedServer) handleKeyRequest(r *overlay.Request) (*overlay.Respon
 		}, nil
 	}
 
-	if err := s.quorumManager.CreateVoteSession(req.FileID, req.ClientID); err != nil {
+	// Hold the download's price until the chunks are delivered
+	if err := s.holdPayment(keyReq, r.Signed); err != nil {
+		if _, stateErr := s.keyManager.SetRequestState(keyReq.ID, keymanager.RequestDenied); stateErr != nil {
+			log.Printf("Failed to close unpaid key request: %v", stateErr)
+		}
+		if errors.Is(err, ledger.ErrInsufficientFunds) {
+			return &overlay.Response{
+				StatusCode: 402,
+				Body:       []byte(`{"error":"Insufficient funds"}`),
+			}, nil
+		}
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to hold payment"}`),
+		}, nil
+	}
+
+	if err := s.createVoteSession(req.FileID, r.PeerID); err != nil {
 		return &overlay.Response{
 			StatusCode: 500,
 			Body:       []byte(`{"error":"Failed to create vote session"}`),
 		}, nil
 	}
+	s.publish(&replicationEvent{Kind: eventVoteSession, FileID: req.FileID, ClientID: r.PeerID})
+	s.pushVoteSession(req.FileID, r.PeerID)
+
+	resp, err := overlay.MarshalJSON(map[string]string{
+		"request_id": keyReq.ID,
+		"state":      keymanager.RequestPending,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return &overlay.Response{StatusCode: 202}, nil
+	return &overlay.Response{
+		StatusCode: 202,
+		Body:       resp,
+	}, nil
 }
 
+// handleKeyVote records a validator's signed vote on a key request
 func (s *IntegratedServer) handleKeyVote(r *overlay.Request) (*overlay.Response, error) {
-	var req struct {
-		FileID      string `json:"file_id"`
-		ClientID    string `json:"client_id"`
-		ValidatorID string `json:"validator_id"`
-		Approved    bool   `json:"approved"`
-	}
-	if err := r.UnmarshalJSON(&req); err != nil {
+	var req SignedVote
+	if err := r.UnmarshalJSON(&req); err != nil || req.ClientID == moderationClient || req.ClientID == slashClient {
 		return &overlay.Response{
 			StatusCode: 400,
 			Body:       []byte(`{"error":"Invalid request body"}`),
 		}, nil
 	}
+	if err := s.checkVote(&req); err != nil {
+		return voteError(err), nil
+	}
 
 	if err := s.quorumManager.SubmitVote(req.FileID, req.ClientID, req.ValidatorID, req.Approved); err != nil {
 		return &overlay.Response{
@@ -395,6 +776,15 @@ func (s *IntegratedServer) handleKeyVote(r *overlay.Request) (*overlay.Response,
 			Body:       []byte(`{"error":"Failed to submit vote"}`),
 		}, nil
 	}
+	s.publish(&replicationEvent{
+		Kind:     eventVote,
+		FileID:   req.FileID,
+		ClientID: req.ClientID,
+		PeerID:   req.ValidatorID,
+		Approved: req.Approved,
+		Vote:     &req,
+	})
+	s.updateKeyRequest(req.FileID, req.ClientID)
 
 	approved, err := s.quorumManager.CheckQuorum(req.FileID, req.ClientID)
 	if err != nil {
@@ -415,17 +805,118 @@ func (s *IntegratedServer) handleKeyVote(r *overlay.Request) (*overlay.Response,
 	}, nil
 }
 
+// handleKeyDeliver gives an approved client, the signer of the request, the
+// file key sealed to the public key it submitted with its key request.
+// Every delivery is audited.
+func (s *IntegratedServer) handleKeyDeliver(r *overlay.Request) (*overlay.Response, error) {
+	var req struct {
+		FileID string `json:"file_id"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	keyReq, err := s.keyManager.GetKeyRequest(req.FileID, r.PeerID)
+	if err != nil {
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"Key request not found"}`),
+		}, nil
+	}
+
+	// Denied and expired requests stay closed whatever later votes say
+	finished := keyReq.State == keymanager.RequestDenied || keyReq.State == keymanager.RequestExpired
+	approved, err := s.quorumManager.CheckQuorum(req.FileID, r.PeerID)
+	if finished || err != nil || !approved {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Key request not approved"}`),
+		}, nil
+	}
+
+	// Access may have expired since the quorum approved
+	if file, exists := s.registry.GetFileByID(req.FileID); exists && !allowsKey(file.ACL, r.PeerID, keyReq.PublicKey) {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Client is not allowed to access this file"}`),
+		}, nil
+	}
+
+	key, err := s.keyManager.ReconstructKey(req.FileID)
+	if err != nil {
+		log.Printf("Failed to reconstruct key for %s: %v", req.FileID, err)
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to reconstruct key"}`),
+		}, nil
+	}
+
+	sealed, err := keymanager.SealKey(key, keyReq.PublicKey)
+	if err != nil {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid client public key"}`),
+		}, nil
+	}
+
+	// Record the delivery before releasing the key
+	record := &deliveryRecord{
+		FileID:    req.FileID,
+		ClientID:  r.PeerID,
+		PublicKey: keyReq.PublicKey,
+		Time:      time.Now().Unix(),
+	}
+	if session, err := s.quorumManager.GetVoteSession(req.FileID, r.PeerID); err == nil {
+		for _, vote := range session.GetVotes() {
+			if vote.Approved {
+				record.Approvals = append(record.Approvals, vote.ValidatorID)
+			}
+		}
+	}
+	if err := s.audit.record(record); err != nil {
+		log.Printf("Failed to audit key delivery: %v", err)
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to record delivery"}`),
+		}, nil
+	}
+
+	resp, err := overlay.MarshalJSON(map[string][]byte{"sealed_key": sealed})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// handleKeyShare gives a validator its own share of a file key. The share
+// goes only to the validator it was dealt to, proven by the request's
+// signature, so no one can collect enough shares to rebuild the key.
 func (s *IntegratedServer) handleKeyShare(r *overlay.Request) (*overlay.Response, error) {
-	fileID := r.QueryParam("file_id")
-	validatorID := r.QueryParam("validator_id")
-	if fileID == "" || validatorID == "" {
+	var req struct {
+		FileID      string `json:"file_id"`
+		ValidatorID string `json:"validator_id"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil || req.FileID == "" || req.ValidatorID == "" {
 		return &overlay.Response{
 			StatusCode: 400,
 			Body:       []byte(`{"error":"Missing file_id or validator_id"}`),
 		}, nil
 	}
+	if req.ValidatorID != r.PeerID {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Key shares are only given to their validator"}`),
+		}, nil
+	}
 
-	share, err := s.keyManager.GetKeyShare(fileID, validatorID)
+	share, err := s.keyManager.GetKeyShare(req.FileID, req.ValidatorID)
 	if err != nil {
 		return &overlay.Response{
 			StatusCode: 404,
@@ -444,24 +935,151 @@ func (s *IntegratedServer) handleKeyShare(r *overlay.Request) (*overlay.Response
 	}, nil
 }
 
+// handleChunksRegister records a peer's full chunk list, signed by the
+// peer. Lists carrying an announcement sequence replace what the peer
+// announced before; ones without are merged into it. A list repeating the
+// idempotency key of one already recorded is acknowledged without being
+// recorded or replicated again.
 func (s *IntegratedServer) handleChunksRegister(r *overlay.Request) (*overlay.Response, error) {
-	var req struct {
-		PeerID   string   `json:"peer_id"`
-		Address  string   `json:"address"`
-		ChunkIDs []string `json:"chunk_ids"`
-	}
+	var req types.PeerChunkInfo
 	if err := r.UnmarshalJSON(&req); err != nil {
 		return &overlay.Response{
 			StatusCode: 400,
 			Body:       []byte(`{"error":"Invalid request body"}`),
 		}, nil
 	}
+	if req.PeerID != r.PeerID {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Chunks can only be announced by their peer"}`),
+		}, nil
+	}
+	if req.IdempotencyKey != "" && !s.markSeen("chunks/"+req.PeerID+"/"+req.IdempotencyKey) {
+		return &overlay.Response{
+			StatusCode: 200,
+			Body:       []byte(`{"duplicate":true}`),
+		}, nil
+	}
+
+	if req.Seq == 0 {
+		s.registry.RegisterPeerChunks(req.PeerID, req.Address, req.ChunkIDs)
+		s.publish(&replicationEvent{
+			Kind:     eventPeerChunks,
+			PeerID:   req.PeerID,
+			Address:  req.Address,
+			ChunkIDs: req.ChunkIDs,
+		})
+		return &overlay.Response{StatusCode: 200}, nil
+	}
+
+	if err := s.registry.SyncPeerChunks(req.PeerID, req.Address, req.Seq, req.ChunkIDs); err != nil {
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to register chunks"}`),
+		}, nil
+	}
+	s.publish(&replicationEvent{
+		Kind:     eventChunkSync,
+		PeerID:   req.PeerID,
+		Address:  req.Address,
+		ChunkIDs: req.ChunkIDs,
+		Seq:      req.Seq,
+	})
+
+	return &overlay.Response{StatusCode: 200}, nil
+}
+
+// handleChunksDelta records the chunks a peer gained and lost since its
+// last announcement, signed by the peer. A delta that does not follow on
+// from it is refused with 409, asking the peer for its full list.
+func (s *IntegratedServer) handleChunksDelta(r *overlay.Request) (*overlay.Response, error) {
+	var delta types.ChunkDelta
+	if err := r.UnmarshalJSON(&delta); err != nil || delta.PeerID == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	if delta.PeerID != r.PeerID {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Chunks can only be announced by their peer"}`),
+		}, nil
+	}
+
+	if err := s.registry.ApplyChunkDelta(&delta); err != nil {
+		if errors.Is(err, registry.ErrResyncRequired) {
+			return s.resyncResponse(delta.PeerID, err)
+		}
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid chunk delta"}`),
+		}, nil
+	}
+	s.publish(&replicationEvent{
+		Kind:     eventChunkDelta,
+		PeerID:   delta.PeerID,
+		Address:  delta.Address,
+		ChunkIDs: delta.Added,
+		Removed:  delta.Removed,
+		BaseSeq:  delta.BaseSeq,
+		Seq:      delta.Seq,
+	})
+
+	return &overlay.Response{StatusCode: 200}, nil
+}
+
+// handleChunksSummary reconciles the registry with a Bloom filter summary
+// of a peer's chunks, signed by the peer. Chunks the peer no longer hosts
+// are dropped; if the registry is behind, the peer is asked for its full
+// list with 409.
+func (s *IntegratedServer) handleChunksSummary(r *overlay.Request) (*overlay.Response, error) {
+	var summary types.ChunkSetSummary
+	if err := r.UnmarshalJSON(&summary); err != nil || summary.PeerID == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	if summary.PeerID != r.PeerID {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Chunks can only be announced by their peer"}`),
+		}, nil
+	}
 
-	s.registry.RegisterPeerChunks(req.PeerID, req.Address, req.ChunkIDs)
+	removed, err := s.registry.ReconcilePeerChunks(&summary)
+	if len(removed) > 0 {
+		s.publish(&replicationEvent{
+			Kind:    eventChunkDelta,
+			PeerID:  summary.PeerID,
+			Removed: removed,
+			BaseSeq: summary.Seq,
+			Seq:     summary.Seq,
+		})
+	}
+	if err != nil {
+		if errors.Is(err, registry.ErrResyncRequired) {
+			return s.resyncResponse(summary.PeerID, err)
+		}
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid chunk summary"}`),
+		}, nil
+	}
 
 	return &overlay.Response{StatusCode: 200}, nil
 }
 
+// resyncResponse asks a peer to announce its full chunk list
+func (s *IntegratedServer) resyncResponse(peerID string, err error) (*overlay.Response, error) {
+	resp, marshalErr := overlay.MarshalJSON(types.SyncStatus{Error: err.Error(), Seq: s.registry.PeerSeq(peerID)})
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	return &overlay.Response{StatusCode: 409, Body: resp}, nil
+}
+
 func (s *IntegratedServer) handleGetChunkPeers(r *overlay.Request) (*overlay.Response, error) {
 	chunkID := r.PathParam("id")
 	if chunkID == "" {
@@ -484,6 +1102,86 @@ func (s *IntegratedServer) handleGetChunkPeers(r *overlay.Request) (*overlay.Res
 	}, nil
 }
 
+func (s *IntegratedServer) handleChunksList(r *overlay.Request) (*overlay.Response, error) {
+	opts, resp := listOptions(r, "id", "peers")
+	if resp != nil {
+		return resp, nil
+	}
+
+	chunks, total := s.registry.ListChunks(opts)
+	_, _, next := opts.Page(total)
+
+	body, err := overlay.MarshalJSON(&types.ChunkList{
+		Chunks:     chunks,
+		Total:      total,
+		NextOffset: next,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       body,
+	}, nil
+}
+
+// listOptions parses a listing's query, accepting the given sort fields with
+// the first as the default
+func listOptions(r *overlay.Request, sortFields ...string) (types.ListOptions, *overlay.Response) {
+	opts, err := types.ParseListOptions(r.QueryParam)
+	if err == nil && opts.Sort == "" {
+		opts.Sort = sortFields[0]
+	}
+	if err == nil && !contains(sortFields, opts.Sort) {
+		err = fmt.Errorf("invalid sort %q", opts.Sort)
+	}
+	if err != nil {
+		body, _ := overlay.MarshalJSON(map[string]string{"error": err.Error()})
+		return opts, &overlay.Response{
+			StatusCode: 400,
+			Body:       body,
+		}
+	}
+	return opts, nil
+}
+
+// handleAccountHistory returns an account's balance and a page of its
+// transactions, newest first. The account defaults to this node's.
+func (s *IntegratedServer) handleAccountHistory(r *overlay.Request) (*overlay.Response, error) {
+	opts, err := types.ParseListOptions(r.QueryParam)
+	if err != nil {
+		body, _ := overlay.MarshalJSON(map[string]string{"error": err.Error()})
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       body,
+		}, nil
+	}
+	account := r.QueryParam("account")
+	if account == "" {
+		account = s.nodeID
+	}
+
+	txs, total := s.ledger.History(account, opts)
+	_, _, next := opts.Page(total)
+
+	resp, err := overlay.MarshalJSON(map[string]interface{}{
+		"account":      account,
+		"balance":      s.ledger.Balance(account),
+		"transactions": txs,
+		"total":        total,
+		"next_offset":  next,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
 func (s *IntegratedServer) monitorManifestReplication() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
@@ -524,8 +1222,23 @@ func (s *IntegratedServer) GetPeers() []string {
 	return s.overlay.Peers()
 }
 
+// GetPeersWithFile returns the peers holding a file, most reputable first
 func (s *IntegratedServer) GetPeersWithFile(fileID string) []string {
-	return s.registry.GetPeersForFile(fileID)
+	peers := append([]string(nil), s.registry.GetPeersForFile(fileID)...)
+	sort.SliceStable(peers, func(i, j int) bool {
+		return s.peerManager.GetChunkStats(peers[i]).Reputation > s.peerManager.GetChunkStats(peers[j]).Reputation
+	})
+	return peers
+}
+
+// GetPeersWithChunk returns the peers announcing a chunk, most reputable
+// first
+func (s *IntegratedServer) GetPeersWithChunk(chunkID string) []string {
+	peers := s.registry.GetPeersForChunk(chunkID)
+	sort.SliceStable(peers, func(i, j int) bool {
+		return s.peerManager.GetChunkStats(peers[i]).Reputation > s.peerManager.GetChunkStats(peers[j]).Reputation
+	})
+	return peers
 }
 
 func (s *IntegratedServer) RegisterFile(fileInfo *FileInfo) error {
@@ -543,6 +1256,7 @@ func (s *IntegratedServer) RegisterFile(fileInfo *FileInfo) error {
 	if err := s.registry.RegisterFile(info); err != nil {
 		return err
 	}
+	s.publish(&replicationEvent{Kind: eventFileRegistered, File: info})
 
 	// Notify peers about new file
 	data := map[string]string{
@@ -561,27 +1275,32 @@ func (s *IntegratedServer) RegisterFile(fileInfo *FileInfo) error {
 	return nil
 }
 
-func (s *IntegratedServer) FetchChunks(fileInfo *FileInfo, peerID string) error {
-	// Request chunks from peer
-	for _, chunk := range fileInfo.Chunks {
-		req := &overlay.Request{
-			Method: "GET",
-			Path:   fmt.Sprintf("/chunks/%s", chunk.ID),
-		}
-
-		resp, err := s.overlay.SendMessage(s.ctx, peerID, req)
-		if err != nil {
-			return fmt.Errorf("failed to fetch chunk %s: %v", chunk.ID, err)
-		}
+// FetchChunk requests a chunk's data from a peer
+func (s *IntegratedServer) FetchChunk(chunk ChunkInfo, peerID string) ([]byte, error) {
+	req := &overlay.Request{
+		Method: "GET",
+		Path:   fmt.Sprintf("/chunks/%s", chunk.ID),
+	}
 
-		// Save chunk data
-		chunkPath := filepath.Join(fileInfo.ChunkDir, chunk.ID)
-		if err := os.WriteFile(chunkPath, resp.Body, 0644); err != nil {
-			return fmt.Errorf("failed to save chunk %s: %v", chunk.ID, err)
-		}
+	resp, err := s.overlay.SendMessage(s.ctx, peerID, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chunk %s: %v", chunk.ID, err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to fetch chunk %s: status %d", chunk.ID, resp.StatusCode)
 	}
+	return resp.Body, nil
+}
 
-	return nil
+// ReportChunkResult records whether a chunk fetched from a peer verified,
+// paying the peer for verified chunks
+func (s *IntegratedServer) ReportChunkResult(peerID string, valid bool) {
+	if valid {
+		s.peerManager.RecordChunkSuccess(peerID)
+		s.payForChunk(peerID)
+	} else {
+		s.peerManager.RecordChunkFailure(peerID)
+	}
 }
 
 // Helper functions
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+)
+
+// Share links name files by ID. A file's registration carries its .zap
+// manifest, so a node given only the ID can resolve the manifest from any
+// validator holding the registration and download the file.
+
+func (s *IntegratedServer) handleFileResolve(r *overlay.Request) (*overlay.Response, error) {
+	var req struct {
+		FileID string `json:"file_id"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil || req.FileID == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	fileInfo, exists := s.registry.GetFileByID(req.FileID)
+	if !exists {
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"File not found"}`),
+		}, nil
+	}
+
+	resp, err := overlay.MarshalJSON(fileInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// ResolveFile returns the manifest of a file by ID, from the local registry
+// or else from the first peer that has it. A registration found on a peer
+// is added to the local registry, so the file's chunks can be fetched from
+// the peers holding them.
+func (s *IntegratedServer) ResolveFile(fileID string) (*FileInfo, error) {
+	if s.registry.IsBlacklisted(fileID) {
+		return nil, fmt.Errorf("file %s has been removed", fileID)
+	}
+	if info, exists := s.registry.GetFileByID(fileID); exists && len(info.ZapMetadata) > 0 {
+		return decodeManifest(info)
+	}
+
+	body, err := overlay.MarshalJSON(map[string]string{"file_id": fileID})
+	if err != nil {
+		return nil, err
+	}
+	req := &overlay.Request{
+		Method: "POST",
+		Path:   "/file/resolve",
+		Body:   body,
+	}
+	for _, peerID := range s.overlay.Peers() {
+		resp, err := s.overlay.SendMessage(s.ctx, peerID, req)
+		if err != nil || resp.StatusCode != 200 {
+			continue
+		}
+		var info registry.FileInfo
+		if err := json.Unmarshal(resp.Body, &info); err != nil || info.ID != fileID || len(info.ZapMetadata) == 0 {
+			continue
+		}
+
+		manifest, err := decodeManifest(&info)
+		if err != nil {
+			continue
+		}
+		if err := s.registry.RegisterFile(&info); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+	return nil, fmt.Errorf("file %s not found on any peer", fileID)
+}
+
+// decodeManifest reads the manifest stored in a registration
+func decodeManifest(info *registry.FileInfo) (*FileInfo, error) {
+	var manifest FileInfo
+	if err := json.Unmarshal(info.ZapMetadata, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest for file %s: %v", info.ID, err)
+	}
+	if manifest.ID != info.ID {
+		return nil, fmt.Errorf("manifest does not match file %s", info.ID)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keystore"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveFileFromPeer(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	holder := newMeshValidator(t, m, "v1")
+	importer := newMeshValidator(t, m, "v2")
+
+	manifest := &FileInfo{ID: "file1", Name: "a.txt", Chunks: []ChunkInfo{{ID: "c1", Hash: "c1"}}}
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, holder.registry.RegisterFile(&registry.FileInfo{
+		ID:          "file1",
+		Name:        "a.txt",
+		PeerIDs:     []string{"v1"},
+		ZapMetadata: data,
+	}))
+
+	resolved, err := importer.ResolveFile("file1")
+	require.NoError(t, err)
+	assert.Equal(t, manifest, resolved)
+
+	// The registration is kept, so the chunks' holders are known
+	assert.Equal(t, []string{"v1"}, importer.GetPeersWithFile("file1"))
+
+	_, err = importer.ResolveFile("missing")
+	assert.Error(t, err)
+}
+
+func TestSendToContact(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	alice := newMeshValidator(t, m, "v1")
+	bob := newMeshValidator(t, m, "v2")
+
+	// Bob refuses files until Alice is a contact
+	_, err := alice.Contacts().Add("Bob", bob.ContactCode())
+	require.NoError(t, err)
+	assert.Error(t, alice.SendToContact("v2", "file1", "a.txt", []byte("file key")))
+	assert.Empty(t, bob.Contacts().Inbox())
+
+	_, err = bob.Contacts().Add("Alice", alice.ContactCode())
+	require.NoError(t, err)
+	require.NoError(t, alice.SendToContact("v2", "file1", "a.txt", []byte("file key")))
+
+	inbox := bob.Contacts().Inbox()
+	require.Len(t, inbox, 1)
+	assert.Equal(t, "v1", inbox[0].From)
+	assert.Equal(t, "a.txt", inbox[0].Name)
+	key, err := bob.Contacts().OpenKey(inbox[0])
+	require.NoError(t, err)
+	assert.Equal(t, []byte("file key"), key)
+
+	// Sending to someone who is not a contact fails before anything is sent
+	assert.Error(t, alice.SendToContact("v3", "file1", "a.txt", []byte("file key")))
+}
+
+func TestProtectIdentity(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	code := s.ContactCode()
+
+	keys, err := keystore.Open(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, keys.Unlock(keystore.Passphrase("secret")))
+	require.NoError(t, s.ProtectIdentity(keys))
+
+	// The identity is unchanged but no longer on disk in plaintext
+	assert.Equal(t, code, s.ContactCode())
+	assert.NoFileExists(t, filepath.Join(s.dataDir, "contact.key"))
+
+	// A fresh book takes the stored identity
+	restarted := newMeshValidator(t, m, "v1")
+	require.NoError(t, restarted.ProtectIdentity(keys))
+	assert.Equal(t, code, restarted.ContactCode())
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+)
+
+// On shutdown the server drains: it stops opening vote sessions and waits
+// up to the drain timeout for requests in flight. Vote sessions and key
+// requests are saved as they change, so the next run resumes them.
+const DefaultDrainTimeout = 10 * time.Second
+
+// handle registers a handler whose requests are waited for on shutdown.
+// Requests arriving once the server drains are refused, so none is added
+// while Shutdown waits.
+func (s *IntegratedServer) handle(method, path string, handler overlay.HandlerFunc) {
+	s.overlay.HandleFunc(method, path, func(r *overlay.Request) (*overlay.Response, error) {
+		s.mu.RLock()
+		if s.draining {
+			s.mu.RUnlock()
+			return drainingResponse(), nil
+		}
+		s.inFlight.Add(1)
+		s.mu.RUnlock()
+		defer s.inFlight.Done()
+		return handler(r)
+	})
+}
+
+// isDraining reports whether the server is shutting down
+func (s *IntegratedServer) isDraining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.draining
+}
+
+// drainingResponse refuses work that would outlive a shutdown
+func drainingResponse() *overlay.Response {
+	return &overlay.Response{
+		StatusCode: 503,
+		Body:       []byte(`{"error":"Server is shutting down"}`),
+	}
+}
+
+// SetDrainTimeout sets how long Stop waits for requests in flight
+func (s *IntegratedServer) SetDrainTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drainTimeout = timeout
+}
+
+// Shutdown stops the server once the requests in flight have finished or
+// ctx is done, then closes its stores
+func (s *IntegratedServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Printf("Shutting down with requests still in flight: %v", ctx.Err())
+	}
+
+	s.cancel()
+	if err := s.registry.Close(); err != nil {
+		log.Printf("Failed to close registry: %v", err)
+	}
+	if err := s.audit.close(); err != nil {
+		log.Printf("Failed to close audit log: %v", err)
+	}
+	if err := s.ledger.Close(); err != nil {
+		log.Printf("Failed to close ledger: %v", err)
+	}
+	if err := s.voteAudit.Close(); err != nil {
+		log.Printf("Failed to close vote audit log: %v", err)
+	}
+	return s.overlay.Close()
+}
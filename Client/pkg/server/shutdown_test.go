@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownDrainsAndSavesSessions(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 3)
+	clientKey, client := newValidatorKey(t)
+
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	require.Equal(t, 202, postSigned(t, s, clientKey, "/key/request", request).StatusCode)
+	require.Equal(t, 200, postVote(t, s, voters[1], "/key/vote", "file1", client, true).StatusCode)
+
+	// Hold a request in flight
+	started, release := make(chan struct{}), make(chan struct{})
+	s.handle("GET", "/slow", func(*overlay.Request) (*overlay.Response, error) {
+		close(started)
+		<-release
+		return &overlay.Response{StatusCode: 200}, nil
+	})
+	slow := make(chan int)
+	go func() {
+		resp, err := s.overlay.HandleRequest(&overlay.Request{Method: "GET", Path: "/slow"})
+		if err != nil {
+			slow <- 0
+			return
+		}
+		slow <- resp.StatusCode
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	require.Eventually(t, s.isDraining, time.Second, time.Millisecond)
+
+	// No new vote sessions while draining
+	assert.Equal(t, 503, postSigned(t, s, nil, "/key/request", request).StatusCode)
+	select {
+	case <-done:
+		t.Fatal("shutdown did not wait for the request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, 200, <-slow)
+	require.NoError(t, <-done)
+
+	// The next run resumes the pending session
+	restarted := newMeshValidator(t, m, "v1")
+	restarted.dataDir = s.dataDir
+	require.NoError(t, restarted.quorumManager.EnablePersistence(s.dataDir))
+	session, err := restarted.quorumManager.GetVoteSession("file1", client)
+	require.NoError(t, err)
+	votes := session.GetVotes()
+	require.Len(t, votes, 1)
+	assert.Equal(t, voters[1].id, votes[0].ValidatorID)
+	original, err := s.quorumManager.GetVoteSession("file1", client)
+	require.NoError(t, err)
+	assert.Equal(t, original.StartTime, session.StartTime)
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s.handle("GET", "/slow", func(*overlay.Request) (*overlay.Response, error) {
+		close(started)
+		<-release
+		return &overlay.Response{StatusCode: 200}, nil
+	})
+	go s.overlay.HandleRequest(&overlay.Request{Method: "GET", Path: "/slow"})
+	<-started
+
+	s.SetDrainTimeout(20 * time.Millisecond)
+	begin := time.Now()
+	require.NoError(t, s.Stop())
+	assert.Less(t, time.Since(begin), time.Second)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/keymanager"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	ncoverlay "github.com/VetheonGames/FileZap/NetworkCore/pkg/overlay"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// The overlay does not tell a handler who sent a request, so requests that
+// act for an identity, such as casting votes, moving stake or funds, handing
+// out key shares or leaving the quorum, carry their sender in the body. The
+// sender wraps the body in a SignedRequest signed with its peer key, which
+// covers the method, path and time so a request cannot be replayed on
+// another endpoint or later. The server checks the signature, takes each
+// request once, limits how often each sender may ask, and passes the body
+// on with the sender's peer ID set on the request.
+const (
+	signedRequestWindow = 2 * time.Minute // How far a request's time may be from the server's
+	signedRequestRate   = 5               // Requests per second each sender may make
+	signedRequestBurst  = 20
+	maxSeenRequests     = 100000
+)
+
+// SignedRequest is a request body signed by its sender
+type SignedRequest struct {
+	PeerID    string          `json:"peer_id"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Time      int64           `json:"time"` // Unix nanoseconds
+	Body      json.RawMessage `json:"body"`
+	Signature []byte          `json:"signature,omitempty"`
+}
+
+// signedBytes returns the request content covered by the signature
+func (r *SignedRequest) signedBytes() ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// verify checks that the request was signed by its sender for method and
+// path
+func (r *SignedRequest) verify(method, path string) error {
+	if r.Method != method || r.Path != path {
+		return fmt.Errorf("request signed for %s %s", r.Method, r.Path)
+	}
+	data, err := r.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+	return verifySigner(r.PeerID, data, r.Signature)
+}
+
+// expired reports whether the request's time is too far from ours to take
+// it
+func (r *SignedRequest) expired() bool {
+	age := time.Since(time.Unix(0, r.Time))
+	return age > signedRequestWindow || age < -signedRequestWindow
+}
+
+// openRequest decodes a signed request carried by a replication event and
+// checks it was signed for method and path and is recent
+func openRequest(raw json.RawMessage, method, path string) (*SignedRequest, error) {
+	var sr SignedRequest
+	if err := json.Unmarshal(raw, &sr); err != nil || sr.PeerID == "" {
+		return nil, fmt.Errorf("invalid signed request")
+	}
+	if err := sr.verify(method, path); err != nil {
+		return nil, err
+	}
+	if sr.expired() {
+		return nil, fmt.Errorf("request expired")
+	}
+	return &sr, nil
+}
+
+// requestKey returns an idempotency key unique to a signed request
+func (r *SignedRequest) requestKey() string {
+	sum := sha256.Sum256(r.Signature)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSignedRequest returns a request to path carrying body, signed with
+// the sender's peer key
+func NewSignedRequest(key crypto.PrivKey, method, path string, body interface{}) (*overlay.Request, error) {
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer key: %v", err)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	sr := &SignedRequest{
+		PeerID: id.String(),
+		Method: method,
+		Path:   path,
+		Time:   time.Now().UnixNano(),
+		Body:   data,
+	}
+	signed, err := sr.signedBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+	if sr.Signature, err = key.Sign(signed); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %v", err)
+	}
+
+	wrapped, err := json.Marshal(sr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+	return &overlay.Request{Method: method, Path: path, Body: wrapped}, nil
+}
+
+// handleSigned registers a handler for requests that must be signed by
+// their sender
+func (s *IntegratedServer) handleSigned(method, path string, handler overlay.HandlerFunc) {
+	s.signedPaths[path] = true
+	s.handle(method, path, func(r *overlay.Request) (*overlay.Response, error) {
+		var sr SignedRequest
+		if err := r.UnmarshalJSON(&sr); err != nil || sr.PeerID == "" {
+			return &overlay.Response{
+				StatusCode: 400,
+				Body:       []byte(`{"error":"Invalid request body"}`),
+			}, nil
+		}
+		if sr.verify(r.Method, r.Path) != nil {
+			return &overlay.Response{
+				StatusCode: 401,
+				Body:       []byte(`{"error":"Invalid request signature"}`),
+			}, nil
+		}
+		if sr.expired() {
+			return &overlay.Response{
+				StatusCode: 401,
+				Body:       []byte(`{"error":"Request expired"}`),
+			}, nil
+		}
+		if !s.limiter.Allow(sr.PeerID) {
+			return &overlay.Response{
+				StatusCode: 429,
+				Body:       []byte(`{"error":"Rate limit exceeded"}`),
+			}, nil
+		}
+		if !s.takeRequest(&sr) {
+			return &overlay.Response{
+				StatusCode: 409,
+				Body:       []byte(`{"error":"Request already taken"}`),
+			}, nil
+		}
+
+		return handler(&overlay.Request{
+			Method: r.Method,
+			Path:   r.Path,
+			Body:   sr.Body,
+			PeerID: sr.PeerID,
+			Signed: r.Body,
+		})
+	})
+}
+
+// takeRequest records a signed request, reporting whether it was new.
+// Requests are remembered until they are too old to be taken anyway.
+func (s *IntegratedServer) takeRequest(sr *SignedRequest) bool {
+	id := sr.requestKey()
+
+	s.requestMu.Lock()
+	defer s.requestMu.Unlock()
+
+	if _, seen := s.seenRequests[id]; seen {
+		return false
+	}
+	if len(s.seenRequests) >= maxSeenRequests {
+		oldest := time.Now().Add(-signedRequestWindow).UnixNano()
+		for seenID, t := range s.seenRequests {
+			if t < oldest {
+				delete(s.seenRequests, seenID)
+			}
+		}
+		if len(s.seenRequests) >= maxSeenRequests {
+			return false
+		}
+	}
+	s.seenRequests[id] = sr.Time
+	return true
+}
+
+// peerSealKey returns the key that keys are sealed to for a peer, derived
+// from the Ed25519 key embedded in its ID
+func peerSealKey(peerID string) ([]byte, error) {
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %v", err)
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("peer ID does not embed its key: %v", err)
+	}
+	if pub.Type() != crypto.Ed25519 {
+		return nil, fmt.Errorf("peer key is not Ed25519")
+	}
+	raw, err := pub.Raw()
+	if err != nil {
+		return nil, err
+	}
+	return keymanager.PeerSealKey(raw)
+}
+
+// newRequest returns a request to a validator endpoint, signed with the
+// node's peer key if the endpoint needs to know its sender
+func (s *IntegratedServer) newRequest(method, path string, body interface{}) (*overlay.Request, error) {
+	if !s.signedPaths[path] {
+		data, err := overlay.MarshalJSON(body)
+		if err != nil {
+			return nil, err
+		}
+		return &overlay.Request{Method: method, Path: path, Body: data}, nil
+	}
+
+	s.mu.RLock()
+	key := s.peerKey
+	s.mu.RUnlock()
+	if key == nil {
+		return nil, fmt.Errorf("no peer key to sign %s with", path)
+	}
+	return NewSignedRequest(key, method, path, body)
+}
+
+// newLimiter returns the limiter of signed requests
+func newLimiter() *ncoverlay.RateLimiter {
+	return ncoverlay.NewRateLimiter(signedRequestRate, signedRequestBurst)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedRequests(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	var sender string
+	s.handleSigned("POST", "/test/signed", func(r *overlay.Request) (*overlay.Response, error) {
+		sender = r.PeerID
+		return &overlay.Response{StatusCode: 200, Body: r.Body}, nil
+	})
+	key, id := newValidatorKey(t)
+
+	// The handler sees the body and who signed it
+	req, err := NewSignedRequest(key, "POST", "/test/signed", map[string]string{"a": "b"})
+	require.NoError(t, err)
+	resp, err := s.overlay.HandleRequest(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	assert.JSONEq(t, `{"a":"b"}`, string(resp.Body))
+	assert.Equal(t, id, sender)
+
+	// Each request is taken once
+	resp, err = s.overlay.HandleRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, 409, resp.StatusCode)
+
+	// Unsigned bodies, tampered requests and requests for another path
+	// are refused
+	assert.Equal(t, 400, postJSON(t, s, "/test/signed", map[string]string{"a": "b"}).StatusCode)
+	tamper := func(change func(*SignedRequest)) int {
+		req, err := NewSignedRequest(key, "POST", "/test/signed", map[string]string{"a": "b"})
+		require.NoError(t, err)
+		var sr SignedRequest
+		require.NoError(t, json.Unmarshal(req.Body, &sr))
+		change(&sr)
+		req.Body, err = json.Marshal(&sr)
+		require.NoError(t, err)
+		resp, err := s.overlay.HandleRequest(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, 401, tamper(func(sr *SignedRequest) { sr.Body = json.RawMessage(`{"a":"c"}`) }))
+	assert.Equal(t, 401, tamper(func(sr *SignedRequest) { sr.Path = "/key/vote" }))
+	_, other := newValidatorKey(t)
+	assert.Equal(t, 401, tamper(func(sr *SignedRequest) { sr.PeerID = other }))
+
+	req, err = NewSignedRequest(key, "POST", "/key/vote", map[string]string{})
+	require.NoError(t, err)
+	req.Path = "/test/signed"
+	resp, err = s.overlay.HandleRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+
+	// Senders are held to a rate
+	limited := 0
+	for i := 0; i < signedRequestBurst+5; i++ {
+		if postSigned(t, s, key, "/test/signed", map[string]int{"i": i}).StatusCode == 429 {
+			limited++
+		}
+	}
+	assert.NotZero(t, limited)
+	assert.Equal(t, 200, postSigned(t, s, nil, "/test/signed", map[string]string{}).StatusCode)
+}
+
+func TestSignedRequestExpired(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	key, id := newValidatorKey(t)
+
+	sr := &SignedRequest{
+		PeerID: id,
+		Method: "POST",
+		Path:   "/account/faucet",
+		Time:   time.Now().Add(-2 * signedRequestWindow).UnixNano(),
+		Body:   json.RawMessage(`{}`),
+	}
+	data, err := sr.signedBytes()
+	require.NoError(t, err)
+	sr.Signature, err = key.Sign(data)
+	require.NoError(t, err)
+	assert.Equal(t, 401, postJSON(t, s, "/account/faucet", sr).StatusCode)
+}
@@ -0,0 +1,574 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/ledger"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Validators may lock part of their ledger balance as stake. With staking
+// enabled each validator's vote weighs as much as its stake. Anyone holding
+// evidence of a validator misbehaving, votes it signed that conflict or
+// that approve a key request for a file removed as bad, can submit it; the
+// evidence is checked and put to a slashing vote, held like removal votes
+// under a reserved client ID with the accused validator's ID in place of
+// the file ID. A passed vote takes slashPercent of the validator's stake.
+// Stakes live in each validator's own ledger, so stake changes are
+// replicated along with the rest of the validator state.
+const (
+	slashClient  = "slashing" // Client ID of slashing vote sessions
+	slashPercent = 50         // Share of its stake a slashed validator loses
+	caseSlashed  = "slashed"
+)
+
+// Votes sent to a validator are signed by the voting validator, and kept so
+// a validator signing two conflicting votes in a session is caught: the
+// second vote is refused and the pair filed as evidence against it. Votes
+// older than voteMaxAge are refused, so old votes cannot be replayed into
+// later sessions.
+const (
+	voteMaxAge     = 5 * time.Minute
+	maxSignedVotes = 100000
+)
+
+// Kinds of evidence against a validator
+const (
+	EvidenceConflictingVotes = "conflicting_votes" // Two signed votes disagreeing in one session
+	EvidenceBadChunks        = "bad_chunks"        // A signed approval of a key request for a removed file
+)
+
+// SignedVote is a vote signed with the voting validator's peer key
+type SignedVote struct {
+	FileID      string `json:"file_id"`
+	ClientID    string `json:"client_id"`
+	ValidatorID string `json:"validator_id"`
+	Approved    bool   `json:"approved"`
+	Time        int64  `json:"time"`
+	Signature   []byte `json:"signature,omitempty"`
+}
+
+// signedBytes returns the vote content covered by the signature
+func (v *SignedVote) signedBytes() ([]byte, error) {
+	unsigned := *v
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// SignVote signs a vote with the validator's peer key. ValidatorID must be
+// the peer ID of that key.
+func SignVote(v *SignedVote, key crypto.PrivKey) error {
+	data, err := v.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode vote: %v", err)
+	}
+	sig, err := key.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign vote: %v", err)
+	}
+	v.Signature = sig
+	return nil
+}
+
+// verifyVote checks a vote's signature against the key embedded in the
+// validator's peer ID
+func verifyVote(v *SignedVote) error {
+	id, err := peer.Decode(v.ValidatorID)
+	if err != nil {
+		return fmt.Errorf("invalid validator ID: %v", err)
+	}
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("validator ID does not embed its key: %v", err)
+	}
+	data, err := v.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode vote: %v", err)
+	}
+	ok, err := pub.Verify(data, v.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid vote signature")
+	}
+	return nil
+}
+
+// signVote returns this node's signed vote, or nil if it has no peer key
+func (s *IntegratedServer) signVote(fileID, clientID string, approved bool) *SignedVote {
+	s.mu.RLock()
+	key := s.peerKey
+	s.mu.RUnlock()
+	if key == nil {
+		return nil
+	}
+
+	vote := &SignedVote{
+		FileID:      fileID,
+		ClientID:    clientID,
+		ValidatorID: s.nodeID,
+		Approved:    approved,
+		Time:        time.Now().Unix(),
+	}
+	if err := SignVote(vote, key); err != nil {
+		log.Printf("Failed to sign vote: %v", err)
+		return nil
+	}
+	s.recordVote(*vote)
+	return vote
+}
+
+// recordVote keeps a validator's signed vote. If the validator signed a
+// vote in the same session before that disagrees, that vote is returned
+// and the new one is not kept.
+func (s *IntegratedServer) recordVote(v SignedVote) (SignedVote, bool) {
+	key := v.FileID + ":" + v.ClientID + ":" + v.ValidatorID
+
+	s.voteMu.Lock()
+	defer s.voteMu.Unlock()
+
+	if prev, exists := s.signedVotes[key]; exists {
+		return prev, prev.Approved != v.Approved
+	}
+	if len(s.signedVotes) >= maxSignedVotes {
+		oldest := time.Now().Add(-2 * voteMaxAge).Unix()
+		for k, vote := range s.signedVotes {
+			if vote.Time < oldest {
+				delete(s.signedVotes, k)
+			}
+		}
+	}
+	s.signedVotes[key] = v
+	return v, false
+}
+
+// checkVote verifies a vote sent to this validator and records it. A vote
+// conflicting with an earlier one is filed as evidence against its
+// validator and refused.
+func (s *IntegratedServer) checkVote(v *SignedVote) error {
+	if err := verifyVote(v); err != nil {
+		return err
+	}
+	if age := time.Since(time.Unix(v.Time, 0)); age > voteMaxAge || age < -voteMaxAge {
+		return fmt.Errorf("vote expired")
+	}
+	prev, conflict := s.recordVote(*v)
+	if !conflict {
+		return nil
+	}
+
+	evidence := Evidence{Kind: EvidenceConflictingVotes, ValidatorID: v.ValidatorID, Votes: []SignedVote{prev, *v}}
+	if err := s.checkEvidence(&evidence); err == nil {
+		s.fileEvidence(evidence)
+	}
+	return errConflictingVote
+}
+
+// errConflictingVote is returned for votes disagreeing with one their
+// validator signed before
+var errConflictingVote = errors.New("vote conflicts with an earlier vote")
+
+// voteError returns the response refusing a vote that failed checkVote
+func voteError(err error) *overlay.Response {
+	if errors.Is(err, errConflictingVote) {
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Vote conflicts with an earlier vote"}`),
+		}
+	}
+	return &overlay.Response{
+		StatusCode: 401,
+		Body:       []byte(`{"error":"Invalid or expired vote signature"}`),
+	}
+}
+
+// Evidence is proof that a validator misbehaved
+type Evidence struct {
+	Kind        string       `json:"kind"`
+	ValidatorID string       `json:"validator_id"` // Accused validator
+	Votes       []SignedVote `json:"votes"`
+}
+
+// checkEvidence verifies evidence against a validator with stake to lose
+func (s *IntegratedServer) checkEvidence(e *Evidence) error {
+	if s.ledger.StakeOf(e.ValidatorID) <= 0 {
+		return ledger.ErrNoStake
+	}
+	for i := range e.Votes {
+		vote := &e.Votes[i]
+		if vote.ValidatorID != e.ValidatorID {
+			return fmt.Errorf("vote not cast by the accused validator")
+		}
+		if err := verifyVote(vote); err != nil {
+			return err
+		}
+	}
+
+	switch e.Kind {
+	case EvidenceConflictingVotes:
+		if len(e.Votes) != 2 {
+			return fmt.Errorf("conflicting votes take two votes")
+		}
+		a, b := e.Votes[0], e.Votes[1]
+		if a.FileID != b.FileID || a.ClientID != b.ClientID || a.Approved == b.Approved {
+			return fmt.Errorf("votes do not conflict")
+		}
+	case EvidenceBadChunks:
+		if len(e.Votes) != 1 {
+			return fmt.Errorf("bad chunks take one vote")
+		}
+		vote := e.Votes[0]
+		if !vote.Approved || vote.ClientID == moderationClient || vote.ClientID == slashClient {
+			return fmt.Errorf("vote does not approve a key request")
+		}
+		if !s.registry.IsBlacklisted(vote.FileID) {
+			return fmt.Errorf("file was not removed")
+		}
+	default:
+		return fmt.Errorf("unknown evidence kind: %s", e.Kind)
+	}
+	return nil
+}
+
+// slashCase collects the evidence against a validator and the outcome of
+// the vote on slashing it
+type slashCase struct {
+	ValidatorID string     `json:"validator_id"`
+	State       string     `json:"state"`
+	Evidence    []Evidence `json:"evidence"`
+	Opened      int64      `json:"opened"`
+	Decided     int64      `json:"decided,omitempty"`
+}
+
+// EnableStaking weighs validators' votes by the stake in the ledger
+func (s *IntegratedServer) EnableStaking() {
+	s.quorumManager.SetStakeWeights(s.ledger.StakeOf)
+}
+
+// addEvidence files evidence against a validator, opening a slashing vote
+// if it has no case under vote. It returns a copy of the validator's case
+// and whether a vote was opened.
+func (s *IntegratedServer) addEvidence(e Evidence) (slashCase, bool) {
+	s.slashMu.Lock()
+	defer s.slashMu.Unlock()
+
+	c, exists := s.slashing[e.ValidatorID]
+	if !exists {
+		c = &slashCase{ValidatorID: e.ValidatorID, State: caseDismissed}
+		s.slashing[e.ValidatorID] = c
+	}
+	for _, existing := range c.Evidence {
+		if existing.Kind == e.Kind && bytes.Equal(existing.Votes[0].Signature, e.Votes[0].Signature) {
+			// Already filed, e.g. replicated back to us
+			return *c, false
+		}
+	}
+	c.Evidence = append(c.Evidence, e)
+
+	// Reopen decided cases once their previous vote is gone
+	reopen := c.State != caseOpen
+	if reopen {
+		if err := s.openSlashVote(e.ValidatorID); err != nil {
+			reopen = false
+		} else {
+			c.State = caseOpen
+			c.Opened = time.Now().Unix()
+			c.Decided = 0
+		}
+	}
+	return *c, reopen
+}
+
+// openSlashVote starts a vote session on slashing a validator, or joins one
+// already replicated from another validator
+func (s *IntegratedServer) openSlashVote(validatorID string) error {
+	if status, err := s.quorumManager.SessionStatus(validatorID, slashClient); err == nil && status == quorum.SessionPending {
+		return nil
+	}
+	return s.createVoteSession(validatorID, slashClient)
+}
+
+// slashPending reports whether a validator is under a slashing vote
+func (s *IntegratedServer) slashPending(validatorID string) bool {
+	s.slashMu.Lock()
+	defer s.slashMu.Unlock()
+	c, exists := s.slashing[validatorID]
+	return exists && c.State == caseOpen
+}
+
+// updateSlashing applies the outcome of a validator's slashing vote, if
+// decided
+func (s *IntegratedServer) updateSlashing(validatorID string) {
+	status, err := s.quorumManager.SessionStatus(validatorID, slashClient)
+	if err != nil || status == quorum.SessionPending {
+		return
+	}
+
+	s.slashMu.Lock()
+	c, exists := s.slashing[validatorID]
+	if !exists || c.State != caseOpen {
+		s.slashMu.Unlock()
+		return
+	}
+	c.Decided = time.Now().Unix()
+	if status == quorum.SessionApproved {
+		c.State = caseSlashed
+	} else {
+		c.State = caseDismissed
+	}
+	opened := c.Opened
+	s.slashMu.Unlock()
+
+	s.quorumManager.RecordDecision(validatorID, slashClient, status)
+	if status != quorum.SessionApproved {
+		return
+	}
+	key := fmt.Sprintf("slash/%s/%d", validatorID, opened)
+	tx, err := s.ledger.Slash(key, validatorID, slashPercent, "slashed by validator vote")
+	if err != nil {
+		log.Printf("Failed to slash validator %s: %v", validatorID, err)
+		return
+	}
+	log.Printf("Slashed validator %s by %d", validatorID, tx.Postings[1].Amount)
+}
+
+// stakeRequest is the body of stake and unstake requests
+type stakeRequest struct {
+	ValidatorID string `json:"validator_id"`
+	Amount      int64  `json:"amount"`
+}
+
+// handleStake locks part of a validator's balance as stake
+func (s *IntegratedServer) handleStake(r *overlay.Request) (*overlay.Response, error) {
+	return s.changeStake(r, eventStake)
+}
+
+// handleUnstake returns stake to a validator's balance. Validators under a
+// slashing vote must wait for its outcome.
+func (s *IntegratedServer) handleUnstake(r *overlay.Request) (*overlay.Response, error) {
+	return s.changeStake(r, eventUnstake)
+}
+
+func (s *IntegratedServer) changeStake(r *overlay.Request, kind string) (*overlay.Response, error) {
+	event, err := s.stakeChange(r.Signed, kind)
+	switch {
+	case errors.Is(err, errNotOwnStake):
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Stake can only be changed by its validator"}`),
+		}, nil
+	case errors.Is(err, errSlashPending):
+		return &overlay.Response{
+			StatusCode: 409,
+			Body:       []byte(`{"error":"Validator is under a slashing vote"}`),
+		}, nil
+	case err != nil:
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	if err := s.applyStake(event); err != nil {
+		if errors.Is(err, ledger.ErrInsufficientFunds) {
+			return &overlay.Response{
+				StatusCode: 402,
+				Body:       []byte(`{"error":"Insufficient funds"}`),
+			}, nil
+		}
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to change stake"}`),
+		}, nil
+	}
+	s.publish(event)
+
+	resp, err := overlay.MarshalJSON(map[string]interface{}{
+		"validator_id": event.PeerID,
+		"stake":        s.ledger.StakeOf(event.PeerID),
+		"balance":      s.ledger.Balance(event.PeerID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// Errors refusing a stake change
+var (
+	errNotOwnStake  = errors.New("stake can only be changed by its validator")
+	errSlashPending = errors.New("validator is under a slashing vote")
+)
+
+// stakePaths are the endpoints of stake changes, by event kind
+var stakePaths = map[string]string{
+	eventStake:   "/validator/stake",
+	eventUnstake: "/validator/unstake",
+}
+
+// stakeChange returns the change a signed stake or unstake request asks
+// for. Its key is the request's, so each request changes the stake once on
+// every validator.
+func (s *IntegratedServer) stakeChange(signed json.RawMessage, kind string) (*replicationEvent, error) {
+	sr, err := openRequest(signed, "POST", stakePaths[kind])
+	if err != nil {
+		return nil, err
+	}
+	var req stakeRequest
+	if err := json.Unmarshal(sr.Body, &req); err != nil || req.ValidatorID == "" || req.Amount <= 0 {
+		return nil, fmt.Errorf("invalid stake request")
+	}
+	if req.ValidatorID != sr.PeerID {
+		return nil, errNotOwnStake
+	}
+	if kind == eventUnstake && s.slashPending(req.ValidatorID) {
+		return nil, errSlashPending
+	}
+
+	return &replicationEvent{
+		Kind:    kind,
+		PeerID:  req.ValidatorID,
+		Amount:  req.Amount,
+		Key:     kind + "/" + sr.requestKey(),
+		Request: signed,
+	}, nil
+}
+
+// applyStake applies a stake or unstake event to the ledger. Events carry
+// their idempotency key, so replays change the stake once.
+func (s *IntegratedServer) applyStake(event *replicationEvent) error {
+	var err error
+	if event.Kind == eventStake {
+		_, err = s.ledger.Stake(event.Key, event.PeerID, event.Amount)
+	} else {
+		_, err = s.ledger.Unstake(event.Key, event.PeerID, event.Amount)
+	}
+	return err
+}
+
+// handleEvidence files evidence against a validator. The validator is put
+// to a slashing vote unless one is already under way.
+func (s *IntegratedServer) handleEvidence(r *overlay.Request) (*overlay.Response, error) {
+	var evidence Evidence
+	if err := r.UnmarshalJSON(&evidence); err != nil {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	if err := s.checkEvidence(&evidence); err != nil {
+		body, _ := overlay.MarshalJSON(map[string]string{"error": fmt.Sprintf("Invalid evidence: %v", err)})
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       body,
+		}, nil
+	}
+
+	resp, err := overlay.MarshalJSON(s.fileEvidence(evidence))
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 202,
+		Body:       resp,
+	}, nil
+}
+
+// fileEvidence files checked evidence and replicates it, opening a slashing
+// vote if none is under way. It returns a copy of the validator's case.
+func (s *IntegratedServer) fileEvidence(evidence Evidence) slashCase {
+	c, opened := s.addEvidence(evidence)
+	s.publish(&replicationEvent{Kind: eventEvidence, Evidence: &evidence})
+	if opened {
+		s.publish(&replicationEvent{Kind: eventVoteSession, FileID: evidence.ValidatorID, ClientID: slashClient})
+		s.pushVoteSession(evidence.ValidatorID, slashClient)
+	}
+	return c
+}
+
+// handleSlashVote records a validator's signed vote on slashing another.
+// The vote's file ID is the accused validator's ID, and approving it
+// slashes the validator.
+func (s *IntegratedServer) handleSlashVote(r *overlay.Request) (*overlay.Response, error) {
+	var vote SignedVote
+	if err := r.UnmarshalJSON(&vote); err != nil || vote.ClientID != slashClient {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	if vote.FileID == vote.ValidatorID {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Validators cannot vote on their own slashing"}`),
+		}, nil
+	}
+	if err := s.checkVote(&vote); err != nil {
+		return voteError(err), nil
+	}
+
+	if err := s.quorumManager.SubmitVote(vote.FileID, slashClient, vote.ValidatorID, vote.Approved); err != nil {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Failed to submit vote"}`),
+		}, nil
+	}
+	s.publish(&replicationEvent{
+		Kind:     eventVote,
+		FileID:   vote.FileID,
+		ClientID: slashClient,
+		PeerID:   vote.ValidatorID,
+		Approved: vote.Approved,
+		Vote:     &vote,
+	})
+	s.updateSlashing(vote.FileID)
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       []byte(`{"status":"success"}`),
+	}, nil
+}
+
+// handleSlashingQueue returns the validators under a slashing vote, oldest
+// case first
+func (s *IntegratedServer) handleSlashingQueue(_ *overlay.Request) (*overlay.Response, error) {
+	s.slashMu.Lock()
+	cases := []slashCase{}
+	for _, c := range s.slashing {
+		if c.State == caseOpen {
+			snapshot := *c
+			snapshot.Evidence = append([]Evidence(nil), c.Evidence...)
+			cases = append(cases, snapshot)
+		}
+	}
+	s.slashMu.Unlock()
+
+	sort.Slice(cases, func(i, j int) bool {
+		if cases[i].Opened != cases[j].Opened {
+			return cases[i].Opened < cases[j].Opened
+		}
+		return cases[i].ValidatorID < cases[j].ValidatorID
+	})
+
+	body, err := overlay.MarshalJSON(map[string]interface{}{"cases": cases})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       body,
+	}, nil
+}
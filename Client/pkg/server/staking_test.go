@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/ledger"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	libp2ppeer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStakeReplicated(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	origin := newKeyedValidator(t, m)
+	replica := newKeyedValidator(t, m)
+	key, staker := newValidatorKey(t)
+	for _, v := range []*IntegratedServer{origin, replica} {
+		v.quorumManager.RegisterValidator(origin.nodeID)
+		v.quorumManager.RegisterValidator(replica.nodeID)
+		_, err := v.ledger.Transfer("fund", "rewards", staker, 50, "")
+		require.NoError(t, err)
+	}
+
+	// Only the validator itself can change its stake
+	stake := map[string]interface{}{"validator_id": staker, "amount": 40}
+	assert.Equal(t, 403, postSigned(t, origin, nil, "/validator/stake", stake).StatusCode)
+	assert.Equal(t, 403, postSigned(t, origin, nil, "/validator/unstake", stake).StatusCode)
+
+	stake["amount"] = 80
+	assert.Equal(t, 402, postSigned(t, origin, key, "/validator/stake", stake).StatusCode)
+	stake["amount"] = 40
+	resp := postSigned(t, origin, key, "/validator/stake", stake)
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	var result struct {
+		Stake   int64 `json:"stake"`
+		Balance int64 `json:"balance"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &result))
+	assert.Equal(t, int64(40), result.Stake)
+	assert.Equal(t, int64(10), result.Balance)
+
+	assert.Eventually(t, func() bool {
+		return replica.ledger.StakeOf(staker) == 40
+	}, 2*time.Second, 10*time.Millisecond)
+
+	stake["amount"] = 15
+	require.Equal(t, 200, postSigned(t, origin, key, "/validator/unstake", stake).StatusCode)
+	assert.Eventually(t, func() bool {
+		return replica.ledger.StakeOf(staker) == 25 && replica.ledger.Balance(staker) == 25
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// newValidatorKey returns a key and the validator ID it signs votes for
+func newValidatorKey(t *testing.T) (crypto.PrivKey, string) {
+	t.Helper()
+	priv, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := libp2ppeer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+	return priv, id.String()
+}
+
+func signedVote(t *testing.T, key crypto.PrivKey, validatorID, fileID string, approved bool) SignedVote {
+	t.Helper()
+	vote := SignedVote{FileID: fileID, ClientID: "client", ValidatorID: validatorID, Approved: approved, Time: time.Now().Unix()}
+	require.NoError(t, SignVote(&vote, key))
+	return vote
+}
+
+// voter is a validator's key and the ID it votes as
+type voter struct {
+	key crypto.PrivKey
+	id  string
+}
+
+// newVoters registers n validators with s
+func newVoters(t *testing.T, s *IntegratedServer, n int) []voter {
+	t.Helper()
+	voters := make([]voter, n)
+	for i := range voters {
+		voters[i].key, voters[i].id = newValidatorKey(t)
+		s.quorumManager.RegisterValidator(voters[i].id)
+	}
+	return voters
+}
+
+// postVote posts a vote signed by v to a vote endpoint
+func postVote(t *testing.T, s *IntegratedServer, v voter, path, fileID, clientID string, approved bool) *overlay.Response {
+	t.Helper()
+	vote := SignedVote{FileID: fileID, ClientID: clientID, ValidatorID: v.id, Approved: approved, Time: time.Now().Unix()}
+	require.NoError(t, SignVote(&vote, v.key))
+	return postSigned(t, s, v.key, path, vote)
+}
+
+func TestSlashingConflictingVotes(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	s.EnableStaking()
+
+	voters := newVoters(t, s, 4)
+	accused, key := voters[0].id, voters[0].key
+	otherKey, _ := newValidatorKey(t)
+	for _, v := range voters {
+		_, err := s.ledger.Transfer("fund-"+v.id, "rewards", v.id, 20, "")
+		require.NoError(t, err)
+		require.Equal(t, 200, postSigned(t, s, v.key, "/validator/stake", map[string]interface{}{"validator_id": v.id, "amount": 20}).StatusCode)
+	}
+
+	approve := signedVote(t, key, accused, "file1", true)
+	reject := signedVote(t, key, accused, "file1", false)
+	forged := signedVote(t, otherKey, accused, "file1", false)
+	for _, evidence := range []Evidence{
+		{Kind: EvidenceConflictingVotes, ValidatorID: accused, Votes: []SignedVote{approve, approve}},
+		{Kind: EvidenceConflictingVotes, ValidatorID: accused, Votes: []SignedVote{approve, forged}},
+		{Kind: EvidenceConflictingVotes, ValidatorID: voters[1].id, Votes: []SignedVote{approve, reject}},
+		{Kind: "gossip", ValidatorID: accused, Votes: []SignedVote{approve}},
+	} {
+		assert.Equal(t, 400, postJSON(t, s, "/validator/evidence", evidence).StatusCode)
+	}
+
+	evidence := Evidence{Kind: EvidenceConflictingVotes, ValidatorID: accused, Votes: []SignedVote{approve, reject}}
+	resp := postJSON(t, s, "/validator/evidence", evidence)
+	require.Equal(t, 202, resp.StatusCode, string(resp.Body))
+	status, err := s.quorumManager.SessionStatus(accused, slashClient)
+	require.NoError(t, err)
+	assert.Equal(t, quorum.SessionPending, status)
+
+	// The accused can neither escape with its stake nor vote on its case
+	unstake := map[string]interface{}{"validator_id": accused, "amount": 20}
+	assert.Equal(t, 409, postSigned(t, s, key, "/validator/unstake", unstake).StatusCode)
+	assert.Equal(t, 403, postVote(t, s, voters[0], "/validator/slash/vote", accused, slashClient, false).StatusCode)
+
+	// Slash votes must be signed by the validator casting them
+	forgedVote := SignedVote{FileID: accused, ClientID: slashClient, ValidatorID: voters[1].id, Approved: true, Time: time.Now().Unix()}
+	require.NoError(t, SignVote(&forgedVote, otherKey))
+	assert.Equal(t, 401, postSigned(t, s, otherKey, "/validator/slash/vote", forgedVote).StatusCode)
+
+	for _, v := range voters[1:] {
+		require.Equal(t, 200, postVote(t, s, v, "/validator/slash/vote", accused, slashClient, true).StatusCode)
+	}
+	assert.Equal(t, int64(10), s.ledger.StakeOf(accused))
+	assert.Equal(t, int64(10), s.ledger.Balance(ledger.SlashedAccount))
+
+	// Once decided the stake can be withdrawn again
+	unstake["amount"] = 10
+	assert.Equal(t, 200, postSigned(t, s, key, "/validator/unstake", unstake).StatusCode)
+}
+
+// A validator signing conflicting votes in a session is caught from the
+// votes themselves
+func TestConflictingKeyVotesFiled(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+	voters := newVoters(t, s, 4)
+	clientKey, client := newValidatorKey(t)
+	for _, v := range voters {
+		_, err := s.ledger.Transfer("fund-"+v.id, "rewards", v.id, 20, "")
+		require.NoError(t, err)
+		require.Equal(t, 200, postSigned(t, s, v.key, "/validator/stake", map[string]interface{}{"validator_id": v.id, "amount": 20}).StatusCode)
+	}
+
+	request := map[string]interface{}{"file_id": "file1", "public_key": make([]byte, 32)}
+	require.Equal(t, 202, postSigned(t, s, clientKey, "/key/request", request).StatusCode)
+	require.Equal(t, 200, postVote(t, s, voters[0], "/key/vote", "file1", client, true).StatusCode)
+	require.Equal(t, 200, postVote(t, s, voters[0], "/key/vote", "file1", client, true).StatusCode)
+	assert.Equal(t, 409, postVote(t, s, voters[0], "/key/vote", "file1", client, false).StatusCode)
+
+	assert.True(t, s.slashPending(voters[0].id))
+	session, err := s.quorumManager.GetVoteSession("file1", client)
+	require.NoError(t, err)
+	votes := session.GetVotes()
+	require.Len(t, votes, 1)
+	assert.True(t, votes[0].Approved)
+
+	// Votes signed by another key, or too old, are refused
+	forged := SignedVote{FileID: "file1", ClientID: client, ValidatorID: voters[1].id, Approved: true, Time: time.Now().Unix()}
+	require.NoError(t, SignVote(&forged, voters[2].key))
+	assert.Equal(t, 401, postSigned(t, s, nil, "/key/vote", forged).StatusCode)
+	old := SignedVote{FileID: "file1", ClientID: client, ValidatorID: voters[1].id, Approved: true, Time: time.Now().Add(-2 * voteMaxAge).Unix()}
+	require.NoError(t, SignVote(&old, voters[1].key))
+	assert.Equal(t, 401, postSigned(t, s, nil, "/key/vote", old).StatusCode)
+}
+
+func TestSlashingEvidenceOfBadChunks(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	s := newMeshValidator(t, m, "v1")
+	s.isValidator = false // No replication
+
+	key, accused := newValidatorKey(t)
+	s.quorumManager.RegisterValidator(accused)
+	require.NoError(t, s.registry.RegisterFile(&registry.FileInfo{ID: "file1", Name: "a.zap"}))
+	evidence := Evidence{
+		Kind:        EvidenceBadChunks,
+		ValidatorID: accused,
+		Votes:       []SignedVote{signedVote(t, key, accused, "file1", true)},
+	}
+
+	// Validators without stake have nothing to slash
+	assert.Equal(t, 400, postJSON(t, s, "/validator/evidence", evidence).StatusCode)
+	_, err := s.ledger.Transfer("fund", "rewards", accused, 20, "")
+	require.NoError(t, err)
+	_, err = s.ledger.Stake("stake", accused, 20)
+	require.NoError(t, err)
+
+	// Approving a file is only misbehavior once it is found bad
+	assert.Equal(t, 400, postJSON(t, s, "/validator/evidence", evidence).StatusCode)
+	require.NoError(t, s.registry.RemoveFile("file1"))
+	require.Equal(t, 202, postJSON(t, s, "/validator/evidence", evidence).StatusCode)
+
+	resp, err := s.overlay.HandleRequest(&overlay.Request{Method: "GET", Path: "/validator/slashing"})
+	require.NoError(t, err)
+	var queue struct {
+		Cases []slashCase `json:"cases"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &queue))
+	require.Len(t, queue.Cases, 1)
+	assert.Equal(t, accused, queue.Cases[0].ValidatorID)
+	assert.Len(t, queue.Cases[0].Evidence, 1)
+
+	// Filing the same evidence again does not add to the case
+	require.Equal(t, 202, postJSON(t, s, "/validator/evidence", evidence).StatusCode)
+	s.slashMu.Lock()
+	assert.Len(t, s.slashing[accused].Evidence, 1)
+	s.slashMu.Unlock()
+}
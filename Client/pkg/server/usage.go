@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/policy"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+)
+
+// Operators may give each client a free allowance of downloaded bytes per
+// period. A key request counts the file's size against the client's
+// allowance and holds only the price of the bytes it does not cover. The
+// validators share usage through replication, so the allowance is one for
+// the network rather than one per validator.
+const usagePath = "/usage"
+
+// SetDownloadPolicy sets the allowance the validator gives each client
+func (s *IntegratedServer) SetDownloadPolicy(p policy.Policy) {
+	s.usage.SetPolicy(p)
+}
+
+// downloadCharge counts a download of file against a client's allowance,
+// returning what to hold for the bytes the allowance does not cover. Files
+// of unknown size are charged in full and not counted.
+func (s *IntegratedServer) downloadCharge(clientID string, file *registry.FileInfo) (int64, *policy.Grant) {
+	price := int64(file.ChunkCount) * chunkPrice
+	if file.TotalSize <= 0 {
+		return price, nil
+	}
+	grant := s.usage.Use(clientID, file.TotalSize)
+	amount := (price*grant.Charged + file.TotalSize - 1) / file.TotalSize
+	return amount, &grant
+}
+
+// applyUsage applies a download counted by another validator. The event
+// carries the client's signed key request, and the grant must cover the
+// size of the file it asked for. Each request is counted once.
+func (s *IntegratedServer) applyUsage(event *replicationEvent) error {
+	if event.Grant == nil || event.ClientID == "" {
+		return fmt.Errorf("usage event missing client or grant")
+	}
+	sr, err := openRequest(event.Request, "POST", "/key/request")
+	if err != nil {
+		return err
+	}
+	if sr.PeerID != event.ClientID {
+		return fmt.Errorf("key request not signed by the client")
+	}
+	var req struct {
+		FileID string `json:"file_id"`
+	}
+	if err := json.Unmarshal(sr.Body, &req); err != nil {
+		return fmt.Errorf("invalid key request")
+	}
+	file, exists := s.registry.GetFileByID(req.FileID)
+	if !exists || event.Grant.Free < 0 || event.Grant.Charged < 0 || event.Grant.Free+event.Grant.Charged != file.TotalSize {
+		return fmt.Errorf("grant does not match the requested file")
+	}
+	if !s.markSeen(eventUsage + "/" + sr.requestKey()) {
+		return nil
+	}
+
+	s.usage.Record(event.ClientID, *event.Grant)
+	return nil
+}
+
+func (s *IntegratedServer) handleUsage(r *overlay.Request) (*overlay.Response, error) {
+	var req struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil || req.ClientID == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	resp, err := overlay.MarshalJSON(s.usage.Usage(req.ClientID))
+	if err != nil {
+		return nil, err
+	}
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+// Usage returns the node's downloads this period and its free allowance as
+// the validators hold them
+func (s *IntegratedServer) Usage() (*policy.Usage, error) {
+	var usage policy.Usage
+	if err := s.askValidators(usagePath, map[string]string{"client_id": s.nodeID}, &usage); err != nil {
+		return nil, fmt.Errorf("failed to get usage: %v", err)
+	}
+	return &usage, nil
+}
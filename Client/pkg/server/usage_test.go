@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/policy"
+	"github.com/VetheonGames/FileZap/Client/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getUsage returns a client's usage as a validator reports it
+func getUsage(t *testing.T, s *IntegratedServer, clientID string) policy.Usage {
+	t.Helper()
+	resp := postJSON(t, s, usagePath, map[string]string{"client_id": clientID})
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	var usage policy.Usage
+	require.NoError(t, json.Unmarshal(resp.Body, &usage))
+	return usage
+}
+
+func TestDownloadAllowance(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	origin := newKeyedValidator(t, m)
+	replica := newKeyedValidator(t, m)
+	for _, v := range []*IntegratedServer{origin, replica} {
+		v.quorumManager.RegisterValidator(origin.nodeID)
+		v.quorumManager.RegisterValidator(replica.nodeID)
+		v.SetDownloadPolicy(policy.Policy{FreeBytes: 1500})
+	}
+	clientKey, client := newValidatorKey(t)
+	for _, v := range []*IntegratedServer{origin, replica} {
+		for _, id := range []string{"file1", "file2", "file3"} {
+			require.NoError(t, v.registry.RegisterFile(&registry.FileInfo{ID: id, Name: id + ".zap", ChunkCount: 10, TotalSize: 1000}))
+		}
+	}
+	_, err := origin.ledger.Transfer("fund", "rewards", client, 5, "")
+	require.NoError(t, err)
+	request := func(fileID string) int {
+		return postSigned(t, origin, clientKey, "/key/request", map[string]string{"file_id": fileID}).StatusCode
+	}
+
+	// The first file is free, the second half free
+	require.Equal(t, 202, request("file1"))
+	assert.Equal(t, int64(5), origin.ledger.Balance(client))
+	require.Equal(t, 202, request("file2"))
+	assert.Equal(t, int64(0), origin.ledger.Balance(client))
+
+	usage := getUsage(t, origin, client)
+	assert.Equal(t, int64(2000), usage.Bytes)
+	assert.Equal(t, int64(0), usage.FreeLeft)
+	assert.Equal(t, int64(1500), usage.FreeBytes)
+
+	// Refused requests do not count
+	assert.Equal(t, 402, request("file3"))
+	assert.Equal(t, int64(2000), getUsage(t, origin, client).Bytes)
+
+	// Other validators hold the same usage
+	assert.Eventually(t, func() bool {
+		usage := getUsage(t, replica, client)
+		return usage.Bytes == 2000 && usage.FreeLeft == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 400, postJSON(t, origin, usagePath, map[string]string{}).StatusCode)
+}
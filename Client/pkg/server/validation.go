@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A node can join or leave validation while it runs. Validators vote on key
+// requests and file removals, hold key shares and keep the ledger, so the
+// peers are told when a node joins or leaves and stop counting on a node
+// that left. The validator status lists the sessions waiting for this
+// node's vote and the votes it cast, from the vote audit log.
+const validatorHistoryLimit = 100
+
+// ErrNotValidator is returned for validation duties on a node that is not a
+// validator
+var ErrNotValidator = errors.New("this node is not a validator")
+
+// ValidatorStatus describes this node's part in validation
+type ValidatorStatus struct {
+	Validator     bool             `json:"validator"`
+	Validators    []string         `json:"validators"`       // Quorum members, sorted
+	RequiredVotes int              `json:"required_votes"`   // Votes that decide a session
+	Epoch         int64            `json:"epoch,omitempty"`  // Current committee epoch, when sessions are decided by committees
+	Stakes        map[string]int64 `json:"stakes,omitempty"` // Stake of each validator, when votes are weighted by it
+	Pending       []PendingVote    `json:"pending"`          // Oldest first
+	History       []VoteRecord     `json:"history"`          // Newest first
+}
+
+// PendingVote is a vote session that has not been decided
+type PendingVote struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name,omitempty"`
+	ClientID string `json:"client_id"`
+	Removal  bool   `json:"removal"`            // A vote on removing a reported file rather than on a key request
+	Slashing bool   `json:"slashing,omitempty"` // A vote on slashing the validator in FileID
+	Started  int64  `json:"started"`
+	Expires  int64  `json:"expires"`
+	Votes    int    `json:"votes"`
+	Voted    bool   `json:"voted"` // Whether this node voted
+}
+
+// VoteRecord is a vote this node cast
+type VoteRecord struct {
+	FileID   string `json:"file_id"`
+	ClientID string `json:"client_id"`
+	Removal  bool   `json:"removal"`
+	Approved bool   `json:"approved"`
+	Outcome  string `json:"outcome"` // Outcome of the session, pending until decided
+	Time     int64  `json:"time"`
+}
+
+// IsValidator reports whether this node takes part in validation
+func (s *IntegratedServer) IsValidator() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isValidator
+}
+
+// SetValidator makes this node join or leave validation and tells its peers.
+// Joining takes the node's peer key, to prove its ID to them.
+func (s *IntegratedServer) SetValidator(enabled bool) error {
+	s.mu.Lock()
+	if s.isValidator == enabled {
+		s.mu.Unlock()
+		return nil
+	}
+	key := s.peerKey
+	if enabled {
+		if key == nil {
+			s.mu.Unlock()
+			return ErrNoPeerKey
+		}
+		if id, err := peer.IDFromPrivateKey(key); err != nil || id.String() != s.nodeID {
+			s.mu.Unlock()
+			return fmt.Errorf("peer key does not match node ID %s", s.nodeID)
+		}
+	}
+	s.isValidator = enabled
+	stop := s.stopDuties
+	s.stopDuties = nil
+	s.mu.Unlock()
+
+	if enabled {
+		if err := s.joinValidatorNetwork(); err != nil {
+			return err
+		}
+		for _, peerID := range s.overlay.Peers() {
+			ctx, cancel := context.WithTimeout(s.ctx, replicationTimeout)
+			err := s.registerWith(ctx, peerID, key)
+			cancel()
+			if err != nil {
+				log.Printf("Failed to register as validator with %s: %v", peerID, err)
+			}
+		}
+		return nil
+	}
+
+	if stop != nil {
+		stop()
+	}
+	s.quorumManager.RemoveValidator(s.nodeID)
+
+	req, err := s.newRequest("POST", "/peer/unregister", map[string]string{"validator_id": s.nodeID})
+	if err != nil {
+		log.Printf("Cannot announce leaving validation: %v", err)
+		return nil
+	}
+	for _, peerID := range s.overlay.Peers() {
+		ctx, cancel := context.WithTimeout(s.ctx, replicationTimeout)
+		resp, err := s.overlay.SendMessage(ctx, peerID, req)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to announce validation change to %s: %v", peerID, err)
+			continue
+		}
+		if resp.StatusCode != 200 {
+			log.Printf("Peer %s rejected validation change: status %d", peerID, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// handlePeerUnregister removes a validator from the quorum. Only the
+// validator itself can leave, proven by the request's signature.
+func (s *IntegratedServer) handlePeerUnregister(r *overlay.Request) (*overlay.Response, error) {
+	var req struct {
+		ValidatorID string `json:"validator_id"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil || req.ValidatorID == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	if req.ValidatorID != r.PeerID {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Validators can only remove themselves"}`),
+		}, nil
+	}
+
+	s.quorumManager.RemoveValidator(req.ValidatorID)
+
+	return &overlay.Response{StatusCode: 200}, nil
+}
+
+// ValidatorStatus reports the quorum, the sessions waiting for a decision
+// and this node's recent votes
+func (s *IntegratedServer) ValidatorStatus() *ValidatorStatus {
+	status := &ValidatorStatus{
+		Validator:     s.IsValidator(),
+		Validators:    s.quorumManager.Validators(),
+		RequiredVotes: s.quorumManager.RequiredVotes(),
+		Epoch:         s.quorumManager.Epoch(),
+		Pending:       []PendingVote{},
+		History:       []VoteRecord{},
+	}
+	sort.Strings(status.Validators)
+	if s.quorumManager.StakeWeighted() {
+		status.Stakes = s.ledger.Stakes()
+	}
+
+	for _, session := range s.quorumManager.GetPendingSessions() {
+		// Sessions decided by other validators' committees wait on others
+		if !session.OnCommittee(s.nodeID) {
+			continue
+		}
+		pending := PendingVote{
+			FileID:   session.FileID,
+			ClientID: session.ClientID,
+			Removal:  session.ClientID == moderationClient,
+			Slashing: session.ClientID == slashClient,
+			Started:  session.StartTime,
+			Expires:  session.StartTime + session.TimeoutSecs,
+		}
+		if file, ok := s.registry.GetFileByID(session.FileID); ok {
+			pending.FileName = file.Name
+		}
+		for _, vote := range session.GetVotes() {
+			pending.Votes++
+			if vote.ValidatorID == s.nodeID {
+				pending.Voted = true
+			}
+		}
+		status.Pending = append(status.Pending, pending)
+	}
+	sort.Slice(status.Pending, func(i, j int) bool {
+		return status.Pending[i].Started < status.Pending[j].Started
+	})
+
+	// Walk the log from the newest entry, so each vote meets the decision
+	// of its session first
+	outcomes := make(map[string]string)
+	opts := types.ListOptions{Limit: types.MaxListLimit, Desc: true}
+	for len(status.History) < validatorHistoryLimit {
+		entries, total := s.voteAudit.Entries("", "", opts)
+		for _, entry := range entries {
+			key := entry.FileID + ":" + entry.ClientID
+			switch {
+			case entry.Kind == quorum.AuditDecision:
+				if _, seen := outcomes[key]; !seen {
+					outcomes[key] = entry.Outcome
+				}
+			case entry.Kind == quorum.AuditVote && entry.ValidatorID == s.nodeID:
+				outcome, decided := outcomes[key]
+				if !decided {
+					outcome = quorum.SessionPending
+				}
+				status.History = append(status.History, VoteRecord{
+					FileID:   entry.FileID,
+					ClientID: entry.ClientID,
+					Removal:  entry.ClientID == moderationClient,
+					Approved: entry.Approved,
+					Outcome:  outcome,
+					Time:     entry.Time,
+				})
+			case entry.Kind == quorum.AuditSession:
+				// Earlier entries of the same file and client belong to an
+				// older session
+				delete(outcomes, key)
+			}
+			if len(status.History) == validatorHistoryLimit {
+				break
+			}
+		}
+		opts.Offset += len(entries)
+		if len(entries) == 0 || opts.Offset >= total {
+			break
+		}
+	}
+	return status
+}
+
+// EnableCommittees has each vote session decided by a committee of size
+// validators, drawn afresh every epoch
+func (s *IntegratedServer) EnableCommittees(size int, epoch time.Duration) {
+	s.quorumManager.SetCommittees(size, epoch)
+}
+
+// CastVote votes on a pending session as this node. For a removal vote,
+// approving removes the file; for a slashing vote, it slashes the
+// validator.
+func (s *IntegratedServer) CastVote(fileID, clientID string, approve bool) error {
+	if !s.IsValidator() {
+		return ErrNotValidator
+	}
+	if err := s.quorumManager.SubmitVote(fileID, clientID, s.nodeID, approve); err != nil {
+		return fmt.Errorf("failed to submit vote: %v", err)
+	}
+	s.publish(&replicationEvent{
+		Kind:     eventVote,
+		FileID:   fileID,
+		ClientID: clientID,
+		PeerID:   s.nodeID,
+		Approved: approve,
+		Vote:     s.signVote(fileID, clientID, approve),
+	})
+	s.updateDecision(fileID, clientID)
+	return nil
+}
+
+// updateDecision applies the outcome of a session, if decided
+func (s *IntegratedServer) updateDecision(fileID, clientID string) {
+	switch clientID {
+	case moderationClient:
+		s.updateModeration(fileID)
+	case slashClient:
+		s.updateSlashing(fileID)
+	default:
+		s.updateKeyRequest(fileID, clientID)
+	}
+}
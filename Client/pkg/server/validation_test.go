@@ -0,0 +1,87 @@
+package server
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/VetheonGames/FileZap/Client/pkg/quorum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinAndLeaveValidation(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	key, nodeID := newValidatorKey(t)
+	v1 := newMeshValidator(t, m, "v1")
+	node := newMeshValidator(t, m, nodeID)
+	node.isValidator = false
+	for _, s := range []*IntegratedServer{v1, node} {
+		s.quorumManager.RegisterValidator("v1")
+	}
+
+	require.NoError(t, v1.quorumManager.CreateVoteSession("file1", "client1"))
+	assert.ErrorIs(t, node.CastVote("file1", "client1", true), ErrNotValidator)
+
+	// Joining takes the key proving the node's ID
+	assert.ErrorIs(t, node.SetValidator(true), ErrNoPeerKey)
+	otherKey, _ := newValidatorKey(t)
+	node.SetPeerKey(otherKey)
+	assert.Error(t, node.SetValidator(true))
+	assert.False(t, node.IsValidator())
+	node.SetPeerKey(key)
+
+	require.NoError(t, node.SetValidator(true))
+	assert.True(t, node.IsValidator())
+	assert.True(t, v1.quorumManager.IsValidator(nodeID))
+	validators := []string{nodeID, "v1"}
+	sort.Strings(validators)
+	assert.Equal(t, validators, node.ValidatorStatus().Validators)
+
+	// No one else can take the node out of the quorum
+	leave := map[string]string{"validator_id": nodeID}
+	assert.Equal(t, 400, postJSON(t, v1, "/peer/unregister", leave).StatusCode)
+	assert.Equal(t, 403, postSigned(t, v1, otherKey, "/peer/unregister", leave).StatusCode)
+	assert.True(t, v1.quorumManager.IsValidator(nodeID))
+
+	require.NoError(t, node.SetValidator(false))
+	assert.False(t, node.IsValidator())
+	assert.False(t, v1.quorumManager.IsValidator(nodeID))
+	assert.False(t, node.quorumManager.IsValidator(nodeID))
+}
+
+func TestValidatorStatus(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	v := newMeshValidator(t, m, "v1")
+	for _, id := range []string{"v1", "v2", "v3"} {
+		v.quorumManager.RegisterValidator(id)
+	}
+	require.NoError(t, v.RegisterFile(&FileInfo{ID: "file1", Name: "a.zap"}))
+
+	require.NoError(t, v.quorumManager.CreateVoteSession("file1", "client1"))
+	require.NoError(t, v.openRemovalVote("file2"))
+	require.NoError(t, v.CastVote("file2", moderationClient, false))
+
+	status := v.ValidatorStatus()
+	assert.True(t, status.Validator)
+	assert.Equal(t, 3, status.RequiredVotes)
+	require.Len(t, status.Pending, 2)
+	byFile := map[string]PendingVote{}
+	for _, pending := range status.Pending {
+		byFile[pending.FileID] = pending
+	}
+	assert.Equal(t, "a.zap", byFile["file1"].FileName)
+	assert.False(t, byFile["file1"].Voted)
+	assert.True(t, byFile["file2"].Removal)
+	assert.True(t, byFile["file2"].Voted)
+	assert.Equal(t, 1, byFile["file2"].Votes)
+
+	require.Len(t, status.History, 1)
+	assert.Equal(t, "file2", status.History[0].FileID)
+	assert.False(t, status.History[0].Approved)
+	assert.Equal(t, quorum.SessionPending, status.History[0].Outcome)
+
+	// Once decided, the history shows the outcome
+	v.quorumManager.RecordDecision("file2", moderationClient, quorum.SessionDenied)
+	assert.Equal(t, quorum.SessionDenied, v.ValidatorStatus().History[0].Outcome)
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When a key request or report opens a vote session, the validator it
+// arrived at pushes a vote_session notification to every other validator
+// over the overlay, carrying what they need to decide, so validators need
+// not poll for pending sessions. A validator can also have every session it
+// learns of, opened on it or replicated to it, posted as JSON to an HTTP
+// webhook. Posts are signed with the HMAC-SHA256 of the body under the
+// webhook's secret, if it has one.
+const (
+	voteSessionAction      = "vote_session"
+	webhookTimeout         = 10 * time.Second
+	webhookSignatureHeader = "X-FileZap-Signature"
+)
+
+// Kinds of vote session
+const (
+	VoteKindKeyRequest = "key_request"
+	VoteKindRemoval    = "removal"
+	VoteKindSlashing   = "slashing"
+)
+
+// VoteSessionNotice describes a new vote session to the validators who
+// must decide it
+type VoteSessionNotice struct {
+	Kind          string   `json:"kind"`
+	FileID        string   `json:"file_id"` // Accused validator of a slashing vote
+	FileName      string   `json:"file_name,omitempty"`
+	ClientID      string   `json:"client_id,omitempty"`  // Client requesting the key
+	RequestID     string   `json:"request_id,omitempty"` // Key request, known on the validator it arrived at
+	ChunkCount    int      `json:"chunk_count,omitempty"`
+	Price         int64    `json:"price,omitempty"`   // Held in escrow for the download
+	Reports       int      `json:"reports,omitempty"` // Reports filed against a file up for removal
+	Reason        string   `json:"reason,omitempty"`  // Reason given in the latest report, or kind of the latest evidence
+	Opened        int64    `json:"opened"`
+	Deadline      int64    `json:"deadline"` // When the session expires
+	RequiredVotes int      `json:"required_votes"`
+	Committee     []string `json:"committee,omitempty"` // Validators deciding the session, if not all
+	Validator     string   `json:"validator"`           // Validator sending the notice
+}
+
+// data flattens the notice for an overlay notification
+func (n *VoteSessionNotice) data() map[string]string {
+	data := map[string]string{
+		"kind":           n.Kind,
+		"file_id":        n.FileID,
+		"opened":         strconv.FormatInt(n.Opened, 10),
+		"deadline":       strconv.FormatInt(n.Deadline, 10),
+		"required_votes": strconv.Itoa(n.RequiredVotes),
+		"validator":      n.Validator,
+	}
+	if n.Committee != nil {
+		data["committee"] = strings.Join(n.Committee, ",")
+	}
+	optional := map[string]string{
+		"file_name":  n.FileName,
+		"client_id":  n.ClientID,
+		"request_id": n.RequestID,
+		"reason":     n.Reason,
+	}
+	if n.ChunkCount > 0 {
+		optional["chunk_count"] = strconv.Itoa(n.ChunkCount)
+	}
+	if n.Price > 0 {
+		optional["price"] = strconv.FormatInt(n.Price, 10)
+	}
+	if n.Reports > 0 {
+		optional["reports"] = strconv.Itoa(n.Reports)
+	}
+	for key, value := range optional {
+		if value != "" {
+			data[key] = value
+		}
+	}
+	return data
+}
+
+// SetVoteWebhook has the server post every new vote session to url, signing
+// posts with secret if it is not empty. An empty url turns the webhook off.
+func (s *IntegratedServer) SetVoteWebhook(url, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhookURL = url
+	s.webhookSecret = []byte(secret)
+}
+
+// voteSessionNotice describes a session opened on this node, or nil if it
+// has no such session
+func (s *IntegratedServer) voteSessionNotice(fileID, clientID string) *VoteSessionNotice {
+	session, err := s.quorumManager.GetVoteSession(fileID, clientID)
+	if err != nil {
+		return nil
+	}
+
+	notice := &VoteSessionNotice{
+		Kind:          VoteKindKeyRequest,
+		FileID:        fileID,
+		ClientID:      clientID,
+		Opened:        session.StartTime,
+		Deadline:      session.StartTime + session.TimeoutSecs,
+		RequiredVotes: session.RequiredVotes,
+		Committee:     session.Committee,
+		Validator:     s.nodeID,
+	}
+	if file, exists := s.registry.GetFileByID(fileID); exists {
+		notice.FileName = file.Name
+		notice.ChunkCount = file.ChunkCount
+	}
+
+	if clientID == moderationClient {
+		notice.Kind = VoteKindRemoval
+		notice.ClientID = ""
+		s.modMu.Lock()
+		if c, exists := s.moderation[fileID]; exists {
+			notice.Reports = len(c.Reports)
+			if len(c.Reports) > 0 {
+				notice.Reason = c.Reports[len(c.Reports)-1].Reason
+			}
+		}
+		s.modMu.Unlock()
+		return notice
+	}
+	if clientID == slashClient {
+		notice.Kind = VoteKindSlashing
+		notice.ClientID = ""
+		s.slashMu.Lock()
+		if c, exists := s.slashing[fileID]; exists && len(c.Evidence) > 0 {
+			notice.Reason = c.Evidence[len(c.Evidence)-1].Kind
+		}
+		s.slashMu.Unlock()
+		return notice
+	}
+
+	notice.Price = int64(notice.ChunkCount) * chunkPrice
+	if req, err := s.keyManager.GetKeyRequest(fileID, clientID); err == nil {
+		notice.RequestID = req.ID
+	}
+	return notice
+}
+
+// pushVoteSession notifies the other validators deciding a session opened
+// here
+func (s *IntegratedServer) pushVoteSession(fileID, clientID string) {
+	notice := s.voteSessionNotice(fileID, clientID)
+	if notice == nil {
+		return
+	}
+
+	data := notice.data()
+	validators := notice.Committee
+	if validators == nil {
+		validators = s.quorumManager.Validators()
+	}
+	for _, validatorID := range validators {
+		if validatorID == s.nodeID {
+			continue
+		}
+		if err := s.overlay.NotifyPeer(validatorID, voteSessionAction, data); err != nil {
+			log.Printf("Failed to notify validator %s of vote on %s: %v", validatorID, fileID, err)
+		}
+	}
+}
+
+// postVoteWebhook posts a new session to the webhook, if one is set. The
+// notice is built in the background, as sessions may be opened with modMu
+// held.
+func (s *IntegratedServer) postVoteWebhook(fileID, clientID string) {
+	s.mu.RLock()
+	url, secret := s.webhookURL, s.webhookSecret
+	s.mu.RUnlock()
+	if url == "" {
+		return
+	}
+
+	go func() {
+		notice := s.voteSessionNotice(fileID, clientID)
+		if notice == nil {
+			return
+		}
+		body, err := json.Marshal(notice)
+		if err != nil {
+			log.Printf("Failed to marshal vote session notice: %v", err)
+			return
+		}
+		if err := postWebhook(s.ctx, url, secret, body); err != nil {
+			log.Printf("Failed to post vote on %s to webhook: %v", fileID, err)
+		}
+	}()
+}
+
+// postWebhook posts a JSON body to url, signed with secret if set
+func postWebhook(ctx context.Context, url string, secret, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookSignature returns the hex HMAC-SHA256 of body under secret
+func webhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
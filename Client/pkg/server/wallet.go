@@ -0,0 +1,433 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/ledger"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A node's account is its peer ID, which it signs delivery receipts with.
+// The account exists once something is credited to it: a deposit made on
+// chain and confirmed by the validators' DepositVerifier, or a grant from
+// the faucet validators run on test networks. A node funds its downloads
+// before fetching chunks, claiming from the faucet if its balance falls
+// short, so key requests are not refused for want of funds. Faucet claims
+// are signed by their account, which must be a validator or answer the
+// admission challenge validators join with, so that peer IDs minted for
+// free cannot draw funds.
+const (
+	faucetAccount  = "faucet"   // Issues faucet grants
+	depositAccount = "deposits" // Issues the credit of chain deposits
+	faucetInterval = 24 * time.Hour
+)
+
+// DepositVerifier confirms a chain transaction paying into the network,
+// returning the account it credits and the amount
+type DepositVerifier func(txID string) (account string, amount int64, err error)
+
+// EnableFaucet has the validator grant amount to each account that asks
+// once a day, for test networks. An amount of zero turns the faucet off.
+func (s *IntegratedServer) EnableFaucet(amount int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faucetAmount = amount
+}
+
+// SetDepositVerifier has the validator credit deposits confirmed by verify
+func (s *IntegratedServer) SetDepositVerifier(verify DepositVerifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.depositVerifier = verify
+}
+
+// applyCredit applies a faucet grant or deposit event to the ledger. Events
+// carry their idempotency key, so replays credit once.
+func (s *IntegratedServer) applyCredit(event *replicationEvent) error {
+	if event.Kind == eventFaucet {
+		_, err := s.ledger.Transfer(event.Key, faucetAccount, event.ClientID, event.Amount, "faucet")
+		return err
+	}
+	_, err := s.ledger.Transfer(event.Key, depositAccount, event.ClientID, event.Amount, event.Reason)
+	return err
+}
+
+// balanceResponse returns an account's balance
+func (s *IntegratedServer) balanceResponse(account string) (*overlay.Response, error) {
+	resp, err := overlay.MarshalJSON(map[string]interface{}{
+		"account": account,
+		"balance": s.ledger.Balance(account),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlay.Response{
+		StatusCode: 200,
+		Body:       resp,
+	}, nil
+}
+
+func (s *IntegratedServer) handleAccountBalance(r *overlay.Request) (*overlay.Response, error) {
+	var req struct {
+		Account string `json:"account"`
+	}
+	if err := r.UnmarshalJSON(&req); err != nil || req.Account == "" {
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+	return s.balanceResponse(req.Account)
+}
+
+func (s *IntegratedServer) handleFaucet(r *overlay.Request) (*overlay.Response, error) {
+	event, registration, err := s.faucetClaim(r.Signed)
+	switch {
+	case errors.Is(err, errNotPeerAccount):
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Account must be a peer ID"}`),
+		}, nil
+	case errors.Is(err, errNotOwnAccount):
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Faucet funds can only be claimed by their account"}`),
+		}, nil
+	case errors.Is(err, errFaucetDisabled):
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"Faucet is disabled"}`),
+		}, nil
+	case err != nil:
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       []byte(`{"error":"Invalid request body"}`),
+		}, nil
+	}
+
+	if _, claimed := s.ledger.Lookup(event.Key); claimed {
+		return &overlay.Response{
+			StatusCode: 429,
+			Body:       []byte(`{"error":"Faucet already claimed today"}`),
+		}, nil
+	}
+	if err := s.admitFaucetClaim(event.ClientID, registration, true); err != nil {
+		return &overlay.Response{
+			StatusCode: 403,
+			Body:       []byte(`{"error":"Faucet claims need a validator or an answered challenge"}`),
+		}, nil
+	}
+	if err := s.applyCredit(event); err != nil {
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to grant funds"}`),
+		}, nil
+	}
+	s.publish(event)
+
+	return s.balanceResponse(event.ClientID)
+}
+
+// Errors refusing a faucet claim or deposit
+var (
+	errNotPeerAccount = errors.New("account must be a peer ID")
+	errNotOwnAccount  = errors.New("faucet funds can only be claimed by their account")
+	errFaucetDisabled = errors.New("faucet is disabled")
+	errNoDeposits     = errors.New("deposits are not accepted")
+)
+
+// faucetClaim returns the grant a signed faucet claim asks for and the
+// registration admitting its account. Its key names the account and the
+// day the claim was signed, so each account is granted once a day.
+func (s *IntegratedServer) faucetClaim(signed json.RawMessage) (*replicationEvent, *Registration, error) {
+	sr, err := openRequest(signed, "POST", "/account/faucet")
+	if err != nil {
+		return nil, nil, err
+	}
+	var req struct {
+		Account      string        `json:"account"`
+		Registration *Registration `json:"registration,omitempty"` // Admits accounts that are not validators
+	}
+	if err := json.Unmarshal(sr.Body, &req); err != nil {
+		return nil, nil, fmt.Errorf("invalid faucet claim")
+	}
+	if _, err := peer.Decode(req.Account); err != nil {
+		return nil, nil, errNotPeerAccount
+	}
+	if req.Account != sr.PeerID {
+		return nil, nil, errNotOwnAccount
+	}
+
+	s.mu.RLock()
+	amount := s.faucetAmount
+	s.mu.RUnlock()
+	if amount <= 0 {
+		return nil, nil, errFaucetDisabled
+	}
+
+	period := sr.Time / int64(faucetInterval)
+	return &replicationEvent{
+		Kind:     eventFaucet,
+		ClientID: req.Account,
+		Amount:   amount,
+		Key:      fmt.Sprintf("%s/%s/%d", eventFaucet, req.Account, period),
+		Request:  signed,
+	}, req.Registration, nil
+}
+
+// admitFaucetClaim checks that an account is a validator, or else that
+// registration answers a challenge for it. Claims made here must answer one
+// of our challenges; claims replicated from other validators answered
+// theirs.
+func (s *IntegratedServer) admitFaucetClaim(account string, registration *Registration, local bool) error {
+	if s.quorumManager.IsValidator(account) {
+		return nil
+	}
+	if registration == nil {
+		return fmt.Errorf("no registration")
+	}
+	if registration.ValidatorID != account {
+		return fmt.Errorf("registration is for %s", registration.ValidatorID)
+	}
+	if local && !s.takeChallenge(registration.Challenge) {
+		return fmt.Errorf("challenge unknown or expired")
+	}
+	return s.checkRegistration(registration)
+}
+
+func (s *IntegratedServer) handleDeposit(r *overlay.Request) (*overlay.Response, error) {
+	event, err := s.depositCredit(r.Signed)
+	switch {
+	case errors.Is(err, errNoDeposits):
+		return &overlay.Response{
+			StatusCode: 404,
+			Body:       []byte(`{"error":"Deposits are not accepted"}`),
+		}, nil
+	case err != nil:
+		body, _ := overlay.MarshalJSON(map[string]string{"error": fmt.Sprintf("Deposit refused: %v", err)})
+		return &overlay.Response{
+			StatusCode: 400,
+			Body:       body,
+		}, nil
+	}
+
+	if err := s.applyCredit(event); err != nil {
+		return &overlay.Response{
+			StatusCode: 500,
+			Body:       []byte(`{"error":"Failed to credit deposit"}`),
+		}, nil
+	}
+	s.publish(event)
+
+	return s.balanceResponse(event.ClientID)
+}
+
+// depositCredit returns the credit of the deposit a signed request submits,
+// as the DepositVerifier confirms it. A deposit is credited once however
+// often it is submitted.
+func (s *IntegratedServer) depositCredit(signed json.RawMessage) (*replicationEvent, error) {
+	sr, err := openRequest(signed, "POST", "/account/deposit")
+	if err != nil {
+		return nil, err
+	}
+	var req struct {
+		TxID string `json:"tx_id"`
+	}
+	if err := json.Unmarshal(sr.Body, &req); err != nil || req.TxID == "" {
+		return nil, fmt.Errorf("invalid request body")
+	}
+
+	s.mu.RLock()
+	verify := s.depositVerifier
+	s.mu.RUnlock()
+	if verify == nil {
+		return nil, errNoDeposits
+	}
+	account, amount, err := verify(req.TxID)
+	if err == nil && amount <= 0 {
+		err = fmt.Errorf("deposit pays nothing")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &replicationEvent{
+		Kind:     eventDeposit,
+		ClientID: account,
+		Amount:   amount,
+		Key:      eventDeposit + "/" + req.TxID,
+		Reason:   "deposit " + req.TxID,
+		Request:  signed,
+	}, nil
+}
+
+// checkCredit rebuilds a replicated faucet grant or deposit from the
+// request it carries
+func (s *IntegratedServer) checkCredit(event *replicationEvent) (*replicationEvent, error) {
+	if event.Kind == eventDeposit {
+		return s.depositCredit(event.Request)
+	}
+	credit, registration, err := s.faucetClaim(event.Request)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admitFaucetClaim(credit.ClientID, registration, false); err != nil {
+		return nil, err
+	}
+	return credit, nil
+}
+
+// askValidators sends a request to the validators in turn, this node
+// included, and decodes the first successful response into out. It returns
+// the last status or error if none succeeds.
+func (s *IntegratedServer) askValidators(path string, body interface{}, out interface{}) error {
+	req, err := s.newRequest("POST", path, body)
+	if err != nil {
+		return err
+	}
+
+	lastErr := fmt.Errorf("no validators known")
+	for _, validatorID := range s.quorumManager.Validators() {
+		if err := s.askValidator(validatorID, req, out); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// askValidator sends a request to one validator, decoding its answer into
+// out
+func (s *IntegratedServer) askValidator(validatorID string, req *overlay.Request, out interface{}) error {
+	var resp *overlay.Response
+	var err error
+	if validatorID == s.nodeID {
+		resp, err = s.overlay.HandleRequest(req)
+	} else {
+		resp, err = s.overlay.SendMessage(s.ctx, validatorID, req)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(resp.Body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s (status %d)", apiErr.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.Unmarshal(resp.Body, out)
+}
+
+// Balance returns the node's balance as the validators hold it
+func (s *IntegratedServer) Balance() (int64, error) {
+	var resp struct {
+		Balance int64 `json:"balance"`
+	}
+	if err := s.askValidators("/account/balance", map[string]string{"account": s.nodeID}, &resp); err != nil {
+		return 0, fmt.Errorf("failed to get balance: %v", err)
+	}
+	return resp.Balance, nil
+}
+
+// ClaimFaucet asks the validators' faucet for funds, returning the new
+// balance. Nodes that are not validators answer the challenge of each
+// validator they ask.
+func (s *IntegratedServer) ClaimFaucet() (int64, error) {
+	s.mu.RLock()
+	key := s.peerKey
+	s.mu.RUnlock()
+	if key == nil {
+		return 0, fmt.Errorf("failed to claim from faucet: %w", ErrNoPeerKey)
+	}
+
+	var resp struct {
+		Balance int64 `json:"balance"`
+	}
+	lastErr := fmt.Errorf("no validators known")
+	for _, validatorID := range s.quorumManager.Validators() {
+		claim := map[string]interface{}{"account": s.nodeID}
+		if !s.quorumManager.IsValidator(s.nodeID) {
+			registration, err := s.answerChallenge(s.ctx, validatorID, key)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			claim["registration"] = registration
+		}
+		req, err := s.newRequest("POST", "/account/faucet", claim)
+		if err != nil {
+			return 0, fmt.Errorf("failed to claim from faucet: %v", err)
+		}
+		if err := s.askValidator(validatorID, req, &resp); err != nil {
+			lastErr = err
+			continue
+		}
+		return resp.Balance, nil
+	}
+	return 0, fmt.Errorf("failed to claim from faucet: %v", lastErr)
+}
+
+// Deposit has the validators credit a chain transaction paying into the
+// network, returning the new balance of the account it paid
+func (s *IntegratedServer) Deposit(txID string) (int64, error) {
+	var resp struct {
+		Balance int64 `json:"balance"`
+	}
+	if err := s.askValidators("/account/deposit", map[string]string{"tx_id": txID}, &resp); err != nil {
+		return 0, fmt.Errorf("failed to deposit: %v", err)
+	}
+	return resp.Balance, nil
+}
+
+// FundDownload makes sure the node can pay for downloading a file, claiming
+// from the faucet if its balance is short. The price is that of the file's
+// registration, or of the manifest's chunks if the file is not registered
+// here, less what the node's free allowance covers. Nothing is charged
+// without validators.
+func (s *IntegratedServer) FundDownload(info *FileInfo) error {
+	if len(s.quorumManager.Validators()) == 0 {
+		return nil
+	}
+	price := int64(len(info.Chunks)) * chunkPrice
+	size := info.TotalSize
+	if file, exists := s.registry.GetFileByID(info.ID); exists {
+		price = int64(file.ChunkCount) * chunkPrice
+		size = file.TotalSize
+	}
+	if price == 0 {
+		return nil
+	}
+
+	balance, err := s.Balance()
+	if err != nil {
+		return err
+	}
+	if balance >= price {
+		return nil
+	}
+	if size > 0 {
+		if usage, err := s.Usage(); err == nil {
+			charged := max(size-usage.FreeLeft, 0)
+			price = (price*charged + size - 1) / size
+		}
+		if balance >= price {
+			return nil
+		}
+	}
+	if claimed, err := s.ClaimFaucet(); err == nil {
+		balance = claimed
+	}
+	if balance < price {
+		return fmt.Errorf("%w: balance %d, the download costs %d", ledger.ErrInsufficientFunds, balance, price)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/Client/pkg/ledger"
+	"github.com/VetheonGames/FileZap/Client/pkg/overlay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaucet(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	origin := newKeyedValidator(t, m)
+	replica := newKeyedValidator(t, m)
+	for _, v := range []*IntegratedServer{origin, replica} {
+		v.quorumManager.RegisterValidator(origin.nodeID)
+		v.quorumManager.RegisterValidator(replica.nodeID)
+		v.SetAdmission(8, 0)
+	}
+	origin.EnableFaucet(10)
+	key, account := newValidatorKey(t)
+
+	// answer registers account for a challenge of s
+	answer := func(s *IntegratedServer) *Registration {
+		c, err := s.issueChallenge()
+		require.NoError(t, err)
+		registration, err := NewRegistration(c, key, false)
+		require.NoError(t, err)
+		return registration
+	}
+
+	claim := map[string]interface{}{"account": account, "registration": answer(origin)}
+	assert.Equal(t, 404, postSigned(t, replica, key, "/account/faucet", claim).StatusCode)
+	replica.EnableFaucet(10)
+	assert.Equal(t, 400, postSigned(t, origin, key, "/account/faucet", map[string]string{"account": "nobody"}).StatusCode)
+
+	// Only the account may claim, and only with an answered challenge
+	assert.Equal(t, 403, postSigned(t, origin, nil, "/account/faucet", claim).StatusCode)
+	assert.Equal(t, 403, postSigned(t, origin, key, "/account/faucet", map[string]string{"account": account}).StatusCode)
+	unanswered := answer(origin)
+	unanswered.Nonce++
+	for workDone(unanswered, 8) {
+		unanswered.Nonce++
+	}
+	assert.Equal(t, 403, postSigned(t, origin, key, "/account/faucet", map[string]interface{}{"account": account, "registration": unanswered}).StatusCode)
+
+	resp := postSigned(t, origin, key, "/account/faucet", claim)
+	require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	var result struct {
+		Balance int64 `json:"balance"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body, &result))
+	assert.Equal(t, int64(10), result.Balance)
+	claim["registration"] = answer(origin)
+	assert.Equal(t, 429, postSigned(t, origin, key, "/account/faucet", claim).StatusCode)
+
+	// The grant is replicated, and cannot be claimed again elsewhere
+	assert.Eventually(t, func() bool {
+		return replica.ledger.Balance(account) == 10
+	}, 2*time.Second, 10*time.Millisecond)
+	claim["registration"] = answer(replica)
+	assert.Equal(t, 429, postSigned(t, replica, key, "/account/faucet", claim).StatusCode)
+
+	// Validators claim without a challenge
+	validatorKey, validatorID := newValidatorKey(t)
+	origin.quorumManager.RegisterValidator(validatorID)
+	resp = postSigned(t, origin, validatorKey, "/account/faucet", map[string]string{"account": validatorID})
+	assert.Equal(t, 200, resp.StatusCode, string(resp.Body))
+}
+
+func TestDeposit(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	origin := newKeyedValidator(t, m)
+	replica := newKeyedValidator(t, m)
+	for _, v := range []*IntegratedServer{origin, replica} {
+		v.quorumManager.RegisterValidator(origin.nodeID)
+		v.quorumManager.RegisterValidator(replica.nodeID)
+	}
+
+	deposit := map[string]string{"tx_id": "tx1"}
+	assert.Equal(t, 404, postSigned(t, origin, nil, "/account/deposit", deposit).StatusCode)
+
+	for _, v := range []*IntegratedServer{origin, replica} {
+		v.SetDepositVerifier(func(txID string) (string, int64, error) {
+			if txID != "tx1" {
+				return "", 0, fmt.Errorf("unknown transaction")
+			}
+			return "client", 25, nil
+		})
+	}
+	assert.Equal(t, 400, postSigned(t, origin, nil, "/account/deposit", map[string]string{"tx_id": "tx2"}).StatusCode)
+
+	// Submitting a deposit again credits it once
+	for i := 0; i < 2; i++ {
+		resp := postSigned(t, origin, nil, "/account/deposit", deposit)
+		require.Equal(t, 200, resp.StatusCode, string(resp.Body))
+	}
+	assert.Equal(t, int64(25), origin.ledger.Balance("client"))
+	assert.Eventually(t, func() bool {
+		return replica.ledger.Balance("client") == 25
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestFundDownload(t *testing.T) {
+	m := &mesh{nodes: make(map[string]overlay.Adapter)}
+	validator := newMeshValidator(t, m, "v1")
+	validator.EnableFaucet(5)
+	key, nodeID := newValidatorKey(t)
+	node := newMeshValidator(t, m, nodeID)
+	node.isValidator = false
+	node.SetPeerKey(key)
+
+	// Without validators nothing is charged
+	file := &FileInfo{ID: "file1", Chunks: make([]ChunkInfo, 3)}
+	require.NoError(t, node.FundDownload(file))
+	_, err := node.Balance()
+	assert.Error(t, err)
+
+	// A node without funds claims from the faucet
+	node.quorumManager.RegisterValidator("v1")
+	require.NoError(t, node.FundDownload(file))
+	balance, err := node.Balance()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), balance)
+
+	large := &FileInfo{ID: "file2", Chunks: make([]ChunkInfo, 10)}
+	assert.ErrorIs(t, node.FundDownload(large), ledger.ErrInsufficientFunds)
+
+	_, err = validator.ledger.Transfer("fund", "rewards", nodeID, 5, "")
+	require.NoError(t, err)
+	assert.NoError(t, node.FundDownload(large))
+}
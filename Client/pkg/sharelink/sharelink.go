@@ -0,0 +1,124 @@
+package sharelink
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A share link names a file by ID so it can be sent out of band, e.g. in a
+// chat message:
+//
+//	filezap://<file ID>?name=<file name>&key=<wrapped key>
+//
+// The key is optional. When present it is the file's encryption key sealed
+// with a passphrase, which is shared separately, so the link alone does not
+// decrypt the file. The sealed key is a random scrypt salt, then a
+// secretbox nonce, then the box.
+const (
+	Scheme = "filezap"
+
+	saltSize  = 16
+	nonceSize = 24
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+)
+
+// ErrWrongPassphrase is returned when a wrapped key does not open
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupt key")
+
+// Link is a shared file
+type Link struct {
+	FileID     string
+	Name       string
+	WrappedKey []byte // Empty if the key is not shared
+}
+
+// String encodes the link as a filezap:// URI
+func (l *Link) String() string {
+	query := url.Values{}
+	if l.Name != "" {
+		query.Set("name", l.Name)
+	}
+	if len(l.WrappedKey) > 0 {
+		query.Set("key", base64.RawURLEncoding.EncodeToString(l.WrappedKey))
+	}
+	u := url.URL{Scheme: Scheme, Host: l.FileID, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// Parse decodes a filezap:// URI
+func Parse(link string) (*Link, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid share link: %v", err)
+	}
+	if u.Scheme != Scheme {
+		return nil, fmt.Errorf("invalid share link: scheme must be %s://", Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid share link: missing file ID")
+	}
+
+	l := &Link{FileID: u.Host, Name: u.Query().Get("name")}
+	if key := u.Query().Get("key"); key != "" {
+		if l.WrappedKey, err = base64.RawURLEncoding.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("invalid share link key: %v", err)
+		}
+	}
+	return l, nil
+}
+
+// WrapKey seals a file key with a passphrase
+func WrapKey(key []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	secret, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := append(salt, nonce[:]...)
+	return secretbox.Seal(wrapped, key, &nonce, secret), nil
+}
+
+// UnwrapKey opens a key sealed with WrapKey
+func UnwrapKey(wrapped []byte, passphrase string) ([]byte, error) {
+	if len(wrapped) < saltSize+nonceSize+secretbox.Overhead {
+		return nil, ErrWrongPassphrase
+	}
+	secret, err := deriveKey(passphrase, wrapped[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], wrapped[saltSize:saltSize+nonceSize])
+
+	key, ok := secretbox.Open(nil, wrapped[saltSize+nonceSize:], &nonce, secret)
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+func deriveKey(passphrase string, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	var secret [32]byte
+	copy(secret[:], derived)
+	return &secret, nil
+}
@@ -0,0 +1,45 @@
+package sharelink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkRoundTrip(t *testing.T) {
+	wrapped, err := WrapKey([]byte("file key"), "correct horse")
+	require.NoError(t, err)
+
+	link := &Link{FileID: "abc123", Name: "My Photos & more.zip", WrappedKey: wrapped}
+	encoded := link.String()
+	assert.Contains(t, encoded, "filezap://abc123?")
+
+	parsed, err := Parse(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, link, parsed)
+
+	key, err := UnwrapKey(parsed.WrappedKey, "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("file key"), key)
+
+	_, err = UnwrapKey(parsed.WrappedKey, "wrong")
+	assert.ErrorIs(t, err, ErrWrongPassphrase)
+}
+
+func TestParseRejectsInvalidLinks(t *testing.T) {
+	for _, link := range []string{
+		"https://abc123",
+		"filezap://",
+		"filezap://abc123?key=!!!",
+	} {
+		_, err := Parse(link)
+		assert.Error(t, err, link)
+	}
+
+	// The key is optional
+	parsed, err := Parse("filezap://abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", parsed.FileID)
+	assert.Empty(t, parsed.WrappedKey)
+}
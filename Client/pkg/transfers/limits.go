@@ -0,0 +1,120 @@
+package transfers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limits caps the bandwidth and concurrency of transfers. Rates are in
+// bytes per second and shared by all transfers of a kind; zero means
+// unlimited.
+type Limits struct {
+	MaxUploadRate        int64
+	MaxDownloadRate      int64
+	MaxParallelTransfers int
+}
+
+// rateLimiter paces transfers to a byte rate. Each transferred chunk pushes
+// the earliest time the next one may start back by its size over the rate.
+type rateLimiter struct {
+	rate int64
+	next time.Time
+	mu   sync.Mutex
+}
+
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	if rate <= 0 {
+		l.next = time.Time{}
+	}
+}
+
+// wait accounts for n bytes and sleeps until the rate allows more
+func (l *rateLimiter) wait(ctx context.Context, n int64) error {
+	l.mu.Lock()
+	if l.rate <= 0 || n <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	delay := time.Until(l.next)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// SetLimits changes the transfer limits. Running transfers pick them up
+// with their next chunk.
+func (m *Manager) SetLimits(limits Limits) {
+	m.uploadRate.setRate(limits.MaxUploadRate)
+	m.downloadRate.setRate(limits.MaxDownloadRate)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limits = limits
+	m.wakeSlotWaitersLocked()
+}
+
+// Limits returns the current transfer limits
+func (m *Manager) Limits() Limits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.limits
+}
+
+// acquireSlot waits until fewer than MaxParallelTransfers transfers run
+func (m *Manager) acquireSlot(ctx context.Context) error {
+	for {
+		m.mu.Lock()
+		if m.limits.MaxParallelTransfers <= 0 || m.running < m.limits.MaxParallelTransfers {
+			m.running++
+			m.mu.Unlock()
+			return nil
+		}
+		wake := m.slotFreed
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// releaseSlot frees a slot taken by acquireSlot
+func (m *Manager) releaseSlot() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running--
+	m.wakeSlotWaitersLocked()
+}
+
+// wakeSlotWaitersLocked lets waiting transfers recheck for a free slot.
+// m.mu must be held.
+func (m *Manager) wakeSlotWaitersLocked() {
+	close(m.slotFreed)
+	m.slotFreed = make(chan struct{})
+}
+
+// rateFor returns the limiter of a transfer kind
+func (m *Manager) rateFor(kind string) *rateLimiter {
+	if kind == Upload {
+		return &m.uploadRate
+	}
+	return &m.downloadRate
+}
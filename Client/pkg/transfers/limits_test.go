@@ -0,0 +1,78 @@
+package transfers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunLimitsParallelTransfers(t *testing.T) {
+	m, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer m.Close()
+	m.SetLimits(Limits{MaxParallelTransfers: 1})
+
+	first, err := m.Start(Upload, "a.bin", "", []string{"a1"})
+	require.NoError(t, err)
+	second, err := m.Start(Download, "b.zap", "out", []string{"b1"})
+	require.NoError(t, err)
+
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	fn := func(_ context.Context, hash string) (int64, error) {
+		started <- hash
+		<-release
+		return 0, nil
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range []string{first.ID, second.ID} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			assert.NoError(t, m.Run(context.Background(), id, fn))
+		}(id)
+	}
+
+	// Only one transfer runs until the limit is raised
+	<-started
+	select {
+	case hash := <-started:
+		t.Fatalf("chunk %s started past the parallel limit", hash)
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.SetLimits(Limits{MaxParallelTransfers: 2})
+	<-started
+	close(release)
+	wg.Wait()
+}
+
+func TestRunPacesToRate(t *testing.T) {
+	m, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer m.Close()
+	m.SetLimits(Limits{MaxUploadRate: 1000})
+
+	tr, err := m.Start(Upload, "a.bin", "", []string{"c1", "c2"})
+	require.NoError(t, err)
+
+	// Two 100 byte chunks at 1000 bytes/s take at least 200ms
+	start := time.Now()
+	require.NoError(t, m.Run(context.Background(), tr.ID, func(context.Context, string) (int64, error) {
+		return 100, nil
+	}))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// Downloads are not limited by the upload rate
+	tr, err = m.Start(Download, "a.zap", "out", []string{"c1", "c2"})
+	require.NoError(t, err)
+	start = time.Now()
+	require.NoError(t, m.Run(context.Background(), tr.ID, func(context.Context, string) (int64, error) {
+		return 100, nil
+	}))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
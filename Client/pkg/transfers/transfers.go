@@ -0,0 +1,476 @@
+package transfers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Each transfer is stored in the transfers directory as <id>.json, holding
+// its description and state, and <id>.log, listing the chunks completed so
+// far one per line. A chunk is appended and synced as soon as it completes,
+// so after a restart a transfer resumes with the chunks it has not done.
+// Transfers that were active when the process stopped are active again on
+// open and are returned by Pending.
+const (
+	transfersDir = "transfers"
+	metaExt      = ".json"
+	progressExt  = ".log"
+)
+
+// Transfer kinds
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Transfer states
+const (
+	StateActive    = "active"
+	StatePaused    = "paused"
+	StateFailed    = "failed"
+	StateCompleted = "completed"
+)
+
+var (
+	// ErrNotFound is returned for unknown transfer IDs
+	ErrNotFound = errors.New("transfer not found")
+
+	// ErrPaused is returned by Run when the transfer was paused
+	ErrPaused = errors.New("transfer paused")
+)
+
+// Transfer is an upload or download of a file's chunks
+type Transfer struct {
+	ID      string   `json:"id"`
+	Kind    string   `json:"kind"`
+	Source  string   `json:"source"`           // File uploaded, or .zap file downloaded
+	Target  string   `json:"target,omitempty"` // Output path of a download
+	Chunks  []string `json:"chunks"`
+	State   string   `json:"state"`
+	Error   string   `json:"error,omitempty"` // Why the transfer failed
+	Created int64    `json:"created"`
+	Updated int64    `json:"updated"`
+
+	done     map[string]bool
+	progress *os.File
+}
+
+// Progress returns the number of chunks completed and in total
+func (t *Transfer) Progress() (int, int) {
+	return len(t.done), len(t.Chunks)
+}
+
+// StateHook is told of each transfer state change, with a snapshot of the
+// transfer. It is called with the manager locked, so it must not call back
+// into the manager.
+type StateHook func(t *Transfer)
+
+// Manager tracks transfers and persists their progress
+type Manager struct {
+	dir       string
+	transfers map[string]*Transfer
+	hook      StateHook
+	mu        sync.Mutex
+
+	limits       Limits
+	running      int           // Transfers holding a slot
+	slotFreed    chan struct{} // Closed when a slot may have freed up
+	uploadRate   rateLimiter
+	downloadRate rateLimiter
+}
+
+// Open loads the transfers stored in dataDir
+func Open(dataDir string) (*Manager, error) {
+	dir := filepath.Join(dataDir, transfersDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create transfers directory: %v", err)
+	}
+
+	m := &Manager{
+		dir:       dir,
+		transfers: make(map[string]*Transfer),
+		slotFreed: make(chan struct{}),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transfers: %v", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), metaExt) {
+			continue
+		}
+		t, err := m.load(strings.TrimSuffix(entry.Name(), metaExt))
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.transfers[t.ID] = t
+	}
+	return m, nil
+}
+
+// load reads a transfer and replays its progress log
+func (m *Manager) load(id string) (*Transfer, error) {
+	data, err := os.ReadFile(filepath.Join(m.dir, id+metaExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transfer %s: %v", id, err)
+	}
+	var t Transfer
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("corrupt transfer %s: %v", id, err)
+	}
+	t.done = make(map[string]bool)
+
+	progress, err := os.OpenFile(filepath.Join(m.dir, id+progressExt), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open progress of transfer %s: %v", id, err)
+	}
+
+	// A trailing line without a newline was never synced; drop it
+	reader := bufio.NewReader(progress)
+	var valid int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			progress.Close()
+			return nil, fmt.Errorf("failed to read progress of transfer %s: %v", id, err)
+		}
+		t.done[strings.TrimSpace(line)] = true
+		valid += int64(len(line))
+	}
+	if err := progress.Truncate(valid); err != nil {
+		progress.Close()
+		return nil, fmt.Errorf("failed to truncate progress of transfer %s: %v", id, err)
+	}
+	if _, err := progress.Seek(valid, io.SeekStart); err != nil {
+		progress.Close()
+		return nil, fmt.Errorf("failed to seek progress of transfer %s: %v", id, err)
+	}
+	t.progress = progress
+	return &t, nil
+}
+
+// Start records a new active transfer of chunks
+func (m *Manager) Start(kind, source, target string, chunks []string) (*Transfer, error) {
+	if kind != Upload && kind != Download {
+		return nil, fmt.Errorf("invalid transfer kind: %s", kind)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate transfer ID: %v", err)
+	}
+	now := time.Now().Unix()
+	t := &Transfer{
+		ID:      hex.EncodeToString(id),
+		Kind:    kind,
+		Source:  source,
+		Target:  target,
+		Chunks:  chunks,
+		State:   StateActive,
+		Created: now,
+		Updated: now,
+		done:    make(map[string]bool),
+	}
+
+	progress, err := os.OpenFile(filepath.Join(m.dir, t.ID+progressExt), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transfer progress: %v", err)
+	}
+	t.progress = progress
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.save(t); err != nil {
+		progress.Close()
+		return nil, err
+	}
+	m.transfers[t.ID] = t
+	return t.copy(), nil
+}
+
+// save atomically writes a transfer's description and state. m.mu must be
+// held.
+func (m *Manager) save(t *Transfer) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transfer: %v", err)
+	}
+
+	path := filepath.Join(m.dir, t.ID+metaExt)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save transfer: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to save transfer: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to save transfer: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to save transfer: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save transfer: %v", err)
+	}
+	return nil
+}
+
+// copy returns a snapshot of a transfer safe to hand out. m.mu must be held.
+func (t *Transfer) copy() *Transfer {
+	c := *t
+	c.Chunks = append([]string(nil), t.Chunks...)
+	c.done = make(map[string]bool, len(t.done))
+	for hash := range t.done {
+		c.done[hash] = true
+	}
+	c.progress = nil
+	return &c
+}
+
+// Get returns a snapshot of a transfer
+func (m *Manager) Get(id string) (*Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, exists := m.transfers[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return t.copy(), nil
+}
+
+// List returns snapshots of all transfers, oldest first
+func (m *Manager) List() []*Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*Transfer, 0, len(m.transfers))
+	for _, t := range m.transfers {
+		list = append(list, t.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Created != list[j].Created {
+			return list[i].Created < list[j].Created
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Pending returns the active transfers, e.g. to resume after a restart
+func (m *Manager) Pending() []*Transfer {
+	var pending []*Transfer
+	for _, t := range m.List() {
+		if t.State == StateActive {
+			pending = append(pending, t)
+		}
+	}
+	return pending
+}
+
+// Remaining returns the chunks of a transfer not yet completed, in order
+func (m *Manager) Remaining(id string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, exists := m.transfers[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	var remaining []string
+	for _, hash := range t.Chunks {
+		if !t.done[hash] {
+			remaining = append(remaining, hash)
+		}
+	}
+	return remaining, nil
+}
+
+// CompleteChunk durably records a chunk as transferred. The transfer
+// completes with its last chunk.
+func (m *Manager) CompleteChunk(id, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, exists := m.transfers[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if t.done[hash] {
+		return nil
+	}
+
+	if _, err := t.progress.WriteString(hash + "\n"); err != nil {
+		return fmt.Errorf("failed to record progress: %v", err)
+	}
+	if err := t.progress.Sync(); err != nil {
+		return fmt.Errorf("failed to sync progress: %v", err)
+	}
+	t.done[hash] = true
+
+	if len(t.done) >= len(t.Chunks) {
+		return m.setStateLocked(t, StateCompleted, "")
+	}
+	return nil
+}
+
+// SetStateHook sets the hook told of transfer state changes
+func (m *Manager) SetStateHook(hook StateHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hook = hook
+}
+
+// setStateLocked changes and saves a transfer's state. m.mu must be held.
+func (m *Manager) setStateLocked(t *Transfer, state, reason string) error {
+	t.State = state
+	t.Error = reason
+	t.Updated = time.Now().Unix()
+	if err := m.save(t); err != nil {
+		return err
+	}
+	if m.hook != nil {
+		m.hook(t.copy())
+	}
+	return nil
+}
+
+// setState moves a transfer to state, if it is in one of from
+func (m *Manager) setState(id, state, reason string, from ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, exists := m.transfers[id]
+	if !exists {
+		return ErrNotFound
+	}
+	for _, s := range from {
+		if t.State == s {
+			return m.setStateLocked(t, state, reason)
+		}
+	}
+	return fmt.Errorf("cannot move %s transfer to %s", t.State, state)
+}
+
+// Pause stops an active transfer after its current chunk
+func (m *Manager) Pause(id string) error {
+	return m.setState(id, StatePaused, "", StateActive)
+}
+
+// Resume reactivates a paused or failed transfer
+func (m *Manager) Resume(id string) error {
+	return m.setState(id, StateActive, "", StatePaused, StateFailed)
+}
+
+// Fail marks a transfer as failed
+func (m *Manager) Fail(id string, reason error) error {
+	return m.setState(id, StateFailed, reason.Error(), StateActive, StatePaused)
+}
+
+// Remove forgets a transfer and deletes its stored state
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, exists := m.transfers[id]
+	if !exists {
+		return ErrNotFound
+	}
+	t.progress.Close()
+	delete(m.transfers, id)
+
+	for _, ext := range []string{metaExt, progressExt} {
+		if err := os.Remove(filepath.Join(m.dir, id+ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove transfer: %v", err)
+		}
+	}
+	return nil
+}
+
+// Run transfers the remaining chunks of an active transfer one at a time
+// with fn, which returns the number of bytes it moved. It returns ErrPaused
+// if the transfer is paused, and fails the transfer if fn does. If ctx is
+// done the transfer stays active, to be resumed later. Run waits for a free
+// slot under MaxParallelTransfers and paces chunks to the transfer rate of
+// the transfer's kind.
+func (m *Manager) Run(ctx context.Context, id string, fn func(ctx context.Context, hash string) (int64, error)) error {
+	remaining, err := m.Remaining(id)
+	if err != nil {
+		return err
+	}
+	t, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	rate := m.rateFor(t.Kind)
+
+	if err := m.acquireSlot(ctx); err != nil {
+		return err
+	}
+	defer m.releaseSlot()
+
+	for _, hash := range remaining {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t, err := m.Get(id)
+		if err != nil {
+			return err
+		}
+		if t.State != StateActive {
+			return ErrPaused
+		}
+
+		n, err := fn(ctx, hash)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if failErr := m.Fail(id, err); failErr != nil {
+				return failErr
+			}
+			return fmt.Errorf("chunk %s: %v", hash, err)
+		}
+		if err := m.CompleteChunk(id, hash); err != nil {
+			return err
+		}
+		if err := rate.wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the progress logs
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var firstErr error
+	for _, t := range m.transfers {
+		if err := t.progress.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,151 @@
+package transfers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := Open(dir)
+	require.NoError(t, err)
+	tr, err := m.Start(Upload, "a.bin", "", []string{"c1", "c2", "c3"})
+	require.NoError(t, err)
+	require.NoError(t, m.CompleteChunk(tr.ID, "c1"))
+
+	// Reopen without Close, as after a crash
+	m, err = Open(dir)
+	require.NoError(t, err)
+	defer m.Close()
+
+	pending := m.Pending()
+	require.Len(t, pending, 1)
+	assert.Equal(t, tr.ID, pending[0].ID)
+	done, total := pending[0].Progress()
+	assert.Equal(t, 1, done)
+	assert.Equal(t, 3, total)
+
+	remaining, err := m.Remaining(tr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c2", "c3"}, remaining)
+}
+
+func TestTransferDropsTornProgress(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := Open(dir)
+	require.NoError(t, err)
+	tr, err := m.Start(Download, "a.zap", "out", []string{"c1", "c2"})
+	require.NoError(t, err)
+	require.NoError(t, m.CompleteChunk(tr.ID, "c1"))
+	require.NoError(t, m.Close())
+
+	// A write cut short by a crash leaves a line without a newline
+	f, err := os.OpenFile(filepath.Join(dir, transfersDir, tr.ID+progressExt), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("c2")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	m, err = Open(dir)
+	require.NoError(t, err)
+	defer m.Close()
+
+	remaining, err := m.Remaining(tr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c2"}, remaining)
+
+	require.NoError(t, m.CompleteChunk(tr.ID, "c2"))
+	got, err := m.Get(tr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StateCompleted, got.State)
+}
+
+func TestRunPauseAndFailure(t *testing.T) {
+	m, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer m.Close()
+
+	tr, err := m.Start(Upload, "a.bin", "", []string{"c1", "c2", "c3"})
+	require.NoError(t, err)
+
+	// Pausing during the first chunk stops the run before the second
+	var sent []string
+	err = m.Run(context.Background(), tr.ID, func(_ context.Context, hash string) (int64, error) {
+		sent = append(sent, hash)
+		return 0, m.Pause(tr.ID)
+	})
+	assert.ErrorIs(t, err, ErrPaused)
+	assert.Equal(t, []string{"c1"}, sent)
+
+	// A failing chunk fails the transfer and keeps earlier progress
+	require.NoError(t, m.Resume(tr.ID))
+	err = m.Run(context.Background(), tr.ID, func(_ context.Context, hash string) (int64, error) {
+		if hash == "c3" {
+			return 0, errors.New("no storage nodes")
+		}
+		return 0, nil
+	})
+	assert.Error(t, err)
+	got, err := m.Get(tr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StateFailed, got.State)
+	assert.Equal(t, "no storage nodes", got.Error)
+	done, _ := got.Progress()
+	assert.Equal(t, 2, done)
+
+	// Resuming retries only the failed chunk
+	require.NoError(t, m.Resume(tr.ID))
+	sent = nil
+	require.NoError(t, m.Run(context.Background(), tr.ID, func(_ context.Context, hash string) (int64, error) {
+		sent = append(sent, hash)
+		return 0, nil
+	}))
+	assert.Equal(t, []string{"c3"}, sent)
+	got, err = m.Get(tr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StateCompleted, got.State)
+}
+
+func TestRemoveTransfer(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := Open(dir)
+	require.NoError(t, err)
+	defer m.Close()
+
+	tr, err := m.Start(Upload, "a.bin", "", []string{"c1"})
+	require.NoError(t, err)
+	require.NoError(t, m.Remove(tr.ID))
+
+	_, err = m.Get(tr.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	entries, err := os.ReadDir(filepath.Join(dir, transfersDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestStateHook(t *testing.T) {
+	m, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer m.Close()
+
+	var states []string
+	m.SetStateHook(func(tr *Transfer) {
+		states = append(states, tr.State)
+	})
+
+	tr, err := m.Start(Download, "a.zap", "out", []string{"c1"})
+	require.NoError(t, err)
+	require.NoError(t, m.Pause(tr.ID))
+	require.NoError(t, m.Resume(tr.ID))
+	require.NoError(t, m.CompleteChunk(tr.ID, "c1"))
+	assert.Equal(t, []string{StatePaused, StateActive, StateCompleted}, states)
+}
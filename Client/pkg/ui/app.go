@@ -2,7 +2,9 @@ package ui
 
 import (
     "fmt"
+    "path/filepath"
     "strconv"
+    "strings"
     "time"
     
     "fyne.io/fyne/v2"
@@ -13,7 +15,15 @@ import (
     "fyne.io/fyne/v2/theme"
     "fyne.io/fyne/v2/widget"
     
+    "github.com/VetheonGames/FileZap/Client/pkg/autostart"
     "github.com/VetheonGames/FileZap/Client/pkg/client"
+    "github.com/VetheonGames/FileZap/Client/pkg/contacts"
+    "github.com/VetheonGames/FileZap/Client/pkg/events"
+    "github.com/VetheonGames/FileZap/Client/pkg/library"
+    "github.com/VetheonGames/FileZap/Client/pkg/server"
+    "github.com/VetheonGames/FileZap/Client/pkg/sharelink"
+    "github.com/VetheonGames/FileZap/Client/pkg/transfers"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
 )
 
 type FileZapUI struct {
@@ -24,21 +34,74 @@ type FileZapUI struct {
 
     // UI Components
     peerList     *widget.List
-    peerData     []string
+    peerData     []network.PeerStats
     selectedPeer int
-    status       *widget.Label
+    peerDetail   *widget.Label
+    status       *widget.Label  // Tasks running in the background
+    bell         *widget.Button // Opens the notification center
+    tasks        taskCenter
     storageStats *widget.Label
+
+    storageToggle *widget.Check // Also paused and resumed from the tray
+    hasTray       bool
+    trayMenu      *fyne.Menu
+    pauseItem     *fyne.MenuItem
+
+    earningsSummary *widget.Label
+    earningsChart   *barChart
+    earningsTable   *widget.Table
+    earningsFiles   []server.FileEarnings
+
+    transferList     *widget.List
+    transferData     []*transfers.Transfer
+    selectedTransfer int
+
+    syncList     *widget.List
+    syncData     []string
+    selectedSync int
+
+    libraryTable    *widget.Table
+    libraryData     []*library.Entry
+    libraryQuery    string
+    selectedLibrary int
+
+    contactList     *widget.List
+    contactData     []*contacts.Contact
+    selectedContact int
+    inboxList       *widget.List
+    inboxData       []*contacts.Delivery
+    selectedInbox   int
+
+    validatorToggle  *widget.Check
+    validatorSummary *widget.Label
+    validatorStatus  *server.ValidatorStatus
+    pendingList      *widget.List
+    pendingData      []server.PendingVote
+    selectedPending  int
+    voteTable        *widget.Table
 }
 
-func NewFileZapUI() *FileZapUI {
+// NewFileZapUI creates the UI. Settings are read from configFile, if not
+// empty, and FILEZAP_* environment variables.
+func NewFileZapUI(configFile string) *FileZapUI {
     ui := &FileZapUI{
-        app:          app.New(),
-        peerData:     make([]string, 0),
+        app:          app.NewWithID(appID),
+        peerData:     make([]network.PeerStats, 0),
         selectedPeer: -1,
+
+        selectedTransfer: -1,
+        selectedSync:     -1,
+        selectedLibrary:  -1,
+        selectedContact:  -1,
+        selectedInbox:    -1,
+        selectedPending:  -1,
+
+        validatorStatus: &server.ValidatorStatus{},
     }
 
     // Create default config
     ui.config = client.DefaultClientConfig()
+    ui.config.ConfigFile = configFile
 
     // Initialize client
     client, err := client.NewFileZapClient(ui.config)
@@ -49,6 +112,7 @@ func NewFileZapUI() *FileZapUI {
 
     ui.mainWindow = ui.app.NewWindow("FileZap")
     ui.setupUI()
+    ui.setupTray()
 
     return ui
 }
@@ -57,15 +121,17 @@ func (ui *FileZapUI) setupUI() {
     // Create tabs for different operations
     tabs := container.NewAppTabs(
         container.NewTabItem("Files", ui.createFilesTab()),
+        container.NewTabItem("Transfers", ui.createTransfersTab()),
+        container.NewTabItem("Sync", ui.createSyncTab()),
+        container.NewTabItem("Contacts", ui.createContactsTab()),
         container.NewTabItem("Network", ui.createNetworkTab()),
         container.NewTabItem("Storage", ui.createStorageTab()),
+        container.NewTabItem("Validator", ui.createValidatorTab()),
         container.NewTabItem("Settings", ui.createSettingsTab()),
     )
     tabs.SetTabLocation(container.TabLocationTop)
 
-    // Create status bar
-    ui.status = widget.NewLabel("Ready")
-    statusBar := container.NewHBox(ui.status)
+    statusBar := ui.createStatusBar()
 
     // Layout
     content := container.NewBorder(
@@ -95,12 +161,224 @@ func (ui *FileZapUI) createFilesTab() fyne.CanvasObject {
         ui.createReportControls(),
     ))
 
-    return container.NewVBox(
-        uploadGroup,
-        widget.NewSeparator(),
-        downloadGroup,
-        widget.NewSeparator(),
-        reportGroup,
+    return container.NewBorder(
+        container.NewVBox(
+            uploadGroup,
+            widget.NewSeparator(),
+            downloadGroup,
+            widget.NewSeparator(),
+            reportGroup,
+        ),
+        nil,
+        nil,
+        nil,
+        widget.NewCard("Library", "", ui.createLibraryView()),
+    )
+}
+
+// libraryColumns are the columns of the library table
+var libraryColumns = []string{"Name", "Origin", "Size", "Replication", "Pinned", "Added"}
+
+func (ui *FileZapUI) createLibraryView() fyne.CanvasObject {
+    ui.libraryTable = widget.NewTable(
+        func() (int, int) { return len(ui.libraryData) + 1, len(libraryColumns) },
+        func() fyne.CanvasObject { return widget.NewLabel("Template Library Cell") },
+        func(id widget.TableCellID, obj fyne.CanvasObject) {
+            label := obj.(*widget.Label)
+            if id.Row == 0 {
+                label.TextStyle = fyne.TextStyle{Bold: true}
+                label.SetText(libraryColumns[id.Col])
+                return
+            }
+            label.TextStyle = fyne.TextStyle{}
+
+            entry := ui.libraryData[id.Row-1]
+            switch id.Col {
+            case 0:
+                label.SetText(entry.Name)
+            case 1:
+                label.SetText(entry.Origin)
+            case 2:
+                label.SetText(fmt.Sprintf("%d KB", entry.Size/1024))
+            case 3:
+                if entry.HealthChecked == 0 {
+                    label.SetText("unknown")
+                } else {
+                    label.SetText(fmt.Sprintf("%d/%d", entry.Replicas, entry.ReplicationGoal))
+                }
+            case 4:
+                if entry.Pinned {
+                    label.SetText("yes")
+                } else {
+                    label.SetText("no")
+                }
+            case 5:
+                label.SetText(time.Unix(entry.Added, 0).Format("2006-01-02 15:04"))
+            }
+        },
+    )
+    ui.libraryTable.SetColumnWidth(0, 240)
+
+    ui.libraryTable.OnSelected = func(id widget.TableCellID) {
+        ui.selectedLibrary = id.Row - 1
+    }
+
+    search := widget.NewEntry()
+    search.SetPlaceHolder("Search library")
+    search.OnChanged = func(query string) {
+        ui.libraryQuery = query
+        ui.updateLibrary()
+    }
+
+    // selected returns the selected library file, or nil after telling the
+    // user to pick one
+    selected := func() *library.Entry {
+        if ui.selectedLibrary < 0 || ui.selectedLibrary >= len(ui.libraryData) {
+            dialog.ShowError(fmt.Errorf("please select a file"), ui.mainWindow)
+            return nil
+        }
+        return ui.libraryData[ui.selectedLibrary]
+    }
+
+    pin := widget.NewButton("Pin/Unpin", func() {
+        entry := selected()
+        if entry == nil {
+            return
+        }
+        if err := ui.client.PinLibraryFile(entry.ID, !entry.Pinned); err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+            return
+        }
+        ui.updateLibrary()
+    })
+
+    shareLink := widget.NewButton("Share Link", func() {
+        entry := selected()
+        if entry == nil {
+            return
+        }
+        passphrase := widget.NewPasswordEntry()
+        passphrase.SetPlaceHolder("Leave empty to share without the key")
+        dialog.ShowForm("Share Link", "Create", "Cancel", []*widget.FormItem{
+            widget.NewFormItem("Passphrase", passphrase),
+        }, func(submit bool) {
+            if !submit {
+                return
+            }
+            link, err := ui.client.ShareLink(entry.ID, passphrase.Text)
+            if err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            output := widget.NewEntry()
+            output.SetText(link)
+            copyLink := widget.NewButtonWithIcon("Copy", theme.ContentCopyIcon(), func() {
+                ui.mainWindow.Clipboard().SetContent(link)
+            })
+            dialog.ShowCustom("Share Link", "Close", container.NewBorder(nil, nil, nil, copyLink, output), ui.mainWindow)
+        }, ui.mainWindow)
+    })
+
+    reshare := widget.NewButtonWithIcon("Re-share", theme.MailSendIcon(), func() {
+        entry := selected()
+        if entry == nil {
+            return
+        }
+        fd := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+            if err != nil || uri == nil {
+                return
+            }
+            path, err := ui.client.ReshareLibraryFile(entry.ID, uri.Path())
+            if err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            dialog.ShowInformation("Manifest Exported", fmt.Sprintf("Share %s to give others access", path), ui.mainWindow)
+        }, ui.mainWindow)
+        fd.Show()
+    })
+
+    versions := widget.NewButtonWithIcon("Versions", theme.HistoryIcon(), func() {
+        entry := selected()
+        if entry == nil {
+            return
+        }
+        snapshots, err := ui.client.LibraryVersions(entry.ID)
+        if err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+            return
+        }
+        if len(snapshots) == 0 {
+            dialog.ShowInformation("Versions", fmt.Sprintf("%s has no earlier versions", entry.Name), ui.mainWindow)
+            return
+        }
+
+        // Newest first
+        options := make([]string, len(snapshots))
+        for i := range snapshots {
+            snapshot := snapshots[len(snapshots)-1-i]
+            options[i] = fmt.Sprintf("%s (%d KB, replaced %s)",
+                time.Unix(snapshot.Added, 0).Format("2006-01-02 15:04"),
+                snapshot.Size/1024,
+                time.Unix(snapshot.Replaced, 0).Format("2006-01-02 15:04"))
+        }
+        choice := widget.NewSelect(options, nil)
+        choice.SetSelectedIndex(0)
+
+        dialog.ShowCustomConfirm("Restore Version", "Restore", "Cancel", choice, func(restore bool) {
+            if !restore || choice.SelectedIndex() < 0 {
+                return
+            }
+            snapshot := snapshots[len(snapshots)-1-choice.SelectedIndex()]
+            fd := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+                if err != nil || uri == nil {
+                    return
+                }
+                go func() {
+                    done := ui.startTask("Restoring " + entry.Name)
+                    err := ui.client.DownloadFile(snapshot.Manifest, uri.Path())
+                    if err == nil {
+                        _, err = ui.client.RestoreLibraryVersion(entry.ID, snapshot.ID, uri.Path())
+                    }
+                    done(err)
+                    if err != nil {
+                        dialog.ShowError(err, ui.mainWindow)
+                        return
+                    }
+                    ui.updateLibrary()
+                    dialog.ShowInformation("Version Restored", fmt.Sprintf("Restored %s to %s", entry.Name, uri.Path()), ui.mainWindow)
+                }()
+            }, ui.mainWindow)
+            fd.Show()
+        }, ui.mainWindow)
+    })
+
+    remove := widget.NewButtonWithIcon("Delete", theme.DeleteIcon(), func() {
+        entry := selected()
+        if entry == nil {
+            return
+        }
+        dialog.ShowConfirm("Delete File", fmt.Sprintf("Remove %s and its .zap manifest from the library?", entry.Name), func(ok bool) {
+            if !ok {
+                return
+            }
+            if err := ui.client.RemoveLibraryFile(entry.ID); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            ui.selectedLibrary = -1
+            ui.libraryTable.UnselectAll()
+            ui.updateLibrary()
+        }, ui.mainWindow)
+    })
+
+    ui.updateLibrary()
+    return container.NewBorder(
+        search,
+        container.NewHBox(pin, shareLink, reshare, versions, remove),
+        nil,
+        nil,
+        ui.libraryTable,
     )
 }
 
@@ -128,13 +406,14 @@ func (ui *FileZapUI) createUploadControls() fyne.CanvasObject {
         }
         
         go func() {
-            ui.status.SetText("Uploading file...")
-            if err := ui.client.UploadFile(inputPath.Text); err != nil {
+            done := ui.startTask("Uploading " + filepath.Base(inputPath.Text))
+            err := ui.client.UploadFile(inputPath.Text)
+            done(err)
+            if err != nil {
                 dialog.ShowError(err, ui.mainWindow)
-                ui.status.SetText("Upload failed")
                 return
             }
-            ui.status.SetText("Upload complete")
+            ui.updateLibrary()
         }()
     })
 
@@ -183,23 +462,96 @@ func (ui *FileZapUI) createDownloadControls() fyne.CanvasObject {
         }
 
         go func() {
-            ui.status.SetText("Downloading file...")
-            if err := ui.client.DownloadFile(zapPath.Text, outputPath.Text); err != nil {
+            done := ui.startTask("Downloading " + strings.TrimSuffix(filepath.Base(zapPath.Text), ".zap"))
+            err := ui.client.DownloadFile(zapPath.Text, outputPath.Text)
+            done(err)
+            if err != nil {
                 dialog.ShowError(err, ui.mainWindow)
-                ui.status.SetText("Download failed")
                 return
             }
-            ui.status.SetText("Download complete")
+            if err := ui.client.RecordDownload(zapPath.Text, outputPath.Text); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+            }
+            ui.updateLibrary()
         }()
     })
 
+    importButton := widget.NewButtonWithIcon("Import Share Link", theme.LoginIcon(), func() {
+        ui.showImportDialog()
+    })
+
     return container.NewVBox(
         container.NewBorder(nil, nil, nil, zapSelect, zapPath),
         container.NewBorder(nil, nil, nil, outputSelect, outputPath),
-        downloadButton,
+        container.NewHBox(downloadButton, importButton),
     )
 }
 
+// showImportDialog asks for a share link and downloads the file it names
+func (ui *FileZapUI) showImportDialog() {
+    link := widget.NewEntry()
+    link.SetPlaceHolder("filezap://...")
+
+    passphrase := widget.NewPasswordEntry()
+    passphrase.SetPlaceHolder("Only needed if the link includes a key")
+
+    outputPath := widget.NewEntry()
+    outputPath.SetPlaceHolder("Select output directory")
+    outputSelect := widget.NewButton("Browse", func() {
+        fd := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+            if err != nil || uri == nil {
+                return
+            }
+            outputPath.SetText(uri.Path())
+        }, ui.mainWindow)
+        fd.Show()
+    })
+
+    dialog.ShowForm("Import Share Link", "Import", "Cancel", []*widget.FormItem{
+        widget.NewFormItem("Link", link),
+        widget.NewFormItem("Passphrase", passphrase),
+        widget.NewFormItem("Save To", container.NewBorder(nil, nil, nil, outputSelect, outputPath)),
+    }, func(submit bool) {
+        if !submit {
+            return
+        }
+        parsed, err := sharelink.Parse(link.Text)
+        if err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+            return
+        }
+        if outputPath.Text == "" {
+            dialog.ShowError(fmt.Errorf("please select an output directory"), ui.mainWindow)
+            return
+        }
+        // Check the passphrase before downloading anything
+        if len(parsed.WrappedKey) > 0 {
+            if _, err := sharelink.UnwrapKey(parsed.WrappedKey, passphrase.Text); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+        }
+
+        go func() {
+            name := parsed.Name
+            if name == "" {
+                name = "shared file"
+            }
+            done := ui.startTask("Importing " + name)
+            zapPath, err := ui.client.ImportFile(parsed.FileID, outputPath.Text)
+            done(err)
+            if err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            if err := ui.client.RecordImport(link.Text, passphrase.Text, zapPath, outputPath.Text); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+            }
+            ui.updateLibrary()
+        }()
+    }, ui.mainWindow)
+}
+
 func (ui *FileZapUI) createReportControls() fyne.CanvasObject {
     fileID := widget.NewEntry()
     fileID.SetPlaceHolder("File ID to report")
@@ -227,6 +579,313 @@ func (ui *FileZapUI) createReportControls() fyne.CanvasObject {
     )
 }
 
+func (ui *FileZapUI) createTransfersTab() fyne.CanvasObject {
+    ui.transferList = widget.NewList(
+        func() int { return len(ui.transferData) },
+        func() fyne.CanvasObject {
+            return container.NewHBox(
+                widget.NewLabel("Template File"),
+                widget.NewLabel("Progress"),
+                widget.NewLabel("State"),
+            )
+        },
+        func(id widget.ListItemID, obj fyne.CanvasObject) {
+            t := ui.transferData[id]
+            done, total := t.Progress()
+            box := obj.(*fyne.Container)
+            box.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%s %s", t.Kind, filepath.Base(t.Source)))
+            box.Objects[1].(*widget.Label).SetText(fmt.Sprintf("%d/%d chunks", done, total))
+            state := t.State
+            if t.State == transfers.StateFailed {
+                state = fmt.Sprintf("failed: %s", t.Error)
+            }
+            box.Objects[2].(*widget.Label).SetText(state)
+        },
+    )
+
+    ui.transferList.OnSelected = func(id widget.ListItemID) {
+        ui.selectedTransfer = int(id)
+    }
+
+    // withSelected runs fn on the selected transfer and refreshes the list
+    withSelected := func(fn func(id string) error) func() {
+        return func() {
+            if ui.selectedTransfer < 0 || ui.selectedTransfer >= len(ui.transferData) {
+                dialog.ShowError(fmt.Errorf("please select a transfer"), ui.mainWindow)
+                return
+            }
+            if err := fn(ui.transferData[ui.selectedTransfer].ID); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+            }
+            ui.updateTransferList()
+        }
+    }
+
+    return container.NewBorder(
+        nil,
+        container.NewHBox(
+            widget.NewButton("Refresh", func() {
+                ui.updateTransferList()
+            }),
+            widget.NewButtonWithIcon("Pause", theme.MediaPauseIcon(), withSelected(ui.client.PauseTransfer)),
+            widget.NewButtonWithIcon("Resume", theme.MediaPlayIcon(), withSelected(ui.client.ResumeTransfer)),
+            widget.NewButtonWithIcon("Remove", theme.DeleteIcon(), withSelected(ui.client.RemoveTransfer)),
+        ),
+        nil,
+        nil,
+        container.NewVScroll(ui.transferList),
+    )
+}
+
+func (ui *FileZapUI) createSyncTab() fyne.CanvasObject {
+    ui.syncList = widget.NewList(
+        func() int { return len(ui.syncData) },
+        func() fyne.CanvasObject { return widget.NewLabel("Template Folder") },
+        func(id widget.ListItemID, obj fyne.CanvasObject) {
+            obj.(*widget.Label).SetText(ui.syncData[id])
+        },
+    )
+
+    ui.syncList.OnSelected = func(id widget.ListItemID) {
+        ui.selectedSync = int(id)
+    }
+
+    addFolder := widget.NewButtonWithIcon("Add Folder", theme.FolderNewIcon(), func() {
+        fd := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+            if err != nil || uri == nil {
+                return
+            }
+            if err := ui.client.AddSyncFolder(uri.Path()); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            ui.updateSyncList()
+        }, ui.mainWindow)
+        fd.Show()
+    })
+
+    removeFolder := widget.NewButtonWithIcon("Remove Folder", theme.DeleteIcon(), func() {
+        if ui.selectedSync < 0 || ui.selectedSync >= len(ui.syncData) {
+            dialog.ShowError(fmt.Errorf("please select a folder"), ui.mainWindow)
+            return
+        }
+        if err := ui.client.RemoveSyncFolder(ui.syncData[ui.selectedSync]); err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+            return
+        }
+        ui.selectedSync = -1
+        ui.syncList.UnselectAll()
+        ui.updateSyncList()
+    })
+
+    ui.updateSyncList()
+    return container.NewBorder(
+        widget.NewCard(
+            "Zap Sync",
+            "New and modified files in these folders are uploaded automatically",
+            nil,
+        ),
+        container.NewHBox(addFolder, removeFolder),
+        nil,
+        nil,
+        container.NewVScroll(ui.syncList),
+    )
+}
+
+func (ui *FileZapUI) createContactsTab() fyne.CanvasObject {
+    ui.contactList = widget.NewList(
+        func() int { return len(ui.contactData) },
+        func() fyne.CanvasObject { return widget.NewLabel("Template Contact") },
+        func(id widget.ListItemID, obj fyne.CanvasObject) {
+            contact := ui.contactData[id]
+            obj.(*widget.Label).SetText(fmt.Sprintf("%s (%s)", contact.Name, contact.PeerID))
+        },
+    )
+    ui.contactList.OnSelected = func(id widget.ListItemID) {
+        ui.selectedContact = int(id)
+    }
+
+    ui.inboxList = widget.NewList(
+        func() int { return len(ui.inboxData) },
+        func() fyne.CanvasObject { return widget.NewLabel("Template Delivery") },
+        func(id widget.ListItemID, obj fyne.CanvasObject) {
+            d := ui.inboxData[id]
+            from := d.From
+            if contact, err := ui.client.GetContact(d.From); err == nil {
+                from = contact.Name
+            }
+            obj.(*widget.Label).SetText(fmt.Sprintf("%s from %s, %s", d.Name, from, d.Received.Format("2006-01-02 15:04")))
+        },
+    )
+    ui.inboxList.OnSelected = func(id widget.ListItemID) {
+        ui.selectedInbox = int(id)
+    }
+
+    myCode := widget.NewButtonWithIcon("My Contact Code", theme.ContentCopyIcon(), func() {
+        code := ui.client.ContactCode()
+        output := widget.NewEntry()
+        output.SetText(code)
+        copyCode := widget.NewButtonWithIcon("Copy", theme.ContentCopyIcon(), func() {
+            ui.mainWindow.Clipboard().SetContent(code)
+        })
+        dialog.ShowCustom("My Contact Code", "Close", container.NewVBox(
+            widget.NewLabel("Give this code to people who will send you files"),
+            container.NewBorder(nil, nil, nil, copyCode, output),
+        ), ui.mainWindow)
+    })
+
+    addContact := widget.NewButtonWithIcon("Add Contact", theme.ContentAddIcon(), func() {
+        name := widget.NewEntry()
+        code := widget.NewEntry()
+        code.SetPlaceHolder(contacts.CodePrefix + "...")
+        dialog.ShowForm("Add Contact", "Add", "Cancel", []*widget.FormItem{
+            widget.NewFormItem("Name", name),
+            widget.NewFormItem("Contact Code", code),
+        }, func(submit bool) {
+            if !submit {
+                return
+            }
+            if _, err := ui.client.AddContact(name.Text, code.Text); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            ui.updateContacts()
+        }, ui.mainWindow)
+    })
+
+    // selectedContact returns the selected contact, or nil after telling
+    // the user to pick one
+    selectedContact := func() *contacts.Contact {
+        if ui.selectedContact < 0 || ui.selectedContact >= len(ui.contactData) {
+            dialog.ShowError(fmt.Errorf("please select a contact"), ui.mainWindow)
+            return nil
+        }
+        return ui.contactData[ui.selectedContact]
+    }
+
+    removeContact := widget.NewButtonWithIcon("Remove", theme.DeleteIcon(), func() {
+        contact := selectedContact()
+        if contact == nil {
+            return
+        }
+        dialog.ShowConfirm("Remove Contact", fmt.Sprintf("Remove %s and forget their key?", contact.Name), func(ok bool) {
+            if !ok {
+                return
+            }
+            if err := ui.client.RemoveContact(contact.PeerID); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            ui.selectedContact = -1
+            ui.contactList.UnselectAll()
+            ui.updateContacts()
+        }, ui.mainWindow)
+    })
+
+    sendFile := widget.NewButtonWithIcon("Send File", theme.MailSendIcon(), func() {
+        contact := selectedContact()
+        if contact == nil {
+            return
+        }
+        files := ui.client.SearchLibrary("")
+        names := make([]string, len(files))
+        for i, entry := range files {
+            names[i] = entry.Name
+        }
+        file := widget.NewSelect(names, nil)
+        dialog.ShowForm(fmt.Sprintf("Send to %s", contact.Name), "Send", "Cancel", []*widget.FormItem{
+            widget.NewFormItem("File", file),
+        }, func(submit bool) {
+            if !submit {
+                return
+            }
+            if file.SelectedIndex() < 0 {
+                dialog.ShowError(fmt.Errorf("please select a file"), ui.mainWindow)
+                return
+            }
+            entry := files[file.SelectedIndex()]
+            if err := ui.client.SendToContact(contact.PeerID, entry.ID); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            ui.notify(events.Info, "File sent", fmt.Sprintf("Sent %s to %s", entry.Name, contact.Name))
+        }, ui.mainWindow)
+    })
+
+    // selectedDelivery returns the selected received file, or nil after
+    // telling the user to pick one
+    selectedDelivery := func() *contacts.Delivery {
+        if ui.selectedInbox < 0 || ui.selectedInbox >= len(ui.inboxData) {
+            dialog.ShowError(fmt.Errorf("please select a received file"), ui.mainWindow)
+            return nil
+        }
+        return ui.inboxData[ui.selectedInbox]
+    }
+
+    download := widget.NewButtonWithIcon("Download", theme.DownloadIcon(), func() {
+        d := selectedDelivery()
+        if d == nil {
+            return
+        }
+        fd := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+            if err != nil || uri == nil {
+                return
+            }
+            go func() {
+                done := ui.startTask("Downloading " + d.Name)
+                zapPath, err := ui.client.ImportDelivery(d, uri.Path())
+                done(err)
+                if err != nil {
+                    dialog.ShowError(err, ui.mainWindow)
+                    return
+                }
+                if err := ui.client.RecordDownload(zapPath, uri.Path()); err != nil {
+                    dialog.ShowError(err, ui.mainWindow)
+                }
+                ui.updateContacts()
+                ui.updateLibrary()
+            }()
+        }, ui.mainWindow)
+        fd.Show()
+    })
+
+    dismiss := widget.NewButton("Dismiss", func() {
+        d := selectedDelivery()
+        if d == nil {
+            return
+        }
+        if err := ui.client.DismissDelivery(d.FileID, d.From); err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+            return
+        }
+        ui.selectedInbox = -1
+        ui.inboxList.UnselectAll()
+        ui.updateContacts()
+    })
+
+    ui.updateContacts()
+    return container.NewVSplit(
+        container.NewBorder(
+            widget.NewCard(
+                "Contacts",
+                "Files sent to a contact can only be opened by them",
+                nil,
+            ),
+            container.NewHBox(myCode, addContact, removeContact, sendFile),
+            nil,
+            nil,
+            container.NewVScroll(ui.contactList),
+        ),
+        container.NewBorder(
+            widget.NewLabel("Received Files"),
+            container.NewHBox(download, dismiss),
+            nil,
+            nil,
+            container.NewVScroll(ui.inboxList),
+        ),
+    )
+}
+
 func (ui *FileZapUI) createNetworkTab() fyne.CanvasObject {
     // Create peer list
     ui.peerList = widget.NewList(
@@ -240,22 +899,65 @@ func (ui *FileZapUI) createNetworkTab() fyne.CanvasObject {
         func(id widget.ListItemID, obj fyne.CanvasObject) {
             peer := ui.peerData[id]
             box := obj.(*fyne.Container)
-            box.Objects[0].(*widget.Label).SetText(peer)
-            // TODO: Add real peer status
-            box.Objects[1].(*widget.Label).SetText("Connected")
+            box.Objects[0].(*widget.Label).SetText(shortPeerID(peer.ID.String()))
+            box.Objects[1].(*widget.Label).SetText(peerSummary(peer))
         },
     )
-    
+
+    ui.peerDetail = widget.NewLabel("Select a peer to see its details")
+    ui.peerDetail.Wrapping = fyne.TextWrapBreak
+
     // Set OnSelected callback for peer list
     ui.peerList.OnSelected = func(id widget.ListItemID) {
         ui.selectedPeer = int(id)
+        ui.showPeerDetail()
     }
 
+    connectPeer := widget.NewButtonWithIcon("Connect", theme.ContentAddIcon(), func() {
+        addr := widget.NewEntry()
+        addr.SetPlaceHolder("/ip4/1.2.3.4/tcp/6001/p2p/<peer ID>")
+        dialog.ShowForm("Connect to Peer", "Connect", "Cancel", []*widget.FormItem{
+            widget.NewFormItem("Address", addr),
+        }, func(submit bool) {
+            if !submit || addr.Text == "" {
+                return
+            }
+            done := ui.startTask("Connecting to peer")
+            go func() {
+                err := ui.client.ConnectPeer(addr.Text)
+                done(err)
+                if err != nil {
+                    dialog.ShowError(err, ui.mainWindow)
+                    return
+                }
+                ui.updatePeerList()
+            }()
+        }, ui.mainWindow)
+    })
+
+    disconnectPeer := widget.NewButtonWithIcon("Disconnect", theme.CancelIcon(), func() {
+        if ui.selectedPeer < 0 || ui.selectedPeer >= len(ui.peerData) {
+            dialog.ShowError(fmt.Errorf("please select a peer to disconnect"), ui.mainWindow)
+            return
+        }
+        peer := ui.peerData[ui.selectedPeer]
+        if !peer.Connected {
+            dialog.ShowError(fmt.Errorf("peer is not connected"), ui.mainWindow)
+            return
+        }
+        if err := ui.client.DisconnectPeer(peer.ID.String()); err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+            return
+        }
+        ui.updatePeerList()
+    })
+
     reportPeer := widget.NewButton("Report Malicious Peer", func() {
         if ui.selectedPeer < 0 || ui.selectedPeer >= len(ui.peerData) {
             dialog.ShowError(fmt.Errorf("please select a peer to report"), ui.mainWindow)
             return
         }
+        peer := ui.peerData[ui.selectedPeer]
 
         reason := widget.NewMultiLineEntry()
         reason.SetPlaceHolder("Enter reason for reporting peer")
@@ -271,8 +973,23 @@ func (ui *FileZapUI) createNetworkTab() fyne.CanvasObject {
                 if !submit || reason.Text == "" {
                     return
                 }
-                // TODO: Implement peer reporting
-                dialog.ShowInformation("Report Submitted", "The peer has been reported", ui.mainWindow)
+                id := peer.ID.String()
+                done := ui.startTask("Reporting peer")
+                go func() {
+                    report, err := ui.client.ReportPeer(id, reason.Text)
+                    done(err)
+                    if err != nil {
+                        dialog.ShowError(err, ui.mainWindow)
+                        return
+                    }
+                    dialog.ShowInformation("Report Submitted", fmt.Sprintf(
+                        "A vote on removing %s is open until %s.\n\nEvidence: %s",
+                        shortPeerID(id),
+                        report.Deadline.Format("15:04:05"),
+                        reportEvidence(report),
+                    ), ui.mainWindow)
+                    ui.followReport(report)
+                }()
             },
             ui.mainWindow,
         )
@@ -285,18 +1002,23 @@ func (ui *FileZapUI) createNetworkTab() fyne.CanvasObject {
             "", 
             container.NewVBox(
                 widget.NewLabel(fmt.Sprintf("Node ID: %s", ui.client.GetNodeID())),
-                widget.NewLabel("Connected Peers:"),
+                widget.NewLabel("Peers (connected or known through gossip):"),
             ),
         ),
         container.NewHBox(
             widget.NewButton("Refresh", func() {
                 ui.updatePeerList()
             }),
+            connectPeer,
+            disconnectPeer,
             reportPeer,
         ),
         nil,
         nil,
-        container.NewVScroll(ui.peerList),
+        container.NewHSplit(
+            container.NewVScroll(ui.peerList),
+            widget.NewCard("Peer Details", "", container.NewVScroll(ui.peerDetail)),
+        ),
     )
 }
 
@@ -305,7 +1027,8 @@ func (ui *FileZapUI) createStorageTab() fyne.CanvasObject {
     ui.storageStats = widget.NewLabel("Calculating storage stats...")
     
     // Storage controls
-    enableStorage := widget.NewCheck("Enable Storage Node", func(enabled bool) {
+    ui.storageToggle = widget.NewCheck("Enable Storage Node", func(enabled bool) {
+        defer ui.refreshTray()
         if enabled {
             err := ui.client.EnableStorageNode()
             if err != nil {
@@ -322,33 +1045,113 @@ func (ui *FileZapUI) createStorageTab() fyne.CanvasObject {
         ui.updateStorageStats()
     })
 
-    return container.NewVBox(
-        widget.NewCard(
-            "Storage Node Status",
-            "",
+    return container.NewBorder(
+        container.NewVBox(
+            widget.NewCard(
+                "Storage Node Status",
+                "",
+                container.NewVBox(
+                    ui.storageToggle,
+                    ui.storageStats,
+                ),
+            ),
+        ),
+        nil,
+        nil,
+        nil,
+        ui.createEarningsView(),
+    )
+}
+
+// earningsColumns are the columns of the per-file earnings table
+var earningsColumns = []string{"File", "Earned", "Downloads Served", "Last Paid"}
+
+func (ui *FileZapUI) createEarningsView() fyne.CanvasObject {
+    ui.earningsSummary = widget.NewLabel("Loading earnings...")
+    ui.earningsChart = newBarChart()
+
+    ui.earningsTable = widget.NewTable(
+        func() (int, int) { return len(ui.earningsFiles) + 1, len(earningsColumns) },
+        func() fyne.CanvasObject { return widget.NewLabel("Template File Name") },
+        func(id widget.TableCellID, obj fyne.CanvasObject) {
+            label := obj.(*widget.Label)
+            if id.Row == 0 {
+                label.TextStyle = fyne.TextStyle{Bold: true}
+                label.SetText(earningsColumns[id.Col])
+                return
+            }
+            label.TextStyle = fyne.TextStyle{}
+            file := ui.earningsFiles[id.Row-1]
+            switch id.Col {
+            case 0:
+                if file.Name != "" {
+                    label.SetText(file.Name)
+                } else {
+                    label.SetText(file.FileID)
+                }
+            case 1:
+                label.SetText(fmt.Sprintf("%d", file.Earned))
+            case 2:
+                label.SetText(fmt.Sprintf("%d", file.Deliveries))
+            case 3:
+                label.SetText(time.Unix(file.LastPaid, 0).Format("2006-01-02 15:04"))
+            }
+        },
+    )
+    ui.earningsTable.SetColumnWidth(0, 240)
+    ui.earningsTable.SetColumnWidth(2, 140)
+    ui.earningsTable.SetColumnWidth(3, 140)
+
+    refresh := widget.NewButtonWithIcon("Refresh", theme.ViewRefreshIcon(), func() {
+        go ui.updateEarnings()
+    })
+
+    go ui.updateEarnings()
+    return widget.NewCard(
+        "Earnings",
+        "Credits earned serving chunks, from the validators' ledger",
+        container.NewBorder(
             container.NewVBox(
-                enableStorage,
-                ui.storageStats,
+                container.NewBorder(nil, nil, nil, refresh, ui.earningsSummary),
+                widget.NewLabel(fmt.Sprintf("Daily earnings, last %d days", server.DefaultEarningsDays)),
+                ui.earningsChart.container,
             ),
+            nil,
+            nil,
+            nil,
+            ui.earningsTable,
         ),
     )
 }
 
 func (ui *FileZapUI) createSettingsTab() fyne.CanvasObject {
     storageDir := widget.NewEntry()
-    storageDir.SetText(ui.config.StorageDirectory)
-
     maxStorage := widget.NewEntry()
-    maxStorage.SetText(fmt.Sprintf("%d", ui.config.MaxStorageSize/(1024*1024)))
-
     minSpace := widget.NewEntry()
-    minSpace.SetText(fmt.Sprintf("%d", ui.config.MinFreeSpace/(1024*1024)))
+    uploadRate := widget.NewEntry()
+    downloadRate := widget.NewEntry()
+    parallel := widget.NewEntry()
+
+    // fill shows the current settings; rates are edited in KB/s, 0 means
+    // unlimited
+    fill := func() {
+        storageDir.SetText(ui.config.StorageDirectory)
+        maxStorage.SetText(fmt.Sprintf("%d", ui.config.MaxStorageSize/(1024*1024)))
+        minSpace.SetText(fmt.Sprintf("%d", ui.config.MinFreeSpace/(1024*1024)))
+        uploadRate.SetText(fmt.Sprintf("%d", ui.config.MaxUploadRate/1024))
+        downloadRate.SetText(fmt.Sprintf("%d", ui.config.MaxDownloadRate/1024))
+        parallel.SetText(fmt.Sprintf("%d", ui.config.MaxParallelTransfers))
+    }
+    fill()
 
     form := &widget.Form{
         Items: []*widget.FormItem{
             {Text: "Storage Directory", Widget: storageDir},
             {Text: "Max Storage (MB)", Widget: maxStorage},
             {Text: "Min Free Space (MB)", Widget: minSpace},
+            {Text: "Max Upload Rate (KB/s)", Widget: uploadRate},
+            {Text: "Max Download Rate (KB/s)", Widget: downloadRate},
+            {Text: "Parallel Transfers", Widget: parallel},
         },
         OnSubmit: func() {
             // Parse and apply settings
@@ -362,10 +1165,34 @@ func (ui *FileZapUI) createSettingsTab() fyne.CanvasObject {
                 dialog.ShowError(fmt.Errorf("invalid min space value"), ui.mainWindow)
                 return
             }
+            upRate, err := strconv.ParseInt(uploadRate.Text, 10, 64)
+            if err != nil || upRate < 0 {
+                dialog.ShowError(fmt.Errorf("invalid upload rate value"), ui.mainWindow)
+                return
+            }
+            downRate, err := strconv.ParseInt(downloadRate.Text, 10, 64)
+            if err != nil || downRate < 0 {
+                dialog.ShowError(fmt.Errorf("invalid download rate value"), ui.mainWindow)
+                return
+            }
+            maxParallel, err := strconv.Atoi(parallel.Text)
+            if err != nil || maxParallel < 0 {
+                dialog.ShowError(fmt.Errorf("invalid parallel transfers value"), ui.mainWindow)
+                return
+            }
 
             ui.config.StorageDirectory = storageDir.Text
             ui.config.MaxStorageSize = maxSize * 1024 * 1024  // Convert MB to bytes
             ui.config.MinFreeSpace = minFree * 1024 * 1024    // Convert MB to bytes
+            ui.config.MaxUploadRate = upRate * 1024           // Convert KB/s to bytes/s
+            ui.config.MaxDownloadRate = downRate * 1024       // Convert KB/s to bytes/s
+            ui.config.MaxParallelTransfers = maxParallel
+
+            // Transfer limits apply to running transfers right away
+            if err := ui.client.SetTransferLimits(ui.config.MaxUploadRate, ui.config.MaxDownloadRate, maxParallel); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
 
             if err := ui.client.UpdateConfig(ui.config); err != nil {
                 dialog.ShowError(err, ui.mainWindow)
@@ -375,20 +1202,294 @@ func (ui *FileZapUI) createSettingsTab() fyne.CanvasObject {
         },
     }
 
+    changePassphrase := widget.NewButton("Change Keystore Passphrase", func() {
+        passphrase := widget.NewPasswordEntry()
+        confirm := widget.NewPasswordEntry()
+        dialog.ShowForm("Change Keystore Passphrase", "Change", "Cancel", []*widget.FormItem{
+            widget.NewFormItem("New Passphrase", passphrase),
+            widget.NewFormItem("Confirm", confirm),
+        }, func(submit bool) {
+            if !submit {
+                return
+            }
+            if passphrase.Text != confirm.Text {
+                dialog.ShowError(fmt.Errorf("passphrases do not match"), ui.mainWindow)
+                return
+            }
+            if err := ui.client.ChangeKeystorePassphrase(passphrase.Text); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            dialog.ShowInformation("Keystore", "Passphrase changed", ui.mainWindow)
+        }, ui.mainWindow)
+    })
+
+    // Edits to the config file apply without a restart; so does SIGHUP
+    reloadConfig := widget.NewButton("Reload Config File", func() {
+        if err := ui.client.ReloadConfig(); err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+            return
+        }
+        ui.config = ui.client.GetConfig()
+        fill()
+        dialog.ShowInformation("Settings Reloaded",
+            "Transfer limits, the low disk threshold, the storage quota and the replication goal were reloaded. Other settings apply on restart.",
+            ui.mainWindow)
+    })
+    reloadConfig.Disable()
+    if ui.config.ConfigFile != "" {
+        reloadConfig.Enable()
+    }
+
+    minimizeToTray := widget.NewCheck("Minimize to tray on close", ui.setMinimizeToTray)
+    minimizeToTray.SetChecked(ui.minimizeToTray())
+    if !ui.hasTray {
+        minimizeToTray.Disable()
+    }
+
+    startOnLogin := widget.NewCheck("Start on login", nil)
+    if enabled, err := autostart.Enabled(); err == nil {
+        startOnLogin.SetChecked(enabled)
+    }
+    startOnLogin.OnChanged = func(enabled bool) {
+        if err := ui.setStartOnLogin(enabled); err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+        }
+    }
+
     return widget.NewCard(
         "Settings",
         "Configure FileZap behavior",
-        form,
+        container.NewVBox(form, reloadConfig, changePassphrase, minimizeToTray, startOnLogin),
     )
 }
 
+// showUnlockDialog asks for the keystore passphrase until the keystore
+// unlocks. Files cannot be shared or decrypted while it is locked.
+func (ui *FileZapUI) showUnlockDialog() {
+    passphrase := widget.NewPasswordEntry()
+    dialog.ShowForm("Unlock Keystore", "Unlock", "Skip", []*widget.FormItem{
+        widget.NewFormItem("Passphrase", passphrase),
+    }, func(submit bool) {
+        if !submit {
+            ui.notify(events.Info, "Keystore locked", "Files cannot be shared or decrypted until the keystore is unlocked")
+            return
+        }
+        if err := ui.client.UnlockKeystore(passphrase.Text); err != nil {
+            dialog.ShowError(err, ui.mainWindow)
+            ui.showUnlockDialog()
+            return
+        }
+        ui.updateLibrary()
+    }, ui.mainWindow)
+}
+
 func (ui *FileZapUI) updatePeerList() {
-    peers := ui.client.GetPeers()
-    ui.peerData = make([]string, len(peers))
-    for i, peer := range peers {
-        ui.peerData[i] = peer.String()
+    // Keep the selection on the same peer as the list changes
+    var selected string
+    if ui.selectedPeer >= 0 && ui.selectedPeer < len(ui.peerData) {
+        selected = ui.peerData[ui.selectedPeer].ID.String()
+    }
+
+    ui.peerData = ui.client.GetPeerStats()
+    ui.selectedPeer = -1
+    for i, peer := range ui.peerData {
+        if peer.ID.String() == selected {
+            ui.selectedPeer = i
+        }
+    }
+    if ui.selectedPeer < 0 {
+        ui.peerList.UnselectAll()
     }
     ui.peerList.Refresh()
+    ui.showPeerDetail()
+}
+
+// showPeerDetail fills the detail pane with the selected peer
+func (ui *FileZapUI) showPeerDetail() {
+    if ui.selectedPeer < 0 || ui.selectedPeer >= len(ui.peerData) {
+        ui.peerDetail.SetText("Select a peer to see its details")
+        return
+    }
+    peer := ui.peerData[ui.selectedPeer]
+
+    status := "Disconnected"
+    if peer.Connected {
+        status = "Connected (" + peer.Direction + ")"
+    }
+    transports := "-"
+    if len(peer.Transports) > 0 {
+        transports = strings.Join(peer.Transports, ", ")
+    }
+    latency := "unknown"
+    if peer.Latency > 0 {
+        latency = peer.Latency.Round(time.Millisecond).String()
+    }
+    lastSeen := "never"
+    if !peer.LastSeen.IsZero() {
+        lastSeen = peer.LastSeen.Format("2006-01-02 15:04:05")
+    }
+
+    ui.peerDetail.SetText(fmt.Sprintf(
+        "Peer ID: %s\n"+
+        "Status: %s\n"+
+        "Transports: %s\n"+
+        "Latency: %s\n"+
+        "Average response: %.0f ms\n"+
+        "Uptime: %.1f%%\n"+
+        "Reputation: %d\n"+
+        "Chunks served: %d (%d KB)\n"+
+        "Chunks received: %d (%d KB)\n"+
+        "Last seen: %s\n"+
+        "Addresses:\n  %s",
+        peer.ID,
+        status,
+        transports,
+        latency,
+        peer.ResponseTime,
+        peer.Uptime,
+        peer.Reputation,
+        peer.ChunksServed, peer.BytesServed/1024,
+        peer.ChunksReceived, peer.BytesReceived/1024,
+        lastSeen,
+        strings.Join(peer.Addresses, "\n  "),
+    ))
+}
+
+// shortPeerID abbreviates a peer ID for lists
+func shortPeerID(id string) string {
+    if len(id) <= 16 {
+        return id
+    }
+    return id[:8] + "..." + id[len(id)-6:]
+}
+
+// reportEvidence describes the evidence a report was backed by
+func reportEvidence(report *network.PeerReport) string {
+    if report.Evidence == network.EvidenceBadChunk {
+        return fmt.Sprintf("the peer served a bad copy of chunk %.12s", report.BadChunk)
+    }
+    if report.FailedRequests > 0 {
+        return fmt.Sprintf("your signed report and %d failed requests", report.FailedRequests)
+    }
+    return "your signed report"
+}
+
+// followReport waits for a report's vote to be decided and shows the outcome
+func (ui *FileZapUI) followReport(report *network.PeerReport) {
+    ticker := time.NewTicker(5 * time.Second)
+    defer ticker.Stop()
+
+    name := shortPeerID(report.Peer.String())
+    for {
+        select {
+        case <-ticker.C:
+            outcome, err := ui.client.ReportOutcome(report.VoteID)
+            if err != nil || outcome == network.ReportPending {
+                continue
+            }
+
+            var message string
+            switch outcome {
+            case network.ReportPassed:
+                message = fmt.Sprintf("The network voted to remove %s", name)
+            case network.ReportRejected:
+                message = fmt.Sprintf("The network voted to keep %s", name)
+            default:
+                message = fmt.Sprintf("Too few peers voted on %s", name)
+            }
+            ui.notify(events.Info, "Peer report decided", message)
+            dialog.ShowInformation("Peer Report", message, ui.mainWindow)
+            ui.updatePeerList()
+            return
+        case <-ui.client.Context().Done():
+            return
+        }
+    }
+}
+
+// peerSummary is a peer's one line status in the peer list
+func peerSummary(peer network.PeerStats) string {
+    if !peer.Connected {
+        return fmt.Sprintf("Disconnected, reputation %d", peer.Reputation)
+    }
+    latency := "?"
+    if peer.Latency > 0 {
+        latency = peer.Latency.Round(time.Millisecond).String()
+    }
+    return fmt.Sprintf("%s %s, %s, reputation %d, %d/%d chunks served/received",
+        peer.Direction,
+        strings.Join(peer.Transports, "+"),
+        latency,
+        peer.Reputation,
+        peer.ChunksServed,
+        peer.ChunksReceived,
+    )
+}
+
+func (ui *FileZapUI) updateTransferList() {
+    ui.transferData = ui.client.ListTransfers()
+    if ui.selectedTransfer >= len(ui.transferData) {
+        ui.selectedTransfer = -1
+        ui.transferList.UnselectAll()
+    }
+    ui.transferList.Refresh()
+}
+
+func (ui *FileZapUI) updateSyncList() {
+    ui.syncData = ui.client.SyncFolders()
+    ui.syncList.Refresh()
+}
+
+func (ui *FileZapUI) updateContacts() {
+    ui.contactData = ui.client.ListContacts()
+    if ui.selectedContact >= len(ui.contactData) {
+        ui.selectedContact = -1
+        ui.contactList.UnselectAll()
+    }
+    ui.contactList.Refresh()
+
+    ui.inboxData = ui.client.ContactInbox()
+    if ui.selectedInbox >= len(ui.inboxData) {
+        ui.selectedInbox = -1
+        ui.inboxList.UnselectAll()
+    }
+    ui.inboxList.Refresh()
+}
+
+func (ui *FileZapUI) updateLibrary() {
+    ui.libraryData = ui.client.SearchLibrary(ui.libraryQuery)
+    if ui.selectedLibrary >= len(ui.libraryData) {
+        ui.selectedLibrary = -1
+        ui.libraryTable.UnselectAll()
+    }
+    ui.libraryTable.Refresh()
+}
+
+func (ui *FileZapUI) updateEarnings() {
+    report, err := ui.client.GetEarnings(server.DefaultEarningsDays)
+    if err != nil {
+        ui.earningsSummary.SetText(fmt.Sprintf("Earnings unavailable: %v", err))
+        return
+    }
+
+    ui.earningsSummary.SetText(fmt.Sprintf(
+        "Balance: %d credits\n"+
+        "Earned in total: %d credits\n"+
+        "Current price: %d credit(s) per chunk served",
+        report.Balance,
+        report.Total,
+        report.ChunkPrice,
+    ))
+
+    daily := make([]int64, len(report.Daily))
+    for i, d := range report.Daily {
+        daily[i] = d.Earned
+    }
+    ui.earningsChart.SetValues(daily)
+
+    ui.earningsFiles = report.Files
+    ui.earningsTable.Refresh()
 }
 
 func (ui *FileZapUI) updateStorageStats() {
@@ -407,18 +1508,39 @@ func (ui *FileZapUI) updateStorageStats() {
 }
 
 func (ui *FileZapUI) Run() {
+    ui.start()
+    ui.mainWindow.ShowAndRun()
+}
+
+// RunInBackground runs the client with its window hidden in the tray. Without
+// a tray the window is shown, as there would be no way to open it.
+func (ui *FileZapUI) RunInBackground() {
+    if !ui.hasTray {
+        ui.Run()
+        return
+    }
+    ui.start()
+    ui.app.Run()
+}
+
+func (ui *FileZapUI) start() {
     ui.mainWindow.Resize(fyne.NewSize(800, 600))
     ui.mainWindow.CenterOnScreen()
 
     // Start periodic updates
     go ui.periodicUpdates()
+    go ui.listenForEvents()
+
+    // File keys are kept in the keystore, which may need a passphrase
+    if ui.client.KeystoreLocked() {
+        ui.showUnlockDialog()
+    }
 
-    // Cleanup on window close
+    // Cleanup on window close; with a tray, closing is intercepted and only
+    // Quit ends the client
     ui.mainWindow.SetOnClosed(func() {
         ui.client.Close()
     })
-
-    ui.mainWindow.ShowAndRun()
 }
 
 func (ui *FileZapUI) periodicUpdates() {
@@ -429,7 +1551,11 @@ func (ui *FileZapUI) periodicUpdates() {
         select {
         case <-ticker.C:
             ui.updatePeerList()
+            ui.updateTransferList()
+            ui.updateLibrary()
+            ui.updateContacts()
             ui.updateStorageStats()
+            ui.updateValidator()
         case <-ui.client.Context().Done():
             return
         }
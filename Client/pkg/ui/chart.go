@@ -0,0 +1,60 @@
+package ui
+
+import (
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/canvas"
+    "fyne.io/fyne/v2/container"
+    "fyne.io/fyne/v2/theme"
+)
+
+// barChart draws one bar per value, scaled to the largest value
+type barChart struct {
+    values    []int64
+    container *fyne.Container
+}
+
+func newBarChart() *barChart {
+    chart := &barChart{}
+    chart.container = container.New(chart)
+    return chart
+}
+
+// SetValues replaces the bars
+func (c *barChart) SetValues(values []int64) {
+    c.values = values
+    bars := make([]fyne.CanvasObject, len(values))
+    for i := range values {
+        bars[i] = canvas.NewRectangle(theme.Color(theme.ColorNamePrimary))
+    }
+    c.container.Objects = bars
+    c.container.Refresh()
+}
+
+// Layout places the bars side by side along the bottom edge
+func (c *barChart) Layout(objects []fyne.CanvasObject, size fyne.Size) {
+    if len(objects) == 0 {
+        return
+    }
+    var max int64
+    for _, v := range c.values {
+        if v > max {
+            max = v
+        }
+    }
+
+    gap := theme.Padding() / 2
+    width := (size.Width - gap*float32(len(objects)-1)) / float32(len(objects))
+    for i, bar := range objects {
+        height := float32(0)
+        if max > 0 && i < len(c.values) {
+            height = size.Height * float32(c.values[i]) / float32(max)
+        }
+        bar.Resize(fyne.NewSize(width, height))
+        bar.Move(fyne.NewPos(float32(i)*(width+gap), size.Height-height))
+    }
+}
+
+// MinSize keeps the chart tall enough to read
+func (c *barChart) MinSize(_ []fyne.CanvasObject) fyne.Size {
+    return fyne.NewSize(200, 120)
+}
@@ -0,0 +1,149 @@
+package ui
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/container"
+    "fyne.io/fyne/v2/dialog"
+    "fyne.io/fyne/v2/theme"
+    "fyne.io/fyne/v2/widget"
+
+    "github.com/VetheonGames/FileZap/Client/pkg/events"
+)
+
+// The status bar shows the tasks running in the background and a bell
+// opening the notification center, which lists recent events from the
+// client's event bus. Events the user may not be watching for, such as a
+// finished transfer or a vote request, are also sent as OS notifications.
+
+// taskCenter tracks the tasks started from the UI
+type taskCenter struct {
+    running []string
+    unread  int
+    mu      sync.Mutex
+}
+
+func (ui *FileZapUI) createStatusBar() fyne.CanvasObject {
+    ui.status = widget.NewLabel("Ready")
+    ui.bell = widget.NewButtonWithIcon("", theme.MailComposeIcon(), ui.showNotifications)
+    return container.NewBorder(nil, nil, nil, ui.bell, ui.status)
+}
+
+// startTask shows a task as running and returns the function to call when
+// it ends, which announces the outcome
+func (ui *FileZapUI) startTask(name string) func(err error) {
+    ui.tasks.mu.Lock()
+    ui.tasks.running = append(ui.tasks.running, name)
+    ui.tasks.mu.Unlock()
+    ui.refreshStatus()
+
+    return func(err error) {
+        ui.tasks.mu.Lock()
+        for i, task := range ui.tasks.running {
+            if task == name {
+                ui.tasks.running = append(ui.tasks.running[:i], ui.tasks.running[i+1:]...)
+                break
+            }
+        }
+        ui.tasks.mu.Unlock()
+
+        if err != nil {
+            ui.notify(events.Info, name+" failed", err.Error())
+        } else {
+            ui.notify(events.Info, name+" complete", "")
+        }
+        ui.refreshStatus()
+    }
+}
+
+// notify publishes an event on the client's bus
+func (ui *FileZapUI) notify(kind, title, message string) {
+    ui.client.Events().Publish(events.Event{Kind: kind, Title: title, Message: message})
+}
+
+// refreshStatus shows the running tasks and the unread count
+func (ui *FileZapUI) refreshStatus() {
+    ui.tasks.mu.Lock()
+    running := append([]string(nil), ui.tasks.running...)
+    unread := ui.tasks.unread
+    ui.tasks.mu.Unlock()
+
+    switch len(running) {
+    case 0:
+        ui.status.SetText("Ready")
+    case 1:
+        ui.status.SetText(running[0] + "...")
+    default:
+        ui.status.SetText(fmt.Sprintf("%d tasks running: %s", len(running), strings.Join(running, ", ")))
+    }
+
+    if unread > 0 {
+        ui.bell.SetText(fmt.Sprintf("%d", unread))
+    } else {
+        ui.bell.SetText("")
+    }
+}
+
+// listenForEvents counts new events and sends OS notifications until the
+// client shuts down
+func (ui *FileZapUI) listenForEvents() {
+    ch, cancel := ui.client.Events().Subscribe()
+    defer cancel()
+
+    for {
+        select {
+        case event := <-ch:
+            ui.tasks.mu.Lock()
+            ui.tasks.unread++
+            ui.tasks.mu.Unlock()
+            ui.refreshStatus()
+
+            if event.Kind != events.Info {
+                ui.app.SendNotification(fyne.NewNotification(event.Title, event.Message))
+            }
+        case <-ui.client.Context().Done():
+            return
+        }
+    }
+}
+
+// showNotifications opens the notification center
+func (ui *FileZapUI) showNotifications() {
+    recent := ui.client.Events().Recent()
+    ui.tasks.mu.Lock()
+    ui.tasks.unread = 0
+    ui.tasks.mu.Unlock()
+    ui.refreshStatus()
+
+    list := widget.NewList(
+        func() int { return len(recent) },
+        func() fyne.CanvasObject {
+            return container.NewVBox(
+                widget.NewLabelWithStyle("Template Title", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+                widget.NewLabel("Template Message"),
+            )
+        },
+        func(id widget.ListItemID, obj fyne.CanvasObject) {
+            event := recent[id]
+            box := obj.(*fyne.Container)
+            box.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%s  (%s)", event.Title, event.Time.Format("15:04")))
+            box.Objects[1].(*widget.Label).SetText(event.Message)
+        },
+    )
+
+    var d dialog.Dialog
+    clearAll := widget.NewButtonWithIcon("Clear", theme.DeleteIcon(), func() {
+        ui.client.Events().Clear()
+        d.Hide()
+    })
+    content := container.NewBorder(nil, clearAll, nil, nil, list)
+    if len(recent) == 0 {
+        content = container.NewBorder(nil, nil, nil, nil, widget.NewLabel("No notifications"))
+    }
+    d = dialog.NewCustom("Notifications", "Close", content, ui.mainWindow)
+    d.Resize(fyne.NewSize(500, 400))
+    d.Show()
+}
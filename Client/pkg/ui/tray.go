@@ -0,0 +1,109 @@
+package ui
+
+import (
+    "path/filepath"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/driver/desktop"
+
+    "github.com/VetheonGames/FileZap/Client/pkg/autostart"
+)
+
+// With minimize to tray on, closing the window only hides it: the client
+// keeps serving chunks and syncing until Quit is chosen from the tray menu.
+// Started in the background, e.g. on login, the window stays hidden until
+// opened from the tray.
+const (
+    appID              = "io.filezap.client"
+    prefMinimizeToTray = "minimize_to_tray"
+
+    // BackgroundFlag starts the client with its window hidden
+    BackgroundFlag = "background"
+)
+
+// setupTray adds the tray menu on desktops that have one
+func (ui *FileZapUI) setupTray() {
+    desk, ok := ui.app.(desktop.App)
+    if !ok {
+        return
+    }
+    ui.hasTray = true
+
+    ui.pauseItem = fyne.NewMenuItem("Pause Serving", ui.toggleServing)
+    ui.trayMenu = fyne.NewMenu("FileZap",
+        fyne.NewMenuItem("Open FileZap", ui.showWindow),
+        ui.pauseItem,
+        fyne.NewMenuItemSeparator(),
+        fyne.NewMenuItem("Quit", ui.quit),
+    )
+    // The tray menu adds its own Quit item unless one is marked
+    ui.trayMenu.Items[3].IsQuit = true
+    desk.SetSystemTrayMenu(ui.trayMenu)
+    ui.refreshTray()
+
+    ui.mainWindow.SetCloseIntercept(func() {
+        if ui.minimizeToTray() {
+            ui.mainWindow.Hide()
+            return
+        }
+        ui.quit()
+    })
+}
+
+// minimizeToTray reports whether closing the window keeps the client running
+func (ui *FileZapUI) minimizeToTray() bool {
+    return ui.hasTray && ui.app.Preferences().BoolWithFallback(prefMinimizeToTray, true)
+}
+
+// setMinimizeToTray chooses what closing the window does
+func (ui *FileZapUI) setMinimizeToTray(enabled bool) {
+    ui.app.Preferences().SetBool(prefMinimizeToTray, enabled)
+}
+
+// setStartOnLogin starts the client in the background when the user logs
+// in, with the same config file
+func (ui *FileZapUI) setStartOnLogin(enabled bool) error {
+    if !enabled {
+        return autostart.Disable()
+    }
+    args := []string{"-" + BackgroundFlag}
+    if ui.config.ConfigFile != "" {
+        path, err := filepath.Abs(ui.config.ConfigFile)
+        if err != nil {
+            return err
+        }
+        args = append(args, "-config", path)
+    }
+    return autostart.Enable(args...)
+}
+
+// showWindow brings the window back from the tray
+func (ui *FileZapUI) showWindow() {
+    ui.mainWindow.Show()
+    ui.mainWindow.RequestFocus()
+}
+
+// toggleServing pauses or resumes serving chunks through the storage node
+// switch, so the Storage tab stays in step
+func (ui *FileZapUI) toggleServing() {
+    ui.storageToggle.SetChecked(!ui.storageToggle.Checked)
+}
+
+// refreshTray labels the pause item after the storage node state
+func (ui *FileZapUI) refreshTray() {
+    if ui.trayMenu == nil {
+        return
+    }
+    if ui.storageToggle.Checked {
+        ui.pauseItem.Label = "Pause Serving"
+    } else {
+        ui.pauseItem.Label = "Resume Serving"
+    }
+    ui.trayMenu.Refresh()
+}
+
+// quit shuts the client down and exits
+func (ui *FileZapUI) quit() {
+    ui.client.Close()
+    ui.app.Quit()
+}
@@ -0,0 +1,215 @@
+package ui
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "fyne.io/fyne/v2"
+    "fyne.io/fyne/v2/container"
+    "fyne.io/fyne/v2/dialog"
+    "fyne.io/fyne/v2/theme"
+    "fyne.io/fyne/v2/widget"
+)
+
+// The Validator tab shows the quorum, the vote sessions waiting for a
+// decision and the votes this node cast, and joins or leaves validation.
+
+// voteColumns are the columns of the vote history table
+var voteColumns = []string{"File", "Kind", "Vote", "Outcome", "Time"}
+
+func (ui *FileZapUI) createValidatorTab() fyne.CanvasObject {
+    ui.validatorSummary = widget.NewLabel("Loading validator status...")
+
+    ui.validatorToggle = widget.NewCheck("Take part in validation", nil)
+    ui.validatorToggle.OnChanged = func(enabled bool) {
+        ui.confirmValidatorChange(enabled)
+    }
+
+    ui.pendingList = widget.NewList(
+        func() int { return len(ui.pendingData) },
+        func() fyne.CanvasObject { return widget.NewLabel("Template Vote Session") },
+        func(id widget.ListItemID, obj fyne.CanvasObject) {
+            session := ui.pendingData[id]
+            voted := ""
+            if session.Voted {
+                voted = ", voted"
+            }
+            obj.(*widget.Label).SetText(fmt.Sprintf("%s: %s, %d/%d votes, expires %s%s",
+                voteKind(session.Removal),
+                voteFile(session.FileName, session.FileID),
+                session.Votes,
+                ui.validatorStatus.RequiredVotes,
+                time.Unix(session.Expires, 0).Format("15:04"),
+                voted,
+            ))
+        },
+    )
+    ui.pendingList.OnSelected = func(id widget.ListItemID) {
+        ui.selectedPending = int(id)
+    }
+
+    vote := func(approve bool) func() {
+        return func() {
+            if ui.selectedPending < 0 || ui.selectedPending >= len(ui.pendingData) {
+                dialog.ShowError(fmt.Errorf("please select a vote session"), ui.mainWindow)
+                return
+            }
+            session := ui.pendingData[ui.selectedPending]
+            if err := ui.client.CastVote(session.FileID, session.ClientID, approve); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+                return
+            }
+            ui.updateValidator()
+        }
+    }
+    approve := widget.NewButtonWithIcon("Approve", theme.ConfirmIcon(), vote(true))
+    deny := widget.NewButtonWithIcon("Deny", theme.CancelIcon(), vote(false))
+
+    ui.voteTable = widget.NewTable(
+        func() (int, int) { return len(ui.validatorStatus.History) + 1, len(voteColumns) },
+        func() fyne.CanvasObject { return widget.NewLabel("Template File Name") },
+        func(id widget.TableCellID, obj fyne.CanvasObject) {
+            label := obj.(*widget.Label)
+            if id.Row == 0 {
+                label.TextStyle = fyne.TextStyle{Bold: true}
+                label.SetText(voteColumns[id.Col])
+                return
+            }
+            label.TextStyle = fyne.TextStyle{}
+            record := ui.validatorStatus.History[id.Row-1]
+            switch id.Col {
+            case 0:
+                label.SetText(record.FileID)
+            case 1:
+                label.SetText(voteKind(record.Removal))
+            case 2:
+                if record.Approved {
+                    label.SetText("Approve")
+                } else {
+                    label.SetText("Deny")
+                }
+            case 3:
+                label.SetText(record.Outcome)
+            case 4:
+                label.SetText(time.Unix(record.Time, 0).Format("2006-01-02 15:04"))
+            }
+        },
+    )
+    ui.voteTable.SetColumnWidth(0, 240)
+    ui.voteTable.SetColumnWidth(4, 140)
+
+    go ui.updateValidator()
+    return container.NewBorder(
+        widget.NewCard(
+            "Validation",
+            "Validators vote on key requests and file removals",
+            container.NewVBox(ui.validatorToggle, ui.validatorSummary),
+        ),
+        nil,
+        nil,
+        nil,
+        container.NewGridWithRows(2,
+            widget.NewCard("Pending Votes", "", container.NewBorder(
+                nil,
+                container.NewHBox(approve, deny),
+                nil,
+                nil,
+                ui.pendingList,
+            )),
+            widget.NewCard("Vote History", "", ui.voteTable),
+        ),
+    )
+}
+
+// confirmValidatorChange explains what joining or leaving validation means
+// for this node before doing it
+func (ui *FileZapUI) confirmValidatorChange(enabled bool) {
+    if enabled == ui.validatorStatus.Validator {
+        return
+    }
+
+    balance := "unknown"
+    if report, err := ui.client.GetEarnings(1); err == nil {
+        balance = fmt.Sprintf("%d credits", report.Balance)
+    }
+
+    var title, message string
+    if enabled {
+        title = "Join Validation"
+        message = "This node will vote on key requests and file removals, hold key shares " +
+            "and keep a copy of the ledger. It should stay online while it validates.\n\n" +
+            "Validating holds no stake and earns nothing by itself: your balance of " + balance +
+            " is unchanged, and storage payouts continue as before."
+    } else {
+        title = "Leave Validation"
+        remaining := len(ui.validatorStatus.Validators) - 1
+        message = fmt.Sprintf("This node will stop voting, and the key shares it holds can no longer "+
+            "be used to decrypt files. %d validator(s) will remain, and a session needs %d votes.",
+            remaining, ui.validatorStatus.RequiredVotes)
+        if remaining < ui.validatorStatus.RequiredVotes {
+            message += "\n\nThe remaining validators cannot decide key requests on their own."
+        }
+        message += "\n\nYour balance of " + balance + " is kept by the remaining validators."
+    }
+
+    dialog.ShowConfirm(title, message, func(ok bool) {
+        if ok {
+            if err := ui.client.SetValidator(enabled); err != nil {
+                dialog.ShowError(err, ui.mainWindow)
+            }
+        }
+        ui.updateValidator()
+    }, ui.mainWindow)
+}
+
+func (ui *FileZapUI) updateValidator() {
+    ui.validatorStatus = ui.client.ValidatorStatus()
+    status := ui.validatorStatus
+
+    // Reflect the state without asking to change it again
+    ui.validatorToggle.Checked = status.Validator
+    ui.validatorToggle.Refresh()
+
+    role := "This node is not a validator."
+    if status.Validator {
+        role = "This node is a validator."
+    }
+    members := "none"
+    if len(status.Validators) > 0 {
+        members = strings.Join(status.Validators, ", ")
+    }
+    ui.validatorSummary.SetText(fmt.Sprintf(
+        "%s\n"+
+        "Quorum: %d validator(s), %d vote(s) decide a session\n"+
+        "Members: %s",
+        role,
+        len(status.Validators),
+        status.RequiredVotes,
+        members,
+    ))
+
+    ui.pendingData = status.Pending
+    if ui.selectedPending >= len(ui.pendingData) {
+        ui.selectedPending = -1
+        ui.pendingList.UnselectAll()
+    }
+    ui.pendingList.Refresh()
+    ui.voteTable.Refresh()
+}
+
+// voteKind names what a vote session decides
+func voteKind(removal bool) string {
+    if removal {
+        return "Removal"
+    }
+    return "Key request"
+}
+
+// voteFile names a file by its name if known
+func voteFile(name, id string) string {
+    if name != "" {
+        return name
+    }
+    return id
+}
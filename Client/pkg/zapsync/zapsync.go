@@ -0,0 +1,433 @@
+package zapsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Zap sync uploads the files of watched folders automatically. A file is
+// uploaded once it has had no events for the settle delay, so files still
+// being written are not uploaded half done, and only if its content changed
+// since its last upload. Manifests go to the library directory, one per
+// file; when an edited file is uploaded again the previous manifest is kept
+// beside the new one with its upload time in the name. A file edited while
+// its upload runs is uploaded again once it settles, and the stale upload's
+// manifest is discarded.
+const (
+	stateFile          = "zapsync.json"
+	LibraryDir         = "library"
+	DefaultSettleDelay = 2 * time.Second
+)
+
+// Uploader splits, encrypts and uploads the file at path, writing its .zap
+// manifest to manifestPath
+type Uploader func(ctx context.Context, path, manifestPath string) error
+
+// UploadHook is told of each stored upload: the file, its manifest, and
+// where the previous version's manifest was moved to, if there was one
+type UploadHook func(path, manifest, previous string)
+
+// FileState records the last upload of a watched file
+type FileState struct {
+	Hash     string `json:"hash"` // SHA-256 of the content uploaded
+	Manifest string `json:"manifest"`
+	Uploaded int64  `json:"uploaded"`
+}
+
+// state is what the syncer persists between runs
+type state struct {
+	Folders []string              `json:"folders"`
+	Files   map[string]*FileState `json:"files"` // By absolute path
+}
+
+// Syncer watches folders and uploads their new and modified files
+type Syncer struct {
+	dir     string
+	library string
+	upload  Uploader
+	settle  time.Duration
+	watcher *fsnotify.Watcher
+	state   state
+	pending map[string]time.Time // map[path]last event
+	hook    UploadHook
+	mu      sync.Mutex
+}
+
+// Open loads the watched folders stored in dataDir and starts watching
+// them. Files are uploaded with upload once Run is called.
+func Open(dataDir string, upload Uploader) (*Syncer, error) {
+	library := filepath.Join(dataDir, LibraryDir)
+	if err := os.MkdirAll(library, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create library directory: %v", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %v", err)
+	}
+
+	s := &Syncer{
+		dir:     dataDir,
+		library: library,
+		upload:  upload,
+		settle:  DefaultSettleDelay,
+		watcher: watcher,
+		state:   state{Files: make(map[string]*FileState)},
+		pending: make(map[string]time.Time),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dataDir, stateFile))
+	if err != nil && !os.IsNotExist(err) {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to read sync state: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("corrupt sync state: %v", err)
+		}
+		if s.state.Files == nil {
+			s.state.Files = make(map[string]*FileState)
+		}
+	}
+
+	// Files changed while we were not running are caught by the scan
+	for _, folder := range s.state.Folders {
+		if err := s.watchLocked(folder); err != nil {
+			log.Printf("Failed to watch %s: %v", folder, err)
+		}
+	}
+	return s, nil
+}
+
+// SetSettleDelay changes how long a file must be quiet before it is uploaded
+func (s *Syncer) SetSettleDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settle = d
+}
+
+// SetUploadHook sets a function told of each stored upload
+func (s *Syncer) SetUploadHook(hook UploadHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = hook
+}
+
+// Folders returns the watched folders
+func (s *Syncer) Folders() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.state.Folders...)
+}
+
+// File returns the last upload of a file, if it was uploaded
+func (s *Syncer) File(path string) (FileState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	file, exists := s.state.Files[path]
+	if !exists {
+		return FileState{}, false
+	}
+	return *file, true
+}
+
+// AddFolder starts watching a folder and its subfolders. Its existing files
+// are uploaded unless they were already.
+func (s *Syncer) AddFolder(folder string) error {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return fmt.Errorf("invalid folder: %v", err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return fmt.Errorf("invalid folder: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a folder", abs)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.state.Folders {
+		if existing == abs || within(abs, existing) || within(existing, abs) {
+			return fmt.Errorf("%s overlaps watched folder %s", abs, existing)
+		}
+	}
+
+	if err := s.watchLocked(abs); err != nil {
+		return err
+	}
+	s.state.Folders = append(s.state.Folders, abs)
+	return s.saveLocked()
+}
+
+// RemoveFolder stops watching a folder. Manifests already in the library
+// are kept.
+func (s *Syncer) RemoveFolder(folder string) error {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return fmt.Errorf("invalid folder: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for i, existing := range s.state.Folders {
+		if existing == abs {
+			s.state.Folders = append(s.state.Folders[:i], s.state.Folders[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s is not watched", abs)
+	}
+
+	for _, watched := range s.watcher.WatchList() {
+		if watched == abs || within(watched, abs) {
+			s.watcher.Remove(watched)
+		}
+	}
+	for path := range s.pending {
+		if within(path, abs) {
+			delete(s.pending, path)
+		}
+	}
+	for path := range s.state.Files {
+		if within(path, abs) {
+			delete(s.state.Files, path)
+		}
+	}
+	return s.saveLocked()
+}
+
+// watchLocked watches a folder tree and queues its files. s.mu must be held.
+func (s *Syncer) watchLocked(root string) error {
+	now := time.Now()
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && ignored(path) {
+				return filepath.SkipDir
+			}
+			if err := s.watcher.Add(path); err != nil {
+				return fmt.Errorf("failed to watch %s: %v", path, err)
+			}
+			return nil
+		}
+		if !ignored(path) {
+			s.pending[path] = now
+		}
+		return nil
+	})
+}
+
+// Run handles file events and uploads settled files until ctx is done
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.tick())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			s.handleEvent(event)
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Sync watcher error: %v", err)
+		case <-ticker.C:
+			for _, path := range s.settled() {
+				if err := s.process(ctx, path); err != nil {
+					log.Printf("Failed to sync %s: %v", path, err)
+				}
+			}
+		}
+	}
+}
+
+func (s *Syncer) tick() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.settle < 20*time.Millisecond {
+		return 10 * time.Millisecond
+	}
+	return s.settle / 2
+}
+
+// handleEvent queues the file an event is about
+func (s *Syncer) handleEvent(event fsnotify.Event) {
+	if ignored(event.Name) || !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// New subfolders are watched too
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := s.watchLocked(event.Name); err != nil {
+				log.Printf("Failed to watch %s: %v", event.Name, err)
+			}
+			return
+		}
+	}
+	s.pending[event.Name] = time.Now()
+}
+
+// settled removes and returns the queued files quiet for the settle delay
+func (s *Syncer) settled() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var paths []string
+	for path, last := range s.pending {
+		if time.Since(last) >= s.settle {
+			paths = append(paths, path)
+			delete(s.pending, path)
+		}
+	}
+	return paths
+}
+
+// process uploads a file if its content changed since its last upload
+func (s *Syncer) process(ctx context.Context, path string) error {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil // Deleted, or not a regular file
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	previous, uploaded := s.state.Files[path]
+	s.mu.Unlock()
+	if uploaded && previous.Hash == hash {
+		return nil
+	}
+
+	manifest := filepath.Join(s.library, manifestName(path))
+	tmp := manifest + ".tmp"
+	if err := s.upload(ctx, path, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("upload failed: %v", err)
+	}
+
+	// An edit during the upload makes it stale; upload again once the
+	// file settles
+	after, err := hashFile(path)
+	if err != nil || after != hash {
+		os.Remove(tmp)
+		s.mu.Lock()
+		s.pending[path] = time.Now()
+		s.mu.Unlock()
+		return nil
+	}
+
+	// Keep the manifest of the previous version
+	var version string
+	if uploaded {
+		version = strings.TrimSuffix(manifest, ".zap") + fmt.Sprintf(".%d.zap", previous.Uploaded)
+		if err := os.Rename(manifest, version); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to keep previous manifest: %v", err)
+		}
+	}
+	if err := os.Rename(tmp, manifest); err != nil {
+		return fmt.Errorf("failed to store manifest: %v", err)
+	}
+
+	s.mu.Lock()
+	s.state.Files[path] = &FileState{Hash: hash, Manifest: manifest, Uploaded: time.Now().UnixNano()}
+	err = s.saveLocked()
+	hook := s.hook
+	s.mu.Unlock()
+
+	if hook != nil {
+		hook(path, manifest, version)
+	}
+	return err
+}
+
+// saveLocked atomically writes the sync state. s.mu must be held.
+func (s *Syncer) saveLocked() error {
+	data, err := json.Marshal(&s.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync state: %v", err)
+	}
+	path := filepath.Join(s.dir, stateFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save sync state: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save sync state: %v", err)
+	}
+	return nil
+}
+
+// Close stops watching
+func (s *Syncer) Close() error {
+	return s.watcher.Close()
+}
+
+// manifestName names a file's manifest after the file, disambiguated by a
+// hash of its path so same-named files in different folders do not clash
+func manifestName(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return fmt.Sprintf("%s-%s.zap", filepath.Base(path), hex.EncodeToString(sum[:4]))
+}
+
+// ignored reports whether a path is hidden, temporary or a manifest
+func ignored(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+		strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".zap")
+}
+
+// within reports whether path is inside folder
+func within(path, folder string) bool {
+	rel, err := filepath.Rel(folder, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,154 @@
+package zapsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is an Uploader that writes the uploaded content as the manifest
+type recorder struct {
+	uploads []string
+	before  func(path string) // Runs during an upload, if set
+	mu      sync.Mutex
+}
+
+func (r *recorder) upload(_ context.Context, path, manifestPath string) error {
+	r.mu.Lock()
+	r.uploads = append(r.uploads, path)
+	before := r.before
+	r.before = nil
+	r.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if before != nil {
+		before(path)
+	}
+	return os.WriteFile(manifestPath, data, 0644)
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.uploads)
+}
+
+func startSyncer(t *testing.T, dataDir string, r *recorder) *Syncer {
+	s, err := Open(dataDir, r.upload)
+	require.NoError(t, err)
+	s.SetSettleDelay(20 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		s.Close()
+	})
+	return s
+}
+
+func waitForUpload(t *testing.T, s *Syncer, path, content string) FileState {
+	var state FileState
+	require.Eventually(t, func() bool {
+		var ok bool
+		state, ok = s.File(path)
+		if !ok {
+			return false
+		}
+		data, err := os.ReadFile(state.Manifest)
+		return err == nil && string(data) == content
+	}, 5*time.Second, 10*time.Millisecond)
+	return state
+}
+
+func TestSyncUploadsNewAndEditedFiles(t *testing.T) {
+	dataDir := t.TempDir()
+	folder := t.TempDir()
+	existing := filepath.Join(folder, "existing.txt")
+	require.NoError(t, os.WriteFile(existing, []byte("v1"), 0644))
+
+	r := &recorder{}
+	s := startSyncer(t, dataDir, r)
+	require.NoError(t, s.AddFolder(folder))
+
+	// Existing files are uploaded when the folder is added
+	first := waitForUpload(t, s, existing, "v1")
+
+	// Files in new subfolders are picked up
+	sub := filepath.Join(folder, "sub")
+	require.NoError(t, os.Mkdir(sub, 0755))
+	created := filepath.Join(sub, "new.txt")
+	require.NoError(t, os.WriteFile(created, []byte("new"), 0644))
+	waitForUpload(t, s, created, "new")
+
+	// An edit replaces the manifest and keeps the previous version
+	require.NoError(t, os.WriteFile(existing, []byte("v2"), 0644))
+	second := waitForUpload(t, s, existing, "v2")
+	assert.Equal(t, first.Manifest, second.Manifest)
+	versions, err := filepath.Glob(filepath.Join(dataDir, LibraryDir, "existing.txt-*.*.zap"))
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	data, err := os.ReadFile(versions[0])
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+
+	// Rewriting the same content uploads nothing
+	uploads := r.count()
+	require.NoError(t, os.WriteFile(existing, []byte("v2"), 0644))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, uploads, r.count())
+}
+
+func TestSyncRetriesFileEditedDuringUpload(t *testing.T) {
+	folder := t.TempDir()
+	path := filepath.Join(folder, "doc.txt")
+	require.NoError(t, os.WriteFile(path, []byte("draft"), 0644))
+
+	r := &recorder{before: func(path string) {
+		os.WriteFile(path, []byte("final"), 0644)
+	}}
+	s := startSyncer(t, t.TempDir(), r)
+	require.NoError(t, s.AddFolder(folder))
+
+	// The upload of the draft is discarded and the final version uploaded
+	waitForUpload(t, s, path, "final")
+	assert.GreaterOrEqual(t, r.count(), 2)
+}
+
+func TestSyncFoldersPersist(t *testing.T) {
+	dataDir := t.TempDir()
+	folder := t.TempDir()
+	path := filepath.Join(folder, "a.txt")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0644))
+
+	s, err := Open(dataDir, (&recorder{}).upload)
+	require.NoError(t, err)
+	require.NoError(t, s.AddFolder(folder))
+	assert.Error(t, s.AddFolder(filepath.Join(folder, ".")))
+	require.NoError(t, s.Close())
+
+	// After a restart the folder is watched again and its files uploaded
+	r := &recorder{}
+	s = startSyncer(t, dataDir, r)
+	assert.Equal(t, []string{folder}, s.Folders())
+	waitForUpload(t, s, path, "a")
+
+	require.NoError(t, s.RemoveFolder(folder))
+	assert.Empty(t, s.Folders())
+	_, ok := s.File(path)
+	assert.False(t, ok)
+}
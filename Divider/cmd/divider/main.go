@@ -131,6 +131,7 @@ func splitMode(inputFile, outputDir string, chunkSize int64) error {
 		ChunkCount:    len(chunks),
 		TotalSize:     chunkSize * int64(len(chunks)),
 		EncryptionKey: key,
+		HashAlgorithm: chunking.DefaultHashAlgorithm,
 		Chunks:        zapChunks,
 	}
 
@@ -186,10 +187,11 @@ func joinMode(zapFile, outputDir string) error {
 		}
 
 		chunkInfos = append(chunkInfos, chunking.ChunkInfo{
-			Index:    chunk.Index,
-			Hash:     chunk.Hash,
-			Size:     chunk.Size,
-			Filename: tempPath,
+			Index:     chunk.Index,
+			Hash:      chunk.Hash,
+			Size:      chunk.Size,
+			Filename:  tempPath,
+			Algorithm: metadata.HashAlgorithm,
 		})
 	}
 
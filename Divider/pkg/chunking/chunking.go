@@ -1,8 +1,6 @@
 package chunking
 
 import (
-"crypto/sha256"
-"encoding/hex"
 "fmt"
 "io"
 "os"
@@ -13,19 +11,31 @@ const DefaultChunkSize = 1024 * 1024 // 1MB default chunk size
 
 // ChunkInfo represents metadata about a chunk
 type ChunkInfo struct {
-	Index    int    `json:"index"`
-	Hash     string `json:"hash"`
-	Size     int64  `json:"size"`
-	Filename string `json:"filename"`
+	Index     int           `json:"index"`
+	Hash      string        `json:"hash"`
+	Size      int64         `json:"size"`
+	Filename  string        `json:"filename"`
+	Algorithm HashAlgorithm `json:"algorithm,omitempty"` // Hash naming the chunk, SHA-256 if empty
 }
 
-// SplitFile splits a file into chunks of specified size
+// SplitFile splits a file into chunks of specified size, named by their
+// DefaultHashAlgorithm hash
 func SplitFile(inputPath string, chunkSize int64, outputDir string) ([]ChunkInfo, error) {
+	return SplitFileWithHash(inputPath, chunkSize, outputDir, DefaultHashAlgorithm)
+}
+
+// SplitFileWithHash splits a file into chunks of specified size, named by
+// their hash under algorithm
+func SplitFileWithHash(inputPath string, chunkSize int64, outputDir string, algorithm HashAlgorithm) ([]ChunkInfo, error) {
     // Validate chunk size
     if chunkSize <= 0 {
         return nil, fmt.Errorf("invalid chunk size: must be greater than 0")
     }
 
+    if _, err := algorithm.Sum(nil); err != nil {
+        return nil, err
+    }
+
     // Check if the path is valid for the current OS
     if filepath.VolumeName(outputDir) == "" && (len(outputDir) > 0 && (outputDir[0] == '/' || outputDir[0] == '\\')) {
         return nil, fmt.Errorf("invalid output directory path: must be a valid OS-specific path")
@@ -80,8 +90,10 @@ func SplitFile(inputPath string, chunkSize int64, outputDir string) ([]ChunkInfo
 		buffer = buffer[:bytesRead]
 
 		// Calculate hash
-		hash := sha256.Sum256(buffer)
-		hashString := hex.EncodeToString(hash[:])
+		hashString, hashErr := algorithm.Sum(buffer)
+		if hashErr != nil {
+			return nil, hashErr
+		}
 
 		// Create chunk filename
 		chunkFilename := filepath.Join(outputDir, hashString)
@@ -92,10 +104,11 @@ func SplitFile(inputPath string, chunkSize int64, outputDir string) ([]ChunkInfo
 		}
 
 		chunks = append(chunks, ChunkInfo{
-			Index:    index,
-			Hash:     hashString,
-			Size:     int64(bytesRead),
-			Filename: chunkFilename,
+			Index:     index,
+			Hash:      hashString,
+			Size:      int64(bytesRead),
+			Filename:  chunkFilename,
+			Algorithm: algorithm,
 		})
 
 		index++
@@ -133,9 +146,8 @@ func ReassembleFile(chunks []ChunkInfo, outputPath string) error {
 		}
 
 		// Verify hash
-		hash := sha256.Sum256(chunkData)
-		if hex.EncodeToString(hash[:]) != chunk.Hash {
-			return err
+		if !chunk.Algorithm.Verify(chunkData, chunk.Hash) {
+			return fmt.Errorf("chunk %d does not match its hash", chunk.Index)
 		}
 
 		if _, err := outFile.Write(chunkData); err != nil {
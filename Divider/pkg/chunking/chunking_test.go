@@ -2,6 +2,7 @@ package chunking
 
 import (
 	"crypto/rand"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -11,7 +12,7 @@ import (
 )
 
 // createTestFile creates a temporary file with random data for testing
-func createTestFile(t *testing.T, size int64) string {
+func createTestFile(t testing.TB, size int64) string {
 	tempFile, err := os.CreateTemp("", "test_file_*.dat")
 	require.NoError(t, err)
 	defer tempFile.Close()
@@ -171,3 +172,58 @@ func TestReassembleFileErrors(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+// benchFileSize is the size of the file split and reassembled by the
+// benchmarks
+const benchFileSize = 16 * 1024 * 1024
+
+// benchChunkSizes are the chunk sizes the benchmarks compare
+var benchChunkSizes = []int64{64 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024}
+
+// relTempDir returns a temporary directory relative to the working
+// directory, as SplitFile refuses rooted paths
+func relTempDir(b *testing.B) string {
+	wd, err := os.Getwd()
+	require.NoError(b, err)
+	dir, err := filepath.Rel(wd, b.TempDir())
+	require.NoError(b, err)
+	return dir
+}
+
+func BenchmarkSplitFile(b *testing.B) {
+	testFile := createTestFile(b, benchFileSize)
+	defer os.Remove(testFile)
+
+	for _, chunkSize := range benchChunkSizes {
+		b.Run(fmt.Sprintf("chunk=%dKiB", chunkSize/1024), func(b *testing.B) {
+			outputDir := relTempDir(b)
+			b.SetBytes(benchFileSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := SplitFile(testFile, chunkSize, outputDir); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReassembleFile(b *testing.B) {
+	testFile := createTestFile(b, benchFileSize)
+	defer os.Remove(testFile)
+
+	for _, chunkSize := range benchChunkSizes {
+		b.Run(fmt.Sprintf("chunk=%dKiB", chunkSize/1024), func(b *testing.B) {
+			chunks, err := SplitFile(testFile, chunkSize, relTempDir(b))
+			require.NoError(b, err)
+			outputPath := filepath.Join(b.TempDir(), "reassembled.dat")
+			b.SetBytes(benchFileSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := ReassembleFile(chunks, outputPath); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package chunking
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"lukechampine.com/blake3"
+)
+
+// HashAlgorithm names the hash chunks are named and verified by
+type HashAlgorithm string
+
+const (
+	HashSHA256 HashAlgorithm = "sha256"
+	HashBLAKE3 HashAlgorithm = "blake3" // 256-bit output, SIMD accelerated where the CPU allows
+
+	// DefaultHashAlgorithm is used for new files. Files recording no
+	// algorithm predate the choice and use SHA-256.
+	DefaultHashAlgorithm = HashBLAKE3
+)
+
+// ParseHashAlgorithm returns the algorithm named s, SHA-256 for ""
+func ParseHashAlgorithm(s string) (HashAlgorithm, error) {
+	switch a := HashAlgorithm(s); a {
+	case "":
+		return HashSHA256, nil
+	case HashSHA256, HashBLAKE3:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", s)
+	}
+}
+
+// Sum returns the hex hash of data. The empty algorithm is SHA-256.
+func (a HashAlgorithm) Sum(data []byte) (string, error) {
+	switch a {
+	case "", HashSHA256:
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	case HashBLAKE3:
+		sum := blake3.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", string(a))
+	}
+}
+
+// Verify reports whether data hashes to hash
+func (a HashAlgorithm) Verify(data []byte, hash string) bool {
+	sum, err := a.Sum(data)
+	return err == nil && sum == hash
+}
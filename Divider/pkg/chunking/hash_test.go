@@ -0,0 +1,32 @@
+package chunking
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAlgorithms(t *testing.T) {
+	vectors := map[HashAlgorithm]string{
+		"":         "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		HashSHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		HashBLAKE3: "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+	}
+	for algorithm, want := range vectors {
+		got, err := algorithm.Sum(nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "algorithm %q", algorithm)
+		assert.True(t, algorithm.Verify(nil, want))
+		assert.False(t, algorithm.Verify([]byte("x"), want))
+	}
+
+	_, err := HashAlgorithm("md5").Sum(nil)
+	assert.Error(t, err)
+
+	parsed, err := ParseHashAlgorithm("")
+	require.NoError(t, err)
+	assert.Equal(t, HashSHA256, parsed)
+	_, err = ParseHashAlgorithm("md5")
+	assert.Error(t, err)
+}
@@ -2,7 +2,9 @@ package encryption
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -147,3 +149,61 @@ func TestDecryptionErrors(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+// benchCiphers are the AES-GCM key sizes Encrypt and Decrypt accept
+var benchCiphers = []struct {
+	name    string
+	keySize int
+}{
+	{"AES-128-GCM", 16},
+	{"AES-192-GCM", 24},
+	{"AES-256-GCM", 32},
+}
+
+// benchDataSizes are the chunk sizes the cipher benchmarks compare
+var benchDataSizes = []int{64 * 1024, 1024 * 1024, 4 * 1024 * 1024}
+
+// benchKey returns a random hex key of keySize bytes
+func benchKey(b *testing.B, keySize int) string {
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	require.NoError(b, err)
+	return hex.EncodeToString(key)
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	for _, c := range benchCiphers {
+		for _, size := range benchDataSizes {
+			b.Run(fmt.Sprintf("%s/size=%dKiB", c.name, size/1024), func(b *testing.B) {
+				key := benchKey(b, c.keySize)
+				data := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := Encrypt(data, key); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	for _, c := range benchCiphers {
+		for _, size := range benchDataSizes {
+			b.Run(fmt.Sprintf("%s/size=%dKiB", c.name, size/1024), func(b *testing.B) {
+				key := benchKey(b, c.keySize)
+				encrypted, err := Encrypt(make([]byte, size), key)
+				require.NoError(b, err)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := Decrypt(encrypted, key); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
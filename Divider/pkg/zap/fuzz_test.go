@@ -0,0 +1,28 @@
+package zap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzReadZapFile feeds arbitrary .zap contents to ReadZapFile, which must
+// fail with an error rather than panic on malformed metadata
+func FuzzReadZapFile(f *testing.F) {
+	f.Add([]byte(`{"id":"test123","original_name":"test.txt","chunk_count":1,"total_size":5,"chunks":[{"index":0,"hash":"a","size":5,"encrypted_hash":"b"}]}`))
+	f.Add([]byte(`{"chunks":[{"index":-1}]}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte{})
+
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		zapPath := filepath.Join(dir, "fuzz.zap")
+		if err := os.WriteFile(zapPath, data, 0644); err != nil {
+			t.Fatalf("failed to write zap file: %v", err)
+		}
+		metadata, err := ReadZapFile(zapPath)
+		if err == nil && metadata == nil {
+			t.Fatal("ReadZapFile() returned neither metadata nor an error")
+		}
+	})
+}
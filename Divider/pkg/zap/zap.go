@@ -8,16 +8,19 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/VetheonGames/FileZap/Divider/pkg/chunking"
 )
 
 // FileMetadata represents the metadata stored in a .zap file
 type FileMetadata struct {
-	ID            string          `json:"id"`
-	OriginalName  string          `json:"original_name"`
-	ChunkCount    int             `json:"chunk_count"`
-	TotalSize     int64           `json:"total_size"`
-	EncryptionKey string          `json:"encryption_key,omitempty"`
-	Chunks        []ChunkMetadata `json:"chunks"`
+	ID            string                 `json:"id"`
+	OriginalName  string                 `json:"original_name"`
+	ChunkCount    int                    `json:"chunk_count"`
+	TotalSize     int64                  `json:"total_size"`
+	EncryptionKey string                 `json:"encryption_key,omitempty"`
+	HashAlgorithm chunking.HashAlgorithm `json:"hash_algorithm,omitempty"` // Hash of the chunks, SHA-256 if empty
+	Chunks        []ChunkMetadata        `json:"chunks"`
 }
 
 // ChunkMetadata represents metadata for a single encrypted chunk
@@ -103,6 +106,9 @@ func ReadZapFile(zapPath string) (*FileMetadata, error) {
 	if err := json.Unmarshal(data, &metadata); err != nil {
 		return nil, err
 	}
+	if _, err := chunking.ParseHashAlgorithm(string(metadata.HashAlgorithm)); err != nil {
+		return nil, err
+	}
 
 	return &metadata, nil
 }
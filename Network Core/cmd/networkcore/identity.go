@@ -0,0 +1,45 @@
+package main
+
+import (
+    "fmt"
+    "os"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
+)
+
+// exportIdentity writes the node key to path, sealed with the identity
+// passphrase, for moving the node to another machine
+func exportIdentity(settings *nodeSettings, path string) error {
+    id, err := identity.Load(settings.identityPath(), settings.IdentityPassphrase)
+    if err != nil {
+        return err
+    }
+    data, err := id.Export(settings.IdentityPassphrase)
+    if err != nil {
+        return err
+    }
+    if err := os.WriteFile(path, data, 0600); err != nil {
+        return fmt.Errorf("failed to write export: %w", err)
+    }
+    return nil
+}
+
+// importIdentity makes the key exported to path the node key. An existing
+// key is never overwritten, since its peer ID would be lost.
+func importIdentity(settings *nodeSettings, path string) (*identity.Identity, error) {
+    if _, err := os.Stat(settings.identityPath()); err == nil {
+        return nil, fmt.Errorf("%s already exists, move it away to replace it", settings.identityPath())
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read export: %w", err)
+    }
+    id, err := identity.Import(data, settings.IdentityPassphrase)
+    if err != nil {
+        return nil, err
+    }
+    if err := id.Save(settings.identityPath(), settings.IdentityPassphrase); err != nil {
+        return nil, err
+    }
+    return id, nil
+}
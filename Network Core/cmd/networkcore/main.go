@@ -10,32 +10,77 @@ import (
     "syscall"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/config"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
     "github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
 )
 
 func main() {
-    // Parse flags for network configuration
-    storageDir := flag.String("storage", "storage", "Directory for storing chunks")
-    metadataDir := flag.String("metadata", "metadata", "Directory for storing metadata")
-    port := flag.Int("port", 6001, "Port to listen on")
+    // Flags are parsed again once the config file is loaded so that they
+    // override it
+    settings := defaultSettings()
+    configPath := flag.String("config", "", "Config file (.yaml, .toml or .json); send SIGHUP to reload it")
+    exportPath := flag.String("identity-export", "", "Export the node key to this file and exit")
+    importPath := flag.String("identity-import", "", "Use the node key exported to this file")
+    settings.bindFlags(flag.CommandLine)
     flag.Parse()
 
+    source := config.Source{Path: *configPath, EnvPrefix: envPrefix}
+    if err := source.Load(settings); err != nil {
+        log.Fatalf("Failed to load config: %v", err)
+    }
+    flag.CommandLine.Parse(os.Args[1:])
+    if err := settings.Validate(); err != nil {
+        log.Fatalf("Invalid settings: %v", err)
+    }
+
+    if *exportPath != "" {
+        if err := exportIdentity(settings, *exportPath); err != nil {
+            log.Fatalf("Failed to export identity: %v", err)
+        }
+        log.Printf("Identity exported to %s", *exportPath)
+        return
+    }
+
+    // Keep the same peer ID across restarts
+    var id *identity.Identity
+    var err error
+    if *importPath != "" {
+        id, err = importIdentity(settings, *importPath)
+    } else {
+        id, err = identity.LoadOrCreate(settings.identityPath(), settings.IdentityPassphrase)
+    }
+    if err != nil {
+        log.Fatalf("Failed to load identity: %v", err)
+    }
+    cfg := settings.networkConfig(id)
+
     // Create base context
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
-    // Create network config
-    cfg := network.DefaultNetworkConfig()
-    cfg.ChunkCacheDir = *storageDir
-    cfg.MetadataStore = *metadataDir
-    cfg.Transport.ListenPort = *port
-
     // Create network engine
     engine, err := network.NewNetworkEngine(ctx, cfg)
     if err != nil {
         log.Fatalf("Failed to create network engine: %v", err)
     }
     defer engine.Close()
+    if err := engine.SetChunkBackend(cfg.Backend); err != nil {
+        log.Fatalf("Failed to open chunk backend: %v", err)
+    }
+
+    // Reload the tunable settings on SIGHUP
+    reloader := config.NewReloader(source, func() interface{} {
+        return defaultSettings()
+    }, func(v interface{}) error {
+        reloaded := v.(*nodeSettings)
+        if err := engine.SetStorageConfig(reloaded.Storage); err != nil {
+            return err
+        }
+        return engine.SetReplicationGoal(reloaded.ReplicationGoal)
+    })
+    go reloader.Watch(ctx)
 
     // Print network information
     log.Printf("Network node started")
@@ -49,12 +94,27 @@ func main() {
         log.Printf("  - %s/p2p/%s", addr, engine.GetNodeID())
     }
 
+    // Start metrics endpoint
+    var metricsErr <-chan error
+    if settings.Metrics != "" {
+        metricsServer := metrics.NewServer(settings.Metrics)
+        metricsErr = metricsServer.Start()
+        defer metricsServer.Stop(context.Background())
+        log.Printf("Metrics available at http://%s/metrics", settings.Metrics)
+    }
+
     // Handle signals
     sigChan := make(chan os.Signal, 1)
     signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-    // Wait for interrupt
-    <-sigChan
+    // Wait for interrupt or metrics server failure
+    select {
+    case <-sigChan:
+    case err, ok := <-metricsErr:
+        if ok {
+            log.Printf("Metrics server failed: %v", err)
+        }
+    }
     fmt.Println("\nShutting down...")
 
     // Give pending operations a chance to complete
@@ -0,0 +1,163 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/ipfs"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
+)
+
+// envPrefix prefixes the environment variables overriding the config file,
+// e.g. FILEZAP_NODE_PORT or FILEZAP_NODE_STORAGE_QUOTA
+const envPrefix = "FILEZAP_NODE"
+
+// nodeSettings are the settings of a network node, read from the config
+// file and the environment. Flags given on the command line override both.
+// Only the storage offer and replication goal are reloaded while running;
+// the rest take effect on restart.
+type nodeSettings struct {
+    StorageDir   string   `json:"storage_dir"`
+    MetadataDir  string   `json:"metadata_dir"`
+    Port         int      `json:"port"`
+    IPv6         bool     `json:"ipv6"`
+    QUIC         bool     `json:"quic"`
+    NoTCP        bool     `json:"no_tcp"`
+    WSPort       int      `json:"ws_port"`
+    WebTransport bool     `json:"webtransport"`
+    Announce     []string `json:"announce"`
+    Metrics      string   `json:"metrics"`
+
+    // Node key, by default in the metadata directory. The passphrase is
+    // best given as FILEZAP_NODE_IDENTITY_PASSPHRASE.
+    IdentityFile       string `json:"identity_file"`
+    IdentityPassphrase string `json:"identity_passphrase"`
+
+    ReplicationGoal int                      `json:"replication_goal"`
+    Storage         network.StorageConfig    `json:"storage"`
+    Backend         network.BackendConfig    `json:"backend"` // The S3 secret is best given as FILEZAP_NODE_BACKEND_S3_SECRET_KEY
+    IPFS            ipfs.Config              `json:"ipfs"`
+    Connections     network.ConnectionConfig `json:"connections"`
+    ManifestCache   network.ManifestCacheConfig `json:"manifest_cache"`
+    Quorum          network.QuorumConfig     `json:"quorum"`
+
+    // Where the node runs, e.g. "eu-west" and its provider's AS number,
+    // and how many replicas of a chunk uploads may place in one of either
+    Region       string `json:"region"`
+    ASN          uint32 `json:"asn"`
+    MaxPerRegion int    `json:"max_per_region"`
+    MaxPerASN    int    `json:"max_per_asn"`
+}
+
+// defaultSettings returns the settings of a node without a config file
+func defaultSettings() *nodeSettings {
+    return &nodeSettings{
+        StorageDir:      "storage",
+        MetadataDir:     "metadata",
+        Port:            6001,
+        Metrics:         "localhost:9090",
+        ReplicationGoal: network.DefaultReplicationGoal,
+        Storage:         network.DefaultStorageConfig(),
+        Connections:     network.DefaultConnectionConfig(),
+        ManifestCache:   network.DefaultManifestCacheConfig(),
+        Quorum:          network.DefaultQuorumConfig(),
+    }
+}
+
+// Validate implements config.Validator
+func (s *nodeSettings) Validate() error {
+    if s.Port < 1 || s.Port > 65534 {
+        return fmt.Errorf("port must be between 1 and 65534")
+    }
+    if s.WSPort < 0 || s.WSPort > 65535 {
+        return fmt.Errorf("ws_port must be between 0 and 65535")
+    }
+    if s.MaxPerRegion < 0 || s.MaxPerASN < 0 {
+        return fmt.Errorf("max_per_region and max_per_asn must not be negative")
+    }
+    if s.ReplicationGoal < 1 {
+        return fmt.Errorf("replication_goal must be at least 1")
+    }
+    if err := s.Storage.Validate(); err != nil {
+        return err
+    }
+    if err := s.Backend.Validate(); err != nil {
+        return err
+    }
+    if err := s.IPFS.Validate(); err != nil {
+        return err
+    }
+    if err := s.Connections.Validate(); err != nil {
+        return err
+    }
+    if err := s.ManifestCache.Validate(); err != nil {
+        return err
+    }
+    return s.Quorum.Validate()
+}
+
+// bindFlags registers the command line flags, which write into s
+func (s *nodeSettings) bindFlags(fs *flag.FlagSet) {
+    fs.StringVar(&s.StorageDir, "storage", s.StorageDir, "Directory for storing chunks")
+    fs.StringVar(&s.MetadataDir, "metadata", s.MetadataDir, "Directory for storing metadata")
+    fs.IntVar(&s.Port, "port", s.Port, "Port to listen on")
+    fs.BoolVar(&s.IPv6, "ipv6", s.IPv6, "Also listen on IPv6")
+    fs.BoolVar(&s.QUIC, "quic", s.QUIC, "Also listen on QUIC")
+    fs.BoolVar(&s.NoTCP, "no-tcp", s.NoTCP, "Disable the TCP transport, e.g. for QUIC-only nodes")
+    fs.IntVar(&s.WSPort, "ws-port", s.WSPort, "Also listen for WebSocket connections on this port (0 to disable)")
+    fs.BoolVar(&s.WebTransport, "webtransport", s.WebTransport, "Also listen on WebTransport, sharing the QUIC port")
+    fs.Func("announce", "Comma-separated multiaddrs to advertise for the transport host, e.g. a port forward", func(value string) error {
+        s.Announce = strings.Split(value, ",")
+        return nil
+    })
+    fs.StringVar(&s.Region, "region", s.Region, "Region this node runs in, gossiped to peers")
+    fs.Func("asn", "AS number of this node's network provider, gossiped to peers", func(value string) error {
+        asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+        if err != nil {
+            return fmt.Errorf("invalid AS number: %s", value)
+        }
+        s.ASN = uint32(asn)
+        return nil
+    })
+    fs.StringVar(&s.Metrics, "metrics", s.Metrics, "Address to serve /metrics on (empty to disable)")
+    fs.StringVar(&s.IdentityFile, "identity", s.IdentityFile, "Node key file (default identity.json in the metadata directory)")
+}
+
+// identityPath returns where the node key is kept
+func (s *nodeSettings) identityPath() string {
+    if s.IdentityFile != "" {
+        return s.IdentityFile
+    }
+    return filepath.Join(s.MetadataDir, identity.FileName)
+}
+
+// networkConfig returns the engine configuration for the settings, running
+// as the given identity
+func (s *nodeSettings) networkConfig(id *identity.Identity) *network.NetworkConfig {
+    cfg := network.DefaultNetworkConfig()
+    cfg.ChunkCacheDir = s.StorageDir
+    cfg.MetadataStore = s.MetadataDir
+    cfg.Transport.ListenPort = s.Port
+    cfg.Transport.EnableIPv6 = s.IPv6
+    cfg.Transport.EnableQUIC = s.QUIC
+    cfg.Transport.EnableTCP = !s.NoTCP
+    cfg.Transport.EnableWebSocket = s.WSPort > 0
+    cfg.Transport.WebSocketPort = s.WSPort
+    cfg.Transport.EnableWebTransport = s.WebTransport
+    cfg.Transport.AnnounceAddrs = s.Announce
+    cfg.ReplicationGoal = s.ReplicationGoal
+    cfg.Storage = s.Storage
+    cfg.Backend = s.Backend
+    cfg.IPFS = s.IPFS
+    cfg.Connections = s.Connections
+    cfg.ManifestCache = s.ManifestCache
+    cfg.Quorum = s.Quorum
+    cfg.Locality = network.Locality{Region: s.Region, ASN: s.ASN}
+    cfg.Placement = network.PlacementPolicy{MaxPerRegion: s.MaxPerRegion, MaxPerASN: s.MaxPerASN}
+    cfg.Identity = id
+    return cfg
+}
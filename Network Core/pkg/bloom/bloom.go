@@ -0,0 +1,107 @@
+// Package bloom implements Bloom filters, compact summaries of a set that
+// answer whether an item may be in it. A filter never reports an added
+// item missing, but reports a missing item present with a small chance
+// chosen when the filter is sized.
+//
+// Filters encode to JSON, so peers can send each other a summary of the
+// chunks they hold instead of the full list.
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// Filter size limits
+const (
+	MaxBits   = 64 * 1024 * 1024 // Largest filter accepted, 8 MiB
+	MaxHashes = 32
+)
+
+// ErrInvalidFilter is returned for filters whose size or hash count is out
+// of range, such as ones decoded from a corrupt message
+var ErrInvalidFilter = errors.New("invalid bloom filter")
+
+// Filter is a Bloom filter over strings
+type Filter struct {
+	Bits   []byte `json:"bits"`
+	Hashes int    `json:"hashes"` // Bits set per item
+}
+
+// New returns a filter sized to hold n items with the given chance of
+// false positives
+func New(n int, falsePositive float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if falsePositive <= 0 || falsePositive >= 1 {
+		falsePositive = 0.01
+	}
+
+	// Optimal size and hash count for n items
+	m := math.Ceil(-float64(n) * math.Log(falsePositive) / (math.Ln2 * math.Ln2))
+	m = math.Min(math.Max(m, 64), MaxBits)
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	if k > MaxHashes {
+		k = MaxHashes
+	}
+
+	return &Filter{Bits: make([]byte, (int(m)+7)/8), Hashes: k}
+}
+
+// FromItems returns a filter holding items with the given chance of false
+// positives
+func FromItems(items []string, falsePositive float64) *Filter {
+	f := New(len(items), falsePositive)
+	for _, item := range items {
+		f.Add(item)
+	}
+	return f
+}
+
+// Validate checks that a filter, typically one received from a peer, can
+// be used
+func (f *Filter) Validate() error {
+	if f == nil || len(f.Bits) == 0 || len(f.Bits)*8 > MaxBits || f.Hashes < 1 || f.Hashes > MaxHashes {
+		return ErrInvalidFilter
+	}
+	return nil
+}
+
+// Add inserts an item
+func (f *Filter) Add(item string) {
+	h1, h2 := hash(item)
+	m := uint64(len(f.Bits)) * 8
+	for i := 0; i < f.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		f.Bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test reports whether an item may have been added. False means it was
+// certainly not.
+func (f *Filter) Test(item string) bool {
+	h1, h2 := hash(item)
+	m := uint64(len(f.Bits)) * 8
+	for i := 0; i < f.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		if f.Bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hash derives the two hashes an item's bits are computed from
+func hash(item string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(item))
+	sum := h.Sum(nil)
+	// An odd step visits distinct bits for every hash
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
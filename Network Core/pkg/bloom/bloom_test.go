@@ -0,0 +1,80 @@
+package bloom
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestFilterHasNoFalseNegatives(t *testing.T) {
+	var items []string
+	for i := 0; i < 5000; i++ {
+		items = append(items, fmt.Sprintf("chunk-%d", i))
+	}
+	f := FromItems(items, 0.01)
+
+	for _, item := range items {
+		if !f.Test(item) {
+			t.Fatalf("Test(%q) = false for an added item", item)
+		}
+	}
+}
+
+func TestFilterFalsePositiveRate(t *testing.T) {
+	const n = 5000
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("chunk-%d", i))
+	}
+
+	positives := 0
+	for i := 0; i < n; i++ {
+		if f.Test(fmt.Sprintf("other-%d", i)) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / n; rate > 0.03 {
+		t.Errorf("false positive rate = %.3f, want about 0.01", rate)
+	}
+}
+
+func TestFilterJSONRoundTrip(t *testing.T) {
+	f := FromItems([]string{"a", "b", "c"}, 0.01)
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var decoded Filter
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if err := decoded.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	for _, item := range []string{"a", "b", "c"} {
+		if !decoded.Test(item) {
+			t.Errorf("decoded Test(%q) = false", item)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+	}{
+		{name: "Nil", filter: nil},
+		{name: "No bits", filter: &Filter{Hashes: 3}},
+		{name: "No hashes", filter: &Filter{Bits: make([]byte, 8)}},
+		{name: "Too many hashes", filter: &Filter{Bits: make([]byte, 8), Hashes: MaxHashes + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); err != ErrInvalidFilter {
+				t.Errorf("Validate() error = %v, want %v", err, ErrInvalidFilter)
+			}
+		})
+	}
+}
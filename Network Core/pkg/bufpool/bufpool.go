@@ -0,0 +1,83 @@
+// Package bufpool shares byte buffers between the network code's stream
+// readers, so that transfers running side by side reuse a few buffers
+// instead of each allocating megabytes that the garbage collector then has
+// to reclaim.
+//
+// Buffers are pooled in power-of-two size classes from MinSize to MaxSize.
+// Get returns a buffer of the requested length from the smallest class
+// that fits it; larger requests are allocated and not pooled. A buffer
+// handed back with Put must not be used again, nor may anything aliasing
+// it outlive the call.
+package bufpool
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	// MinSize is the capacity of the smallest pooled buffers
+	MinSize = 4 * 1024
+	// MaxSize is the capacity of the largest pooled buffers
+	MaxSize = 16 * 1024 * 1024
+
+	minShift = 12 // log2(MinSize)
+	maxShift = 24 // log2(MaxSize)
+)
+
+// Pool is a set of size-classed buffer pools. The zero value is ready to
+// use.
+type Pool struct {
+	classes [maxShift - minShift + 1]sync.Pool
+}
+
+// Default is the pool shared across the network code
+var Default = &Pool{}
+
+// Get returns a buffer from the default pool
+func Get(size int) []byte {
+	return Default.Get(size)
+}
+
+// Put returns a buffer to the default pool
+func Put(buf []byte) {
+	Default.Put(buf)
+}
+
+// class returns the index of the smallest class holding size bytes, or -1
+// if size is larger than MaxSize
+func class(size int) int {
+	if size > MaxSize {
+		return -1
+	}
+	if size <= MinSize {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - minShift
+}
+
+// Get returns a buffer of length size. Its contents are undefined.
+func (p *Pool) Get(size int) []byte {
+	if size < 0 {
+		size = 0
+	}
+	c := class(size)
+	if c < 0 {
+		return make([]byte, size)
+	}
+	if v := p.classes[c].Get(); v != nil {
+		return (*v.(*[]byte))[:size]
+	}
+	return make([]byte, size, MinSize<<c)
+}
+
+// Put returns a buffer obtained from Get to the pool. Buffers of other
+// capacities are dropped.
+func (p *Pool) Put(buf []byte) {
+	c := class(cap(buf))
+	if c < 0 || cap(buf) != MinSize<<c {
+		return
+	}
+	buf = buf[:cap(buf)]
+	p.classes[c].Put(&buf)
+}
@@ -0,0 +1,55 @@
+package bufpool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSizeClasses(t *testing.T) {
+	p := &Pool{}
+	for _, tc := range []struct{ size, cap int }{
+		{0, MinSize},
+		{1, MinSize},
+		{MinSize, MinSize},
+		{MinSize + 1, 2 * MinSize},
+		{1024 * 1024, 1024 * 1024},
+		{1024*1024 + 1, 2 * 1024 * 1024},
+		{MaxSize, MaxSize},
+	} {
+		buf := p.Get(tc.size)
+		assert.Len(t, buf, tc.size)
+		assert.Equal(t, tc.cap, cap(buf), "size %d", tc.size)
+		p.Put(buf)
+	}
+
+	// Requests past the largest class are allocated as asked
+	buf := p.Get(MaxSize + 1)
+	assert.Len(t, buf, MaxSize+1)
+	p.Put(buf)
+}
+
+func TestPutReusesBuffers(t *testing.T) {
+	p := &Pool{}
+	buf := p.Get(1000)
+	p.Put(buf[:10])
+
+	// sync.Pool may drop items at any time, so only a reuse is checked
+	again := p.Get(2000)
+	assert.Len(t, again, 2000)
+	assert.Equal(t, MinSize, cap(again))
+
+	// Buffers not from the pool are dropped rather than misfiled
+	p.Put(make([]byte, 10, 5000))
+	assert.Equal(t, 2*MinSize, cap(p.Get(5000)))
+}
+
+func BenchmarkGetPut(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buf := Get(1024 * 1024)
+			Put(buf)
+		}
+	})
+}
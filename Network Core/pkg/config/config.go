@@ -0,0 +1,174 @@
+// Package config loads daemon settings from a YAML, TOML or JSON file,
+// overridden by environment variables, and reloads them while running.
+//
+// Settings are plain structs holding their defaults before they are
+// loaded. Keys in files and environment variables follow the json tags of
+// the fields, so structs already sent over the wire need nothing extra:
+//
+//	type Settings struct {
+//		Port    int                   `json:"port"`
+//		Storage network.StorageConfig `json:"storage"`
+//	}
+//
+// is set by "port: 6001" and "storage: {quota: 1073741824}" in a YAML file,
+// or by FILEZAP_PORT and FILEZAP_STORAGE_QUOTA with the prefix FILEZAP.
+// Untagged fields use their Go name. Durations are written as strings such
+// as "30s" and lists in environment variables are comma-separated.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config errors
+var (
+	ErrFormat     = errors.New("unsupported config format")
+	ErrUnknownKey = errors.New("unknown config key")
+	ErrValue      = errors.New("invalid config value")
+)
+
+// Validator is implemented by settings that check their own values.
+// Settings are only used once Validate accepts them.
+type Validator interface {
+	Validate() error
+}
+
+// Source locates a daemon's settings: an optional file and the environment
+// variables overriding it
+type Source struct {
+	Path      string // Config file, empty for none
+	EnvPrefix string // Prefix of overriding environment variables, empty for none
+}
+
+// Load fills in settings from the file and then the environment, and
+// validates them. settings must point to a struct holding the defaults.
+func (s Source) Load(settings interface{}) error {
+	if s.Path != "" {
+		if err := LoadFile(s.Path, settings); err != nil {
+			return err
+		}
+	}
+	if s.EnvPrefix != "" {
+		if err := ApplyEnv(s.EnvPrefix, settings); err != nil {
+			return err
+		}
+	}
+	if v, ok := settings.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrValue, err)
+		}
+	}
+	return nil
+}
+
+// LoadFile sets the keys found in a config file on settings, leaving the
+// other fields alone. The format follows the file extension: .yaml, .yml,
+// .toml or .json.
+func LoadFile(path string, settings interface{}) error {
+	target, err := structOf(settings)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	raw, err := parse(path, data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return decodeStruct(target, raw, "")
+}
+
+// parse decodes a config file into nested maps
+func parse(path string, data []byte) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrFormat, filepath.Ext(path))
+	}
+	return raw, nil
+}
+
+// structOf returns the struct settings points to
+func structOf(settings interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(settings)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("config: settings must be a pointer to a struct, got %T", settings)
+	}
+	return v.Elem(), nil
+}
+
+// keyOf returns the config key of a struct field, or "" if it has none
+func keyOf(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name := field.Name
+	if tag, ok := field.Tag.Lookup("json"); ok {
+		tag, _, _ = strings.Cut(tag, ",")
+		if tag == "-" {
+			return ""
+		}
+		if tag != "" {
+			name = tag
+		}
+	}
+	return name
+}
+
+// decodeStruct sets the fields of v named in raw. Keys match case
+// insensitively; unknown keys are an error so that typos are noticed.
+func decodeStruct(v reflect.Value, raw map[string]interface{}, path string) error {
+	fields := make(map[string]int)
+	for i := 0; i < v.NumField(); i++ {
+		if key := keyOf(v.Type().Field(i)); key != "" {
+			fields[strings.ToLower(key)] = i
+		}
+	}
+
+	for key, value := range raw {
+		name := joinKey(path, key)
+		i, ok := fields[strings.ToLower(key)]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownKey, name)
+		}
+		if err := assign(v.Field(i), value, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinKey returns the dotted name of a nested key, for errors
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testStorage struct {
+	Quota int64         `json:"quota"`
+	Retry time.Duration `json:"retry"`
+}
+
+type testSettings struct {
+	Name     string       `json:"name"`
+	Port     int          `json:"port"`
+	Verbose  bool         `json:"verbose"`
+	Ratio    float64      `json:"ratio"`
+	Peers    []string     `json:"peers"`
+	Storage  testStorage  `json:"storage"`
+	Limits   *testStorage `json:"limits"`
+	Internal string       `json:"-"`
+}
+
+func (s *testSettings) Validate() error {
+	if s.Port < 1 || s.Port > 65535 {
+		return errors.New("port out of range")
+	}
+	return nil
+}
+
+func defaultTestSettings() *testSettings {
+	return &testSettings{Name: "node", Port: 6001, Storage: testStorage{Quota: 1024}}
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadFormats(t *testing.T) {
+	files := map[string]string{
+		"node.yaml": `
+port: 7001
+verbose: true
+ratio: 0.5
+peers: [a, b]
+storage:
+  quota: 4096
+  retry: 30s
+limits:
+  quota: 10
+`,
+		"node.toml": `
+port = 7001
+verbose = true
+ratio = 0.5
+peers = ["a", "b"]
+
+[storage]
+quota = 4096
+retry = "30s"
+
+[limits]
+quota = 10
+`,
+		"node.json": `{
+	"port": 7001, "verbose": true, "ratio": 0.5, "peers": ["a", "b"],
+	"storage": {"quota": 4096, "retry": "30s"},
+	"limits": {"quota": 10}
+}`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			s := defaultTestSettings()
+			require.NoError(t, Source{Path: writeConfig(t, name, content)}.Load(s))
+
+			assert.Equal(t, "node", s.Name, "keys left out keep their default")
+			assert.Equal(t, 7001, s.Port)
+			assert.True(t, s.Verbose)
+			assert.Equal(t, 0.5, s.Ratio)
+			assert.Equal(t, []string{"a", "b"}, s.Peers)
+			assert.Equal(t, int64(4096), s.Storage.Quota)
+			assert.Equal(t, 30*time.Second, s.Storage.Retry)
+			require.NotNil(t, s.Limits)
+			assert.Equal(t, int64(10), s.Limits.Quota)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		file    string
+		content string
+		err     error
+	}{
+		"unknown key":     {"node.yaml", "prot: 7001\n", ErrUnknownKey},
+		"nested unknown":  {"node.yaml", "storage:\n  qouta: 1\n", ErrUnknownKey},
+		"ignored field":   {"node.yaml", "internal: x\n", ErrUnknownKey},
+		"wrong type":      {"node.yaml", "port: many\n", ErrValue},
+		"overflow":        {"node.yaml", "port: 99999999999999999999\n", ErrValue},
+		"bare duration":   {"node.yaml", "storage:\n  retry: 30\n", ErrValue},
+		"invalid setting": {"node.yaml", "port: 70000\n", ErrValue},
+		"format":          {"node.ini", "port=1\n", ErrFormat},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := Source{Path: writeConfig(t, tc.file, tc.content)}.Load(defaultTestSettings())
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	assert.Error(t, LoadFile(filepath.Join(t.TempDir(), "missing.yaml"), defaultTestSettings()))
+	assert.Error(t, LoadFile(writeConfig(t, "node.yaml", "port: 1\n"), testSettings{}), "settings must be a pointer")
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, "node.yaml", "port: 7001\nname: file\n")
+	t.Setenv("FILEZAP_PORT", "8001")
+	t.Setenv("FILEZAP_PEERS", "a, b,")
+	t.Setenv("FILEZAP_STORAGE_RETRY", "1m")
+	t.Setenv("FILEZAP_LIMITS_QUOTA", "5") // Skipped, limits is nil
+
+	s := defaultTestSettings()
+	require.NoError(t, Source{Path: path, EnvPrefix: "filezap"}.Load(s))
+	assert.Equal(t, "file", s.Name)
+	assert.Equal(t, 8001, s.Port)
+	assert.Equal(t, []string{"a", "b"}, s.Peers)
+	assert.Equal(t, time.Minute, s.Storage.Retry)
+	assert.Nil(t, s.Limits)
+
+	t.Setenv("FILEZAP_VERBOSE", "maybe")
+	assert.ErrorIs(t, Source{EnvPrefix: "FILEZAP"}.Load(defaultTestSettings()), ErrValue)
+}
+
+func TestReloader(t *testing.T) {
+	path := writeConfig(t, "node.yaml", "port: 7001\n")
+	var applied *testSettings
+	r := NewReloader(Source{Path: path},
+		func() interface{} { return defaultTestSettings() },
+		func(settings interface{}) error {
+			applied = settings.(*testSettings)
+			return nil
+		})
+
+	require.NoError(t, r.Reload())
+	assert.Equal(t, 7001, applied.Port)
+
+	// Keys removed from the file fall back to their defaults
+	require.NoError(t, os.WriteFile(path, []byte("name: renamed\n"), 0600))
+	require.NoError(t, r.Reload())
+	assert.Equal(t, 6001, applied.Port)
+	assert.Equal(t, "renamed", applied.Name)
+
+	// Invalid settings are not applied
+	require.NoError(t, os.WriteFile(path, []byte("port: 0\n"), 0600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, "renamed", applied.Name)
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ApplyEnv sets fields from the environment. A field's variable is the
+// prefix followed by the keys of its enclosing structs and its own key,
+// upper-cased and joined by underscores, e.g. FILEZAP_STORAGE_QUOTA. Nil
+// pointers to structs are skipped.
+func ApplyEnv(prefix string, settings interface{}) error {
+	target, err := structOf(settings)
+	if err != nil {
+		return err
+	}
+	return applyEnv(target, strings.ToUpper(prefix))
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	for i := 0; i < v.NumField(); i++ {
+		key := keyOf(v.Type().Field(i))
+		if key == "" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		f := v.Field(i)
+
+		if f.Type() != durationType {
+			nested := f
+			if nested.Kind() == reflect.Pointer && nested.Type().Elem().Kind() == reflect.Struct {
+				if nested.IsNil() {
+					continue
+				}
+				nested = nested.Elem()
+			}
+			if nested.Kind() == reflect.Struct {
+				if err := applyEnv(nested, name); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if value, ok := os.LookupEnv(name); ok {
+			if err := assign(f, value, name); err != nil {
+				return fmt.Errorf("failed to apply environment: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Reloader reloads settings while a daemon runs, on SIGHUP or when asked
+// to, e.g. from a UI. Each reload starts from fresh defaults and hands the
+// settings to apply only once they are valid, so a bad edit leaves the
+// running settings alone.
+type Reloader struct {
+	source   Source
+	defaults func() interface{}
+	apply    func(settings interface{}) error
+	mu       sync.Mutex
+}
+
+// NewReloader creates a reloader. defaults returns a pointer to a new
+// struct of default settings; apply puts loaded settings into effect.
+func NewReloader(source Source, defaults func() interface{}, apply func(settings interface{}) error) *Reloader {
+	return &Reloader{
+		source:   source,
+		defaults: defaults,
+		apply:    apply,
+	}
+}
+
+// Reload loads the settings again and applies them
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	settings := r.defaults()
+	if err := r.source.Load(settings); err != nil {
+		return err
+	}
+	return r.apply(settings)
+}
+
+// Watch reloads the settings each time the process receives SIGHUP until
+// ctx is done. Failed reloads are logged. There is no SIGHUP on Windows,
+// where settings are only reloaded through Reload.
+func (r *Reloader) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			if err := r.Reload(); err != nil {
+				log.Printf("Failed to reload config: %v", err)
+				continue
+			}
+			log.Printf("Reloaded config")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
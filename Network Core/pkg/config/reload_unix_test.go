@@ -0,0 +1,44 @@
+//go:build !windows
+
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloaderWatchesSIGHUP(t *testing.T) {
+	path := writeConfig(t, "node.yaml", "port: 7001\n")
+	reloaded := make(chan int, 1)
+	r := NewReloader(Source{Path: path},
+		func() interface{} { return defaultTestSettings() },
+		func(settings interface{}) error {
+			reloaded <- settings.(*testSettings).Port
+			return nil
+		})
+
+	// A SIGHUP sent before the watcher listens must not end the test
+	signal.Ignore(syscall.SIGHUP)
+	defer signal.Reset(syscall.SIGHUP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx)
+
+	// Signal until the watcher is listening
+	require.Eventually(t, func() bool {
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+		select {
+		case port := <-reloaded:
+			return port == 7001
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 2*time.Second, 10*time.Millisecond)
+}
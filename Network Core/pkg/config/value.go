@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// assign sets a field from a value decoded from a file or, as a string,
+// from the environment
+func assign(f reflect.Value, value interface{}, name string) error {
+	if f.Type() == durationType {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%w: %s must be a duration such as \"30s\"", ErrValue, name)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrValue, name, err)
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.Pointer:
+		if f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		return assign(f.Elem(), value, name)
+
+	case reflect.Struct:
+		raw, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: %s must be a table", ErrValue, name)
+		}
+		return decodeStruct(f, raw, name)
+
+	case reflect.Slice:
+		var items []interface{}
+		switch value := value.(type) {
+		case []interface{}:
+			items = value
+		case string:
+			if f.Type().Elem().Kind() == reflect.Uint8 {
+				f.SetBytes([]byte(value))
+				return nil
+			}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		default:
+			return fmt.Errorf("%w: %s must be a list", ErrValue, name)
+		}
+		slice := reflect.MakeSlice(f.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := assign(slice.Index(i), item, fmt.Sprintf("%s[%d]", name, i)); err != nil {
+				return err
+			}
+		}
+		f.Set(slice)
+		return nil
+
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%w: %s must be a string", ErrValue, name)
+		}
+		f.SetString(s)
+		return nil
+
+	case reflect.Bool:
+		switch value := value.(type) {
+		case bool:
+			f.SetBool(value)
+			return nil
+		case string:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w: %s must be true or false", ErrValue, name)
+			}
+			f.SetBool(b)
+			return nil
+		}
+		return fmt.Errorf("%w: %s must be true or false", ErrValue, name)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(numberText(value), 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%w: %s must be a whole number", ErrValue, name)
+		}
+		f.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(numberText(value), 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%w: %s must be a whole number of at least 0", ErrValue, name)
+		}
+		f.SetUint(n)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(numberText(value), f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%w: %s must be a number", ErrValue, name)
+		}
+		f.SetFloat(n)
+		return nil
+	}
+	return fmt.Errorf("%w: %s has unsupported type %s", ErrValue, name, f.Type())
+}
+
+// numberText returns a decoded number as text, or "" if value is not one.
+// Files decode numbers to different types and the environment gives text.
+func numberText(value interface{}) string {
+	switch value := value.(type) {
+	case int, int64, uint64, float64:
+		return fmt.Sprint(value)
+	case json.Number:
+		return value.String()
+	case string:
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
@@ -0,0 +1,5 @@
+// Package e2e holds end-to-end tests that run whole FileZap networks
+// in-process with testtools. They take a while and are skipped with -short:
+//
+//	go test ./pkg/e2e
+package e2e
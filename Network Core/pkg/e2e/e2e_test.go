@@ -0,0 +1,174 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/testtools"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/testtools/chaos"
+)
+
+const (
+	chunkSize = 16 * 1024
+	goal      = 3
+)
+
+func randomFile(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	return data
+}
+
+// TestFileSurvivesChurn splits and uploads a file, waits for it to
+// replicate, takes the uploader and some storage nodes down and has a node
+// that joined afterwards download and reconstruct it
+func TestFileSurvivesChurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end-to-end test")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// Node 0 uploads, nodes 1-5 store
+	net := testtools.NewNetwork(t, testtools.Options{
+		Nodes:        6,
+		StorageNodes: []int{1, 2, 3, 4, 5},
+	})
+	uploader := net.Nodes[0]
+	net.WaitForStorageNodes(uploader, 5)
+
+	data := randomFile(t, 5*chunkSize+123)
+	manifest, result, err := uploader.Upload(ctx, "churn.zap", data, chunkSize, goal)
+	require.NoError(t, err)
+	require.Len(t, manifest.ChunkHashes, 6)
+	assert.Empty(t, result.Shortfall)
+
+	// Storage nodes store what they acknowledged on their next tick
+	net.Eventually(func() bool {
+		net.Advance(testtools.StorageInterval)
+		for hash, holders := range result.Placements {
+			for _, id := range holders {
+				for _, node := range net.Nodes {
+					if node.ID() == id && !node.Holds(hash) {
+						return false
+					}
+				}
+			}
+		}
+		return true
+	}, "chunks were not stored by the nodes that acknowledged them")
+
+	// Losing the uploader and fewer storage nodes than the goal leaves a
+	// replica of every chunk
+	net.Stop(0)
+	net.Stop(1)
+	net.Stop(2)
+	newcomer := net.AddNode()
+
+	var downloaded []byte
+	net.Eventually(func() bool {
+		downloaded, err = newcomer.Download(ctx, "churn.zap")
+		return err == nil
+	}, "download failed after churn: %v", err)
+	assert.True(t, bytes.Equal(data, downloaded), "reconstructed file differs from the upload")
+}
+
+// TestReplicasOnDistinctNodes checks that every chunk reaches the
+// replication goal on distinct storage nodes and never on the uploader
+func TestReplicasOnDistinctNodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end-to-end test")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	net := testtools.NewNetwork(t, testtools.Options{
+		Nodes:        4,
+		StorageNodes: []int{1, 2, 3},
+		Topology:     testtools.Star,
+	})
+	uploader := net.Nodes[0]
+	net.WaitForStorageNodes(uploader, 3)
+
+	_, result, err := uploader.Upload(ctx, "star.zap", randomFile(t, 3*chunkSize), chunkSize, goal)
+	require.NoError(t, err)
+	for hash, holders := range result.Placements {
+		seen := make(map[string]bool)
+		for _, id := range holders {
+			assert.NotEqual(t, uploader.ID(), id, "chunk %s placed on the uploader", hash)
+			assert.False(t, seen[id.String()], "chunk %s placed twice on %s", hash, id)
+			seen[id.String()] = true
+		}
+		assert.Len(t, holders, goal, "chunk %s", hash)
+	}
+}
+
+// TestDownloadAcrossPartition cuts a node off from the storage nodes on a
+// slow network, checks that it cannot download while partitioned and that
+// it can once the partition heals
+func TestDownloadAcrossPartition(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end-to-end test")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// Node 0 uploads, nodes 1-3 store and node 4 downloads
+	net := testtools.NewNetwork(t, testtools.Options{
+		Nodes:        5,
+		StorageNodes: []int{1, 2, 3},
+		Faults:       chaos.Faults{Latency: 2 * time.Millisecond, Jitter: 3 * time.Millisecond, Seed: 1},
+	})
+	uploader, downloader := net.Nodes[0], net.Nodes[4]
+	net.WaitForStorageNodes(uploader, 3)
+
+	data := randomFile(t, 3*chunkSize)
+	manifest, result, err := uploader.Upload(ctx, "partition.zap", data, chunkSize, goal)
+	require.NoError(t, err)
+	net.Eventually(func() bool {
+		net.Advance(testtools.StorageInterval)
+		for hash, holders := range result.Placements {
+			for _, node := range net.Nodes {
+				for _, id := range holders {
+					if node.ID() == id && !node.Holds(hash) {
+						return false
+					}
+				}
+			}
+		}
+		return true
+	}, "chunks were not stored by the nodes that acknowledged them")
+	net.Eventually(func() bool {
+		_, err := downloader.Manifests.GetManifest(manifest.Name)
+		return err == nil
+	}, "downloader never learned the manifest")
+
+	// Scheduled partitions split and heal in the background once the clock
+	// passes their time
+	partitioned := func() bool {
+		return !net.Partitions.Reachable(downloader.ID(), uploader.ID())
+	}
+	net.SchedulePartition(time.Minute, time.Hour, []int{4}, []int{0, 1, 2, 3})
+	net.Advance(time.Minute)
+	net.Eventually(partitioned, "partition did not start")
+	_, err = downloader.Download(ctx, "partition.zap")
+	assert.Error(t, err, "download succeeded across a partition")
+
+	net.Advance(time.Hour)
+	net.Eventually(func() bool { return !partitioned() }, "partition did not heal")
+	net.Reconnect()
+	var downloaded []byte
+	net.Eventually(func() bool {
+		downloaded, err = downloader.Download(ctx, "partition.zap")
+		return err == nil
+	}, "download failed after the partition healed: %v", err)
+	assert.True(t, bytes.Equal(data, downloaded), "reconstructed file differs from the upload")
+}
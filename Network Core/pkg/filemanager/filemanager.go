@@ -6,6 +6,7 @@ import (
     "os"
     "path/filepath"
     "runtime"
+    "sort"
     "sync"
 )
 
@@ -16,10 +17,15 @@ var (
     ErrInvalidAccess = errors.New("directory access denied")
 )
 
-// ChunkManager handles storage and retrieval of file chunks
+// ChunkManager handles storage and retrieval of file chunks. Chunks are kept
+// in hash-prefixed subdirectories of baseDir with an index of their metadata.
 type ChunkManager struct {
     baseDir    string
     quotaSize  int64
+    index      map[string]*ChunkMeta
+    indexDirty bool
+    tier       TierPolicy
+    idxMu      sync.Mutex
     mu         sync.RWMutex
 }
 
@@ -87,49 +93,49 @@ func (cm *ChunkManager) StoreChunk(chunkID string, data []byte) error {
         return err
     }
 
-    // Check quota
+    // Check quota, not counting a copy of this chunk being replaced
     usage, err := cm.getDiskUsageNoLock()
     if err != nil {
         return fmt.Errorf("failed to check disk usage: %v", err)
     }
+    existing, replacing := cm.ChunkInfo(chunkID)
+    if replacing {
+        usage -= existing.Size
+    }
 
     if usage+int64(len(data)) > cm.quotaSize {
         return fmt.Errorf("quota exceeded: would exceed %d bytes", cm.quotaSize)
     }
 
     // Store chunk
-    chunkPath := filepath.Join(cm.baseDir, chunkID)
-    if err := os.WriteFile(chunkPath, data, 0644); err != nil {
+    chunkPath := cm.shardPath(chunkID)
+    if err := os.MkdirAll(filepath.Dir(chunkPath), 0755); err != nil {
+        return ErrInvalidAccess
+    }
+    if err := writeFileAtomic(chunkPath, data); err != nil {
         return ErrInvalidAccess
     }
 
+    if err := cm.recordStore(chunkID, data); err != nil {
+        return err
+    }
+    if replacing && existing.Offloaded && cm.tier.Backend != nil {
+        if err := cm.tier.Backend.Delete(chunkID); err != nil {
+            fmt.Printf("failed to remove replaced chunk %s from cold storage: %v\n", chunkID, err)
+        }
+    }
     return nil
 }
 
 // getDiskUsageNoLock returns the total size of all stored chunks without locking
 func (cm *ChunkManager) getDiskUsageNoLock() (int64, error) {
-    entries, err := os.ReadDir(cm.baseDir)
-    if err != nil {
+    if err := cm.loadIndex(); err != nil {
         if runtime.GOOS == "windows" {
             return 0, ErrInvalidAccess
         }
         return 0, err
     }
-
-    var total int64
-    for _, entry := range entries {
-        if !entry.IsDir() {
-            info, err := entry.Info()
-            if err != nil {
-                if runtime.GOOS == "windows" {
-                    return 0, ErrInvalidAccess
-                }
-                return 0, err
-            }
-            total += info.Size()
-        }
-    }
-    return total, nil
+    return cm.usage(), nil
 }
 
 // GetChunk retrieves a chunk by its ID
@@ -145,8 +151,20 @@ func (cm *ChunkManager) GetChunk(chunkID string) ([]byte, error) {
         return nil, err
     }
 
-    chunkPath := filepath.Join(cm.baseDir, chunkID)
-    data, err := os.ReadFile(chunkPath)
+    if err := cm.loadIndex(); err != nil {
+        return nil, err
+    }
+
+    if meta, ok := cm.ChunkInfo(chunkID); ok && meta.Tier == TierCold {
+        data, err := cm.rehydrate(chunkID, meta)
+        if err != nil {
+            return nil, err
+        }
+        cm.recordAccess(chunkID)
+        return data, nil
+    }
+
+    data, err := os.ReadFile(cm.shardPath(chunkID))
     if err != nil {
         if os.IsNotExist(err) {
             return nil, fmt.Errorf("chunk %s not found", chunkID)
@@ -154,6 +172,11 @@ func (cm *ChunkManager) GetChunk(chunkID string) ([]byte, error) {
         return nil, ErrInvalidAccess
     }
 
+    if err := cm.verifyChunk(chunkID, data); err != nil {
+        return nil, err
+    }
+
+    cm.recordAccess(chunkID)
     return data, nil
 }
 
@@ -170,15 +193,25 @@ func (cm *ChunkManager) DeleteChunk(chunkID string) error {
         return err
     }
 
-    chunkPath := filepath.Join(cm.baseDir, chunkID)
-    if err := os.Remove(chunkPath); err != nil {
+    if err := cm.loadIndex(); err != nil {
+        return err
+    }
+
+    if meta, ok := cm.ChunkInfo(chunkID); ok && meta.Tier == TierCold {
+        if err := cm.removeCold(chunkID, meta); err != nil {
+            return fmt.Errorf("failed to remove cold chunk %s: %v", chunkID, err)
+        }
+        return cm.recordDelete(chunkID)
+    }
+
+    if err := os.Remove(cm.shardPath(chunkID)); err != nil {
         if os.IsNotExist(err) {
             return fmt.Errorf("chunk %s not found", chunkID)
         }
         return ErrInvalidAccess
     }
 
-    return nil
+    return cm.recordDelete(chunkID)
 }
 
 // ListChunks returns a list of all stored chunk IDs
@@ -194,17 +227,18 @@ func (cm *ChunkManager) ListChunks() ([]string, error) {
         return nil, err
     }
 
-    entries, err := os.ReadDir(cm.baseDir)
-    if err != nil {
-        return nil, ErrInvalidAccess
+    if err := cm.loadIndex(); err != nil {
+        return nil, err
     }
 
-    chunks := make([]string, 0, len(entries))
-    for _, entry := range entries {
-        if !entry.IsDir() {
-            chunks = append(chunks, entry.Name())
-        }
+    cm.idxMu.Lock()
+    chunks := make([]string, 0, len(cm.index))
+    for chunkID := range cm.index {
+        chunks = append(chunks, chunkID)
     }
+    cm.idxMu.Unlock()
+
+    sort.Strings(chunks)
     return chunks, nil
 }
 
@@ -0,0 +1,288 @@
+package filemanager
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const (
+    // indexFile holds chunk metadata. It is hidden so it is never mistaken
+    // for a chunk.
+    indexFile = ".index.json"
+    // quarantineDir receives chunks that fail their checksum
+    quarantineDir = ".quarantine"
+)
+
+// ErrChunkCorrupt is returned when a stored chunk no longer matches its
+// checksum
+var ErrChunkCorrupt = errors.New("chunk is corrupt")
+
+// ChunkMeta records what is known about a stored chunk
+type ChunkMeta struct {
+    Size       int64     `json:"size"`
+    Checksum   string    `json:"checksum"` // Hex SHA-256 of the chunk data
+    Served     uint64    `json:"served"`
+    LastAccess time.Time `json:"last_access"`
+
+    // Where a cold chunk is kept, see TierPolicy
+    Tier       string `json:"tier,omitempty"`       // TierCold, or empty for hot chunks
+    Compressed bool   `json:"compressed,omitempty"` // Stored gzip compressed
+    Offloaded  bool   `json:"offloaded,omitempty"`  // Stored in the cold backend
+}
+
+// checksum returns the hex SHA-256 of data
+func checksum(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes data to path so that readers see either the old
+// file or the complete new one. The data is written to a temporary file in
+// the same directory, synced and renamed into place.
+func writeFileAtomic(path string, data []byte) error {
+    dir := filepath.Dir(path)
+    tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if err := tmp.Chmod(0644); err != nil {
+        tmp.Close()
+        return err
+    }
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp.Name(), path); err != nil {
+        return err
+    }
+
+    // Persist the rename itself
+    if d, err := os.Open(dir); err == nil {
+        d.Sync()
+        d.Close()
+    }
+    return nil
+}
+
+// shardPath returns where a chunk is stored. Chunks are spread over two
+// levels of subdirectories named after the leading bytes of the SHA-256 of
+// the chunk ID, so no single directory grows past a few thousand entries.
+func (cm *ChunkManager) shardPath(chunkID string) string {
+    return shardedPath(cm.baseDir, chunkID)
+}
+
+// shardedPath returns where a chunk is stored in the sharded layout under dir
+func shardedPath(dir, chunkID string) string {
+    sum := sha256.Sum256([]byte(chunkID))
+    prefix := hex.EncodeToString(sum[:2])
+    return filepath.Join(dir, prefix[:2], prefix[2:], chunkID)
+}
+
+// loadIndex loads the chunk index on first use. Stores written before the
+// index existed are migrated: flat chunk files are moved into their shard
+// directories and any chunks found without an index entry are added.
+func (cm *ChunkManager) loadIndex() error {
+    cm.idxMu.Lock()
+    defer cm.idxMu.Unlock()
+
+    if cm.index != nil {
+        return nil
+    }
+
+    index := make(map[string]*ChunkMeta)
+    data, err := os.ReadFile(filepath.Join(cm.baseDir, indexFile))
+    switch {
+    case err == nil:
+        if err := json.Unmarshal(data, &index); err != nil {
+            return fmt.Errorf("failed to parse chunk index: %v", err)
+        }
+    case !os.IsNotExist(err):
+        return ErrInvalidAccess
+    }
+
+    migrated, err := cm.migrateFlat(index)
+    if err != nil {
+        return err
+    }
+    cm.index = index
+    cm.indexDirty = migrated
+    if data == nil || migrated {
+        return cm.saveIndexLocked()
+    }
+    return nil
+}
+
+// migrateFlat moves chunks stored directly in baseDir into the sharded
+// layout and records them in index. It reports whether anything changed.
+func (cm *ChunkManager) migrateFlat(index map[string]*ChunkMeta) (bool, error) {
+    entries, err := os.ReadDir(cm.baseDir)
+    if err != nil {
+        return false, ErrInvalidAccess
+    }
+
+    changed := false
+    for _, entry := range entries {
+        if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+            continue
+        }
+        chunkID := entry.Name()
+        data, err := os.ReadFile(filepath.Join(cm.baseDir, chunkID))
+        if err != nil {
+            return changed, ErrInvalidAccess
+        }
+        info, err := entry.Info()
+        if err != nil {
+            return changed, ErrInvalidAccess
+        }
+
+        dest := cm.shardPath(chunkID)
+        if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+            return changed, ErrInvalidAccess
+        }
+        if err := os.Rename(filepath.Join(cm.baseDir, chunkID), dest); err != nil {
+            return changed, fmt.Errorf("failed to migrate chunk %s: %v", chunkID, err)
+        }
+        if _, ok := index[chunkID]; !ok {
+            index[chunkID] = &ChunkMeta{
+                Size:       int64(len(data)),
+                Checksum:   checksum(data),
+                LastAccess: info.ModTime(),
+            }
+        }
+        changed = true
+    }
+    return changed, nil
+}
+
+// saveIndexLocked writes the index to disk. Callers must hold cm.idxMu.
+func (cm *ChunkManager) saveIndexLocked() error {
+    data, err := json.Marshal(cm.index)
+    if err != nil {
+        return fmt.Errorf("failed to encode chunk index: %v", err)
+    }
+
+    if err := writeFileAtomic(filepath.Join(cm.baseDir, indexFile), data); err != nil {
+        return ErrInvalidAccess
+    }
+    cm.indexDirty = false
+    return nil
+}
+
+// recordStore adds or replaces a chunk's index entry and saves the index
+func (cm *ChunkManager) recordStore(chunkID string, data []byte) error {
+    cm.idxMu.Lock()
+    defer cm.idxMu.Unlock()
+
+    meta, ok := cm.index[chunkID]
+    if !ok {
+        meta = &ChunkMeta{}
+        cm.index[chunkID] = meta
+    }
+    meta.Size = int64(len(data))
+    meta.Checksum = checksum(data)
+    meta.LastAccess = time.Now()
+    meta.Tier = ""
+    meta.Compressed = false
+    meta.Offloaded = false
+    return cm.saveIndexLocked()
+}
+
+// recordDelete removes a chunk's index entry and saves the index
+func (cm *ChunkManager) recordDelete(chunkID string) error {
+    cm.idxMu.Lock()
+    defer cm.idxMu.Unlock()
+
+    delete(cm.index, chunkID)
+    return cm.saveIndexLocked()
+}
+
+// verifyChunk checks data read for a chunk against its indexed checksum.
+// Chunks that fail are moved to the quarantine directory and dropped from the
+// index so they are never served again.
+func (cm *ChunkManager) verifyChunk(chunkID string, data []byte) error {
+    cm.idxMu.Lock()
+    defer cm.idxMu.Unlock()
+
+    meta, ok := cm.index[chunkID]
+    if !ok || meta.Checksum == "" || meta.Checksum == checksum(data) {
+        return nil
+    }
+
+    quarantine := filepath.Join(cm.baseDir, quarantineDir)
+    if err := os.MkdirAll(quarantine, 0755); err == nil {
+        os.Rename(cm.shardPath(chunkID), filepath.Join(quarantine, chunkID))
+    } else {
+        os.Remove(cm.shardPath(chunkID))
+    }
+    delete(cm.index, chunkID)
+    cm.saveIndexLocked()
+    return fmt.Errorf("%w: %s", ErrChunkCorrupt, chunkID)
+}
+
+// recordAccess counts a chunk being served. Access statistics are kept in
+// memory and written out with the next index save or Flush.
+func (cm *ChunkManager) recordAccess(chunkID string) {
+    cm.idxMu.Lock()
+    defer cm.idxMu.Unlock()
+
+    if meta, ok := cm.index[chunkID]; ok {
+        meta.Served++
+        meta.LastAccess = time.Now()
+        cm.indexDirty = true
+    }
+}
+
+// usage returns the total size of indexed chunks
+func (cm *ChunkManager) usage() int64 {
+    cm.idxMu.Lock()
+    defer cm.idxMu.Unlock()
+
+    var total int64
+    for _, meta := range cm.index {
+        total += meta.Size
+    }
+    return total
+}
+
+// ChunkInfo returns the index entry for a chunk
+func (cm *ChunkManager) ChunkInfo(chunkID string) (ChunkMeta, bool) {
+    if err := cm.loadIndex(); err != nil {
+        return ChunkMeta{}, false
+    }
+
+    cm.idxMu.Lock()
+    defer cm.idxMu.Unlock()
+    meta, ok := cm.index[chunkID]
+    if !ok {
+        return ChunkMeta{}, false
+    }
+    return *meta, true
+}
+
+// Flush writes pending access statistics to the index file
+func (cm *ChunkManager) Flush() error {
+    cm.idxMu.Lock()
+    defer cm.idxMu.Unlock()
+
+    if cm.index == nil || !cm.indexDirty {
+        return nil
+    }
+    return cm.saveIndexLocked()
+}
@@ -0,0 +1,130 @@
+package filemanager
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestShardedLayout(t *testing.T) {
+    tempDir := t.TempDir()
+
+    fm := NewChunkManager(tempDir)
+    if err := fm.StoreChunk("sharded-chunk", []byte("sharded data")); err != nil {
+        t.Fatalf("StoreChunk() error = %v", err)
+    }
+
+    // The chunk lives two directories below the base directory
+    if _, err := os.Stat(filepath.Join(tempDir, "sharded-chunk")); !os.IsNotExist(err) {
+        t.Error("chunk should not be stored in the base directory")
+    }
+    if _, err := os.Stat(fm.shardPath("sharded-chunk")); err != nil {
+        t.Errorf("chunk missing from shard directory: %v", err)
+    }
+    rel, _ := filepath.Rel(tempDir, fm.shardPath("sharded-chunk"))
+    if strings.Count(filepath.ToSlash(rel), "/") != 2 {
+        t.Errorf("chunk path %s is not two levels deep", rel)
+    }
+
+    // Serving a chunk updates its metadata
+    if _, err := fm.GetChunk("sharded-chunk"); err != nil {
+        t.Fatalf("GetChunk() error = %v", err)
+    }
+    meta, ok := fm.ChunkInfo("sharded-chunk")
+    if !ok {
+        t.Fatal("ChunkInfo() missing entry")
+    }
+    if meta.Size != int64(len("sharded data")) || meta.Served != 1 {
+        t.Errorf("ChunkInfo() = %+v, want size %d served 1", meta, len("sharded data"))
+    }
+
+    // The index survives a restart
+    if err := fm.Flush(); err != nil {
+        t.Fatalf("Flush() error = %v", err)
+    }
+    reopened := NewChunkManager(tempDir)
+    meta, ok = reopened.ChunkInfo("sharded-chunk")
+    if !ok || meta.Served != 1 {
+        t.Errorf("reopened ChunkInfo() = %+v, %v", meta, ok)
+    }
+
+    if err := reopened.DeleteChunk("sharded-chunk"); err != nil {
+        t.Fatalf("DeleteChunk() error = %v", err)
+    }
+    if _, ok := reopened.ChunkInfo("sharded-chunk"); ok {
+        t.Error("deleted chunk still indexed")
+    }
+}
+
+func TestFlatStoreMigration(t *testing.T) {
+    tempDir := t.TempDir()
+
+    // A store written by the flat layout
+    for _, id := range []string{"old-chunk-1", "old-chunk-2"} {
+        if err := os.WriteFile(filepath.Join(tempDir, id), []byte(id), 0644); err != nil {
+            t.Fatalf("failed to write flat chunk: %v", err)
+        }
+    }
+
+    fm := NewChunkManager(tempDir)
+    chunks, err := fm.ListChunks()
+    if err != nil {
+        t.Fatalf("ListChunks() error = %v", err)
+    }
+    if len(chunks) != 2 || chunks[0] != "old-chunk-1" || chunks[1] != "old-chunk-2" {
+        t.Errorf("ListChunks() = %v", chunks)
+    }
+
+    for _, id := range chunks {
+        data, err := fm.GetChunk(id)
+        if err != nil || string(data) != id {
+            t.Errorf("GetChunk(%s) = %q, %v", id, data, err)
+        }
+        if _, err := os.Stat(filepath.Join(tempDir, id)); !os.IsNotExist(err) {
+            t.Errorf("chunk %s was not moved out of the base directory", id)
+        }
+    }
+
+    usage, err := fm.GetDiskUsage()
+    if err != nil {
+        t.Fatalf("GetDiskUsage() error = %v", err)
+    }
+    if usage != int64(len("old-chunk-1")+len("old-chunk-2")) {
+        t.Errorf("GetDiskUsage() = %d", usage)
+    }
+}
+
+func TestCorruptChunkQuarantine(t *testing.T) {
+    tempDir := t.TempDir()
+
+    fm := NewChunkManager(tempDir)
+    if err := fm.StoreChunk("fragile-chunk", []byte("original data")); err != nil {
+        t.Fatalf("StoreChunk() error = %v", err)
+    }
+
+    // Simulate a torn write
+    if err := os.WriteFile(fm.shardPath("fragile-chunk"), []byte("orig"), 0644); err != nil {
+        t.Fatalf("failed to corrupt chunk: %v", err)
+    }
+
+    if _, err := fm.GetChunk("fragile-chunk"); !errors.Is(err, ErrChunkCorrupt) {
+        t.Fatalf("GetChunk() error = %v, want %v", err, ErrChunkCorrupt)
+    }
+    if _, err := os.Stat(filepath.Join(tempDir, quarantineDir, "fragile-chunk")); err != nil {
+        t.Errorf("corrupt chunk was not quarantined: %v", err)
+    }
+    if _, ok := fm.ChunkInfo("fragile-chunk"); ok {
+        t.Error("corrupt chunk still indexed")
+    }
+    if _, err := fm.GetChunk("fragile-chunk"); err == nil {
+        t.Error("corrupt chunk served after quarantine")
+    }
+
+    // No temporary files are left behind by successful writes
+    matches, _ := filepath.Glob(filepath.Join(filepath.Dir(fm.shardPath("fragile-chunk")), ".*.tmp-*"))
+    if len(matches) != 0 {
+        t.Errorf("temporary files left behind: %v", matches)
+    }
+}
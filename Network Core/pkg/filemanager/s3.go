@@ -0,0 +1,184 @@
+package filemanager
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// The S3 backend speaks the plain object API, signed with AWS Signature
+// Version 4, so it works with AWS and S3-compatible stores such as MinIO.
+// Objects are addressed path-style, as those stores expect.
+
+// s3Timeout bounds each request to the object store
+const s3Timeout = 30 * time.Second
+
+// S3Config locates a bucket holding cold chunks
+type S3Config struct {
+    Endpoint  string `json:"endpoint"`   // e.g. "https://s3.eu-west-1.amazonaws.com" or "http://minio:9000"
+    Region    string `json:"region"`     // Defaults to us-east-1
+    Bucket    string `json:"bucket"`
+    Prefix    string `json:"prefix"`     // Prepended to chunk IDs to form object keys
+    AccessKey string `json:"access_key"`
+    SecretKey string `json:"secret_key"`
+}
+
+// S3Backend keeps cold chunks in an S3-compatible bucket
+type S3Backend struct {
+    cfg    S3Config
+    client *http.Client
+}
+
+// NewS3Backend creates a cold backend storing chunks in a bucket
+func NewS3Backend(cfg S3Config) (*S3Backend, error) {
+    if cfg.Endpoint == "" || cfg.Bucket == "" {
+        return nil, fmt.Errorf("S3 endpoint and bucket are required")
+    }
+    if cfg.AccessKey == "" || cfg.SecretKey == "" {
+        return nil, fmt.Errorf("S3 credentials are required")
+    }
+    if cfg.Region == "" {
+        cfg.Region = "us-east-1"
+    }
+    cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+    return &S3Backend{
+        cfg:    cfg,
+        client: &http.Client{Timeout: s3Timeout},
+    }, nil
+}
+
+// Put stores a chunk
+func (b *S3Backend) Put(chunkID string, data []byte) error {
+    resp, err := b.do(http.MethodPut, chunkID, data)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return b.statusError(resp, chunkID)
+    }
+    return nil
+}
+
+// Get reads a chunk
+func (b *S3Backend) Get(chunkID string) ([]byte, error) {
+    resp, err := b.do(http.MethodGet, chunkID, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    switch resp.StatusCode {
+    case http.StatusOK:
+        return io.ReadAll(resp.Body)
+    case http.StatusNotFound:
+        return nil, fmt.Errorf("%w: object %s", os.ErrNotExist, b.key(chunkID))
+    default:
+        return nil, b.statusError(resp, chunkID)
+    }
+}
+
+// Delete removes a chunk
+func (b *S3Backend) Delete(chunkID string) error {
+    resp, err := b.do(http.MethodDelete, chunkID, nil)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    switch resp.StatusCode {
+    case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+        return nil
+    default:
+        return b.statusError(resp, chunkID)
+    }
+}
+
+func (b *S3Backend) key(chunkID string) string {
+    return b.cfg.Prefix + chunkID
+}
+
+// do sends a signed request for a chunk's object
+func (b *S3Backend) do(method, chunkID string, body []byte) (*http.Response, error) {
+    path := "/" + s3Escape(b.cfg.Bucket) + "/" + s3Escape(b.key(chunkID))
+    req, err := http.NewRequest(method, b.cfg.Endpoint+path, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.ContentLength = int64(len(body))
+    b.sign(req, path, body, time.Now().UTC())
+    return b.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 authorization to req, whose escaped
+// path is path
+func (b *S3Backend) sign(req *http.Request, path string, body []byte, now time.Time) {
+    amzDate := now.Format("20060102T150405Z")
+    date := now.Format("20060102")
+    payloadHash := sha256Hex(body)
+    req.Header.Set("x-amz-date", amzDate)
+    req.Header.Set("x-amz-content-sha256", payloadHash)
+
+    const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+    canonical := strings.Join([]string{
+        req.Method,
+        path,
+        "", // No query
+        "host:" + req.URL.Host,
+        "x-amz-content-sha256:" + payloadHash,
+        "x-amz-date:" + amzDate,
+        "",
+        signedHeaders,
+        payloadHash,
+    }, "\n")
+
+    scope := date + "/" + b.cfg.Region + "/s3/aws4_request"
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+    key := hmacSHA256([]byte("AWS4"+b.cfg.SecretKey), date)
+    key = hmacSHA256(key, b.cfg.Region)
+    key = hmacSHA256(key, "s3")
+    key = hmacSHA256(key, "aws4_request")
+    signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        b.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func (b *S3Backend) statusError(resp *http.Response, chunkID string) error {
+    msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+    return fmt.Errorf("S3 %s of object %s failed with status %d: %s",
+        resp.Request.Method, b.key(chunkID), resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// s3Escape percent-encodes every byte of s but the unreserved characters
+// and slashes, as Signature Version 4 requires of paths
+func s3Escape(s string) string {
+    var buf strings.Builder
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+            c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+            buf.WriteByte(c)
+            continue
+        }
+        fmt.Fprintf(&buf, "%%%02X", c)
+    }
+    return buf.String()
+}
+
+func sha256Hex(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
@@ -0,0 +1,167 @@
+package filemanager
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "time"
+)
+
+// DefaultScrubInterval is how often stored chunks are re-verified
+const DefaultScrubInterval = 24 * time.Hour
+
+// ChunkFetcher retrieves a copy of a chunk from another replica
+type ChunkFetcher func(chunkID string) ([]byte, error)
+
+// LossReporter is told about chunks that were corrupt and could not be
+// repaired, so the file they belong to can be re-replicated
+type LossReporter func(chunkID string, err error)
+
+// ScrubResult summarizes a scrub pass
+type ScrubResult struct {
+    Checked  int
+    Repaired int
+    Lost     int
+}
+
+// Scrubber periodically re-hashes stored chunks against the index. Corrupt
+// chunks are quarantined and replaced with a copy fetched from the network;
+// chunks that cannot be repaired are passed to the loss reporter.
+type Scrubber struct {
+    cm       *ChunkManager
+    interval time.Duration
+    fetch    ChunkFetcher
+    report   LossReporter
+}
+
+// NewScrubber creates a scrubber for cm. fetch and report may be nil, in
+// which case corrupt chunks are only quarantined.
+func NewScrubber(cm *ChunkManager, interval time.Duration, fetch ChunkFetcher, report LossReporter) *Scrubber {
+    if interval <= 0 {
+        interval = DefaultScrubInterval
+    }
+    return &Scrubber{
+        cm:       cm,
+        interval: interval,
+        fetch:    fetch,
+        report:   report,
+    }
+}
+
+// Start scrubs every interval until ctx is cancelled
+func (s *Scrubber) Start(ctx context.Context) {
+    go func() {
+        ticker := time.NewTicker(s.interval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                if _, err := s.ScrubOnce(ctx); err != nil && ctx.Err() == nil {
+                    fmt.Printf("chunk scrub failed: %v\n", err)
+                }
+            }
+        }
+    }()
+}
+
+// ScrubOnce verifies every indexed chunk once
+func (s *Scrubber) ScrubOnce(ctx context.Context) (ScrubResult, error) {
+    var result ScrubResult
+
+    chunks, err := s.cm.ListChunks()
+    if err != nil {
+        return result, err
+    }
+
+    for _, chunkID := range chunks {
+        if ctx.Err() != nil {
+            return result, ctx.Err()
+        }
+
+        meta, ok := s.cm.ChunkInfo(chunkID)
+        if !ok {
+            continue // Deleted since listing
+        }
+        result.Checked++
+
+        err := s.cm.scrubChunk(chunkID)
+        if err == nil {
+            continue
+        }
+        if !errors.Is(err, ErrChunkCorrupt) {
+            return result, err
+        }
+        if err := s.repair(chunkID, meta); err != nil {
+            result.Lost++
+            if s.report != nil {
+                s.report(chunkID, err)
+            }
+            continue
+        }
+        result.Repaired++
+    }
+    return result, nil
+}
+
+// repair replaces a corrupt chunk with a replica matching its checksum
+func (s *Scrubber) repair(chunkID string, meta ChunkMeta) error {
+    if s.fetch == nil {
+        return fmt.Errorf("%w: no replica source", ErrChunkCorrupt)
+    }
+
+    data, err := s.fetch(chunkID)
+    if err != nil {
+        return fmt.Errorf("%w: failed to fetch replica: %v", ErrChunkCorrupt, err)
+    }
+    if meta.Checksum != "" && checksum(data) != meta.Checksum {
+        return fmt.Errorf("%w: replica does not match checksum", ErrChunkCorrupt)
+    }
+    return s.cm.StoreChunk(chunkID, data)
+}
+
+// scrubChunk reads a chunk and verifies it against its checksum without
+// counting it as served. Missing and corrupt chunks are quarantined and
+// dropped from the index. Cold chunks are verified where they are kept,
+// except offloaded ones.
+func (cm *ChunkManager) scrubChunk(chunkID string) error {
+    cm.mu.RLock()
+    defer cm.mu.RUnlock()
+
+    if meta, ok := cm.ChunkInfo(chunkID); ok && meta.Tier == TierCold {
+        if meta.Offloaded {
+            return nil
+        }
+        stored, data, err := cm.readCold(chunkID, meta)
+        if err == nil && meta.Checksum != "" && checksum(data) != meta.Checksum {
+            err = fmt.Errorf("%w: %s", ErrChunkCorrupt, chunkID)
+        }
+        if errors.Is(err, os.ErrNotExist) {
+            err = fmt.Errorf("%w: %s is missing", ErrChunkCorrupt, chunkID)
+        }
+        if errors.Is(err, ErrChunkCorrupt) {
+            cm.quarantineCold(chunkID, meta, stored)
+            return err
+        }
+        if err != nil {
+            return ErrInvalidAccess
+        }
+        return nil
+    }
+
+    data, err := os.ReadFile(cm.shardPath(chunkID))
+    if os.IsNotExist(err) {
+        cm.idxMu.Lock()
+        delete(cm.index, chunkID)
+        cm.saveIndexLocked()
+        cm.idxMu.Unlock()
+        return fmt.Errorf("%w: %s is missing", ErrChunkCorrupt, chunkID)
+    }
+    if err != nil {
+        return ErrInvalidAccess
+    }
+    return cm.verifyChunk(chunkID, data)
+}
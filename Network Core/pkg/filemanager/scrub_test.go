@@ -0,0 +1,70 @@
+package filemanager
+
+import (
+    "context"
+    "errors"
+    "os"
+    "testing"
+)
+
+func TestScrubber(t *testing.T) {
+    tempDir := t.TempDir()
+    fm := NewChunkManager(tempDir)
+
+    replicas := map[string][]byte{
+        "healthy":      []byte("healthy data"),
+        "repairable":   []byte("repairable data"),
+        "unrepairable": []byte("unrepairable data"),
+    }
+    for id, data := range replicas {
+        if err := fm.StoreChunk(id, data); err != nil {
+            t.Fatalf("StoreChunk() error = %v", err)
+        }
+    }
+
+    // Corrupt two chunks; only one has a good replica available
+    for _, id := range []string{"repairable", "unrepairable"} {
+        if err := os.WriteFile(fm.shardPath(id), []byte("bit rot"), 0644); err != nil {
+            t.Fatalf("failed to corrupt chunk: %v", err)
+        }
+    }
+
+    fetch := func(chunkID string) ([]byte, error) {
+        if chunkID == "unrepairable" {
+            return []byte("wrong data"), nil
+        }
+        return replicas[chunkID], nil
+    }
+    var lost []string
+    report := func(chunkID string, err error) {
+        if !errors.Is(err, ErrChunkCorrupt) {
+            t.Errorf("loss reported with error %v", err)
+        }
+        lost = append(lost, chunkID)
+    }
+
+    result, err := NewScrubber(fm, 0, fetch, report).ScrubOnce(context.Background())
+    if err != nil {
+        t.Fatalf("ScrubOnce() error = %v", err)
+    }
+    if result != (ScrubResult{Checked: 3, Repaired: 1, Lost: 1}) {
+        t.Errorf("ScrubOnce() = %+v", result)
+    }
+    if len(lost) != 1 || lost[0] != "unrepairable" {
+        t.Errorf("lost chunks = %v", lost)
+    }
+
+    data, err := fm.GetChunk("repairable")
+    if err != nil || string(data) != "repairable data" {
+        t.Errorf("repaired GetChunk() = %q, %v", data, err)
+    }
+    if _, err := fm.GetChunk("unrepairable"); err == nil {
+        t.Error("unrepairable chunk still served")
+    }
+
+    // Scrubbing does not count as serving a chunk
+    meta, _ := fm.ChunkInfo("healthy")
+    if meta.Served != 0 {
+        t.Errorf("scrub counted as serve: %+v", meta)
+    }
+}
@@ -0,0 +1,332 @@
+package filemanager
+
+import (
+    "bytes"
+    "compress/gzip"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// Chunks that have not been read for a while can go cold: they are
+// compressed at gzip's best compression, moved to a cold backend such as a
+// slower disk or an S3-compatible bucket, or both. Reading a cold chunk
+// rehydrates it, bringing it back into the hot store before it is served,
+// so callers never see the difference. Offloaded chunks are left to the
+// backend's own durability and are not scrubbed.
+
+// DefaultTierInterval is how often chunks are checked for going cold
+const DefaultTierInterval = time.Hour
+
+// TierCold marks chunks in cold storage in their ChunkMeta
+const TierCold = "cold"
+
+// ColdBackend keeps cold chunks outside the hot store. Get returns an error
+// wrapping os.ErrNotExist for chunks it does not have; deleting such chunks
+// succeeds.
+type ColdBackend interface {
+    Put(chunkID string, data []byte) error
+    Get(chunkID string) ([]byte, error)
+    Delete(chunkID string) error
+}
+
+// TierPolicy decides which chunks go cold and what happens to them. The
+// backend must stay configured while chunks are offloaded to it.
+type TierPolicy struct {
+    ColdAfter time.Duration // Time without reads before a chunk goes cold, 0 disables tiering
+    Compress  bool          // Compress cold chunks
+    Backend   ColdBackend   // Where cold chunks are moved, nil to keep them in place
+}
+
+// TierResult summarizes a tiering pass
+type TierResult struct {
+    Cooled int   // Chunks moved to cold storage
+    Bytes  int64 // Their uncompressed size
+}
+
+// SetTierPolicy sets the policy applied by TierOnce
+func (cm *ChunkManager) SetTierPolicy(policy TierPolicy) {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+    cm.tier = policy
+}
+
+// StartTiering applies the tier policy every interval until ctx is
+// cancelled
+func (cm *ChunkManager) StartTiering(ctx context.Context, interval time.Duration) {
+    if interval <= 0 {
+        interval = DefaultTierInterval
+    }
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                if _, err := cm.TierOnce(time.Now()); err != nil {
+                    fmt.Printf("chunk tiering failed: %v\n", err)
+                }
+            }
+        }
+    }()
+}
+
+// TierOnce moves the chunks not read since ColdAfter before now to cold
+// storage
+func (cm *ChunkManager) TierOnce(now time.Time) (TierResult, error) {
+    var result TierResult
+
+    cm.mu.RLock()
+    policy := cm.tier
+    cm.mu.RUnlock()
+    if policy.ColdAfter <= 0 || (!policy.Compress && policy.Backend == nil) {
+        return result, nil
+    }
+
+    if err := cm.loadIndex(); err != nil {
+        return result, err
+    }
+    cutoff := now.Add(-policy.ColdAfter)
+    cm.idxMu.Lock()
+    var idle []string
+    for chunkID, meta := range cm.index {
+        if meta.Tier == "" && !meta.LastAccess.After(cutoff) {
+            idle = append(idle, chunkID)
+        }
+    }
+    cm.idxMu.Unlock()
+    sort.Strings(idle)
+
+    for _, chunkID := range idle {
+        size, err := cm.cool(chunkID, policy, cutoff)
+        if err != nil {
+            return result, err
+        }
+        if size > 0 {
+            result.Cooled++
+            result.Bytes += size
+        }
+    }
+    return result, nil
+}
+
+// cool moves a chunk to cold storage unless it was read since cutoff,
+// returning its size if it was moved. Chunks that are missing or fail their
+// checksum are left to the scrubber.
+func (cm *ChunkManager) cool(chunkID string, policy TierPolicy, cutoff time.Time) (int64, error) {
+    cm.mu.Lock()
+    defer cm.mu.Unlock()
+
+    meta, ok := cm.ChunkInfo(chunkID)
+    if !ok || meta.Tier != "" || meta.LastAccess.After(cutoff) {
+        return 0, nil
+    }
+    path := cm.shardPath(chunkID)
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, ErrInvalidAccess
+    }
+    if meta.Checksum != "" && checksum(data) != meta.Checksum {
+        return 0, nil
+    }
+
+    stored := data
+    if policy.Compress {
+        if stored, err = compress(data); err != nil {
+            return 0, fmt.Errorf("failed to compress chunk %s: %v", chunkID, err)
+        }
+    }
+    if policy.Backend != nil {
+        if err := policy.Backend.Put(chunkID, stored); err != nil {
+            return 0, fmt.Errorf("failed to offload chunk %s: %v", chunkID, err)
+        }
+    } else if err := writeFileAtomic(path, stored); err != nil {
+        return 0, ErrInvalidAccess
+    }
+
+    cm.idxMu.Lock()
+    if m, ok := cm.index[chunkID]; ok {
+        m.Tier = TierCold
+        m.Compressed = policy.Compress
+        m.Offloaded = policy.Backend != nil
+    }
+    err = cm.saveIndexLocked()
+    cm.idxMu.Unlock()
+    if err != nil {
+        return 0, err
+    }
+
+    if policy.Backend != nil {
+        os.Remove(path)
+    }
+    return int64(len(data)), nil
+}
+
+// readCold reads a cold chunk as stored and returns its uncompressed data.
+// Callers must hold cm.mu.
+func (cm *ChunkManager) readCold(chunkID string, meta ChunkMeta) (stored, data []byte, err error) {
+    if meta.Offloaded {
+        if cm.tier.Backend == nil {
+            return nil, nil, fmt.Errorf("chunk %s is offloaded but no cold backend is configured", chunkID)
+        }
+        stored, err = cm.tier.Backend.Get(chunkID)
+    } else {
+        stored, err = os.ReadFile(cm.shardPath(chunkID))
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if !meta.Compressed {
+        return stored, stored, nil
+    }
+    if data, err = decompress(stored); err != nil {
+        return stored, nil, fmt.Errorf("%w: %s: %v", ErrChunkCorrupt, chunkID, err)
+    }
+    return stored, data, nil
+}
+
+// rehydrate brings a cold chunk back into the hot store and returns its
+// data. Callers must hold cm.mu.
+func (cm *ChunkManager) rehydrate(chunkID string, meta ChunkMeta) ([]byte, error) {
+    stored, data, err := cm.readCold(chunkID, meta)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("chunk %s not found", chunkID)
+    }
+    if err == nil && meta.Checksum != "" && checksum(data) != meta.Checksum {
+        err = fmt.Errorf("%w: %s", ErrChunkCorrupt, chunkID)
+    }
+    if errors.Is(err, ErrChunkCorrupt) {
+        cm.quarantineCold(chunkID, meta, stored)
+        return nil, err
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to rehydrate chunk %s: %v", chunkID, err)
+    }
+
+    path := cm.shardPath(chunkID)
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, ErrInvalidAccess
+    }
+    if err := writeFileAtomic(path, data); err != nil {
+        return nil, ErrInvalidAccess
+    }
+
+    cm.idxMu.Lock()
+    if m, ok := cm.index[chunkID]; ok {
+        m.Tier = ""
+        m.Compressed = false
+        m.Offloaded = false
+    }
+    err = cm.saveIndexLocked()
+    cm.idxMu.Unlock()
+    if err != nil {
+        return nil, err
+    }
+
+    if meta.Offloaded {
+        if err := cm.tier.Backend.Delete(chunkID); err != nil {
+            fmt.Printf("failed to remove rehydrated chunk %s from cold storage: %v\n", chunkID, err)
+        }
+    }
+    return data, nil
+}
+
+// quarantineCold moves a corrupt cold chunk as stored to the quarantine
+// directory and drops it from the index. Callers must hold cm.mu.
+func (cm *ChunkManager) quarantineCold(chunkID string, meta ChunkMeta, stored []byte) {
+    quarantine := filepath.Join(cm.baseDir, quarantineDir)
+    if stored != nil && os.MkdirAll(quarantine, 0755) == nil {
+        os.WriteFile(filepath.Join(quarantine, chunkID), stored, 0644)
+    }
+    cm.removeCold(chunkID, meta)
+
+    cm.idxMu.Lock()
+    delete(cm.index, chunkID)
+    cm.saveIndexLocked()
+    cm.idxMu.Unlock()
+}
+
+// removeCold deletes a cold chunk from where it is stored. Callers must
+// hold cm.mu.
+func (cm *ChunkManager) removeCold(chunkID string, meta ChunkMeta) error {
+    if meta.Offloaded && cm.tier.Backend != nil {
+        if err := cm.tier.Backend.Delete(chunkID); err != nil {
+            return err
+        }
+    }
+    if err := os.Remove(cm.shardPath(chunkID)); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
+
+// compress gzips data at the best compression
+func compress(data []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := zw.Write(data); err != nil {
+        return nil, err
+    }
+    if err := zw.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// decompress reverses compress
+func decompress(data []byte) ([]byte, error) {
+    zr, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    defer zr.Close()
+    return io.ReadAll(zr)
+}
+
+// DirBackend keeps cold chunks in a directory, e.g. on a slower disk, in
+// the same sharded layout as the hot store
+type DirBackend struct {
+    dir string
+}
+
+// NewDirBackend creates a cold backend storing chunks under dir
+func NewDirBackend(dir string) *DirBackend {
+    return &DirBackend{dir: dir}
+}
+
+// Put stores a chunk
+func (b *DirBackend) Put(chunkID string, data []byte) error {
+    path := shardedPath(b.dir, chunkID)
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    return writeFileAtomic(path, data)
+}
+
+// Get reads a chunk
+func (b *DirBackend) Get(chunkID string) ([]byte, error) {
+    return os.ReadFile(shardedPath(b.dir, chunkID))
+}
+
+// Delete removes a chunk
+func (b *DirBackend) Delete(chunkID string) error {
+    if err := os.Remove(shardedPath(b.dir, chunkID)); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,196 @@
+package filemanager
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestTiering(t *testing.T) {
+    fm := NewChunkManager(t.TempDir())
+    cold := NewDirBackend(t.TempDir())
+    fm.SetTierPolicy(TierPolicy{ColdAfter: 24 * time.Hour, Compress: true, Backend: cold})
+
+    data := bytes.Repeat([]byte("rarely read "), 100)
+    for _, id := range []string{"idle", "busy"} {
+        if err := fm.StoreChunk(id, data); err != nil {
+            t.Fatalf("StoreChunk() error = %v", err)
+        }
+    }
+
+    // Nothing has been idle long enough yet
+    if result, err := fm.TierOnce(time.Now()); err != nil || result.Cooled != 0 {
+        t.Fatalf("TierOnce() = %+v, %v", result, err)
+    }
+
+    later := time.Now().Add(48 * time.Hour)
+    fm.idxMu.Lock()
+    fm.index["busy"].LastAccess = later
+    fm.idxMu.Unlock()
+    result, err := fm.TierOnce(later)
+    if err != nil || result != (TierResult{Cooled: 1, Bytes: int64(len(data))}) {
+        t.Fatalf("TierOnce() = %+v, %v", result, err)
+    }
+
+    meta, _ := fm.ChunkInfo("idle")
+    if meta.Tier != TierCold || !meta.Compressed || !meta.Offloaded {
+        t.Errorf("idle chunk meta = %+v", meta)
+    }
+    if _, err := os.Stat(fm.shardPath("idle")); !os.IsNotExist(err) {
+        t.Errorf("offloaded chunk still in the hot store: %v", err)
+    }
+    stored, err := cold.Get("idle")
+    if err != nil || len(stored) >= len(data) {
+        t.Errorf("cold copy is %d bytes, %v", len(stored), err)
+    }
+
+    // Scrubbing leaves offloaded chunks alone
+    if err := fm.scrubChunk("idle"); err != nil {
+        t.Errorf("scrubChunk() error = %v", err)
+    }
+
+    // Reading rehydrates the chunk
+    got, err := fm.GetChunk("idle")
+    if err != nil || !bytes.Equal(got, data) {
+        t.Fatalf("GetChunk() = %d bytes, %v", len(got), err)
+    }
+    meta, _ = fm.ChunkInfo("idle")
+    if meta.Tier != "" || meta.Served != 1 {
+        t.Errorf("rehydrated chunk meta = %+v", meta)
+    }
+    if _, err := cold.Get("idle"); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("rehydrated chunk still in cold storage: %v", err)
+    }
+
+    // Deleting a cold chunk removes it from the backend
+    if _, err := fm.TierOnce(later.Add(48 * time.Hour)); err != nil {
+        t.Fatalf("TierOnce() error = %v", err)
+    }
+    if err := fm.DeleteChunk("busy"); err != nil {
+        t.Fatalf("DeleteChunk() error = %v", err)
+    }
+    if _, err := cold.Get("busy"); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("deleted chunk still in cold storage: %v", err)
+    }
+}
+
+func TestTieringInPlace(t *testing.T) {
+    fm := NewChunkManager(t.TempDir())
+    fm.SetTierPolicy(TierPolicy{ColdAfter: time.Hour, Compress: true})
+
+    data := bytes.Repeat([]byte("compressible "), 100)
+    for _, id := range []string{"good", "rotten"} {
+        if err := fm.StoreChunk(id, data); err != nil {
+            t.Fatalf("StoreChunk() error = %v", err)
+        }
+    }
+    if result, err := fm.TierOnce(time.Now().Add(2 * time.Hour)); err != nil || result.Cooled != 2 {
+        t.Fatalf("TierOnce() = %+v, %v", result, err)
+    }
+
+    // Cold chunks are scrubbed where they are
+    if err := fm.scrubChunk("good"); err != nil {
+        t.Errorf("scrubChunk() error = %v", err)
+    }
+    if err := os.WriteFile(fm.shardPath("rotten"), []byte("bit rot"), 0644); err != nil {
+        t.Fatalf("failed to corrupt chunk: %v", err)
+    }
+    if err := fm.scrubChunk("rotten"); !errors.Is(err, ErrChunkCorrupt) {
+        t.Errorf("scrubChunk() error = %v, want ErrChunkCorrupt", err)
+    }
+    if _, ok := fm.ChunkInfo("rotten"); ok {
+        t.Error("corrupt cold chunk still indexed")
+    }
+
+    got, err := fm.GetChunk("good")
+    if err != nil || !bytes.Equal(got, data) {
+        t.Errorf("GetChunk() = %d bytes, %v", len(got), err)
+    }
+}
+
+// fakeS3 is an object store checking that requests are signed
+type fakeS3 struct {
+    mu      sync.Mutex
+    objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    auth := r.Header.Get("Authorization")
+    if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+        w.WriteHeader(http.StatusForbidden)
+        return
+    }
+    body, _ := io.ReadAll(r.Body)
+    if r.Header.Get("x-amz-content-sha256") != sha256Hex(body) {
+        w.WriteHeader(http.StatusBadRequest)
+        return
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    switch r.Method {
+    case http.MethodPut:
+        f.objects[r.URL.EscapedPath()] = body
+    case http.MethodGet:
+        data, ok := f.objects[r.URL.EscapedPath()]
+        if !ok {
+            w.WriteHeader(http.StatusNotFound)
+            return
+        }
+        w.Write(data)
+    case http.MethodDelete:
+        delete(f.objects, r.URL.EscapedPath())
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+func TestS3Backend(t *testing.T) {
+    store := &fakeS3{objects: make(map[string][]byte)}
+    srv := httptest.NewServer(store)
+    defer srv.Close()
+
+    if _, err := NewS3Backend(S3Config{Endpoint: srv.URL, Bucket: "chunks"}); err == nil {
+        t.Error("NewS3Backend() accepted missing credentials")
+    }
+    b, err := NewS3Backend(S3Config{Endpoint: srv.URL + "/", Bucket: "chunks", Prefix: "cold/", AccessKey: "key", SecretKey: "secret"})
+    if err != nil {
+        t.Fatalf("NewS3Backend() error = %v", err)
+    }
+
+    if err := b.Put("a+b", []byte("data")); err != nil {
+        t.Fatalf("Put() error = %v", err)
+    }
+    if _, ok := store.objects["/chunks/cold/a%2Bb"]; !ok {
+        t.Errorf("objects = %v", store.objects)
+    }
+    data, err := b.Get("a+b")
+    if err != nil || string(data) != "data" {
+        t.Errorf("Get() = %q, %v", data, err)
+    }
+    if err := b.Delete("a+b"); err != nil {
+        t.Errorf("Delete() error = %v", err)
+    }
+    if _, err := b.Get("a+b"); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("Get() of deleted object error = %v", err)
+    }
+    if err := b.Delete("a+b"); err != nil {
+        t.Errorf("Delete() of missing object error = %v", err)
+    }
+
+    // The signature covers the payload
+    now := time.Date(2013, 5, 24, 0, 0, 0, 0, time.UTC)
+    req1, _ := http.NewRequest(http.MethodPut, srv.URL+"/chunks/x", nil)
+    req2, _ := http.NewRequest(http.MethodPut, srv.URL+"/chunks/x", nil)
+    b.sign(req1, "/chunks/x", []byte("one"), now)
+    b.sign(req2, "/chunks/x", []byte("two"), now)
+    if req1.Header.Get("Authorization") == req2.Header.Get("Authorization") {
+        t.Error("signature does not depend on the payload")
+    }
+}
@@ -0,0 +1,222 @@
+// Package identity keeps a node's libp2p key on disk so that its peer ID
+// survives restarts. The overlay and the network engine take an Identity
+// instead of generating a key of their own.
+//
+// Identities are stored and exported in the same format: JSON holding the
+// peer ID and the marshalled private key. Given a passphrase the key is
+// sealed with it: a random scrypt salt, then a secretbox nonce, then the
+// box, the way the client seals share links.
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// FileName is the usual name of the identity file in a data directory
+	FileName = "identity.json"
+
+	saltSize  = 16
+	nonceSize = 24
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+)
+
+// Identity errors
+var (
+	ErrNotFound           = errors.New("identity not found")
+	ErrWrongPassphrase    = errors.New("wrong passphrase or corrupt identity")
+	ErrPassphraseRequired = errors.New("identity is encrypted, a passphrase is required")
+	ErrMismatch           = errors.New("identity key does not match its peer ID")
+)
+
+// Identity is a node's key pair and the peer ID derived from it
+type Identity struct {
+	PrivKey crypto.PrivKey
+	ID      peer.ID
+}
+
+// keyFile is the stored and exported form of an identity
+type keyFile struct {
+	PeerID    string `json:"peer_id"`
+	Encrypted bool   `json:"encrypted"`
+	Key       []byte `json:"key"` // Marshalled private key, sealed if Encrypted
+}
+
+// New returns the identity of a private key
+func New(priv crypto.PrivKey) (*Identity, error) {
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	return &Identity{PrivKey: priv, ID: id}, nil
+}
+
+// Generate creates a new Ed25519 identity
+func Generate() (*Identity, error) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate node key: %w", err)
+	}
+	return New(priv)
+}
+
+// Export encodes the identity for moving it to another machine. The key is
+// sealed with passphrase unless it is empty.
+func (id *Identity) Export(passphrase string) ([]byte, error) {
+	key, err := crypto.MarshalPrivateKey(id.PrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node key: %w", err)
+	}
+
+	file := keyFile{PeerID: id.ID.String(), Key: key}
+	if passphrase != "" {
+		if file.Key, err = seal(key, passphrase); err != nil {
+			return nil, err
+		}
+		file.Encrypted = true
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// Import decodes an exported identity, opening it with passphrase if it
+// is sealed
+func Import(data []byte, passphrase string) (*Identity, error) {
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse identity: %w", err)
+	}
+
+	key := file.Key
+	if file.Encrypted {
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		var err error
+		if key, err = open(key, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	priv, err := crypto.UnmarshalPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node key: %w", err)
+	}
+	id, err := New(priv)
+	if err != nil {
+		return nil, err
+	}
+	if file.PeerID != "" && file.PeerID != id.ID.String() {
+		return nil, ErrMismatch
+	}
+	return id, nil
+}
+
+// Save writes the identity to path, readable only by the owner. The key is
+// sealed with passphrase unless it is empty.
+func (id *Identity) Save(path, passphrase string) error {
+	data, err := id.Export(passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create identity directory: %w", err)
+	}
+
+	// Write a temporary file first so a crash never leaves half a key
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write identity: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write identity: %w", err)
+	}
+	return nil
+}
+
+// Load reads the identity saved at path
+func Load(path, passphrase string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
+	return Import(data, passphrase)
+}
+
+// LoadOrCreate reads the identity saved at path, generating and saving a
+// new one on first run
+func LoadOrCreate(path, passphrase string) (*Identity, error) {
+	id, err := Load(path, passphrase)
+	if !errors.Is(err, ErrNotFound) {
+		return id, err
+	}
+
+	if id, err = Generate(); err != nil {
+		return nil, err
+	}
+	if err := id.Save(path, passphrase); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// seal encrypts a key with a key derived from passphrase
+func seal(key []byte, passphrase string) ([]byte, error) {
+	sealed := make([]byte, saltSize+nonceSize, saltSize+nonceSize+len(key)+secretbox.Overhead)
+	if _, err := rand.Read(sealed); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	secret, err := deriveKey(passphrase, sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[saltSize:])
+	return secretbox.Seal(sealed, key, &nonce, secret), nil
+}
+
+// open decrypts a key sealed by seal
+func open(sealed []byte, passphrase string) ([]byte, error) {
+	if len(sealed) < saltSize+nonceSize+secretbox.Overhead {
+		return nil, ErrWrongPassphrase
+	}
+	secret, err := deriveKey(passphrase, sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[saltSize:saltSize+nonceSize])
+	key, ok := secretbox.Open(nil, sealed[saltSize+nonceSize:], &nonce, secret)
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+// deriveKey stretches a passphrase into a secretbox key
+func deriveKey(passphrase string, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	var secret [32]byte
+	copy(secret[:], derived)
+	return &secret, nil
+}
@@ -0,0 +1,67 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node", FileName)
+
+	_, err := Load(path, "")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// The first run creates the key, later runs keep the same peer ID
+	created, err := LoadOrCreate(path, "secret")
+	require.NoError(t, err)
+	loaded, err := LoadOrCreate(path, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, loaded.ID)
+	assert.True(t, created.PrivKey.Equals(loaded.PrivKey))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// The key is not stored in the clear
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	plain, err := created.Export("")
+	require.NoError(t, err)
+	assert.NotEqual(t, plain, data)
+
+	_, err = Load(path, "wrong")
+	assert.ErrorIs(t, err, ErrWrongPassphrase)
+	_, err = Load(path, "")
+	assert.ErrorIs(t, err, ErrPassphraseRequired)
+}
+
+func TestExportImport(t *testing.T) {
+	id, err := Generate()
+	require.NoError(t, err)
+
+	for _, passphrase := range []string{"", "move me"} {
+		data, err := id.Export(passphrase)
+		require.NoError(t, err)
+		imported, err := Import(data, passphrase)
+		require.NoError(t, err)
+		assert.Equal(t, id.ID, imported.ID)
+	}
+
+	// An export whose key was swapped for another is refused
+	other, err := Generate()
+	require.NoError(t, err)
+	data, err := other.Export("")
+	require.NoError(t, err)
+	swapped := strings.Replace(string(data), other.ID.String(), id.ID.String(), 1)
+	_, err = Import([]byte(swapped), "")
+	assert.ErrorIs(t, err, ErrMismatch)
+
+	_, err = Import([]byte("not json"), "")
+	assert.Error(t, err)
+}
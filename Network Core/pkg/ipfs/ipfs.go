@@ -0,0 +1,200 @@
+// Package ipfs lets nodes interoperate with IPFS. Chunks are already
+// encrypted, so they can be published as raw IPFS blocks through a local
+// IPFS daemon and recorded by CID in their manifest. When no FileZap peer
+// serving a chunk is reachable, the block is fetched from public gateways
+// instead. Gateways are not trusted: a block is only accepted if it hashes
+// to its CID.
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// DefaultTimeout bounds each request to the daemon or a gateway
+	DefaultTimeout = 30 * time.Second
+
+	// maxBlockSize is the largest block accepted from a gateway. Chunks are
+	// at most 100MB.
+	maxBlockSize = 100 * 1024 * 1024
+)
+
+// DefaultGateways are the public gateways tried when none are configured
+var DefaultGateways = []string{"https://ipfs.io", "https://dweb.link"}
+
+// ErrBlockNotFound is returned when no gateway served an intact block
+var ErrBlockNotFound = errors.New("block not found on any gateway")
+
+// Config enables IPFS interop
+type Config struct {
+	Publish  bool     `json:"publish"`  // Publish uploaded chunks through the daemon
+	API      string   `json:"api"`      // Daemon RPC API, e.g. "http://127.0.0.1:5001"
+	Fallback bool     `json:"fallback"` // Fetch chunks from gateways when no peer serves them
+	Gateways []string `json:"gateways"` // Gateways to fetch from, DefaultGateways if empty
+}
+
+// Validate checks that publishing has a daemon to publish through
+func (c Config) Validate() error {
+	if c.Publish && c.API == "" {
+		return fmt.Errorf("publishing to IPFS needs the daemon API address")
+	}
+	return nil
+}
+
+// Client publishes and fetches blocks
+type Client struct {
+	api      string
+	gateways []string
+	http     *http.Client
+}
+
+// NewClient creates a client for cfg
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	gateways := cfg.Gateways
+	if len(gateways) == 0 {
+		gateways = DefaultGateways
+	}
+	c := &Client{
+		api:  strings.TrimRight(cfg.API, "/"),
+		http: &http.Client{Timeout: DefaultTimeout},
+	}
+	for _, gw := range gateways {
+		c.gateways = append(c.gateways, strings.TrimRight(gw, "/"))
+	}
+	return c, nil
+}
+
+// BlockCID returns the CID of data as a raw block: CIDv1 with the raw codec
+// and a SHA-256 multihash, as the daemon assigns it
+func BlockCID(data []byte) (cid.Cid, error) {
+	hash, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, hash), nil
+}
+
+// Verify checks that data is the block c names
+func Verify(c cid.Cid, data []byte) error {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(c) {
+		return fmt.Errorf("block does not match %s", c)
+	}
+	return nil
+}
+
+// Publish adds data to the daemon as a pinned raw block and returns its CID
+func (c *Client) Publish(ctx context.Context, data []byte) (cid.Cid, error) {
+	if c.api == "" {
+		return cid.Undef, fmt.Errorf("no IPFS daemon configured")
+	}
+	want, err := BlockCID(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", want.String())
+	if err != nil {
+		return cid.Undef, err
+	}
+	part.Write(data)
+	if err := form.Close(); err != nil {
+		return cid.Undef, err
+	}
+
+	url := c.api + "/api/v0/block/put?cid-codec=raw&mhtype=sha2-256&pin=true"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return cid.Undef, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to reach IPFS daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return cid.Undef, fmt.Errorf("IPFS daemon refused block: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var reply struct {
+		Key string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return cid.Undef, fmt.Errorf("invalid reply from IPFS daemon: %w", err)
+	}
+	got, err := cid.Decode(reply.Key)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid CID from IPFS daemon: %w", err)
+	}
+	if !got.Equals(want) {
+		return cid.Undef, fmt.Errorf("IPFS daemon stored block as %s, expected %s", got, want)
+	}
+	return got, nil
+}
+
+// Fetch returns block c from the first gateway serving an intact copy
+func (c *Client) Fetch(ctx context.Context, block cid.Cid) ([]byte, error) {
+	var errs []string
+	for _, gw := range c.gateways {
+		data, err := c.fetchFrom(ctx, gw, block)
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", gw, err))
+	}
+	return nil, fmt.Errorf("%w: %s: %s", ErrBlockNotFound, block, strings.Join(errs, "; "))
+}
+
+// fetchFrom requests a raw block from a trustless gateway and verifies it
+func (c *Client) fetchFrom(ctx context.Context, gateway string, block cid.Cid) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gateway+"/ipfs/"+block.String()+"?format=raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.raw")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBlockSize {
+		return nil, fmt.Errorf("block exceeds %d bytes", maxBlockSize)
+	}
+	if err := Verify(block, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
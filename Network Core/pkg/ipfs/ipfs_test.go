@@ -0,0 +1,140 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+// fakeIPFS serves the block API of a daemon and the raw block responses of
+// a trustless gateway from the same store
+type fakeIPFS struct {
+	mu     sync.Mutex
+	blocks map[string][]byte
+}
+
+func newFakeIPFS() *fakeIPFS {
+	return &fakeIPFS{blocks: make(map[string][]byte)}
+}
+
+func (f *fakeIPFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/api/v0/block/put" && r.Method == http.MethodPost:
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		c, _ := BlockCID(data)
+		f.blocks[c.String()] = data
+		json.NewEncoder(w).Encode(map[string]interface{}{"Key": c.String(), "Size": len(data)})
+	case strings.HasPrefix(r.URL.Path, "/ipfs/") && r.Method == http.MethodGet:
+		if r.Header.Get("Accept") != "application/vnd.ipld.raw" {
+			http.Error(w, "raw blocks only", http.StatusNotAcceptable)
+			return
+		}
+		data, ok := f.blocks[strings.TrimPrefix(r.URL.Path, "/ipfs/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPublishAndFetch(t *testing.T) {
+	fake := newFakeIPFS()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client, err := NewClient(Config{Publish: true, API: srv.URL, Fallback: true, Gateways: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := []byte("encrypted chunk")
+
+	c, err := client.Publish(ctx, data)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	want, _ := BlockCID(data)
+	if !c.Equals(want) || c.Prefix().Codec != cid.Raw || c.Version() != 1 {
+		t.Fatalf("Publish returned %s, want raw CIDv1 %s", c, want)
+	}
+
+	got, err := client.Fetch(ctx, c)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("Fetch returned %q, want %q", got, data)
+	}
+
+	missing, _ := BlockCID([]byte("never published"))
+	if _, err := client.Fetch(ctx, missing); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("Fetch of a missing block returned %v, want ErrBlockNotFound", err)
+	}
+}
+
+func TestFetchRejectsTamperedBlocks(t *testing.T) {
+	data := []byte("encrypted chunk")
+	c, _ := BlockCID(data)
+
+	tampered := newFakeIPFS()
+	tampered.blocks[c.String()] = []byte("something else")
+	bad := httptest.NewServer(tampered)
+	defer bad.Close()
+
+	honest := newFakeIPFS()
+	honest.blocks[c.String()] = data
+	good := httptest.NewServer(honest)
+	defer good.Close()
+
+	client, err := NewClient(Config{Fallback: true, Gateways: []string{bad.URL, good.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.Fetch(context.Background(), c)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("Fetch returned %q from a tampering gateway", got)
+	}
+
+	client, _ = NewClient(Config{Fallback: true, Gateways: []string{bad.URL}})
+	if _, err := client.Fetch(context.Background(), c); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("Fetch accepted a tampered block: %v", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if err := (Config{Publish: true}).Validate(); err == nil {
+		t.Error("publishing without a daemon was accepted")
+	}
+	client, err := NewClient(Config{Fallback: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.gateways) != len(DefaultGateways) {
+		t.Errorf("client uses %v, want the default gateways", client.gateways)
+	}
+	if _, err := client.Publish(context.Background(), []byte("x")); err == nil {
+		t.Error("Publish without a daemon succeeded")
+	}
+}
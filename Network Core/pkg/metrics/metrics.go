@@ -0,0 +1,205 @@
+package metrics
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "filezap"
+
+var (
+    // Registry holds all Network Core collectors
+    Registry = prometheus.NewRegistry()
+
+    // ChunkStoreBytes is the total size of chunks held in the local store
+    ChunkStoreBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Subsystem: "chunk",
+        Name:      "store_bytes",
+        Help:      "Total size of chunks held in the local chunk store.",
+    })
+
+    // ChunkStoreChunks is the number of chunks held in the local store
+    ChunkStoreChunks = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Subsystem: "chunk",
+        Name:      "store_chunks",
+        Help:      "Number of chunks held in the local chunk store.",
+    })
+
+    // ChunkTransferBytes counts chunk bytes sent and received
+    ChunkTransferBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Subsystem: "chunk",
+        Name:      "transfer_bytes_total",
+        Help:      "Chunk bytes transferred, by direction.",
+    }, []string{"direction"})
+
+    // ChunkTransfers counts chunk transfers by direction and result
+    ChunkTransfers = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Subsystem: "chunk",
+        Name:      "transfers_total",
+        Help:      "Chunk transfers, by direction and result.",
+    }, []string{"direction", "result"})
+
+    // ChunkTransferDuration observes how long chunk downloads take
+    ChunkTransferDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Subsystem: "chunk",
+        Name:      "transfer_duration_seconds",
+        Help:      "Duration of chunk downloads.",
+        Buckets:   prometheus.DefBuckets,
+    })
+
+    // StorageQueueDepth is the number of storage requests awaiting a decision
+    StorageQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Subsystem: "storage",
+        Name:      "queue_depth",
+        Help:      "Number of storage requests awaiting a decision.",
+    })
+
+    // StorageQueueRejections counts storage requests rejected by the queue
+    StorageQueueRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Subsystem: "storage",
+        Name:      "queue_rejections_total",
+        Help:      "Storage requests rejected by the request queue, by reason.",
+    }, []string{"reason"})
+
+    // StorageDuplicateRequests counts retried storage requests recognised by
+    // their idempotency key
+    StorageDuplicateRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Subsystem: "storage",
+        Name:      "duplicate_requests_total",
+        Help:      "Retried storage requests recognised by idempotency key, by outcome.",
+    }, []string{"outcome"})
+
+    // DHTRoutingTableSize is the number of peers in the DHT routing table
+    DHTRoutingTableSize = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Subsystem: "dht",
+        Name:      "routing_table_size",
+        Help:      "Number of peers in the DHT routing table.",
+    })
+
+    // GossipPeers is the number of peers known through gossip
+    GossipPeers = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Subsystem: "gossip",
+        Name:      "peers",
+        Help:      "Number of peers known through gossip.",
+    })
+
+    // Votes counts quorum votes by type and outcome
+    Votes = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Subsystem: "quorum",
+        Name:      "votes_total",
+        Help:      "Quorum votes, by type and outcome.",
+    }, []string{"type", "outcome"})
+
+    // ManifestUpdates counts manifest updates by source and result
+    ManifestUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Subsystem: "manifest",
+        Name:      "updates_total",
+        Help:      "Manifest updates, by source and result.",
+    }, []string{"source", "result"})
+
+    // ManifestCacheLookups counts manifest lookups outside the local store
+    // by cache result
+    ManifestCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Subsystem: "manifest",
+        Name:      "cache_lookups_total",
+        Help:      "Manifest lookups outside the local store, by cache result.",
+    }, []string{"result"})
+
+    // ReplicationLag is the number of missing manifest replicas seen in the
+    // last replication pass
+    ReplicationLag = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Subsystem: "manifest",
+        Name:      "replication_lag_replicas",
+        Help:      "Missing manifest replicas seen in the last replication pass.",
+    })
+
+    // ReplicationLastRun is the time of the last completed replication pass
+    ReplicationLastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Subsystem: "manifest",
+        Name:      "replication_last_run_timestamp_seconds",
+        Help:      "Unix time of the last completed replication pass.",
+    })
+)
+
+func init() {
+    Registry.MustRegister(
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+        ChunkStoreBytes,
+        ChunkStoreChunks,
+        ChunkTransferBytes,
+        ChunkTransfers,
+        ChunkTransferDuration,
+        StorageQueueDepth,
+        StorageQueueRejections,
+        StorageDuplicateRequests,
+        DHTRoutingTableSize,
+        GossipPeers,
+        Votes,
+        ManifestUpdates,
+        ManifestCacheLookups,
+        ReplicationLag,
+        ReplicationLastRun,
+    )
+}
+
+// Handler returns an HTTP handler serving the registry in Prometheus format
+func Handler() http.Handler {
+    return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Server exposes metrics over HTTP at /metrics
+type Server struct {
+    srv *http.Server
+}
+
+// NewServer creates a metrics server listening on addr
+func NewServer(addr string) *Server {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", Handler())
+    return &Server{
+        srv: &http.Server{
+            Addr:              addr,
+            Handler:           mux,
+            ReadHeaderTimeout: 10 * time.Second,
+        },
+    }
+}
+
+// Start begins serving metrics in the background. Errors other than a
+// graceful shutdown are delivered on the returned channel.
+func (s *Server) Start() <-chan error {
+    errCh := make(chan error, 1)
+    go func() {
+        if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            errCh <- err
+        }
+        close(errCh)
+    }()
+    return errCh
+}
+
+// Stop gracefully shuts the metrics server down
+func (s *Server) Stop(ctx context.Context) error {
+    return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerExposesMetrics(t *testing.T) {
+	ChunkStoreBytes.Set(42)
+	Votes.WithLabelValues("remove_peer", "passed").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	out := string(body)
+
+	assert.True(t, strings.Contains(out, "filezap_chunk_store_bytes 42"))
+	assert.True(t, strings.Contains(out, `filezap_quorum_votes_total{outcome="passed",type="remove_peer"} 1`))
+}
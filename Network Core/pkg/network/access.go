@@ -0,0 +1,87 @@
+package network
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Storage nodes serve a chunk only to peers allowed by the access list of a
+// manifest listing it. A chunk listed by any world-readable manifest is
+// world-readable, as its content is public anyway, and a manifest's owner
+// can always fetch its chunks. Chunks of manifests the node has not seen
+// are served to everyone.
+
+// ErrAccessDenied is returned when a peer may not fetch a restricted chunk
+var ErrAccessDenied = errors.New("access denied")
+
+// chunkAccess is the restriction a manifest places on its chunks
+type chunkAccess struct {
+    owner string
+    acl   *types.AccessList // Nil for a world-readable manifest
+}
+
+// SetManifestAccess applies a manifest's access list to its chunks,
+// replacing the one set by an earlier version of the manifest
+func (cs *ChunkStore) SetManifestAccess(manifest *ManifestInfo) {
+    cs.setManifestAccess(manifest, manifest.ChunkHashes)
+}
+
+// setManifestAccess applies a manifest's access list to hashes, the
+// chunks of a paged manifest known so far or else its ChunkHashes
+func (cs *ChunkStore) setManifestAccess(manifest *ManifestInfo, hashes []string) {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+
+    if cs.access == nil {
+        cs.access = make(map[string]map[string]chunkAccess)
+        cs.manifestChunks = make(map[string][]string)
+    }
+    for _, hash := range cs.manifestChunks[manifest.Name] {
+        delete(cs.access[hash], manifest.Name)
+        if len(cs.access[hash]) == 0 {
+            delete(cs.access, hash)
+        }
+    }
+
+    entry := chunkAccess{owner: manifest.Owner, acl: manifest.ACL}
+    for _, hash := range hashes {
+        if cs.access[hash] == nil {
+            cs.access[hash] = make(map[string]chunkAccess)
+        }
+        cs.access[hash][manifest.Name] = entry
+    }
+    cs.manifestChunks[manifest.Name] = append([]string(nil), hashes...)
+}
+
+// CheckAccess reports whether a peer, with public key pub, may fetch a
+// chunk. pub may be nil if the peer's key is unknown.
+func (cs *ChunkStore) CheckAccess(hash string, p peer.ID, pub crypto.PubKey) error {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    return cs.checkAccess(hash, p, pub)
+}
+
+// checkAccess implements CheckAccess. Callers must hold cs.mu.
+func (cs *ChunkStore) checkAccess(hash string, p peer.ID, pub crypto.PubKey) error {
+    entries := cs.access[hash]
+    if len(entries) == 0 {
+        return nil
+    }
+
+    var key []byte
+    if pub != nil {
+        key, _ = crypto.MarshalPublicKey(pub)
+    }
+    now := time.Now()
+    for _, entry := range entries {
+        if entry.acl == nil || entry.owner == p.String() || entry.acl.Allows(p.String(), key, now) {
+            return nil
+        }
+    }
+    return fmt.Errorf("%w: %s may not fetch chunk %s", ErrAccessDenied, p, hash)
+}
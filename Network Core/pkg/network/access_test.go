@@ -0,0 +1,79 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkAccess(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	downloader := NewChunkStore(h1)
+	holder := NewChunkStore(h2)
+	require.True(t, holder.Store("a", []byte("data")))
+
+	manifest := &ManifestInfo{
+		Name:        "private.zap",
+		Owner:       "someone-else",
+		ChunkHashes: []string{"a"},
+		ACL:         &types.AccessList{ClientIDs: []string{"friend"}},
+	}
+	holder.SetManifestAccess(manifest)
+
+	// Peers outside the list can neither fetch nor locate the chunk
+	_, err := downloader.transfers.Download(h2.ID(), "a")
+	assert.ErrorContains(t, err, "access denied")
+	held, err := downloader.transfers.QueryChunks(h2.ID(), []string{"a"})
+	require.NoError(t, err)
+	assert.Empty(t, held)
+
+	// Peers are allowed by ID or by public key
+	manifest.ACL = &types.AccessList{ClientIDs: []string{h1.ID().String()}}
+	holder.SetManifestAccess(manifest)
+	data, err := downloader.transfers.Download(h2.ID(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	key, err := crypto.MarshalPublicKey(h1.Peerstore().PubKey(h1.ID()))
+	require.NoError(t, err)
+	manifest.ACL = &types.AccessList{PublicKeys: [][]byte{key}}
+	holder.SetManifestAccess(manifest)
+	assert.NoError(t, holder.CheckAccess("a", h1.ID(), h1.Peerstore().PubKey(h1.ID())))
+
+	// Expired lists deny everyone but the owner
+	manifest.ACL = &types.AccessList{ClientIDs: []string{h1.ID().String()}, Expires: time.Now().Add(-time.Minute).Unix()}
+	holder.SetManifestAccess(manifest)
+	assert.ErrorIs(t, holder.CheckAccess("a", h1.ID(), nil), ErrAccessDenied)
+	manifest.Owner = h1.ID().String()
+	holder.SetManifestAccess(manifest)
+	assert.NoError(t, holder.CheckAccess("a", h1.ID(), nil))
+}
+
+func TestChunkAccessSharedChunks(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	cs := NewChunkStore(h2)
+	private := &ManifestInfo{Name: "private.zap", Owner: "owner", ChunkHashes: []string{"a", "b"}, ACL: &types.AccessList{}}
+	cs.SetManifestAccess(private)
+	assert.ErrorIs(t, cs.CheckAccess("a", h1.ID(), nil), ErrAccessDenied)
+
+	// A chunk also listed by a world-readable manifest is public
+	cs.SetManifestAccess(&ManifestInfo{Name: "public.zap", Owner: "owner", ChunkHashes: []string{"a"}})
+	assert.NoError(t, cs.CheckAccess("a", h1.ID(), nil))
+	assert.ErrorIs(t, cs.CheckAccess("b", h1.ID(), nil), ErrAccessDenied)
+
+	// A new version of a manifest replaces the chunks it restricts
+	private.ChunkHashes = []string{"c"}
+	cs.SetManifestAccess(private)
+	assert.NoError(t, cs.CheckAccess("b", h1.ID(), nil))
+	assert.ErrorIs(t, cs.CheckAccess("c", h1.ID(), nil), ErrAccessDenied)
+}
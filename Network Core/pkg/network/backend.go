@@ -0,0 +1,255 @@
+package network
+
+import (
+    "container/list"
+    "errors"
+    "fmt"
+    "os"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/filemanager"
+)
+
+// By default a chunk store keeps its chunks in memory. With a backend the
+// backend holds every chunk and memory only caches the most recently used
+// ones, so a node can offer far more storage than it has RAM or disk, e.g.
+// from an S3-compatible bucket. The quota then counts what the backend
+// holds. Chunks found in the backend but stored by an earlier run are
+// served and counted once they are first read.
+
+// Chunk backend types
+const (
+    BackendMemory = "memory"
+    BackendDir    = "dir"
+    BackendS3     = "s3"
+)
+
+// DefaultHotCacheSize is the memory used to cache chunks of a backend
+const DefaultHotCacheSize = 256 * 1024 * 1024
+
+// ChunkBackend persists the chunks of a chunk store. Get returns an error
+// wrapping os.ErrNotExist for chunks it does not hold; deleting such
+// chunks succeeds.
+type ChunkBackend interface {
+    Put(hash string, data []byte) error
+    Get(hash string) ([]byte, error)
+    Delete(hash string) error
+}
+
+// BackendConfig selects where a node keeps the chunks it stores
+type BackendConfig struct {
+    Type         string               `json:"type"`           // BackendMemory, BackendDir or BackendS3
+    Dir          string               `json:"dir"`            // Directory of a dir backend
+    S3           filemanager.S3Config `json:"s3"`             // Bucket of an s3 backend
+    HotCacheSize int64                `json:"hot_cache_size"` // Bytes of chunks cached in memory, 0 for the default
+}
+
+// Validate checks that the backend is fully described
+func (c BackendConfig) Validate() error {
+    if c.HotCacheSize < 0 {
+        return fmt.Errorf("%w: hot cache size must not be negative", ErrInvalidBackendConfig)
+    }
+    switch c.Type {
+    case "", BackendMemory:
+    case BackendDir:
+        if c.Dir == "" {
+            return fmt.Errorf("%w: dir backend needs a directory", ErrInvalidBackendConfig)
+        }
+    case BackendS3:
+        if c.S3.Endpoint == "" || c.S3.Bucket == "" || c.S3.AccessKey == "" || c.S3.SecretKey == "" {
+            return fmt.Errorf("%w: s3 backend needs an endpoint, bucket and credentials", ErrInvalidBackendConfig)
+        }
+    default:
+        return fmt.Errorf("%w: unknown backend type %q", ErrInvalidBackendConfig, c.Type)
+    }
+    return nil
+}
+
+// Open creates the configured backend, nil for the memory backend
+func (c BackendConfig) Open() (ChunkBackend, error) {
+    if err := c.Validate(); err != nil {
+        return nil, err
+    }
+    switch c.Type {
+    case BackendDir:
+        return filemanager.NewDirBackend(c.Dir), nil
+    case BackendS3:
+        return filemanager.NewS3Backend(c.S3)
+    default:
+        return nil, nil
+    }
+}
+
+// SetBackend makes backend hold the store's chunks, caching up to
+// cacheSize bytes of them in memory. Chunks already in memory are written
+// to the backend. A nil backend keeps chunks in memory only.
+func (cs *ChunkStore) SetBackend(backend ChunkBackend, cacheSize int64) error {
+    if cacheSize <= 0 {
+        cacheSize = DefaultHotCacheSize
+    }
+
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+
+    if backend != nil {
+        for hash, data := range cs.chunks {
+            if err := backend.Put(hash, data); err != nil {
+                return fmt.Errorf("failed to move chunk %s to backend: %w", hash, err)
+            }
+        }
+    }
+
+    cs.backend = backend
+    cs.cacheSize = uint64(cacheSize)
+    cs.stored = make(map[string]uint64)
+    cs.storedSize = 0
+    cs.cacheOrder = list.New()
+    cs.cached = make(map[string]*list.Element)
+    if backend != nil {
+        for hash, data := range cs.chunks {
+            cs.stored[hash] = uint64(len(data))
+            cs.storedSize += uint64(len(data))
+            cs.cached[hash] = cs.cacheOrder.PushFront(hash)
+        }
+        cs.evictLocked()
+    }
+    cs.updateMetrics()
+    return nil
+}
+
+// SetChunkBackend moves the node's stored chunks to the configured backend
+func (e *NetworkEngine) SetChunkBackend(cfg BackendConfig) error {
+    backend, err := cfg.Open()
+    if err != nil {
+        return err
+    }
+    return e.chunkStore.SetBackend(backend, cfg.HotCacheSize)
+}
+
+// storeBacked writes a chunk to the backend and caches it
+func (cs *ChunkStore) storeBacked(backend ChunkBackend, hash string, data []byte) bool {
+    if err := backend.Put(hash, data); err != nil {
+        fmt.Printf("failed to store chunk %s in backend: %v\n", hash, err)
+        return false
+    }
+
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    cs.trackLocked(hash, data)
+    cs.updateMetrics()
+    return true
+}
+
+// getBacked reads a chunk from the cache, or else from the backend
+func (cs *ChunkStore) getBacked(backend ChunkBackend, hash string) ([]byte, bool) {
+    cs.mu.Lock()
+    if data, ok := cs.chunks[hash]; ok {
+        if elem, ok := cs.cached[hash]; ok {
+            cs.cacheOrder.MoveToFront(elem)
+        }
+        cs.mu.Unlock()
+        return data, true
+    }
+    cs.mu.Unlock()
+
+    data, err := backend.Get(hash)
+    if err != nil {
+        if !errors.Is(err, os.ErrNotExist) {
+            fmt.Printf("failed to read chunk %s from backend: %v\n", hash, err)
+        }
+        return nil, false
+    }
+
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    cs.trackLocked(hash, data)
+    cs.updateMetrics()
+    return data, true
+}
+
+// removeBacked deletes a chunk from the backend and the cache
+func (cs *ChunkStore) removeBacked(backend ChunkBackend, hash string) {
+    if err := backend.Delete(hash); err != nil {
+        fmt.Printf("failed to delete chunk %s from backend: %v\n", hash, err)
+        return
+    }
+
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    cs.storedSize -= cs.stored[hash]
+    delete(cs.stored, hash)
+    cs.uncacheLocked(hash)
+    cs.updateMetrics()
+}
+
+// trackLocked counts a chunk held by the backend and caches it. Callers
+// must hold cs.mu.
+func (cs *ChunkStore) trackLocked(hash string, data []byte) {
+    cs.storedSize -= cs.stored[hash]
+    cs.stored[hash] = uint64(len(data))
+    cs.storedSize += uint64(len(data))
+
+    cs.uncacheLocked(hash)
+    if uint64(len(data)) > cs.cacheSize {
+        return
+    }
+    cs.chunks[hash] = data
+    cs.totalSize += uint64(len(data))
+    cs.cached[hash] = cs.cacheOrder.PushFront(hash)
+    cs.evictLocked()
+}
+
+// uncacheLocked drops a chunk from the cache. Callers must hold cs.mu.
+func (cs *ChunkStore) uncacheLocked(hash string) {
+    if data, ok := cs.chunks[hash]; ok {
+        cs.totalSize -= uint64(len(data))
+        delete(cs.chunks, hash)
+    }
+    if elem, ok := cs.cached[hash]; ok {
+        cs.cacheOrder.Remove(elem)
+        delete(cs.cached, hash)
+    }
+}
+
+// evictLocked drops the least recently used chunks until the cache fits
+// its size. Callers must hold cs.mu.
+func (cs *ChunkStore) evictLocked() {
+    for cs.totalSize > cs.cacheSize && cs.cacheOrder.Len() > 0 {
+        cs.uncacheLocked(cs.cacheOrder.Back().Value.(string))
+    }
+}
+
+// usedLocked returns the bytes stored, counting what the backend holds
+// rather than the cache. Callers must hold cs.mu.
+func (cs *ChunkStore) usedLocked() uint64 {
+    if cs.backend != nil {
+        return cs.storedSize
+    }
+    return cs.totalSize
+}
+
+// holdsLocked reports whether the store holds a chunk. Callers must hold
+// cs.mu.
+func (cs *ChunkStore) holdsLocked(hash string) bool {
+    if cs.backend != nil {
+        _, ok := cs.stored[hash]
+        return ok
+    }
+    _, ok := cs.chunks[hash]
+    return ok
+}
+
+// heldLocked returns the hashes of the chunks the store holds. Callers
+// must hold cs.mu.
+func (cs *ChunkStore) heldLocked() []string {
+    held := make([]string, 0, len(cs.chunks)+len(cs.stored))
+    if cs.backend != nil {
+        for hash := range cs.stored {
+            held = append(held, hash)
+        }
+        return held
+    }
+    for hash := range cs.chunks {
+        held = append(held, hash)
+    }
+    return held
+}
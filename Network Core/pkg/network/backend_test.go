@@ -0,0 +1,81 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/filemanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkBackend(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	cs := NewChunkStore(h1)
+	require.True(t, cs.Store("early", []byte("stored before the backend")))
+
+	dir := t.TempDir()
+	backend, err := BackendConfig{Type: BackendDir, Dir: dir}.Open()
+	require.NoError(t, err)
+	require.NoError(t, cs.SetBackend(backend, 100))
+
+	// Chunks held in memory moved to the backend
+	data, err := backend.Get("early")
+	require.NoError(t, err)
+	assert.Equal(t, "stored before the backend", string(data))
+
+	// Only the most recently used chunks stay cached
+	for _, hash := range []string{"a", "b", "c"} {
+		require.True(t, cs.Store(hash, bytes.Repeat([]byte(hash), 40)))
+	}
+	assert.NotContains(t, cs.chunks, "a")
+	assert.Contains(t, cs.chunks, "c")
+	assert.LessOrEqual(t, cs.totalSize, uint64(100))
+
+	// Evicted chunks are read back from the backend, and all count
+	// against the quota
+	got, ok := cs.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, bytes.Repeat([]byte("a"), 40), got)
+	assert.Contains(t, cs.chunks, "a")
+	assert.Equal(t, DefaultStorageConfig().Quota-145, cs.FreeSpace())
+	assert.NoError(t, cs.CheckAdmission(&StorageRequest{ChunkHash: "b", Size: 40}))
+	assert.ElementsMatch(t, []string{"early", "a", "b", "c"}, cs.heldLocked())
+
+	// Chunks stored by an earlier run are found in the backend
+	restarted := NewChunkStore(h2)
+	require.NoError(t, restarted.SetBackend(filemanager.NewDirBackend(dir), 0))
+	got, ok = restarted.Get("b")
+	require.True(t, ok)
+	assert.Equal(t, bytes.Repeat([]byte("b"), 40), got)
+
+	cs.Remove("a")
+	_, ok = cs.Get("a")
+	assert.False(t, ok)
+	_, ok = restarted.Get("a")
+	assert.False(t, ok)
+}
+
+func TestBackendConfig(t *testing.T) {
+	assert.NoError(t, BackendConfig{}.Validate())
+	assert.ErrorIs(t, BackendConfig{Type: BackendDir}.Validate(), ErrInvalidBackendConfig)
+	assert.ErrorIs(t, BackendConfig{Type: BackendS3, S3: filemanager.S3Config{Endpoint: "http://minio:9000"}}.Validate(), ErrInvalidBackendConfig)
+	assert.ErrorIs(t, BackendConfig{Type: "tape"}.Validate(), ErrInvalidBackendConfig)
+	assert.ErrorIs(t, BackendConfig{HotCacheSize: -1}.Validate(), ErrInvalidBackendConfig)
+
+	backend, err := BackendConfig{Type: BackendS3, S3: filemanager.S3Config{
+		Endpoint:  "http://minio:9000",
+		Bucket:    "chunks",
+		AccessKey: "key",
+		SecretKey: "secret",
+	}}.Open()
+	require.NoError(t, err)
+	assert.IsType(t, &filemanager.S3Backend{}, backend)
+
+	backend, err = BackendConfig{Type: BackendMemory}.Open()
+	require.NoError(t, err)
+	assert.Nil(t, backend)
+}
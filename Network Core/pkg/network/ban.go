@@ -0,0 +1,340 @@
+package network
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    pubsub "github.com/libp2p/go-libp2p-pubsub"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+    // BanTopic is the pubsub topic used to propagate bans
+    BanTopic = "filezap-bans"
+    // BanDuration is how long a peer stays banned after a removal vote
+    BanDuration = 7 * 24 * time.Hour
+    // banSweepInterval is how often expired bans are lifted
+    banSweepInterval = time.Minute
+)
+
+// ErrBanUnproven is returned when a ban is not backed by a passed vote
+var ErrBanUnproven = fmt.Errorf("ban is not backed by a passed removal vote")
+
+// BanEntry records a banned peer and the vote that banned it
+type BanEntry struct {
+    Peer    peer.ID    `json:"peer"`
+    Expires time.Time  `json:"expires"`
+    Reason  string     `json:"reason"`
+    Vote    *VoteState `json:"vote"`
+}
+
+// BanManager enforces passed VoteRemovePeer votes. Banned peers are
+// disconnected, refused by the peer policy at connection time and on chunk
+// streams, persisted across restarts and announced to the network.
+type BanManager struct {
+    ctx          context.Context
+    hosts        []host.Host
+    policy       *PeerPolicy
+    quorum       *QuorumManagerImpl
+    topic        *pubsub.Topic
+    subscription *pubsub.Subscription
+    gossip       GossipManager
+    bans         map[peer.ID]*BanEntry
+    path         string
+    mu           sync.RWMutex
+}
+
+// NewBanManager creates a ban manager that applies bans to the given hosts
+func NewBanManager(ctx context.Context, ps *pubsub.PubSub, quorum *QuorumManagerImpl, policy *PeerPolicy, hosts ...host.Host) (*BanManager, error) {
+    topic, err := ps.Join(BanTopic)
+    if err != nil {
+        return nil, fmt.Errorf("failed to join ban topic: %w", err)
+    }
+    subscription, err := topic.Subscribe()
+    if err != nil {
+        return nil, fmt.Errorf("failed to subscribe to ban topic: %w", err)
+    }
+
+    return &BanManager{
+        ctx:          ctx,
+        hosts:        hosts,
+        policy:       policy,
+        quorum:       quorum,
+        topic:        topic,
+        subscription: subscription,
+        bans:         make(map[peer.ID]*BanEntry),
+    }, nil
+}
+
+// Start begins consuming vote results, ban announcements and expiries
+func (bm *BanManager) Start() error {
+    go bm.consumeVotes()
+    go bm.handleAnnouncements()
+    go bm.sweepExpired()
+    return nil
+}
+
+// Stop ends the ban announcement subscription
+func (bm *BanManager) Stop() error {
+    bm.subscription.Cancel()
+    return nil
+}
+
+// EnablePersistence stores the ban list in dir and re-applies any bans that
+// have not yet expired
+func (bm *BanManager) EnablePersistence(dir string) error {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return fmt.Errorf("failed to create ban directory: %w", err)
+    }
+    path := filepath.Join(dir, "bans.json")
+
+    bm.mu.Lock()
+    bm.path = path
+    bm.mu.Unlock()
+
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to read bans: %w", err)
+    }
+
+    var entries []*BanEntry
+    if err := json.Unmarshal(data, &entries); err != nil {
+        return fmt.Errorf("failed to parse bans: %w", err)
+    }
+    for _, entry := range entries {
+        if time.Now().Before(entry.Expires) {
+            bm.apply(entry)
+        }
+    }
+    return nil
+}
+
+// IsBanned reports whether a peer is currently banned
+func (bm *BanManager) IsBanned(id peer.ID) bool {
+    bm.mu.RLock()
+    defer bm.mu.RUnlock()
+    entry, ok := bm.bans[id]
+    return ok && time.Now().Before(entry.Expires)
+}
+
+// Bans returns the active ban list
+func (bm *BanManager) Bans() []BanEntry {
+    bm.mu.RLock()
+    defer bm.mu.RUnlock()
+
+    entries := make([]BanEntry, 0, len(bm.bans))
+    for _, entry := range bm.bans {
+        entries = append(entries, *entry)
+    }
+    return entries
+}
+
+// consumeVotes bans the targets of passed removal votes
+func (bm *BanManager) consumeVotes() {
+    for {
+        select {
+        case <-bm.ctx.Done():
+            return
+        case vote := <-bm.quorum.PeerBanned():
+            state, ok := bm.quorum.VoteState(vote.ID)
+            if !ok {
+                continue
+            }
+            entry := &BanEntry{
+                Peer:    peer.ID(vote.Target),
+                Expires: time.Now().Add(BanDuration),
+                Reason:  vote.Reason,
+                Vote:    state,
+            }
+            bm.apply(entry)
+            bm.announce(entry)
+        }
+    }
+}
+
+// handleAnnouncements applies bans announced by other peers once the
+// attached vote has been verified
+func (bm *BanManager) handleAnnouncements() {
+    for {
+        msg, err := bm.subscription.Next(bm.ctx)
+        if err != nil {
+            if bm.ctx.Err() != nil {
+                return
+            }
+            continue
+        }
+
+        bm.handleEntry(msg.Data)
+    }
+}
+
+// RegisterGossip also receives and sends bans over the gossip dispatcher
+func (bm *BanManager) RegisterGossip(gm GossipManager) {
+    bm.mu.Lock()
+    bm.gossip = gm
+    bm.mu.Unlock()
+
+    gm.Subscribe(GossipBan, func(_ peer.ID, payload json.RawMessage) {
+        bm.handleEntry(payload)
+    })
+}
+
+// handleEntry applies an announced ban once its vote has been verified
+func (bm *BanManager) handleEntry(data []byte) {
+    var entry BanEntry
+    if err := json.Unmarshal(data, &entry); err != nil {
+        return
+    }
+    if bm.verify(&entry) != nil {
+        return
+    }
+    bm.apply(&entry)
+}
+
+// verify checks that a ban is backed by a passed, properly signed removal
+// vote and does not outlast the standard ban duration
+func (bm *BanManager) verify(entry *BanEntry) error {
+    if entry.Vote == nil || entry.Vote.Vote == nil {
+        return ErrBanUnproven
+    }
+    vote := entry.Vote.Vote
+    if vote.Type != VoteRemovePeer || peer.ID(vote.Target) != entry.Peer {
+        return fmt.Errorf("%w: vote does not target %s", ErrBanUnproven, entry.Peer)
+    }
+    if entry.Expires.After(vote.Timestamp.Add(BanDuration + MaxVotingTimeout)) {
+        return fmt.Errorf("%w: ban outlasts its vote", ErrBanUnproven)
+    }
+    return verifyPassedVote(entry.Vote, bm.quorum.Rules())
+}
+
+// verifyPassedVote checks the proposer and voter signatures on a vote and
+// recomputes that it passed under the given rules
+func verifyPassedVote(state *VoteState, rules QuorumConfig) error {
+    if err := verifyVoteSignature(state.Vote); err != nil {
+        return err
+    }
+
+    valid := make(map[peer.ID]*VoteResponse, len(state.Responses))
+    for voter, resp := range state.Responses {
+        if resp == nil || resp.Voter != voter || resp.VoteID != state.Vote.ID {
+            continue
+        }
+        if verifyVoteResponseSignature(resp) != nil {
+            continue
+        }
+        valid[voter] = resp
+    }
+    if len(valid) < rules.MinQuorumSize {
+        return fmt.Errorf("%w: only %d valid responses", ErrBanUnproven, len(valid))
+    }
+
+    total, approval := tallyResponses(valid, rules)
+    if total == 0 || approval*100/total < rules.MinVotingPercentage {
+        return fmt.Errorf("%w: vote did not pass", ErrBanUnproven)
+    }
+    return nil
+}
+
+// apply records a ban, refuses the peer and drops existing connections
+func (bm *BanManager) apply(entry *BanEntry) {
+    bm.mu.Lock()
+    if existing, ok := bm.bans[entry.Peer]; ok && !entry.Expires.After(existing.Expires) {
+        bm.mu.Unlock()
+        return
+    }
+    bm.bans[entry.Peer] = entry
+    bm.saveLocked()
+    bm.mu.Unlock()
+
+    bm.policy.Deny(entry.Peer)
+    for _, h := range bm.hosts {
+        h.Network().ClosePeer(entry.Peer)
+    }
+}
+
+// announce publishes a ban to the network
+func (bm *BanManager) announce(entry *BanEntry) {
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return
+    }
+    if err := bm.topic.Publish(bm.ctx, data); err != nil {
+        fmt.Printf("failed to announce ban: %v\n", err)
+    }
+
+    bm.mu.RLock()
+    gm := bm.gossip
+    bm.mu.RUnlock()
+    if gm != nil {
+        if err := gm.Publish(GossipBan, entry); err != nil {
+            fmt.Printf("failed to gossip ban: %v\n", err)
+        }
+    }
+}
+
+// sweepExpired lifts bans once they expire
+func (bm *BanManager) sweepExpired() {
+    ticker := time.NewTicker(banSweepInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-bm.ctx.Done():
+            return
+        case <-ticker.C:
+            bm.liftExpired()
+        }
+    }
+}
+
+// liftExpired removes expired bans from the list and the peer policy
+func (bm *BanManager) liftExpired() {
+    bm.mu.Lock()
+    defer bm.mu.Unlock()
+
+    now := time.Now()
+    changed := false
+    for id, entry := range bm.bans {
+        if now.After(entry.Expires) {
+            delete(bm.bans, id)
+            bm.policy.Undeny(id)
+            changed = true
+        }
+    }
+    if changed {
+        bm.saveLocked()
+    }
+}
+
+// saveLocked writes the ban list to disk. Callers must hold bm.mu.
+func (bm *BanManager) saveLocked() {
+    if bm.path == "" {
+        return
+    }
+
+    entries := make([]*BanEntry, 0, len(bm.bans))
+    for _, entry := range bm.bans {
+        entries = append(entries, entry)
+    }
+    data, err := json.Marshal(entries)
+    if err != nil {
+        return
+    }
+    tmp := bm.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        fmt.Printf("failed to save bans: %v\n", err)
+        return
+    }
+    if err := os.Rename(tmp, bm.path); err != nil {
+        fmt.Printf("failed to save bans: %v\n", err)
+    }
+}
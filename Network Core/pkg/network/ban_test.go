@@ -0,0 +1,101 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanManager(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	rules := DefaultQuorumConfig()
+	rules.MinQuorumSize = 3
+	ps, err := pubsub.NewGossipSub(ctx, h1)
+	require.NoError(t, err)
+	qm, err := newQuorumManagerImpl(ctx, h1, ps, nil, rules)
+	require.NoError(t, err)
+
+	policy := NewPeerPolicy(SecurityConfig{})
+	bm, err := NewBanManager(ctx, ps, qm, policy, h1)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, bm.EnablePersistence(dir))
+
+	// Retarget a passed vote at h2 and re-sign it
+	priv, proposer := newTestKey(t)
+	state := newFinishedVote(t, 3)
+	state.Vote.Target = string(h2.ID())
+	state.Vote.Proposer = proposer
+	state.Vote.Timestamp = time.Now()
+	require.NoError(t, SignVote(state.Vote, priv, VotingTimeout))
+	for voter, resp := range state.Responses {
+		delete(state.Responses, voter)
+		vpriv, id := newTestKey(t)
+		resp.Voter = id
+		resp.VoteID = state.Vote.ID
+		require.NoError(t, SignVoteResponse(resp, vpriv, VotingTimeout))
+		state.Responses[id] = resp
+	}
+
+	entry := &BanEntry{Peer: h2.ID(), Expires: time.Now().Add(BanDuration), Vote: state}
+	require.NoError(t, bm.verify(entry))
+
+	require.Equal(t, network.Connected, h1.Network().Connectedness(h2.ID()))
+	bm.apply(entry)
+	assert.True(t, bm.IsBanned(h2.ID()))
+	assert.False(t, policy.IsAllowed(h2.ID()))
+	assert.NotEqual(t, network.Connected, h1.Network().Connectedness(h2.ID()))
+
+	// The ban survives a restart
+	policy2 := NewPeerPolicy(SecurityConfig{})
+	ps2, err := pubsub.NewGossipSub(ctx, h2)
+	require.NoError(t, err)
+	bm2, err := NewBanManager(ctx, ps2, qm, policy2)
+	require.NoError(t, err)
+	require.NoError(t, bm2.EnablePersistence(dir))
+	assert.True(t, bm2.IsBanned(h2.ID()))
+	assert.False(t, policy2.IsAllowed(h2.ID()))
+
+	// Expired bans are lifted
+	bm2.mu.Lock()
+	bm2.bans[h2.ID()].Expires = time.Now().Add(-time.Second)
+	bm2.mu.Unlock()
+	bm2.liftExpired()
+	assert.False(t, bm2.IsBanned(h2.ID()))
+	assert.True(t, policy2.IsAllowed(h2.ID()))
+}
+
+func TestBanVerification(t *testing.T) {
+	rules := DefaultQuorumConfig()
+	rules.MinQuorumSize = 3
+
+	// A vote that did not pass cannot back a ban
+	rejected := newFinishedVote(t, 1)
+	assert.ErrorIs(t, verifyPassedVote(rejected, rules), ErrBanUnproven)
+
+	// Forged responses are ignored, leaving too few to form a quorum
+	passed := newFinishedVote(t, 3)
+	for _, resp := range passed.Responses {
+		resp.Signature = []byte("forged")
+		break
+	}
+	assert.ErrorIs(t, verifyPassedVote(passed, rules), ErrBanUnproven)
+
+	// Ban entries must target the vote's peer
+	bm := &BanManager{}
+	valid := newFinishedVote(t, 3)
+	entry := &BanEntry{Peer: peer.ID("other"), Expires: time.Now().Add(time.Hour), Vote: valid}
+	assert.ErrorIs(t, bm.verify(entry), ErrBanUnproven)
+}
@@ -0,0 +1,156 @@
+package network
+
+import (
+    "fmt"
+    "time"
+)
+
+const (
+    // StorageAdvertiseInterval is how often a storage node re-announces its
+    // capacity
+    StorageAdvertiseInterval = time.Minute
+
+    // StorageProcessInterval is how often a storage node stores the chunks
+    // peers have queued with it
+    StorageProcessInterval = 5 * time.Second
+
+    // nodeVersion is advertised in StorageNodeInfo
+    nodeVersion = "0.1.0"
+)
+
+// SetStorageConfig sets the storage offer enforced on incoming requests
+func (cs *ChunkStore) SetStorageConfig(cfg StorageConfig) error {
+    if err := cfg.Validate(); err != nil {
+        return err
+    }
+
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    cs.offer = cfg
+    return nil
+}
+
+// SetStorageConfig changes the storage offer of a running node. A
+// registered storage node announces the new offer right away.
+func (e *NetworkEngine) SetStorageConfig(cfg StorageConfig) error {
+    if err := e.chunkStore.SetStorageConfig(cfg); err != nil {
+        return err
+    }
+
+    e.storageMu.Lock()
+    registered := e.advertising != nil
+    e.storageMu.Unlock()
+    if registered {
+        return e.gossipMgr.AnnounceStorageNode(e.storageInfo())
+    }
+    return nil
+}
+
+// SetReplicationGoal changes the number of replicas placed for uploads
+// whose manifest sets no goal
+func (e *NetworkEngine) SetReplicationGoal(goal int) error {
+    if goal < 1 {
+        return fmt.Errorf("replication goal must be at least 1")
+    }
+    e.storageMu.Lock()
+    defer e.storageMu.Unlock()
+    e.config.ReplicationGoal = goal
+    return nil
+}
+
+// defaultReplicationGoal returns the goal for manifests that set none
+func (e *NetworkEngine) defaultReplicationGoal() int {
+    e.storageMu.Lock()
+    defer e.storageMu.Unlock()
+    if e.config == nil || e.config.ReplicationGoal <= 0 {
+        return DefaultReplicationGoal
+    }
+    return e.config.ReplicationGoal
+}
+
+// FreeSpace returns the bytes still available under the quota, counting
+// requests that are queued but not yet stored
+func (cs *ChunkStore) FreeSpace() int64 {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+    return cs.freeSpaceLocked()
+}
+
+// freeSpaceLocked computes FreeSpace. Callers must hold cs.mu.
+func (cs *ChunkStore) freeSpaceLocked() int64 {
+    free := cs.offer.Quota - int64(cs.usedLocked()) - cs.requests.Bytes()
+    if free < 0 {
+        return 0
+    }
+    return free
+}
+
+// CheckAdmission reports whether a storage request fits the node's offer:
+// its chunk size must be within the accepted range and it must fit under the
+// quota alongside everything stored and queued
+func (cs *ChunkStore) CheckAdmission(req *StorageRequest) error {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+
+    if req.Size < cs.offer.MinChunkSize || req.Size > cs.offer.MaxChunkSize {
+        return fmt.Errorf("%w: %d bytes, accepting %d to %d",
+            ErrChunkSizeRange, req.Size, cs.offer.MinChunkSize, cs.offer.MaxChunkSize)
+    }
+    if cs.holdsLocked(req.ChunkHash) {
+        return nil
+    }
+    if free := cs.freeSpaceLocked(); req.Size > free {
+        return fmt.Errorf("%w: %d bytes requested, %d free", ErrQuotaExceeded, req.Size, free)
+    }
+    return nil
+}
+
+// storageInfo describes this node's current storage offer
+func (e *NetworkEngine) storageInfo() *StorageNodeInfo {
+    e.chunkStore.mu.RLock()
+    offer := e.chunkStore.offer
+    e.chunkStore.mu.RUnlock()
+
+    var region string
+    if e.config != nil {
+        region = e.config.Locality.Region
+    }
+
+    return &StorageNodeInfo{
+        ID:             e.transportHost.ID().String(),
+        AvailableSpace: e.chunkStore.FreeSpace(),
+        TotalSpace:     offer.Quota,
+        Uptime:         100.0, // TODO: Calculate actual uptime
+        Version:        nodeVersion,
+        Location:       region,
+        Quota:          offer.Quota,
+        MinChunkSize:   offer.MinChunkSize,
+        MaxChunkSize:   offer.MaxChunkSize,
+        Price:          offer.Price,
+    }
+}
+
+// advertiseStorage periodically re-announces this node's capacity and
+// stores the chunks queued with it until the engine stops or the node
+// unregisters
+func (e *NetworkEngine) advertiseStorage(done <-chan struct{}) {
+    ticker := time.NewTicker(StorageAdvertiseInterval)
+    defer ticker.Stop()
+    process := time.NewTicker(StorageProcessInterval)
+    defer process.Stop()
+
+    for {
+        select {
+        case <-e.ctx.Done():
+            return
+        case <-done:
+            return
+        case <-ticker.C:
+            if err := e.gossipMgr.AnnounceStorageNode(e.storageInfo()); err != nil {
+                fmt.Printf("failed to advertise storage: %v\n", err)
+            }
+        case <-process.C:
+            e.chunkStore.ProcessPending()
+        }
+    }
+}
@@ -0,0 +1,66 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageAdmission(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	cs := NewChunkStore(h1)
+	assert.ErrorIs(t, cs.SetStorageConfig(StorageConfig{Quota: 100, MinChunkSize: 10, MaxChunkSize: 5}), ErrInvalidStorageConfig)
+	require.NoError(t, cs.SetStorageConfig(StorageConfig{Quota: 100, MinChunkSize: 10, MaxChunkSize: 60}))
+
+	request := func(hash string, size int) *StorageRequest {
+		return &StorageRequest{ChunkHash: hash, Data: make([]byte, size), Size: int64(size)}
+	}
+
+	// Chunks outside the accepted size range are refused
+	assert.ErrorIs(t, cs.queueRequest(request("tiny", 5)), ErrChunkSizeRange)
+	assert.ErrorIs(t, cs.queueRequest(request("huge", 70)), ErrChunkSizeRange)
+
+	// Queued requests count against the quota
+	require.NoError(t, cs.queueRequest(request("a", 60)))
+	assert.Equal(t, int64(40), cs.FreeSpace())
+	assert.ErrorIs(t, cs.queueRequest(request("b", 50)), ErrQuotaExceeded)
+
+	// As do stored chunks once the request is served
+	req, err := cs.GetPendingRequest()
+	require.NoError(t, err)
+	require.True(t, cs.Store(req.ChunkHash, req.Data))
+	assert.Equal(t, int64(40), cs.FreeSpace())
+	assert.ErrorIs(t, cs.CheckAdmission(request("b", 50)), ErrQuotaExceeded)
+	assert.NoError(t, cs.CheckAdmission(request("c", 40)))
+}
+
+func TestEngineTunables(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	e := &NetworkEngine{
+		config:        DefaultNetworkConfig(),
+		transportHost: h1,
+		chunkStore:    NewChunkStore(h1),
+	}
+
+	// The offer changes without restarting; invalid offers are refused
+	offer := DefaultStorageConfig()
+	offer.Quota = 1024
+	require.NoError(t, e.SetStorageConfig(offer))
+	assert.Equal(t, int64(1024), e.storageInfo().Quota)
+	offer.Quota = -1
+	assert.ErrorIs(t, e.SetStorageConfig(offer), ErrInvalidStorageConfig)
+	assert.Equal(t, int64(1024), e.storageInfo().Quota)
+
+	assert.Equal(t, DefaultReplicationGoal, e.defaultReplicationGoal())
+	require.NoError(t, e.SetReplicationGoal(5))
+	assert.Equal(t, 5, e.defaultReplicationGoal())
+	assert.Error(t, e.SetReplicationGoal(0))
+	assert.Equal(t, 5, e.defaultReplicationGoal())
+}
@@ -1,24 +1,30 @@
 package network
 
 import (
-    "bytes"
+    "container/list"
     "context"
     "crypto/sha256"
+    "encoding/hex"
     "fmt"
     "io"
     "sync"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/bufpool"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/network"
     "github.com/libp2p/go-libp2p/core/peer"
     "github.com/libp2p/go-libp2p/core/protocol"
     quic "github.com/quic-go/quic-go"
+    "lukechampine.com/blake3"
 )
 
 // Protocol identifiers
 const (
     chunkProtocol = "/filezap/chunk/1.0.0"
+    storeProtocol = "/filezap/store/1.0.0"
+    haveProtocol  = "/filezap/have/1.0.0"
 )
 
 // ChunkStore manages chunk storage
@@ -27,14 +33,31 @@ type ChunkStore struct {
     chunks    map[string][]byte
     totalSize uint64
     transfers *TransferManager
-    requests  chan *StorageRequest
+    requests  *RequestQueue
+    keys      *idempotencyKeys // Keys of storage requests in flight or accepted
+    offer     StorageConfig
+    policy    *PeerPolicy
+    gossip    GossipManager
+    access    map[string]map[string]chunkAccess // Chunk hash to manifest name to restriction
     mu        sync.RWMutex
+
+    manifestChunks map[string][]string // Chunks each manifest in access lists
+
+    // With a backend, chunks holds the cached chunks, see SetBackend
+    backend    ChunkBackend
+    cacheSize  uint64
+    stored     map[string]uint64 // Sizes of the chunks the backend holds
+    storedSize uint64
+    cacheOrder *list.List // Cached hashes, most recently used first
+    cached     map[string]*list.Element
 }
 
 // TransferManager handles QUIC-based chunk transfers
 type TransferManager struct {
     host     host.Host
     sessions map[peer.ID]*quic.Connection
+    policy   *PeerPolicy
+    gossip   GossipManager // Counts the chunks received from each peer
     mu       sync.RWMutex
 }
 
@@ -53,33 +76,116 @@ func NewChunkStore(host host.Host) *ChunkStore {
         host:      host,
         chunks:    make(map[string][]byte),
         transfers: NewTransferManager(host),
-        requests:  make(chan *StorageRequest, 100),
+        requests:  NewRequestQueue(DefaultRequestQueueSize),
+        keys:      newIdempotencyKeys(),
+        offer:     DefaultStorageConfig(),
     }
 
     // Set up chunk protocol handler
     host.SetStreamHandler(protocol.ID(chunkProtocol), cs.handleChunkStream)
+    host.SetStreamHandler(protocol.ID(storeProtocol), cs.handleStoreStream)
+    host.SetStreamHandler(protocol.ID(haveProtocol), cs.handleHaveStream)
     return cs
 }
 
-// GetPendingRequest gets the next pending storage request
+// SetPeerPolicy applies a peer policy to incoming and outgoing chunk streams
+func (cs *ChunkStore) SetPeerPolicy(policy *PeerPolicy) {
+    cs.mu.Lock()
+    cs.policy = policy
+    cs.mu.Unlock()
+
+    cs.transfers.mu.Lock()
+    cs.transfers.policy = policy
+    cs.transfers.mu.Unlock()
+}
+
+// RegisterGossip notifies the network through gm when storage requests are
+// rejected, and counts the chunks exchanged with each peer in gm
+func (cs *ChunkStore) RegisterGossip(gm GossipManager) {
+    cs.mu.Lock()
+    cs.gossip = gm
+    cs.mu.Unlock()
+
+    cs.transfers.mu.Lock()
+    cs.transfers.gossip = gm
+    cs.transfers.mu.Unlock()
+}
+
+// EnableRequestPersistence keeps pending storage requests in dir so they
+// survive restarts
+func (cs *ChunkStore) EnableRequestPersistence(dir string) error {
+    if err := cs.requests.EnablePersistence(dir); err != nil {
+        return err
+    }
+
+    // Retries of requests queued before a restart are still duplicates
+    for _, req := range cs.requests.Pending() {
+        if key := requestKey(req); key != "" {
+            cs.keys.begin(key, req.ChunkHash)
+            cs.keys.accept(key)
+        }
+    }
+    return nil
+}
+
+// GetPendingRequest gets the highest priority pending storage request
 func (cs *ChunkStore) GetPendingRequest() (*StorageRequest, error) {
-    select {
-    case req := <-cs.requests:
-        return req, nil
-    default:
+    req, ok := cs.requests.Pop()
+    if !ok {
         return nil, ErrNoRequestsPending
     }
+    return req, nil
+}
+
+// queueRequest queues an incoming storage request, rejecting it if it does
+// not fit the node's offer, or rejecting it or a lower priority request it
+// displaces when the queue is full
+func (cs *ChunkStore) queueRequest(req *StorageRequest) error {
+    if err := cs.CheckAdmission(req); err != nil {
+        cs.rejectRequest(req, err.Error())
+        return err
+    }
+
+    evicted, err := cs.requests.Push(req)
+    if err != nil {
+        cs.rejectRequest(req, RejectQueueFull)
+        return err
+    }
+    if evicted != nil {
+        cs.rejectRequest(evicted, RejectEvicted)
+    }
+    return nil
+}
+
+// rejectRequest tells the network a storage request will not be served,
+// and forgets its idempotency key so a retry is judged afresh
+func (cs *ChunkStore) rejectRequest(req *StorageRequest, reason string) {
+    cs.keys.release(requestKey(req))
+
+    cs.mu.RLock()
+    gm := cs.gossip
+    cs.mu.RUnlock()
+    if gm == nil {
+        return
+    }
+    if err := gm.NotifyStorageRejection(withoutData(req), reason); err != nil {
+        fmt.Printf("failed to notify storage rejection: %v\n", err)
+    }
 }
 
 // isValidChunk validates chunk metadata
 func isValidChunk(hash string, data []byte) bool {
-    // Check for empty hash
-    if len(hash) == 0 {
+    // Check for nil data
+    if data == nil {
         return false
     }
+    return isValidChunkHash(hash)
+}
 
-    // Check for nil data
-    if data == nil {
+// isValidChunkHash checks that a chunk hash is non-empty valid UTF-8
+func isValidChunkHash(hash string) bool {
+    // Check for empty hash
+    if len(hash) == 0 {
         return false
     }
 
@@ -99,14 +205,21 @@ func (cs *ChunkStore) Store(hash string, data []byte) bool {
         return false
     }
 
-    cs.mu.Lock()
-    defer cs.mu.Unlock()
-
     // Check chunk size limit
     if len(data) > maxChunkSize {
         return false
     }
 
+    cs.mu.RLock()
+    backend := cs.backend
+    cs.mu.RUnlock()
+    if backend != nil {
+        return cs.storeBacked(backend, hash, data)
+    }
+
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+
     // Check if we need to evict chunks to make space
     for cs.totalSize+uint64(len(data)) > maxTotalSize && len(cs.chunks) > 0 {
         // Remove oldest chunk (first one we find)
@@ -121,31 +234,56 @@ func (cs *ChunkStore) Store(hash string, data []byte) bool {
     if cs.totalSize+uint64(len(data)) <= maxTotalSize {
         cs.chunks[hash] = data
         cs.totalSize += uint64(len(data))
+        cs.updateMetrics()
         return true
     }
 
+    cs.updateMetrics()
     return false
 }
 
 // Get retrieves a chunk from the local store
 func (cs *ChunkStore) Get(hash string) ([]byte, bool) {
     cs.mu.RLock()
-    defer cs.mu.RUnlock()
     data, ok := cs.chunks[hash]
+    backend := cs.backend
+    cs.mu.RUnlock()
+    if backend != nil {
+        return cs.getBacked(backend, hash)
+    }
     return data, ok
 }
 
 // Remove deletes a chunk from the store
 func (cs *ChunkStore) Remove(hash string) {
+    cs.mu.RLock()
+    backend := cs.backend
+    cs.mu.RUnlock()
+    if backend != nil {
+        cs.removeBacked(backend, hash)
+        return
+    }
+
     cs.mu.Lock()
     defer cs.mu.Unlock()
 
     if data, exists := cs.chunks[hash]; exists {
         cs.totalSize -= uint64(len(data))
         delete(cs.chunks, hash)
+        cs.updateMetrics()
     }
 }
 
+// updateMetrics publishes the store size. Callers must hold cs.mu.
+func (cs *ChunkStore) updateMetrics() {
+    metrics.ChunkStoreBytes.Set(float64(cs.usedLocked()))
+    if cs.backend != nil {
+        metrics.ChunkStoreChunks.Set(float64(len(cs.stored)))
+        return
+    }
+    metrics.ChunkStoreChunks.Set(float64(len(cs.chunks)))
+}
+
 // handleChunkStream handles incoming chunk requests
 func (cs *ChunkStore) handleChunkStream(stream network.Stream) {
     defer func() {
@@ -155,15 +293,35 @@ func (cs *ChunkStore) handleChunkStream(stream network.Stream) {
         }
     }()
 
+    cs.mu.RLock()
+    policy := cs.policy
+    gossip := cs.gossip
+    cs.mu.RUnlock()
+    if policy != nil {
+        if err := policy.CheckStream(stream); err != nil {
+            stream.Reset()
+            return
+        }
+    }
+
     // Read chunk hash with timeout
     stream.SetDeadline(time.Now().Add(10 * time.Second))
-    buf := make([]byte, 64)
+    buf := bufpool.Get(64)
     n, err := stream.Read(buf)
+    hash := string(buf[:n])
+    bufpool.Put(buf)
     if err != nil {
         stream.Reset()
         return
     }
-    hash := string(buf[:n])
+
+    // Restricted chunks are only served to peers their manifests allow
+    if err := cs.CheckAccess(hash, stream.Conn().RemotePeer(), stream.Conn().RemotePublicKey()); err != nil {
+        if _, err := stream.Write(append([]byte{0}, "access denied"...)); err != nil {
+            stream.Reset()
+        }
+        return
+    }
 
     // Get chunk data
     data, ok := cs.Get(hash)
@@ -193,15 +351,45 @@ func (cs *ChunkStore) handleChunkStream(stream network.Stream) {
         if end > len(data) {
             end = len(data)
         }
-        if _, err := stream.Write(data[i:end]); err != nil {
+        n, err := stream.Write(data[i:end])
+        metrics.ChunkTransferBytes.WithLabelValues("upload").Add(float64(n))
+        if err != nil {
+            metrics.ChunkTransfers.WithLabelValues("upload", "error").Inc()
             stream.Reset()
             return
         }
     }
+    metrics.ChunkTransfers.WithLabelValues("upload", "success").Inc()
+    if gossip != nil {
+        gossip.RecordChunkServed(stream.Conn().RemotePeer(), len(data))
+    }
 }
 
 // Download downloads a chunk from a peer
 func (tm *TransferManager) Download(from peer.ID, hash string) ([]byte, error) {
+    start := time.Now()
+    data, err := tm.download(from, hash)
+    metrics.ChunkTransferDuration.Observe(time.Since(start).Seconds())
+
+    tm.mu.RLock()
+    gossip := tm.gossip
+    tm.mu.RUnlock()
+    if err != nil {
+        metrics.ChunkTransfers.WithLabelValues("download", "error").Inc()
+        if gossip != nil {
+            gossip.RecordFailure(from)
+        }
+        return nil, err
+    }
+    metrics.ChunkTransfers.WithLabelValues("download", "success").Inc()
+    if gossip != nil {
+        gossip.RecordChunkReceived(from, len(data))
+    }
+    return data, nil
+}
+
+// download performs a single chunk request over a new stream
+func (tm *TransferManager) download(from peer.ID, hash string) ([]byte, error) {
     if tm.host == nil {
         return nil, fmt.Errorf("transfer manager not initialized")
     }
@@ -226,6 +414,15 @@ func (tm *TransferManager) Download(from peer.ID, hash string) ([]byte, error) {
         stream.Close()
     }()
 
+    tm.mu.RLock()
+    policy := tm.policy
+    tm.mu.RUnlock()
+    if policy != nil {
+        if err := policy.CheckStream(stream); err != nil {
+            return nil, fmt.Errorf("refusing chunk stream: %w", err)
+        }
+    }
+
     // Set a short deadline for initial operations
     stream.SetDeadline(time.Now().Add(5 * time.Second))
 
@@ -251,7 +448,8 @@ func (tm *TransferManager) Download(from peer.ID, hash string) ([]byte, error) {
 
     // Read chunk data with shorter timeouts to detect disconnections faster
     var data []byte
-    buf := make([]byte, 1024*1024) // 1MB buffer
+    buf := bufpool.Get(1024 * 1024) // 1MB buffer
+    defer bufpool.Put(buf)
     for {
         // Set a shorter deadline for each read operation
         stream.SetDeadline(time.Now().Add(2 * time.Second))
@@ -271,51 +469,38 @@ func (tm *TransferManager) Download(from peer.ID, hash string) ([]byte, error) {
             }
             return nil, fmt.Errorf("failed to read chunk: %w", err)
         }
+        metrics.ChunkTransferBytes.WithLabelValues("download").Add(float64(n))
         data = append(data, buf[:n]...)
     }
 
     return data, nil
 }
 
-// ChunkValidationEvidence contains proof of chunk validation failure
+// ChunkValidationEvidence contains proof of chunk validation failure. Data
+// holds the bytes the provider served so voters can repeat the validation.
 type ChunkValidationEvidence struct {
     ChunkHash    string            `json:"chunk_hash"`
     Provider     peer.ID           `json:"provider"`
     FailureType  ValidationResult  `json:"failure_type"`
+    Data         []byte            `json:"data,omitempty"`
 }
 
 // Marshal converts evidence to bytes for network transmission
 func (e *ChunkValidationEvidence) Marshal() ([]byte, error) {
-    buf := bytes.NewBuffer(nil)
-    
-    // Write chunk hash
-    if _, err := buf.WriteString(e.ChunkHash); err != nil {
-        return nil, err
-    }
-    
-    // Write provider ID
-    if _, err := buf.Write([]byte(e.Provider)); err != nil {
-        return nil, err
-    }
-    
-    // Write failure type
-    if err := buf.WriteByte(byte(e.FailureType)); err != nil {
-        return nil, err
-    }
-    
-    return buf.Bytes(), nil
+    return EncodeEvidence(e)
 }
 
 // Unmarshal parses evidence from bytes
 func (e *ChunkValidationEvidence) Unmarshal(data []byte) error {
-    if len(data) < 65 { // Minimum length for hash(32) + peerID(32) + failureType(1)
-        return fmt.Errorf("evidence data too short")
+    ev, err := DecodeEvidence(data)
+    if err != nil {
+        return err
     }
-
-    e.ChunkHash = string(data[:32])
-    e.Provider = peer.ID(data[32:64])
-    e.FailureType = ValidationResult(data[64])
-
+    chunkEv, ok := ev.(*ChunkValidationEvidence)
+    if !ok {
+        return fmt.Errorf("%w: expected %s, got %s", ErrUnknownEvidence, EvidenceBadChunk, ev.Kind())
+    }
+    *e = *chunkEv
     return nil
 }
 
@@ -328,6 +513,9 @@ type ChunkValidator struct {
     // Cache of recently validated chunks to prevent duplicate work
     cache      map[string]ValidationResult
     cacheSize  int
+
+    // Latest bad chunk each provider served, kept as evidence for reports
+    badChunks  map[peer.ID]*ChunkValidationEvidence
     mu         sync.RWMutex
 }
 
@@ -339,6 +527,7 @@ func NewChunkValidator(ctx context.Context, quorum QuorumManager, store *ChunkSt
         store:     store,
         cache:     make(map[string]ValidationResult),
         cacheSize: 1000, // Cache size limit
+        badChunks: make(map[peer.ID]*ChunkValidationEvidence),
     }
 }
 
@@ -350,23 +539,22 @@ func (cv *ChunkValidator) ValidateChunk(chunk []byte, expectedHash string, provi
     }
 
     // Validate chunk hash
-    actualHash := cv.calculateHash(chunk)
-    if actualHash != expectedHash {
-        cv.reportBadChunk(provider, expectedHash, ValidationHashMismatch)
+    if !chunkHashMatches(chunk, expectedHash) {
+        cv.reportBadChunk(provider, expectedHash, chunk, ValidationHashMismatch)
         cv.cacheResult(expectedHash, ValidationHashMismatch)
         return ValidationHashMismatch
     }
 
     // Validate chunk size
     if !cv.validateChunkSize(chunk) {
-        cv.reportBadChunk(provider, expectedHash, ValidationSizeMismatch)
+        cv.reportBadChunk(provider, expectedHash, chunk, ValidationSizeMismatch)
         cv.cacheResult(expectedHash, ValidationSizeMismatch)
         return ValidationSizeMismatch
     }
 
     // Validate chunk content format
     if !cv.validateChunkFormat(chunk) {
-        cv.reportBadChunk(provider, expectedHash, ValidationContentMalformed)
+        cv.reportBadChunk(provider, expectedHash, chunk, ValidationContentMalformed)
         cv.cacheResult(expectedHash, ValidationContentMalformed)
         return ValidationContentMalformed
     }
@@ -376,10 +564,16 @@ func (cv *ChunkValidator) ValidateChunk(chunk []byte, expectedHash string, provi
     return ValidationSuccess
 }
 
-// calculateHash generates the SHA-256 hash of chunk data
-func (cv *ChunkValidator) calculateHash(chunk []byte) string {
-    hash := sha256.Sum256(chunk)
-    return fmt.Sprintf("%x", hash[:])
+// chunkHashMatches reports whether chunk hashes to expectedHash under
+// BLAKE3, which new files name their chunks by, or SHA-256, which older
+// files use
+func chunkHashMatches(chunk []byte, expectedHash string) bool {
+    b3 := blake3.Sum256(chunk)
+    if hex.EncodeToString(b3[:]) == expectedHash {
+        return true
+    }
+    sha := sha256.Sum256(chunk)
+    return hex.EncodeToString(sha[:]) == expectedHash
 }
 
 // validateChunkSize checks if the chunk size is within acceptable limits
@@ -408,12 +602,20 @@ func (cv *ChunkValidator) validateChunkFormat(chunk []byte) bool {
 }
 
 // reportBadChunk notifies the quorum of a bad chunk provider
-func (cv *ChunkValidator) reportBadChunk(provider peer.ID, hash string, reason ValidationResult) {
+func (cv *ChunkValidator) reportBadChunk(provider peer.ID, hash string, chunk []byte, reason ValidationResult) {
     evidence := &ChunkValidationEvidence{
         ChunkHash:    hash,
         Provider:     provider,
         FailureType:  reason,
     }
+    // Oversized chunks are left out; voters then fall back to reputation
+    if len(chunk) <= maxEvidenceChunkSize {
+        evidence.Data = chunk
+    }
+
+    cv.mu.Lock()
+    cv.badChunks[provider] = evidence
+    cv.mu.Unlock()
 
     evidenceBytes, err := evidence.Marshal()
     if err != nil {
@@ -430,6 +632,15 @@ func (cv *ChunkValidator) reportBadChunk(provider peer.ID, hash string, reason V
     cv.quorum.UpdatePeerReputation(provider, -10) // Significant reputation penalty
 }
 
+// BadChunkEvidence returns evidence of the latest bad chunk a provider
+// served, if any
+func (cv *ChunkValidator) BadChunkEvidence(provider peer.ID) (*ChunkValidationEvidence, bool) {
+    cv.mu.RLock()
+    defer cv.mu.RUnlock()
+    evidence, ok := cv.badChunks[provider]
+    return evidence, ok
+}
+
 // getCachedResult retrieves a cached validation result
 func (cv *ChunkValidator) getCachedResult(hash string) (ValidationResult, bool) {
     cv.mu.RLock()
@@ -16,7 +16,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func setupTestHosts(t *testing.T) (host.Host, host.Host) {
+func setupTestHosts(t testing.TB) (host.Host, host.Host) {
 	// Create two libp2p hosts for testing with TCP transport
 	host1, err := libp2p.New(
 		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
@@ -356,3 +356,61 @@ func TestChunkStoreConcurrentTransfers(t *testing.T) {
 	}
 }
 
+
+// benchChunkSizes are the chunk sizes the transfer benchmarks compare
+var benchChunkSizes = []int{64 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024}
+
+func BenchmarkChunkDownload(b *testing.B) {
+	host1, host2 := setupTestHosts(b)
+	defer host1.Close()
+	defer host2.Close()
+	store1 := NewChunkStore(host1)
+	store2 := NewChunkStore(host2)
+
+	for _, size := range benchChunkSizes {
+		b.Run(fmt.Sprintf("chunk=%dKiB", size/1024), func(b *testing.B) {
+			data := make([]byte, size)
+			_, err := rand.Read(data)
+			require.NoError(b, err)
+			hash := fmt.Sprintf("bench-%d", size)
+			require.True(b, store1.Store(hash, data))
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := store2.transfers.Download(host1.ID(), hash); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkChunkUpload(b *testing.B) {
+	host1, host2 := setupTestHosts(b)
+	defer host1.Close()
+	defer host2.Close()
+	store1 := NewChunkStore(host1)
+	store2 := NewChunkStore(host2)
+
+	for _, size := range benchChunkSizes {
+		b.Run(fmt.Sprintf("chunk=%dKiB", size/1024), func(b *testing.B) {
+			data := make([]byte, size)
+			_, err := rand.Read(data)
+			require.NoError(b, err)
+			req := &StorageRequest{ChunkHash: fmt.Sprintf("bench-%d", size), Data: data, Size: int64(size)}
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := store1.transfers.Upload(host2.ID(), req); err != nil {
+					b.Fatal(err)
+				}
+				// Keep the receiver's queue from filling up
+				if _, err := store2.GetPendingRequest(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,26 +1,62 @@
 package network
 
 import (
+    "fmt"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/ipfs"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/vpn"
+    "github.com/libp2p/go-libp2p/core/crypto"
     "github.com/libp2p/go-libp2p/core/peer"
 )
 
 // NetworkConfig represents the configuration for the network
 type NetworkConfig struct {
     Transport struct {
-        ListenAddrs     []string
-        ListenPort      int
-        EnableQUIC      bool
-        EnableTCP       bool
-        EnableRelay     bool
-        EnableAutoRelay bool
-        EnableHolePunch bool
-        QUICOpts        QUICOptions
+        ListenAddrs           []string // Explicit transport host listen multiaddrs, overriding ListenPort
+        MetadataListenAddrs   []string // Explicit metadata host listen multiaddrs
+        AnnounceAddrs         []string // Addresses advertised for the transport host, e.g. a port forward
+        MetadataAnnounceAddrs []string // Addresses advertised for the metadata host
+        ListenPort            int
+        EnableIPv4            bool
+        EnableIPv6            bool
+        EnableQUIC            bool
+        EnableTCP             bool
+        EnableWebSocket       bool
+        EnableWebTransport    bool
+        WebSocketPort         int // WebSocket listen port, 0 for any free port
+        EnableRelay           bool
+        EnableAutoRelay       bool
+        EnableHolePunch       bool
+        EnableRelayService    bool
+        EnableAutoNAT         bool
+        StaticRelays          []string
+        QUICOpts              QUICOptions
     }
     MetadataStore string
     ChunkCacheDir string
     VPNConfig     *VPNConfig
+    Security      SecurityConfig
+    Quorum        QuorumConfig
+    Storage       StorageConfig
+    Backend       BackendConfig // Where stored chunks are kept
+    IPFS          ipfs.Config   // Publishing chunks to IPFS and fetching them from gateways
+    Connections   ConnectionConfig
+    ManifestCache ManifestCacheConfig // Caching of manifests looked up in the DHT
+
+    // Replicas placed for uploaded files whose manifest sets no goal
+    ReplicationGoal int
+
+    // Where this node runs, gossiped to peers, and how uploads spread
+    // replicas over where storage nodes run
+    Locality  Locality
+    Placement PlacementPolicy
+
+    // Key of the transport host, so the node keeps its peer ID across
+    // restarts. Nil gives a new peer ID each run. The metadata host always
+    // uses a key of its own.
+    Identity *identity.Identity
 }
 
 // QUICOptions defines configuration for QUIC transport
@@ -31,12 +67,144 @@ type QUICOptions struct {
     IdleTimeout      time.Duration
 }
 
+// QuorumConfig defines the voting rules used by the quorum. The values are
+// the initial rules; a passed VoteUpdateRules vote replaces them at runtime.
+type QuorumConfig struct {
+    MinQuorumSize       int           `json:"min_quorum_size"`
+    VotingTimeout       time.Duration `json:"voting_timeout"`
+    MinVotingPercentage int           `json:"min_voting_percentage"`
+    BaseVoteWeight      int           `json:"base_vote_weight"`
+    StorerVoteWeight    int           `json:"storer_vote_weight"`
+}
+
+// DefaultQuorumConfig returns the default voting rules
+func DefaultQuorumConfig() QuorumConfig {
+    return QuorumConfig{
+        MinQuorumSize:       MinQuorumSize,
+        VotingTimeout:       VotingTimeout,
+        MinVotingPercentage: MinVotingPercentage,
+        BaseVoteWeight:      BaseVoteWeight,
+        StorerVoteWeight:    StorerVoteWeight,
+    }
+}
+
+// Validate checks that the voting rules are within sane bounds
+func (c QuorumConfig) Validate() error {
+    if c.MinQuorumSize < 1 {
+        return fmt.Errorf("%w: minimum quorum size must be at least 1", ErrInvalidQuorumConfig)
+    }
+    if c.VotingTimeout < MinVotingTimeout || c.VotingTimeout > MaxVotingTimeout {
+        return fmt.Errorf("%w: voting timeout must be between %v and %v", ErrInvalidQuorumConfig, MinVotingTimeout, MaxVotingTimeout)
+    }
+    if c.MinVotingPercentage <= 50 || c.MinVotingPercentage > 100 {
+        return fmt.Errorf("%w: voting percentage must be above 50 and at most 100", ErrInvalidQuorumConfig)
+    }
+    if c.BaseVoteWeight < 1 || c.StorerVoteWeight < 1 {
+        return fmt.Errorf("%w: vote weights must be at least 1", ErrInvalidQuorumConfig)
+    }
+    return nil
+}
+
+// StorageConfig defines what a storage node offers the network. It is
+// advertised in StorageNodeInfo and enforced on incoming storage requests.
+type StorageConfig struct {
+    Quota        int64 `json:"quota"`          // Bytes this node will hold
+    MinChunkSize int64 `json:"min_chunk_size"` // Smallest chunk accepted
+    MaxChunkSize int64 `json:"max_chunk_size"` // Largest chunk accepted
+    Price        int64 `json:"price"`          // Asking price per GiB stored, 0 for free
+}
+
+// DefaultStorageConfig returns the default storage offer
+func DefaultStorageConfig() StorageConfig {
+    return StorageConfig{
+        Quota:        maxTotalSize,
+        MinChunkSize: 1,
+        MaxChunkSize: maxChunkSize,
+    }
+}
+
+// Validate checks that the storage offer is usable
+func (c StorageConfig) Validate() error {
+    if c.Quota < 0 || c.Price < 0 {
+        return fmt.Errorf("%w: quota and price must not be negative", ErrInvalidStorageConfig)
+    }
+    if c.MinChunkSize < 1 || c.MaxChunkSize < c.MinChunkSize || c.MaxChunkSize > maxChunkSize {
+        return fmt.Errorf("%w: chunk size range must be within 1 and %d bytes", ErrInvalidStorageConfig, maxChunkSize)
+    }
+    return nil
+}
+
+// ConnectionConfig defines the connection manager watermarks. Once a host
+// has more than HighWater connections it closes connections, least valuable
+// peers first, until LowWater remain. Connections younger than GracePeriod
+// are never closed.
+type ConnectionConfig struct {
+    LowWater    int           `json:"low_water"`
+    HighWater   int           `json:"high_water"`
+    GracePeriod time.Duration `json:"grace_period"`
+}
+
+// DefaultConnectionConfig returns the default watermarks
+func DefaultConnectionConfig() ConnectionConfig {
+    return ConnectionConfig{
+        LowWater:    100,
+        HighWater:   400,
+        GracePeriod: time.Minute,
+    }
+}
+
+// Validate checks the watermarks are usable
+func (c ConnectionConfig) Validate() error {
+    if c.LowWater < 0 || c.HighWater < c.LowWater {
+        return fmt.Errorf("%w: watermarks must satisfy 0 <= low <= high", ErrInvalidConnectionConfig)
+    }
+    if c.GracePeriod < 0 {
+        return fmt.Errorf("%w: grace period must not be negative", ErrInvalidConnectionConfig)
+    }
+    return nil
+}
+
+// ManifestCacheConfig defines the cache of manifests looked up in the DHT.
+// Up to Size lookups are cached: found manifests for TTL and names with no
+// DHT record for NegativeTTL, 0 to not cache those.
+type ManifestCacheConfig struct {
+    Size        int           `json:"size"`
+    TTL         time.Duration `json:"ttl"`
+    NegativeTTL time.Duration `json:"negative_ttl"`
+}
+
+// DefaultManifestCacheConfig returns the default manifest cache
+func DefaultManifestCacheConfig() ManifestCacheConfig {
+    return ManifestCacheConfig{
+        Size:        1024,
+        TTL:         10 * time.Minute,
+        NegativeTTL: 15 * time.Second,
+    }
+}
+
+// Validate checks the cache size and TTLs are usable
+func (c ManifestCacheConfig) Validate() error {
+    if c.Size < 1 {
+        return fmt.Errorf("%w: size must be at least 1", ErrInvalidManifestCacheConfig)
+    }
+    if c.TTL <= 0 || c.NegativeTTL < 0 || c.NegativeTTL > c.TTL {
+        return fmt.Errorf("%w: TTLs must satisfy 0 <= negative TTL <= TTL and TTL > 0", ErrInvalidManifestCacheConfig)
+    }
+    return nil
+}
+
 // VPNConfig defines VPN configuration options
 type VPNConfig struct {
-    Enabled       bool
-    NetworkCIDR   string
-    InterfaceName string
-    NetworkKey    []byte
+    Enabled            bool
+    NetworkCIDR        string
+    InterfaceName      string
+    NetworkKey         []byte          // Pre-shared key peers must prove they hold to join
+    Allowlist          *vpn.Allowlist  // Signed list of peers admitted without the key
+    AllowlistAuthority crypto.PubKey   // Key the allowlist and credentials must be signed by
+    Credential         *vpn.Credential // Issued to this node by the authority, presented instead of the key
+    AdvertiseRoutes    []string        // Subnets this peer forwards to, e.g. a LAN or 0.0.0.0/0 as exit node
+    AcceptRoutes       bool            // Install subnet routes advertised by peers
+    ExitNodes          []peer.ID       // Peers whose default route is accepted, most preferred first
 }
 
 // VPNStatus represents the current state of VPN connections
@@ -52,17 +220,35 @@ func DefaultNetworkConfig() *NetworkConfig {
     return &NetworkConfig{
         ChunkCacheDir: "storage",
         MetadataStore: "metadata",
+        Quorum:        DefaultQuorumConfig(),
+        Storage:       DefaultStorageConfig(),
+        Connections:   DefaultConnectionConfig(),
+        ManifestCache: DefaultManifestCacheConfig(),
+
+        ReplicationGoal: DefaultReplicationGoal,
         Transport: struct {
-            ListenAddrs     []string
-            ListenPort      int
-            EnableQUIC      bool
-            EnableTCP       bool
-            EnableRelay     bool
-            EnableAutoRelay bool
-            EnableHolePunch bool
-            QUICOpts        QUICOptions
+            ListenAddrs           []string
+            MetadataListenAddrs   []string
+            AnnounceAddrs         []string
+            MetadataAnnounceAddrs []string
+            ListenPort            int
+            EnableIPv4            bool
+            EnableIPv6            bool
+            EnableQUIC            bool
+            EnableTCP             bool
+            EnableWebSocket       bool
+            EnableWebTransport    bool
+            WebSocketPort         int
+            EnableRelay           bool
+            EnableAutoRelay       bool
+            EnableHolePunch       bool
+            EnableRelayService    bool
+            EnableAutoNAT         bool
+            StaticRelays          []string
+            QUICOpts              QUICOptions
         }{
             ListenPort: 6001,
+            EnableIPv4: true,
             EnableTCP:  true,
         },
     }
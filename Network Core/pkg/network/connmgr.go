@@ -0,0 +1,75 @@
+package network
+
+import (
+    "fmt"
+
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+// PeerRole is a reason to keep a connection open under connection pressure
+type PeerRole string
+
+// Peer roles, tagged on the connection manager so that trimming closes
+// connections to peers without a role first
+const (
+    RoleStorageProvider PeerRole = "filezap-storage-provider"
+    RoleManifestReplica PeerRole = "filezap-manifest-replica"
+    RoleValidator       PeerRole = "filezap-validator"
+)
+
+// roleWeights rank roles against each other. Storage providers hold our
+// data and are the most expensive to lose.
+var roleWeights = map[PeerRole]int{
+    RoleStorageProvider: 50,
+    RoleValidator:       30,
+    RoleManifestReplica: 20,
+}
+
+// connectionOptions returns the libp2p options installing a connection
+// manager with the configured watermarks. Each host needs its own manager.
+// An unset config uses the defaults.
+func connectionOptions(cfg ConnectionConfig) ([]libp2p.Option, error) {
+    if cfg == (ConnectionConfig{}) {
+        cfg = DefaultConnectionConfig()
+    }
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+    cm, err := connmgr.NewConnManager(cfg.LowWater, cfg.HighWater, connmgr.WithGracePeriod(cfg.GracePeriod))
+    if err != nil {
+        return nil, fmt.Errorf("failed to create connection manager: %w", err)
+    }
+    return []libp2p.Option{libp2p.ConnectionManager(cm)}, nil
+}
+
+// tagPeerRole marks a peer as filling a role on the host's connection manager
+func tagPeerRole(h host.Host, id peer.ID, role PeerRole) {
+    if h == nil || id == h.ID() {
+        return
+    }
+    h.ConnManager().TagPeer(id, string(role), roleWeights[role])
+}
+
+// untagPeerRole removes a role from a peer
+func untagPeerRole(h host.Host, id peer.ID, role PeerRole) {
+    if h == nil {
+        return
+    }
+    h.ConnManager().UntagPeer(id, string(role))
+}
+
+// TagPeer marks a peer as filling a role on both hosts, protecting its
+// connections when the node trims down to its low watermark
+func (e *NetworkEngine) TagPeer(id peer.ID, role PeerRole) {
+    tagPeerRole(e.transportHost, id, role)
+    tagPeerRole(e.metadataHost, id, role)
+}
+
+// UntagPeer removes a role from a peer on both hosts
+func (e *NetworkEngine) UntagPeer(id peer.ID, role PeerRole) {
+    untagPeerRole(e.transportHost, id, role)
+    untagPeerRole(e.metadataHost, id, role)
+}
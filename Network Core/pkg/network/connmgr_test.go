@@ -0,0 +1,50 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionConfigValidate(t *testing.T) {
+	assert.NoError(t, DefaultConnectionConfig().Validate())
+	assert.ErrorIs(t, ConnectionConfig{LowWater: 10, HighWater: 5}.Validate(), ErrInvalidConnectionConfig)
+	assert.ErrorIs(t, ConnectionConfig{LowWater: -1}.Validate(), ErrInvalidConnectionConfig)
+}
+
+func TestConnectionTrimmingKeepsTaggedPeers(t *testing.T) {
+	ctx := context.Background()
+
+	opts, err := connectionOptions(ConnectionConfig{LowWater: 1, HighWater: 2})
+	require.NoError(t, err)
+	hub, err := libp2p.New(append(opts, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))...)
+	require.NoError(t, err)
+	defer hub.Close()
+
+	var peers []host.Host
+	for i := 0; i < 3; i++ {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		defer h.Close()
+		require.NoError(t, hub.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}))
+		peers = append(peers, h)
+	}
+
+	storage := peers[1].ID()
+	tagPeerRole(hub, storage, RoleStorageProvider)
+	assert.Equal(t, roleWeights[RoleStorageProvider], hub.ConnManager().GetTagInfo(storage).Value)
+
+	// Over the high watermark, untagged peers are trimmed first
+	time.Sleep(10 * time.Millisecond)
+	hub.ConnManager().TrimOpenConns(ctx)
+
+	assert.Equal(t, network.Connected, hub.Network().Connectedness(storage))
+	assert.Len(t, hub.Network().Peers(), 1)
+}
@@ -3,8 +3,11 @@ package network
 import (
     "context"
     "fmt"
+    "sync"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/ipfs"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
     "github.com/VetheonGames/FileZap/NetworkCore/pkg/vpn"
     "github.com/ipfs/go-cid"
     "github.com/libp2p/go-libp2p"
@@ -12,6 +15,7 @@ import (
     "github.com/libp2p/go-libp2p/core/peer"
     pubsub "github.com/libp2p/go-libp2p-pubsub"
     dht "github.com/libp2p/go-libp2p-kad-dht"
+    ma "github.com/multiformats/go-multiaddr"
 )
 
 var (
@@ -33,31 +37,71 @@ type NetworkEngine struct {
     validator     *ChunkValidator
     manifests     ManifestManager
     chunkStore    *ChunkStore
+    ipfs          *ipfs.Client
     vpnManager    *vpn.VPNManager
+    policy        *PeerPolicy
     dht           *dht.IpfsDHT
     pubsub        *pubsub.PubSub
+    advertising   chan struct{}
+    storageMu     sync.Mutex
 }
 
 // NewNetworkEngine creates a new network engine instance
 func NewNetworkEngine(ctx context.Context, cfg *NetworkConfig) (*NetworkEngine, error) {
+    if err := cfg.Backend.Validate(); err != nil {
+        return nil, err
+    }
+    interop, err := newIPFSClient(cfg.IPFS)
+    if err != nil {
+        return nil, err
+    }
+    policy := NewPeerPolicy(cfg.Security)
+
     // Create the transport host
-    transportHost, err := libp2p.New(
-        libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", cfg.Transport.ListenPort)),
-        libp2p.DisableRelay(),
-    )
+    var transportHost, metadataHost host.Host
+    var transportRef, metadataRef hostRef
+    transportOpts, err := transportOptions(cfg, cfg.Transport.ListenPort,
+        cfg.Transport.ListenAddrs, cfg.Transport.AnnounceAddrs, &transportRef)
+    if err != nil {
+        return nil, fmt.Errorf("invalid transport config: %w", err)
+    }
+    connOpts, err := connectionOptions(cfg.Connections)
+    if err != nil {
+        return nil, fmt.Errorf("invalid connection config: %w", err)
+    }
+    transportOpts = append(transportOpts, connOpts...)
+    if cfg.Identity != nil {
+        transportOpts = append(transportOpts, libp2p.Identity(cfg.Identity.PrivKey))
+    }
+    transportHost, err = libp2p.New(append(transportOpts, policy.hostOptions()...)...)
     if err != nil {
         return nil, fmt.Errorf("failed to create transport host: %v", err)
     }
+    transportRef.set(transportHost)
 
     // Create the metadata host (using a different port)
-    metadataHost, err := libp2p.New(
-        libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", cfg.Transport.ListenPort+1)),
-        libp2p.DisableRelay(),
-    )
+    metadataOpts, err := transportOptions(cfg, cfg.Transport.ListenPort+1,
+        cfg.Transport.MetadataListenAddrs, cfg.Transport.MetadataAnnounceAddrs, &metadataRef)
+    if err != nil {
+        transportHost.Close()
+        return nil, fmt.Errorf("invalid transport config: %w", err)
+    }
+    connOpts, err = connectionOptions(cfg.Connections)
+    if err != nil {
+        transportHost.Close()
+        return nil, fmt.Errorf("invalid connection config: %w", err)
+    }
+    metadataOpts = append(metadataOpts, connOpts...)
+    metadataHost, err = libp2p.New(append(metadataOpts, policy.hostOptions()...)...)
     if err != nil {
         transportHost.Close()
         return nil, fmt.Errorf("failed to create metadata host: %v", err)
     }
+    metadataRef.set(metadataHost)
+
+    // Chunk streams are served on the transport host under the same policy
+    chunkStore := NewChunkStore(transportHost)
+    chunkStore.SetPeerPolicy(policy)
 
     ctx, cancel := context.WithCancel(ctx)
     engine := &NetworkEngine{
@@ -68,7 +112,11 @@ func NewNetworkEngine(ctx context.Context, cfg *NetworkConfig) (*NetworkEngine,
         transportHost: transportHost,
         metadataHost: metadataHost,
         nodeID:       transportHost.ID(),
+        chunkStore:   chunkStore,
+        ipfs:         interop,
+        policy:       policy,
     }
+    go engine.collectMetrics()
 
     return engine, nil
 }
@@ -109,6 +157,16 @@ func (e *NetworkEngine) initVPN(ctx context.Context, h host.Host, cfg *VPNConfig
         NetworkCIDR:   cfg.NetworkCIDR,
         InterfaceName: cfg.InterfaceName,
         MTU:          vpn.DefaultMTU,
+        NetworkKey:   cfg.NetworkKey,
+        Allowlist:    cfg.Allowlist,
+        AllowlistAuthority: cfg.AllowlistAuthority,
+        Credential:         cfg.Credential,
+        AdvertiseRoutes:    cfg.AdvertiseRoutes,
+        AcceptRoutes:       cfg.AcceptRoutes,
+        ExitNodes:          cfg.ExitNodes,
+    }
+    if e.dht != nil {
+        vpnConfig.Claims = e.dht
     }
 
     var err error
@@ -120,6 +178,28 @@ func (e *NetworkEngine) initVPN(ctx context.Context, h host.Host, cfg *VPNConfig
     return nil
 }
 
+// collectMetrics periodically samples gauges that are not updated inline
+func (e *NetworkEngine) collectMetrics() {
+    ticker := time.NewTicker(15 * time.Second)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-e.ctx.Done():
+            return
+        case <-ticker.C:
+            if e.dht != nil {
+                metrics.DHTRoutingTableSize.Set(float64(e.dht.RoutingTable().Size()))
+            }
+        }
+    }
+}
+
+// GetPeerPolicy returns the peer admission policy shared by both hosts
+func (e *NetworkEngine) GetPeerPolicy() *PeerPolicy {
+    return e.policy
+}
+
 // GetVPNManager returns the VPN manager if enabled
 func (e *NetworkEngine) GetVPNManager() *vpn.VPNManager {
     return e.vpnManager
@@ -155,9 +235,100 @@ func (e *NetworkEngine) AddZapFile(manifest *ManifestInfo, chunks map[string][]b
         }
     }
 
+    e.replicateChunks(manifest, chunks)
+    return nil
+}
+
+// SelectStorageNodes returns up to n of the best scoring storage nodes
+// known through gossip that satisfy the constraints
+func (e *NetworkEngine) SelectStorageNodes(n int, constraints StorageConstraints) []peer.ID {
+    if e.gossipMgr == nil {
+        return nil
+    }
+    constraints.Exclude = append(constraints.Exclude, e.nodeID)
+    return e.gossipMgr.SelectStorageNodes(n, constraints)
+}
+
+// PeerStats returns what this node knows about its peers
+func (e *NetworkEngine) PeerStats() []PeerStats {
+    if e.gossipMgr == nil {
+        return nil
+    }
+    return e.gossipMgr.PeerStats()
+}
+
+// ConnectPeer connects the transport host to a peer's p2p address
+func (e *NetworkEngine) ConnectPeer(addr ma.Multiaddr) error {
+    info, err := peer.AddrInfoFromP2pAddr(addr)
+    if err != nil {
+        return fmt.Errorf("invalid peer address: %w", err)
+    }
+    if info.ID == e.nodeID {
+        return fmt.Errorf("cannot connect to self")
+    }
+    return e.transportHost.Connect(e.ctx, *info)
+}
+
+// DisconnectPeer closes the transport host's connections to a peer. The
+// peer may connect again unless it is banned.
+func (e *NetworkEngine) DisconnectPeer(id peer.ID) error {
+    if err := e.transportHost.Network().ClosePeer(id); err != nil {
+        return fmt.Errorf("failed to disconnect %s: %w", id, err)
+    }
     return nil
 }
 
+// replicateChunks pushes a file's chunks to the best scoring storage nodes
+// so that each chunk reaches the manifest's replication goal. Shortfalls
+// are logged and otherwise ignored; the local copy remains available.
+func (e *NetworkEngine) replicateChunks(manifest *ManifestInfo, chunks map[string][]byte) {
+    if _, err := e.uploads().Place(e.ctx, manifest.ReplicationGoal, chunks); err != nil {
+        fmt.Printf("replication of %s incomplete: %v\n", manifest.Name, err)
+    }
+}
+
+// UploadZapFile places a file's chunks on storage nodes and adds its
+// manifest only once every chunk has reached the replication goal. The
+// chunks are kept locally as well. If the goal is not met the manifest is
+// not added and the error wraps ErrReplicationGoalUnmet.
+func (e *NetworkEngine) UploadZapFile(ctx context.Context, manifest *ManifestInfo, chunks map[string][]byte) (*UploadResult, error) {
+    for hash, data := range chunks {
+        if !e.chunkStore.Store(hash, data) {
+            return nil, fmt.Errorf("failed to store chunk %s", hash)
+        }
+    }
+
+    goal := manifest.ReplicationGoal
+    if goal <= 0 {
+        goal = e.defaultReplicationGoal()
+    }
+    result, err := e.uploads().Place(ctx, goal, chunks)
+    if err != nil {
+        return result, err
+    }
+    if err := e.publishChunks(ctx, manifest, chunks); err != nil {
+        return result, err
+    }
+
+    if err := e.manifests.AddManifest(manifest); err != nil {
+        return result, fmt.Errorf("failed to add manifest: %w", err)
+    }
+    return result, nil
+}
+
+// uploads returns a scheduler placing chunks on behalf of this node
+func (e *NetworkEngine) uploads() *UploadScheduler {
+    var nodes StorageNodeSelector
+    if e.gossipMgr != nil {
+        nodes = e.gossipMgr
+    }
+    s := NewUploadScheduler(e.chunkStore.transfers, nodes, e.nodeID, DefaultUploadWorkers)
+    if e.config != nil {
+        s.SetPlacementPolicy(e.config.Placement)
+    }
+    return s
+}
+
 func (e *NetworkEngine) GetZapFile(name string) (*ManifestInfo, map[string][]byte, error) {
     manifest, err := e.manifests.GetManifest(name)
     if err != nil {
@@ -165,12 +336,19 @@ func (e *NetworkEngine) GetZapFile(name string) (*ManifestInfo, map[string][]byt
     }
 
     chunks := make(map[string][]byte)
-    for _, hash := range manifest.ChunkHashes {
+    err = e.manifests.WalkChunks(e.ctx, manifest, func(_ int, hash, chunkCID string) error {
         data, ok := e.chunkStore.Get(hash)
         if !ok {
-            return nil, nil, fmt.Errorf("chunk not found: %s", hash)
+            var err error
+            if data, err = e.fetchFromGateway(chunkCID); err != nil {
+                return fmt.Errorf("chunk not found: %s: %w", hash, err)
+            }
         }
         chunks[hash] = data
+        return nil
+    })
+    if err != nil {
+        return nil, nil, err
     }
 
     return manifest, chunks, nil
@@ -182,18 +360,27 @@ func (e *NetworkEngine) ReportBadFile(name string, reason string) error {
 
 // Storage operations
 func (e *NetworkEngine) RegisterStorageNode() error {
-    info := &StorageNodeInfo{
-        ID:             e.transportHost.ID().String(),
-        AvailableSpace: maxStorageSize,
-        TotalSpace:     maxStorageSize,
-        Uptime:         100.0, // TODO: Calculate actual uptime
-        Version:        "0.1.0",
-        Location:       "", // TODO: Add location support
+    if err := e.gossipMgr.AnnounceStorageNode(e.storageInfo()); err != nil {
+        return err
+    }
+
+    e.storageMu.Lock()
+    defer e.storageMu.Unlock()
+    if e.advertising == nil {
+        e.advertising = make(chan struct{})
+        go e.advertiseStorage(e.advertising)
     }
-    return e.gossipMgr.AnnounceStorageNode(info)
+    return nil
 }
 
 func (e *NetworkEngine) UnregisterStorageNode() error {
+    e.storageMu.Lock()
+    if e.advertising != nil {
+        close(e.advertising)
+        e.advertising = nil
+    }
+    e.storageMu.Unlock()
+
     nodeID := e.transportHost.ID()
     return e.gossipMgr.RemoveStorageNode(nodeID.String())
 }
@@ -211,6 +398,9 @@ func (e *NetworkEngine) ValidateChunkRequest(req *StorageRequest) error {
 }
 
 func (e *NetworkEngine) StoreChunk(req *StorageRequest) error {
+    if err := e.chunkStore.CheckAdmission(req); err != nil {
+        return err
+    }
     if !e.chunkStore.Store(req.ChunkHash, req.Data) {
         return ErrStorageFull
     }
@@ -0,0 +1,237 @@
+package network
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// EvidenceKind identifies the type of evidence attached to a vote
+type EvidenceKind string
+
+const (
+    // EvidenceBadChunk proves a provider served a chunk that fails validation
+    EvidenceBadChunk EvidenceKind = "bad_chunk"
+    // EvidenceMisbehavior is a signed report of misbehavior by a peer
+    EvidenceMisbehavior EvidenceKind = "misbehavior"
+    // EvidenceRuleUpdate carries the rules proposed by a rules-update vote
+    EvidenceRuleUpdate EvidenceKind = "rule_update"
+)
+
+// ruleUpdateTarget is the vote target used for rules-update votes
+const ruleUpdateTarget = "quorum-rules"
+
+const (
+    // maxEvidenceChunkSize bounds the chunk data embedded in bad chunk
+    // evidence so that votes still fit in a pubsub message
+    maxEvidenceChunkSize = 512 * 1024
+    // maxReportAge is how long a signed misbehavior report stays valid
+    maxReportAge = 24 * time.Hour
+)
+
+// Evidence errors
+var (
+    ErrNoEvidence        = fmt.Errorf("vote carries no evidence")
+    ErrUnknownEvidence   = fmt.Errorf("unknown evidence kind")
+    ErrEvidenceTarget    = fmt.Errorf("evidence does not match vote target")
+    ErrEvidenceUnproven  = fmt.Errorf("evidence does not prove misbehavior")
+    ErrEvidenceSignature = fmt.Errorf("evidence signature invalid")
+)
+
+// Evidence is verifiable proof supporting a quorum vote
+type Evidence interface {
+    // Kind returns the evidence type
+    Kind() EvidenceKind
+    // Verify checks that the evidence is well-formed, proves the claimed
+    // misbehavior and applies to the given vote
+    Verify(vote *Vote) error
+}
+
+// evidenceEnvelope is the wire format for evidence
+type evidenceEnvelope struct {
+    Kind    EvidenceKind    `json:"kind"`
+    Payload json.RawMessage `json:"payload"`
+}
+
+// EncodeEvidence serializes evidence for inclusion in a vote
+func EncodeEvidence(ev Evidence) ([]byte, error) {
+    payload, err := json.Marshal(ev)
+    if err != nil {
+        return nil, fmt.Errorf("failed to marshal evidence: %w", err)
+    }
+    return json.Marshal(&evidenceEnvelope{Kind: ev.Kind(), Payload: payload})
+}
+
+// DecodeEvidence parses evidence attached to a vote
+func DecodeEvidence(data []byte) (Evidence, error) {
+    if len(data) == 0 {
+        return nil, ErrNoEvidence
+    }
+
+    var env evidenceEnvelope
+    if err := json.Unmarshal(data, &env); err != nil {
+        return nil, fmt.Errorf("failed to unmarshal evidence: %w", err)
+    }
+
+    var ev Evidence
+    switch env.Kind {
+    case EvidenceBadChunk:
+        ev = &ChunkValidationEvidence{}
+    case EvidenceMisbehavior:
+        ev = &MisbehaviorReport{}
+    case EvidenceRuleUpdate:
+        ev = &RuleUpdateEvidence{}
+    default:
+        return nil, fmt.Errorf("%w: %q", ErrUnknownEvidence, env.Kind)
+    }
+
+    if err := json.Unmarshal(env.Payload, ev); err != nil {
+        return nil, fmt.Errorf("failed to unmarshal %s evidence: %w", env.Kind, err)
+    }
+    return ev, nil
+}
+
+// VerifyVoteEvidence decodes and verifies the evidence attached to a vote
+func VerifyVoteEvidence(vote *Vote) error {
+    ev, err := DecodeEvidence(vote.Evidence)
+    if err != nil {
+        return err
+    }
+    return ev.Verify(vote)
+}
+
+// classifyChunk runs the chunk validation checks against data
+func classifyChunk(chunk []byte, expectedHash string) ValidationResult {
+    if !chunkHashMatches(chunk, expectedHash) {
+        return ValidationHashMismatch
+    }
+    if len(chunk) == 0 || int64(len(chunk)) > maxChunkSize {
+        return ValidationSizeMismatch
+    }
+    if len(chunk) < 5 || chunk[0] != 1 {
+        return ValidationContentMalformed
+    }
+    return ValidationSuccess
+}
+
+// Kind implements Evidence
+func (e *ChunkValidationEvidence) Kind() EvidenceKind {
+    return EvidenceBadChunk
+}
+
+// Verify implements Evidence by re-running chunk validation on the data the
+// provider served and checking it fails the same way
+func (e *ChunkValidationEvidence) Verify(vote *Vote) error {
+    if vote.Type != VoteRemovePeer || vote.Target != string(e.Provider) {
+        return ErrEvidenceTarget
+    }
+    if e.FailureType == ValidationSuccess || e.Data == nil {
+        return ErrEvidenceUnproven
+    }
+    if result := classifyChunk(e.Data, e.ChunkHash); result != e.FailureType {
+        return fmt.Errorf("%w: chunk %s validates as %d, claimed %d",
+            ErrEvidenceUnproven, e.ChunkHash, result, e.FailureType)
+    }
+    return nil
+}
+
+// MisbehaviorReport is a report of misbehavior signed by the reporting peer
+type MisbehaviorReport struct {
+    Offender  peer.ID   `json:"offender"`
+    Reporter  peer.ID   `json:"reporter"`
+    Reason    string    `json:"reason"`
+    Timestamp time.Time `json:"timestamp"`
+    PublicKey []byte    `json:"public_key"`
+    Signature []byte    `json:"signature"`
+}
+
+// NewMisbehaviorReport creates a report against offender signed with priv
+func NewMisbehaviorReport(offender peer.ID, reason string, priv crypto.PrivKey) (*MisbehaviorReport, error) {
+    reporter, err := peer.IDFromPrivateKey(priv)
+    if err != nil {
+        return nil, fmt.Errorf("failed to derive reporter ID: %w", err)
+    }
+    pubKey, err := crypto.MarshalPublicKey(priv.GetPublic())
+    if err != nil {
+        return nil, fmt.Errorf("failed to marshal public key: %w", err)
+    }
+
+    report := &MisbehaviorReport{
+        Offender:  offender,
+        Reporter:  reporter,
+        Reason:    reason,
+        Timestamp: time.Now(),
+        PublicKey: pubKey,
+    }
+    data, err := report.signingBytes()
+    if err != nil {
+        return nil, err
+    }
+    if report.Signature, err = priv.Sign(data); err != nil {
+        return nil, fmt.Errorf("failed to sign report: %w", err)
+    }
+    return report, nil
+}
+
+// signingBytes returns the canonical encoding covered by the signature
+func (r *MisbehaviorReport) signingBytes() ([]byte, error) {
+    unsigned := *r
+    unsigned.Signature = nil
+    return json.Marshal(&unsigned)
+}
+
+// Kind implements Evidence
+func (r *MisbehaviorReport) Kind() EvidenceKind {
+    return EvidenceMisbehavior
+}
+
+// Verify implements Evidence by checking the reporter's signature
+func (r *MisbehaviorReport) Verify(vote *Vote) error {
+    if vote.Type != VoteRemovePeer || vote.Target != string(r.Offender) {
+        return ErrEvidenceTarget
+    }
+    if r.Reporter == r.Offender {
+        return fmt.Errorf("%w: peer reported itself", ErrEvidenceUnproven)
+    }
+    if time.Since(r.Timestamp) > maxReportAge {
+        return fmt.Errorf("%w: report expired", ErrEvidenceUnproven)
+    }
+
+    pubKey, err := crypto.UnmarshalPublicKey(r.PublicKey)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrEvidenceSignature, err)
+    }
+    if !r.Reporter.MatchesPublicKey(pubKey) {
+        return fmt.Errorf("%w: key does not match reporter", ErrEvidenceSignature)
+    }
+    data, err := r.signingBytes()
+    if err != nil {
+        return err
+    }
+    ok, err := pubKey.Verify(data, r.Signature)
+    if err != nil || !ok {
+        return ErrEvidenceSignature
+    }
+    return nil
+}
+
+// RuleUpdateEvidence carries the voting rules proposed by a rules-update vote
+type RuleUpdateEvidence struct {
+    Rules QuorumConfig `json:"rules"`
+}
+
+// Kind implements Evidence
+func (e *RuleUpdateEvidence) Kind() EvidenceKind {
+    return EvidenceRuleUpdate
+}
+
+// Verify implements Evidence by checking the proposed rules are sane
+func (e *RuleUpdateEvidence) Verify(vote *Vote) error {
+    if vote.Type != VoteUpdateRules || vote.Target != ruleUpdateTarget {
+        return ErrEvidenceTarget
+    }
+    return e.Rules.Validate()
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"lukechampine.com/blake3"
+)
+
+func newTestKey(t *testing.T) (crypto.PrivKey, peer.ID) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	return priv, id
+}
+
+func TestChunkEvidence(t *testing.T) {
+	_, provider := newTestKey(t)
+	good := []byte{1, 0, 0, 0, 1, 'd', 'a', 't', 'a'}
+	sum := sha256.Sum256(good)
+	hash := fmt.Sprintf("%x", sum[:])
+
+	vote := &Vote{Type: VoteRemovePeer, Target: string(provider)}
+
+	// Served data does not match the requested hash
+	ev := &ChunkValidationEvidence{
+		ChunkHash:   hash,
+		Provider:    provider,
+		FailureType: ValidationHashMismatch,
+		Data:        []byte("tampered"),
+	}
+	data, err := ev.Marshal()
+	require.NoError(t, err)
+	vote.Evidence = data
+	assert.NoError(t, VerifyVoteEvidence(vote))
+
+	var decoded ChunkValidationEvidence
+	require.NoError(t, decoded.Unmarshal(data))
+	assert.Equal(t, *ev, decoded)
+
+	// Claiming a failure for data that validates is rejected
+	ev.Data = good
+	vote.Evidence, err = ev.Marshal()
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyVoteEvidence(vote), ErrEvidenceUnproven)
+
+	// Evidence against a different peer is rejected
+	ev.Data = []byte("tampered")
+	vote.Evidence, err = ev.Marshal()
+	require.NoError(t, err)
+	vote.Target = "someone-else"
+	assert.ErrorIs(t, VerifyVoteEvidence(vote), ErrEvidenceTarget)
+}
+
+func TestMisbehaviorReport(t *testing.T) {
+	priv, _ := newTestKey(t)
+	_, offender := newTestKey(t)
+
+	report, err := NewMisbehaviorReport(offender, "spamming", priv)
+	require.NoError(t, err)
+
+	data, err := EncodeEvidence(report)
+	require.NoError(t, err)
+	vote := &Vote{Type: VoteRemovePeer, Target: string(offender), Evidence: data}
+	assert.NoError(t, VerifyVoteEvidence(vote))
+
+	// Tampering with the report breaks the signature
+	report.Reason = "something else"
+	vote.Evidence, err = EncodeEvidence(report)
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyVoteEvidence(vote), ErrEvidenceSignature)
+
+	// Expired reports are rejected
+	report, err = NewMisbehaviorReport(offender, "spamming", priv)
+	require.NoError(t, err)
+	report.Timestamp = time.Now().Add(-2 * maxReportAge)
+	vote.Evidence, err = EncodeEvidence(report)
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyVoteEvidence(vote), ErrEvidenceUnproven)
+}
+
+func TestVoteWithoutEvidence(t *testing.T) {
+	vote := &Vote{Type: VoteRemoveFile, Target: "file.zap"}
+	assert.ErrorIs(t, VerifyVoteEvidence(vote), ErrNoEvidence)
+
+	vote.Evidence = []byte(`{"kind":"bogus","payload":{}}`)
+	assert.ErrorIs(t, VerifyVoteEvidence(vote), ErrUnknownEvidence)
+}
+
+func TestChunkHashAlgorithms(t *testing.T) {
+	chunk := append([]byte{1}, []byte("payload")...)
+	sha := sha256.Sum256(chunk)
+	b3 := blake3.Sum256(chunk)
+
+	// Chunks of older files are named by SHA-256, newer ones by BLAKE3
+	assert.Equal(t, ValidationSuccess, classifyChunk(chunk, fmt.Sprintf("%x", sha[:])))
+	assert.Equal(t, ValidationSuccess, classifyChunk(chunk, fmt.Sprintf("%x", b3[:])))
+	assert.Equal(t, ValidationHashMismatch, classifyChunk(chunk, fmt.Sprintf("%x", b3[:16])))
+}
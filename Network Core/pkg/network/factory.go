@@ -27,6 +27,6 @@ func (f *managerFactory) CreateManifestManager(ctx context.Context, h host.Host,
 }
 
 // CreateQuorumManager creates a new quorum manager instance
-func (f *managerFactory) CreateQuorumManager(ctx context.Context, h host.Host, ps *pubsub.PubSub, g GossipManager) (QuorumManager, error) {
-    return newQuorumManagerImpl(ctx, h, ps, g)
+func (f *managerFactory) CreateQuorumManager(ctx context.Context, h host.Host, ps *pubsub.PubSub, g GossipManager, rules QuorumConfig) (QuorumManager, error) {
+    return newQuorumManagerImpl(ctx, h, ps, g, rules)
 }
@@ -0,0 +1,84 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// FuzzChunkEvidenceUnmarshal feeds arbitrary bytes to
+// ChunkValidationEvidence.Unmarshal, which must fail with an error rather
+// than panic on malformed evidence
+func FuzzChunkEvidenceUnmarshal(f *testing.F) {
+	ev := &ChunkValidationEvidence{
+		ChunkHash:   "abc",
+		Provider:    peer.ID("provider"),
+		FailureType: ValidationHashMismatch,
+		Data:        []byte("data"),
+	}
+	data, err := ev.Marshal()
+	if err != nil {
+		f.Fatalf("Marshal() error = %v", err)
+	}
+	f.Add(data)
+	f.Add([]byte(`{"kind":"bad_chunk","payload":null}`))
+	f.Add([]byte(`{"kind":"misbehavior","payload":{}}`))
+	f.Add([]byte(`null`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var ev ChunkValidationEvidence
+		if err := ev.Unmarshal(data); err != nil {
+			return
+		}
+		if _, err := ev.Marshal(); err != nil {
+			t.Fatalf("Marshal() of unmarshalled evidence error = %v", err)
+		}
+	})
+}
+
+// FuzzManifestValidator feeds arbitrary DHT keys and records to the
+// manifest validator, which must reject anything that is not a manifest
+// signed by its owner and stored under its own name
+func FuzzManifestValidator(f *testing.F) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		f.Fatalf("GenerateEd25519Key() error = %v", err)
+	}
+	owner, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		f.Fatalf("IDFromPrivateKey() error = %v", err)
+	}
+	manifest := &ManifestInfo{Name: "file.zap", Owner: owner.String(), ChunkHashes: []string{"abc"}}
+	if err := SignManifest(manifest, priv); err != nil {
+		f.Fatalf("SignManifest() error = %v", err)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		f.Fatalf("json.Marshal() error = %v", err)
+	}
+	f.Add(getDHTKey(manifest.Name), data)
+	f.Add("/filezap/other.zap", data)
+	f.Add("filezap", []byte(`{}`))
+	f.Add("/filezap/x", []byte(`{"name":"x","owner_key":"AAAA","signature":"AAAA"}`))
+	f.Add("", []byte{})
+
+	v := &validator{}
+	f.Fuzz(func(t *testing.T, key string, value []byte) {
+		if err := v.Validate(key, value); err != nil {
+			return
+		}
+		var got ManifestInfo
+		if err := json.Unmarshal(value, &got); err != nil {
+			t.Fatalf("validator accepted a record that is not a manifest: %v", err)
+		}
+		if err := VerifyManifest(&got); err != nil {
+			t.Fatalf("validator accepted an unverified manifest: %v", err)
+		}
+		if _, err := v.Select(key, [][]byte{value}); err != nil {
+			t.Fatalf("Select() of a valid record error = %v", err)
+		}
+	})
+}
@@ -6,6 +6,7 @@ import (
     "sync"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/peer"
     pubsub "github.com/libp2p/go-libp2p-pubsub"
@@ -21,6 +22,56 @@ type GossipManager interface {
     NotifyStorageSuccess(req *StorageRequest) error
     NotifyStorageRejection(req *StorageRequest, reason string) error
     GetPeers() []peer.ID
+    Subscribe(msgType GossipMessageType, handler GossipHandler)
+    Publish(msgType GossipMessageType, payload interface{}) error
+    SelectStorageNodes(n int, constraints StorageConstraints) []peer.ID
+    PeerLocality(id peer.ID) Locality
+    RecordSuccess(id peer.ID, responseTime time.Duration)
+    RecordFailure(id peer.ID)
+    RecordChunkServed(id peer.ID, size int)
+    RecordChunkReceived(id peer.ID, size int)
+    PeerStats() []PeerStats
+}
+
+// GossipMessageType identifies the payload carried by a gossip message
+type GossipMessageType string
+
+const (
+    GossipPeerInfo        GossipMessageType = "peer_info"
+    GossipStorageAnnounce GossipMessageType = "storage_announce"
+    GossipStorageRemove   GossipMessageType = "storage_remove"
+    GossipStorageReject   GossipMessageType = "storage_reject"
+    GossipStorageSuccess  GossipMessageType = "storage_success"
+    GossipBan             GossipMessageType = "ban"
+    GossipManifestHint    GossipMessageType = "manifest_hint"
+    GossipPeerExchange    GossipMessageType = "peer_exchange"
+)
+
+// GossipMessage is the envelope for every message on the gossip topic
+type GossipMessage struct {
+    Type    GossipMessageType `json:"type"`
+    Payload json.RawMessage   `json:"payload"`
+}
+
+// GossipHandler processes the payload of a dispatched gossip message
+type GossipHandler func(from peer.ID, payload json.RawMessage)
+
+// StorageRemoval is the payload of a storage_remove message
+type StorageRemoval struct {
+    NodeID string `json:"node_id"`
+}
+
+// StorageRejection is the payload of a storage_reject message
+type StorageRejection struct {
+    Request *StorageRequest `json:"request"`
+    Reason  string          `json:"reason"`
+}
+
+// ManifestHint is the payload of a manifest_hint message, telling peers a
+// newer version of a manifest is available in the DHT
+type ManifestHint struct {
+    Name     string `json:"name"`
+    Sequence uint64 `json:"sequence"`
 }
 
 const (
@@ -41,6 +92,8 @@ type PeerGossipInfo struct {
     Uptime        float64     `json:"uptime"`     // Uptime percentage
     ResponseTime  float64     `json:"resp_time"`  // Average response time in ms
     Version       string      `json:"version"`     // Protocol version
+    Region        string      `json:"region,omitempty"` // Self-reported, see Locality
+    ASN           uint32      `json:"asn,omitempty"`
 }
 
 // GossipManagerImpl implements the GossipManager interface
@@ -52,6 +105,10 @@ type GossipManagerImpl struct {
     subscription  *pubsub.Subscription
     peerStore     map[peer.ID]*PeerGossipInfo
     metrics       map[peer.ID]*PeerMetrics
+    handlers      map[GossipMessageType][]GossipHandler
+    storageNodes  map[peer.ID]*StorageNodeInfo
+    reputation    ReputationSource
+    locality      Locality // This node's, gossiped with its peer info
     mu            sync.RWMutex
     
     // Channels for peer events
@@ -68,6 +125,12 @@ type PeerMetrics struct {
     lastResponseTime  time.Time
     lastSeen         time.Time
     connectionStart  time.Time
+
+    // Chunks exchanged with the peer
+    chunksServed   uint64
+    chunksReceived uint64
+    bytesServed    uint64
+    bytesReceived  uint64
 }
 
 // NewGossipManager creates a new gossip manager for peer discovery
@@ -92,15 +155,23 @@ func NewGossipManager(ctx context.Context, h host.Host, ps *pubsub.PubSub) (Goss
         subscription:   subscription,
         peerStore:      make(map[peer.ID]*PeerGossipInfo),
         metrics:        make(map[peer.ID]*PeerMetrics),
+        handlers:       make(map[GossipMessageType][]GossipHandler),
+        storageNodes:   make(map[peer.ID]*StorageNodeInfo),
         peerDiscovered: make(chan peer.ID, 100),
         peerLeft:       make(chan peer.ID, 100),
         peerUpdated:    make(chan peer.ID, 100),
     }
 
+    gm.Subscribe(GossipPeerInfo, gm.handlePeerInfo)
+    gm.Subscribe(GossipStorageAnnounce, gm.handleStorageAnnounce)
+    gm.Subscribe(GossipStorageRemove, gm.handleStorageRemove)
+    gm.Subscribe(GossipPeerExchange, gm.handlePeerExchange)
+
     // Start gossip protocol
     go gm.startGossiping()
     go gm.handlePeerUpdates()
     go gm.cleanupStaleEntries()
+    go gm.exchangePeers()
 
     return gm, nil
 }
@@ -110,6 +181,7 @@ func (gm *GossipManagerImpl) Start() error {
     go gm.startGossiping()
     go gm.handlePeerUpdates()
     go gm.cleanupStaleEntries()
+    go gm.exchangePeers()
     return nil
 }
 
@@ -148,10 +220,16 @@ func (gm *GossipManagerImpl) broadcastPeerInfo() {
         addrs = append(addrs, addr.String())
     }
 
+    gm.mu.RLock()
+    locality := gm.locality
+    gm.mu.RUnlock()
+
     info := &PeerGossipInfo{
         ID:        gm.host.ID(),
         Addresses: addrs,
         LastSeen:  time.Now(),
+        Region:    locality.Region,
+        ASN:       locality.ASN,
     }
 
     // Add metrics if available
@@ -160,15 +238,42 @@ func (gm *GossipManagerImpl) broadcastPeerInfo() {
         info.ResponseTime = gm.calculateAverageResponseTime(metrics)
     }
 
-    data, err := json.Marshal(info)
+    gm.Publish(GossipPeerInfo, info)
+}
+
+// Subscribe registers a handler for a gossip message type. Handlers run on
+// the gossip receive loop and should not block.
+func (gm *GossipManagerImpl) Subscribe(msgType GossipMessageType, handler GossipHandler) {
+    gm.mu.Lock()
+    defer gm.mu.Unlock()
+    gm.handlers[msgType] = append(gm.handlers[msgType], handler)
+}
+
+// Publish wraps a payload in a typed envelope and broadcasts it
+func (gm *GossipManagerImpl) Publish(msgType GossipMessageType, payload interface{}) error {
+    body, err := json.Marshal(payload)
     if err != nil {
-        return
+        return err
     }
+    data, err := json.Marshal(&GossipMessage{Type: msgType, Payload: body})
+    if err != nil {
+        return err
+    }
+    return gm.topic.Publish(gm.ctx, data)
+}
+
+// dispatch hands a message to the handlers registered for its type
+func (gm *GossipManagerImpl) dispatch(from peer.ID, msg *GossipMessage) {
+    gm.mu.RLock()
+    handlers := gm.handlers[msg.Type]
+    gm.mu.RUnlock()
 
-    gm.topic.Publish(gm.ctx, data)
+    for _, handler := range handlers {
+        handler(from, msg.Payload)
+    }
 }
 
-// handlePeerUpdates processes incoming peer information
+// handlePeerUpdates dispatches incoming gossip messages by type
 func (gm *GossipManagerImpl) handlePeerUpdates() {
     for {
         msg, err := gm.subscription.Next(gm.ctx)
@@ -184,13 +289,24 @@ func (gm *GossipManagerImpl) handlePeerUpdates() {
             continue
         }
 
-        var info PeerGossipInfo
-        if err := json.Unmarshal(msg.Data, &info); err != nil {
+        var envelope GossipMessage
+        if err := json.Unmarshal(msg.Data, &envelope); err != nil || envelope.Type == "" {
             continue
         }
+        gm.dispatch(msg.GetFrom(), &envelope)
+    }
+}
 
-        gm.updatePeerInfo(&info)
+// handlePeerInfo records peer information gossiped by the peer itself
+func (gm *GossipManagerImpl) handlePeerInfo(from peer.ID, payload json.RawMessage) {
+    var info PeerGossipInfo
+    if err := json.Unmarshal(payload, &info); err != nil {
+        return
     }
+    if info.ID != from {
+        return
+    }
+    gm.updatePeerInfo(&info)
 }
 
 // updatePeerInfo updates the stored peer information
@@ -213,9 +329,12 @@ func (gm *GossipManagerImpl) updatePeerInfo(info *PeerGossipInfo) {
         existing.ChunkCount = info.ChunkCount
         existing.Uptime = info.Uptime
         existing.ResponseTime = info.ResponseTime
+        existing.Region = info.Region
+        existing.ASN = info.ASN
         gm.metrics[info.ID].lastSeen = time.Now()
         gm.peerUpdated <- info.ID
     }
+    metrics.GossipPeers.Set(float64(len(gm.peerStore)))
 }
 
 // cleanupStaleEntries removes information about stale peers
@@ -237,6 +356,7 @@ func (gm *GossipManagerImpl) cleanupStaleEntries() {
                     gm.peerLeft <- id
                 }
             }
+            metrics.GossipPeers.Set(float64(len(gm.peerStore)))
             gm.mu.Unlock()
         }
     }
@@ -244,64 +364,22 @@ func (gm *GossipManagerImpl) cleanupStaleEntries() {
 
 // AnnounceStorageNode announces this node as a storage provider
 func (gm *GossipManagerImpl) AnnounceStorageNode(info *StorageNodeInfo) error {
-    data, err := json.Marshal(struct {
-        Type string         `json:"type"`
-        Info *StorageNodeInfo `json:"info"`
-    }{
-        Type: "storage_announce",
-        Info: info,
-    })
-    if err != nil {
-        return err
-    }
-    return gm.topic.Publish(gm.ctx, data)
+    return gm.Publish(GossipStorageAnnounce, info)
 }
 
 // RemoveStorageNode removes this node from storage providers
 func (gm *GossipManagerImpl) RemoveStorageNode(nodeID string) error {
-    data, err := json.Marshal(struct {
-        Type   string `json:"type"`
-        NodeID string `json:"node_id"`
-    }{
-        Type:   "storage_remove",
-        NodeID: nodeID,
-    })
-    if err != nil {
-        return err
-    }
-    return gm.topic.Publish(gm.ctx, data)
+    return gm.Publish(GossipStorageRemove, &StorageRemoval{NodeID: nodeID})
 }
 
 // NotifyStorageRejection notifies network of rejected storage request
 func (gm *GossipManagerImpl) NotifyStorageRejection(req *StorageRequest, reason string) error {
-    data, err := json.Marshal(struct {
-        Type   string         `json:"type"`
-        Request *StorageRequest `json:"request"`
-        Reason string         `json:"reason"`
-    }{
-        Type:    "storage_reject",
-        Request: req,
-        Reason:  reason,
-    })
-    if err != nil {
-        return err
-    }
-    return gm.topic.Publish(gm.ctx, data)
+    return gm.Publish(GossipStorageReject, &StorageRejection{Request: req, Reason: reason})
 }
 
 // NotifyStorageSuccess notifies network of successful storage
 func (gm *GossipManagerImpl) NotifyStorageSuccess(req *StorageRequest) error {
-    data, err := json.Marshal(struct {
-        Type    string         `json:"type"`
-        Request *StorageRequest `json:"request"`
-    }{
-        Type:    "storage_success",
-        Request: req,
-    })
-    if err != nil {
-        return err
-    }
-    return gm.topic.Publish(gm.ctx, data)
+    return gm.Publish(GossipStorageSuccess, req)
 }
 
 // GetPeers returns all known peers
@@ -341,15 +419,14 @@ func (gm *GossipManagerImpl) RecordSuccess(id peer.ID, responseTime time.Duratio
     }
 }
 
-// RecordFailure records a failed interaction with a peer
+// RecordFailure records a failed interaction with a peer. Failures are
+// counted for peers not yet known through gossip too, as evidence for
+// reports against them.
 func (gm *GossipManagerImpl) RecordFailure(id peer.ID) {
     gm.mu.Lock()
     defer gm.mu.Unlock()
 
-    if metrics, ok := gm.metrics[id]; ok {
-        metrics.failedRequests++
-        metrics.lastSeen = time.Now()
-    }
+    gm.metricsLocked(id).failedRequests++
 }
 
 // calculateUptime calculates the peer's uptime percentage
@@ -0,0 +1,83 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGossipDispatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	ps1, err := pubsub.NewGossipSub(ctx, h1)
+	require.NoError(t, err)
+	ps2, err := pubsub.NewGossipSub(ctx, h2)
+	require.NoError(t, err)
+
+	gm1, err := NewGossipManager(ctx, h1, ps1)
+	require.NoError(t, err)
+	gm2, err := NewGossipManager(ctx, h2, ps2)
+	require.NoError(t, err)
+
+	type received struct {
+		from   peer.ID
+		nodeID string
+	}
+	got := make(chan received, 10)
+	gm2.Subscribe(GossipStorageRemove, func(from peer.ID, payload json.RawMessage) {
+		var removal StorageRemoval
+		if err := json.Unmarshal(payload, &removal); err == nil {
+			got <- received{from: from, nodeID: removal.NodeID}
+		}
+	})
+
+	// Wait for the gossip mesh to form, re-publishing until delivered
+	deadline := time.After(10 * time.Second)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-got:
+			assert.Equal(t, h1.ID(), r.from)
+			assert.Equal(t, "node-1", r.nodeID)
+			return
+		case <-ticker.C:
+			require.NoError(t, gm1.RemoveStorageNode("node-1"))
+		case <-deadline:
+			t.Fatal("storage_remove message was not dispatched")
+		}
+	}
+}
+
+func TestGossipPeerInfoMustComeFromPeer(t *testing.T) {
+	gm := &GossipManagerImpl{
+		peerStore:      make(map[peer.ID]*PeerGossipInfo),
+		metrics:        make(map[peer.ID]*PeerMetrics),
+		handlers:       make(map[GossipMessageType][]GossipHandler),
+		peerDiscovered: make(chan peer.ID, 10),
+		peerUpdated:    make(chan peer.ID, 10),
+	}
+	gm.Subscribe(GossipPeerInfo, gm.handlePeerInfo)
+
+	_, peerA := newTestKey(t)
+	_, peerB := newTestKey(t)
+	payload, err := json.Marshal(&PeerGossipInfo{ID: peerA})
+	require.NoError(t, err)
+
+	gm.dispatch(peerB, &GossipMessage{Type: GossipPeerInfo, Payload: payload})
+	assert.Empty(t, gm.GetPeers(), "spoofed peer info should be ignored")
+
+	gm.dispatch(peerA, &GossipMessage{Type: GossipPeerInfo, Payload: payload})
+	assert.Equal(t, []peer.ID{peerA}, gm.GetPeers())
+}
@@ -0,0 +1,194 @@
+package network
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sync"
+    "time"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/bloom"
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// A downloader asks a peer which chunks it holds over haveProtocol by
+// sending a Bloom filter of the chunks it needs, one JSON line. The peer
+// answers with every chunk it holds that the filter may contain and the
+// downloader may fetch. False positives only add chunks the downloader did
+// not ask for, which it drops, so one round trip per peer replaces one per
+// chunk.
+
+const (
+    // haveStreamTimeout bounds a whole possession query
+    haveStreamTimeout = 10 * time.Second
+
+    // maxHaveQuerySize bounds the queries read from a stream, enough for a
+    // filter of several hundred thousand chunks
+    maxHaveQuerySize = 1024 * 1024
+
+    // maxHaveReplyChunks bounds the chunks listed in an answer, and
+    // maxHaveReplySize the answers read from a stream
+    maxHaveReplyChunks = 100000
+    maxHaveReplySize   = 16 * 1024 * 1024
+
+    // HaveFalsePositive is the false positive rate of possession queries
+    HaveFalsePositive = 0.01
+)
+
+// haveQuery asks which of the chunks in a filter a peer holds
+type haveQuery struct {
+    Filter *bloom.Filter `json:"filter"`
+}
+
+// haveReply lists the chunks a peer holds that matched a query
+type haveReply struct {
+    Chunks []string `json:"chunks"`
+}
+
+// handleHaveStream answers a possession query
+func (cs *ChunkStore) handleHaveStream(stream network.Stream) {
+    defer stream.Close()
+
+    cs.mu.RLock()
+    policy := cs.policy
+    cs.mu.RUnlock()
+    if policy != nil {
+        if err := policy.CheckStream(stream); err != nil {
+            stream.Reset()
+            return
+        }
+    }
+
+    stream.SetDeadline(time.Now().Add(haveStreamTimeout))
+    var query haveQuery
+    if err := json.NewDecoder(io.LimitReader(stream, maxHaveQuerySize)).Decode(&query); err != nil {
+        stream.Reset()
+        return
+    }
+    if err := query.Filter.Validate(); err != nil {
+        stream.Reset()
+        return
+    }
+
+    remote := stream.Conn()
+    reply := haveReply{Chunks: cs.matching(query.Filter, maxHaveReplyChunks, remote.RemotePeer(), remote.RemotePublicKey())}
+    if err := json.NewEncoder(stream).Encode(&reply); err != nil {
+        stream.Reset()
+    }
+}
+
+// matching returns up to limit stored chunks the filter may contain that
+// the peer p, with public key pub, may fetch
+func (cs *ChunkStore) matching(f *bloom.Filter, limit int, p peer.ID, pub crypto.PubKey) []string {
+    cs.mu.RLock()
+    defer cs.mu.RUnlock()
+
+    var held []string
+    for _, hash := range cs.heldLocked() {
+        if len(held) == limit {
+            break
+        }
+        if f.Test(hash) && cs.checkAccess(hash, p, pub) == nil {
+            held = append(held, hash)
+        }
+    }
+    return held
+}
+
+// QueryChunks asks a peer which of hashes it holds, in one round trip. The
+// chunks it holds are returned in the order they were asked for.
+func (tm *TransferManager) QueryChunks(to peer.ID, hashes []string) ([]string, error) {
+    if tm.host == nil {
+        return nil, fmt.Errorf("transfer manager not initialized")
+    }
+    if to == tm.host.ID() {
+        return nil, fmt.Errorf("cannot query self")
+    }
+    if len(hashes) == 0 {
+        return nil, nil
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), haveStreamTimeout)
+    defer cancel()
+
+    stream, err := tm.host.NewStream(ctx, to, protocol.ID(haveProtocol))
+    if err != nil {
+        return nil, fmt.Errorf("failed to open stream: %w", err)
+    }
+    defer stream.Close()
+
+    tm.mu.RLock()
+    policy := tm.policy
+    tm.mu.RUnlock()
+    if policy != nil {
+        if err := policy.CheckStream(stream); err != nil {
+            stream.Reset()
+            return nil, fmt.Errorf("refusing have stream: %w", err)
+        }
+    }
+
+    stream.SetDeadline(time.Now().Add(haveStreamTimeout))
+    query := haveQuery{Filter: bloom.FromItems(hashes, HaveFalsePositive)}
+    if err := json.NewEncoder(stream).Encode(&query); err != nil {
+        stream.Reset()
+        return nil, fmt.Errorf("failed to send query: %w", err)
+    }
+    if err := stream.CloseWrite(); err != nil {
+        stream.Reset()
+        return nil, fmt.Errorf("failed to send query: %w", err)
+    }
+
+    var reply haveReply
+    if err := json.NewDecoder(io.LimitReader(stream, maxHaveReplySize)).Decode(&reply); err != nil {
+        stream.Reset()
+        return nil, fmt.Errorf("failed to read reply: %w", err)
+    }
+
+    // Drop false positives and anything else not asked for
+    held := make(map[string]bool, len(reply.Chunks))
+    for _, hash := range reply.Chunks {
+        held[hash] = true
+    }
+    var result []string
+    for _, hash := range hashes {
+        if held[hash] {
+            result = append(result, hash)
+            delete(held, hash)
+        }
+    }
+    return result, nil
+}
+
+// LocateChunks queries peers concurrently and returns the peers holding
+// each of hashes, in the order the peers were given. Peers that do not
+// answer are left out.
+func (tm *TransferManager) LocateChunks(ctx context.Context, peers []peer.ID, hashes []string) map[string][]peer.ID {
+    answers := make([][]string, len(peers))
+    var wg sync.WaitGroup
+    for i, p := range peers {
+        if ctx.Err() != nil {
+            break
+        }
+        wg.Add(1)
+        go func(i int, p peer.ID) {
+            defer wg.Done()
+            held, err := tm.QueryChunks(p, hashes)
+            if err == nil {
+                answers[i] = held
+            }
+        }(i, p)
+    }
+    wg.Wait()
+
+    located := make(map[string][]peer.ID)
+    for i, held := range answers {
+        for _, hash := range held {
+            located[hash] = append(located[hash], peers[i])
+        }
+    }
+    return located
+}
@@ -0,0 +1,59 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryChunks(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	downloader := NewChunkStore(h1)
+	holder := NewChunkStore(h2)
+
+	// The holder has every other chunk of the file, and chunks of others
+	var needed, held []string
+	for i := 0; i < 2000; i++ {
+		hash := fmt.Sprintf("chunk-%04d", i)
+		needed = append(needed, hash)
+		if i%2 == 0 {
+			require.True(t, holder.Store(hash, []byte(hash)))
+			held = append(held, hash)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		require.True(t, holder.Store(fmt.Sprintf("other-%d", i), []byte("x")))
+	}
+
+	got, err := downloader.transfers.QueryChunks(h2.ID(), needed)
+	require.NoError(t, err)
+	assert.Equal(t, held, got)
+
+	none, err := downloader.transfers.QueryChunks(h2.ID(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+	_, err = downloader.transfers.QueryChunks(h1.ID(), needed)
+	assert.Error(t, err)
+}
+
+func TestLocateChunks(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	downloader := NewChunkStore(h1)
+	holder := NewChunkStore(h2)
+	require.True(t, holder.Store("a", []byte("a")))
+	require.True(t, holder.Store("b", []byte("b")))
+
+	// Peers that cannot answer are left out
+	located := downloader.transfers.LocateChunks(context.Background(), []peer.ID{h2.ID(), h1.ID()}, []string{"a", "c"})
+	assert.Equal(t, map[string][]peer.ID{"a": {h2.ID()}}, located)
+}
@@ -0,0 +1,144 @@
+package network
+
+import (
+    "container/list"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "sync"
+    "time"
+)
+
+// Senders tag storage requests with an idempotency key, reused for every
+// retry of the same request. The receiving node remembers the keys of the
+// requests it has accepted, so a retried offer is acknowledged as a
+// duplicate without the chunk being sent, queued or counted against the
+// quota again. Keys are scoped to the sender, and forgotten when their
+// request is rejected so that a retry is judged afresh.
+
+const (
+    // idempotencyKeyTTL is how long the key of an accepted request is
+    // remembered
+    idempotencyKeyTTL = time.Hour
+
+    // maxIdempotencyKeys bounds the keys remembered, oldest dropped first
+    maxIdempotencyKeys = 10000
+
+    // maxIdempotencyKeyLen bounds the length of a key sent in an offer
+    maxIdempotencyKeyLen = 128
+)
+
+var (
+    // ErrRequestInProgress is returned for a retried offer whose first
+    // attempt is still being transferred
+    ErrRequestInProgress = errors.New("storage request already in progress")
+
+    // ErrIdempotencyConflict is returned when an idempotency key is reused
+    // for a different chunk
+    ErrIdempotencyConflict = errors.New("idempotency key reused for a different chunk")
+)
+
+// NewIdempotencyKey returns a random key for a new storage request
+func NewIdempotencyKey() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// requestKey returns the key of a request scoped to its sender, empty if
+// the request has none
+func requestKey(req *StorageRequest) string {
+    if req.IdempotencyKey == "" {
+        return ""
+    }
+    return req.Owner + "/" + req.IdempotencyKey
+}
+
+// idempotencyEntry is a remembered request key
+type idempotencyEntry struct {
+    key       string
+    chunkHash string
+    accepted  bool // False while the chunk is being transferred
+    expires   time.Time
+}
+
+// idempotencyKeys remembers the keys of storage requests in flight or
+// accepted
+type idempotencyKeys struct {
+    entries map[string]*list.Element
+    order   *list.List // Entries, most recently claimed first
+    mu      sync.Mutex
+}
+
+func newIdempotencyKeys() *idempotencyKeys {
+    return &idempotencyKeys{
+        entries: make(map[string]*list.Element),
+        order:   list.New(),
+    }
+}
+
+// begin claims key for a request for chunkHash. It reports a duplicate if
+// a request under key was already accepted, and fails if one is still in
+// flight or the key was used for another chunk.
+func (k *idempotencyKeys) begin(key, chunkHash string) (bool, error) {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    now := time.Now()
+    if elem, ok := k.entries[key]; ok {
+        entry := elem.Value.(*idempotencyEntry)
+        if now.Before(entry.expires) {
+            if entry.chunkHash != chunkHash {
+                return false, ErrIdempotencyConflict
+            }
+            if !entry.accepted {
+                return false, ErrRequestInProgress
+            }
+            return true, nil
+        }
+        k.removeLocked(elem)
+    }
+
+    entry := &idempotencyEntry{key: key, chunkHash: chunkHash, expires: now.Add(storeStreamTimeout)}
+    k.entries[key] = k.order.PushFront(entry)
+    for k.order.Len() > maxIdempotencyKeys {
+        k.removeLocked(k.order.Back())
+    }
+    return false, nil
+}
+
+// accept records that the request claiming key was accepted
+func (k *idempotencyKeys) accept(key string) {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    if elem, ok := k.entries[key]; ok {
+        entry := elem.Value.(*idempotencyEntry)
+        entry.accepted = true
+        entry.expires = time.Now().Add(idempotencyKeyTTL)
+    }
+}
+
+// release forgets key, so a retry under it is processed again
+func (k *idempotencyKeys) release(key string) {
+    if key == "" {
+        return
+    }
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    if elem, ok := k.entries[key]; ok {
+        k.removeLocked(elem)
+    }
+}
+
+// len returns the number of keys remembered
+func (k *idempotencyKeys) len() int {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    return k.order.Len()
+}
+
+// removeLocked drops an entry. Callers must hold k.mu.
+func (k *idempotencyKeys) removeLocked(elem *list.Element) {
+    k.order.Remove(elem)
+    delete(k.entries, elem.Value.(*idempotencyEntry).key)
+}
@@ -0,0 +1,51 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	k := newIdempotencyKeys()
+
+	duplicate, err := k.begin("peer/key", "abc")
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+
+	// Retries while the first attempt is in flight wait for it
+	_, err = k.begin("peer/key", "abc")
+	assert.ErrorIs(t, err, ErrRequestInProgress)
+
+	k.accept("peer/key")
+	duplicate, err = k.begin("peer/key", "abc")
+	require.NoError(t, err)
+	assert.True(t, duplicate)
+	_, err = k.begin("peer/key", "other")
+	assert.ErrorIs(t, err, ErrIdempotencyConflict)
+
+	// Released keys are processed again
+	k.release("peer/key")
+	duplicate, err = k.begin("peer/key", "other")
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+
+	// Expired keys are too
+	k.mu.Lock()
+	k.entries["peer/key"].Value.(*idempotencyEntry).expires = time.Now().Add(-time.Second)
+	k.mu.Unlock()
+	duplicate, err = k.begin("peer/key", "abc")
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+	assert.Equal(t, 1, k.len())
+}
+
+func TestRequestKeyScopedToSender(t *testing.T) {
+	assert.Empty(t, requestKey(&StorageRequest{Owner: "peer"}))
+	a := requestKey(&StorageRequest{Owner: "a", IdempotencyKey: "k"})
+	b := requestKey(&StorageRequest{Owner: "b", IdempotencyKey: "k"})
+	assert.NotEqual(t, a, b)
+	assert.NotEqual(t, NewIdempotencyKey(), NewIdempotencyKey())
+}
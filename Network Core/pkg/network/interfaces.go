@@ -1,6 +1,8 @@
 package network
 
 import (
+    "context"
+
     "github.com/libp2p/go-libp2p/core/peer"
     ma "github.com/multiformats/go-multiaddr"
 
@@ -17,11 +19,14 @@ type Network interface {
     // Identity and peer operations
     GetNodeID() string
     GetPeers() []peer.ID
+    PeerStats() []PeerStats
+    DisconnectPeer(id peer.ID) error
     GetTransportHost() host.Host
     GetMetadataHost() host.Host
 
     // File operations
     AddZapFile(manifest *ManifestInfo, chunks map[string][]byte) error
+    UploadZapFile(ctx context.Context, manifest *ManifestInfo, chunks map[string][]byte) (*UploadResult, error)
     GetZapFile(name string) (*ManifestInfo, map[string][]byte, error)
     ReportBadFile(name string, reason string) error
 
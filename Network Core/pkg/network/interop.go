@@ -0,0 +1,83 @@
+package network
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/ipfs"
+    "github.com/ipfs/go-cid"
+)
+
+// Uploaded chunks can also be published as IPFS blocks, recording their
+// CIDs in the manifest, whose signature then covers them. A chunk no
+// FileZap peer serves can still be fetched through public IPFS gateways by
+// its CID; chunks are encrypted before upload, so gateways learn nothing
+// of the file.
+
+// errNoGateway is returned for chunks that cannot be fetched from IPFS
+var errNoGateway = errors.New("no IPFS fallback for chunk")
+
+// newIPFSClient creates the IPFS client for cfg, nil if interop is off
+func newIPFSClient(cfg ipfs.Config) (*ipfs.Client, error) {
+    if !cfg.Publish && !cfg.Fallback {
+        return nil, nil
+    }
+    client, err := ipfs.NewClient(cfg)
+    if err != nil {
+        return nil, fmt.Errorf("invalid IPFS config: %w", err)
+    }
+    return client, nil
+}
+
+// validateChunkCIDs checks that a manifest names a valid CID for each chunk
+// if it names any
+func validateChunkCIDs(manifest *ManifestInfo) error {
+    if len(manifest.ChunkCIDs) == 0 {
+        return nil
+    }
+    if len(manifest.ChunkCIDs) != len(manifest.ChunkHashes) {
+        return fmt.Errorf("manifest has %d chunk CIDs for %d chunks", len(manifest.ChunkCIDs), len(manifest.ChunkHashes))
+    }
+    for _, s := range manifest.ChunkCIDs {
+        if _, err := cid.Decode(s); err != nil {
+            return fmt.Errorf("invalid chunk CID %q: %w", s, err)
+        }
+    }
+    return nil
+}
+
+// publishChunks publishes the manifest's chunks to IPFS and records their
+// CIDs, if publishing is enabled
+func (e *NetworkEngine) publishChunks(ctx context.Context, manifest *ManifestInfo, chunks map[string][]byte) error {
+    if e.ipfs == nil || e.config == nil || !e.config.IPFS.Publish {
+        return nil
+    }
+    cids := make([]string, len(manifest.ChunkHashes))
+    for i, hash := range manifest.ChunkHashes {
+        data, ok := chunks[hash]
+        if !ok {
+            return fmt.Errorf("chunk %s of manifest %s was not uploaded", hash, manifest.Name)
+        }
+        c, err := e.ipfs.Publish(ctx, data)
+        if err != nil {
+            return fmt.Errorf("failed to publish chunk %s to IPFS: %w", hash, err)
+        }
+        cids[i] = c.String()
+    }
+    manifest.ChunkCIDs = cids
+    return nil
+}
+
+// fetchFromGateway fetches a chunk from IPFS gateways by its CID, empty
+// if the manifest names none
+func (e *NetworkEngine) fetchFromGateway(chunkCID string) ([]byte, error) {
+    if e.ipfs == nil || e.config == nil || !e.config.IPFS.Fallback || chunkCID == "" {
+        return nil, errNoGateway
+    }
+    c, err := cid.Decode(chunkCID)
+    if err != nil {
+        return nil, fmt.Errorf("invalid chunk CID: %w", err)
+    }
+    return e.ipfs.Fetch(e.ctx, c)
+}
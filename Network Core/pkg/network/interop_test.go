@@ -0,0 +1,56 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/ipfs"
+)
+
+func TestGatewayFallback(t *testing.T) {
+	data := []byte("encrypted chunk")
+	c, err := ipfs.BlockCID(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimPrefix(r.URL.Path, "/ipfs/") != c.String() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer gateway.Close()
+
+	cfg := &NetworkConfig{IPFS: ipfs.Config{Fallback: true, Gateways: []string{gateway.URL}}}
+	client, err := newIPFSClient(cfg.IPFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &NetworkEngine{ctx: context.Background(), config: cfg, ipfs: client}
+
+	manifest := &ManifestInfo{Name: "file", ChunkHashes: []string{"a", "b"}, ChunkCIDs: []string{c.String(), c.String()}}
+	if err := validateChunkCIDs(manifest); err != nil {
+		t.Fatalf("valid chunk CIDs rejected: %v", err)
+	}
+	got, err := e.fetchFromGateway(manifest.ChunkCIDs[1])
+	if err != nil {
+		t.Fatalf("fetchFromGateway failed: %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("fetchFromGateway returned %q, want %q", got, data)
+	}
+
+	if _, err := e.fetchFromGateway(""); err == nil {
+		t.Error("chunk without a CID was fetched")
+	}
+	if err := validateChunkCIDs(&ManifestInfo{ChunkHashes: []string{"a", "b"}, ChunkCIDs: []string{c.String()}}); err == nil {
+		t.Error("manifest with a CID missing was accepted")
+	}
+	if err := validateChunkCIDs(&ManifestInfo{ChunkHashes: []string{"a"}, ChunkCIDs: []string{"not a cid"}}); err == nil {
+		t.Error("manifest with an invalid CID was accepted")
+	}
+}
@@ -0,0 +1,90 @@
+package network
+
+import (
+    "sort"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Nodes may gossip the region they run in and the autonomous system (ASN)
+// of their network provider, so uploads can spread a chunk's replicas over
+// regions and providers. Both are self-reported. Where this node has
+// measured round trips to several peers claiming a region, a peer whose
+// round trip is far from theirs has its claim disregarded: a node cannot
+// be close to us and in a distant region at once.
+const (
+    minRegionWitnesses  = 2                     // Other peers in a region needed to check a claim
+    regionLatencyFactor = 3                     // Tolerated ratio to the region's median round trip
+    regionLatencySlack  = 50 * time.Millisecond // Tolerated difference for nearby regions
+)
+
+// Locality is where a node reports it runs
+type Locality struct {
+    Region   string // e.g. "eu-west", empty if unknown
+    ASN      uint32 // Autonomous system of the node's provider, 0 if unknown
+    Verified bool   // Round trips from this node agree with the region
+}
+
+// SetLocality sets the locality this node gossips about itself
+func (gm *GossipManagerImpl) SetLocality(locality Locality) {
+    gm.mu.Lock()
+    defer gm.mu.Unlock()
+    gm.locality = locality
+}
+
+// PeerLocality returns the locality a peer gossiped. A claim that round
+// trips from this node contradict is returned as unknown.
+func (gm *GossipManagerImpl) PeerLocality(id peer.ID) Locality {
+    gm.mu.RLock()
+    defer gm.mu.RUnlock()
+
+    info, ok := gm.peerStore[id]
+    if !ok || (info.Region == "" && info.ASN == 0) {
+        return Locality{}
+    }
+    locality := Locality{Region: info.Region, ASN: info.ASN}
+    if locality.Region == "" {
+        return locality
+    }
+
+    rtt := gm.roundTripLocked(id)
+    if rtt == 0 {
+        return locality
+    }
+    var witnesses []time.Duration
+    for other, otherInfo := range gm.peerStore {
+        if other == id || otherInfo.Region != info.Region {
+            continue
+        }
+        if d := gm.roundTripLocked(other); d > 0 {
+            witnesses = append(witnesses, d)
+        }
+    }
+    if len(witnesses) < minRegionWitnesses {
+        return locality
+    }
+
+    sort.Slice(witnesses, func(i, j int) bool { return witnesses[i] < witnesses[j] })
+    median := witnesses[len(witnesses)/2]
+    if rtt > median*regionLatencyFactor+regionLatencySlack || median > rtt*regionLatencyFactor+regionLatencySlack {
+        return Locality{}
+    }
+    locality.Verified = true
+    return locality
+}
+
+// roundTripLocked returns the round trip to a peer measured by the host,
+// or else the peer's average response time, zero if neither is known.
+// Callers must hold gm.mu.
+func (gm *GossipManagerImpl) roundTripLocked(id peer.ID) time.Duration {
+    if gm.host != nil {
+        if rtt := gm.host.Peerstore().LatencyEWMA(id); rtt > 0 {
+            return rtt
+        }
+    }
+    if m, ok := gm.metrics[id]; ok && m.successfulRequests > 0 {
+        return time.Duration(gm.calculateAverageResponseTime(m) * float64(time.Millisecond))
+    }
+    return 0
+}
@@ -0,0 +1,41 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerLocality(t *testing.T) {
+	host1, host2 := setupTestHosts(t)
+	defer host1.Close()
+	defer host2.Close()
+	gm := newStatsGossip(host1)
+
+	claim := func(region string, asn uint32, rtt time.Duration) peer.ID {
+		_, id := newTestKey(t)
+		gm.peerStore[id] = &PeerGossipInfo{ID: id, Region: region, ASN: asn}
+		if rtt > 0 {
+			host1.Peerstore().RecordLatency(id, rtt)
+		}
+		return id
+	}
+
+	near := claim("eu-west", 100, 20*time.Millisecond)
+	assert.Equal(t, Locality{Region: "eu-west", ASN: 100}, gm.PeerLocality(near), "unchecked without witnesses")
+
+	claim("eu-west", 101, 25*time.Millisecond)
+	claim("eu-west", 102, 30*time.Millisecond)
+	assert.Equal(t, Locality{Region: "eu-west", ASN: 100, Verified: true}, gm.PeerLocality(near))
+
+	// Too far away for the region it claims
+	far := claim("eu-west", 300, 400*time.Millisecond)
+	assert.Equal(t, Locality{}, gm.PeerLocality(far))
+
+	unmeasured := claim("eu-west", 0, 0)
+	assert.Equal(t, Locality{Region: "eu-west"}, gm.PeerLocality(unmeasured))
+	_, unknown := newTestKey(t)
+	assert.Equal(t, Locality{}, gm.PeerLocality(unknown))
+}
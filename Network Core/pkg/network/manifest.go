@@ -4,21 +4,29 @@ import (
     "bytes"
     "context"
     "encoding/json"
+    "errors"
     "fmt"
+    "reflect"
     "strings"
+    "sync"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/vpn"
     "github.com/ipfs/go-cid"
     dht "github.com/libp2p/go-libp2p-kad-dht"
     record "github.com/libp2p/go-libp2p-record"
     pubsub "github.com/libp2p/go-libp2p-pubsub"
+    "github.com/libp2p/go-libp2p/core/crypto"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/peer"
     mh "github.com/multiformats/go-multihash"
 )
 
 // Custom validator for DHT records
-type validator struct{}
+type validator struct {
+    owners *manifestOwners // Owners the node has bound manifest names to
+}
 
 func (v *validator) Validate(key string, value []byte) error {
     parts := strings.Split(key, "/")
@@ -51,8 +59,19 @@ func (v *validator) Validate(key string, value []byte) error {
     if err := json.Unmarshal(value, &manifest); err != nil {
         return fmt.Errorf("invalid manifest data: %w", err)
     }
-    
-    return nil
+
+    // A record may only be stored under the key derived from its own name
+    if getDHTKey(manifest.Name) != getDHTKey(key) {
+        return fmt.Errorf("manifest %s does not match key %s", manifest.Name, key)
+    }
+
+    // Only the owner may publish a manifest
+    if err := VerifyManifest(&manifest); err != nil {
+        return err
+    }
+
+    // And a name keeps the owner it was first published by
+    return v.owners.claim(manifest.Name, manifest.Owner)
 }
 
 func (v *validator) Select(key string, values [][]byte) (int, error) {
@@ -60,19 +79,31 @@ func (v *validator) Select(key string, values [][]byte) (int, error) {
         return 0, fmt.Errorf("no values to select from")
     }
     
-    // Select the most recent valid manifest
-    var latest time.Time
+    // Select the valid manifest with the highest sequence from the owner
+    // of the name. Records from any other owner are ignored, so a name
+    // cannot be taken over by signing a higher sequence. Names the node has
+    // not bound yet keep the owner of the first valid record.
+    var best *ManifestInfo
     selected := 0
-    
+    owner := v.owners.owner(key)
+
     for i, value := range values {
         var manifest ManifestInfo
         if err := json.Unmarshal(value, &manifest); err != nil {
             continue
         }
+        if err := VerifyManifest(&manifest); err != nil {
+            continue
+        }
+        if owner == "" {
+            owner = manifest.Owner
+        }
+        if manifest.Owner != owner {
+            continue
+        }
         
-        // Compare timestamps if available, otherwise keep first valid one
-        if latest.IsZero() || manifest.UpdatedAt.After(latest) {
-            latest = manifest.UpdatedAt
+        if best == nil || manifestSupersedes(&manifest, best) {
+            best = &manifest
             selected = i
         }
     }
@@ -80,6 +111,147 @@ func (v *validator) Select(key string, values [][]byte) (int, error) {
     return selected, nil
 }
 
+// manifestSupersedes reports whether candidate should replace current. Higher
+// sequence numbers win; ties between different owners are broken by owner ID
+// so that every node converges on the same record regardless of arrival order.
+func manifestSupersedes(candidate, current *ManifestInfo) bool {
+    if candidate.Sequence != current.Sequence {
+        return candidate.Sequence > current.Sequence
+    }
+    if candidate.Owner != current.Owner {
+        return candidate.Owner < current.Owner
+    }
+    return bytes.Compare(candidate.Signature, current.Signature) < 0
+}
+
+// manifestOwners binds each manifest name to the first owner the node
+// accepted it from. Owners never change, so every later version of a name
+// must come from its bound owner or one of their co-owners.
+type manifestOwners struct {
+    owners map[string]string // DHT key of each name to its owner
+    mu     sync.RWMutex
+}
+
+func newManifestOwners() *manifestOwners {
+    return &manifestOwners{owners: make(map[string]string)}
+}
+
+// owner returns the owner name is bound to, empty if it is not bound
+func (o *manifestOwners) owner(name string) string {
+    if o == nil {
+        return ""
+    }
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+    return o.owners[getDHTKey(name)]
+}
+
+// claim binds name to owner if it is not bound yet, and fails with
+// ErrManifestOwner if it is bound to someone else
+func (o *manifestOwners) claim(name, owner string) error {
+    if o == nil {
+        return nil
+    }
+    key := getDHTKey(name)
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if current, ok := o.owners[key]; ok && current != owner {
+        return fmt.Errorf("%w: %s is owned by %s", ErrManifestOwner, name, current)
+    }
+    o.owners[key] = owner
+    return nil
+}
+
+// manifestSigningBytes returns the canonical bytes covered by a manifest signature
+func manifestSigningBytes(manifest *ManifestInfo) ([]byte, error) {
+    unsigned := *manifest
+    unsigned.Signature = nil
+    return json.Marshal(&unsigned)
+}
+
+// SignManifest signs a manifest with the owner's private key, setting Owner,
+// OwnerKey, CoOwnerSig and Signature. Sequence and UpdatedAt must be set by
+// the caller.
+func SignManifest(manifest *ManifestInfo, priv crypto.PrivKey) error {
+    if priv == nil {
+        return fmt.Errorf("no private key available for signing")
+    }
+
+    owner, err := peer.IDFromPrivateKey(priv)
+    if err != nil {
+        return fmt.Errorf("failed to derive owner ID: %w", err)
+    }
+    pubBytes, err := crypto.MarshalPublicKey(priv.GetPublic())
+    if err != nil {
+        return fmt.Errorf("failed to marshal public key: %w", err)
+    }
+
+    manifest.Owner = owner.String()
+    manifest.OwnerKey = pubBytes
+    manifest.Writer = ""
+    manifest.WriterKey = nil
+
+    // Co-owners publish under the owner's grant
+    manifest.CoOwnerSig = nil
+    if len(manifest.CoOwners) > 0 {
+        grant, err := coOwnerSigningBytes(manifest)
+        if err != nil {
+            return fmt.Errorf("failed to encode co-owners: %w", err)
+        }
+        if manifest.CoOwnerSig, err = priv.Sign(grant); err != nil {
+            return fmt.Errorf("failed to sign co-owners: %w", err)
+        }
+    }
+
+    payload, err := manifestSigningBytes(manifest)
+    if err != nil {
+        return fmt.Errorf("failed to encode manifest: %w", err)
+    }
+    sig, err := priv.Sign(payload)
+    if err != nil {
+        return fmt.Errorf("failed to sign manifest: %w", err)
+    }
+    manifest.Signature = sig
+    return nil
+}
+
+// VerifyManifest checks that a manifest carries a valid signature from its
+// Owner, or from a co-owner the Owner granted
+func VerifyManifest(manifest *ManifestInfo) error {
+    if len(manifest.Signature) == 0 || len(manifest.OwnerKey) == 0 {
+        return ErrManifestUnsigned
+    }
+
+    pub, err := crypto.UnmarshalPublicKey(manifest.OwnerKey)
+    if err != nil {
+        return fmt.Errorf("%w: bad owner key: %v", ErrManifestBadSig, err)
+    }
+    owner, err := peer.IDFromPublicKey(pub)
+    if err != nil {
+        return fmt.Errorf("%w: bad owner key: %v", ErrManifestBadSig, err)
+    }
+    if owner.String() != manifest.Owner {
+        return fmt.Errorf("%w: key belongs to %s, manifest claims %s", ErrManifestOwner, owner, manifest.Owner)
+    }
+
+    signer := pub
+    if manifest.Writer != "" {
+        if signer, err = verifyWriter(manifest, pub); err != nil {
+            return err
+        }
+    }
+
+    payload, err := manifestSigningBytes(manifest)
+    if err != nil {
+        return fmt.Errorf("failed to encode manifest: %w", err)
+    }
+    ok, err := signer.Verify(payload, manifest.Signature)
+    if err != nil || !ok {
+        return ErrManifestBadSig
+    }
+    return nil
+}
+
 const (
     manifestTopic            = "filezap-manifests"
     replicationCheckInterval = time.Minute * 5
@@ -92,8 +264,16 @@ type ManifestManager struct {
     dht       *dht.IpfsDHT
     store     map[string]*ManifestInfo
     localNode peer.ID
+    privKey   crypto.PrivKey
     topic     *pubsub.Topic
+    gossip    GossipManager
+    chunks    *ChunkStore // Enforces the access lists of stored manifests
+    history   map[string][]*ManifestInfo // Versions of each manifest, oldest first
+    cache     *manifestCache             // Manifests looked up in the DHT but not stored
+    pages     map[string]*ManifestPage   // Chunk list pages of stored manifests, by ID
+    owners    *manifestOwners            // Owner each name is bound to, shared with the DHT validator
     replicator *ManifestReplicator
+    mu        sync.RWMutex
 }
 
 // ManifestReplicator handles manifest replication across the network
@@ -116,10 +296,13 @@ func (m *ManifestManager) Stop() error {
 // NewManifestManager creates a new manifest manager
 func NewManifestManager(ctx context.Context, h host.Host, kdht *dht.IpfsDHT, ps *pubsub.PubSub) (*ManifestManager, error) {
     // Set up validator
+    owners := newManifestOwners()
     nsval := record.NamespacedValidator{
         "pk":     record.PublicKeyValidator{},
         "ipns":   record.PublicKeyValidator{},
-        "filezap": &validator{},
+        "filezap": &validator{owners: owners},
+        pageNamespace: pageValidator{},
+        vpn.ClaimNamespace: vpn.ClaimValidator{},
     }
     kdht.Validator = nsval
 
@@ -154,9 +337,14 @@ init:
         ctx:       ctx,
         dht:       kdht,
         store:     make(map[string]*ManifestInfo),
+        history:   make(map[string][]*ManifestInfo),
+        pages:     make(map[string]*ManifestPage),
+        owners:    owners,
         localNode: h.ID(),
+        privKey:   h.Peerstore().PrivKey(h.ID()),
         topic:     topic,
     }
+    mm.cache = newManifestCache(DefaultManifestCacheConfig(), mm.lookupManifest)
 
 // Create and start replicator
 mm.replicator = NewManifestReplicator(kdht, mm)
@@ -170,7 +358,10 @@ mm.replicator = NewManifestReplicator(kdht, mm)
 return mm, nil
 }
 
-// AddManifest stores a manifest and ensures it meets replication goals
+// AddManifest stores a manifest and ensures it meets replication goals.
+// Manifests owned by this node are signed here. So are unsigned updates to
+// manifests this node is a co-owner of, which must name the current owner;
+// the owner's co-owner list and access list are kept.
 func (m *ManifestManager) AddManifest(manifest *ManifestInfo) error {
     // Validate manifest
     if manifest == nil {
@@ -179,9 +370,15 @@ func (m *ManifestManager) AddManifest(manifest *ManifestInfo) error {
     if manifest.Name == "" {
         return fmt.Errorf("manifest name cannot be empty")
     }
-    if len(manifest.ChunkHashes) == 0 {
+    if manifest.NumChunks() == 0 {
         return fmt.Errorf("manifest must have at least one chunk hash")
     }
+    if err := validateChunkCIDs(manifest); err != nil {
+        return err
+    }
+    if err := validateChunkPages(manifest); err != nil {
+        return err
+    }
     if manifest.ReplicationGoal <= 0 {
         return fmt.Errorf("replication goal must be greater than 0")
     }
@@ -189,11 +386,73 @@ func (m *ManifestManager) AddManifest(manifest *ManifestInfo) error {
         return fmt.Errorf("manifest must have an owner")
     }
 
-    // Set update timestamp
-    manifest.UpdatedAt = time.Now()
+    m.mu.Lock()
+    current := m.store[manifest.Name]
+    source := "remote"
+    local := m.localNode.String()
+    coWriter := manifest.Owner != local && len(manifest.Signature) == 0 && current != nil &&
+        current.Owner == manifest.Owner && containsString(current.CoOwners, local)
+    if manifest.Owner == local || coWriter {
+        source = "local"
+        // Local manifests are re-signed as the next version
+        if current != nil && current.Owner != manifest.Owner {
+            m.mu.Unlock()
+            metrics.ManifestUpdates.WithLabelValues(source, "rejected").Inc()
+            return fmt.Errorf("%w: %s is owned by %s", ErrManifestOwner, manifest.Name, current.Owner)
+        }
+        if owner := m.owners.owner(manifest.Name); owner != "" && owner != manifest.Owner {
+            m.mu.Unlock()
+            metrics.ManifestUpdates.WithLabelValues(source, "rejected").Inc()
+            return fmt.Errorf("%w: %s is owned by %s", ErrManifestOwner, manifest.Name, owner)
+        }
+        if len(manifest.ChunkHashes) > 0 {
+            manifest.ChunkRoot = ChunkMerkleRoot(manifest.ChunkHashes)
+        }
+        pages, err := PageManifest(manifest)
+        if err != nil {
+            m.mu.Unlock()
+            metrics.ManifestUpdates.WithLabelValues(source, "rejected").Inc()
+            return err
+        }
+        m.holdPages(pages...)
+        manifest.Versions = nextVersions(current, local)
+        manifest.Sequence = manifest.Versions.Sum()
+        manifest.UpdatedAt = time.Now()
+        if coWriter {
+            err = signCoOwnerUpdate(manifest, current, m.privKey)
+        } else {
+            err = SignManifest(manifest, m.privKey)
+        }
+        if err != nil {
+            m.mu.Unlock()
+            metrics.ManifestUpdates.WithLabelValues(source, "rejected").Inc()
+            return err
+        }
+    } else {
+        // Manifests from other owners must already carry a valid signature
+        if err := VerifyManifest(manifest); err != nil {
+            m.mu.Unlock()
+            metrics.ManifestUpdates.WithLabelValues(source, "rejected").Inc()
+            return err
+        }
+        if err := validateChunkRoot(manifest); err != nil {
+            m.mu.Unlock()
+            metrics.ManifestUpdates.WithLabelValues(source, "rejected").Inc()
+            return err
+        }
+        if err := m.apply(manifest); err != nil {
+            m.mu.Unlock()
+            metrics.ManifestUpdates.WithLabelValues(source, "rejected").Inc()
+            return err
+        }
+    }
 
     // Store locally
-    m.store[manifest.Name] = manifest
+    if source == "local" {
+        m.put(manifest)
+    }
+    m.mu.Unlock()
+    metrics.ManifestUpdates.WithLabelValues(source, "accepted").Inc()
 
 // Store in DHT
 data, err := json.Marshal(manifest)
@@ -212,6 +471,10 @@ if len(peers) == 0 {
 ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 defer cancel()
 
+// Pages go first so that readers of the manifest can find them
+if err := m.publishPages(ctx, manifest); err != nil {
+    return err
+}
 if err := m.dht.PutValue(ctx, getDHTKey(manifest.Name), data); err != nil {
     return fmt.Errorf("failed to store manifest in DHT: %w", err)
 }
@@ -224,32 +487,150 @@ if err := m.dht.PutValue(ctx, getDHTKey(manifest.Name), data); err != nil {
 		}
 	}
 
+	// Hint gossip peers that a newer version is in the DHT
+	m.mu.RLock()
+	gm := m.gossip
+	m.mu.RUnlock()
+	if gm != nil {
+		hint := &ManifestHint{Name: manifest.Name, Sequence: manifest.Sequence}
+		if err := gm.Publish(GossipManifestHint, hint); err != nil {
+			fmt.Printf("failed to gossip manifest hint: %v\n", err)
+		}
+	}
+
 	return nil
 }
 
-// GetManifest retrieves a manifest from local store or DHT
+// RegisterGossip sends and receives manifest hints over the gossip dispatcher
+func (m *ManifestManager) RegisterGossip(gm GossipManager) {
+	m.mu.Lock()
+	m.gossip = gm
+	m.mu.Unlock()
+
+	gm.Subscribe(GossipManifestHint, func(_ peer.ID, payload json.RawMessage) {
+		var hint ManifestHint
+		if err := json.Unmarshal(payload, &hint); err != nil {
+			return
+		}
+		go m.handleManifestHint(&hint)
+	})
+}
+
+// RegisterChunkStore has cs enforce the access lists of the manifests this
+// manager stores, including those already stored
+func (m *ManifestManager) RegisterChunkStore(cs *ChunkStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chunks = cs
+	for _, manifest := range m.store {
+		cs.setManifestAccess(manifest, m.heldChunkHashes(manifest))
+	}
+}
+
+// put stores a manifest locally, binds its name to its owner, records it
+// in the manifest's history, applies its access list and drops any cached
+// lookup of it. m.mu must be held.
+func (m *ManifestManager) put(manifest *ManifestInfo) {
+	m.owners.claim(manifest.Name, manifest.Owner)
+	m.store[manifest.Name] = manifest
+	m.record(manifest)
+	if m.cache != nil {
+		m.cache.invalidate(manifest.Name)
+	}
+	if m.chunks != nil {
+		m.chunks.setManifestAccess(manifest, m.heldChunkHashes(manifest))
+	}
+}
+
+// handleManifestHint fetches a manifest from the DHT when a hint announces a
+// newer sequence than the one stored locally
+func (m *ManifestManager) handleManifestHint(hint *ManifestHint) {
+	m.mu.RLock()
+	current := m.store[hint.Name]
+	m.mu.RUnlock()
+	if current != nil && current.Sequence >= hint.Sequence {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+	defer cancel()
+	data, err := m.dht.GetValue(ctx, getDHTKey(hint.Name))
+	if err != nil {
+		return
+	}
+
+	var fetched ManifestInfo
+	if err := json.Unmarshal(data, &fetched); err != nil {
+		return
+	}
+	if fetched.Name != hint.Name || VerifyManifest(&fetched) != nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.apply(&fetched)
+	m.mu.Unlock()
+}
+
+// GetManifest retrieves a manifest from local store or, through the cache,
+// the DHT. Manifests found in the DHT must come from the owner the name is
+// bound to.
 func (m *ManifestManager) GetManifest(name string) (*ManifestInfo, error) {
 	// Check local store first
-	if manifest, ok := m.store[name]; ok {
+	m.mu.RLock()
+	manifest, ok := m.store[name]
+	cache := m.cache
+	m.mu.RUnlock()
+	if ok {
 		return manifest, nil
 	}
+	manifest, err := cache.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.owners.claim(name, manifest.Owner); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
 
-// Try to get from DHT
-dhtKey := getDHTKey(name)
-data, err := m.dht.GetValue(context.Background(), dhtKey)
-if err != nil {
-    // Pass through the DHT error directly so it can be properly checked
-    return nil, err
+// SetCacheConfig replaces the cache of manifests looked up in the DHT with
+// an empty one using config
+func (m *ManifestManager) SetCacheConfig(config ManifestCacheConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cache = newManifestCache(config, m.lookupManifest)
+	m.mu.Unlock()
+	return nil
 }
 
-	var manifest ManifestInfo
-	if err := json.Unmarshal(data, &manifest); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+// lookupManifest fetches and verifies a manifest from the DHT
+func (m *ManifestManager) lookupManifest(name string) (*ManifestInfo, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, manifestLookupTimeout)
+	defer cancel()
+	data, err := m.dht.GetValue(ctx, getDHTKey(name))
+	if err != nil {
+		// Pass through the DHT error directly so it can be properly checked
+		return nil, err
 	}
 
-	// Cache locally
-	m.store[name] = &manifest
-	return &manifest, nil
+	var fetched ManifestInfo
+	if err := json.Unmarshal(data, &fetched); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	}
+	if fetched.Name != name {
+		return nil, fmt.Errorf("DHT record for %s holds manifest %s", name, fetched.Name)
+	}
+	if err := VerifyManifest(&fetched); err != nil {
+		return nil, err
+	}
+	if err := validateChunkRoot(&fetched); err != nil {
+		return nil, err
+	}
+	return &fetched, nil
 }
 
 // subscribeToUpdates subscribes to manifest updates via pubsub
@@ -279,10 +660,85 @@ func (m *ManifestManager) subscribeToUpdates(ctx context.Context) {
 		if err := json.Unmarshal(msg.Data, &manifest); err != nil {
 			continue
 		}
+		if err := VerifyManifest(&manifest); err != nil {
+			metrics.ManifestUpdates.WithLabelValues("pubsub", "rejected").Inc()
+			continue
+		}
+
+		// Update local store if this is a newer version from the same owner
+		m.mu.Lock()
+		if m.apply(&manifest) == nil {
+			metrics.ManifestUpdates.WithLabelValues("pubsub", "accepted").Inc()
+		} else {
+			metrics.ManifestUpdates.WithLabelValues("pubsub", "rejected").Inc()
+		}
+		m.mu.Unlock()
+	}
+}
+
+// apply stores a manifest received from another node if it comes from the
+// owner of its name and may replace the current version. A version that loses to a concurrent update is only
+// recorded in the history. m.mu must be held.
+func (m *ManifestManager) apply(manifest *ManifestInfo) error {
+	current := m.store[manifest.Name]
+	if current == nil {
+		if err := m.owners.claim(manifest.Name, manifest.Owner); err != nil {
+			return err
+		}
+	}
+	err := checkManifestUpdate(current, manifest)
+	if errors.Is(err, ErrManifestConflict) {
+		m.record(manifest)
+	}
+	if err != nil {
+		return err
+	}
+	m.put(manifest)
+	return nil
+}
+
+// checkManifestUpdate verifies that update may replace current: the owner
+// must match, a co-owner must still be one and may not change who can read
+// or write the file, and update must include every update current does.
+// Concurrent updates, neither including the other, are ordered by
+// manifestSupersedes so that every node keeps the same one.
+func checkManifestUpdate(current, update *ManifestInfo) error {
+	if current == nil {
+		return nil
+	}
+	if current.Owner != update.Owner {
+		return fmt.Errorf("%w: %s is owned by %s", ErrManifestOwner, update.Name, current.Owner)
+	}
+	if update.Writer != "" {
+		if !containsString(current.CoOwners, update.Writer) {
+			return fmt.Errorf("%w: %s is not a co-owner of %s", ErrManifestOwner, update.Writer, update.Name)
+		}
+		if !bytes.Equal(update.CoOwnerSig, current.CoOwnerSig) || !reflect.DeepEqual(update.ACL, current.ACL) {
+			return fmt.Errorf("%w: only the owner of %s may change its co-owners or access list", ErrManifestOwner, update.Name)
+		}
+	}
+
+	have, got := manifestVersions(current), manifestVersions(update)
+	switch {
+	case have.Includes(got):
+		return fmt.Errorf("%w: have %d, got %d", ErrManifestStale, current.Sequence, update.Sequence)
+	case got.Includes(have), manifestSupersedes(update, current):
+		return nil
+	default:
+		return fmt.Errorf("%w: %s version %d", ErrManifestConflict, update.Name, update.Sequence)
+	}
+}
 
-		// Update local store
-		m.store[manifest.Name] = &manifest
+// snapshot returns a copy of the locally stored manifests
+func (m *ManifestManager) snapshot() []*ManifestInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	manifests := make([]*ManifestInfo, 0, len(m.store))
+	for _, manifest := range m.store {
+		manifests = append(manifests, manifest)
 	}
+	return manifests
 }
 
 // NewManifestReplicator creates a new manifest replicator
@@ -312,9 +768,14 @@ func (r *ManifestReplicator) Start(ctx context.Context) {
 // checkReplication ensures all manifests meet their replication goals
 func (r *ManifestReplicator) checkReplication() {
 	ctx := context.Background()
+	lag := 0
+	defer func() {
+		metrics.ReplicationLag.Set(float64(lag))
+		metrics.ReplicationLastRun.SetToCurrentTime()
+	}()
 
 	// Get all manifests we're responsible for storing
-	for _, manifest := range r.manifests.store {
+	for _, manifest := range r.manifests.snapshot() {
 		// Get the XOR distance between our node ID and the manifest key
 		manifestKey := getDHTKey(manifest.Name)
 		localDist := xorDistance(r.manifests.localNode.String(), manifestKey)
@@ -342,20 +803,25 @@ func (r *ManifestReplicator) checkReplication() {
 
 		// If we're one of the N closest nodes, ensure we have the manifest
 		if closerPeers < manifest.ReplicationGoal {
-			// We should store this manifest
-			if _, ok := r.manifests.store[manifest.Name]; !ok {
-				// Get manifest from another peer
-				data, err := r.dht.GetValue(ctx, manifestKey)
-				if err != nil {
-					continue
-				}
-
+			// Pick up any newer version another replica may hold
+			if data, err := r.dht.GetValue(ctx, manifestKey); err == nil {
 				var fetchedManifest ManifestInfo
-				if err := json.Unmarshal(data, &fetchedManifest); err != nil {
-					continue
+				if err := json.Unmarshal(data, &fetchedManifest); err == nil && VerifyManifest(&fetchedManifest) == nil {
+					r.manifests.mu.Lock()
+					r.manifests.apply(&fetchedManifest)
+					r.manifests.mu.Unlock()
 				}
+			}
 
-				r.manifests.store[manifest.Name] = &fetchedManifest
+			// Replicas serve the chunk list pages along with the manifest
+			r.manifests.mu.RLock()
+			stored := r.manifests.store[manifest.Name]
+			r.manifests.mu.RUnlock()
+			if stored == nil {
+				continue
+			}
+			if err := r.manifests.fetchPages(ctx, stored); err != nil {
+				continue
 			}
 
 			// Announce that we're providing this manifest
@@ -379,13 +845,20 @@ func (r *ManifestReplicator) checkReplication() {
 		if err != nil {
 			continue
 		}
+		for _, provider := range providers {
+			tagPeerRole(r.dht.Host(), provider.ID, RoleManifestReplica)
+		}
 
 		// If insufficient providers found, publish manifest again
 		if len(providers) < manifest.ReplicationGoal {
+			lag += manifest.ReplicationGoal - len(providers)
 			data, err := json.Marshal(manifest)
 			if err != nil {
 				continue
 			}
+			if err := r.manifests.publishPages(ctx, manifest); err != nil {
+				continue
+			}
 			if err := r.dht.PutValue(ctx, manifestKey, data); err != nil {
 				continue
 			}
@@ -7,7 +7,9 @@ import (
     "testing"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
     "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p/core/crypto"
     dht "github.com/libp2p/go-libp2p-kad-dht"
     pubsub "github.com/libp2p/go-libp2p-pubsub"
     record "github.com/libp2p/go-libp2p-record"
@@ -104,7 +106,9 @@ func setupTestManifestNetwork(ctx context.Context, t *testing.T) (host.Host, *dh
         Owner:          h1.ID().String(),
         Size:            100,
         UpdatedAt:       time.Now(),
+        Sequence:        1,
     }
+    require.NoError(t, SignManifest(testManifest, h1.Peerstore().PrivKey(h1.ID())))
     testData, err := json.Marshal(testManifest)
     require.NoError(t, err)
     require.NoError(t, d1.PutValue(ctx, testKey, testData))
@@ -401,3 +405,280 @@ func TestManifestNonexistent(t *testing.T) {
     _, err = mm.GetManifest("nonexistent.zap")
     assert.Error(t, err)
 }
+
+func newSignedTestManifest(t *testing.T, priv crypto.PrivKey, name string, seq uint64) *ManifestInfo {
+    manifest := &ManifestInfo{
+        Name:            name,
+        ChunkHashes:     []string{"hash1"},
+        ReplicationGoal: DefaultReplicationGoal,
+        Size:            1024,
+        Sequence:        seq,
+    }
+    require.NoError(t, SignManifest(manifest, priv))
+    return manifest
+}
+
+func TestManifestSignatures(t *testing.T) {
+    priv, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+    other, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+
+    v := &validator{}
+
+    t.Run("Signed manifest validates", func(t *testing.T) {
+        manifest := newSignedTestManifest(t, priv, "signed.zap", 1)
+        require.NoError(t, VerifyManifest(manifest))
+
+        data, err := json.Marshal(manifest)
+        require.NoError(t, err)
+        assert.NoError(t, v.Validate(getDHTKey("signed.zap"), data))
+    })
+
+    t.Run("Unsigned manifest rejected", func(t *testing.T) {
+        manifest := &ManifestInfo{Name: "unsigned.zap", Owner: "someone", ChunkHashes: []string{"hash1"}}
+        assert.ErrorIs(t, VerifyManifest(manifest), ErrManifestUnsigned)
+
+        data, err := json.Marshal(manifest)
+        require.NoError(t, err)
+        assert.Error(t, v.Validate(getDHTKey("unsigned.zap"), data))
+    })
+
+    t.Run("Tampered manifest rejected", func(t *testing.T) {
+        manifest := newSignedTestManifest(t, priv, "tampered.zap", 1)
+        manifest.ChunkHashes = append(manifest.ChunkHashes, "injected")
+        assert.ErrorIs(t, VerifyManifest(manifest), ErrManifestBadSig)
+    })
+
+    t.Run("Spoofed owner rejected", func(t *testing.T) {
+        manifest := newSignedTestManifest(t, priv, "spoofed.zap", 1)
+        otherID, err := peer.IDFromPrivateKey(other)
+        require.NoError(t, err)
+        manifest.Owner = otherID.String()
+        assert.ErrorIs(t, VerifyManifest(manifest), ErrManifestOwner)
+    })
+
+    t.Run("Record under foreign key rejected", func(t *testing.T) {
+        manifest := newSignedTestManifest(t, priv, "real.zap", 1)
+        data, err := json.Marshal(manifest)
+        require.NoError(t, err)
+        assert.Error(t, v.Validate(getDHTKey("other.zap"), data))
+    })
+}
+
+func TestManifestSelectBySequence(t *testing.T) {
+    priv, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+
+    v := &validator{}
+    older, err := json.Marshal(newSignedTestManifest(t, priv, "select.zap", 1))
+    require.NoError(t, err)
+    newer, err := json.Marshal(newSignedTestManifest(t, priv, "select.zap", 2))
+    require.NoError(t, err)
+
+    // Newer sequence wins even if it is not the most recent wall-clock update
+    selected, err := v.Select(getDHTKey("select.zap"), [][]byte{older, newer, []byte("garbage")})
+    require.NoError(t, err)
+    assert.Equal(t, 1, selected)
+
+    // Stale updates from the same owner are refused
+    current := newSignedTestManifest(t, priv, "select.zap", 2)
+    stale := newSignedTestManifest(t, priv, "select.zap", 2)
+    assert.ErrorIs(t, checkManifestUpdate(current, stale), ErrManifestStale)
+}
+
+func TestManifestOwnerBinding(t *testing.T) {
+    owner, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+    hijacker, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+
+    original := newSignedTestManifest(t, owner, "owned.zap", 1)
+    hijack := newSignedTestManifest(t, hijacker, "owned.zap", 5)
+    originalData, err := json.Marshal(original)
+    require.NoError(t, err)
+    hijackData, err := json.Marshal(hijack)
+    require.NoError(t, err)
+
+    // A higher sequence from another owner does not take the name
+    v := &validator{owners: newManifestOwners()}
+    key := getDHTKey("owned.zap")
+    require.NoError(t, v.Validate(key, originalData))
+    assert.ErrorIs(t, v.Validate(key, hijackData), ErrManifestOwner)
+    selected, err := v.Select(key, [][]byte{hijackData, originalData})
+    require.NoError(t, err)
+    assert.Equal(t, 1, selected)
+
+    // Without a binding the first valid record's owner keeps the name
+    selected, err = (&validator{}).Select(key, [][]byte{originalData, hijackData})
+    require.NoError(t, err)
+    assert.Equal(t, 0, selected)
+
+    // Lookups and updates from another owner are refused too
+    m := &ManifestManager{store: make(map[string]*ManifestInfo), owners: newManifestOwners()}
+    m.cache = newManifestCache(DefaultManifestCacheConfig(), func(name string) (*ManifestInfo, error) {
+        return hijack, nil
+    })
+    require.NoError(t, m.apply(original))
+    delete(m.store, "owned.zap")
+    _, err = m.GetManifest("owned.zap")
+    assert.ErrorIs(t, err, ErrManifestOwner)
+    assert.ErrorIs(t, m.apply(hijack), ErrManifestOwner)
+}
+
+// newVersion returns the next version of current, which may be nil, written
+// by priv as owner or co-owner
+func newVersion(t *testing.T, priv crypto.PrivKey, current *ManifestInfo, hash string) *ManifestInfo {
+    id, err := peer.IDFromPrivateKey(priv)
+    require.NoError(t, err)
+    manifest := &ManifestInfo{
+        Name:            "shared.zap",
+        ChunkHashes:     []string{hash},
+        ReplicationGoal: DefaultReplicationGoal,
+        Size:            1024,
+        Versions:        nextVersions(current, id.String()),
+    }
+    manifest.Sequence = manifest.Versions.Sum()
+    if current == nil || current.Owner == id.String() {
+        if current != nil {
+            manifest.CoOwners = current.CoOwners
+        }
+        require.NoError(t, SignManifest(manifest, priv))
+    } else {
+        require.NoError(t, signCoOwnerUpdate(manifest, current, priv))
+    }
+    return manifest
+}
+
+func TestManifestCoOwners(t *testing.T) {
+    owner, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+    coOwner, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+    stranger, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+    coOwnerID, err := peer.IDFromPrivateKey(coOwner)
+    require.NoError(t, err)
+    strangerID, err := peer.IDFromPrivateKey(stranger)
+    require.NoError(t, err)
+
+    first := &ManifestInfo{
+        Name:            "shared.zap",
+        ChunkHashes:     []string{"hash1"},
+        ReplicationGoal: DefaultReplicationGoal,
+        CoOwners:        []string{coOwnerID.String()},
+        Sequence:        1,
+    }
+    require.NoError(t, SignManifest(first, owner))
+    require.NoError(t, VerifyManifest(first))
+
+    // Co-owners publish updates under their own signatures
+    update := newVersion(t, coOwner, first, "hash2")
+    assert.Equal(t, first.Owner, update.Owner)
+    assert.Equal(t, coOwnerID.String(), update.Writer)
+    require.NoError(t, VerifyManifest(update))
+    assert.NoError(t, checkManifestUpdate(first, update))
+    assert.ErrorIs(t, checkManifestUpdate(update, first), ErrManifestStale)
+
+    t.Run("Other peers may not write", func(t *testing.T) {
+        forged := newVersion(t, stranger, first, "injected")
+        assert.ErrorIs(t, VerifyManifest(forged), ErrManifestOwner)
+
+        // Nor grant themselves co-ownership
+        forged.CoOwners = append(forged.CoOwners, strangerID.String())
+        assert.ErrorIs(t, VerifyManifest(forged), ErrManifestBadSig)
+    })
+
+    t.Run("Co-owners may not change access", func(t *testing.T) {
+        restricted := *first
+        restricted.ACL = &types.AccessList{ClientIDs: []string{"friend"}}
+        assert.ErrorIs(t, checkManifestUpdate(&restricted, update), ErrManifestOwner)
+
+        revoked := newVersion(t, owner, first, "hash1")
+        revoked.CoOwners = nil
+        require.NoError(t, SignManifest(revoked, owner))
+        assert.ErrorIs(t, checkManifestUpdate(revoked, newVersion(t, coOwner, first, "late")), ErrManifestOwner)
+    })
+
+    t.Run("Concurrent updates converge", func(t *testing.T) {
+        concurrent := newVersion(t, owner, first, "hash3")
+        assert.Equal(t, update.Sequence, concurrent.Sequence)
+
+        forward := checkManifestUpdate(update, concurrent)
+        backward := checkManifestUpdate(concurrent, update)
+        if manifestSupersedes(concurrent, update) {
+            assert.NoError(t, forward)
+            assert.ErrorIs(t, backward, ErrManifestConflict)
+        } else {
+            assert.ErrorIs(t, forward, ErrManifestConflict)
+            assert.NoError(t, backward)
+        }
+
+        // An update including both follows either
+        merged := &ManifestInfo{
+            Name:            "shared.zap",
+            ChunkHashes:     []string{"hash4"},
+            ReplicationGoal: DefaultReplicationGoal,
+            Versions:        nextVersions(concurrent, coOwnerID.String()),
+        }
+        merged.Versions[coOwnerID.String()]++ // The co-owner's second update
+        merged.Sequence = merged.Versions.Sum()
+        require.NoError(t, signCoOwnerUpdate(merged, first, coOwner))
+        assert.NoError(t, checkManifestUpdate(update, merged))
+        assert.NoError(t, checkManifestUpdate(concurrent, merged))
+    })
+}
+
+func TestManifestHistory(t *testing.T) {
+    owner, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+    coOwner, _, err := crypto.GenerateEd25519Key(nil)
+    require.NoError(t, err)
+    coOwnerID, err := peer.IDFromPrivateKey(coOwner)
+    require.NoError(t, err)
+
+    m := &ManifestManager{store: make(map[string]*ManifestInfo)}
+    first := &ManifestInfo{
+        Name:            "shared.zap",
+        ChunkHashes:     []string{"hash1"},
+        ReplicationGoal: DefaultReplicationGoal,
+        CoOwners:        []string{coOwnerID.String()},
+        Sequence:        1,
+    }
+    require.NoError(t, SignManifest(first, owner))
+    require.NoError(t, m.apply(first))
+
+    // Both of two concurrent versions are kept
+    update := newVersion(t, coOwner, first, "hash2")
+    concurrent := newVersion(t, owner, first, "hash3")
+    m.apply(update)
+    m.apply(concurrent)
+    assert.Len(t, m.ManifestHistory("shared.zap"), 3)
+
+    version, err := m.GetManifestVersion("shared.zap", 1)
+    require.NoError(t, err)
+    assert.Equal(t, []string{"hash1"}, version.ChunkHashes)
+    winner := update
+    if manifestSupersedes(concurrent, update) {
+        winner = concurrent
+    }
+    version, err = m.GetManifestVersion("shared.zap", 2)
+    require.NoError(t, err)
+    assert.Equal(t, winner.ChunkHashes, version.ChunkHashes)
+    assert.Equal(t, winner.ChunkHashes, m.store["shared.zap"].ChunkHashes)
+
+    _, err = m.GetManifestVersion("shared.zap", 3)
+    assert.ErrorIs(t, err, ErrManifestVersion)
+
+    // Old versions are dropped past the limit
+    current := m.store["shared.zap"]
+    for i := 0; i < ManifestHistoryLimit; i++ {
+        current = newVersion(t, owner, current, fmt.Sprintf("hash%d", i))
+        require.NoError(t, m.apply(current))
+    }
+    history := m.ManifestHistory("shared.zap")
+    assert.Len(t, history, ManifestHistoryLimit)
+    assert.Equal(t, current.Sequence, history[len(history)-1].Sequence)
+    _, err = m.GetManifestVersion("shared.zap", 1)
+    assert.ErrorIs(t, err, ErrManifestVersion)
+}
@@ -0,0 +1,184 @@
+package network
+
+import (
+    "container/list"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
+    "github.com/libp2p/go-libp2p/core/routing"
+)
+
+// Manifests this node does not store are looked up in the DHT and kept in
+// a manifestCache. Found manifests are kept for the configured TTL and
+// names the DHT has no record of for the shorter NegativeTTL, so repeated
+// lookups of a missing file do not each walk the DHT. Concurrent lookups of
+// a name share one DHT query. A manifest read manifestHotHits times since
+// it was fetched is refreshed in the background once it nears expiry, so
+// readers of a popular file keep getting answers without waiting on the
+// DHT. The least recently used entries are evicted beyond Size.
+const (
+    manifestLookupTimeout = 30 * time.Second
+    manifestHotHits       = 3 // Reads after which a manifest is refreshed before it expires
+    manifestRefreshWindow = 4 // Refresh within the last 1/manifestRefreshWindow of the TTL
+)
+
+// manifestFetcher looks a manifest up in the DHT
+type manifestFetcher func(name string) (*ManifestInfo, error)
+
+// manifestCache holds the results of DHT manifest lookups
+type manifestCache struct {
+    config   ManifestCacheConfig
+    fetch    manifestFetcher
+    entries  map[string]*list.Element
+    order    *list.List // Cached names, most recently used first
+    inflight map[string]*manifestLookup
+    mu       sync.Mutex
+}
+
+// manifestCacheEntry is a cached lookup result; a nil manifest records
+// that the DHT has no record of the name
+type manifestCacheEntry struct {
+    name       string
+    manifest   *ManifestInfo
+    err        error
+    expires    time.Time
+    hits       int
+    refreshing bool
+}
+
+// manifestLookup is a DHT query callers of the same name wait on
+type manifestLookup struct {
+    done     chan struct{}
+    manifest *ManifestInfo
+    err      error
+}
+
+func newManifestCache(config ManifestCacheConfig, fetch manifestFetcher) *manifestCache {
+    return &manifestCache{
+        config:   config,
+        fetch:    fetch,
+        entries:  make(map[string]*list.Element),
+        order:    list.New(),
+        inflight: make(map[string]*manifestLookup),
+    }
+}
+
+// get returns the cached result for name, looking it up when there is none
+func (c *manifestCache) get(name string) (*ManifestInfo, error) {
+    c.mu.Lock()
+    if elem, ok := c.entries[name]; ok {
+        entry := elem.Value.(*manifestCacheEntry)
+        if time.Now().Before(entry.expires) {
+            c.order.MoveToFront(elem)
+            entry.hits++
+            if c.shouldRefresh(entry) {
+                entry.refreshing = true
+                go c.refresh(name)
+            }
+            c.mu.Unlock()
+            if entry.manifest == nil {
+                metrics.ManifestCacheLookups.WithLabelValues("negative").Inc()
+                return nil, entry.err
+            }
+            metrics.ManifestCacheLookups.WithLabelValues("hit").Inc()
+            return entry.manifest, nil
+        }
+        c.removeLocked(elem)
+    }
+    metrics.ManifestCacheLookups.WithLabelValues("miss").Inc()
+
+    if lookup, ok := c.inflight[name]; ok {
+        c.mu.Unlock()
+        <-lookup.done
+        return lookup.manifest, lookup.err
+    }
+    lookup := &manifestLookup{done: make(chan struct{})}
+    c.inflight[name] = lookup
+    c.mu.Unlock()
+
+    lookup.manifest, lookup.err = c.fetch(name)
+
+    c.mu.Lock()
+    delete(c.inflight, name)
+    c.storeLocked(name, lookup.manifest, lookup.err)
+    c.mu.Unlock()
+    close(lookup.done)
+    return lookup.manifest, lookup.err
+}
+
+// shouldRefresh reports whether a hot positive entry is close enough to
+// expiry to be refreshed. c.mu must be held.
+func (c *manifestCache) shouldRefresh(entry *manifestCacheEntry) bool {
+    if entry.manifest == nil || entry.refreshing || entry.hits < manifestHotHits {
+        return false
+    }
+    return time.Until(entry.expires) < c.config.TTL/manifestRefreshWindow
+}
+
+// refresh looks a hot manifest up again. A failed refresh leaves the
+// current entry to expire.
+func (c *manifestCache) refresh(name string) {
+    manifest, err := c.fetch(name)
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    elem, ok := c.entries[name]
+    if !ok {
+        // Invalidated while refreshing; the next lookup starts afresh
+        return
+    }
+    if err != nil {
+        elem.Value.(*manifestCacheEntry).refreshing = false
+        return
+    }
+    c.storeLocked(name, manifest, nil)
+}
+
+// storeLocked caches a lookup result. Errors other than the DHT having no
+// record are not cached. c.mu must be held.
+func (c *manifestCache) storeLocked(name string, manifest *ManifestInfo, err error) {
+    ttl := c.config.TTL
+    if err != nil {
+        if !errors.Is(err, routing.ErrNotFound) || c.config.NegativeTTL <= 0 {
+            return
+        }
+        ttl = c.config.NegativeTTL
+        manifest = nil
+    }
+
+    entry := &manifestCacheEntry{name: name, manifest: manifest, err: err, expires: time.Now().Add(ttl)}
+    if elem, ok := c.entries[name]; ok {
+        elem.Value = entry
+        c.order.MoveToFront(elem)
+        return
+    }
+    c.entries[name] = c.order.PushFront(entry)
+    for c.order.Len() > c.config.Size {
+        c.removeLocked(c.order.Back())
+    }
+}
+
+// invalidate drops the cached result for name, for when a newer manifest
+// is stored locally
+func (c *manifestCache) invalidate(name string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if elem, ok := c.entries[name]; ok {
+        c.removeLocked(elem)
+    }
+}
+
+// removeLocked drops a cache entry. c.mu must be held.
+func (c *manifestCache) removeLocked(elem *list.Element) {
+    c.order.Remove(elem)
+    delete(c.entries, elem.Value.(*manifestCacheEntry).name)
+}
+
+// len returns the number of cached results
+func (c *manifestCache) len() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.order.Len()
+}
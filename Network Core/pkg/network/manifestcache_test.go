@@ -0,0 +1,142 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestCacheHitsAndNegatives(t *testing.T) {
+	var calls atomic.Int32
+	fetch := func(name string) (*ManifestInfo, error) {
+		calls.Add(1)
+		if name == "missing" {
+			return nil, routing.ErrNotFound
+		}
+		if name == "broken" {
+			return nil, errors.New("timed out")
+		}
+		return &ManifestInfo{Name: name}, nil
+	}
+	c := newManifestCache(DefaultManifestCacheConfig(), fetch)
+
+	for i := 0; i < 3; i++ {
+		m, err := c.get("file")
+		require.NoError(t, err)
+		assert.Equal(t, "file", m.Name)
+	}
+	assert.EqualValues(t, 1, calls.Load())
+
+	// Names the DHT has no record of are cached too
+	for i := 0; i < 3; i++ {
+		_, err := c.get("missing")
+		assert.ErrorIs(t, err, routing.ErrNotFound)
+	}
+	assert.EqualValues(t, 2, calls.Load())
+
+	// Other failures are retried
+	for i := 0; i < 2; i++ {
+		_, err := c.get("broken")
+		assert.Error(t, err)
+	}
+	assert.EqualValues(t, 4, calls.Load())
+
+	c.invalidate("file")
+	_, err := c.get("file")
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, calls.Load())
+}
+
+func TestManifestCacheEviction(t *testing.T) {
+	config := DefaultManifestCacheConfig()
+	config.Size = 2
+	c := newManifestCache(config, func(name string) (*ManifestInfo, error) {
+		return &ManifestInfo{Name: name}, nil
+	})
+
+	for _, name := range []string{"a", "b", "a", "c"} {
+		_, err := c.get(name)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, c.len())
+
+	// "b" was least recently used
+	c.mu.Lock()
+	_, hasA := c.entries["a"]
+	_, hasB := c.entries["b"]
+	c.mu.Unlock()
+	assert.True(t, hasA)
+	assert.False(t, hasB)
+}
+
+func TestManifestCacheSharesLookups(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := newManifestCache(DefaultManifestCacheConfig(), func(name string) (*ManifestInfo, error) {
+		calls.Add(1)
+		<-release
+		return &ManifestInfo{Name: name}, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := c.get("file")
+			assert.NoError(t, err)
+			assert.Equal(t, "file", m.Name)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestManifestCacheRefreshesHotEntries(t *testing.T) {
+	config := DefaultManifestCacheConfig()
+	config.TTL = time.Second
+	var calls atomic.Int32
+	c := newManifestCache(config, func(name string) (*ManifestInfo, error) {
+		calls.Add(1)
+		return &ManifestInfo{Name: name}, nil
+	})
+
+	_, err := c.get("file")
+	require.NoError(t, err)
+
+	// Bring the entry close to expiry and read it until it is hot
+	c.mu.Lock()
+	c.entries["file"].Value.(*manifestCacheEntry).expires = time.Now().Add(100 * time.Millisecond)
+	c.mu.Unlock()
+	for i := 0; i < manifestHotHits; i++ {
+		_, err := c.get("file")
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return time.Until(c.entries["file"].Value.(*manifestCacheEntry).expires) > 500*time.Millisecond
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestManifestCacheConfigValidate(t *testing.T) {
+	assert.NoError(t, DefaultManifestCacheConfig().Validate())
+
+	config := DefaultManifestCacheConfig()
+	config.Size = 0
+	assert.ErrorIs(t, config.Validate(), ErrInvalidManifestCacheConfig)
+
+	config = DefaultManifestCacheConfig()
+	config.NegativeTTL = config.TTL + time.Second
+	assert.ErrorIs(t, config.Validate(), ErrInvalidManifestCacheConfig)
+}
@@ -0,0 +1,310 @@
+package network
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+)
+
+// A manifest embedding every chunk hash of a very large file would exceed
+// the DHT's record size limits. The chunk list of a file with more than
+// ManifestPageSize chunks is instead split into pages of ManifestPageSize
+// chunks, each stored in the DHT as its own record named by the SHA-256 of
+// its content. The manifest lists the page IDs, so its signature covers
+// every page, and records ChunkCount. Pages are fetched as a reader reaches
+// them; nodes storing a manifest hold its pages too.
+const (
+    ManifestPageSize = 1024 // Chunks listed per page
+    pageNamespace    = "filezap-page"
+)
+
+// ManifestPage is one page of a manifest's chunk list
+type ManifestPage struct {
+    Index       int      // Position of the page in the manifest's ChunkPages
+    ChunkHashes []string
+    ChunkCIDs   []string `json:",omitempty"` // IPFS CIDs of the chunks, in ChunkHashes order, if published
+}
+
+// ID returns the hex SHA-256 of the page's encoding, under which the page
+// is stored
+func (p *ManifestPage) ID() (string, error) {
+    data, err := json.Marshal(p)
+    if err != nil {
+        return "", fmt.Errorf("failed to encode manifest page: %w", err)
+    }
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:]), nil
+}
+
+// NumChunks returns the number of chunks in the manifest's file
+func (m *ManifestInfo) NumChunks() int {
+    if len(m.ChunkPages) > 0 {
+        return m.ChunkCount
+    }
+    return len(m.ChunkHashes)
+}
+
+// PageManifest moves the chunk list of a manifest with more than
+// ManifestPageSize chunks into pages, setting ChunkPages and ChunkCount,
+// and returns the pages. Other manifests are left as they are.
+func PageManifest(manifest *ManifestInfo) ([]*ManifestPage, error) {
+    if len(manifest.ChunkHashes) <= ManifestPageSize {
+        return nil, nil
+    }
+    if err := validateChunkCIDs(manifest); err != nil {
+        return nil, err
+    }
+
+    var pages []*ManifestPage
+    var ids []string
+    for start := 0; start < len(manifest.ChunkHashes); start += ManifestPageSize {
+        end := start + ManifestPageSize
+        if end > len(manifest.ChunkHashes) {
+            end = len(manifest.ChunkHashes)
+        }
+        page := &ManifestPage{
+            Index:       len(pages),
+            ChunkHashes: append([]string(nil), manifest.ChunkHashes[start:end]...),
+        }
+        if len(manifest.ChunkCIDs) > 0 {
+            page.ChunkCIDs = append([]string(nil), manifest.ChunkCIDs[start:end]...)
+        }
+        id, err := page.ID()
+        if err != nil {
+            return nil, err
+        }
+        pages = append(pages, page)
+        ids = append(ids, id)
+    }
+
+    manifest.ChunkCount = len(manifest.ChunkHashes)
+    manifest.ChunkPages = ids
+    manifest.ChunkHashes = nil
+    manifest.ChunkCIDs = nil
+    return pages, nil
+}
+
+// validateChunkPages checks that a paged manifest lists no chunks itself
+// and has as many pages as its chunk count needs
+func validateChunkPages(manifest *ManifestInfo) error {
+    if len(manifest.ChunkPages) == 0 {
+        if manifest.ChunkCount != 0 {
+            return fmt.Errorf("manifest has a chunk count but no chunk pages")
+        }
+        return nil
+    }
+    if len(manifest.ChunkHashes) > 0 || len(manifest.ChunkCIDs) > 0 {
+        return fmt.Errorf("paged manifest also lists chunks")
+    }
+    want := (manifest.ChunkCount + ManifestPageSize - 1) / ManifestPageSize
+    if manifest.ChunkCount <= ManifestPageSize || len(manifest.ChunkPages) != want {
+        return fmt.Errorf("manifest has %d chunk pages for %d chunks", len(manifest.ChunkPages), manifest.ChunkCount)
+    }
+    return nil
+}
+
+// checkPage verifies that page is page i of manifest's chunk list
+func checkPage(manifest *ManifestInfo, i int, page *ManifestPage) error {
+    id, err := page.ID()
+    if err != nil {
+        return err
+    }
+    if id != manifest.ChunkPages[i] {
+        return fmt.Errorf("page %s does not match page %d of manifest %s", id, i, manifest.Name)
+    }
+    want := ManifestPageSize
+    if i == len(manifest.ChunkPages)-1 {
+        want = manifest.ChunkCount - i*ManifestPageSize
+    }
+    if page.Index != i || len(page.ChunkHashes) != want {
+        return fmt.Errorf("page %d of manifest %s lists %d chunks, want %d", i, manifest.Name, len(page.ChunkHashes), want)
+    }
+    if len(page.ChunkCIDs) > 0 && len(page.ChunkCIDs) != len(page.ChunkHashes) {
+        return fmt.Errorf("page %d of manifest %s has %d chunk CIDs for %d chunks", i, manifest.Name, len(page.ChunkCIDs), len(page.ChunkHashes))
+    }
+    return nil
+}
+
+// getPageDHTKey returns the DHT key for a manifest page
+func getPageDHTKey(id string) string {
+    return "/" + pageNamespace + "/" + id
+}
+
+// pageValidator accepts manifest pages stored under their own ID
+type pageValidator struct{}
+
+func (pageValidator) Validate(key string, value []byte) error {
+    var page ManifestPage
+    if err := json.Unmarshal(value, &page); err != nil {
+        return fmt.Errorf("invalid manifest page: %w", err)
+    }
+    id, err := page.ID()
+    if err != nil {
+        return err
+    }
+    if key != getPageDHTKey(id) {
+        return fmt.Errorf("manifest page %s does not match key %s", id, key)
+    }
+    return nil
+}
+
+// Select picks the first page; valid records under a key are identical
+func (pageValidator) Select(key string, values [][]byte) (int, error) {
+    if len(values) == 0 {
+        return 0, fmt.Errorf("no values to select from")
+    }
+    return 0, nil
+}
+
+// ChunkPage returns page i of a paged manifest's chunk list from the pages
+// this node holds, or else the DHT. Pages of manifests this node stores
+// are held once fetched.
+func (m *ManifestManager) ChunkPage(ctx context.Context, manifest *ManifestInfo, i int) (*ManifestPage, error) {
+    if i < 0 || i >= len(manifest.ChunkPages) {
+        return nil, fmt.Errorf("manifest %s has no chunk page %d", manifest.Name, i)
+    }
+    id := manifest.ChunkPages[i]
+    m.mu.RLock()
+    page, ok := m.pages[id]
+    m.mu.RUnlock()
+    if ok {
+        return page, nil
+    }
+
+    if m.dht == nil {
+        return nil, fmt.Errorf("page %d of manifest %s not found", i, manifest.Name)
+    }
+    data, err := m.dht.GetValue(ctx, getPageDHTKey(id))
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch page %d of manifest %s: %w", i, manifest.Name, err)
+    }
+    page = &ManifestPage{}
+    if err := json.Unmarshal(data, page); err != nil {
+        return nil, fmt.Errorf("failed to unmarshal manifest page: %w", err)
+    }
+    if err := checkPage(manifest, i, page); err != nil {
+        return nil, err
+    }
+
+    m.mu.Lock()
+    if stored := m.store[manifest.Name]; stored != nil && containsString(stored.ChunkPages, id) {
+        m.holdPages(page)
+        if m.chunks != nil {
+            m.chunks.setManifestAccess(stored, m.heldChunkHashes(stored))
+        }
+    }
+    m.mu.Unlock()
+    return page, nil
+}
+
+// WalkChunks calls fn with the index, hash and IPFS CID, empty if none, of
+// each chunk of manifest in file order, fetching pages as they are
+// reached. It stops at the first error.
+func (m *ManifestManager) WalkChunks(ctx context.Context, manifest *ManifestInfo, fn func(i int, hash, cid string) error) error {
+    if len(manifest.ChunkPages) == 0 {
+        for i, hash := range manifest.ChunkHashes {
+            var c string
+            if i < len(manifest.ChunkCIDs) {
+                c = manifest.ChunkCIDs[i]
+            }
+            if err := fn(i, hash, c); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+
+    for p := range manifest.ChunkPages {
+        page, err := m.ChunkPage(ctx, manifest, p)
+        if err != nil {
+            return err
+        }
+        for j, hash := range page.ChunkHashes {
+            var c string
+            if j < len(page.ChunkCIDs) {
+                c = page.ChunkCIDs[j]
+            }
+            if err := fn(p*ManifestPageSize+j, hash, c); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// ChunkHashes returns the hashes of all of a manifest's chunks in file
+// order, fetching any pages not held, and checks them against the
+// manifest's chunk root if it has one
+func (m *ManifestManager) ChunkHashes(ctx context.Context, manifest *ManifestInfo) ([]string, error) {
+    hashes := make([]string, 0, manifest.NumChunks())
+    err := m.WalkChunks(ctx, manifest, func(_ int, hash, _ string) error {
+        hashes = append(hashes, hash)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    if manifest.ChunkRoot != "" && ChunkMerkleRoot(hashes) != manifest.ChunkRoot {
+        return nil, fmt.Errorf("%w: chunk list of %s does not match its root", ErrChunkProof, manifest.Name)
+    }
+    return hashes, nil
+}
+
+// holdPages keeps pages this node serves. m.mu must be held.
+func (m *ManifestManager) holdPages(pages ...*ManifestPage) {
+    if m.pages == nil {
+        m.pages = make(map[string]*ManifestPage)
+    }
+    for _, page := range pages {
+        if id, err := page.ID(); err == nil {
+            m.pages[id] = page
+        }
+    }
+}
+
+// heldChunkHashes returns the hashes of a manifest's chunks listed in the
+// manifest itself or in pages this node holds. m.mu must be held.
+func (m *ManifestManager) heldChunkHashes(manifest *ManifestInfo) []string {
+    if len(manifest.ChunkPages) == 0 {
+        return manifest.ChunkHashes
+    }
+    var hashes []string
+    for _, id := range manifest.ChunkPages {
+        if page, ok := m.pages[id]; ok {
+            hashes = append(hashes, page.ChunkHashes...)
+        }
+    }
+    return hashes
+}
+
+// fetchPages has this node hold every page of a manifest it stores
+func (m *ManifestManager) fetchPages(ctx context.Context, manifest *ManifestInfo) error {
+    for i := range manifest.ChunkPages {
+        if _, err := m.ChunkPage(ctx, manifest, i); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// publishPages stores the held pages of a manifest in the DHT
+func (m *ManifestManager) publishPages(ctx context.Context, manifest *ManifestInfo) error {
+    for _, id := range manifest.ChunkPages {
+        m.mu.RLock()
+        page, ok := m.pages[id]
+        m.mu.RUnlock()
+        if !ok {
+            continue
+        }
+        data, err := json.Marshal(page)
+        if err != nil {
+            return fmt.Errorf("failed to marshal manifest page: %w", err)
+        }
+        if err := m.dht.PutValue(ctx, getPageDHTKey(id), data); err != nil {
+            return fmt.Errorf("failed to store page %s in DHT: %w", id, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,86 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pagedTestManifest(chunks int) *ManifestInfo {
+	hashes := make([]string, chunks)
+	for i := range hashes {
+		hashes[i] = fmt.Sprintf("%064x", i)
+	}
+	return &ManifestInfo{Name: "large.zap", Owner: "owner", ChunkHashes: hashes, ReplicationGoal: 1}
+}
+
+func TestPageManifest(t *testing.T) {
+	small := pagedTestManifest(ManifestPageSize)
+	pages, err := PageManifest(small)
+	require.NoError(t, err)
+	assert.Nil(t, pages)
+	assert.Len(t, small.ChunkHashes, ManifestPageSize)
+
+	manifest := pagedTestManifest(2*ManifestPageSize + 5)
+	hashes := append([]string(nil), manifest.ChunkHashes...)
+	pages, err = PageManifest(manifest)
+	require.NoError(t, err)
+	require.Len(t, pages, 3)
+	assert.Empty(t, manifest.ChunkHashes)
+	assert.Equal(t, len(hashes), manifest.ChunkCount)
+	assert.Equal(t, len(hashes), manifest.NumChunks())
+	require.NoError(t, validateChunkPages(manifest))
+	for i, page := range pages {
+		assert.NoError(t, checkPage(manifest, i, page))
+	}
+	assert.Error(t, checkPage(manifest, 0, pages[1]))
+
+	// Pages held by the manager are walked in file order
+	m := &ManifestManager{}
+	m.holdPages(pages...)
+	got, err := m.ChunkHashes(context.Background(), manifest)
+	require.NoError(t, err)
+	assert.Equal(t, hashes, got)
+
+	// Walking stops at the first missing page
+	delete(m.pages, manifest.ChunkPages[2])
+	_, err = m.ChunkHashes(context.Background(), manifest)
+	assert.Error(t, err)
+}
+
+func TestValidateChunkPages(t *testing.T) {
+	manifest := pagedTestManifest(ManifestPageSize + 1)
+	_, err := PageManifest(manifest)
+	require.NoError(t, err)
+
+	tooFew := *manifest
+	tooFew.ChunkCount = 3 * ManifestPageSize
+	assert.Error(t, validateChunkPages(&tooFew))
+
+	mixed := *manifest
+	mixed.ChunkHashes = []string{"a"}
+	assert.Error(t, validateChunkPages(&mixed))
+
+	countOnly := &ManifestInfo{ChunkHashes: []string{"a"}, ChunkCount: 1}
+	assert.Error(t, validateChunkPages(countOnly))
+}
+
+func TestPageValidator(t *testing.T) {
+	page := &ManifestPage{Index: 1, ChunkHashes: []string{"a", "b"}}
+	id, err := page.ID()
+	require.NoError(t, err)
+	data, err := json.Marshal(page)
+	require.NoError(t, err)
+
+	v := pageValidator{}
+	assert.NoError(t, v.Validate(getPageDHTKey(id), data))
+
+	page.ChunkHashes[1] = "c"
+	tampered, err := json.Marshal(page)
+	require.NoError(t, err)
+	assert.Error(t, v.Validate(getPageDHTKey(id), tampered))
+}
@@ -0,0 +1,159 @@
+package network
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+)
+
+// Manifests commit to their chunk list with ChunkRoot, the root of a
+// merkle tree over the chunk hashes in file order. A downloader holding
+// only the manifest can check any single chunk with a ChunkProof, the
+// sibling nodes on the path from the chunk's leaf to the root, without the
+// rest of the chunk list. Leaves and inner nodes are hashed with distinct
+// prefixes, and a node left without a sibling is carried up a level as is.
+
+// ErrChunkProof is returned for chunks that do not match a manifest's root
+var ErrChunkProof = errors.New("chunk proof does not match manifest")
+
+const (
+    merkleLeafPrefix = 0x00
+    merkleNodePrefix = 0x01
+)
+
+// ChunkProof shows that a chunk hash is at Index in a manifest's chunk list
+type ChunkProof struct {
+    Index    int
+    Hash     string
+    Siblings []string // Hex sibling nodes, from the leaf up
+}
+
+// merkleLeaf hashes a chunk hash into a leaf
+func merkleLeaf(hash string) []byte {
+    sum := sha256.Sum256(append([]byte{merkleLeafPrefix}, hash...))
+    return sum[:]
+}
+
+// merkleNode hashes two children into their parent
+func merkleNode(left, right []byte) []byte {
+    buf := make([]byte, 0, 1+len(left)+len(right))
+    buf = append(buf, merkleNodePrefix)
+    buf = append(buf, left...)
+    buf = append(buf, right...)
+    sum := sha256.Sum256(buf)
+    return sum[:]
+}
+
+// merkleLevels returns every level of the tree over hashes, leaves first
+func merkleLevels(hashes []string) [][][]byte {
+    level := make([][]byte, len(hashes))
+    for i, hash := range hashes {
+        level[i] = merkleLeaf(hash)
+    }
+    levels := [][][]byte{level}
+    for len(level) > 1 {
+        next := make([][]byte, 0, (len(level)+1)/2)
+        for i := 0; i < len(level); i += 2 {
+            if i+1 == len(level) {
+                next = append(next, level[i])
+            } else {
+                next = append(next, merkleNode(level[i], level[i+1]))
+            }
+        }
+        levels = append(levels, next)
+        level = next
+    }
+    return levels
+}
+
+// ChunkMerkleRoot returns the hex merkle root over chunk hashes in file
+// order, empty for no chunks
+func ChunkMerkleRoot(hashes []string) string {
+    if len(hashes) == 0 {
+        return ""
+    }
+    levels := merkleLevels(hashes)
+    return hex.EncodeToString(levels[len(levels)-1][0])
+}
+
+// BuildChunkProof returns the proof for chunk i of hashes
+func BuildChunkProof(hashes []string, i int) (*ChunkProof, error) {
+    if i < 0 || i >= len(hashes) {
+        return nil, fmt.Errorf("no chunk %d in a list of %d", i, len(hashes))
+    }
+    proof := &ChunkProof{Index: i, Hash: hashes[i]}
+    levels := merkleLevels(hashes)
+    for _, level := range levels[:len(levels)-1] {
+        if sibling := i ^ 1; sibling < len(level) {
+            proof.Siblings = append(proof.Siblings, hex.EncodeToString(level[sibling]))
+        }
+        i /= 2
+    }
+    return proof, nil
+}
+
+// VerifyChunkProof checks that proof places its hash in a chunk list of
+// count chunks with the given root
+func VerifyChunkProof(root string, count int, proof *ChunkProof) error {
+    if proof == nil || proof.Index < 0 || proof.Index >= count {
+        return fmt.Errorf("%w: chunk index out of range", ErrChunkProof)
+    }
+    node := merkleLeaf(proof.Hash)
+    i, width, used := proof.Index, count, 0
+    for width > 1 {
+        if sibling := i ^ 1; sibling < width {
+            if used == len(proof.Siblings) {
+                return fmt.Errorf("%w: proof too short", ErrChunkProof)
+            }
+            other, err := hex.DecodeString(proof.Siblings[used])
+            if err != nil || len(other) != sha256.Size {
+                return fmt.Errorf("%w: invalid sibling", ErrChunkProof)
+            }
+            used++
+            if i%2 == 0 {
+                node = merkleNode(node, other)
+            } else {
+                node = merkleNode(other, node)
+            }
+        }
+        i /= 2
+        width = (width + 1) / 2
+    }
+    if used != len(proof.Siblings) || hex.EncodeToString(node) != root {
+        return ErrChunkProof
+    }
+    return nil
+}
+
+// VerifyChunk checks a chunk hash against a manifest's root, for
+// manifests that have one
+func VerifyChunk(manifest *ManifestInfo, proof *ChunkProof) error {
+    if manifest.ChunkRoot == "" {
+        return fmt.Errorf("%w: manifest %s has no chunk root", ErrChunkProof, manifest.Name)
+    }
+    return VerifyChunkProof(manifest.ChunkRoot, manifest.NumChunks(), proof)
+}
+
+// validateChunkRoot checks the root of a manifest listing its chunks
+// itself; roots of paged manifests are checked as the pages are read
+func validateChunkRoot(manifest *ManifestInfo) error {
+    if manifest.ChunkRoot == "" || len(manifest.ChunkPages) > 0 {
+        return nil
+    }
+    if ChunkMerkleRoot(manifest.ChunkHashes) != manifest.ChunkRoot {
+        return fmt.Errorf("%w: chunk root of %s does not match its chunks", ErrChunkProof, manifest.Name)
+    }
+    return nil
+}
+
+// ChunkProof returns the proof for chunk i of a manifest, built from its
+// chunk list
+func (m *ManifestManager) ChunkProof(ctx context.Context, manifest *ManifestInfo, i int) (*ChunkProof, error) {
+    hashes, err := m.ChunkHashes(ctx, manifest)
+    if err != nil {
+        return nil, err
+    }
+    return BuildChunkProof(hashes, i)
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkProofs(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 8, 13} {
+		hashes := make([]string, n)
+		for i := range hashes {
+			hashes[i] = fmt.Sprintf("chunk-%d", i)
+		}
+		root := ChunkMerkleRoot(hashes)
+		for i := range hashes {
+			proof, err := BuildChunkProof(hashes, i)
+			require.NoError(t, err)
+			assert.NoError(t, VerifyChunkProof(root, n, proof), "chunk %d of %d", i, n)
+
+			forged := *proof
+			forged.Hash = "forged"
+			assert.ErrorIs(t, VerifyChunkProof(root, n, &forged), ErrChunkProof)
+			if n > 1 {
+				moved := *proof
+				moved.Index = (i + 1) % n
+				assert.Error(t, VerifyChunkProof(root, n, &moved))
+			}
+		}
+	}
+
+	_, err := BuildChunkProof([]string{"a"}, 1)
+	assert.Error(t, err)
+	assert.Empty(t, ChunkMerkleRoot(nil))
+}
+
+func TestChunkRootOfPagedManifest(t *testing.T) {
+	manifest := pagedTestManifest(ManifestPageSize + 3)
+	hashes := append([]string(nil), manifest.ChunkHashes...)
+	manifest.ChunkRoot = ChunkMerkleRoot(hashes)
+	require.NoError(t, validateChunkRoot(manifest))
+	pages, err := PageManifest(manifest)
+	require.NoError(t, err)
+
+	m := &ManifestManager{}
+	m.holdPages(pages...)
+	proof, err := m.ChunkProof(context.Background(), manifest, ManifestPageSize+1)
+	require.NoError(t, err)
+	assert.Equal(t, hashes[ManifestPageSize+1], proof.Hash)
+	assert.NoError(t, VerifyChunk(manifest, proof))
+
+	// A chunk list not matching the root is rejected
+	manifest.ChunkRoot = ChunkMerkleRoot(hashes[1:])
+	_, err = m.ChunkHashes(context.Background(), manifest)
+	assert.ErrorIs(t, err, ErrChunkProof)
+
+	unpaged := &ManifestInfo{Name: "small.zap", ChunkHashes: []string{"a", "b"}, ChunkRoot: ChunkMerkleRoot([]string{"b", "a"})}
+	assert.ErrorIs(t, validateChunkRoot(unpaged), ErrChunkProof)
+}
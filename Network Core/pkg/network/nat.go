@@ -0,0 +1,220 @@
+package network
+
+import (
+    "context"
+    "fmt"
+    "sync"
+
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/p2p/host/autorelay"
+    libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+    "github.com/libp2p/go-libp2p/p2p/transport/tcp"
+    "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+    libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+    ma "github.com/multiformats/go-multiaddr"
+)
+
+// relayHopProtocol is the circuit relay v2 protocol served by relay nodes
+const relayHopProtocol = "/libp2p/circuit/relay/0.2.0/hop"
+
+// transportOptions builds the transport, listen and NAT traversal options
+// for a host. Only the enabled transports are registered, so a disabled
+// transport is neither listened on nor dialed. The host listens on listen
+// if given, otherwise on port on every enabled IP version and transport.
+// Non-empty announce replaces the addresses the host advertises. The relay
+// peer source reaches the host through h once the caller sets it.
+func transportOptions(cfg *NetworkConfig, port int, listen, announce []string, h *hostRef) ([]libp2p.Option, error) {
+    t := cfg.Transport
+    if t.WebSocketPort < 0 {
+        return nil, fmt.Errorf("invalid WebSocket port %d", t.WebSocketPort)
+    }
+
+    addrs := listen
+    if len(addrs) == 0 {
+        addrs = defaultListenAddrs(cfg, port)
+    }
+    for _, addr := range addrs {
+        if _, err := ma.NewMultiaddr(addr); err != nil {
+            return nil, fmt.Errorf("invalid listen address %q: %w", addr, err)
+        }
+    }
+    opts := append(enabledTransports(cfg), libp2p.ListenAddrStrings(addrs...))
+
+    if len(announce) > 0 {
+        announced := make([]ma.Multiaddr, 0, len(announce))
+        for _, addr := range announce {
+            maddr, err := ma.NewMultiaddr(addr)
+            if err != nil {
+                return nil, fmt.Errorf("invalid announce address %q: %w", addr, err)
+            }
+            announced = append(announced, maddr)
+        }
+        opts = append(opts, libp2p.AddrsFactory(func([]ma.Multiaddr) []ma.Multiaddr {
+            return announced
+        }))
+    }
+
+    // AutoRelay and hole punching both depend on the relay v2 client
+    if !t.EnableRelay && !t.EnableAutoRelay && !t.EnableHolePunch {
+        opts = append(opts, libp2p.DisableRelay())
+    } else {
+        opts = append(opts, libp2p.EnableRelay())
+    }
+
+    if t.EnableRelayService {
+        opts = append(opts, libp2p.EnableRelayService())
+    }
+
+    if t.EnableAutoNAT {
+        opts = append(opts, libp2p.EnableNATService(), libp2p.NATPortMap())
+    }
+
+    if t.EnableHolePunch {
+        opts = append(opts, libp2p.EnableHolePunching())
+    }
+
+    if t.EnableAutoRelay {
+        if len(t.StaticRelays) > 0 {
+            relays, err := parseRelayAddrs(t.StaticRelays)
+            if err != nil {
+                return nil, err
+            }
+            opts = append(opts, libp2p.EnableAutoRelayWithStaticRelays(relays))
+        } else {
+            opts = append(opts, libp2p.EnableAutoRelayWithPeerSource(relayPeerSource(h)))
+        }
+    }
+
+    return opts, nil
+}
+
+// enabledTransports returns the libp2p transports enabled in cfg. TCP is
+// used when no transport is enabled.
+func enabledTransports(cfg *NetworkConfig) []libp2p.Option {
+    t := cfg.Transport
+
+    var opts []libp2p.Option
+    if useTCP(cfg) {
+        opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+    }
+    if t.EnableQUIC {
+        opts = append(opts, libp2p.Transport(libp2pquic.NewTransport))
+    }
+    if t.EnableWebSocket {
+        opts = append(opts, libp2p.Transport(websocket.New))
+    }
+    if t.EnableWebTransport {
+        opts = append(opts, libp2p.Transport(libp2pwebtransport.New))
+    }
+    return opts
+}
+
+// useTCP reports whether TCP is enabled, either explicitly or as the
+// fallback when no transport is
+func useTCP(cfg *NetworkConfig) bool {
+    t := cfg.Transport
+    return t.EnableTCP || !(t.EnableQUIC || t.EnableWebSocket || t.EnableWebTransport)
+}
+
+// defaultListenAddrs returns wildcard listen addresses on port for each
+// enabled IP version and transport. IPv4 and TCP are used when neither of
+// their alternatives is enabled. WebTransport shares the QUIC port; the
+// WebSocket port is offset from WebSocketPort like port is from ListenPort,
+// so the transport and metadata hosts do not collide.
+func defaultListenAddrs(cfg *NetworkConfig, port int) []string {
+    t := cfg.Transport
+
+    var ips []string
+    if t.EnableIPv4 || !t.EnableIPv6 {
+        ips = append(ips, "/ip4/0.0.0.0")
+    }
+    if t.EnableIPv6 {
+        ips = append(ips, "/ip6/::")
+    }
+
+    wsPort := 0
+    if t.WebSocketPort > 0 {
+        wsPort = t.WebSocketPort + port - t.ListenPort
+    }
+
+    var addrs []string
+    for _, ip := range ips {
+        if useTCP(cfg) {
+            addrs = append(addrs, fmt.Sprintf("%s/tcp/%d", ip, port))
+        }
+        if t.EnableQUIC {
+            addrs = append(addrs, fmt.Sprintf("%s/udp/%d/quic-v1", ip, port))
+        }
+        if t.EnableWebTransport {
+            addrs = append(addrs, fmt.Sprintf("%s/udp/%d/quic-v1/webtransport", ip, port))
+        }
+        if t.EnableWebSocket {
+            addrs = append(addrs, fmt.Sprintf("%s/tcp/%d/ws", ip, wsPort))
+        }
+    }
+    return addrs
+}
+
+// parseRelayAddrs converts relay multiaddr strings into peer address info
+func parseRelayAddrs(addrs []string) ([]peer.AddrInfo, error) {
+    relays := make([]peer.AddrInfo, 0, len(addrs))
+    for _, addr := range addrs {
+        info, err := peer.AddrInfoFromString(addr)
+        if err != nil {
+            return nil, fmt.Errorf("invalid static relay %q: %w", addr, err)
+        }
+        relays = append(relays, *info)
+    }
+    return relays, nil
+}
+
+// hostRef holds a host for options built before it is created. Services
+// started by libp2p may read it while the caller sets it.
+type hostRef struct {
+    mu sync.RWMutex
+    h  host.Host
+}
+
+// set stores the created host
+func (r *hostRef) set(h host.Host) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.h = h
+}
+
+// get returns the host, or nil if it has not been set yet
+func (r *hostRef) get() host.Host {
+    if r == nil {
+        return nil
+    }
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return r.h
+}
+
+// relayPeerSource offers connected peers that advertise the relay hop
+// protocol as AutoRelay candidates. It offers none until the host is set.
+func relayPeerSource(h *hostRef) autorelay.PeerSource {
+    return func(ctx context.Context, num int) <-chan peer.AddrInfo {
+        out := make(chan peer.AddrInfo, num)
+        defer close(out)
+
+        hst := h.get()
+        if hst == nil {
+            return out
+        }
+        for _, p := range hst.Network().Peers() {
+            if len(out) >= num {
+                break
+            }
+            protos, err := hst.Peerstore().SupportsProtocols(p, relayHopProtocol)
+            if err != nil || len(protos) == 0 {
+                continue
+            }
+            out <- peer.AddrInfo{ID: p, Addrs: hst.Peerstore().Addrs(p)}
+        }
+        return out
+    }
+}
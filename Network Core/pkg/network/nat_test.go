@@ -0,0 +1,166 @@
+package network
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportOptionsNAT(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+	cfg.Transport.EnableQUIC = true
+	cfg.Transport.EnableAutoRelay = true
+	cfg.Transport.EnableHolePunch = true
+	cfg.Transport.EnableRelayService = true
+	cfg.Transport.EnableAutoNAT = true
+
+	var ref hostRef
+	opts, err := transportOptions(cfg, 0, nil, nil, &ref)
+	require.NoError(t, err)
+
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	defer h.Close()
+	ref.set(h)
+
+	var hasQUIC, hasTCP bool
+	for _, addr := range h.Addrs() {
+		if _, err := addr.ValueForProtocol(ma.P_QUIC_V1); err == nil {
+			hasQUIC = true
+		}
+		if _, err := addr.ValueForProtocol(ma.P_TCP); err == nil {
+			hasTCP = true
+		}
+	}
+	assert.True(t, hasQUIC, "expected a QUIC listen address")
+	assert.True(t, hasTCP, "expected a TCP listen address")
+}
+
+func TestRelayPeerSourceBeforeHost(t *testing.T) {
+	var ref hostRef
+	source := relayPeerSource(&ref)
+
+	// AutoRelay may ask for candidates while the host is being set
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range source(context.Background(), 1) {
+		}
+	}()
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer h.Close()
+	ref.set(h)
+	<-done
+
+	// A host without relay peers offers no candidates
+	var offered int
+	for range source(context.Background(), 1) {
+		offered++
+	}
+	assert.Zero(t, offered)
+}
+
+func TestTransportOptionsStaticRelays(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+	cfg.Transport.EnableAutoRelay = true
+	cfg.Transport.StaticRelays = []string{"not-a-multiaddr"}
+
+	_, err := transportOptions(cfg, 0, nil, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestDefaultListenAddrs(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+	assert.Equal(t, []string{"/ip4/0.0.0.0/tcp/6001"}, defaultListenAddrs(cfg, 6001))
+
+	cfg.Transport.EnableIPv6 = true
+	cfg.Transport.EnableQUIC = true
+	assert.Equal(t, []string{
+		"/ip4/0.0.0.0/tcp/6001",
+		"/ip4/0.0.0.0/udp/6001/quic-v1",
+		"/ip6/::/tcp/6001",
+		"/ip6/::/udp/6001/quic-v1",
+	}, defaultListenAddrs(cfg, 6001))
+
+	// IPv6 only
+	cfg.Transport.EnableIPv4 = false
+	cfg.Transport.EnableTCP = false
+	assert.Equal(t, []string{"/ip6/::/udp/6001/quic-v1"}, defaultListenAddrs(cfg, 6001))
+}
+
+func TestDefaultListenAddrsBrowserTransports(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+	cfg.Transport.EnableTCP = false
+	cfg.Transport.EnableQUIC = true
+	cfg.Transport.EnableWebTransport = true
+	cfg.Transport.EnableWebSocket = true
+	cfg.Transport.WebSocketPort = 8080
+
+	assert.Equal(t, []string{
+		"/ip4/0.0.0.0/udp/6001/quic-v1",
+		"/ip4/0.0.0.0/udp/6001/quic-v1/webtransport",
+		"/ip4/0.0.0.0/tcp/8080/ws",
+	}, defaultListenAddrs(cfg, 6001))
+
+	// The metadata host's WebSocket port is offset like its main port
+	assert.Contains(t, defaultListenAddrs(cfg, 6002), "/ip4/0.0.0.0/tcp/8081/ws")
+}
+
+func TestTransportOptionsQUICOnly(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+	cfg.Transport.EnableTCP = false
+	cfg.Transport.EnableQUIC = true
+
+	opts, err := transportOptions(cfg, 0, nil, nil, nil)
+	require.NoError(t, err)
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	defer h.Close()
+
+	require.NotEmpty(t, h.Network().ListenAddresses())
+	for _, addr := range h.Network().ListenAddresses() {
+		_, err := addr.ValueForProtocol(ma.P_QUIC_V1)
+		assert.NoError(t, err, "unexpected listen address %s", addr)
+	}
+
+	// With TCP disabled the host cannot listen on TCP at all
+	opts, err = transportOptions(cfg, 0, []string{"/ip4/127.0.0.1/tcp/0"}, nil, nil)
+	require.NoError(t, err)
+	_, err = libp2p.New(opts...)
+	assert.Error(t, err)
+
+	cfg.Transport.WebSocketPort = -1
+	_, err = transportOptions(cfg, 0, nil, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestTransportOptionsListenAndAnnounce(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+	listen := []string{"/ip4/127.0.0.1/tcp/0"}
+	announce := []string{"/ip4/203.0.113.7/tcp/4001"}
+
+	opts, err := transportOptions(cfg, 0, listen, announce, nil)
+	require.NoError(t, err)
+	h, err := libp2p.New(opts...)
+	require.NoError(t, err)
+	defer h.Close()
+
+	// Listening happens on the explicit address, but only the announced
+	// address is advertised
+	require.Len(t, h.Network().ListenAddresses(), 1)
+	port, err := h.Network().ListenAddresses()[0].ValueForProtocol(ma.P_TCP)
+	require.NoError(t, err)
+	assert.NotEqual(t, "0", port)
+	require.Len(t, h.Addrs(), 1)
+	assert.Equal(t, announce[0], h.Addrs()[0].String())
+
+	_, err = transportOptions(cfg, 0, []string{"bogus"}, nil, nil)
+	assert.Error(t, err)
+	_, err = transportOptions(cfg, 0, nil, []string{"bogus"}, nil)
+	assert.Error(t, err)
+}
@@ -76,6 +76,14 @@ func (n *networkImpl) GetPeers() []peer.ID {
     return n.engine.gossipMgr.GetPeers()
 }
 
+func (n *networkImpl) PeerStats() []PeerStats {
+    return n.engine.PeerStats()
+}
+
+func (n *networkImpl) DisconnectPeer(id peer.ID) error {
+    return n.engine.DisconnectPeer(id)
+}
+
 func (n *networkImpl) GetVPNStatus() *VPNStatus {
     return n.engine.GetVPNStatus()
 }
@@ -86,6 +94,10 @@ func (n *networkImpl) AddZapFile(manifest *ManifestInfo, chunks map[string][]byt
     return n.engine.AddZapFile(manifest, chunks)
 }
 
+func (n *networkImpl) UploadZapFile(ctx context.Context, manifest *ManifestInfo, chunks map[string][]byte) (*UploadResult, error) {
+    return n.engine.UploadZapFile(ctx, manifest, chunks)
+}
+
 func (n *networkImpl) GetZapFile(name string) (*ManifestInfo, map[string][]byte, error) {
     return n.engine.GetZapFile(name)
 }
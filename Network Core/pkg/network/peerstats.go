@@ -0,0 +1,173 @@
+package network
+
+import (
+    "sort"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    ma "github.com/multiformats/go-multiaddr"
+)
+
+// Peer statistics combine what the gossip manager measured about a peer,
+// what the peer gossiped about itself and the connections the host has open
+// to it. Peers are listed while they are connected or known through gossip.
+
+// Directions of a connection, from this node's point of view
+const (
+    DirectionInbound  = "inbound"
+    DirectionOutbound = "outbound"
+)
+
+// PeerStats describes a peer for display
+type PeerStats struct {
+    ID             peer.ID
+    Connected      bool
+    Direction      string        // Direction of the first open connection, empty if none
+    Transports     []string      // Transports of the open connections, e.g. "tcp" or "quic-v1"
+    Addresses      []string      // Addresses of the open connections, or gossiped ones
+    Latency        time.Duration // Round trip measured by the host, zero if unknown
+    ResponseTime   float64       // Average response time in ms
+    Uptime         float64       // Percentage of successful requests
+    Reputation     int
+    FailedRequests uint64 // Requests to the peer that failed, e.g. chunk downloads
+    ChunksServed   uint64 // Chunks this node served to the peer
+    ChunksReceived uint64 // Chunks this node downloaded from the peer
+    BytesServed    uint64
+    BytesReceived  uint64
+    LastSeen       time.Time
+}
+
+// RecordChunkServed counts a chunk served to a peer
+func (gm *GossipManagerImpl) RecordChunkServed(id peer.ID, size int) {
+    gm.mu.Lock()
+    defer gm.mu.Unlock()
+
+    m := gm.metricsLocked(id)
+    m.chunksServed++
+    m.bytesServed += uint64(size)
+}
+
+// RecordChunkReceived counts a chunk downloaded from a peer
+func (gm *GossipManagerImpl) RecordChunkReceived(id peer.ID, size int) {
+    gm.mu.Lock()
+    defer gm.mu.Unlock()
+
+    m := gm.metricsLocked(id)
+    m.chunksReceived++
+    m.bytesReceived += uint64(size)
+}
+
+// metricsLocked returns a peer's metrics, starting them for peers we
+// exchanged chunks with before they gossiped. Callers must hold gm.mu.
+func (gm *GossipManagerImpl) metricsLocked(id peer.ID) *PeerMetrics {
+    m, ok := gm.metrics[id]
+    if !ok {
+        now := time.Now()
+        m = &PeerMetrics{lastSeen: now, connectionStart: now}
+        gm.metrics[id] = m
+    }
+    m.lastSeen = time.Now()
+    return m
+}
+
+// PeerStats returns the statistics of every connected or gossiped peer,
+// ordered by ID
+func (gm *GossipManagerImpl) PeerStats() []PeerStats {
+    gm.mu.RLock()
+    ids := make(map[peer.ID]bool, len(gm.peerStore))
+    for id := range gm.peerStore {
+        ids[id] = true
+    }
+    for id := range gm.metrics {
+        ids[id] = true
+    }
+    if gm.host != nil {
+        for _, id := range gm.host.Network().Peers() {
+            ids[id] = true
+        }
+    }
+
+    stats := make([]PeerStats, 0, len(ids))
+    for id := range ids {
+        s := PeerStats{ID: id}
+        if info, ok := gm.peerStore[id]; ok {
+            s.Addresses = append(s.Addresses, info.Addresses...)
+            s.Uptime = info.Uptime
+            s.ResponseTime = info.ResponseTime
+            s.LastSeen = info.LastSeen
+        }
+        if m, ok := gm.metrics[id]; ok {
+            if m.successfulRequests+m.failedRequests > 0 {
+                s.Uptime = gm.calculateUptime(m)
+                s.ResponseTime = gm.calculateAverageResponseTime(m)
+            }
+            s.FailedRequests = m.failedRequests
+            s.ChunksServed = m.chunksServed
+            s.ChunksReceived = m.chunksReceived
+            s.BytesServed = m.bytesServed
+            s.BytesReceived = m.bytesReceived
+            if m.lastSeen.After(s.LastSeen) {
+                s.LastSeen = m.lastSeen
+            }
+        }
+        if gm.reputation != nil {
+            s.Reputation = gm.reputation(id)
+        }
+        stats = append(stats, s)
+    }
+    gm.mu.RUnlock()
+
+    if gm.host != nil {
+        for i := range stats {
+            gm.addConnectionStats(&stats[i])
+        }
+    }
+    sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+    return stats
+}
+
+// addConnectionStats fills in what the host knows about its connections
+// to a peer
+func (gm *GossipManagerImpl) addConnectionStats(s *PeerStats) {
+    conns := gm.host.Network().ConnsToPeer(s.ID)
+    if len(conns) == 0 {
+        return
+    }
+
+    s.Connected = true
+    s.Latency = gm.host.Peerstore().LatencyEWMA(s.ID)
+    s.Addresses = nil
+    seen := make(map[string]bool)
+    for i, conn := range conns {
+        if i == 0 {
+            switch conn.Stat().Direction {
+            case network.DirInbound:
+                s.Direction = DirectionInbound
+            case network.DirOutbound:
+                s.Direction = DirectionOutbound
+            }
+        }
+        addr := conn.RemoteMultiaddr()
+        s.Addresses = append(s.Addresses, addr.String())
+        if transport := transportName(addr); transport != "" && !seen[transport] {
+            seen[transport] = true
+            s.Transports = append(s.Transports, transport)
+        }
+    }
+}
+
+// transportName names the transport of an address after its last protocol
+// below the peer ID, e.g. "tcp", "quic-v1" or "p2p-circuit" for relays
+func transportName(addr ma.Multiaddr) string {
+    var name string
+    ma.ForEach(addr, func(c ma.Component) bool {
+        switch c.Protocol().Code {
+        case ma.P_IP4, ma.P_IP6, ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR, ma.P_P2P, ma.P_CERTHASH:
+        default:
+            name = c.Protocol().Name
+        }
+        return true
+    })
+    return name
+}
@@ -0,0 +1,78 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStatsGossip creates a gossip manager that only keeps peer statistics
+func newStatsGossip(h host.Host) *GossipManagerImpl {
+	return &GossipManagerImpl{
+		host:      h,
+		peerStore: make(map[peer.ID]*PeerGossipInfo),
+		metrics:   make(map[peer.ID]*PeerMetrics),
+	}
+}
+
+func TestPeerStatsCountChunks(t *testing.T) {
+	host1, host2 := setupTestHosts(t)
+	defer host1.Close()
+	defer host2.Close()
+
+	gm1 := newStatsGossip(host1)
+	gm2 := newStatsGossip(host2)
+	gm2.SetReputationSource(func(id peer.ID) int { return 42 })
+
+	store1 := NewChunkStore(host1)
+	store1.RegisterGossip(gm1)
+	store2 := NewChunkStore(host2)
+	store2.RegisterGossip(gm2)
+
+	data := []byte("test chunk data")
+	store1.Store("testhash", data)
+	_, err := store2.transfers.Download(host1.ID(), "testhash")
+	require.NoError(t, err)
+
+	// The downloader counts the chunk as received
+	stats := gm2.PeerStats()
+	require.Len(t, stats, 1)
+	s := stats[0]
+	assert.Equal(t, host1.ID(), s.ID)
+	assert.True(t, s.Connected)
+	assert.Equal(t, DirectionInbound, s.Direction)
+	assert.Equal(t, []string{"tcp"}, s.Transports)
+	assert.Equal(t, uint64(1), s.ChunksReceived)
+	assert.Equal(t, uint64(len(data)), s.BytesReceived)
+	assert.Equal(t, 42, s.Reputation)
+
+	// The server counts it as served once the stream is done
+	require.Eventually(t, func() bool {
+		stats := gm1.PeerStats()
+		return len(stats) == 1 && stats[0].ChunksServed == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, DirectionOutbound, gm1.PeerStats()[0].Direction)
+
+	// Disconnected peers are still listed with their counts
+	require.NoError(t, host2.Network().ClosePeer(host1.ID()))
+	stats = gm2.PeerStats()
+	require.Len(t, stats, 1)
+	assert.False(t, stats[0].Connected)
+	assert.Equal(t, uint64(1), stats[0].ChunksReceived)
+}
+
+func TestTransportName(t *testing.T) {
+	for addr, want := range map[string]string{
+		"/ip4/127.0.0.1/tcp/4001":                      "tcp",
+		"/ip6/::1/udp/4001/quic-v1":                    "quic-v1",
+		"/dns4/example.com/tcp/443/wss":                "wss",
+		"/ip4/127.0.0.1/udp/4001/quic-v1/webtransport": "webtransport",
+	} {
+		assert.Equal(t, want, transportName(ma.StringCast(addr)), addr)
+	}
+}
@@ -0,0 +1,219 @@
+package network
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "math/rand"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/peerstore"
+    ma "github.com/multiformats/go-multiaddr"
+)
+
+// Peer exchange (PEX) lets a node that joined through a single bootstrap
+// address find the rest of the network quickly. Every PexInterval a node
+// gossips a small random sample of the healthy peers it is connected to,
+// signed with its key so peers relaying the sample cannot alter it. A node
+// with fewer than pexTargetPeers connections dials a few of the peers it
+// learns of; the others are kept in the peerstore for later.
+const (
+    PexInterval    = time.Minute
+    pexSampleSize  = 8                // Peers in a sample
+    pexMaxAddrs    = 4                // Addresses given per peer
+    pexTargetPeers = 16               // Connections below which learned peers are dialed
+    pexMaxDials    = 4                // Peers dialed per received sample
+    pexMaxAge      = 3 * PexInterval  // Oldest sample accepted
+    pexClockSkew   = time.Minute      // Tolerated timestamp in the future
+    pexDialTimeout = 10 * time.Second
+)
+
+// PexPeer is a peer in a peer exchange sample
+type PexPeer struct {
+    ID    peer.ID  `json:"id"`
+    Addrs []string `json:"addrs"`
+}
+
+// PeerExchange is the payload of a peer_exchange message, a sample of the
+// sender's healthy peers signed by the sender
+type PeerExchange struct {
+    Sender    peer.ID   `json:"sender"`
+    Peers     []PexPeer `json:"peers"`
+    Timestamp time.Time `json:"timestamp"`
+    PublicKey []byte    `json:"public_key"`
+    Signature []byte    `json:"signature"`
+}
+
+// NewPeerExchange creates a sample of peers signed with priv
+func NewPeerExchange(peers []PexPeer, priv crypto.PrivKey) (*PeerExchange, error) {
+    sender, err := peer.IDFromPrivateKey(priv)
+    if err != nil {
+        return nil, fmt.Errorf("failed to derive sender ID: %w", err)
+    }
+    pubKey, err := crypto.MarshalPublicKey(priv.GetPublic())
+    if err != nil {
+        return nil, fmt.Errorf("failed to marshal public key: %w", err)
+    }
+
+    x := &PeerExchange{
+        Sender:    sender,
+        Peers:     peers,
+        Timestamp: time.Now(),
+        PublicKey: pubKey,
+    }
+    data, err := x.signingBytes()
+    if err != nil {
+        return nil, err
+    }
+    if x.Signature, err = priv.Sign(data); err != nil {
+        return nil, fmt.Errorf("failed to sign peer exchange: %w", err)
+    }
+    return x, nil
+}
+
+// signingBytes returns the canonical encoding covered by the signature
+func (x *PeerExchange) signingBytes() ([]byte, error) {
+    unsigned := *x
+    unsigned.Signature = nil
+    return json.Marshal(&unsigned)
+}
+
+// Verify checks that a sample is signed by its sender, recent and small
+func (x *PeerExchange) Verify() error {
+    if len(x.Peers) > pexSampleSize {
+        return fmt.Errorf("%w: %d peers in sample", ErrInvalidPeerExchange, len(x.Peers))
+    }
+    age := time.Since(x.Timestamp)
+    if age > pexMaxAge || age < -pexClockSkew {
+        return fmt.Errorf("%w: sample is stale", ErrInvalidPeerExchange)
+    }
+
+    pubKey, err := crypto.UnmarshalPublicKey(x.PublicKey)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidPeerExchange, err)
+    }
+    if !x.Sender.MatchesPublicKey(pubKey) {
+        return fmt.Errorf("%w: key does not match sender", ErrInvalidPeerExchange)
+    }
+    data, err := x.signingBytes()
+    if err != nil {
+        return err
+    }
+    ok, err := pubKey.Verify(data, x.Signature)
+    if err != nil || !ok {
+        return fmt.Errorf("%w: bad signature", ErrInvalidPeerExchange)
+    }
+    return nil
+}
+
+// exchangePeers periodically gossips a sample of this node's peers
+func (gm *GossipManagerImpl) exchangePeers() {
+    ticker := time.NewTicker(PexInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-gm.ctx.Done():
+            return
+        case <-ticker.C:
+            if err := gm.publishPeerSample(); err != nil {
+                fmt.Printf("failed to publish peer sample: %v\n", err)
+            }
+        }
+    }
+}
+
+// publishPeerSample gossips a signed sample of this node's healthy peers
+func (gm *GossipManagerImpl) publishPeerSample() error {
+    sample := gm.healthyPeerSample()
+    if len(sample) == 0 {
+        return nil
+    }
+    x, err := NewPeerExchange(sample, gm.host.Peerstore().PrivKey(gm.host.ID()))
+    if err != nil {
+        return err
+    }
+    return gm.Publish(GossipPeerExchange, x)
+}
+
+// healthyPeerSample returns up to pexSampleSize random connected peers
+// that have not failed more requests than they served, with their
+// addresses
+func (gm *GossipManagerImpl) healthyPeerSample() []PexPeer {
+    connected := gm.host.Network().Peers()
+    rand.Shuffle(len(connected), func(i, j int) { connected[i], connected[j] = connected[j], connected[i] })
+
+    gm.mu.RLock()
+    defer gm.mu.RUnlock()
+
+    var sample []PexPeer
+    for _, id := range connected {
+        if len(sample) == pexSampleSize {
+            break
+        }
+        if m, ok := gm.metrics[id]; ok && m.failedRequests > m.successfulRequests {
+            continue
+        }
+        var addrs []string
+        for _, addr := range gm.host.Peerstore().Addrs(id) {
+            if len(addrs) == pexMaxAddrs {
+                break
+            }
+            addrs = append(addrs, addr.String())
+        }
+        if len(addrs) > 0 {
+            sample = append(sample, PexPeer{ID: id, Addrs: addrs})
+        }
+    }
+    return sample
+}
+
+// handlePeerExchange learns the peers in a sample gossiped by its sender,
+// dialing some while this node has few connections
+func (gm *GossipManagerImpl) handlePeerExchange(from peer.ID, payload json.RawMessage) {
+    var x PeerExchange
+    if err := json.Unmarshal(payload, &x); err != nil {
+        return
+    }
+    if x.Sender != from || x.Verify() != nil {
+        return
+    }
+
+    var dial []peer.AddrInfo
+    for _, p := range x.Peers {
+        if p.ID == gm.host.ID() || p.ID == "" {
+            continue
+        }
+        info := peer.AddrInfo{ID: p.ID}
+        for _, s := range p.Addrs {
+            if len(info.Addrs) == pexMaxAddrs {
+                break
+            }
+            if addr, err := ma.NewMultiaddr(s); err == nil {
+                info.Addrs = append(info.Addrs, addr)
+            }
+        }
+        if len(info.Addrs) == 0 {
+            continue
+        }
+        gm.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+        if gm.host.Network().Connectedness(info.ID) != network.Connected {
+            dial = append(dial, info)
+        }
+    }
+
+    room := pexTargetPeers - len(gm.host.Network().Peers())
+    if room > pexMaxDials {
+        room = pexMaxDials
+    }
+    for i := 0; i < room && i < len(dial); i++ {
+        go func(info peer.AddrInfo) {
+            ctx, cancel := context.WithTimeout(gm.ctx, pexDialTimeout)
+            defer cancel()
+            gm.host.Connect(ctx, info)
+        }(dial[i])
+    }
+}
@@ -0,0 +1,95 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerExchangeVerify(t *testing.T) {
+	priv, sender := newTestKey(t)
+	_, other := newTestKey(t)
+	peers := []PexPeer{{ID: other, Addrs: []string{"/ip4/127.0.0.1/tcp/4001"}}}
+
+	x, err := NewPeerExchange(peers, priv)
+	require.NoError(t, err)
+	assert.Equal(t, sender, x.Sender)
+	assert.NoError(t, x.Verify())
+
+	tampered := *x
+	tampered.Peers = []PexPeer{{ID: other, Addrs: []string{"/ip4/10.0.0.1/tcp/4001"}}}
+	assert.ErrorIs(t, tampered.Verify(), ErrInvalidPeerExchange)
+
+	impersonated := *x
+	impersonated.Sender = other
+	assert.ErrorIs(t, impersonated.Verify(), ErrInvalidPeerExchange)
+
+	stale, err := NewPeerExchange(peers, priv)
+	require.NoError(t, err)
+	stale.Timestamp = time.Now().Add(-2 * pexMaxAge)
+	assert.ErrorIs(t, stale.Verify(), ErrInvalidPeerExchange)
+
+	large, err := NewPeerExchange(make([]PexPeer, pexSampleSize+1), priv)
+	require.NoError(t, err)
+	assert.ErrorIs(t, large.Verify(), ErrInvalidPeerExchange)
+}
+
+func TestPeerExchangeDialsLearnedPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The bootstrap node knows both others, the joining node only it
+	joining, bootstrap := setupTestHosts(t)
+	defer joining.Close()
+	defer bootstrap.Close()
+	other, _ := setupTestHosts(t)
+	defer other.Close()
+	require.NoError(t, bootstrap.Connect(ctx, peer.AddrInfo{ID: other.ID(), Addrs: other.Addrs()}))
+
+	sender := newStatsGossip(bootstrap)
+	sample := sender.healthyPeerSample()
+	ids := make([]peer.ID, 0, len(sample))
+	for _, p := range sample {
+		ids = append(ids, p.ID)
+	}
+	assert.ElementsMatch(t, []peer.ID{joining.ID(), other.ID()}, ids)
+
+	x, err := NewPeerExchange(sample, bootstrap.Peerstore().PrivKey(bootstrap.ID()))
+	require.NoError(t, err)
+	payload, err := json.Marshal(x)
+	require.NoError(t, err)
+
+	receiver := newStatsGossip(joining)
+	receiver.ctx = ctx
+
+	// Samples must come from their signer
+	receiver.handlePeerExchange(other.ID(), payload)
+	time.Sleep(200 * time.Millisecond)
+	assert.NotEqual(t, network.Connected, joining.Network().Connectedness(other.ID()))
+
+	receiver.handlePeerExchange(bootstrap.ID(), payload)
+	assert.Eventually(t, func() bool {
+		return joining.Network().Connectedness(other.ID()) == network.Connected
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPeerExchangeSkipsUnhealthyPeers(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	gm := newStatsGossip(h1)
+	gm.RecordFailure(h2.ID())
+	gm.RecordFailure(h2.ID())
+	gm.RecordSuccess(h2.ID(), time.Millisecond)
+	assert.Empty(t, gm.healthyPeerSample())
+
+	gm.RecordSuccess(h2.ID(), time.Millisecond)
+	assert.Len(t, gm.healthyPeerSample(), 1)
+}
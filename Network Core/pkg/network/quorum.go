@@ -7,21 +7,29 @@ import (
     "sync"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
     pubsub "github.com/libp2p/go-libp2p-pubsub"
+    "github.com/libp2p/go-libp2p/core/crypto"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
 )
 
 // VoteState tracks the state of an active vote
 type VoteState struct {
-    Vote          *Vote
-    Responses     map[peer.ID]*VoteResponse
-    Deadline      time.Time
-    complete      bool
+    Vote          *Vote                         `json:"vote"`
+    Responses     map[peer.ID]*VoteResponse     `json:"responses"`
+    Deadline      time.Time                     `json:"deadline"`
+    Complete      bool                          `json:"complete"`
+    Passed        bool                          `json:"passed"`
 }
 
 // newQuorumManagerImpl creates a new quorum management system implementation
-func newQuorumManagerImpl(ctx context.Context, h host.Host, ps *pubsub.PubSub, gm GossipManager) (*QuorumManagerImpl, error) {
+func newQuorumManagerImpl(ctx context.Context, h host.Host, ps *pubsub.PubSub, gm GossipManager, rules QuorumConfig) (*QuorumManagerImpl, error) {
+    if err := rules.Validate(); err != nil {
+        return nil, err
+    }
+
     // Join quorum topic
     topic, err := ps.Join(QuorumTopic)
     if err != nil {
@@ -45,10 +53,16 @@ func newQuorumManagerImpl(ctx context.Context, h host.Host, ps *pubsub.PubSub, g
         peerRep:      make(map[peer.ID]int),
         voteResults:  make(map[string]bool),
         voteComplete: make(chan *Vote, 100),
-        peerBanned:   make(chan peer.ID, 100),
+        peerBanned:   make(chan *Vote, 100),
         fileRemoved:  make(chan string, 100),
+        privKey:      h.Peerstore().PrivKey(h.ID()),
+        replay:       newReplayGuard(),
+        rules:        rules,
     }
 
+    // Serve vote history to peers catching up
+    h.SetStreamHandler(protocol.ID(voteHistoryProtocol), qm.handleHistoryStream)
+
     // Start vote handling
     go qm.handleVotes()
     go qm.processVoteResults()
@@ -64,6 +78,10 @@ type QuorumManagerImpl struct {
     topic        *pubsub.Topic
     subscription *pubsub.Subscription
     gossipMgr    GossipManager
+    privKey      crypto.PrivKey
+    replay       *replayGuard
+    votesPath    string
+    rules        QuorumConfig
 
     // Voting state
     activeVotes map[string]*VoteState
@@ -73,7 +91,7 @@ type QuorumManagerImpl struct {
 
     // Channels
     voteComplete chan *Vote
-    peerBanned   chan peer.ID
+    peerBanned   chan *Vote
     fileRemoved  chan string
 }
 
@@ -89,10 +107,19 @@ func (qm *QuorumManagerImpl) Stop() error {
 
 // ProposeVote initiates a new network vote
 func (qm *QuorumManagerImpl) ProposeVote(voteType VoteType, target string, reason string, evidence []byte) error {
+    _, err := qm.Propose(voteType, target, reason, evidence)
+    return err
+}
+
+// Propose initiates a new network vote and returns it, so its outcome can
+// be followed with VoteState
+func (qm *QuorumManagerImpl) Propose(voteType VoteType, target string, reason string, evidence []byte) (*Vote, error) {
+    rules := qm.Rules()
+
     // Check if we have enough peers for a valid quorum
     peers := qm.gossipMgr.GetPeers()
-    if len(peers) < MinQuorumSize {
-        return fmt.Errorf("insufficient peers for quorum: need %d, have %d", MinQuorumSize, len(peers))
+    if len(peers) < rules.MinQuorumSize {
+        return nil, fmt.Errorf("insufficient peers for quorum: need %d, have %d", rules.MinQuorumSize, len(peers))
     }
 
     vote := &Vote{
@@ -104,26 +131,34 @@ func (qm *QuorumManagerImpl) ProposeVote(voteType VoteType, target string, reaso
         Timestamp: time.Now(),
         Proposer:  qm.host.ID(),
     }
+    if err := SignVote(vote, qm.privKey, rules.VotingTimeout); err != nil {
+        return nil, err
+    }
 
     // Initialize vote state
     voteState := &VoteState{
         Vote:      vote,
         Responses: make(map[peer.ID]*VoteResponse),
-        Deadline:  time.Now().Add(VotingTimeout),
+        Deadline:  time.Now().Add(rules.VotingTimeout),
     }
 
     // Register active vote
     qm.mu.Lock()
     qm.activeVotes[vote.ID] = voteState
+    qm.saveVotesLocked()
     qm.mu.Unlock()
 
     // Broadcast vote proposal
     data, err := json.Marshal(vote)
     if err != nil {
-        return fmt.Errorf("failed to marshal vote: %w", err)
+        return nil, fmt.Errorf("failed to marshal vote: %w", err)
     }
+    metrics.Votes.WithLabelValues(voteType.String(), "proposed").Inc()
 
-    return qm.topic.Publish(qm.ctx, data)
+    if err := qm.topic.Publish(qm.ctx, data); err != nil {
+        return nil, err
+    }
+    return vote, nil
 }
 
 // handleVotes processes incoming vote messages
@@ -137,18 +172,31 @@ func (qm *QuorumManagerImpl) handleVotes() {
             continue
         }
 
-        // Handle vote proposal or response
+        // Handle vote proposal or response. Messages must be signed by
+        // their author, unexpired and not seen before.
         if isVoteResponse(msg.Data) {
             var resp VoteResponse
             if err := json.Unmarshal(msg.Data, &resp); err != nil {
                 continue
             }
+            if msg.GetFrom() != resp.Voter || VerifyVoteResponse(&resp) != nil {
+                continue
+            }
+            if qm.replay.check(resp.Voter, resp.Nonce, resp.Expires) != nil {
+                continue
+            }
             qm.processVoteResponse(&resp)
         } else {
             var vote Vote
             if err := json.Unmarshal(msg.Data, &vote); err != nil {
                 continue
             }
+            if msg.GetFrom() != vote.Proposer || VerifyVote(&vote) != nil {
+                continue
+            }
+            if qm.replay.check(vote.Proposer, vote.Nonce, vote.Expires) != nil {
+                continue
+            }
             qm.processNewVote(&vote)
         }
     }
@@ -164,6 +212,27 @@ func (qm *QuorumManagerImpl) processNewVote(vote *Vote) {
         return
     }
 
+    qm.publishResponseLocked(vote)
+
+    // Track vote locally
+    qm.activeVotes[vote.ID] = &VoteState{
+        Vote:      vote,
+        Responses: make(map[peer.ID]*VoteResponse),
+        Deadline:  time.Now().Add(qm.rules.VotingTimeout),
+    }
+    qm.saveVotesLocked()
+}
+
+// respondToVote casts our response on a vote that is already tracked
+func (qm *QuorumManagerImpl) respondToVote(vote *Vote) {
+    qm.mu.Lock()
+    defer qm.mu.Unlock()
+    qm.publishResponseLocked(vote)
+}
+
+// publishResponseLocked validates a vote and publishes our signed response.
+// Callers must hold qm.mu.
+func (qm *QuorumManagerImpl) publishResponseLocked(vote *Vote) {
     // Validate vote based on type
     response := &VoteResponse{
         VoteID:    vote.ID,
@@ -181,64 +250,78 @@ func (qm *QuorumManagerImpl) processNewVote(vote *Vote) {
     }
 
     // Send vote response
+    if err := SignVoteResponse(response, qm.privKey, qm.rules.VotingTimeout); err != nil {
+        return
+    }
     data, err := json.Marshal(response)
     if err != nil {
         return
     }
     qm.topic.Publish(qm.ctx, data)
+}
 
-    // Track vote locally
-    qm.activeVotes[vote.ID] = &VoteState{
-        Vote:      vote,
-        Responses: make(map[peer.ID]*VoteResponse),
-        Deadline:  time.Now().Add(VotingTimeout),
+// processVoteResponse handles an incoming vote response. A vote it
+// completes is signalled once qm.mu is released, so a full voteComplete
+// channel cannot stall the other vote handlers.
+func (qm *QuorumManagerImpl) processVoteResponse(resp *VoteResponse) {
+    if completed := qm.recordVoteResponse(resp); completed != nil {
+        qm.voteComplete <- completed
     }
 }
 
-// processVoteResponse handles an incoming vote response
-func (qm *QuorumManagerImpl) processVoteResponse(resp *VoteResponse) {
+// recordVoteResponse stores a vote response and tallies the vote with it,
+// returning the vote if the response completed it
+func (qm *QuorumManagerImpl) recordVoteResponse(resp *VoteResponse) *Vote {
     qm.mu.Lock()
     defer qm.mu.Unlock()
 
     voteState, exists := qm.activeVotes[resp.VoteID]
-    if !exists || voteState.complete {
-        return
-    }
-
-    // Record vote with weight
-    totalWeight := 0
-    approvalWeight := 0
-    for _, v := range voteState.Responses {
-        if v.IsStorer {
-            totalWeight += StorerVoteWeight
-            if v.Approve {
-                approvalWeight += StorerVoteWeight
-            }
-        } else {
-            totalWeight += BaseVoteWeight
-            if v.Approve {
-                approvalWeight += BaseVoteWeight
-            }
-        }
+    if !exists || voteState.Complete {
+        return nil
     }
 
-    // Add new vote
+    // Add new vote, then tally with it counted
     voteState.Responses[resp.Voter] = resp
+    tagPeerRole(qm.host, resp.Voter, RoleValidator)
+    defer qm.saveVotesLocked()
+    totalWeight, approvalWeight := tallyResponses(voteState.Responses, qm.rules)
 
     // Check if we have enough weighted votes
     totalPeers := len(qm.gossipMgr.GetPeers())
     
-    minRequiredWeight := (totalPeers * BaseVoteWeight * MinVotingPercentage) / 100
+    minRequiredWeight := (totalPeers * qm.rules.BaseVoteWeight * qm.rules.MinVotingPercentage) / 100
 
-    if totalWeight >= minRequiredWeight {
-        // Calculate result using weighted votes
-        passed := (approvalWeight * 100 / totalWeight) >= MinVotingPercentage
-        voteState.complete = true
-        qm.voteResults[resp.VoteID] = passed
+    if totalWeight == 0 || totalWeight < minRequiredWeight {
+        return nil
+    }
+
+    // Calculate result using weighted votes
+    passed := (approvalWeight * 100 / totalWeight) >= qm.rules.MinVotingPercentage
+    voteState.Complete = true
+    voteState.Passed = passed
+    qm.voteResults[resp.VoteID] = passed
 
-        // Signal vote completion
-        qm.voteComplete <- voteState.Vote
+    outcome := "rejected"
+    if passed {
+        outcome = "passed"
     }
+    metrics.Votes.WithLabelValues(voteState.Vote.Type.String(), outcome).Inc()
+    return voteState.Vote
+}
+
+// tallyResponses sums the total and approving weight of vote responses
+func tallyResponses(responses map[peer.ID]*VoteResponse, rules QuorumConfig) (total, approval int) {
+    for _, v := range responses {
+        weight := rules.BaseVoteWeight
+        if v.IsStorer {
+            weight = rules.StorerVoteWeight
+        }
+        total += weight
+        if v.Approve {
+            approval += weight
+        }
+    }
+    return total, approval
 }
 
 // validatePeerRemoval checks if a peer should be removed
@@ -250,25 +333,20 @@ func (qm *QuorumManagerImpl) validatePeerRemoval(vote *Vote) bool {
         }
     }
 
-    // Validate evidence if provided
-    if len(vote.Evidence) > 0 {
-        // TODO: Implement evidence validation (e.g., cryptographic proof of bad behavior)
-        return true
-    }
-
-    return false
+    // Otherwise only approve on verifiable proof of misbehavior
+    return VerifyVoteEvidence(vote) == nil
 }
 
-// validateFileRemoval checks if a file should be removed
+// validateFileRemoval checks if a file should be removed. Manifests are
+// only published with a valid owner signature, so a bad signature proves
+// nothing about a published file; removals need evidence of their own.
 func (qm *QuorumManagerImpl) validateFileRemoval(vote *Vote) bool {
-    // TODO: Implement file content validation
-    return true
+    return VerifyVoteEvidence(vote) == nil
 }
 
 // validateRuleUpdate checks if a rule update should be approved
 func (qm *QuorumManagerImpl) validateRuleUpdate(vote *Vote) bool {
-    // TODO: Implement rule update validation
-    return false
+    return VerifyVoteEvidence(vote) == nil
 }
 
 // processVoteResults handles completed votes
@@ -285,17 +363,82 @@ func (qm *QuorumManagerImpl) processVoteResults() {
             if passed {
                 switch vote.Type {
                 case VoteRemovePeer:
-                    qm.peerBanned <- peer.ID(vote.Target)
+                    qm.peerBanned <- vote
                 case VoteRemoveFile:
                     qm.fileRemoved <- vote.Target
                 case VoteUpdateRules:
-                    // TODO: Implement rule updates
+                    qm.applyRuleUpdate(vote)
                 }
             }
         }
     }
 }
 
+// PeerBanned returns passed VoteRemovePeer votes for the ban manager
+func (qm *QuorumManagerImpl) PeerBanned() <-chan *Vote {
+    return qm.peerBanned
+}
+
+// VoteState returns a copy of the tracked state of a vote
+func (qm *QuorumManagerImpl) VoteState(id string) (*VoteState, bool) {
+    qm.mu.RLock()
+    defer qm.mu.RUnlock()
+
+    state, ok := qm.activeVotes[id]
+    if !ok {
+        return nil, false
+    }
+    cp := *state
+    cp.Responses = make(map[peer.ID]*VoteResponse, len(state.Responses))
+    for voter, resp := range state.Responses {
+        cp.Responses[voter] = resp
+    }
+    return &cp, true
+}
+
+// Rules returns the voting rules currently in effect
+func (qm *QuorumManagerImpl) Rules() QuorumConfig {
+    qm.mu.RLock()
+    defer qm.mu.RUnlock()
+    return qm.rules
+}
+
+// ProposeRuleUpdate starts a network-wide vote to replace the voting rules
+func (qm *QuorumManagerImpl) ProposeRuleUpdate(rules QuorumConfig) error {
+    if err := rules.Validate(); err != nil {
+        return err
+    }
+    evidence, err := EncodeEvidence(&RuleUpdateEvidence{Rules: rules})
+    if err != nil {
+        return err
+    }
+    return qm.ProposeVote(VoteUpdateRules, ruleUpdateTarget, "Update quorum rules", evidence)
+}
+
+// applyRuleUpdate installs the rules carried by a passed rules-update vote
+func (qm *QuorumManagerImpl) applyRuleUpdate(vote *Vote) {
+    ev, err := DecodeEvidence(vote.Evidence)
+    if err != nil {
+        return
+    }
+    update, ok := ev.(*RuleUpdateEvidence)
+    if !ok || update.Verify(vote) != nil {
+        return
+    }
+
+    qm.mu.Lock()
+    qm.rules = update.Rules
+    qm.mu.Unlock()
+}
+
+// PeerReputation returns a peer's reputation score. It can be used as a
+// GossipManager reputation source when scoring storage nodes.
+func (qm *QuorumManagerImpl) PeerReputation(id peer.ID) int {
+    qm.mu.RLock()
+    defer qm.mu.RUnlock()
+    return qm.peerRep[id]
+}
+
 // UpdatePeerReputation adjusts a peer's reputation score
 func (qm *QuorumManagerImpl) UpdatePeerReputation(id peer.ID, delta int) error {
     qm.mu.Lock()
@@ -0,0 +1,111 @@
+package network
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A user can report a peer for misbehavior. The report opens a vote on
+// removing the peer, backed by the strongest evidence this node holds: a bad
+// chunk the peer served, which voters can check for themselves, or else a
+// signed misbehavior report stating the user's reason and the transfers
+// that failed. The outcome of the vote is followed by its ID.
+
+// Outcomes of a report's vote
+const (
+    ReportPending  = "pending"
+    ReportPassed   = "passed"
+    ReportRejected = "rejected"
+    ReportExpired  = "expired"
+)
+
+// PeerReport is a report against a peer and the vote it opened
+type PeerReport struct {
+    Peer           peer.ID
+    VoteID         string
+    Evidence       EvidenceKind
+    Reason         string
+    FailedRequests uint64 // Failed requests to the peer at the time of the report
+    BadChunk       string // Hash of the bad chunk offered as evidence, if any
+    Deadline       time.Time
+}
+
+// ReportPeer opens a vote on removing a peer, with the evidence this node
+// holds against it
+func (e *NetworkEngine) ReportPeer(id peer.ID, reason string) (*PeerReport, error) {
+    if e.quorum == nil {
+        return nil, fmt.Errorf("quorum not available")
+    }
+    if id == e.nodeID {
+        return nil, fmt.Errorf("cannot report self")
+    }
+    reason = strings.TrimSpace(reason)
+    if reason == "" {
+        return nil, fmt.Errorf("a reason is required")
+    }
+
+    report := &PeerReport{Peer: id, Reason: reason}
+    for _, stats := range e.PeerStats() {
+        if stats.ID == id {
+            report.FailedRequests = stats.FailedRequests
+        }
+    }
+
+    var ev Evidence
+    if e.validator != nil {
+        if chunk, ok := e.validator.BadChunkEvidence(id); ok && chunk.Data != nil {
+            report.BadChunk = chunk.ChunkHash
+            ev = chunk
+        }
+    }
+    if ev == nil {
+        statement := reason
+        if report.FailedRequests > 0 {
+            statement = fmt.Sprintf("%s (%d failed requests)", reason, report.FailedRequests)
+        }
+        misbehavior, err := NewMisbehaviorReport(id, statement, e.transportHost.Peerstore().PrivKey(e.nodeID))
+        if err != nil {
+            return nil, fmt.Errorf("failed to build evidence: %w", err)
+        }
+        ev = misbehavior
+    }
+    report.Evidence = ev.Kind()
+    evidence, err := EncodeEvidence(ev)
+    if err != nil {
+        return nil, err
+    }
+
+    vote, err := e.quorum.Propose(VoteRemovePeer, string(id), reason, evidence)
+    if err != nil {
+        return nil, fmt.Errorf("failed to propose vote: %w", err)
+    }
+    report.VoteID = vote.ID
+    if state, ok := e.quorum.VoteState(vote.ID); ok {
+        report.Deadline = state.Deadline
+    }
+    return report, nil
+}
+
+// ReportOutcome returns the outcome of a report's vote
+func (e *NetworkEngine) ReportOutcome(voteID string) (string, error) {
+    if e.quorum == nil {
+        return "", fmt.Errorf("quorum not available")
+    }
+    state, ok := e.quorum.VoteState(voteID)
+    if !ok {
+        return "", fmt.Errorf("unknown vote %s", voteID)
+    }
+    switch {
+    case state.Complete && state.Passed:
+        return ReportPassed, nil
+    case state.Complete:
+        return ReportRejected, nil
+    case time.Now().After(state.Deadline):
+        return ReportExpired, nil
+    default:
+        return ReportPending, nil
+    }
+}
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	gm := newStatsGossip(h1)
+	gm.peerStore[h2.ID()] = &PeerGossipInfo{ID: h2.ID()}
+	gm.RecordFailure(h2.ID())
+	gm.RecordFailure(h2.ID())
+
+	rules := DefaultQuorumConfig()
+	rules.MinQuorumSize = 1
+	ps, err := pubsub.NewGossipSub(ctx, h1)
+	require.NoError(t, err)
+	qm, err := newQuorumManagerImpl(ctx, h1, ps, gm, rules)
+	require.NoError(t, err)
+
+	e := &NetworkEngine{
+		transportHost: h1,
+		nodeID:        h1.ID(),
+		quorum:        qm,
+		gossipMgr:     gm,
+		validator:     NewChunkValidator(ctx, qm, nil),
+	}
+
+	_, err = e.ReportPeer(h2.ID(), "  ")
+	assert.Error(t, err, "a reason is required")
+	_, err = e.ReportPeer(h1.ID(), "spam")
+	assert.Error(t, err, "cannot report self")
+
+	// Without a bad chunk the report is backed by a signed statement
+	report, err := e.ReportPeer(h2.ID(), "drops transfers")
+	require.NoError(t, err)
+	assert.Equal(t, EvidenceMisbehavior, report.Evidence)
+	assert.Equal(t, uint64(2), report.FailedRequests)
+	assert.Empty(t, report.BadChunk)
+	assert.True(t, report.Deadline.After(time.Now()))
+
+	state, ok := qm.VoteState(report.VoteID)
+	require.True(t, ok)
+	assert.Equal(t, VoteRemovePeer, state.Vote.Type)
+	require.NoError(t, VerifyVoteEvidence(state.Vote))
+
+	outcome, err := e.ReportOutcome(report.VoteID)
+	require.NoError(t, err)
+	assert.Equal(t, ReportPending, outcome)
+
+	// A bad chunk the peer served is preferred, as voters can check it
+	e.validator.reportBadChunk(h2.ID(), "expectedhash", []byte("tampered"), ValidationHashMismatch)
+	report, err = e.ReportPeer(h2.ID(), "served a corrupt chunk")
+	require.NoError(t, err)
+	assert.Equal(t, EvidenceBadChunk, report.Evidence)
+	assert.Equal(t, "expectedhash", report.BadChunk)
+
+	// Decided votes report their outcome
+	qm.mu.Lock()
+	qm.activeVotes[report.VoteID].Complete = true
+	qm.activeVotes[report.VoteID].Passed = true
+	qm.mu.Unlock()
+	outcome, err = e.ReportOutcome(report.VoteID)
+	require.NoError(t, err)
+	assert.Equal(t, ReportPassed, outcome)
+
+	_, err = e.ReportOutcome("unknown")
+	assert.Error(t, err)
+}
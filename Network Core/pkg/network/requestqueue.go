@@ -0,0 +1,218 @@
+package network
+
+import (
+    "container/heap"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
+)
+
+const (
+    // DefaultRequestQueueSize is the number of storage requests held before
+    // lower priority requests are rejected
+    DefaultRequestQueueSize = 100
+)
+
+// Rejection reasons sent with NotifyStorageRejection
+const (
+    RejectQueueFull = "storage request queue full"
+    RejectEvicted   = "evicted by higher priority request"
+)
+
+// ErrQueueFull is returned when a request does not outrank anything queued
+var ErrQueueFull = fmt.Errorf("storage request queue full")
+
+// queuedRequest is a storage request and its arrival order
+type queuedRequest struct {
+    Request *StorageRequest `json:"request"`
+    Seq     uint64          `json:"seq"`
+}
+
+// requestHeap orders requests by priority, then arrival
+type requestHeap []*queuedRequest
+
+func (h requestHeap) Len() int { return len(h) }
+
+func (h requestHeap) Less(i, j int) bool {
+    if h[i].Request.Priority != h[j].Request.Priority {
+        return h[i].Request.Priority > h[j].Request.Priority
+    }
+    return h[i].Seq < h[j].Seq
+}
+
+func (h requestHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *requestHeap) Push(x interface{}) { *h = append(*h, x.(*queuedRequest)) }
+
+func (h *requestHeap) Pop() interface{} {
+    old := *h
+    n := len(old)
+    item := old[n-1]
+    old[n-1] = nil
+    *h = old[:n-1]
+    return item
+}
+
+// RequestQueue is a bounded priority queue of pending storage requests.
+// Higher priority requests are served first and, when the queue is full,
+// displace the lowest priority request queued. The queue can be persisted so
+// accepted requests survive restarts.
+type RequestQueue struct {
+    items    requestHeap
+    capacity int
+    bytes    int64
+    seq      uint64
+    path     string
+    mu       sync.Mutex
+}
+
+// NewRequestQueue creates a queue holding at most capacity requests
+func NewRequestQueue(capacity int) *RequestQueue {
+    if capacity <= 0 {
+        capacity = DefaultRequestQueueSize
+    }
+    return &RequestQueue{capacity: capacity}
+}
+
+// EnablePersistence stores the queue in dir and reloads any requests saved
+// by a previous run
+func (q *RequestQueue) EnablePersistence(dir string) error {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return fmt.Errorf("failed to create queue directory: %w", err)
+    }
+    path := filepath.Join(dir, "requests.json")
+
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.path = path
+
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to read request queue: %w", err)
+    }
+
+    var items []*queuedRequest
+    if err := json.Unmarshal(data, &items); err != nil {
+        return fmt.Errorf("failed to parse request queue: %w", err)
+    }
+    for _, item := range items {
+        if item.Request == nil || len(q.items) >= q.capacity {
+            continue
+        }
+        heap.Push(&q.items, item)
+        q.bytes += item.Request.Size
+        if item.Seq >= q.seq {
+            q.seq = item.Seq + 1
+        }
+    }
+    metrics.StorageQueueDepth.Set(float64(len(q.items)))
+    return nil
+}
+
+// Push queues a request. If the queue is full and the request outranks the
+// lowest priority request queued, that request is evicted and returned so
+// the caller can reject it. ErrQueueFull is returned if the request itself
+// cannot be queued.
+func (q *RequestQueue) Push(req *StorageRequest) (*StorageRequest, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    var evicted *StorageRequest
+    if len(q.items) >= q.capacity {
+        lowest := q.lowestLocked()
+        if lowest < 0 || q.items[lowest].Request.Priority >= req.Priority {
+            metrics.StorageQueueRejections.WithLabelValues("full").Inc()
+            return nil, ErrQueueFull
+        }
+        evicted = heap.Remove(&q.items, lowest).(*queuedRequest).Request
+        q.bytes -= evicted.Size
+        metrics.StorageQueueRejections.WithLabelValues("evicted").Inc()
+    }
+
+    heap.Push(&q.items, &queuedRequest{Request: req, Seq: q.seq})
+    q.bytes += req.Size
+    q.seq++
+    q.updateLocked()
+    return evicted, nil
+}
+
+// Pop removes and returns the highest priority request
+func (q *RequestQueue) Pop() (*StorageRequest, bool) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if len(q.items) == 0 {
+        return nil, false
+    }
+    item := heap.Pop(&q.items).(*queuedRequest)
+    q.bytes -= item.Request.Size
+    q.updateLocked()
+    return item.Request, true
+}
+
+// Pending returns the queued requests in no particular order
+func (q *RequestQueue) Pending() []*StorageRequest {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    reqs := make([]*StorageRequest, len(q.items))
+    for i, item := range q.items {
+        reqs[i] = item.Request
+    }
+    return reqs
+}
+
+// Len returns the number of queued requests
+func (q *RequestQueue) Len() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return len(q.items)
+}
+
+// Bytes returns the total size of queued requests
+func (q *RequestQueue) Bytes() int64 {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.bytes
+}
+
+// lowestLocked returns the index of the request that would be served last.
+// Callers must hold q.mu.
+func (q *RequestQueue) lowestLocked() int {
+    lowest := -1
+    for i := range q.items {
+        if lowest < 0 || q.items.Less(lowest, i) {
+            lowest = i
+        }
+    }
+    return lowest
+}
+
+// updateLocked refreshes metrics and persists the queue. Callers must hold
+// q.mu.
+func (q *RequestQueue) updateLocked() {
+    metrics.StorageQueueDepth.Set(float64(len(q.items)))
+    if q.path == "" {
+        return
+    }
+
+    data, err := json.Marshal(q.items)
+    if err != nil {
+        return
+    }
+    tmp := q.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        fmt.Printf("failed to save request queue: %v\n", err)
+        return
+    }
+    if err := os.Rename(tmp, q.path); err != nil {
+        fmt.Printf("failed to save request queue: %v\n", err)
+    }
+}
@@ -0,0 +1,63 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestQueuePriority(t *testing.T) {
+	q := NewRequestQueue(3)
+
+	low := &StorageRequest{ChunkHash: "low", Priority: 0}
+	first := &StorageRequest{ChunkHash: "first", Priority: 1}
+	second := &StorageRequest{ChunkHash: "second", Priority: 1}
+	urgent := &StorageRequest{ChunkHash: "urgent", Priority: 3}
+
+	for _, req := range []*StorageRequest{low, first, second} {
+		evicted, err := q.Push(req)
+		require.NoError(t, err)
+		assert.Nil(t, evicted)
+	}
+
+	// A full queue rejects requests that do not outrank anything queued
+	_, err := q.Push(&StorageRequest{ChunkHash: "late", Priority: 0})
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	// Higher priority requests displace the lowest priority one
+	evicted, err := q.Push(urgent)
+	require.NoError(t, err)
+	assert.Equal(t, low, evicted)
+
+	// Served by priority, then arrival order
+	for _, want := range []*StorageRequest{urgent, first, second} {
+		got, ok := q.Pop()
+		require.True(t, ok)
+		assert.Equal(t, want, got)
+	}
+	_, ok := q.Pop()
+	assert.False(t, ok)
+}
+
+func TestRequestQueuePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	q := NewRequestQueue(10)
+	require.NoError(t, q.EnablePersistence(dir))
+	_, err := q.Push(&StorageRequest{ChunkHash: "a", Data: []byte{1}, Size: 1, Priority: 1})
+	require.NoError(t, err)
+	_, err = q.Push(&StorageRequest{ChunkHash: "b", Data: []byte{2}, Size: 1, Priority: 2})
+	require.NoError(t, err)
+	_, ok := q.Pop()
+	require.True(t, ok)
+
+	// Requests still pending are restored after a restart
+	restored := NewRequestQueue(10)
+	require.NoError(t, restored.EnablePersistence(dir))
+	require.Equal(t, 1, restored.Len())
+	req, ok := restored.Pop()
+	require.True(t, ok)
+	assert.Equal(t, "a", req.ChunkHash)
+	assert.Equal(t, []byte{1}, req.Data)
+}
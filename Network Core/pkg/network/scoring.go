@@ -0,0 +1,208 @@
+package network
+
+import (
+    "encoding/json"
+    "sort"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Score weights, summing to 1
+const (
+    uptimeScoreWeight     = 0.35
+    latencyScoreWeight    = 0.25
+    spaceScoreWeight      = 0.2
+    reputationScoreWeight = 0.2
+
+    // latencyReference is the response time in ms that halves the latency score
+    latencyReference = 100.0
+)
+
+// StorageConstraints filters candidate storage nodes
+type StorageConstraints struct {
+    MinFreeSpace    int64     // Minimum available bytes
+    MinUptime       float64   // Minimum uptime percentage
+    MaxResponseTime float64   // Maximum average response time in ms, 0 for no limit
+    ChunkSize       int64     // Chunk size the node must accept, 0 for any
+    MaxPrice        int64     // Maximum asking price per GiB, 0 for no limit
+    Exclude         []peer.ID // Peers that must not be selected
+}
+
+// ReputationSource reports a peer's quorum reputation
+type ReputationSource func(id peer.ID) int
+
+// PeerScore is the computed desirability of a storage node
+type PeerScore struct {
+    ID           peer.ID
+    Score        float64
+    Uptime       float64
+    ResponseTime float64
+    FreeSpace    int64
+    Reputation   int
+}
+
+// SetReputationSource sets where peer reputation is read from when scoring
+func (gm *GossipManagerImpl) SetReputationSource(source ReputationSource) {
+    gm.mu.Lock()
+    defer gm.mu.Unlock()
+    gm.reputation = source
+}
+
+// handleStorageAnnounce records a storage node announced by the node itself
+func (gm *GossipManagerImpl) handleStorageAnnounce(from peer.ID, payload json.RawMessage) {
+    var info StorageNodeInfo
+    if err := json.Unmarshal(payload, &info); err != nil {
+        return
+    }
+    if info.ID != from.String() {
+        return
+    }
+
+    gm.mu.Lock()
+    defer gm.mu.Unlock()
+    gm.storageNodes[from] = &info
+    tagPeerRole(gm.host, from, RoleStorageProvider)
+
+    // Track transfers to the node so our own measurements feed its score
+    if _, ok := gm.metrics[from]; !ok {
+        gm.metrics[from] = &PeerMetrics{
+            lastSeen:        time.Now(),
+            connectionStart: time.Now(),
+        }
+    }
+}
+
+// handleStorageRemove forgets a storage node that withdrew itself
+func (gm *GossipManagerImpl) handleStorageRemove(from peer.ID, payload json.RawMessage) {
+    var removal StorageRemoval
+    if err := json.Unmarshal(payload, &removal); err != nil {
+        return
+    }
+    if removal.NodeID != from.String() {
+        return
+    }
+
+    gm.mu.Lock()
+    defer gm.mu.Unlock()
+    delete(gm.storageNodes, from)
+    untagPeerRole(gm.host, from, RoleStorageProvider)
+}
+
+// ScoreStorageNodes scores every known storage node that satisfies the
+// constraints, best first
+func (gm *GossipManagerImpl) ScoreStorageNodes(constraints StorageConstraints) []PeerScore {
+    excluded := make(map[peer.ID]struct{}, len(constraints.Exclude))
+    for _, id := range constraints.Exclude {
+        excluded[id] = struct{}{}
+    }
+
+    gm.mu.RLock()
+    defer gm.mu.RUnlock()
+
+    scores := make([]PeerScore, 0, len(gm.storageNodes))
+    for id, node := range gm.storageNodes {
+        if _, skip := excluded[id]; skip {
+            continue
+        }
+        if !node.accepts(constraints) {
+            continue
+        }
+
+        s := gm.scorePeerLocked(id, node)
+        if s.FreeSpace < constraints.MinFreeSpace || s.Uptime < constraints.MinUptime {
+            continue
+        }
+        if constraints.MaxResponseTime > 0 && s.ResponseTime > constraints.MaxResponseTime {
+            continue
+        }
+        if s.Reputation <= ReputationThreshold {
+            continue
+        }
+        scores = append(scores, s)
+    }
+
+    sort.Slice(scores, func(i, j int) bool {
+        if scores[i].Score != scores[j].Score {
+            return scores[i].Score > scores[j].Score
+        }
+        return scores[i].ID < scores[j].ID
+    })
+    return scores
+}
+
+// SelectStorageNodes returns up to n of the best scoring storage nodes
+func (gm *GossipManagerImpl) SelectStorageNodes(n int, constraints StorageConstraints) []peer.ID {
+    scores := gm.ScoreStorageNodes(constraints)
+    if len(scores) > n {
+        scores = scores[:n]
+    }
+
+    nodes := make([]peer.ID, len(scores))
+    for i, s := range scores {
+        nodes[i] = s.ID
+    }
+    return nodes
+}
+
+// scorePeerLocked computes a node's score. Measurements we made ourselves
+// take precedence over values the peer gossiped about itself. Callers must
+// hold gm.mu.
+func (gm *GossipManagerImpl) scorePeerLocked(id peer.ID, node *StorageNodeInfo) PeerScore {
+    s := PeerScore{
+        ID:        id,
+        Uptime:    node.Uptime,
+        FreeSpace: node.AvailableSpace,
+    }
+    if info, ok := gm.peerStore[id]; ok {
+        s.Uptime = info.Uptime
+        s.ResponseTime = info.ResponseTime
+    }
+    if m, ok := gm.metrics[id]; ok && m.successfulRequests+m.failedRequests > 0 {
+        s.Uptime = gm.calculateUptime(m)
+        s.ResponseTime = gm.calculateAverageResponseTime(m)
+    }
+    if gm.reputation != nil {
+        s.Reputation = gm.reputation(id)
+    }
+
+    uptime := clampScore(s.Uptime / 100)
+    latency := latencyReference / (latencyReference + s.ResponseTime)
+    space := 0.0
+    if node.TotalSpace > 0 {
+        space = clampScore(float64(node.AvailableSpace) / float64(node.TotalSpace))
+    }
+    reputation := clampScore(float64(s.Reputation-ReputationThreshold) / float64(MaxReputation-ReputationThreshold))
+
+    s.Score = uptime*uptimeScoreWeight +
+        latency*latencyScoreWeight +
+        space*spaceScoreWeight +
+        reputation*reputationScoreWeight
+    return s
+}
+
+// accepts reports whether the node's advertised offer covers the chunk size
+// and price constraints. Nodes that do not advertise a size range accept
+// any chunk.
+func (node *StorageNodeInfo) accepts(constraints StorageConstraints) bool {
+    if constraints.ChunkSize > 0 && node.MaxChunkSize > 0 {
+        if constraints.ChunkSize < node.MinChunkSize || constraints.ChunkSize > node.MaxChunkSize {
+            return false
+        }
+    }
+    if constraints.MaxPrice > 0 && node.Price > constraints.MaxPrice {
+        return false
+    }
+    return true
+}
+
+// clampScore limits a score component to [0, 1]
+func clampScore(v float64) float64 {
+    if v < 0 {
+        return 0
+    }
+    if v > 1 {
+        return 1
+    }
+    return v
+}
@@ -0,0 +1,123 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func announceTestNode(t *testing.T, gm *GossipManagerImpl, available, total int64, uptime float64) peer.ID {
+	_, id := newTestKey(t)
+	payload, err := json.Marshal(&StorageNodeInfo{
+		ID:             id.String(),
+		AvailableSpace: available,
+		TotalSpace:     total,
+		Uptime:         uptime,
+	})
+	require.NoError(t, err)
+	gm.handleStorageAnnounce(id, payload)
+	return id
+}
+
+func TestSelectStorageNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	ps, err := pubsub.NewGossipSub(ctx, h1)
+	require.NoError(t, err)
+	mgr, err := NewGossipManager(ctx, h1, ps)
+	require.NoError(t, err)
+	gm := mgr.(*GossipManagerImpl)
+
+	good := announceTestNode(t, gm, 900, 1000, 99)
+	slow := announceTestNode(t, gm, 900, 1000, 99)
+	full := announceTestNode(t, gm, 10, 1000, 99)
+	flaky := announceTestNode(t, gm, 900, 1000, 40)
+
+	gm.RecordSuccess(good, 20*time.Millisecond)
+	gm.RecordSuccess(slow, 2*time.Second)
+
+	// Nodes cannot announce on behalf of others
+	_, other := newTestKey(t)
+	payload, err := json.Marshal(&StorageNodeInfo{ID: other.String(), AvailableSpace: 1000, TotalSpace: 1000})
+	require.NoError(t, err)
+	gm.handleStorageAnnounce(good, payload)
+
+	// The fast node ranks first and the slow node last
+	selected := gm.SelectStorageNodes(10, StorageConstraints{})
+	require.Len(t, selected, 4)
+	assert.Equal(t, good, selected[0])
+	assert.Equal(t, slow, selected[3])
+	assert.Equal(t, []peer.ID{good}, gm.SelectStorageNodes(1, StorageConstraints{}))
+
+	selected = gm.SelectStorageNodes(10, StorageConstraints{
+		MinFreeSpace:    100,
+		MinUptime:       50,
+		MaxResponseTime: 500,
+	})
+	assert.Equal(t, []peer.ID{good}, selected)
+
+	selected = gm.SelectStorageNodes(10, StorageConstraints{Exclude: []peer.ID{good}})
+	assert.NotContains(t, selected, good)
+	assert.Contains(t, selected, full)
+	assert.Contains(t, selected, flaky)
+
+	// Peers below the reputation threshold are never selected
+	gm.SetReputationSource(func(id peer.ID) int {
+		if id == good {
+			return ReputationThreshold
+		}
+		return 0
+	})
+	assert.NotContains(t, gm.SelectStorageNodes(10, StorageConstraints{}), good)
+
+	// Withdrawn nodes are forgotten
+	payload, err = json.Marshal(&StorageRemoval{NodeID: slow.String()})
+	require.NoError(t, err)
+	gm.handleStorageRemove(slow, payload)
+	assert.NotContains(t, gm.SelectStorageNodes(10, StorageConstraints{}), slow)
+}
+
+func TestChunkUpload(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	sender := NewChunkStore(h1)
+	receiver := NewChunkStore(h2)
+
+	data := []byte{1, 2, 3, 4, 5, 6}
+	req := &StorageRequest{ChunkHash: "abc", Data: data, Size: int64(len(data))}
+	require.NoError(t, sender.transfers.Upload(h2.ID(), req))
+
+	queued, err := receiver.GetPendingRequest()
+	require.NoError(t, err)
+	assert.Equal(t, data, queued.Data)
+	assert.Equal(t, h1.ID().String(), queued.Owner)
+
+	// Size must match the data sent
+	req.Size = 100
+	assert.Error(t, sender.transfers.Upload(h2.ID(), req))
+}
+
+func TestStorageNodeAccepts(t *testing.T) {
+	node := &StorageNodeInfo{MinChunkSize: 10, MaxChunkSize: 100, Price: 5}
+
+	assert.True(t, node.accepts(StorageConstraints{ChunkSize: 50, MaxPrice: 5}))
+	assert.False(t, node.accepts(StorageConstraints{ChunkSize: 5}))
+	assert.False(t, node.accepts(StorageConstraints{ChunkSize: 500}))
+	assert.False(t, node.accepts(StorageConstraints{MaxPrice: 4}))
+
+	// Nodes without an advertised range take any chunk
+	assert.True(t, (&StorageNodeInfo{}).accepts(StorageConstraints{ChunkSize: 500}))
+}
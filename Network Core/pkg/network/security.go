@@ -0,0 +1,157 @@
+package network
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+
+    "github.com/libp2p/go-libp2p"
+    "github.com/libp2p/go-libp2p/core/control"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/p2p/security/noise"
+    libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+    ma "github.com/multiformats/go-multiaddr"
+)
+
+// SecurityConfig defines transport security and peer admission settings
+type SecurityConfig struct {
+    // RequireSecureStreams restricts the hosts to Noise/TLS security and makes
+    // the chunk protocol refuse streams over anything else
+    RequireSecureStreams bool
+    // AllowedPeers, when non-empty, is the exclusive set of peers we talk to
+    AllowedPeers []peer.ID
+    // DeniedPeers are always refused, even if present in AllowedPeers
+    DeniedPeers []peer.ID
+}
+
+// ErrPeerNotAllowed is returned when a peer is rejected by the peer policy
+var ErrPeerNotAllowed = fmt.Errorf("peer not allowed by policy")
+
+// ErrInsecureStream is returned when a stream is not mutually authenticated
+var ErrInsecureStream = fmt.Errorf("stream is not secured")
+
+// PeerPolicy enforces the allowlist/denylist at connection time and the
+// secure-stream requirement for chunk transfers. It implements
+// connmgr.ConnectionGater so it can be installed directly on a libp2p host.
+type PeerPolicy struct {
+    requireSecure bool
+    allowed       map[peer.ID]struct{}
+    denied        map[peer.ID]struct{}
+    mu            sync.RWMutex
+}
+
+// NewPeerPolicy creates a peer policy from configuration
+func NewPeerPolicy(cfg SecurityConfig) *PeerPolicy {
+    p := &PeerPolicy{
+        requireSecure: cfg.RequireSecureStreams,
+        allowed:       make(map[peer.ID]struct{}),
+        denied:        make(map[peer.ID]struct{}),
+    }
+    for _, id := range cfg.AllowedPeers {
+        p.allowed[id] = struct{}{}
+    }
+    for _, id := range cfg.DeniedPeers {
+        p.denied[id] = struct{}{}
+    }
+    return p
+}
+
+// IsAllowed reports whether we may exchange data with a peer
+func (p *PeerPolicy) IsAllowed(id peer.ID) bool {
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+
+    if _, denied := p.denied[id]; denied {
+        return false
+    }
+    if len(p.allowed) == 0 {
+        return true
+    }
+    _, ok := p.allowed[id]
+    return ok
+}
+
+// Deny adds a peer to the denylist
+func (p *PeerPolicy) Deny(id peer.ID) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.denied[id] = struct{}{}
+}
+
+// Undeny removes a peer from the denylist
+func (p *PeerPolicy) Undeny(id peer.ID) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    delete(p.denied, id)
+}
+
+// CheckStream verifies that a stream comes from an allowed peer and, if
+// required, that it runs over a mutually authenticated secure channel
+func (p *PeerPolicy) CheckStream(s network.Stream) error {
+    remote := s.Conn().RemotePeer()
+    if !p.IsAllowed(remote) {
+        return fmt.Errorf("%w: %s", ErrPeerNotAllowed, remote)
+    }
+    if p.requireSecure && !isSecureConn(s.Conn()) {
+        return fmt.Errorf("%w: %s", ErrInsecureStream, remote)
+    }
+    return nil
+}
+
+// isSecureConn reports whether a connection is encrypted and authenticated.
+// QUIC and WebTransport embed TLS 1.3 so they do not report a separate
+// security protocol.
+func isSecureConn(c network.Conn) bool {
+    state := c.ConnState()
+    switch state.Security {
+    case noise.ID, libp2ptls.ID:
+        return true
+    }
+    return strings.HasPrefix(state.Transport, "quic") || state.Transport == "webtransport"
+}
+
+// hostOptions returns the libp2p options that apply this policy to a host
+func (p *PeerPolicy) hostOptions() []libp2p.Option {
+    opts := []libp2p.Option{libp2p.ConnectionGater(p)}
+    if p.requireSecure {
+        // Only negotiate authenticated security transports, never plaintext
+        opts = append(opts,
+            libp2p.Security(noise.ID, noise.New),
+            libp2p.Security(libp2ptls.ID, libp2ptls.New),
+        )
+    }
+    return opts
+}
+
+// InterceptPeerDial implements connmgr.ConnectionGater
+func (p *PeerPolicy) InterceptPeerDial(id peer.ID) bool {
+    return p.IsAllowed(id)
+}
+
+// InterceptAddrDial implements connmgr.ConnectionGater
+func (p *PeerPolicy) InterceptAddrDial(id peer.ID, _ ma.Multiaddr) bool {
+    return p.IsAllowed(id)
+}
+
+// InterceptAccept implements connmgr.ConnectionGater. The remote peer is not
+// known yet, so the decision is deferred to InterceptSecured.
+func (p *PeerPolicy) InterceptAccept(network.ConnMultiaddrs) bool {
+    return true
+}
+
+// InterceptSecured implements connmgr.ConnectionGater
+func (p *PeerPolicy) InterceptSecured(_ network.Direction, id peer.ID, _ network.ConnMultiaddrs) bool {
+    return p.IsAllowed(id)
+}
+
+// InterceptUpgraded implements connmgr.ConnectionGater
+func (p *PeerPolicy) InterceptUpgraded(c network.Conn) (bool, control.DisconnectReason) {
+    if !p.IsAllowed(c.RemotePeer()) {
+        return false, 0
+    }
+    if p.requireSecure && !isSecureConn(c) {
+        return false, 0
+    }
+    return true, 0
+}
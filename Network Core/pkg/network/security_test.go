@@ -0,0 +1,100 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerPolicyLists(t *testing.T) {
+	a, b := peer.ID("peer-a"), peer.ID("peer-b")
+
+	open := NewPeerPolicy(SecurityConfig{})
+	assert.True(t, open.IsAllowed(a))
+
+	allow := NewPeerPolicy(SecurityConfig{AllowedPeers: []peer.ID{a}})
+	assert.True(t, allow.IsAllowed(a))
+	assert.False(t, allow.IsAllowed(b))
+
+	deny := NewPeerPolicy(SecurityConfig{AllowedPeers: []peer.ID{a}, DeniedPeers: []peer.ID{a}})
+	assert.False(t, deny.IsAllowed(a))
+
+	deny.Undeny(a)
+	assert.True(t, deny.IsAllowed(a))
+	deny.Deny(a)
+	assert.False(t, deny.IsAllowed(a))
+}
+
+func TestPeerPolicyGatesConnections(t *testing.T) {
+	h2, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h2.Close()
+
+	policy := NewPeerPolicy(SecurityConfig{
+		RequireSecureStreams: true,
+		DeniedPeers:          []peer.ID{h2.ID()},
+	})
+	h1, err := libp2p.New(append([]libp2p.Option{
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	}, policy.hostOptions()...)...)
+	require.NoError(t, err)
+	defer h1.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info := peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}
+	assert.Error(t, h1.Connect(ctx, info), "dial to denied peer should be refused")
+
+	policy.Undeny(h2.ID())
+	require.NoError(t, h1.Connect(ctx, info))
+
+	conns := h1.Network().ConnsToPeer(h2.ID())
+	require.NotEmpty(t, conns)
+	assert.True(t, isSecureConn(conns[0]))
+}
+
+func TestChunkStoreRefusesInsecureStreams(t *testing.T) {
+	newInsecureHost := func() host.Host {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.NoSecurity)
+		require.NoError(t, err)
+		return h
+	}
+	server, client := newInsecureHost(), newInsecureHost()
+	defer server.Close()
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.Connect(ctx, peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}))
+
+	cs := NewChunkStore(server)
+	require.True(t, cs.Store("hash1", []byte("chunk")))
+	transfers := NewTransferManager(client)
+	_, err := transfers.Download(server.ID(), "hash1")
+	require.NoError(t, err)
+
+	cs.SetPeerPolicy(NewPeerPolicy(SecurityConfig{RequireSecureStreams: true}))
+	_, err = transfers.Download(server.ID(), "hash1")
+	assert.Error(t, err, "plaintext chunk stream should be refused")
+}
+
+func TestNetworkEngineChunkStorePolicy(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+	cfg.Security.RequireSecureStreams = true
+	cfg.Transport.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	cfg.Transport.MetadataListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	engine, err := NewNetworkEngine(context.Background(), cfg)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	require.NotNil(t, engine.chunkStore)
+	assert.Same(t, engine.policy, engine.chunkStore.policy)
+	assert.Same(t, engine.policy, engine.chunkStore.transfers.policy)
+}
@@ -0,0 +1,293 @@
+package network
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/metrics"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// A peer asks another to store a chunk over storeProtocol in four steps:
+//
+//  1. The sender offers the chunk: its hash, size and priority.
+//  2. The receiver accepts or rejects the offer against its storage offer
+//     and quota, so a rejected chunk is never sent.
+//  3. The sender transfers exactly the offered number of bytes.
+//  4. The receiver queues the request and acknowledges it.
+//
+// An offer repeating the idempotency key of a request the receiver already
+// accepted is acknowledged as a duplicate in step 2, and the exchange ends
+// there: a retry after a lost acknowledgement is neither sent nor queued
+// twice.
+//
+// Offers, replies and acknowledgements are JSON lines. Queued requests are
+// stored when the node processes its queue, which tells the network
+// through gossip whether each chunk was stored or rejected.
+
+const (
+    // storeStreamTimeout bounds a whole store exchange
+    storeStreamTimeout = 30 * time.Second
+
+    // maxStoreMessageSize bounds the offers and replies read from a stream
+    maxStoreMessageSize = 4096
+)
+
+// ErrStorageDeclined is returned when a peer rejects a chunk offered to it
+var ErrStorageDeclined = errors.New("storage declined")
+
+// storeOffer announces a chunk before it is transferred
+type storeOffer struct {
+    ChunkHash string `json:"chunk_hash"`
+    Size      int64  `json:"size"`
+    Priority  int    `json:"priority,omitempty"`
+    Key       string `json:"idempotency_key,omitempty"`
+}
+
+// storeReply answers an offer, then acknowledges the transfer
+type storeReply struct {
+    Accepted  bool   `json:"accepted"`
+    Reason    string `json:"reason,omitempty"`    // Why the chunk was rejected
+    Duplicate bool   `json:"duplicate,omitempty"` // The request was already accepted
+}
+
+// handleStoreStream serves a peer asking us to store a chunk. Offers that
+// do not fit the node's storage offer are rejected before any data is
+// sent. Accepted chunks are queued and acknowledged; the node stores them
+// when it processes its queue.
+func (cs *ChunkStore) handleStoreStream(stream network.Stream) {
+    defer stream.Close()
+
+    cs.mu.RLock()
+    policy := cs.policy
+    cs.mu.RUnlock()
+    if policy != nil {
+        if err := policy.CheckStream(stream); err != nil {
+            stream.Reset()
+            return
+        }
+    }
+
+    stream.SetDeadline(time.Now().Add(storeStreamTimeout))
+    dec := json.NewDecoder(io.LimitReader(stream, maxStoreMessageSize))
+    var offer storeOffer
+    if err := dec.Decode(&offer); err != nil {
+        stream.Reset()
+        return
+    }
+
+    if len(offer.Key) > maxIdempotencyKeyLen {
+        writeStoreReply(stream, fmt.Errorf("idempotency key longer than %d bytes", maxIdempotencyKeyLen))
+        return
+    }
+    req := &StorageRequest{
+        ChunkHash:      offer.ChunkHash,
+        Size:           offer.Size,
+        Owner:          stream.Conn().RemotePeer().String(),
+        Priority:       offer.Priority,
+        IdempotencyKey: offer.Key,
+    }
+
+    // Retries of accepted requests are acknowledged without the chunk
+    queued := false
+    if key := requestKey(req); key != "" {
+        duplicate, err := cs.keys.begin(key, req.ChunkHash)
+        if err != nil {
+            metrics.StorageDuplicateRequests.WithLabelValues("refused").Inc()
+            writeStoreReply(stream, err)
+            return
+        }
+        if duplicate {
+            metrics.StorageDuplicateRequests.WithLabelValues("acknowledged").Inc()
+            json.NewEncoder(stream).Encode(&storeReply{Accepted: true, Duplicate: true})
+            return
+        }
+        defer func() {
+            if queued {
+                cs.keys.accept(key)
+            } else {
+                cs.keys.release(key)
+            }
+        }()
+    }
+
+    if err := cs.checkOffer(req); err != nil {
+        writeStoreReply(stream, err)
+        return
+    }
+    if err := writeStoreReply(stream, nil); err != nil {
+        stream.Reset()
+        return
+    }
+
+    // The chunk follows the newline ending the offer, which the decoder
+    // may have read past
+    rest := bufio.NewReader(io.MultiReader(dec.Buffered(), stream))
+    if b, err := rest.ReadByte(); err != nil || b != '\n' {
+        stream.Reset()
+        return
+    }
+    req.Data = make([]byte, req.Size)
+    if _, err := io.ReadFull(rest, req.Data); err != nil {
+        stream.Reset()
+        return
+    }
+    metrics.ChunkTransferBytes.WithLabelValues("download").Add(float64(req.Size))
+
+    err := cs.queueRequest(req)
+    queued = err == nil
+    writeStoreReply(stream, err)
+}
+
+// checkOffer decides whether an offered chunk may be sent, telling the
+// network when it may not
+func (cs *ChunkStore) checkOffer(req *StorageRequest) error {
+    if !isValidChunkHash(req.ChunkHash) {
+        return ErrInvalidChunk
+    }
+    if err := cs.CheckAdmission(req); err != nil {
+        cs.rejectRequest(req, err.Error())
+        return err
+    }
+    return nil
+}
+
+// writeStoreReply accepts an offer or transfer, or rejects it with err
+func writeStoreReply(w io.Writer, err error) error {
+    reply := storeReply{Accepted: err == nil}
+    if err != nil {
+        reply.Reason = err.Error()
+    }
+    return json.NewEncoder(w).Encode(&reply)
+}
+
+// readStoreReply reads the answer to an offer or transfer, reporting
+// whether the peer already holds the request
+func readStoreReply(dec *json.Decoder, to peer.ID, hash string) (bool, error) {
+    var reply storeReply
+    if err := dec.Decode(&reply); err != nil {
+        return false, fmt.Errorf("failed to read reply: %w", err)
+    }
+    if !reply.Accepted {
+        return false, fmt.Errorf("%w: peer %s declined chunk %s: %s", ErrStorageDeclined, to, hash, reply.Reason)
+    }
+    return reply.Duplicate, nil
+}
+
+// Upload asks a peer to store a chunk. It returns once the peer has queued
+// the chunk, or with ErrStorageDeclined if the peer rejected it. Requests
+// without an idempotency key are given one, so uploading the same request
+// again is a retry the peer handles once.
+func (tm *TransferManager) Upload(to peer.ID, req *StorageRequest) error {
+    if tm.host == nil {
+        return fmt.Errorf("transfer manager not initialized")
+    }
+    if to == tm.host.ID() {
+        return fmt.Errorf("cannot upload to self")
+    }
+    if req.Size != int64(len(req.Data)) {
+        return fmt.Errorf("chunk %s is %d bytes, not the %d offered", req.ChunkHash, len(req.Data), req.Size)
+    }
+    if req.IdempotencyKey == "" {
+        req.IdempotencyKey = NewIdempotencyKey()
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), storeStreamTimeout)
+    defer cancel()
+
+    stream, err := tm.host.NewStream(ctx, to, protocol.ID(storeProtocol))
+    if err != nil {
+        return fmt.Errorf("failed to open stream: %w", err)
+    }
+    defer stream.Close()
+
+    tm.mu.RLock()
+    policy := tm.policy
+    tm.mu.RUnlock()
+    if policy != nil {
+        if err := policy.CheckStream(stream); err != nil {
+            stream.Reset()
+            return fmt.Errorf("refusing store stream: %w", err)
+        }
+    }
+
+    stream.SetDeadline(time.Now().Add(storeStreamTimeout))
+    offer := storeOffer{ChunkHash: req.ChunkHash, Size: req.Size, Priority: req.Priority, Key: req.IdempotencyKey}
+    if err := json.NewEncoder(stream).Encode(&offer); err != nil {
+        stream.Reset()
+        return fmt.Errorf("failed to offer chunk: %w", err)
+    }
+    replies := json.NewDecoder(io.LimitReader(stream, 2*maxStoreMessageSize))
+    duplicate, err := readStoreReply(replies, to, req.ChunkHash)
+    if err != nil {
+        return err
+    }
+    if duplicate {
+        return nil
+    }
+
+    if _, err := stream.Write(req.Data); err != nil {
+        stream.Reset()
+        return fmt.Errorf("failed to send chunk: %w", err)
+    }
+    if err := stream.CloseWrite(); err != nil {
+        stream.Reset()
+        return fmt.Errorf("failed to send chunk: %w", err)
+    }
+    if _, err := readStoreReply(replies, to, req.ChunkHash); err != nil {
+        return err
+    }
+    metrics.ChunkTransferBytes.WithLabelValues("upload").Add(float64(len(req.Data)))
+    return nil
+}
+
+// ProcessPending stores every queued storage request that still fits the
+// node's offer and tells the network through gossip which chunks were
+// stored and which were rejected. It returns the number stored.
+func (cs *ChunkStore) ProcessPending() int {
+    stored := 0
+    for {
+        req, err := cs.GetPendingRequest()
+        if err != nil {
+            return stored
+        }
+        if err := cs.CheckAdmission(req); err != nil {
+            cs.rejectRequest(req, err.Error())
+            continue
+        }
+        if !cs.Store(req.ChunkHash, req.Data) {
+            cs.rejectRequest(req, ErrStorageFull.Error())
+            continue
+        }
+        stored++
+        cs.acknowledgeRequest(req)
+    }
+}
+
+// acknowledgeRequest tells the network a requested chunk was stored
+func (cs *ChunkStore) acknowledgeRequest(req *StorageRequest) {
+    cs.mu.RLock()
+    gm := cs.gossip
+    cs.mu.RUnlock()
+    if gm == nil {
+        return
+    }
+    if err := gm.NotifyStorageSuccess(withoutData(req)); err != nil {
+        fmt.Printf("failed to notify storage success: %v\n", err)
+    }
+}
+
+// withoutData copies a request without its chunk, which notifications do
+// not need to carry
+func withoutData(req *StorageRequest) *StorageRequest {
+    notice := *req
+    notice.Data = nil
+    return &notice
+}
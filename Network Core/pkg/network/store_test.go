@@ -0,0 +1,137 @@
+package network
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingGossip records the storage notifications a chunk store sends
+type recordingGossip struct {
+	GossipManager
+	mu       sync.Mutex
+	stored   []*StorageRequest
+	rejected map[string]string // Chunk hash to reason
+}
+
+func newRecordingGossip() *recordingGossip {
+	return &recordingGossip{rejected: make(map[string]string)}
+}
+
+func (g *recordingGossip) NotifyStorageSuccess(req *StorageRequest) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stored = append(g.stored, req)
+	return nil
+}
+
+func (g *recordingGossip) NotifyStorageRejection(req *StorageRequest, reason string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rejected[req.ChunkHash] = reason
+	return nil
+}
+
+func TestStoreWorkflow(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	sender := NewChunkStore(h1)
+	receiver := NewChunkStore(h2)
+	gossip := newRecordingGossip()
+	receiver.RegisterGossip(gossip)
+
+	data := []byte("chunk data")
+	req := &StorageRequest{ChunkHash: "abc", Data: data, Size: int64(len(data)), Priority: 2}
+	require.NoError(t, sender.transfers.Upload(h2.ID(), req))
+
+	// Accepted chunks are queued until the node processes its queue
+	_, ok := receiver.Get("abc")
+	assert.False(t, ok)
+	assert.Equal(t, 1, receiver.ProcessPending())
+	stored, ok := receiver.Get("abc")
+	require.True(t, ok)
+	assert.Equal(t, data, stored)
+
+	// The network hears about the stored chunk, without its data
+	require.Len(t, gossip.stored, 1)
+	assert.Equal(t, "abc", gossip.stored[0].ChunkHash)
+	assert.Equal(t, h1.ID().String(), gossip.stored[0].Owner)
+	assert.Equal(t, 2, gossip.stored[0].Priority)
+	assert.Nil(t, gossip.stored[0].Data)
+	assert.Zero(t, receiver.ProcessPending())
+}
+
+func TestStoreOfferRejected(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	sender := NewChunkStore(h1)
+	receiver := NewChunkStore(h2)
+	gossip := newRecordingGossip()
+	receiver.RegisterGossip(gossip)
+	require.NoError(t, receiver.SetStorageConfig(StorageConfig{Quota: 100, MinChunkSize: 1, MaxChunkSize: 50}))
+
+	// Chunks outside the offer are declined before they are sent
+	big := &StorageRequest{ChunkHash: "big", Data: make([]byte, 60), Size: 60}
+	err := sender.transfers.Upload(h2.ID(), big)
+	assert.ErrorIs(t, err, ErrStorageDeclined)
+	assert.Contains(t, err.Error(), ErrChunkSizeRange.Error())
+	assert.Contains(t, gossip.rejected["big"], ErrChunkSizeRange.Error())
+	_, err = receiver.GetPendingRequest()
+	assert.ErrorIs(t, err, ErrNoRequestsPending)
+
+	// As are chunks that would exceed the quota, counting queued requests
+	for _, hash := range []string{"a", "b"} {
+		req := &StorageRequest{ChunkHash: hash, Data: make([]byte, 50), Size: 50}
+		require.NoError(t, sender.transfers.Upload(h2.ID(), req))
+	}
+	full := &StorageRequest{ChunkHash: "full", Data: make([]byte, 1), Size: 1}
+	assert.ErrorIs(t, sender.transfers.Upload(h2.ID(), full), ErrStorageDeclined)
+	assert.Contains(t, gossip.rejected["full"], ErrQuotaExceeded.Error())
+
+	// Offers must match the data sent
+	mismatched := &StorageRequest{ChunkHash: "c", Data: make([]byte, 5), Size: 10}
+	assert.Error(t, sender.transfers.Upload(h2.ID(), mismatched))
+	assert.Equal(t, 2, receiver.ProcessPending())
+}
+
+func TestStoreRetryIsDuplicate(t *testing.T) {
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	sender := NewChunkStore(h1)
+	receiver := NewChunkStore(h2)
+	gossip := newRecordingGossip()
+	receiver.RegisterGossip(gossip)
+	require.NoError(t, receiver.SetStorageConfig(StorageConfig{Quota: 100, MinChunkSize: 1, MaxChunkSize: 60}))
+
+	// Uploading the same request again is acknowledged without being
+	// queued or counted against the quota twice
+	req := &StorageRequest{ChunkHash: "abc", Data: make([]byte, 60), Size: 60}
+	require.NoError(t, sender.transfers.Upload(h2.ID(), req))
+	require.NotEmpty(t, req.IdempotencyKey)
+	require.NoError(t, sender.transfers.Upload(h2.ID(), req))
+	assert.Equal(t, 1, receiver.requests.Len())
+	assert.Equal(t, int64(40), receiver.FreeSpace())
+
+	// Reusing the key for another chunk is refused
+	other := &StorageRequest{ChunkHash: "def", Data: make([]byte, 10), Size: 10, IdempotencyKey: req.IdempotencyKey}
+	err := sender.transfers.Upload(h2.ID(), other)
+	assert.ErrorIs(t, err, ErrStorageDeclined)
+	assert.Contains(t, err.Error(), ErrIdempotencyConflict.Error())
+
+	// A rejected request is judged afresh when retried
+	big := &StorageRequest{ChunkHash: "big", Data: make([]byte, 50), Size: 50}
+	assert.ErrorIs(t, sender.transfers.Upload(h2.ID(), big), ErrStorageDeclined)
+	assert.Equal(t, 1, receiver.ProcessPending())
+	require.NoError(t, receiver.SetStorageConfig(StorageConfig{Quota: 200, MinChunkSize: 1, MaxChunkSize: 60}))
+	require.NoError(t, sender.transfers.Upload(h2.ID(), big))
+	assert.Equal(t, 1, receiver.ProcessPending())
+	assert.Len(t, gossip.stored, 2)
+}
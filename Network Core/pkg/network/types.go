@@ -5,6 +5,7 @@ import (
     "fmt"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
     "github.com/libp2p/go-libp2p/core/peer"
 )
 
@@ -29,7 +30,7 @@ const (
     maxStorageSize  = 10 * 1024 * 1024 * 1024 // 10GB default max storage
 )
 
-// Vote related constants
+// Vote related constants. The quorum values are defaults for QuorumConfig.
 const (
     QuorumTopic          = "filezap-quorum"
     VotingTimeout        = 30 * time.Second
@@ -39,6 +40,9 @@ const (
     MaxReputation        = 100 // Maximum reputation score
     BaseVoteWeight       = 1   // Base voting weight for regular nodes
     StorerVoteWeight     = 3   // Higher voting weight for storage nodes
+    VoteClockSkew        = 30 * time.Second // Tolerated clock drift for vote expiry
+    MinVotingTimeout     = 5 * time.Second  // Shortest voting timeout rules may set
+    MaxVotingTimeout     = time.Hour        // Longest voting timeout rules may set
 )
 
 // VoteType represents different types of votes
@@ -53,6 +57,20 @@ const (
     VoteUpdateRules
 )
 
+// String returns a readable name for the vote type
+func (t VoteType) String() string {
+    switch t {
+    case VoteRemovePeer:
+        return "remove_peer"
+    case VoteRemoveFile:
+        return "remove_file"
+    case VoteUpdateRules:
+        return "update_rules"
+    default:
+        return "unknown"
+    }
+}
+
 // Vote represents a network decision to be made
 type Vote struct {
     ID        string    `json:"id"`
@@ -62,6 +80,10 @@ type Vote struct {
     Evidence  []byte    `json:"evidence"` // Optional evidence (e.g., invalid chunk data)
     Timestamp time.Time `json:"timestamp"`
     Proposer  peer.ID   `json:"proposer"`
+    Nonce     string    `json:"nonce"`
+    Expires   time.Time `json:"expires"`
+    PublicKey []byte    `json:"public_key"`
+    Signature []byte    `json:"signature"`
 }
 
 // VoteResponse represents a peer's vote
@@ -72,6 +94,10 @@ type VoteResponse struct {
     Timestamp time.Time `json:"timestamp"`
     IsStorer  bool      `json:"is_storer"` // Whether voter is a storage node
     Weight    int       `json:"weight"`     // Voting weight (higher for storage nodes)
+    Nonce     string    `json:"nonce"`
+    Expires   time.Time `json:"expires"`
+    PublicKey []byte    `json:"public_key"`
+    Signature []byte    `json:"signature"`
 }
 
 // ManifestInfo contains metadata about a stored file
@@ -79,11 +105,24 @@ type ManifestInfo struct {
     Name            string
     Owner           string
     ChunkHashes     []string
+    ChunkCIDs       []string `json:",omitempty"` // IPFS CIDs of the chunks, in ChunkHashes order, if published
+    ChunkCount      int      `json:",omitempty"` // Number of chunks listed in ChunkPages
+    ChunkPages      []string `json:",omitempty"` // IDs of the pages listing the chunks of large files, in order
+    ChunkRoot       string   `json:",omitempty"` // Hex merkle root over the chunk hashes, see ChunkProof
     Size            int64
     Created         time.Time
     Modified        time.Time
     ReplicationGoal int
     UpdatedAt       time.Time
+    Sequence        uint64 // Monotonic per-owner update counter used for conflict resolution
+    OwnerKey        []byte // Marshalled public key of Owner
+    ACL             *types.AccessList `json:",omitempty"` // Clients allowed to fetch the chunks, nil for everyone
+    CoOwners        []string          `json:",omitempty"` // Peers the owner allows to publish updates
+    CoOwnerSig      []byte            `json:",omitempty"` // Owner's signature over Name and CoOwners
+    Versions        VersionVector     `json:",omitempty"` // Updates included from each writer
+    Writer          string            `json:",omitempty"` // Co-owner that published this version, empty for the owner
+    WriterKey       []byte            `json:",omitempty"` // Marshalled public key of Writer
+    Signature       []byte // Writer's, or else the owner's, signature over all other fields
 }
 
 // StorageRequest represents a request to store data
@@ -92,6 +131,11 @@ type StorageRequest struct {
     Data      []byte
     Size      int64
     Owner     string
+    Priority  int // Higher priority requests are served first, e.g. under-replicated chunks
+
+    // IdempotencyKey is shared by every retry of the request, so the
+    // receiver handles it once. Empty for requests that are not retried.
+    IdempotencyKey string `json:",omitempty"`
 }
 
 // StorageNodeInfo contains information about a storage node
@@ -102,6 +146,10 @@ type StorageNodeInfo struct {
     Uptime         float64
     Version        string
     Location       string
+    Quota          int64 // Bytes the node offers in total
+    MinChunkSize   int64 // Smallest chunk the node accepts
+    MaxChunkSize   int64 // Largest chunk the node accepts
+    Price          int64 // Asking price per GiB stored, 0 for free
 }
 
 // Error definitions
@@ -109,6 +157,24 @@ var (
     ErrNoRequestsPending = fmt.Errorf("no pending requests")
     ErrStorageFull      = fmt.Errorf("storage full")
     ErrInvalidChunk     = fmt.Errorf("invalid chunk")
+    ErrManifestUnsigned = fmt.Errorf("manifest is not signed")
+    ErrManifestBadSig   = fmt.Errorf("invalid manifest signature")
+    ErrManifestOwner    = fmt.Errorf("manifest owner mismatch")
+    ErrManifestStale    = fmt.Errorf("manifest sequence is not newer than current")
+    ErrManifestConflict = fmt.Errorf("manifest update lost to a concurrent update")
+    ErrManifestVersion  = fmt.Errorf("manifest version not found")
+    ErrVoteUnsigned     = fmt.Errorf("vote message is not signed")
+    ErrVoteBadSig       = fmt.Errorf("invalid vote signature")
+    ErrVoteExpired      = fmt.Errorf("vote message has expired")
+    ErrVoteReplay       = fmt.Errorf("vote message replayed")
+    ErrInvalidQuorumConfig = fmt.Errorf("invalid quorum config")
+    ErrInvalidStorageConfig = fmt.Errorf("invalid storage config")
+    ErrInvalidBackendConfig = fmt.Errorf("invalid chunk backend config")
+    ErrInvalidConnectionConfig = fmt.Errorf("invalid connection config")
+    ErrInvalidManifestCacheConfig = fmt.Errorf("invalid manifest cache config")
+    ErrChunkSizeRange   = fmt.Errorf("chunk size outside accepted range")
+    ErrQuotaExceeded    = fmt.Errorf("storage quota exceeded")
+    ErrInvalidPeerExchange = fmt.Errorf("invalid peer exchange")
 )
 
 // Interface definitions
@@ -142,6 +208,8 @@ type QuorumManager interface {
     Start() error
     Stop() error
     ProposeVote(voteType VoteType, target string, reason string, evidence []byte) error
+    Propose(voteType VoteType, target string, reason string, evidence []byte) (*Vote, error)
+    VoteState(id string) (*VoteState, bool)
     StartVote(voteType VoteType, target string, proposer peer.ID) error
     UpdatePeerReputation(p peer.ID, delta int) error
 }
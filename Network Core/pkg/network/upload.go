@@ -0,0 +1,231 @@
+package network
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Uploads place every chunk of a file on remote storage nodes before the
+// file's manifest is published, so a manifest never points at chunks that
+// exist only on the uploader. Chunks are expected to be encrypted already
+// (the Divider encrypts them when splitting). Each chunk goes to the best
+// scoring nodes from gossip; a node counts towards the replication goal
+// only once it acknowledges the chunk, and nodes that refuse are replaced
+// by the next candidates. The placement policy spreads a chunk's replicas
+// over the regions and providers the nodes report, preferring lower
+// scoring nodes elsewhere over another replica in the same place.
+const (
+    DefaultUploadWorkers  = 4 // Chunks uploaded concurrently
+    uploadCandidateFactor = 2 // Candidate nodes per replica, to replace refusals
+    placementCandidates   = 8 // Extra candidates considered for their locality
+)
+
+// ErrReplicationGoalUnmet is returned when too few nodes acknowledged a chunk
+var ErrReplicationGoalUnmet = errors.New("replication goal not met")
+
+// ChunkUploader stores a chunk on a peer, returning once the peer has
+// acknowledged it
+type ChunkUploader interface {
+    Upload(to peer.ID, req *StorageRequest) error
+}
+
+// StorageNodeSelector picks storage nodes and learns from their transfers
+type StorageNodeSelector interface {
+    SelectStorageNodes(n int, constraints StorageConstraints) []peer.ID
+    PeerLocality(id peer.ID) Locality
+    RecordSuccess(id peer.ID, responseTime time.Duration)
+    RecordFailure(id peer.ID)
+}
+
+// UploadResult records where each chunk of an upload was placed
+type UploadResult struct {
+    Placements map[string][]peer.ID // map[chunkHash]nodes that acknowledged it
+    Shortfall  map[string]int       // map[chunkHash]replicas missing, for chunks below the goal
+}
+
+// PlacementPolicy limits the replicas of a chunk placed in one region or
+// with one provider. Nodes of unknown locality are not limited.
+type PlacementPolicy struct {
+    MaxPerRegion int  // 0 for all but one of a chunk's replicas
+    MaxPerASN    int  // 0 for all but one of a chunk's replicas
+    Strict       bool // Leave replicas unplaced rather than exceed the limits
+}
+
+// UploadScheduler places chunks on storage nodes
+type UploadScheduler struct {
+    uploader ChunkUploader
+    nodes    StorageNodeSelector
+    owner    peer.ID
+    workers  int
+    policy   PlacementPolicy
+}
+
+// NewUploadScheduler creates a scheduler uploading on behalf of owner.
+// nodes may be nil, in which case no chunk can be placed.
+func NewUploadScheduler(uploader ChunkUploader, nodes StorageNodeSelector, owner peer.ID, workers int) *UploadScheduler {
+    if workers <= 0 {
+        workers = DefaultUploadWorkers
+    }
+    return &UploadScheduler{
+        uploader: uploader,
+        nodes:    nodes,
+        owner:    owner,
+        workers:  workers,
+    }
+}
+
+// SetPlacementPolicy changes how replicas are spread over regions and
+// providers
+func (s *UploadScheduler) SetPlacementPolicy(policy PlacementPolicy) {
+    s.policy = policy
+}
+
+// Place uploads chunks until each is acknowledged by goal nodes. It returns
+// the placements made and wraps ErrReplicationGoalUnmet if any chunk fell
+// short.
+func (s *UploadScheduler) Place(ctx context.Context, goal int, chunks map[string][]byte) (*UploadResult, error) {
+    result := &UploadResult{
+        Placements: make(map[string][]peer.ID),
+        Shortfall:  make(map[string]int),
+    }
+
+    hashes := make(chan string)
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    for i := 0; i < s.workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for hash := range hashes {
+                placed := s.placeChunk(ctx, goal, hash, chunks[hash])
+                mu.Lock()
+                result.Placements[hash] = placed
+                if len(placed) < goal {
+                    result.Shortfall[hash] = goal - len(placed)
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+
+feed:
+    for hash := range chunks {
+        select {
+        case hashes <- hash:
+        case <-ctx.Done():
+            break feed
+        }
+    }
+    close(hashes)
+    wg.Wait()
+
+    if err := ctx.Err(); err != nil {
+        return result, fmt.Errorf("upload cancelled: %w", err)
+    }
+    if len(result.Shortfall) > 0 {
+        short := make([]string, 0, len(result.Shortfall))
+        for hash := range result.Shortfall {
+            short = append(short, hash)
+        }
+        sort.Strings(short)
+        return result, fmt.Errorf("%w: %d of %d chunks, e.g. %s on %d of %d nodes", ErrReplicationGoalUnmet,
+            len(short), len(chunks), short[0], len(result.Placements[short[0]]), goal)
+    }
+    return result, nil
+}
+
+// placeChunk uploads a chunk to candidates until goal of them acknowledge
+// it and returns the nodes that did. Candidates that would exceed the
+// placement limits are tried last, unless the policy is strict.
+func (s *UploadScheduler) placeChunk(ctx context.Context, goal int, hash string, data []byte) []peer.ID {
+    if s.nodes == nil || goal <= 0 {
+        return nil
+    }
+
+    candidates := s.nodes.SelectStorageNodes(goal*uploadCandidateFactor+placementCandidates, StorageConstraints{
+        MinFreeSpace: int64(len(data)),
+        ChunkSize:    int64(len(data)),
+        Exclude:      []peer.ID{s.owner},
+    })
+
+    var placed, deferred []peer.ID
+    regions := make(map[string]int)
+    asns := make(map[uint32]int)
+    for _, node := range candidates {
+        if len(placed) >= goal || ctx.Err() != nil {
+            break
+        }
+        locality := s.nodes.PeerLocality(node)
+        if !s.policy.allows(locality, regions, asns, goal) {
+            deferred = append(deferred, node)
+            continue
+        }
+        if s.upload(node, goal-len(placed), hash, data) {
+            placed = append(placed, node)
+            regions[locality.Region]++
+            asns[locality.ASN]++
+        }
+    }
+
+    if s.policy.Strict {
+        return placed
+    }
+    for _, node := range deferred {
+        if len(placed) >= goal || ctx.Err() != nil {
+            break
+        }
+        if s.upload(node, goal-len(placed), hash, data) {
+            fmt.Printf("placed chunk %s on %s beyond the locality limits\n", hash, node)
+            placed = append(placed, node)
+        }
+    }
+    return placed
+}
+
+// upload sends a chunk to a node, reporting whether the node acknowledged it
+func (s *UploadScheduler) upload(node peer.ID, priority int, hash string, data []byte) bool {
+    req := &StorageRequest{
+        ChunkHash: hash,
+        Data:      data,
+        Size:      int64(len(data)),
+        Owner:     s.owner.String(),
+        Priority:  priority,
+    }
+    start := time.Now()
+    if err := s.uploader.Upload(node, req); err != nil {
+        s.nodes.RecordFailure(node)
+        fmt.Printf("failed to upload chunk %s to %s: %v\n", hash, node, err)
+        return false
+    }
+    s.nodes.RecordSuccess(node, time.Since(start))
+    return true
+}
+
+// allows reports whether another replica may go to a node of the given
+// locality, with regions and asns counting the replicas placed so far
+func (p PlacementPolicy) allows(locality Locality, regions map[string]int, asns map[uint32]int, goal int) bool {
+    if locality.Region != "" && regions[locality.Region] >= placementLimit(p.MaxPerRegion, goal) {
+        return false
+    }
+    if locality.ASN != 0 && asns[locality.ASN] >= placementLimit(p.MaxPerASN, goal) {
+        return false
+    }
+    return true
+}
+
+// placementLimit returns the replicas allowed in one place for a goal
+func placementLimit(max, goal int) int {
+    if max <= 0 {
+        max = goal - 1
+    }
+    if max < 1 {
+        max = 1
+    }
+    return max
+}
@@ -0,0 +1,166 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStorage acknowledges uploads except to refusing nodes and records
+// the scores reported for each node
+type fakeStorage struct {
+	mu        sync.Mutex
+	nodes     []peer.ID
+	refusing  map[peer.ID]bool
+	locality  map[peer.ID]Locality
+	stored    map[peer.ID][]string
+	successes map[peer.ID]int
+	failures  map[peer.ID]int
+}
+
+func newFakeStorage(n int, refusing ...int) *fakeStorage {
+	f := &fakeStorage{
+		refusing:  make(map[peer.ID]bool),
+		locality:  make(map[peer.ID]Locality),
+		stored:    make(map[peer.ID][]string),
+		successes: make(map[peer.ID]int),
+		failures:  make(map[peer.ID]int),
+	}
+	for i := 0; i < n; i++ {
+		f.nodes = append(f.nodes, peer.ID(fmt.Sprintf("node%d", i)))
+	}
+	for _, i := range refusing {
+		f.refusing[f.nodes[i]] = true
+	}
+	return f
+}
+
+func (f *fakeStorage) Upload(to peer.ID, req *StorageRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.refusing[to] {
+		return fmt.Errorf("peer %s declined chunk %s", to, req.ChunkHash)
+	}
+	f.stored[to] = append(f.stored[to], req.ChunkHash)
+	return nil
+}
+
+func (f *fakeStorage) SelectStorageNodes(n int, constraints StorageConstraints) []peer.ID {
+	var selected []peer.ID
+	for _, id := range f.nodes {
+		excluded := false
+		for _, ex := range constraints.Exclude {
+			excluded = excluded || ex == id
+		}
+		if !excluded && len(selected) < n {
+			selected = append(selected, id)
+		}
+	}
+	return selected
+}
+
+func (f *fakeStorage) PeerLocality(id peer.ID) Locality {
+	return f.locality[id]
+}
+
+func (f *fakeStorage) RecordSuccess(id peer.ID, _ time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.successes[id]++
+}
+
+func (f *fakeStorage) RecordFailure(id peer.ID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[id]++
+}
+
+func testChunks(n int) map[string][]byte {
+	chunks := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		chunks[fmt.Sprintf("hash%d", i)] = []byte(fmt.Sprintf("data%d", i))
+	}
+	return chunks
+}
+
+func TestUploadReplacesRefusingNodes(t *testing.T) {
+	storage := newFakeStorage(6, 0)
+	s := NewUploadScheduler(storage, storage, peer.ID("owner"), 2)
+
+	result, err := s.Place(context.Background(), 3, testChunks(5))
+	require.NoError(t, err)
+	assert.Empty(t, result.Shortfall)
+	for hash, nodes := range result.Placements {
+		assert.Len(t, nodes, 3, hash)
+		assert.NotContains(t, nodes, storage.nodes[0])
+	}
+
+	// The refusing node was scored down for every chunk, the others up
+	assert.Equal(t, 5, storage.failures[storage.nodes[0]])
+	assert.Equal(t, 5, storage.successes[storage.nodes[1]])
+	assert.Empty(t, storage.stored[storage.nodes[4]], "more nodes than needed were used")
+}
+
+func TestUploadReportsShortfall(t *testing.T) {
+	storage := newFakeStorage(3, 1)
+	s := NewUploadScheduler(storage, storage, peer.ID("owner"), 0)
+
+	result, err := s.Place(context.Background(), 3, testChunks(2))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrReplicationGoalUnmet))
+	assert.Equal(t, map[string]int{"hash0": 1, "hash1": 1}, result.Shortfall)
+	assert.Len(t, result.Placements["hash0"], 2)
+
+	// Without storage nodes nothing can be placed
+	_, err = NewUploadScheduler(storage, nil, peer.ID("owner"), 0).Place(context.Background(), 1, testChunks(1))
+	assert.True(t, errors.Is(err, ErrReplicationGoalUnmet))
+}
+
+func TestUploadCancelled(t *testing.T) {
+	storage := newFakeStorage(3)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewUploadScheduler(storage, storage, peer.ID("owner"), 1).Place(ctx, 1, testChunks(3))
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestUploadSpreadsReplicas(t *testing.T) {
+	// The best scoring nodes share a region and the next ones a provider
+	storage := newFakeStorage(6)
+	for i := 0; i < 3; i++ {
+		storage.locality[storage.nodes[i]] = Locality{Region: "eu-west", ASN: 100 + uint32(i)}
+	}
+	storage.locality[storage.nodes[3]] = Locality{Region: "us-east", ASN: 200}
+	storage.locality[storage.nodes[4]] = Locality{Region: "ap-south", ASN: 200}
+
+	s := NewUploadScheduler(storage, storage, peer.ID("owner"), 1)
+	result, err := s.Place(context.Background(), 3, testChunks(1))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []peer.ID{storage.nodes[0], storage.nodes[1], storage.nodes[3]}, result.Placements["hash0"])
+
+	// A limit of one per place leaves the provider's second node and the
+	// region's other nodes to the node of unknown locality
+	s.SetPlacementPolicy(PlacementPolicy{MaxPerRegion: 1, MaxPerASN: 1})
+	result, err = s.Place(context.Background(), 3, testChunks(1))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []peer.ID{storage.nodes[0], storage.nodes[3], storage.nodes[5]}, result.Placements["hash0"])
+
+	// Without enough places the limits are exceeded, unless strict
+	s.SetPlacementPolicy(PlacementPolicy{MaxPerRegion: 1})
+	result, err = s.Place(context.Background(), 5, testChunks(1))
+	require.NoError(t, err)
+	assert.Len(t, result.Placements["hash0"], 5)
+
+	s.SetPlacementPolicy(PlacementPolicy{MaxPerRegion: 1, Strict: true})
+	result, err = s.Place(context.Background(), 5, testChunks(1))
+	assert.True(t, errors.Is(err, ErrReplicationGoalUnmet))
+	assert.Len(t, result.Placements["hash0"], 4)
+}
@@ -0,0 +1,233 @@
+package network
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "sort"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// A manifest's owner may name co-owners, who publish updates to it under
+// their own signatures. The owner signs the co-owner list separately, so a
+// co-owner's update carries proof of the grant without the owner's key.
+// Each version carries a version vector counting the updates it includes
+// from every writer; its Sequence is their sum, so a version always
+// follows the ones it builds on. Every version a node sees is kept, up to
+// ManifestHistoryLimit per manifest, for rolling back.
+
+// ManifestHistoryLimit bounds the versions kept of each manifest
+const ManifestHistoryLimit = 32
+
+// VersionVector counts the updates a manifest version includes from each
+// writer
+type VersionVector map[string]uint64
+
+// Includes reports whether v includes every update other does
+func (v VersionVector) Includes(other VersionVector) bool {
+    for writer, n := range other {
+        if v[writer] < n {
+            return false
+        }
+    }
+    return true
+}
+
+// Sum returns the number of updates v includes
+func (v VersionVector) Sum() uint64 {
+    var sum uint64
+    for _, n := range v {
+        sum += n
+    }
+    return sum
+}
+
+// manifestVersions returns a manifest's version vector. Manifests from
+// before version vectors count every update as the owner's.
+func manifestVersions(manifest *ManifestInfo) VersionVector {
+    if len(manifest.Versions) > 0 {
+        return manifest.Versions
+    }
+    if manifest.Sequence == 0 {
+        return VersionVector{}
+    }
+    return VersionVector{manifest.Owner: manifest.Sequence}
+}
+
+// nextVersions returns the version vector of writer's update to current,
+// which may be nil
+func nextVersions(current *ManifestInfo, writer string) VersionVector {
+    next := make(VersionVector)
+    if current != nil {
+        for w, n := range manifestVersions(current) {
+            next[w] = n
+        }
+    }
+    next[writer]++
+    return next
+}
+
+// coOwnerSigningBytes returns the bytes covered by an owner's co-owner grant
+func coOwnerSigningBytes(manifest *ManifestInfo) ([]byte, error) {
+    return json.Marshal(struct {
+        Name     string
+        CoOwners []string
+    }{manifest.Name, manifest.CoOwners})
+}
+
+// signCoOwnerUpdate signs a co-owner's update to current with the
+// co-owner's private key, carrying over the owner's key and grant and the
+// access list, which only the owner may change
+func signCoOwnerUpdate(manifest, current *ManifestInfo, priv crypto.PrivKey) error {
+    if priv == nil {
+        return fmt.Errorf("no private key available for signing")
+    }
+
+    writer, err := peer.IDFromPrivateKey(priv)
+    if err != nil {
+        return fmt.Errorf("failed to derive writer ID: %w", err)
+    }
+    pubBytes, err := crypto.MarshalPublicKey(priv.GetPublic())
+    if err != nil {
+        return fmt.Errorf("failed to marshal public key: %w", err)
+    }
+
+    manifest.Owner = current.Owner
+    manifest.OwnerKey = current.OwnerKey
+    manifest.CoOwners = current.CoOwners
+    manifest.CoOwnerSig = current.CoOwnerSig
+    manifest.ACL = current.ACL
+    manifest.Writer = writer.String()
+    manifest.WriterKey = pubBytes
+    manifest.Signature = nil
+
+    payload, err := manifestSigningBytes(manifest)
+    if err != nil {
+        return fmt.Errorf("failed to encode manifest: %w", err)
+    }
+    sig, err := priv.Sign(payload)
+    if err != nil {
+        return fmt.Errorf("failed to sign manifest: %w", err)
+    }
+    manifest.Signature = sig
+    return nil
+}
+
+// verifyWriter checks that a manifest's Writer is a co-owner granted by the
+// owner's key, and returns the Writer's key
+func verifyWriter(manifest *ManifestInfo, owner crypto.PubKey) (crypto.PubKey, error) {
+    if !containsString(manifest.CoOwners, manifest.Writer) {
+        return nil, fmt.Errorf("%w: %s is not a co-owner of %s", ErrManifestOwner, manifest.Writer, manifest.Name)
+    }
+    grant, err := coOwnerSigningBytes(manifest)
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode co-owners: %w", err)
+    }
+    if ok, err := owner.Verify(grant, manifest.CoOwnerSig); err != nil || !ok {
+        return nil, fmt.Errorf("%w: bad co-owner grant", ErrManifestBadSig)
+    }
+
+    pub, err := crypto.UnmarshalPublicKey(manifest.WriterKey)
+    if err != nil {
+        return nil, fmt.Errorf("%w: bad writer key: %v", ErrManifestBadSig, err)
+    }
+    writer, err := peer.IDFromPublicKey(pub)
+    if err != nil {
+        return nil, fmt.Errorf("%w: bad writer key: %v", ErrManifestBadSig, err)
+    }
+    if writer.String() != manifest.Writer {
+        return nil, fmt.Errorf("%w: key belongs to %s, manifest claims writer %s", ErrManifestOwner, writer, manifest.Writer)
+    }
+    return pub, nil
+}
+
+// record adds a version to its manifest's history, ordered by sequence.
+// The oldest versions are dropped past ManifestHistoryLimit. m.mu must be
+// held.
+func (m *ManifestManager) record(manifest *ManifestInfo) {
+    if m.history == nil {
+        m.history = make(map[string][]*ManifestInfo)
+    }
+    versions := m.history[manifest.Name]
+    for _, version := range versions {
+        if bytes.Equal(version.Signature, manifest.Signature) {
+            return
+        }
+    }
+
+    // Callers may go on to change the manifest they stored
+    version := *manifest
+    version.ChunkHashes = append([]string(nil), manifest.ChunkHashes...)
+    version.ChunkPages = append([]string(nil), manifest.ChunkPages...)
+    versions = append(versions, &version)
+    sort.SliceStable(versions, func(i, j int) bool {
+        return versions[i].Sequence < versions[j].Sequence
+    })
+    if len(versions) > ManifestHistoryLimit {
+        versions = versions[len(versions)-ManifestHistoryLimit:]
+    }
+    m.history[manifest.Name] = versions
+}
+
+// ManifestHistory returns the versions of a manifest this node has seen,
+// oldest first
+func (m *ManifestManager) ManifestHistory(name string) []*ManifestInfo {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return append([]*ManifestInfo(nil), m.history[name]...)
+}
+
+// GetManifestVersion returns version n of a manifest, the version whose
+// Sequence is n. Of concurrent versions numbered n, the one that wins
+// conflicts is returned.
+func (m *ManifestManager) GetManifestVersion(name string, n uint64) (*ManifestInfo, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    var found *ManifestInfo
+    for _, version := range m.history[name] {
+        if version.Sequence == n && (found == nil || manifestSupersedes(version, found)) {
+            found = version
+        }
+    }
+    if found == nil {
+        return nil, fmt.Errorf("%w: %s version %d", ErrManifestVersion, name, n)
+    }
+    return found, nil
+}
+
+// RollbackManifest publishes the content of version n of a manifest as its
+// newest version. This node must own the manifest or be a co-owner.
+func (m *ManifestManager) RollbackManifest(name string, n uint64) error {
+    version, err := m.GetManifestVersion(name, n)
+    if err != nil {
+        return err
+    }
+    m.mu.RLock()
+    current := m.store[name]
+    m.mu.RUnlock()
+    if current == nil {
+        return fmt.Errorf("manifest %s not found", name)
+    }
+
+    rollback := *current
+    rollback.ChunkHashes = append([]string(nil), version.ChunkHashes...)
+    rollback.ChunkCount = version.ChunkCount
+    rollback.ChunkRoot = version.ChunkRoot
+    rollback.ChunkPages = append([]string(nil), version.ChunkPages...)
+    rollback.Size = version.Size
+    rollback.Modified = version.Modified
+    rollback.Signature = nil
+    return m.AddManifest(&rollback)
+}
+
+func containsString(values []string, s string) bool {
+    for _, v := range values {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
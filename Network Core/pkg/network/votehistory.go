@@ -0,0 +1,224 @@
+package network
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+    // voteHistoryProtocol serves recent votes to peers catching up
+    voteHistoryProtocol = "/filezap/quorum/history/1.0.0"
+    // VoteHistoryRetention is how long finished votes are kept and served
+    VoteHistoryRetention = 24 * time.Hour
+    // maxCatchUpPeers bounds how many peers are asked for history
+    maxCatchUpPeers = 3
+    // maxVoteHistorySize bounds a history response
+    maxVoteHistorySize = 16 * 1024 * 1024
+)
+
+// EnablePersistence stores vote state in dir and restores any votes saved by
+// a previous run
+func (qm *QuorumManagerImpl) EnablePersistence(dir string) error {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return fmt.Errorf("failed to create vote directory: %w", err)
+    }
+    path := filepath.Join(dir, "votes.json")
+
+    qm.mu.Lock()
+    defer qm.mu.Unlock()
+    qm.votesPath = path
+
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to read votes: %w", err)
+    }
+
+    var states []*VoteState
+    if err := json.Unmarshal(data, &states); err != nil {
+        return fmt.Errorf("failed to parse votes: %w", err)
+    }
+    for _, state := range states {
+        qm.restoreVoteLocked(state)
+    }
+    return nil
+}
+
+// saveVotesLocked writes retained votes to disk. Callers must hold qm.mu.
+func (qm *QuorumManagerImpl) saveVotesLocked() {
+    if qm.votesPath == "" {
+        return
+    }
+
+    data, err := json.Marshal(qm.historyLocked())
+    if err != nil {
+        return
+    }
+    tmp := qm.votesPath + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        fmt.Printf("failed to save votes: %v\n", err)
+        return
+    }
+    if err := os.Rename(tmp, qm.votesPath); err != nil {
+        fmt.Printf("failed to save votes: %v\n", err)
+    }
+}
+
+// historyLocked returns votes still within the retention window, dropping
+// older ones. Callers must hold qm.mu.
+func (qm *QuorumManagerImpl) historyLocked() []*VoteState {
+    cutoff := time.Now().Add(-VoteHistoryRetention)
+    states := make([]*VoteState, 0, len(qm.activeVotes))
+    for id, state := range qm.activeVotes {
+        if state.Deadline.Before(cutoff) {
+            delete(qm.activeVotes, id)
+            delete(qm.voteResults, id)
+            continue
+        }
+        states = append(states, state)
+    }
+    return states
+}
+
+// restoreVoteLocked merges a vote from disk or a peer into local state after
+// checking every signature. Callers must hold qm.mu.
+func (qm *QuorumManagerImpl) restoreVoteLocked(state *VoteState) bool {
+    if state == nil || state.Vote == nil {
+        return false
+    }
+    if verifyVoteSignature(state.Vote) != nil {
+        return false
+    }
+    if time.Since(state.Deadline) > VoteHistoryRetention {
+        return false
+    }
+
+    local, exists := qm.activeVotes[state.Vote.ID]
+    if !exists {
+        local = &VoteState{
+            Vote:      state.Vote,
+            Responses: make(map[peer.ID]*VoteResponse),
+            Deadline:  state.Vote.Expires,
+        }
+        qm.activeVotes[state.Vote.ID] = local
+    }
+
+    for voter, resp := range state.Responses {
+        if resp == nil || resp.Voter != voter || resp.VoteID != state.Vote.ID {
+            continue
+        }
+        if verifyVoteResponseSignature(resp) != nil {
+            continue
+        }
+        local.Responses[voter] = resp
+    }
+
+    // Outcomes are recomputed from the signed responses rather than
+    // trusted, and a vote too few of them reached stays open
+    if state.Complete && !local.Complete && len(local.Responses) >= qm.rules.MinQuorumSize {
+        total, approval := tallyResponses(local.Responses, qm.rules)
+        if total > 0 {
+            local.Complete = true
+            local.Passed = (approval * 100 / total) >= qm.rules.MinVotingPercentage
+            qm.voteResults[state.Vote.ID] = local.Passed
+        }
+    }
+    return !exists
+}
+
+// handleHistoryStream serves our retained votes to a peer
+func (qm *QuorumManagerImpl) handleHistoryStream(s network.Stream) {
+    defer s.Close()
+
+    qm.mu.Lock()
+    data, err := json.Marshal(qm.historyLocked())
+    qm.mu.Unlock()
+    if err != nil {
+        s.Reset()
+        return
+    }
+
+    s.SetWriteDeadline(time.Now().Add(30 * time.Second))
+    if _, err := s.Write(data); err != nil {
+        s.Reset()
+    }
+}
+
+// CatchUp requests recent votes from connected peers so that a node which
+// was offline or just joined learns outcomes and can vote on open proposals
+func (qm *QuorumManagerImpl) CatchUp(ctx context.Context) error {
+    peers := qm.host.Network().Peers()
+    if len(peers) == 0 {
+        return fmt.Errorf("no peers to catch up from")
+    }
+    if len(peers) > maxCatchUpPeers {
+        peers = peers[:maxCatchUpPeers]
+    }
+
+    var lastErr error
+    fetched := 0
+    for _, p := range peers {
+        states, err := qm.fetchHistory(ctx, p)
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        fetched++
+
+        var open []*Vote
+        qm.mu.Lock()
+        for _, state := range states {
+            if qm.restoreVoteLocked(state) && !qm.activeVotes[state.Vote.ID].Complete && time.Now().Before(state.Vote.Expires) {
+                open = append(open, state.Vote)
+            }
+        }
+        qm.saveVotesLocked()
+        qm.mu.Unlock()
+
+        // Cast our own response on votes that are still open
+        for _, vote := range open {
+            qm.respondToVote(vote)
+        }
+    }
+
+    if fetched == 0 {
+        return fmt.Errorf("failed to fetch vote history: %w", lastErr)
+    }
+    return nil
+}
+
+// fetchHistory downloads the retained votes of a single peer
+func (qm *QuorumManagerImpl) fetchHistory(ctx context.Context, p peer.ID) ([]*VoteState, error) {
+    ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+    defer cancel()
+
+    s, err := qm.host.NewStream(ctx, p, protocol.ID(voteHistoryProtocol))
+    if err != nil {
+        return nil, fmt.Errorf("failed to open history stream: %w", err)
+    }
+    defer s.Close()
+
+    s.SetReadDeadline(time.Now().Add(30 * time.Second))
+    data, err := io.ReadAll(io.LimitReader(s, maxVoteHistorySize))
+    if err != nil {
+        s.Reset()
+        return nil, fmt.Errorf("failed to read history: %w", err)
+    }
+
+    var states []*VoteState
+    if err := json.Unmarshal(data, &states); err != nil {
+        return nil, fmt.Errorf("failed to parse history: %w", err)
+    }
+    return states, nil
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQuorum(t *testing.T, ctx context.Context, h host.Host) *QuorumManagerImpl {
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	require.NoError(t, err)
+	qm, err := newQuorumManagerImpl(ctx, h, ps, nil, DefaultQuorumConfig())
+	require.NoError(t, err)
+	return qm
+}
+
+// finishedVoters is the number of responses newFinishedVote collects
+const finishedVoters = 3
+
+// newHistoryQuorum creates a quorum manager that a vote from
+// newFinishedVote reaches quorum on
+func newHistoryQuorum(t *testing.T, ctx context.Context, h host.Host) *QuorumManagerImpl {
+	qm := newTestQuorum(t, ctx, h)
+	qm.rules.MinQuorumSize = finishedVoters
+	return qm
+}
+
+// newFinishedVote builds a completed vote signed by a proposer and voters
+func newFinishedVote(t *testing.T, approvals int) *VoteState {
+	priv, proposer := newTestKey(t)
+	vote := &Vote{ID: "vote-" + proposer.String(), Type: VoteRemovePeer, Target: "bad-peer", Proposer: proposer}
+	require.NoError(t, SignVote(vote, priv, VotingTimeout))
+
+	state := &VoteState{
+		Vote:      vote,
+		Responses: make(map[peer.ID]*VoteResponse),
+		Deadline:  vote.Expires,
+		Complete:  true,
+		Passed:    true,
+	}
+	for i := 0; i < finishedVoters; i++ {
+		vpriv, voter := newTestKey(t)
+		resp := &VoteResponse{VoteID: vote.ID, Voter: voter, Approve: i < approvals}
+		require.NoError(t, SignVoteResponse(resp, vpriv, VotingTimeout))
+		state.Responses[voter] = resp
+	}
+	return state
+}
+
+func TestVotePersistence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	qm1 := newHistoryQuorum(t, ctx, h1)
+	require.NoError(t, qm1.EnablePersistence(dir))
+
+	state := newFinishedVote(t, 3)
+	qm1.mu.Lock()
+	qm1.restoreVoteLocked(state)
+	qm1.saveVotesLocked()
+	qm1.mu.Unlock()
+
+	// A restarted node reloads the vote and its outcome
+	qm2 := newHistoryQuorum(t, ctx, h2)
+	require.NoError(t, qm2.EnablePersistence(dir))
+
+	qm2.mu.RLock()
+	defer qm2.mu.RUnlock()
+	restored, ok := qm2.activeVotes[state.Vote.ID]
+	require.True(t, ok)
+	assert.True(t, restored.Complete)
+	assert.True(t, restored.Passed)
+	assert.Len(t, restored.Responses, 3)
+}
+
+func TestRestoredVoteNeedsQuorum(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+	qm := newHistoryQuorum(t, ctx, h1)
+
+	// A vote claimed complete with fewer responses than a quorum stays open
+	state := newFinishedVote(t, 3)
+	var held *VoteResponse
+	for voter, resp := range state.Responses {
+		held = resp
+		delete(state.Responses, voter)
+		break
+	}
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	require.True(t, qm.restoreVoteLocked(state))
+	restored := qm.activeVotes[state.Vote.ID]
+	assert.False(t, restored.Complete)
+	assert.NotContains(t, qm.voteResults, state.Vote.ID)
+	assert.Len(t, restored.Responses, 2)
+
+	// Until a later copy brings the missing response
+	state.Responses[held.Voter] = held
+	assert.False(t, qm.restoreVoteLocked(state))
+	assert.True(t, restored.Complete)
+	assert.True(t, qm.voteResults[state.Vote.ID])
+}
+
+func TestVoteCatchUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+
+	qm1 := newHistoryQuorum(t, ctx, h1)
+	qm2 := newHistoryQuorum(t, ctx, h2)
+
+	passed := newFinishedVote(t, 3)
+	rejected := newFinishedVote(t, 1)
+
+	// A forged response must be dropped by the catching-up node
+	_, forger := newTestKey(t)
+	for _, resp := range rejected.Responses {
+		if !resp.Approve {
+			forged := *resp
+			forged.Approve = true
+			forged.Voter = forger
+			rejected.Responses[forger] = &forged
+			break
+		}
+	}
+	// And the claimed outcome is not trusted
+	rejected.Passed = true
+
+	qm1.mu.Lock()
+	qm1.activeVotes[passed.Vote.ID] = passed
+	qm1.activeVotes[rejected.Vote.ID] = rejected
+	qm1.mu.Unlock()
+
+	catchCtx, catchCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer catchCancel()
+	require.NoError(t, qm2.CatchUp(catchCtx))
+
+	qm2.mu.RLock()
+	defer qm2.mu.RUnlock()
+	require.Contains(t, qm2.activeVotes, passed.Vote.ID)
+	require.Contains(t, qm2.activeVotes, rejected.Vote.ID)
+	assert.True(t, qm2.voteResults[passed.Vote.ID])
+	assert.False(t, qm2.voteResults[rejected.Vote.ID])
+	assert.NotContains(t, qm2.activeVotes[rejected.Vote.ID].Responses, forger)
+}
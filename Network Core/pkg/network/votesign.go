@@ -0,0 +1,181 @@
+package network
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newVoteNonce returns a random nonce for a vote message
+func newVoteNonce() (string, error) {
+    buf := make([]byte, 16)
+    if _, err := rand.Read(buf); err != nil {
+        return "", fmt.Errorf("failed to generate nonce: %w", err)
+    }
+    return hex.EncodeToString(buf), nil
+}
+
+// SignVote stamps a vote with a nonce and an expiry ttl from now and signs it
+// with priv
+func SignVote(vote *Vote, priv crypto.PrivKey, ttl time.Duration) error {
+    nonce, err := newVoteNonce()
+    if err != nil {
+        return err
+    }
+    vote.Nonce = nonce
+    vote.Expires = time.Now().Add(ttl)
+
+    vote.PublicKey, err = crypto.MarshalPublicKey(priv.GetPublic())
+    if err != nil {
+        return fmt.Errorf("failed to marshal public key: %w", err)
+    }
+    vote.Signature = nil
+    data, err := json.Marshal(vote)
+    if err != nil {
+        return fmt.Errorf("failed to marshal vote: %w", err)
+    }
+    if vote.Signature, err = priv.Sign(data); err != nil {
+        return fmt.Errorf("failed to sign vote: %w", err)
+    }
+    return nil
+}
+
+// VerifyVote checks that a vote is signed by its proposer and not expired
+func VerifyVote(vote *Vote) error {
+    if err := checkVoteExpiry(vote.Expires); err != nil {
+        return err
+    }
+    return verifyVoteSignature(vote)
+}
+
+// verifyVoteSignature checks only the proposer signature, so that votes from
+// history can be verified after they have expired
+func verifyVoteSignature(vote *Vote) error {
+    unsigned := *vote
+    unsigned.Signature = nil
+    data, err := json.Marshal(&unsigned)
+    if err != nil {
+        return fmt.Errorf("failed to marshal vote: %w", err)
+    }
+    return verifyVoteMessage(vote.Proposer, vote.PublicKey, vote.Signature, data)
+}
+
+// SignVoteResponse stamps a vote response with a nonce and an expiry ttl from
+// now and signs it with priv
+func SignVoteResponse(resp *VoteResponse, priv crypto.PrivKey, ttl time.Duration) error {
+    nonce, err := newVoteNonce()
+    if err != nil {
+        return err
+    }
+    resp.Nonce = nonce
+    resp.Expires = time.Now().Add(ttl)
+
+    resp.PublicKey, err = crypto.MarshalPublicKey(priv.GetPublic())
+    if err != nil {
+        return fmt.Errorf("failed to marshal public key: %w", err)
+    }
+    resp.Signature = nil
+    data, err := json.Marshal(resp)
+    if err != nil {
+        return fmt.Errorf("failed to marshal vote response: %w", err)
+    }
+    if resp.Signature, err = priv.Sign(data); err != nil {
+        return fmt.Errorf("failed to sign vote response: %w", err)
+    }
+    return nil
+}
+
+// VerifyVoteResponse checks that a response is signed by its voter and not
+// expired
+func VerifyVoteResponse(resp *VoteResponse) error {
+    if err := checkVoteExpiry(resp.Expires); err != nil {
+        return err
+    }
+    return verifyVoteResponseSignature(resp)
+}
+
+// verifyVoteResponseSignature checks only the voter signature
+func verifyVoteResponseSignature(resp *VoteResponse) error {
+    unsigned := *resp
+    unsigned.Signature = nil
+    data, err := json.Marshal(&unsigned)
+    if err != nil {
+        return fmt.Errorf("failed to marshal vote response: %w", err)
+    }
+    return verifyVoteMessage(resp.Voter, resp.PublicKey, resp.Signature, data)
+}
+
+// checkVoteExpiry rejects expired messages and far-future expiries, which
+// would otherwise keep nonces in the replay cache indefinitely
+func checkVoteExpiry(expires time.Time) error {
+    now := time.Now()
+    if now.After(expires) {
+        return ErrVoteExpired
+    }
+    if expires.After(now.Add(MaxVotingTimeout + VoteClockSkew)) {
+        return fmt.Errorf("%w: expiry too far in the future", ErrVoteExpired)
+    }
+    return nil
+}
+
+// verifyVoteMessage checks the author key and signature shared by votes and
+// vote responses
+func verifyVoteMessage(author peer.ID, pubKeyBytes, sig []byte, data []byte) error {
+    if len(sig) == 0 || len(pubKeyBytes) == 0 {
+        return ErrVoteUnsigned
+    }
+
+    pubKey, err := crypto.UnmarshalPublicKey(pubKeyBytes)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrVoteBadSig, err)
+    }
+    if !author.MatchesPublicKey(pubKey) {
+        return fmt.Errorf("%w: key does not match %s", ErrVoteBadSig, author)
+    }
+    ok, err := pubKey.Verify(data, sig)
+    if err != nil || !ok {
+        return ErrVoteBadSig
+    }
+    return nil
+}
+
+// replayGuard remembers nonces until their messages expire
+type replayGuard struct {
+    seen map[string]time.Time
+    mu   sync.Mutex
+}
+
+// newReplayGuard creates an empty replay guard
+func newReplayGuard() *replayGuard {
+    return &replayGuard{seen: make(map[string]time.Time)}
+}
+
+// check records a nonce from author and fails if it was already used
+func (g *replayGuard) check(author peer.ID, nonce string, expires time.Time) error {
+    if nonce == "" {
+        return ErrVoteUnsigned
+    }
+
+    g.mu.Lock()
+    defer g.mu.Unlock()
+
+    now := time.Now()
+    for key, exp := range g.seen {
+        if now.After(exp) {
+            delete(g.seen, key)
+        }
+    }
+
+    key := author.String() + "/" + nonce
+    if _, ok := g.seen[key]; ok {
+        return ErrVoteReplay
+    }
+    g.seen[key] = expires
+    return nil
+}
@@ -0,0 +1,144 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoteSignatures(t *testing.T) {
+	priv, id := newTestKey(t)
+	_, other := newTestKey(t)
+
+	vote := &Vote{ID: "v1", Type: VoteRemovePeer, Target: "target", Proposer: id, Timestamp: time.Now()}
+	require.NoError(t, SignVote(vote, priv, VotingTimeout))
+	assert.NotEmpty(t, vote.Nonce)
+	assert.NoError(t, VerifyVote(vote))
+
+	// Tampered fields break the signature
+	tampered := *vote
+	tampered.Target = "someone-else"
+	assert.ErrorIs(t, VerifyVote(&tampered), ErrVoteBadSig)
+
+	// Claiming another proposer fails the key check
+	forged := *vote
+	forged.Proposer = other
+	assert.ErrorIs(t, VerifyVote(&forged), ErrVoteBadSig)
+
+	unsigned := *vote
+	unsigned.Signature = nil
+	assert.ErrorIs(t, VerifyVote(&unsigned), ErrVoteUnsigned)
+
+	resp := &VoteResponse{VoteID: vote.ID, Voter: id, Approve: true, Timestamp: time.Now()}
+	require.NoError(t, SignVoteResponse(resp, priv, VotingTimeout))
+	assert.NoError(t, VerifyVoteResponse(resp))
+
+	flipped := *resp
+	flipped.Approve = false
+	assert.ErrorIs(t, VerifyVoteResponse(&flipped), ErrVoteBadSig)
+
+	forgedResp := *resp
+	forgedResp.Voter = other
+	assert.ErrorIs(t, VerifyVoteResponse(&forgedResp), ErrVoteBadSig)
+}
+
+func TestVoteExpiry(t *testing.T) {
+	priv, id := newTestKey(t)
+
+	resp := &VoteResponse{VoteID: "v1", Voter: id, Approve: true}
+	require.NoError(t, SignVoteResponse(resp, priv, VotingTimeout))
+
+	// Re-sign with an expiry in the past
+	resp.Expires = time.Now().Add(-time.Second)
+	resp.Signature = nil
+	data, err := json.Marshal(resp)
+	require.NoError(t, err)
+	resp.Signature, err = priv.Sign(data)
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyVoteResponse(resp), ErrVoteExpired)
+}
+
+func TestReplayGuard(t *testing.T) {
+	_, id := newTestKey(t)
+	g := newReplayGuard()
+	exp := time.Now().Add(time.Minute)
+
+	assert.NoError(t, g.check(id, "n1", exp))
+	assert.ErrorIs(t, g.check(id, "n1", exp), ErrVoteReplay)
+	assert.NoError(t, g.check(id, "n2", exp))
+	assert.ErrorIs(t, g.check(id, "", exp), ErrVoteUnsigned)
+
+	// Expired entries are pruned
+	assert.NoError(t, g.check(id, "old", time.Now().Add(-time.Second)))
+	assert.NoError(t, g.check(id, "n3", exp))
+	assert.NotContains(t, g.seen, id.String()+"/old")
+}
+
+func TestQuorumRuleUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+	qm := newTestQuorum(t, ctx, h1)
+	assert.Equal(t, DefaultQuorumConfig(), qm.Rules())
+
+	rules := DefaultQuorumConfig()
+	rules.MinQuorumSize = 3
+	rules.VotingTimeout = time.Minute
+
+	evidence, err := EncodeEvidence(&RuleUpdateEvidence{Rules: rules})
+	require.NoError(t, err)
+	vote := &Vote{ID: "rules-1", Type: VoteUpdateRules, Target: ruleUpdateTarget, Evidence: evidence}
+	assert.True(t, qm.validateRuleUpdate(vote))
+
+	qm.applyRuleUpdate(vote)
+	assert.Equal(t, rules, qm.Rules())
+
+	// Rules outside the allowed bounds are refused
+	bad := rules
+	bad.MinVotingPercentage = 40
+	assert.ErrorIs(t, qm.ProposeRuleUpdate(bad), ErrInvalidQuorumConfig)
+	vote.Evidence, err = EncodeEvidence(&RuleUpdateEvidence{Rules: bad})
+	require.NoError(t, err)
+	assert.False(t, qm.validateRuleUpdate(vote))
+	qm.applyRuleUpdate(vote)
+	assert.Equal(t, rules, qm.Rules())
+}
+
+func TestVoteResponseCounted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := setupTestHosts(t)
+	defer h1.Close()
+	defer h2.Close()
+	qm := newTestQuorum(t, ctx, h1)
+	qm.gossipMgr = &GossipManagerImpl{} // No peers, so any response reaches quorum
+
+	vote := &Vote{ID: "vote-1", Type: VoteRemovePeer, Target: "bad-peer"}
+	qm.mu.Lock()
+	qm.activeVotes[vote.ID] = &VoteState{Vote: vote, Responses: make(map[peer.ID]*VoteResponse)}
+	qm.mu.Unlock()
+
+	// The response completing a vote is counted in its outcome
+	qm.processVoteResponse(&VoteResponse{VoteID: vote.ID, Voter: h2.ID(), Approve: true})
+	state, ok := qm.VoteState(vote.ID)
+	require.True(t, ok)
+	assert.True(t, state.Complete)
+	assert.True(t, state.Passed)
+	assert.Len(t, state.Responses, 1)
+
+	select {
+	case banned := <-qm.PeerBanned():
+		assert.Equal(t, vote.ID, banned.ID)
+	case <-time.After(time.Second):
+		t.Fatal("completed vote was not signalled")
+	}
+}
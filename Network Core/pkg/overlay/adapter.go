@@ -4,13 +4,16 @@ import (
     "context"
     "encoding/json"
     "fmt"
+    "net/url"
+    "strings"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
 )
 
 // NetworkAdapter wraps the overlay network for use by other components
 type NetworkAdapter struct {
-    node    *Node
-    ctx     context.Context
-    msgChan chan *Message
+    node *Node
+    ctx  context.Context
 }
 
 // MessageType constants
@@ -24,6 +27,7 @@ type Request struct {
     Method  string          `json:"method"`
     Path    string          `json:"path"`
     Body    json.RawMessage `json:"body"`
+    PeerID  string          `json:"-"` // Authenticated sender, set by the server
     pattern string          // internal field for routing
 }
 
@@ -33,21 +37,42 @@ type Response struct {
     Body       json.RawMessage `json:"body"`
 }
 
+// QueryParam returns a query parameter of the request path, e.g. limit in
+// /file/list?limit=10
+func (r *Request) QueryParam(name string) string {
+    i := strings.IndexByte(r.Path, '?')
+    if i < 0 {
+        return ""
+    }
+    values, _ := url.ParseQuery(r.Path[i+1:])
+    return values.Get(name)
+}
+
 // NewNetworkAdapter creates a new network adapter
 func NewNetworkAdapter(ctx context.Context) (*NetworkAdapter, error) {
-    node, err := NewNode(ctx)
+    id, err := identity.Generate()
+    if err != nil {
+        return nil, err
+    }
+    return NewNetworkAdapterWithIdentity(ctx, id)
+}
+
+// NewNetworkAdapterWithIdentity creates a new network adapter whose node keeps
+// the peer ID of a persistent identity
+func NewNetworkAdapterWithIdentity(ctx context.Context, id *identity.Identity) (*NetworkAdapter, error) {
+    node, err := NewNodeWithIdentity(ctx, DefaultTransportConfig(), id)
     if err != nil {
         return nil, fmt.Errorf("failed to create overlay node: %v", err)
     }
 
     adapter := &NetworkAdapter{
-        node:    node,
-        ctx:     ctx,
-        msgChan: make(chan *Message, 100),
+        node: node,
+        ctx:  ctx,
     }
 
-    // Set up message handler
+    // Set up message handlers
     node.SetMessageHandler(adapter)
+    node.SetRequestHandler(adapter)
 
     return adapter, nil
 }
@@ -71,66 +96,70 @@ func (a *NetworkAdapter) SendRequest(peerID string, method string, path string,
         return nil, fmt.Errorf("failed to marshal request: %v", err)
     }
 
-    // Send message and wait for response
-    if err := a.node.SendMessage(peerID, MsgTypeValidatorRequest, reqData); err != nil {
-        return nil, fmt.Errorf("failed to send message: %v", err)
+    // Send request and wait for its response
+    msg, err := a.node.Request(a.ctx, peerID, MsgTypeValidatorRequest, reqData)
+    if err != nil {
+        return nil, fmt.Errorf("failed to send request: %v", err)
+    }
+    if msg.Type != MsgTypeValidatorResponse {
+        return nil, fmt.Errorf("unexpected message type: %s", msg.Type)
     }
 
-    // Wait for response
-    select {
-    case msg := <-a.msgChan:
-        if msg.Type != MsgTypeValidatorResponse {
-            return nil, fmt.Errorf("unexpected message type: %s", msg.Type)
-        }
+    var resp Response
+    if err := json.Unmarshal(msg.Payload, &resp); err != nil {
+        return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+    }
 
-        var resp Response
-        if err := json.Unmarshal(msg.Payload, &resp); err != nil {
-            return nil, fmt.Errorf("failed to unmarshal response: %v", err)
-        }
+    return &resp, nil
+}
 
-        return &resp, nil
+// HandleMessage implements MessageHandler. Responses arrive through
+// Request, so only requests sent as plain messages are handled here.
+func (a *NetworkAdapter) HandleMessage(msg *Message) error {
+    if msg.Type != MsgTypeValidatorRequest {
+        return nil
+    }
 
-    case <-a.ctx.Done():
-        return nil, fmt.Errorf("context cancelled")
+    resp, err := a.HandleRequest(msg)
+    if err != nil {
+        return err
+    }
+
+    // Send response
+    if err := a.node.SendMessage(msg.FromID, resp.Type, resp.Payload); err != nil {
+        return fmt.Errorf("failed to send response: %v", err)
     }
+
+    return nil
 }
 
-// HandleMessage implements MessageHandler
-func (a *NetworkAdapter) HandleMessage(msg *Message) error {
-    // For requests, process and send response
-    if msg.Type == MsgTypeValidatorRequest {
-        var req Request
-        if err := json.Unmarshal(msg.Payload, &req); err != nil {
-            return fmt.Errorf("failed to unmarshal request: %v", err)
-        }
-
-        // Process request (to be implemented by validator server)
-        resp := &Response{
-            StatusCode: 200,
-            Body:       []byte(`{"status":"ok"}`),
-        }
-
-        respData, err := json.Marshal(resp)
-        if err != nil {
-            return fmt.Errorf("failed to marshal response: %v", err)
-        }
-
-        // Send response
-        if err := a.node.SendMessage(msg.FromID, MsgTypeValidatorResponse, respData); err != nil {
-            return fmt.Errorf("failed to send response: %v", err)
-        }
+// HandleRequest implements RequestHandler
+func (a *NetworkAdapter) HandleRequest(msg *Message) (*Message, error) {
+    if msg.Type != MsgTypeValidatorRequest {
+        return nil, fmt.Errorf("unexpected message type: %s", msg.Type)
+    }
 
-        return nil
+    var req Request
+    if err := json.Unmarshal(msg.Payload, &req); err != nil {
+        return nil, fmt.Errorf("failed to unmarshal request: %v", err)
     }
 
-    // For responses, forward to channel
-    a.msgChan <- msg
-    return nil
+    // Process request (to be implemented by validator server)
+    resp := &Response{
+        StatusCode: 200,
+        Body:       []byte(`{"status":"ok"}`),
+    }
+
+    respData, err := json.Marshal(resp)
+    if err != nil {
+        return nil, fmt.Errorf("failed to marshal response: %v", err)
+    }
+
+    return &Message{Type: MsgTypeValidatorResponse, Payload: respData}, nil
 }
 
 // Close closes the network adapter
 func (a *NetworkAdapter) Close() error {
-    close(a.msgChan)
     return a.node.Close()
 }
 
@@ -0,0 +1,261 @@
+package overlay
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/ecdh"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+    // ErrUnsigned is returned for messages that carry no signature
+    ErrUnsigned = errors.New("message is not signed")
+    // ErrInvalidSignature is returned when a signature does not verify
+    ErrInvalidSignature = errors.New("invalid message signature")
+    // ErrSenderMismatch is returned when FromID does not belong to the
+    // signing key or the peer that delivered the message
+    ErrSenderMismatch = errors.New("message sender does not match its key")
+    // ErrUnsupportedKey is returned when a key cannot be used for encryption
+    ErrUnsupportedKey = errors.New("key does not support encryption")
+)
+
+// nodeIDFromPeer returns the overlay node ID of a libp2p peer
+func nodeIDFromPeer(id peer.ID) string {
+    return hex.EncodeToString([]byte(id))
+}
+
+// peerFromNodeID returns the libp2p peer behind an overlay node ID
+func peerFromNodeID(nodeID string) (peer.ID, error) {
+    raw, err := hex.DecodeString(nodeID)
+    if err != nil {
+        return "", fmt.Errorf("invalid node ID %q: %v", nodeID, err)
+    }
+    return peer.IDFromBytes(raw)
+}
+
+// signingBytes returns the bytes a message signature covers: the message
+// encoded without its signature
+func signingBytes(msg *Message) ([]byte, error) {
+    unsigned := *msg
+    unsigned.Signature = nil
+    return json.Marshal(&unsigned)
+}
+
+// SignMessage signs msg with the sender's libp2p key, setting FromID to the
+// node ID the key belongs to
+func SignMessage(msg *Message, priv crypto.PrivKey) error {
+    id, err := peer.IDFromPrivateKey(priv)
+    if err != nil {
+        return fmt.Errorf("failed to derive sender ID: %v", err)
+    }
+    pub, err := crypto.MarshalPublicKey(priv.GetPublic())
+    if err != nil {
+        return fmt.Errorf("failed to marshal public key: %v", err)
+    }
+
+    msg.FromID = nodeIDFromPeer(id)
+    msg.PublicKey = pub
+    msg.Signature = nil
+
+    data, err := signingBytes(msg)
+    if err != nil {
+        return fmt.Errorf("failed to marshal message: %v", err)
+    }
+    sig, err := priv.Sign(data)
+    if err != nil {
+        return fmt.Errorf("failed to sign message: %v", err)
+    }
+    msg.Signature = sig
+    return nil
+}
+
+// VerifyMessage checks that msg is signed by the key it carries and that
+// the key belongs to FromID
+func VerifyMessage(msg *Message) error {
+    if len(msg.Signature) == 0 {
+        return ErrUnsigned
+    }
+
+    pub, err := crypto.UnmarshalPublicKey(msg.PublicKey)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+    }
+    id, err := peer.IDFromPublicKey(pub)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+    }
+    if nodeIDFromPeer(id) != msg.FromID {
+        return ErrSenderMismatch
+    }
+
+    data, err := signingBytes(msg)
+    if err != nil {
+        return fmt.Errorf("failed to marshal message: %v", err)
+    }
+    ok, err := pub.Verify(data, msg.Signature)
+    if err != nil || !ok {
+        return ErrInvalidSignature
+    }
+    return nil
+}
+
+// EncryptMessage encrypts the payload of msg to the public key of the node
+// in ToID. An ephemeral X25519 key is agreed with the recipient's Ed25519
+// identity key and the payload is sealed with AES-GCM. Encrypt before
+// signing so the signature covers the ciphertext.
+func EncryptMessage(msg *Message) error {
+    recipient, err := peerFromNodeID(msg.ToID)
+    if err != nil {
+        return err
+    }
+    pub, err := recipient.ExtractPublicKey()
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+    }
+    remote, err := x25519Public(pub)
+    if err != nil {
+        return err
+    }
+
+    ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+    if err != nil {
+        return fmt.Errorf("failed to generate ephemeral key: %v", err)
+    }
+    shared, err := ephemeral.ECDH(remote)
+    if err != nil {
+        return fmt.Errorf("failed to agree key: %v", err)
+    }
+
+    aead, err := payloadCipher(shared, ephemeral.PublicKey().Bytes(), remote.Bytes())
+    if err != nil {
+        return err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return fmt.Errorf("failed to generate nonce: %v", err)
+    }
+
+    msg.Payload = aead.Seal(nil, nonce, msg.Payload, nil)
+    msg.EphemeralKey = ephemeral.PublicKey().Bytes()
+    msg.Nonce = nonce
+    msg.Encrypted = true
+    return nil
+}
+
+// DecryptMessage decrypts the payload of an encrypted message with the
+// recipient's libp2p key. Messages that are not encrypted are left as is.
+func DecryptMessage(msg *Message, priv crypto.PrivKey) error {
+    if !msg.Encrypted {
+        return nil
+    }
+
+    local, err := x25519Private(priv)
+    if err != nil {
+        return err
+    }
+    ephemeral, err := ecdh.X25519().NewPublicKey(msg.EphemeralKey)
+    if err != nil {
+        return fmt.Errorf("invalid ephemeral key: %v", err)
+    }
+    shared, err := local.ECDH(ephemeral)
+    if err != nil {
+        return fmt.Errorf("failed to agree key: %v", err)
+    }
+
+    aead, err := payloadCipher(shared, msg.EphemeralKey, local.PublicKey().Bytes())
+    if err != nil {
+        return err
+    }
+    if len(msg.Nonce) != aead.NonceSize() {
+        return fmt.Errorf("invalid nonce length %d", len(msg.Nonce))
+    }
+    payload, err := aead.Open(nil, msg.Nonce, msg.Payload, nil)
+    if err != nil {
+        return fmt.Errorf("failed to decrypt payload: %v", err)
+    }
+
+    msg.Payload = payload
+    msg.EphemeralKey = nil
+    msg.Nonce = nil
+    msg.Encrypted = false
+    return nil
+}
+
+// payloadCipher derives the AES-GCM cipher for a shared secret, binding it
+// to both public keys of the exchange
+func payloadCipher(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+    h := sha256.New()
+    h.Write(shared)
+    h.Write(ephemeral)
+    h.Write(recipient)
+
+    block, err := aes.NewCipher(h.Sum(nil))
+    if err != nil {
+        return nil, fmt.Errorf("failed to create cipher: %v", err)
+    }
+    return cipher.NewGCM(block)
+}
+
+// fieldPrime is 2^255 - 19, the prime of the field Curve25519 is defined over
+var fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// x25519Public converts an Ed25519 public key to the X25519 key of the same
+// identity using the birational map u = (1 + y) / (1 - y)
+func x25519Public(pub crypto.PubKey) (*ecdh.PublicKey, error) {
+    if pub.Type() != crypto.Ed25519 {
+        return nil, ErrUnsupportedKey
+    }
+    raw, err := pub.Raw()
+    if err != nil || len(raw) != 32 {
+        return nil, ErrUnsupportedKey
+    }
+
+    // The key is y in little-endian with the sign of x in the top bit
+    be := make([]byte, 32)
+    for i, b := range raw {
+        be[31-i] = b
+    }
+    be[0] &= 0x7f
+    y := new(big.Int).SetBytes(be)
+
+    den := new(big.Int).Sub(big.NewInt(1), y)
+    den.Mod(den, fieldPrime)
+    if den.Sign() == 0 {
+        return nil, ErrUnsupportedKey
+    }
+    u := new(big.Int).Add(big.NewInt(1), y)
+    u.Mul(u, den.ModInverse(den, fieldPrime))
+    u.Mod(u, fieldPrime)
+
+    out := make([]byte, 32)
+    u.FillBytes(out)
+    for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+        out[i], out[j] = out[j], out[i]
+    }
+    return ecdh.X25519().NewPublicKey(out)
+}
+
+// x25519Private converts an Ed25519 private key to its X25519 scalar, the
+// first half of the SHA-512 of the seed
+func x25519Private(priv crypto.PrivKey) (*ecdh.PrivateKey, error) {
+    if priv.Type() != crypto.Ed25519 {
+        return nil, ErrUnsupportedKey
+    }
+    raw, err := priv.Raw()
+    if err != nil || len(raw) < 32 {
+        return nil, ErrUnsupportedKey
+    }
+
+    digest := sha512.Sum512(raw[:32])
+    return ecdh.X25519().NewPrivateKey(digest[:32])
+}
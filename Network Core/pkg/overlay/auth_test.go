@@ -0,0 +1,165 @@
+package overlay
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestKey(t *testing.T) (crypto.PrivKey, string) {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateEd25519Key() error = %v", err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("IDFromPrivateKey() error = %v", err)
+	}
+	return priv, nodeIDFromPeer(id)
+}
+
+func TestSignVerifyMessage(t *testing.T) {
+	priv, nodeID := newTestKey(t)
+	_, otherID := newTestKey(t)
+
+	msg := &Message{ToID: otherID, Type: "test", Payload: []byte("payload")}
+	if err := SignMessage(msg, priv); err != nil {
+		t.Fatalf("SignMessage() error = %v", err)
+	}
+	if msg.FromID != nodeID {
+		t.Errorf("FromID = %s, want %s", msg.FromID, nodeID)
+	}
+	if err := VerifyMessage(msg); err != nil {
+		t.Errorf("VerifyMessage() error = %v", err)
+	}
+
+	tampered := *msg
+	tampered.Payload = []byte("changed")
+	if err := VerifyMessage(&tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("VerifyMessage() on tampered payload error = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	spoofed := *msg
+	spoofed.FromID = otherID
+	if err := VerifyMessage(&spoofed); !errors.Is(err, ErrSenderMismatch) {
+		t.Errorf("VerifyMessage() on spoofed sender error = %v, want %v", err, ErrSenderMismatch)
+	}
+
+	if err := VerifyMessage(&Message{FromID: nodeID}); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("VerifyMessage() on unsigned message error = %v, want %v", err, ErrUnsigned)
+	}
+}
+
+func TestReadMessageRejectsTampered(t *testing.T) {
+	priv, _ := newTestKey(t)
+	_, toID := newTestKey(t)
+
+	msg := &Message{ToID: toID, Type: "test", Payload: []byte("payload")}
+	if err := SignMessage(msg, priv); err != nil {
+		t.Fatalf("SignMessage() error = %v", err)
+	}
+	msg.Type = "other"
+
+	stream := newMockStream()
+	if err := WriteMessage(stream, msg); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	stream.readBuf.Write(stream.writeBuf.Bytes())
+
+	if _, err := ReadMessage(stream); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("ReadMessage() error = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestEncryptDecryptMessage(t *testing.T) {
+	senderKey, _ := newTestKey(t)
+	recipientKey, recipientID := newTestKey(t)
+	otherKey, _ := newTestKey(t)
+
+	payload := []byte("secret payload")
+	msg := &Message{ToID: recipientID, Type: "test", Payload: append([]byte(nil), payload...)}
+	if err := EncryptMessage(msg); err != nil {
+		t.Fatalf("EncryptMessage() error = %v", err)
+	}
+	if !msg.Encrypted || bytes.Contains(msg.Payload, payload) {
+		t.Fatal("payload was not encrypted")
+	}
+	if err := SignMessage(msg, senderKey); err != nil {
+		t.Fatalf("SignMessage() error = %v", err)
+	}
+	if err := VerifyMessage(msg); err != nil {
+		t.Fatalf("VerifyMessage() error = %v", err)
+	}
+
+	wrong := *msg
+	if err := DecryptMessage(&wrong, otherKey); err == nil {
+		t.Error("Expected error when decrypting with another node's key")
+	}
+
+	if err := DecryptMessage(msg, recipientKey); err != nil {
+		t.Fatalf("DecryptMessage() error = %v", err)
+	}
+	if !bytes.Equal(msg.Payload, payload) || msg.Encrypted {
+		t.Errorf("DecryptMessage() payload = %q, want %q", msg.Payload, payload)
+	}
+}
+
+func TestIncomingStreamAuthentication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, err := NewNode(ctx)
+	if err != nil {
+		t.Fatalf("NewNode() error = %v", err)
+	}
+	defer node.Close()
+
+	handler := &mockMessageHandler{}
+	node.SetMessageHandler(handler)
+
+	senderKey, senderID := newTestKey(t)
+	sender, _ := peerFromNodeID(senderID)
+	_, otherID := newTestKey(t)
+	other, _ := peerFromNodeID(otherID)
+
+	deliver := func(msg *Message, remote peer.ID) {
+		stream := newMockStream()
+		stream.remote = remote
+		if err := WriteMessage(stream, msg); err != nil {
+			t.Fatalf("WriteMessage() error = %v", err)
+		}
+		stream.readBuf.Write(stream.writeBuf.Bytes())
+		node.handleIncomingStream(stream)
+	}
+
+	// Unsigned and relayed-by-another-peer messages are dropped
+	deliver(&Message{FromID: senderID, ToID: node.nodeID, Type: "test"}, sender)
+
+	signed := &Message{ToID: node.nodeID, Type: "test", Payload: []byte("hello")}
+	if err := SignMessage(signed, senderKey); err != nil {
+		t.Fatalf("SignMessage() error = %v", err)
+	}
+	deliver(signed, other)
+	handler.AssertNotCalled(t, "HandleMessage", mock.Anything)
+
+	// Encrypted messages reach the handler decrypted
+	encrypted := &Message{ToID: node.nodeID, Type: "test", Payload: []byte("hello")}
+	if err := EncryptMessage(encrypted); err != nil {
+		t.Fatalf("EncryptMessage() error = %v", err)
+	}
+	if err := SignMessage(encrypted, senderKey); err != nil {
+		t.Fatalf("SignMessage() error = %v", err)
+	}
+	handler.On("HandleMessage", mock.MatchedBy(func(msg *Message) bool {
+		return msg.FromID == senderID && string(msg.Payload) == "hello" && !msg.Encrypted
+	})).Return(nil).Once()
+	deliver(encrypted, sender)
+	handler.AssertExpectations(t)
+}
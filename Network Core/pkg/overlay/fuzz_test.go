@@ -0,0 +1,41 @@
+package overlay
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// FuzzReadMessage feeds arbitrary frames to ReadMessage, which must reject
+// malformed input with an error rather than panic or allocate whatever
+// length the frame claims
+func FuzzReadMessage(f *testing.F) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		f.Fatalf("GenerateEd25519Key() error = %v", err)
+	}
+	signed := &Message{ToID: "peer", Type: "test", Payload: []byte("payload")}
+	if err := SignMessage(signed, priv); err != nil {
+		f.Fatalf("SignMessage() error = %v", err)
+	}
+	for _, msg := range []*Message{signed, {Type: "unsigned"}} {
+		stream := newMockStream()
+		if err := WriteMessage(stream, msg); err != nil {
+			f.Fatalf("WriteMessage() error = %v", err)
+		}
+		f.Add(stream.writeBuf.Bytes())
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 4, 'n', 'u', 'l', 'l'})
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		stream := newMockStream()
+		stream.readBuf.Write(frame)
+		msg, err := ReadMessage(stream)
+		if err == nil && msg == nil {
+			t.Fatal("ReadMessage() returned neither a message nor an error")
+		}
+	})
+}
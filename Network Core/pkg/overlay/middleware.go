@@ -0,0 +1,126 @@
+package overlay
+
+import (
+    "math"
+    "net/http"
+    "sync"
+    "time"
+)
+
+const (
+    // maxLimiterClients bounds the clients a RateLimiter tracks; idle
+    // clients are forgotten first
+    maxLimiterClients = 10000
+)
+
+// Middleware wraps a handler, e.g. to authenticate or throttle requests
+type Middleware func(HandlerFunc) HandlerFunc
+
+// Use adds middleware run for every request, in the order added, before
+// the route's handler
+func (s *ServerAdapter) Use(mw ...Middleware) {
+    s.middleware = append(s.middleware, mw...)
+}
+
+// wrap applies the adapter's middleware to a handler
+func (s *ServerAdapter) wrap(handler HandlerFunc) HandlerFunc {
+    for i := len(s.middleware) - 1; i >= 0; i-- {
+        handler = s.middleware[i](handler)
+    }
+    return handler
+}
+
+// RequireSigned rejects requests without an authenticated sender. If allow
+// is not nil the sender must also pass it, e.g. to restrict an endpoint to
+// known validators.
+func RequireSigned(allow func(peerID string) bool) Middleware {
+    return func(next HandlerFunc) HandlerFunc {
+        return func(r *Request) (*Response, error) {
+            if r.PeerID == "" {
+                return errorResponse(http.StatusUnauthorized, "unauthenticated request"), nil
+            }
+            if allow != nil && !allow(r.PeerID) {
+                return errorResponse(http.StatusForbidden, "peer not authorized"), nil
+            }
+            return next(r)
+        }
+    }
+}
+
+// RateLimiter throttles requests per sending peer with a token bucket: each
+// peer may make burst requests at once, refilled at rate per second. Peers
+// are identified by their signature, so they cannot dodge the limit by
+// claiming another identity.
+type RateLimiter struct {
+    rate  float64
+    burst float64
+
+    mu      sync.Mutex
+    clients map[string]*bucket
+}
+
+type bucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate requests per second per
+// peer, with bursts of up to burst requests
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+    return &RateLimiter{
+        rate:    rate,
+        burst:   float64(burst),
+        clients: make(map[string]*bucket),
+    }
+}
+
+// Allow reports whether a peer may make a request now, consuming a token
+// if so
+func (l *RateLimiter) Allow(peerID string) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := time.Now()
+    b, ok := l.clients[peerID]
+    if !ok {
+        if len(l.clients) >= maxLimiterClients {
+            l.pruneLocked(now)
+        }
+        b = &bucket{tokens: l.burst, last: now}
+        l.clients[peerID] = b
+    }
+
+    b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+    b.last = now
+    if b.tokens < 1 {
+        return false
+    }
+    b.tokens--
+    return true
+}
+
+// pruneLocked forgets peers whose buckets have refilled, which are
+// indistinguishable from new peers. l.mu must be held.
+func (l *RateLimiter) pruneLocked(now time.Time) {
+    for id, b := range l.clients {
+        if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+            delete(l.clients, id)
+        }
+    }
+}
+
+// Limit wraps a single handler with the limiter, for endpoints that need a
+// stricter limit than the rest
+func (l *RateLimiter) Limit(next HandlerFunc) HandlerFunc {
+    return func(r *Request) (*Response, error) {
+        if !l.Allow(r.PeerID) {
+            return errorResponse(http.StatusTooManyRequests, "rate limit exceeded"), nil
+        }
+        return next(r)
+    }
+}
+
+// Middleware returns the limiter as middleware for every route
+func (l *RateLimiter) Middleware() Middleware {
+    return l.Limit
+}
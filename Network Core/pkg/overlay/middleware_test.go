@@ -0,0 +1,85 @@
+package overlay
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func okHandler(r *Request) (*Response, error) {
+	return &Response{StatusCode: http.StatusOK, Body: []byte(`{"peer":"` + r.PeerID + `"}`)}, nil
+}
+
+func serveAs(t *testing.T, s *ServerAdapter, fromID string, path string) *Response {
+	t.Helper()
+	payload, err := json.Marshal(&Request{Method: "POST", Path: path})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	resp, err := s.serve(&Message{FromID: fromID, Payload: payload})
+	if err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+	return resp
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(10, 2)
+
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("Allow() rejected requests within the burst")
+	}
+	if l.Allow("a") {
+		t.Error("Allow() accepted a request beyond the burst")
+	}
+	if !l.Allow("b") {
+		t.Error("Allow() limited a peer by another peer's requests")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if !l.Allow("a") {
+		t.Error("Allow() did not refill tokens over time")
+	}
+}
+
+func TestServerMiddleware(t *testing.T) {
+	s := &ServerAdapter{routes: make(map[string]map[string]HandlerFunc)}
+	allowed := map[string]bool{"validator": true}
+	s.Use(RequireSigned(func(peerID string) bool { return allowed[peerID] }))
+	s.Use(NewRateLimiter(0.001, 1).Middleware())
+	s.HandleFunc("POST", "/vote", okHandler)
+
+	resp := serveAs(t, s, "validator", "/vote")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Authorized request status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if string(resp.Body) != `{"peer":"validator"}` {
+		t.Errorf("Handler saw body %s, want the authenticated sender", resp.Body)
+	}
+
+	if resp := serveAs(t, s, "validator", "/vote"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Request over the limit status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if resp := serveAs(t, s, "stranger", "/vote"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Unauthorized request status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if resp := serveAs(t, s, "", "/vote"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unsigned request status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestServerRoutesQuery(t *testing.T) {
+	s := &ServerAdapter{routes: make(map[string]map[string]HandlerFunc)}
+	s.HandleFunc("POST", "/file/list", func(r *Request) (*Response, error) {
+		return &Response{StatusCode: http.StatusOK, Body: []byte(r.QueryParam("prefix"))}, nil
+	})
+
+	resp := serveAs(t, s, "client", "/file/list?prefix=a%20b&limit=5")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Request with query status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if string(resp.Body) != "a b" {
+		t.Errorf("QueryParam(prefix) = %q, want %q", resp.Body, "a b")
+	}
+}
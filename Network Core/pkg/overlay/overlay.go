@@ -2,8 +2,6 @@ package overlay
 
 import (
     "context"
-    "crypto/rand"
-    "encoding/hex"
     "encoding/json"
     "fmt"
     "io"
@@ -11,6 +9,8 @@ import (
     "sync"
     "time"
 
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/bufpool"
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
     "github.com/libp2p/go-libp2p"
     dht "github.com/libp2p/go-libp2p-kad-dht"
     "github.com/libp2p/go-libp2p/core/crypto"
@@ -27,6 +27,10 @@ const (
     DHTPingInterval  = 30 * time.Second
     LANDiscoveryPort = 6666
     BootstrapTimeout = 60 * time.Second
+
+    // MaxMessageSize bounds the encoded size of a message read from a
+    // stream, so a peer cannot make us allocate whatever length it claims
+    MaxMessageSize = 16 * 1024 * 1024
 )
 
 // Node represents a node in the overlay network
@@ -36,8 +40,19 @@ type Node struct {
     ctx        context.Context
     cancel     context.CancelFunc
     nodeID     string
+    privKey    crypto.PrivKey
     lanPeers   sync.Map // string -> PeerInfo
     msgHandler MessageHandler
+    reqHandler RequestHandler
+
+    rpcMu      sync.Mutex
+    rpcStreams map[peer.ID]*rpcStream
+    nextID     uint64
+
+    relayMu    sync.Mutex
+    relaySeen  map[string]time.Time     // envelope ID -> last seen
+    relayAcks  map[string]chan struct{} // envelope ID -> waiting sender
+    relayStore []storedEnvelope
 }
 
 // PeerInfo stores information about a peer
@@ -48,13 +63,21 @@ type PeerInfo struct {
     LastSeen  time.Time
 }
 
-// Message represents an overlay network message
+// Message represents an overlay network message. Messages are signed with
+// the sender's libp2p key, and the payload may be encrypted to the recipient.
 type Message struct {
-    FromID  string `json:"from_id"`
-    ToID    string `json:"to_id"`
-    Type    string `json:"msg_type"`
-    Payload []byte `json:"payload"`
-    IsLAN   bool   `json:"is_lan"`
+    ID           uint64 `json:"id,omitempty"`       // Set on requests
+    ReplyTo      uint64 `json:"reply_to,omitempty"` // ID of the request answered
+    FromID       string `json:"from_id"`
+    ToID         string `json:"to_id"`
+    Type         string `json:"msg_type"`
+    Payload      []byte `json:"payload"`
+    IsLAN        bool   `json:"is_lan"`
+    PublicKey    []byte `json:"public_key,omitempty"`
+    Signature    []byte `json:"signature,omitempty"`
+    Encrypted    bool   `json:"encrypted,omitempty"`
+    EphemeralKey []byte `json:"ephemeral_key,omitempty"`
+    Nonce        []byte `json:"nonce,omitempty"`
 }
 
 // MessageHandler handles incoming messages
@@ -62,28 +85,39 @@ type MessageHandler interface {
     HandleMessage(msg *Message) error
 }
 
-// NewOverlayNode creates a new overlay network node
+// NewOverlayNode creates a new overlay network node using the default
+// transports
 func NewNode(ctx context.Context) (*Node, error) {
-    // Generate node private key
-    priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+    return NewNodeWithTransports(ctx, DefaultTransportConfig())
+}
+
+// NewNodeWithTransports creates a new overlay network node that listens on
+// and dials with the given transports
+func NewNodeWithTransports(ctx context.Context, transports TransportConfig) (*Node, error) {
+    // Without a saved identity the node gets a new peer ID each run
+    id, err := identity.Generate()
     if err != nil {
-        return nil, fmt.Errorf("failed to generate node key: %v", err)
+        return nil, err
     }
+    return NewNodeWithIdentity(ctx, transports, id)
+}
 
+// NewNodeWithIdentity creates a new overlay network node that keeps the
+// peer ID of a persistent identity
+func NewNodeWithIdentity(ctx context.Context, transports TransportConfig, id *identity.Identity) (*Node, error) {
     // Configure network transports
-    listenAddrs := []multiaddr.Multiaddr{
-        mustMultiaddr("/ip4/0.0.0.0/tcp/0"),
-        mustMultiaddr("/ip4/0.0.0.0/udp/0/quic"),
+    transportOpts, err := transports.options()
+    if err != nil {
+        return nil, err
     }
 
     // Create libp2p host
-    h, err := libp2p.New(
-        libp2p.Identity(priv),
-        libp2p.ListenAddrs(listenAddrs...),
+    h, err := libp2p.New(append(transportOpts,
+        libp2p.Identity(id.PrivKey),
         libp2p.EnableRelay(),
         libp2p.NATPortMap(),
         libp2p.EnableHolePunching(),
-    )
+    )...)
     if err != nil {
         return nil, fmt.Errorf("failed to create libp2p host: %v", err)
     }
@@ -100,11 +134,16 @@ func NewNode(ctx context.Context) (*Node, error) {
 
     // Create node
     node := &Node{
-        host:   h,
-        dht:    kdht,
-        ctx:    ctx,
-        cancel: cancel,
-        nodeID: hex.EncodeToString([]byte(h.ID())),
+        host:    h,
+        dht:     kdht,
+        ctx:     ctx,
+        cancel:  cancel,
+        nodeID:  nodeIDFromPeer(h.ID()),
+        privKey: id.PrivKey,
+
+        rpcStreams: make(map[peer.ID]*rpcStream),
+        relaySeen:  make(map[string]time.Time),
+        relayAcks:  make(map[string]chan struct{}),
     }
 
     // Set up stream handler
@@ -135,28 +174,45 @@ func (n *Node) Close() error {
     return n.host.Close()
 }
 
-// SendMessage sends a message to a specific node
+// SendMessage sends a signed message to a specific node
 func (n *Node) SendMessage(toID string, msgType string, payload []byte) error {
+    return n.send(toID, msgType, payload, false)
+}
+
+// SendEncryptedMessage sends a signed message whose payload is encrypted to
+// the recipient's public key
+func (n *Node) SendEncryptedMessage(toID string, msgType string, payload []byte) error {
+    return n.send(toID, msgType, payload, true)
+}
+
+// send builds, optionally encrypts and signs a message, then delivers it
+func (n *Node) send(toID string, msgType string, payload []byte, encrypt bool) error {
+    msg := &Message{
+        ToID:    toID,
+        Type:    msgType,
+        Payload: payload,
+    }
+
     // Check if peer is on LAN first
-    if lanPeer, ok := n.lanPeers.Load(toID); ok {
+    lanPeer, isLAN := n.lanPeers.Load(toID)
+    msg.IsLAN = isLAN
+
+    if encrypt {
+        if err := EncryptMessage(msg); err != nil {
+            return fmt.Errorf("failed to encrypt message: %v", err)
+        }
+    }
+    if err := SignMessage(msg, n.privKey); err != nil {
+        return err
+    }
+
+    if isLAN {
         peerInfo := lanPeer.(PeerInfo)
-        return n.sendDirectMessage(peerInfo.ID, &Message{
-            FromID:  n.nodeID,
-            ToID:    toID,
-            Type:    msgType,
-            Payload: payload,
-            IsLAN:   true,
-        })
+        return n.sendDirectMessage(peerInfo.ID, msg)
     }
 
     // Otherwise route through overlay
-    return n.sendOverlayMessage(&Message{
-        FromID:  n.nodeID,
-        ToID:    toID,
-        Type:    msgType,
-        Payload: payload,
-        IsLAN:   false,
-    })
+    return n.sendOverlayMessage(msg)
 }
 
 // SetMessageHandler sets the handler for incoming messages
@@ -287,12 +343,44 @@ func (n *Node) sendOverlayMessage(msg *Message) error {
     ctx, cancel := context.WithTimeout(n.ctx, 30*time.Second)
     defer cancel()
 
-    peerID, err := n.dht.FindPeer(ctx, peer.ID(msg.ToID))
+    if _, err := peerFromNodeID(msg.ToID); err != nil {
+        return err
+    }
+
+    // Fall back to relaying through connected peers when the destination
+    // cannot be found or dialed, e.g. behind a NAT
+    peerID, err := n.findPeer(ctx, msg.ToID)
+    if err == nil {
+        if err = n.sendDirectMessage(peerID, msg); err == nil {
+            return nil
+        }
+    }
+    if relayErr := n.relayMessage(ctx, msg); relayErr != nil {
+        return fmt.Errorf("failed to deliver message: %v (relay: %v)", err, relayErr)
+    }
+    return nil
+}
+
+// findPeer resolves an overlay node ID to a reachable peer, preferring LAN
+// peers over a DHT lookup
+func (n *Node) findPeer(ctx context.Context, nodeID string) (peer.ID, error) {
+    if lanPeer, ok := n.lanPeers.Load(nodeID); ok {
+        return lanPeer.(PeerInfo).ID, nil
+    }
+
+    target, err := peerFromNodeID(nodeID)
     if err != nil {
-        return fmt.Errorf("failed to find peer: %v", err)
+        return "", err
+    }
+    if len(n.host.Peerstore().Addrs(target)) > 0 {
+        return target, nil
     }
 
-    return n.sendDirectMessage(peerID.ID, msg)
+    info, err := n.dht.FindPeer(ctx, target)
+    if err != nil {
+        return "", fmt.Errorf("failed to find peer: %v", err)
+    }
+    return info.ID, nil
 }
 
 func (n *Node) handleIncomingStream(stream network.Stream) {
@@ -304,6 +392,11 @@ func (n *Node) handleIncomingStream(stream network.Stream) {
         return
     }
 
+    if err := n.authenticate(msg, stream.Conn().RemotePeer()); err != nil {
+        fmt.Printf("Rejected message: %v\n", err)
+        return
+    }
+
     if n.msgHandler != nil {
         if err := n.msgHandler.HandleMessage(msg); err != nil {
             fmt.Printf("Failed to handle message: %v\n", err)
@@ -311,9 +404,26 @@ func (n *Node) handleIncomingStream(stream network.Stream) {
     }
 }
 
+// authenticate only accepts messages signed by the peer that delivered
+// them, decrypting the payload if it was encrypted to this node
+func (n *Node) authenticate(msg *Message, remote peer.ID) error {
+    if len(msg.Signature) == 0 {
+        return ErrUnsigned
+    }
+    if msg.FromID != nodeIDFromPeer(remote) {
+        return ErrSenderMismatch
+    }
+    if err := DecryptMessage(msg, n.privKey); err != nil {
+        return fmt.Errorf("failed to decrypt message: %v", err)
+    }
+    return nil
+}
+
 // setupStreamHandler sets up the handler for incoming streams
 func (n *Node) setupStreamHandler() {
     n.host.SetStreamHandler(protocol.ID(ProtocolID), n.handleIncomingStream)
+    n.host.SetStreamHandler(protocol.ID(RPCProtocolID), n.handleRPCStream)
+    n.setupRelay()
 }
 
 // Utility functions for message serialization
@@ -325,6 +435,9 @@ func WriteMessage(stream network.Stream, msg *Message) error {
 
     // Write length prefix
     length := uint64(len(data))
+    if length > MaxMessageSize {
+        return fmt.Errorf("message of %d bytes exceeds the %d byte limit", length, MaxMessageSize)
+    }
     if err := writeUint64(stream, length); err != nil {
         return fmt.Errorf("failed to write message length: %v", err)
     }
@@ -344,9 +457,14 @@ func ReadMessage(stream network.Stream) (*Message, error) {
     if err != nil {
         return nil, fmt.Errorf("failed to read message length: %v", err)
     }
+    if length > MaxMessageSize {
+        return nil, fmt.Errorf("message of %d bytes exceeds the %d byte limit", length, MaxMessageSize)
+    }
 
-    // Read message data
-    data := make([]byte, length)
+    // Read message data into a pooled buffer; unmarshalling copies out
+    // everything the message keeps
+    data := bufpool.Get(int(length))
+    defer bufpool.Put(data)
     _, err = io.ReadFull(stream, data)
     if err != nil {
         return nil, fmt.Errorf("failed to read message data: %v", err)
@@ -357,6 +475,14 @@ func ReadMessage(stream network.Stream) (*Message, error) {
         return nil, fmt.Errorf("failed to unmarshal message: %v", err)
     }
 
+    // Signed messages must verify; callers decide whether to accept
+    // unsigned ones
+    if len(msg.Signature) > 0 {
+        if err := VerifyMessage(&msg); err != nil {
+            return nil, err
+        }
+    }
+
     return &msg, nil
 }
 
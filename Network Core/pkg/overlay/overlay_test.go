@@ -3,10 +3,13 @@ package overlay
 import (
 "bytes"
 "context"
+"crypto/rand"
 "testing"
 "time"
 
+"github.com/libp2p/go-libp2p/core/crypto"
 "github.com/libp2p/go-libp2p/core/network"
+"github.com/libp2p/go-libp2p/core/peer"
 "github.com/stretchr/testify/mock"
 )
 
@@ -15,8 +18,17 @@ type mockStream struct {
 	network.Stream
 	readBuf  *bytes.Buffer
 	writeBuf *bytes.Buffer
+	remote   peer.ID
 }
 
+// Mock connection reporting the stream's remote peer
+type mockConn struct {
+	network.Conn
+	remote peer.ID
+}
+
+func (c *mockConn) RemotePeer() peer.ID { return c.remote }
+
 func newMockStream() *mockStream {
 	return &mockStream{
 		readBuf:  new(bytes.Buffer),
@@ -27,6 +39,7 @@ func newMockStream() *mockStream {
 func (m *mockStream) Read(p []byte) (n int, err error)  { return m.readBuf.Read(p) }
 func (m *mockStream) Write(p []byte) (n int, err error) { return m.writeBuf.Write(p) }
 func (m *mockStream) Close() error                      { return nil }
+func (m *mockStream) Conn() network.Conn                { return &mockConn{remote: m.remote} }
 
 // Mock message handler
 type mockMessageHandler struct {
@@ -162,17 +175,29 @@ func TestMessageHandling(t *testing.T) {
 	handler := &mockMessageHandler{}
 	node.SetMessageHandler(handler)
 
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateEd25519Key() error = %v", err)
+	}
+	sender, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("IDFromPrivateKey() error = %v", err)
+	}
+
 	testMsg := &Message{
-		FromID:  "sender123",
 		ToID:    node.nodeID,
 		Type:    "test",
 		Payload: []byte("test payload"),
 		IsLAN:   false,
 	}
+	if err := SignMessage(testMsg, priv); err != nil {
+		t.Fatalf("SignMessage() error = %v", err)
+	}
 
 	handler.On("HandleMessage", testMsg).Return(nil)
 
 	stream := newMockStream()
+	stream.remote = sender
 	if err := WriteMessage(stream, testMsg); err != nil {
 		t.Fatalf("WriteMessage() error = %v", err)
 	}
@@ -0,0 +1,418 @@
+package overlay
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/bufpool"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+    // RelayProtocolID carries messages forwarded on behalf of other nodes
+    RelayProtocolID = "/filezap/relay/1.0.0"
+
+    // DefaultRelayTTL is the number of hops a relayed message may take
+    DefaultRelayTTL = 6
+    // RelayAckTimeout is how long a sender waits for a delivery
+    // acknowledgement
+    RelayAckTimeout = 10 * time.Second
+    // RelayStoreTime is how long undeliverable messages are held for a
+    // route to appear, and how long relayed message IDs are remembered
+    RelayStoreTime = 5 * time.Minute
+    // MaxRelayStore bounds the messages held for later forwarding
+    MaxRelayStore = 256
+    // relayFanout is the number of peers a message is forwarded to when the
+    // destination is not directly connected
+    relayFanout = 3
+    // maxRelayFrame bounds the size of a relayed envelope
+    maxRelayFrame = 16 << 20
+
+    // msgTypeRelayAck acknowledges delivery of a relayed message; its
+    // payload is the ID of the envelope delivered
+    msgTypeRelayAck = "relay_ack"
+)
+
+// ErrNotDelivered is returned when a relayed message is not acknowledged
+var ErrNotDelivered = errors.New("message not delivered")
+
+// relayEnvelope wraps a signed message travelling through relays. Path
+// lists the nodes it has visited, so it never revisits one, and TTL bounds
+// the remaining hops. Acknowledgements are source routed back along the
+// reversed path in Route.
+type relayEnvelope struct {
+    ID      string   `json:"id"`
+    TTL     int      `json:"ttl"`
+    Path    []string `json:"path"`
+    Route   []string `json:"route,omitempty"`
+    Message *Message `json:"message"`
+}
+
+// storedEnvelope is an envelope held until a route to its destination
+// appears
+type storedEnvelope struct {
+    env     *relayEnvelope
+    expires time.Time
+}
+
+// newEnvelopeID returns a random envelope ID
+func newEnvelopeID() (string, error) {
+    id := make([]byte, 16)
+    if _, err := rand.Read(id); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(id), nil
+}
+
+// relayMessage sends a signed message through mutually connected peers and
+// waits for the destination to acknowledge it
+func (n *Node) relayMessage(ctx context.Context, msg *Message) error {
+    id, err := newEnvelopeID()
+    if err != nil {
+        return fmt.Errorf("failed to create envelope: %v", err)
+    }
+    env := &relayEnvelope{
+        ID:      id,
+        TTL:     DefaultRelayTTL,
+        Message: msg,
+    }
+
+    ack := make(chan struct{})
+    n.relayMu.Lock()
+    n.relayAcks[id] = ack
+    n.relaySeen[id] = time.Now()
+    n.relayMu.Unlock()
+    defer func() {
+        n.relayMu.Lock()
+        delete(n.relayAcks, id)
+        n.relayMu.Unlock()
+    }()
+
+    n.forwardEnvelope(env)
+
+    ctx, cancel := context.WithTimeout(ctx, RelayAckTimeout)
+    defer cancel()
+    select {
+    case <-ack:
+        return nil
+    case <-ctx.Done():
+        return fmt.Errorf("%w: no acknowledgement from %s", ErrNotDelivered, msg.ToID)
+    }
+}
+
+// handleRelayStream receives one envelope from a peer
+func (n *Node) handleRelayStream(stream network.Stream) {
+    defer stream.Close()
+
+    var env relayEnvelope
+    if err := readFrame(stream, &env); err != nil {
+        fmt.Printf("Failed to read relayed message: %v\n", err)
+        return
+    }
+
+    remote := nodeIDFromPeer(stream.Conn().RemotePeer())
+    if len(env.Path) == 0 || env.Path[len(env.Path)-1] != remote {
+        fmt.Printf("Rejected relayed message: path does not end at sender\n")
+        return
+    }
+    if env.Message == nil {
+        fmt.Printf("Rejected relayed message: empty envelope\n")
+        return
+    }
+    // Relays cannot tell who originated a message, so it must carry a valid
+    // signature from the node named in FromID
+    if err := VerifyMessage(env.Message); err != nil {
+        fmt.Printf("Rejected relayed message: %v\n", err)
+        return
+    }
+
+    n.receiveEnvelope(&env)
+}
+
+// receiveEnvelope delivers an envelope addressed to this node or passes it
+// on. Envelopes already seen are dropped.
+func (n *Node) receiveEnvelope(env *relayEnvelope) {
+    n.relayMu.Lock()
+    n.pruneRelayLocked()
+    _, seen := n.relaySeen[env.ID]
+    n.relaySeen[env.ID] = time.Now()
+    n.relayMu.Unlock()
+    if seen {
+        return
+    }
+
+    if env.Message.ToID != n.nodeID {
+        n.forwardEnvelope(env)
+        return
+    }
+
+    if env.Message.Type == msgTypeRelayAck {
+        n.relayMu.Lock()
+        if ack, ok := n.relayAcks[string(env.Message.Payload)]; ok {
+            close(ack)
+            delete(n.relayAcks, string(env.Message.Payload))
+        }
+        n.relayMu.Unlock()
+        return
+    }
+
+    n.acknowledge(env)
+
+    msg := env.Message
+    if err := DecryptMessage(msg, n.privKey); err != nil {
+        fmt.Printf("Failed to decrypt relayed message: %v\n", err)
+        return
+    }
+    if n.msgHandler != nil {
+        if err := n.msgHandler.HandleMessage(msg); err != nil {
+            fmt.Printf("Failed to handle message: %v\n", err)
+        }
+    }
+}
+
+// acknowledge sends a signed delivery acknowledgement back along the path
+// the envelope took
+func (n *Node) acknowledge(env *relayEnvelope) {
+    id, err := newEnvelopeID()
+    if err != nil {
+        fmt.Printf("Failed to acknowledge relayed message: %v\n", err)
+        return
+    }
+
+    ack := &Message{
+        ToID:    env.Message.FromID,
+        Type:    msgTypeRelayAck,
+        Payload: []byte(env.ID),
+    }
+    if err := SignMessage(ack, n.privKey); err != nil {
+        fmt.Printf("Failed to acknowledge relayed message: %v\n", err)
+        return
+    }
+
+    route := make([]string, 0, len(env.Path))
+    for i := len(env.Path) - 1; i >= 0; i-- {
+        route = append(route, env.Path[i])
+    }
+
+    n.relayMu.Lock()
+    n.relaySeen[id] = time.Now()
+    n.relayMu.Unlock()
+
+    n.forwardEnvelope(&relayEnvelope{
+        ID:      id,
+        TTL:     DefaultRelayTTL,
+        Route:   route,
+        Message: ack,
+    })
+}
+
+// forwardEnvelope sends an envelope one hop closer to its destination. A
+// source routed envelope goes to the next node on its route; otherwise it
+// goes straight to the destination when connected, or to a few connected
+// peers it has not visited. Envelopes that cannot be forwarded are stored.
+func (n *Node) forwardEnvelope(env *relayEnvelope) {
+    if env.TTL <= 0 {
+        return
+    }
+
+    next := *env
+    next.TTL--
+    next.Path = append(append([]string(nil), env.Path...), n.nodeID)
+
+    // Source routed: take the next hop, falling back to normal forwarding
+    // if it is gone
+    if len(next.Route) > 0 {
+        hop := next.Route[0]
+        next.Route = next.Route[1:]
+        if target, err := peerFromNodeID(hop); err == nil && n.sendEnvelope(target, &next) == nil {
+            return
+        }
+        next.Route = nil
+    }
+
+    if n.forwardTo(n.relayCandidates(&next), &next) == 0 {
+        n.storeEnvelope(&next)
+    }
+}
+
+// relayCandidates returns the connected peers an envelope may be forwarded
+// to: the destination alone if it is connected, otherwise up to
+// relayFanout peers that are not already on its path
+func (n *Node) relayCandidates(env *relayEnvelope) []peer.ID {
+    connected := n.host.Network().Peers()
+
+    for _, p := range connected {
+        if nodeIDFromPeer(p) == env.Message.ToID {
+            return []peer.ID{p}
+        }
+    }
+
+    visited := make(map[string]bool, len(env.Path))
+    for _, id := range env.Path {
+        visited[id] = true
+    }
+    visited[env.Message.FromID] = true
+
+    var candidates []peer.ID
+    for _, p := range connected {
+        if visited[nodeIDFromPeer(p)] {
+            continue
+        }
+        candidates = append(candidates, p)
+        if len(candidates) == relayFanout {
+            break
+        }
+    }
+    return candidates
+}
+
+// forwardTo sends an envelope to each peer, returning how many accepted it
+func (n *Node) forwardTo(peers []peer.ID, env *relayEnvelope) int {
+    sent := 0
+    for _, p := range peers {
+        if err := n.sendEnvelope(p, env); err != nil {
+            fmt.Printf("Failed to relay message via %s: %v\n", p, err)
+            continue
+        }
+        sent++
+    }
+    return sent
+}
+
+// sendEnvelope writes an envelope to a single peer
+func (n *Node) sendEnvelope(p peer.ID, env *relayEnvelope) error {
+    ctx, cancel := context.WithTimeout(n.ctx, 10*time.Second)
+    defer cancel()
+
+    stream, err := n.host.NewStream(ctx, p, protocol.ID(RelayProtocolID))
+    if err != nil {
+        return fmt.Errorf("failed to open stream: %v", err)
+    }
+    defer stream.Close()
+
+    return writeFrame(stream, env)
+}
+
+// storeEnvelope holds an envelope until a peer it can be forwarded to
+// connects, dropping the oldest when the store is full
+func (n *Node) storeEnvelope(env *relayEnvelope) {
+    n.relayMu.Lock()
+    defer n.relayMu.Unlock()
+
+    n.pruneRelayLocked()
+    if len(n.relayStore) >= MaxRelayStore {
+        n.relayStore = n.relayStore[1:]
+    }
+    n.relayStore = append(n.relayStore, storedEnvelope{
+        env:     env,
+        expires: time.Now().Add(RelayStoreTime),
+    })
+}
+
+// retryStored forwards stored envelopes once a new peer connects. The
+// forwarding hop was already counted when the envelope was stored.
+func (n *Node) retryStored(p peer.ID) {
+    n.relayMu.Lock()
+    n.pruneRelayLocked()
+    stored := n.relayStore
+    n.relayStore = nil
+    n.relayMu.Unlock()
+
+    var remaining []storedEnvelope
+    for _, s := range stored {
+        if !n.canRelayTo(p, s.env) || n.sendEnvelope(p, s.env) != nil {
+            remaining = append(remaining, s)
+        }
+    }
+
+    if len(remaining) > 0 {
+        n.relayMu.Lock()
+        n.relayStore = append(remaining, n.relayStore...)
+        n.relayMu.Unlock()
+    }
+}
+
+// canRelayTo reports whether a stored envelope may go to peer p
+func (n *Node) canRelayTo(p peer.ID, env *relayEnvelope) bool {
+    id := nodeIDFromPeer(p)
+    if id == env.Message.FromID {
+        return false
+    }
+    for _, visited := range env.Path {
+        if visited == id {
+            return false
+        }
+    }
+    return true
+}
+
+// pruneRelayLocked expires stored envelopes and remembered IDs. Callers
+// must hold n.relayMu.
+func (n *Node) pruneRelayLocked() {
+    now := time.Now()
+    for id, seen := range n.relaySeen {
+        if now.Sub(seen) > RelayStoreTime {
+            delete(n.relaySeen, id)
+        }
+    }
+
+    kept := n.relayStore[:0]
+    for _, s := range n.relayStore {
+        if now.Before(s.expires) {
+            kept = append(kept, s)
+        }
+    }
+    n.relayStore = kept
+}
+
+// setupRelay registers the relay protocol and retries stored envelopes
+// whenever a peer connects
+func (n *Node) setupRelay() {
+    n.host.SetStreamHandler(protocol.ID(RelayProtocolID), n.handleRelayStream)
+    n.host.Network().Notify(&network.NotifyBundle{
+        ConnectedF: func(_ network.Network, conn network.Conn) {
+            go n.retryStored(conn.RemotePeer())
+        },
+    })
+}
+
+// writeFrame writes v as length-prefixed JSON
+func writeFrame(w io.Writer, v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return fmt.Errorf("failed to marshal frame: %v", err)
+    }
+    if err := writeUint64(w, uint64(len(data))); err != nil {
+        return fmt.Errorf("failed to write frame length: %v", err)
+    }
+    if _, err := w.Write(data); err != nil {
+        return fmt.Errorf("failed to write frame data: %v", err)
+    }
+    return nil
+}
+
+// readFrame reads length-prefixed JSON into v
+func readFrame(r io.Reader, v interface{}) error {
+    length, err := readUint64(r)
+    if err != nil {
+        return fmt.Errorf("failed to read frame length: %v", err)
+    }
+    if length > maxRelayFrame {
+        return fmt.Errorf("frame of %d bytes exceeds limit", length)
+    }
+
+    data := bufpool.Get(int(length))
+    defer bufpool.Put(data)
+    if _, err := io.ReadFull(r, data); err != nil {
+        return fmt.Errorf("failed to read frame data: %v", err)
+    }
+    return json.Unmarshal(data, v)
+}
@@ -0,0 +1,156 @@
+package overlay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/mock"
+)
+
+func connectNodes(t *testing.T, ctx context.Context, from, to *Node) {
+	t.Helper()
+	if err := from.host.Connect(ctx, peer.AddrInfo{ID: to.host.ID(), Addrs: to.host.Addrs()}); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+}
+
+func newRelayNode(t *testing.T, ctx context.Context) *Node {
+	t.Helper()
+	node, err := NewNode(ctx)
+	if err != nil {
+		t.Fatalf("NewNode() error = %v", err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node
+}
+
+func signedMessage(t *testing.T, from, to *Node, payload string) *Message {
+	t.Helper()
+	msg := &Message{ToID: to.nodeID, Type: "test", Payload: []byte(payload)}
+	if err := SignMessage(msg, from.privKey); err != nil {
+		t.Fatalf("SignMessage() error = %v", err)
+	}
+	return msg
+}
+
+func TestRelayMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a and c only reach each other through b
+	a := newRelayNode(t, ctx)
+	b := newRelayNode(t, ctx)
+	c := newRelayNode(t, ctx)
+	connectNodes(t, ctx, a, b)
+	connectNodes(t, ctx, b, c)
+
+	handler := &mockMessageHandler{}
+	c.SetMessageHandler(handler)
+	handler.On("HandleMessage", mock.MatchedBy(func(msg *Message) bool {
+		return msg.FromID == a.nodeID && string(msg.Payload) == "relayed"
+	})).Return(nil).Once()
+
+	if err := a.relayMessage(ctx, signedMessage(t, a, c, "relayed")); err != nil {
+		t.Fatalf("relayMessage() error = %v", err)
+	}
+	handler.AssertExpectations(t)
+}
+
+func TestRelayDropsDuplicatesAndExpired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := newRelayNode(t, ctx)
+	b := newRelayNode(t, ctx)
+	c := newRelayNode(t, ctx)
+	connectNodes(t, ctx, b, c)
+
+	handler := &mockMessageHandler{}
+	c.SetMessageHandler(handler)
+	handler.On("HandleMessage", mock.Anything).Return(nil).Once()
+
+	// The same envelope arriving twice is delivered once
+	env := &relayEnvelope{ID: "dup", TTL: DefaultRelayTTL, Path: []string{a.nodeID}, Message: signedMessage(t, a, c, "once")}
+	c.receiveEnvelope(env)
+	c.receiveEnvelope(env)
+	handler.AssertNumberOfCalls(t, "HandleMessage", 1)
+
+	// An envelope with no hops left is neither forwarded nor stored
+	expired := &relayEnvelope{ID: "expired", TTL: 0, Path: []string{a.nodeID}, Message: signedMessage(t, a, c, "late")}
+	b.receiveEnvelope(expired)
+	time.Sleep(100 * time.Millisecond)
+	handler.AssertNumberOfCalls(t, "HandleMessage", 1)
+
+	b.relayMu.Lock()
+	defer b.relayMu.Unlock()
+	for _, s := range b.relayStore {
+		if s.env.ID == expired.ID {
+			t.Error("Expired envelope was stored for forwarding")
+		}
+	}
+}
+
+func TestRelayStoreAndForward(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := newRelayNode(t, ctx)
+	b := newRelayNode(t, ctx)
+	c := newRelayNode(t, ctx)
+	connectNodes(t, ctx, a, b)
+
+	handler := &mockMessageHandler{}
+	c.SetMessageHandler(handler)
+	handler.On("HandleMessage", mock.Anything).Return(nil).Once()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.relayMessage(ctx, signedMessage(t, a, c, "stored"))
+	}()
+
+	// b holds the message until a route to c appears
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.relayMu.Lock()
+		stored := len(b.relayStore)
+		b.relayMu.Unlock()
+		if stored == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Relay did not store the undeliverable message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	connectNodes(t, ctx, c, b)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("relayMessage() error = %v", err)
+		}
+	case <-time.After(RelayAckTimeout):
+		t.Fatal("Stored message was not acknowledged")
+	}
+	handler.AssertExpectations(t)
+}
+
+func TestRelayWithoutRoute(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := newRelayNode(t, ctx)
+	c := newRelayNode(t, ctx)
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer reqCancel()
+
+	err := a.relayMessage(reqCtx, signedMessage(t, a, c, "lost"))
+	if !errors.Is(err, ErrNotDelivered) {
+		t.Errorf("relayMessage() error = %v, want %v", err, ErrNotDelivered)
+	}
+}
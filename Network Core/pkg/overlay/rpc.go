@@ -0,0 +1,296 @@
+package overlay
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+    // RPCProtocolID carries requests and their responses. Each pair of
+    // peers shares one long-lived stream that multiplexes all requests.
+    RPCProtocolID = "/filezap/rpc/1.0.0"
+
+    // DefaultRequestTimeout bounds requests whose context has no deadline
+    DefaultRequestTimeout = 30 * time.Second
+
+    // MsgTypeError is the response type sent when a request handler fails;
+    // the payload holds the error text
+    MsgTypeError = "error"
+)
+
+var (
+    // ErrRequestTimeout is returned when no response arrives in time
+    ErrRequestTimeout = errors.New("request timed out")
+    // ErrStreamClosed is returned for requests pending on a stream that
+    // closed before they were answered
+    ErrStreamClosed = errors.New("request stream closed")
+    // ErrNoRequestHandler is returned to peers when this node does not
+    // answer requests
+    ErrNoRequestHandler = errors.New("no request handler")
+)
+
+// RequestHandler answers requests sent with Node.Request. Only the Type and
+// Payload of the returned message are used; the node addresses, correlates
+// and signs the response.
+type RequestHandler interface {
+    HandleRequest(msg *Message) (*Message, error)
+}
+
+// SetRequestHandler sets the handler that answers incoming requests
+func (n *Node) SetRequestHandler(handler RequestHandler) {
+    n.rpcMu.Lock()
+    defer n.rpcMu.Unlock()
+    n.reqHandler = handler
+}
+
+// requestHandler returns the current request handler
+func (n *Node) requestHandler() RequestHandler {
+    n.rpcMu.Lock()
+    defer n.rpcMu.Unlock()
+    return n.reqHandler
+}
+
+// rpcStream multiplexes concurrent requests to one peer over one stream.
+// Requests carry a unique ID and responses name it in ReplyTo, so any
+// number of requests can be in flight and answered in any order.
+type rpcStream struct {
+    node   *Node
+    stream network.Stream
+    remote peer.ID
+
+    writeMu sync.Mutex
+
+    mu      sync.Mutex
+    pending map[uint64]chan *Message
+    closed  bool
+}
+
+// Request sends a signed request to a node and waits for its response. The
+// request is abandoned with ErrRequestTimeout once ctx expires, or after
+// DefaultRequestTimeout if ctx has no deadline. A response of type
+// MsgTypeError is returned as an error.
+func (n *Node) Request(ctx context.Context, toID string, msgType string, payload []byte) (*Message, error) {
+    if _, ok := ctx.Deadline(); !ok {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+        defer cancel()
+    }
+
+    s, err := n.rpcStreamTo(ctx, toID)
+    if err != nil {
+        return nil, err
+    }
+
+    msg := &Message{
+        ID:      atomic.AddUint64(&n.nextID, 1),
+        ToID:    toID,
+        Type:    msgType,
+        Payload: payload,
+    }
+    if err := SignMessage(msg, n.privKey); err != nil {
+        return nil, err
+    }
+
+    respChan, err := s.register(msg.ID)
+    if err != nil {
+        return nil, err
+    }
+    defer s.unregister(msg.ID)
+
+    if err := s.write(msg); err != nil {
+        s.close()
+        return nil, fmt.Errorf("failed to send request: %v", err)
+    }
+
+    select {
+    case resp, ok := <-respChan:
+        if !ok {
+            return nil, ErrStreamClosed
+        }
+        if resp.Type == MsgTypeError {
+            return nil, fmt.Errorf("request failed: %s", resp.Payload)
+        }
+        return resp, nil
+    case <-ctx.Done():
+        return nil, fmt.Errorf("%w: %v", ErrRequestTimeout, ctx.Err())
+    }
+}
+
+// rpcStreamTo returns the request stream to a node, opening it on first use
+func (n *Node) rpcStreamTo(ctx context.Context, toID string) (*rpcStream, error) {
+    peerID, err := n.findPeer(ctx, toID)
+    if err != nil {
+        return nil, err
+    }
+
+    n.rpcMu.Lock()
+    s, ok := n.rpcStreams[peerID]
+    n.rpcMu.Unlock()
+    if ok {
+        return s, nil
+    }
+
+    stream, err := n.host.NewStream(ctx, peerID, protocol.ID(RPCProtocolID))
+    if err != nil {
+        return nil, fmt.Errorf("failed to open stream: %v", err)
+    }
+
+    n.rpcMu.Lock()
+    if existing, ok := n.rpcStreams[peerID]; ok {
+        // Lost a race with another request; keep the stream already shared
+        n.rpcMu.Unlock()
+        stream.Reset()
+        return existing, nil
+    }
+    s = n.newRPCStream(stream, peerID)
+    n.rpcStreams[peerID] = s
+    n.rpcMu.Unlock()
+
+    go s.readLoop()
+    return s, nil
+}
+
+// handleRPCStream serves a request stream opened by a peer. The stream is
+// also used for this node's own requests to that peer.
+func (n *Node) handleRPCStream(stream network.Stream) {
+    remote := stream.Conn().RemotePeer()
+    s := n.newRPCStream(stream, remote)
+
+    n.rpcMu.Lock()
+    if _, ok := n.rpcStreams[remote]; !ok {
+        n.rpcStreams[remote] = s
+    }
+    n.rpcMu.Unlock()
+
+    s.readLoop()
+}
+
+func (n *Node) newRPCStream(stream network.Stream, remote peer.ID) *rpcStream {
+    return &rpcStream{
+        node:    n,
+        stream:  stream,
+        remote:  remote,
+        pending: make(map[uint64]chan *Message),
+    }
+}
+
+// register reserves the response channel for a request
+func (s *rpcStream) register(id uint64) (chan *Message, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.closed {
+        return nil, ErrStreamClosed
+    }
+    ch := make(chan *Message, 1)
+    s.pending[id] = ch
+    return ch, nil
+}
+
+// unregister drops a request that was answered or abandoned
+func (s *rpcStream) unregister(id uint64) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.pending, id)
+}
+
+// write sends one message, serializing writers sharing the stream
+func (s *rpcStream) write(msg *Message) error {
+    s.writeMu.Lock()
+    defer s.writeMu.Unlock()
+    return WriteMessage(s.stream, msg)
+}
+
+// readLoop dispatches responses to waiting requests and serves incoming
+// requests concurrently until the stream fails
+func (s *rpcStream) readLoop() {
+    defer s.close()
+
+    for {
+        msg, err := ReadMessage(s.stream)
+        if err != nil {
+            return
+        }
+        if err := s.node.authenticate(msg, s.remote); err != nil {
+            fmt.Printf("Rejected request stream message: %v\n", err)
+            continue
+        }
+
+        if msg.ReplyTo != 0 {
+            s.deliver(msg)
+            continue
+        }
+        go s.serve(msg)
+    }
+}
+
+// deliver hands a response to the request waiting for it. Responses to
+// abandoned requests are dropped.
+func (s *rpcStream) deliver(msg *Message) {
+    s.mu.Lock()
+    ch, ok := s.pending[msg.ReplyTo]
+    delete(s.pending, msg.ReplyTo)
+    s.mu.Unlock()
+
+    if ok {
+        ch <- msg
+    }
+}
+
+// serve answers one incoming request
+func (s *rpcStream) serve(req *Message) {
+    resp := &Message{Type: MsgTypeError}
+
+    handler := s.node.requestHandler()
+    if handler == nil {
+        resp.Payload = []byte(ErrNoRequestHandler.Error())
+    } else if out, err := handler.HandleRequest(req); err != nil {
+        resp.Payload = []byte(err.Error())
+    } else if out != nil {
+        resp.Type = out.Type
+        resp.Payload = out.Payload
+    }
+
+    resp.ReplyTo = req.ID
+    resp.ToID = req.FromID
+    if err := SignMessage(resp, s.node.privKey); err != nil {
+        fmt.Printf("Failed to sign response: %v\n", err)
+        return
+    }
+    if err := s.write(resp); err != nil {
+        fmt.Printf("Failed to send response: %v\n", err)
+        s.close()
+    }
+}
+
+// close tears down the stream, failing every pending request
+func (s *rpcStream) close() {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return
+    }
+    s.closed = true
+    pending := s.pending
+    s.pending = make(map[uint64]chan *Message)
+    s.mu.Unlock()
+
+    for _, ch := range pending {
+        close(ch)
+    }
+    s.stream.Reset()
+
+    s.node.rpcMu.Lock()
+    if s.node.rpcStreams[s.remote] == s {
+        delete(s.node.rpcStreams, s.remote)
+    }
+    s.node.rpcMu.Unlock()
+}
@@ -0,0 +1,140 @@
+package overlay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peerstore"
+)
+
+// Request handler echoing payloads, optionally after a delay
+type echoHandler struct {
+	delay time.Duration
+}
+
+func (h *echoHandler) HandleRequest(msg *Message) (*Message, error) {
+	time.Sleep(h.delay)
+	if string(msg.Payload) == "fail" {
+		return nil, errors.New("handler failed")
+	}
+	return &Message{Type: "echo", Payload: msg.Payload}, nil
+}
+
+func newConnectedNodes(t *testing.T, ctx context.Context) (*Node, *Node) {
+	t.Helper()
+
+	node1, err := NewNode(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create node1: %v", err)
+	}
+	t.Cleanup(func() { node1.Close() })
+
+	node2, err := NewNode(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create node2: %v", err)
+	}
+	t.Cleanup(func() { node2.Close() })
+
+	node1.host.Peerstore().AddAddrs(node2.host.ID(), node2.host.Addrs(), peerstore.PermanentAddrTTL)
+	return node1, node2
+}
+
+func TestRequestResponse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node1, node2 := newConnectedNodes(t, ctx)
+	node2.SetRequestHandler(&echoHandler{})
+
+	resp, err := node1.Request(ctx, node2.nodeID, "test", []byte("hello"))
+	if err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+	if resp.Type != "echo" || string(resp.Payload) != "hello" {
+		t.Errorf("Request() = %s %q, want echo %q", resp.Type, resp.Payload, "hello")
+	}
+	if resp.FromID != node2.nodeID {
+		t.Errorf("Response FromID = %s, want %s", resp.FromID, node2.nodeID)
+	}
+
+	_, err = node1.Request(ctx, node2.nodeID, "test", []byte("fail"))
+	if err == nil || !strings.Contains(err.Error(), "handler failed") {
+		t.Errorf("Request() error = %v, want handler error", err)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node1, node2 := newConnectedNodes(t, ctx)
+	node2.SetRequestHandler(&echoHandler{delay: 20 * time.Millisecond})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := fmt.Sprintf("request-%d", i)
+			resp, err := node1.Request(ctx, node2.nodeID, "test", []byte(payload))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(resp.Payload) != payload {
+				errs <- fmt.Errorf("got response %q for %q", resp.Payload, payload)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	node1.rpcMu.Lock()
+	streams := len(node1.rpcStreams)
+	node1.rpcMu.Unlock()
+	if streams != 1 {
+		t.Errorf("Request streams = %d, want 1", streams)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node1, node2 := newConnectedNodes(t, ctx)
+	node2.SetRequestHandler(&echoHandler{delay: time.Second})
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer reqCancel()
+
+	start := time.Now()
+	_, err := node1.Request(reqCtx, node2.nodeID, "test", []byte("slow"))
+	if !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("Request() error = %v, want %v", err, ErrRequestTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Request() took %v, want it abandoned at its deadline", elapsed)
+	}
+}
+
+func TestRequestWithoutHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node1, node2 := newConnectedNodes(t, ctx)
+
+	_, err := node1.Request(ctx, node2.nodeID, "test", []byte("hello"))
+	if err == nil || !strings.Contains(err.Error(), ErrNoRequestHandler.Error()) {
+		t.Errorf("Request() error = %v, want %v", err, ErrNoRequestHandler)
+	}
+}
@@ -4,6 +4,9 @@ import (
     "context"
     "encoding/json"
     "fmt"
+    "strings"
+
+    "github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
 )
 
 // ServerAdapter wraps the overlay network for HTTP-like server functionality
@@ -12,6 +15,8 @@ type ServerAdapter struct {
     ctx     context.Context
     routes  map[string]map[string]HandlerFunc // method -> path -> handler
     msgChan chan *Message
+
+    middleware []Middleware
 }
 
 // HandlerFunc handles HTTP-like requests over the overlay network
@@ -19,7 +24,17 @@ type HandlerFunc func(r *Request) (*Response, error)
 
 // NewServerAdapter creates a new server adapter
 func NewServerAdapter(ctx context.Context) (*ServerAdapter, error) {
-    node, err := NewNode(ctx)
+    id, err := identity.Generate()
+    if err != nil {
+        return nil, err
+    }
+    return NewServerAdapterWithIdentity(ctx, id)
+}
+
+// NewServerAdapterWithIdentity creates a new server adapter whose node keeps
+// the peer ID of a persistent identity
+func NewServerAdapterWithIdentity(ctx context.Context, id *identity.Identity) (*ServerAdapter, error) {
+    node, err := NewNodeWithIdentity(ctx, DefaultTransportConfig(), id)
     if err != nil {
         return nil, fmt.Errorf("failed to create overlay node: %v", err)
     }
@@ -31,8 +46,9 @@ func NewServerAdapter(ctx context.Context) (*ServerAdapter, error) {
         msgChan: make(chan *Message, 100),
     }
 
-    // Set up message handler
+    // Set up message handlers
     node.SetMessageHandler(adapter)
+    node.SetRequestHandler(adapter)
 
     return adapter, nil
 }
@@ -48,29 +64,9 @@ func (s *ServerAdapter) HandleFunc(method string, path string, handler HandlerFu
 // HandleMessage implements MessageHandler
 func (s *ServerAdapter) HandleMessage(msg *Message) error {
     if msg.Type == MsgTypeValidatorRequest {
-        var req Request
-        if err := json.Unmarshal(msg.Payload, &req); err != nil {
-            return fmt.Errorf("failed to unmarshal request: %v", err)
-        }
-
-        // Find handler
-        handlers, ok := s.routes[req.Method]
-        if !ok {
-            return s.sendError(msg.FromID, 405, "method not allowed")
-        }
-
-        handler, pattern := s.matchRoute(handlers, req.Path)
-        if handler == nil {
-            return s.sendError(msg.FromID, 404, "not found")
-        }
-
-        // Update request with pattern info
-        req.pattern = pattern
-
-        // Call handler
-        resp, err := handler(&req)
+        resp, err := s.serve(msg)
         if err != nil {
-            return s.sendError(msg.FromID, 500, err.Error())
+            return err
         }
 
         // Send response
@@ -91,13 +87,76 @@ func (s *ServerAdapter) HandleMessage(msg *Message) error {
     return nil
 }
 
-func (s *ServerAdapter) sendError(peerID string, status int, message string) error {
-    resp := &Response{
+// HandleRequest implements RequestHandler
+func (s *ServerAdapter) HandleRequest(msg *Message) (*Message, error) {
+    if msg.Type != MsgTypeValidatorRequest {
+        return nil, fmt.Errorf("unexpected message type: %s", msg.Type)
+    }
+
+    resp, err := s.serve(msg)
+    if err != nil {
+        return nil, err
+    }
+
+    respData, err := json.Marshal(resp)
+    if err != nil {
+        respData, err = json.Marshal(errorResponse(500, "failed to marshal response"))
+        if err != nil {
+            return nil, fmt.Errorf("failed to marshal error response: %v", err)
+        }
+    }
+
+    return &Message{Type: MsgTypeValidatorResponse, Payload: respData}, nil
+}
+
+// serve routes a request message to its handler. Routing and handler
+// failures become error responses.
+func (s *ServerAdapter) serve(msg *Message) (*Response, error) {
+    var req Request
+    if err := json.Unmarshal(msg.Payload, &req); err != nil {
+        return nil, fmt.Errorf("failed to unmarshal request: %v", err)
+    }
+
+    // Find handler
+    handlers, ok := s.routes[req.Method]
+    if !ok {
+        return errorResponse(405, "method not allowed"), nil
+    }
+
+    // Routes match the path without its query
+    path := req.Path
+    if i := strings.IndexByte(path, '?'); i >= 0 {
+        path = path[:i]
+    }
+    handler, pattern := s.matchRoute(handlers, path)
+    if handler == nil {
+        return errorResponse(404, "not found"), nil
+    }
+
+    // Update request with pattern info and the sender, whose signature the
+    // node has verified
+    req.pattern = pattern
+    req.PeerID = msg.FromID
+
+    // Call handler
+    resp, err := s.wrap(handler)(&req)
+    if err != nil {
+        return errorResponse(500, err.Error()), nil
+    }
+
+    return resp, nil
+}
+
+// errorResponse builds a response carrying an error message
+func errorResponse(status int, message string) *Response {
+    return &Response{
         StatusCode: status,
         Body:       []byte(fmt.Sprintf(`{"error":"%s"}`, message)),
     }
+}
 
-    respData, err := json.Marshal(resp)
+func (s *ServerAdapter) sendError(peerID string, status int, message string) error {
+    respData, err := json.Marshal(errorResponse(status, message))
     if err != nil {
         return fmt.Errorf("failed to marshal error response: %v", err)
     }
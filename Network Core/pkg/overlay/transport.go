@@ -0,0 +1,61 @@
+package overlay
+
+import (
+    "errors"
+
+    "github.com/libp2p/go-libp2p"
+    libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+    "github.com/libp2p/go-libp2p/p2p/transport/tcp"
+    "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+    libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// ErrNoTransports is returned when a node is created without any transport
+var ErrNoTransports = errors.New("no transports enabled")
+
+// TransportConfig selects the transports an overlay node listens on and
+// dials with. Browser-facing gateways can add WebSocket or WebTransport.
+type TransportConfig struct {
+    TCP          bool
+    QUIC         bool
+    WebSocket    bool
+    WebTransport bool
+}
+
+// DefaultTransportConfig returns the transports used by NewNode
+func DefaultTransportConfig() TransportConfig {
+    return TransportConfig{
+        TCP:  true,
+        QUIC: true,
+    }
+}
+
+// options returns the libp2p transport and listen options for the enabled
+// transports, each listening on an ephemeral port
+func (t TransportConfig) options() ([]libp2p.Option, error) {
+    var transports []libp2p.Option
+    var listenAddrs []multiaddr.Multiaddr
+
+    if t.TCP {
+        transports = append(transports, libp2p.Transport(tcp.NewTCPTransport))
+        listenAddrs = append(listenAddrs, mustMultiaddr("/ip4/0.0.0.0/tcp/0"))
+    }
+    if t.QUIC {
+        transports = append(transports, libp2p.Transport(libp2pquic.NewTransport))
+        listenAddrs = append(listenAddrs, mustMultiaddr("/ip4/0.0.0.0/udp/0/quic-v1"))
+    }
+    if t.WebSocket {
+        transports = append(transports, libp2p.Transport(websocket.New))
+        listenAddrs = append(listenAddrs, mustMultiaddr("/ip4/0.0.0.0/tcp/0/ws"))
+    }
+    if t.WebTransport {
+        transports = append(transports, libp2p.Transport(libp2pwebtransport.New))
+        listenAddrs = append(listenAddrs, mustMultiaddr("/ip4/0.0.0.0/udp/0/quic-v1/webtransport"))
+    }
+    if len(transports) == 0 {
+        return nil, ErrNoTransports
+    }
+
+    return append(transports, libp2p.ListenAddrs(listenAddrs...)), nil
+}
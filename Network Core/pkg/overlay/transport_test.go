@@ -0,0 +1,66 @@
+package overlay
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/identity"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestNodeTransports(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, err := NewNodeWithTransports(ctx, TransportConfig{QUIC: true, WebSocket: true})
+	if err != nil {
+		t.Fatalf("NewNodeWithTransports() error = %v", err)
+	}
+	defer node.Close()
+
+	var quic, ws bool
+	for _, addr := range node.host.Network().ListenAddresses() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+			continue // Relay listener
+		}
+		if _, err := addr.ValueForProtocol(multiaddr.P_WS); err == nil {
+			ws = true
+			continue
+		}
+		if _, err := addr.ValueForProtocol(multiaddr.P_QUIC_V1); err == nil {
+			quic = true
+			continue
+		}
+		t.Errorf("Unexpected listen address %s", addr)
+	}
+	if !quic || !ws {
+		t.Errorf("Listen addresses = %v, want QUIC and WebSocket", node.host.Network().ListenAddresses())
+	}
+
+	if _, err := NewNodeWithTransports(ctx, TransportConfig{}); !errors.Is(err, ErrNoTransports) {
+		t.Errorf("NewNodeWithTransports() error = %v, want %v", err, ErrNoTransports)
+	}
+}
+
+func TestNodeIdentity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	// Restarting with the same identity keeps the peer ID
+	for i := 0; i < 2; i++ {
+		node, err := NewNodeWithIdentity(ctx, DefaultTransportConfig(), id)
+		if err != nil {
+			t.Fatalf("NewNodeWithIdentity() error = %v", err)
+		}
+		if node.host.ID() != id.ID {
+			t.Errorf("Node ID = %s, want %s", node.host.ID(), id.ID)
+		}
+		node.Close()
+	}
+}
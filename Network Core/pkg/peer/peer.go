@@ -30,6 +30,7 @@ type PeerInfo struct {
     LastSeen    time.Time
     ChunkCount  int
     TotalChunks int64 // total size of all chunks in bytes
+    Reputation  int   // raised by good interactions, lowered by bad ones
     manager     *PeerManager
     mu          sync.RWMutex
 }
@@ -42,6 +43,8 @@ maxPeers     int
 maxChunks    int
 maxChunkSize int64
 }
+path      string // persistence file, empty when disabled
+persistMu sync.Mutex
 }
 
 // NewPeerManager creates a new peer manager with default limits
@@ -88,6 +91,7 @@ info.LastSeen = time.Now()
 info.mu.Unlock()
 }
 
+pm.save()
 return info, nil
 }
 
@@ -102,6 +106,7 @@ return nil, false
 // RemovePeer removes a peer from tracking
 func (pm *PeerManager) RemovePeer(id peer.ID) {
 pm.peers.Delete(id)
+pm.save()
 }
 
 // UpdatePeerState updates a peer's connection state
@@ -112,6 +117,7 @@ info.mu.Lock()
 info.State = state
 info.LastSeen = time.Now()
 info.mu.Unlock()
+pm.save()
 return true
 }
 return false
@@ -0,0 +1,162 @@
+package peer
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// savedPeer is the on-disk form of a tracked peer
+type savedPeer struct {
+    ID          peer.ID   `json:"id"`
+    Addrs       []string  `json:"addrs"`
+    Blocked     bool      `json:"blocked"`
+    Reputation  int       `json:"reputation"`
+    LastSeen    time.Time `json:"last_seen"`
+    ChunkCount  int       `json:"chunk_count"`
+    TotalChunks int64     `json:"total_chunks"`
+}
+
+// savedLimits is the on-disk form of the manager's limits
+type savedLimits struct {
+    MaxPeers     int   `json:"max_peers"`
+    MaxChunks    int   `json:"max_chunks"`
+    MaxChunkSize int64 `json:"max_chunk_size"`
+}
+
+// savedState is the on-disk form of the peer manager
+type savedState struct {
+    Limits savedLimits `json:"limits"`
+    Peers  []savedPeer `json:"peers"`
+}
+
+// EnablePersistence stores known peers, their reputation and the manager's
+// limits in path, restoring any state saved by a previous run. Restored
+// peers start out disconnected until Reconnect re-establishes them.
+func (pm *PeerManager) EnablePersistence(path string) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return fmt.Errorf("failed to create peer directory: %w", err)
+    }
+
+    data, err := os.ReadFile(path)
+    if err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("failed to read peers: %w", err)
+    }
+    if err == nil {
+        var state savedState
+        if err := json.Unmarshal(data, &state); err != nil {
+            return fmt.Errorf("failed to parse peers: %w", err)
+        }
+        pm.restore(&state)
+    }
+
+    pm.persistMu.Lock()
+    pm.path = path
+    pm.persistMu.Unlock()
+    return nil
+}
+
+// restore loads saved limits and peers, keeping the most reputable peers
+// when there are more than the peer limit allows
+func (pm *PeerManager) restore(state *savedState) {
+    if state.Limits.MaxPeers > 0 {
+        pm.SetLimits(state.Limits.MaxPeers, state.Limits.MaxChunks, state.Limits.MaxChunkSize)
+    }
+
+    sort.SliceStable(state.Peers, func(i, j int) bool {
+        return state.Peers[i].Reputation > state.Peers[j].Reputation
+    })
+    for i, saved := range state.Peers {
+        if i >= pm.limits.maxPeers {
+            break
+        }
+
+        addrs := make([]multiaddr.Multiaddr, 0, len(saved.Addrs))
+        for _, s := range saved.Addrs {
+            if addr, err := multiaddr.NewMultiaddr(s); err == nil {
+                addrs = append(addrs, addr)
+            }
+        }
+
+        pm.peers.Store(saved.ID, &PeerInfo{
+            ID:          saved.ID,
+            Addrs:       addrs,
+            State:       restoredState(saved.Blocked),
+            LastSeen:    saved.LastSeen,
+            ChunkCount:  saved.ChunkCount,
+            TotalChunks: saved.TotalChunks,
+            Reputation:  saved.Reputation,
+            manager:     pm,
+        })
+    }
+}
+
+// restoredState returns the state a restored peer starts in
+func restoredState(blocked bool) PeerState {
+    if blocked {
+        return PeerBlocked
+    }
+    return PeerDisconnected
+}
+
+// Save writes the known peers to the persistence path, if enabled
+func (pm *PeerManager) Save() error {
+    pm.persistMu.Lock()
+    defer pm.persistMu.Unlock()
+
+    if pm.path == "" {
+        return nil
+    }
+
+    state := savedState{
+        Limits: savedLimits{
+            MaxPeers:     pm.limits.maxPeers,
+            MaxChunks:    pm.limits.maxChunks,
+            MaxChunkSize: pm.limits.maxChunkSize,
+        },
+    }
+    for _, info := range pm.ListPeers() {
+        info.mu.RLock()
+        saved := savedPeer{
+            ID:          info.ID,
+            Addrs:       make([]string, len(info.Addrs)),
+            Blocked:     info.State == PeerBlocked,
+            Reputation:  info.Reputation,
+            LastSeen:    info.LastSeen,
+            ChunkCount:  info.ChunkCount,
+            TotalChunks: info.TotalChunks,
+        }
+        for i, addr := range info.Addrs {
+            saved.Addrs[i] = addr.String()
+        }
+        info.mu.RUnlock()
+        state.Peers = append(state.Peers, saved)
+    }
+
+    data, err := json.Marshal(&state)
+    if err != nil {
+        return fmt.Errorf("failed to encode peers: %w", err)
+    }
+    tmp := pm.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        return fmt.Errorf("failed to save peers: %w", err)
+    }
+    if err := os.Rename(tmp, pm.path); err != nil {
+        return fmt.Errorf("failed to save peers: %w", err)
+    }
+    return nil
+}
+
+// save persists state after a change, logging rather than failing the
+// caller's operation
+func (pm *PeerManager) save() {
+    if err := pm.Save(); err != nil {
+        fmt.Printf("%v\n", err)
+    }
+}
@@ -0,0 +1,130 @@
+package peer
+
+import (
+"context"
+"crypto/rand"
+"fmt"
+"path/filepath"
+"sync"
+"testing"
+"time"
+
+"github.com/libp2p/go-libp2p/core/crypto"
+"github.com/libp2p/go-libp2p/core/peer"
+"github.com/stretchr/testify/assert"
+"github.com/stretchr/testify/require"
+)
+
+// newKeyedID returns a real peer ID, which unlike createTestID survives a
+// JSON round trip
+func newKeyedID(t *testing.T) peer.ID {
+priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+require.NoError(t, err)
+id, err := peer.IDFromPrivateKey(priv)
+require.NoError(t, err)
+return id
+}
+
+func TestPeerPersistence(t *testing.T) {
+path := filepath.Join(t.TempDir(), "peers.json")
+
+pm := NewPeerManager()
+require.NoError(t, pm.EnablePersistence(path))
+pm.SetLimits(2, 10, 1024)
+
+good, bad, blocked := newKeyedID(t), newKeyedID(t), newKeyedID(t)
+_, err := pm.AddPeer(good, createTestAddrs(9001))
+require.NoError(t, err)
+_, err = pm.AddPeer(bad, createTestAddrs(9002))
+require.NoError(t, err)
+_, err = pm.AddPeer(blocked, createTestAddrs(9003))
+require.NoError(t, err)
+pm.AdjustReputation(good, 5)
+pm.AdjustReputation(bad, -5)
+pm.AdjustReputation(blocked, 1)
+pm.UpdatePeerState(blocked, PeerBlocked)
+
+// A restarted manager restores limits and the most reputable peers
+restored := NewPeerManager()
+require.NoError(t, restored.EnablePersistence(path))
+assert.Equal(t, 2, restored.limits.maxPeers)
+assert.Equal(t, int64(1024), restored.limits.maxChunkSize)
+assert.Equal(t, 2, restored.CountPeers())
+
+info, ok := restored.GetPeer(good)
+require.True(t, ok)
+assert.Equal(t, PeerDisconnected, info.GetState())
+assert.Equal(t, 5, info.Reputation)
+assert.Equal(t, createTestAddrs(9001), info.Addrs)
+
+info, ok = restored.GetPeer(blocked)
+require.True(t, ok)
+assert.Equal(t, PeerBlocked, info.GetState())
+
+_, ok = restored.GetPeer(bad)
+assert.False(t, ok)
+
+// Only disconnected peers in good standing are reconnected
+reliable := restored.ReliablePeers()
+require.Len(t, reliable, 1)
+assert.Equal(t, good, reliable[0].ID)
+}
+
+type flakyDialer struct {
+failures int
+mu       sync.Mutex
+attempts map[peer.ID]int
+}
+
+func (d *flakyDialer) Connect(ctx context.Context, pi peer.AddrInfo) error {
+d.mu.Lock()
+defer d.mu.Unlock()
+d.attempts[pi.ID]++
+if d.attempts[pi.ID] <= d.failures {
+return fmt.Errorf("dial failed")
+}
+return nil
+}
+
+func TestReconnectBackoff(t *testing.T) {
+pm := NewPeerManager()
+for i := 1; i <= 3; i++ {
+_, err := pm.AddPeer(createTestID(i), createTestAddrs(9000+i))
+require.NoError(t, err)
+pm.UpdatePeerState(createTestID(i), PeerDisconnected)
+}
+pm.AdjustReputation(createTestID(3), -1)
+
+cfg := ReconnectConfig{
+InitialBackoff: time.Millisecond,
+MaxBackoff:     4 * time.Millisecond,
+MaxAttempts:    5,
+DialTimeout:    time.Second,
+}
+d := &flakyDialer{failures: 2, attempts: make(map[peer.ID]int)}
+
+select {
+case <-pm.Reconnect(context.Background(), d, cfg):
+case <-time.After(5 * time.Second):
+t.Fatal("reconnect did not finish")
+}
+
+for i := 1; i <= 2; i++ {
+info, _ := pm.GetPeer(createTestID(i))
+assert.Equal(t, PeerConnected, info.GetState())
+assert.Equal(t, 3, d.attempts[createTestID(i)])
+}
+
+// Peers with poor reputation are left alone
+info, _ := pm.GetPeer(createTestID(3))
+assert.Equal(t, PeerDisconnected, info.GetState())
+assert.Zero(t, d.attempts[createTestID(3)])
+
+// Attempts stop once the limit is reached
+pm.UpdatePeerState(createTestID(1), PeerDisconnected)
+d = &flakyDialer{failures: 100, attempts: make(map[peer.ID]int)}
+<-pm.Reconnect(context.Background(), d, cfg)
+assert.Equal(t, cfg.MaxAttempts, d.attempts[createTestID(1)])
+info, _ = pm.GetPeer(createTestID(1))
+assert.Equal(t, PeerDisconnected, info.GetState())
+}
@@ -0,0 +1,133 @@
+package peer
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Dialer connects to a peer. libp2p hosts satisfy this interface.
+type Dialer interface {
+    Connect(ctx context.Context, pi peer.AddrInfo) error
+}
+
+// ReconnectConfig controls the reconnect backoff
+type ReconnectConfig struct {
+    InitialBackoff time.Duration // Delay after the first failed attempt
+    MaxBackoff     time.Duration // Upper bound for the doubling delay
+    MaxAttempts    int           // Attempts per peer, 0 for unlimited
+    DialTimeout    time.Duration // Timeout for each connection attempt
+}
+
+// DefaultReconnectConfig returns the default reconnect backoff
+func DefaultReconnectConfig() ReconnectConfig {
+    return ReconnectConfig{
+        InitialBackoff: time.Second,
+        MaxBackoff:     5 * time.Minute,
+        MaxAttempts:    10,
+        DialTimeout:    30 * time.Second,
+    }
+}
+
+// MinReconnectReputation is the reputation a peer needs to be reconnected
+const MinReconnectReputation = 0
+
+// AdjustReputation changes a peer's reputation by delta
+func (pm *PeerManager) AdjustReputation(id peer.ID, delta int) bool {
+    value, ok := pm.peers.Load(id)
+    if !ok {
+        return false
+    }
+
+    info := value.(*PeerInfo)
+    info.mu.Lock()
+    info.Reputation += delta
+    info.mu.Unlock()
+    pm.save()
+    return true
+}
+
+// ReliablePeers returns disconnected peers with known addresses and good
+// reputation, most reputable first
+func (pm *PeerManager) ReliablePeers() []*PeerInfo {
+    var peers []*PeerInfo
+    reputation := make(map[peer.ID]int)
+    for _, info := range pm.ListPeers() {
+        info.mu.RLock()
+        reliable := info.State == PeerDisconnected &&
+            len(info.Addrs) > 0 &&
+            info.Reputation >= MinReconnectReputation
+        reputation[info.ID] = info.Reputation
+        info.mu.RUnlock()
+        if reliable {
+            peers = append(peers, info)
+        }
+    }
+
+    sort.Slice(peers, func(i, j int) bool {
+        return reputation[peers[i].ID] > reputation[peers[j].ID]
+    })
+    return peers
+}
+
+// Reconnect dials previously reliable peers in the background, retrying
+// each with exponential backoff until it connects, runs out of attempts or
+// ctx is cancelled. The returned channel is closed once all attempts end.
+func (pm *PeerManager) Reconnect(ctx context.Context, d Dialer, cfg ReconnectConfig) <-chan struct{} {
+    done := make(chan struct{})
+    var wg sync.WaitGroup
+
+    for _, info := range pm.ReliablePeers() {
+        wg.Add(1)
+        go func(info *PeerInfo) {
+            defer wg.Done()
+            pm.reconnectPeer(ctx, d, cfg, info)
+        }(info)
+    }
+
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
+    return done
+}
+
+// reconnectPeer retries a single peer with exponential backoff
+func (pm *PeerManager) reconnectPeer(ctx context.Context, d Dialer, cfg ReconnectConfig, info *PeerInfo) {
+    if cfg.DialTimeout <= 0 {
+        cfg.DialTimeout = DefaultReconnectConfig().DialTimeout
+    }
+
+    info.mu.RLock()
+    target := peer.AddrInfo{ID: info.ID, Addrs: info.Addrs}
+    info.mu.RUnlock()
+
+    backoff := cfg.InitialBackoff
+    for attempt := 1; cfg.MaxAttempts == 0 || attempt <= cfg.MaxAttempts; attempt++ {
+        // Another path may have connected or blocked the peer meanwhile
+        if info.GetState() != PeerDisconnected {
+            return
+        }
+
+        dialCtx, cancel := context.WithTimeout(ctx, cfg.DialTimeout)
+        err := d.Connect(dialCtx, target)
+        cancel()
+        if err == nil {
+            pm.UpdatePeerState(info.ID, PeerConnected)
+            return
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        backoff *= 2
+        if backoff > cfg.MaxBackoff {
+            backoff = cfg.MaxBackoff
+        }
+    }
+}
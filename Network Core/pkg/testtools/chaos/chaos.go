@@ -0,0 +1,174 @@
+// Package chaos injects network faults into libp2p hosts for tests.
+//
+// Wrap returns a host whose streams, opened or accepted, suffer the
+// configured latency, bandwidth cap and drops. Hosts wrapped with the same
+// Partitions can be split into groups that cannot reach each other, now or
+// on a schedule driven by a clock. Components under test see the faults
+// when they are given the wrapped host instead of the real one.
+//
+// Latency and bandwidth delays are real sleeps, so keep them small. Only
+// partition schedules follow the clock passed in, which may be a mock.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+var (
+	// ErrPartitioned is returned for streams to peers across a partition
+	ErrPartitioned = errors.New("peer is unreachable across a partition")
+	// ErrDropped is returned when a write is dropped, resetting its stream
+	ErrDropped = errors.New("stream dropped by fault injection")
+)
+
+// Faults describes how badly a host's streams behave
+type Faults struct {
+	DropRate  float64       // Chance, from 0 to 1, that a write resets its stream
+	Latency   time.Duration // Delay before each write
+	Jitter    time.Duration // Random extra delay of up to this much
+	Bandwidth int64         // Bytes per second each stream may write, 0 for no cap
+	Seed      int64         // Seeds drops and jitter so runs can be repeated
+}
+
+// Host is a libp2p host whose streams suffer injected faults
+type Host struct {
+	host.Host
+
+	partitions *Partitions
+	mu         sync.Mutex
+	faults     Faults
+	rng        *rand.Rand
+}
+
+// Wrap injects faults into h's streams. partitions may be nil if the host
+// is never partitioned.
+func Wrap(h host.Host, partitions *Partitions) *Host {
+	wrapped := &Host{Host: h, partitions: partitions, rng: rand.New(rand.NewSource(0))}
+	if partitions != nil {
+		partitions.register(wrapped)
+	}
+	return wrapped
+}
+
+// SetFaults replaces the faults injected from now on
+func (h *Host) SetFaults(f Faults) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.faults = f
+	h.rng = rand.New(rand.NewSource(f.Seed))
+}
+
+// Faults returns the faults currently injected
+func (h *Host) Faults() Faults {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.faults
+}
+
+// reachable reports whether the host may talk to p
+func (h *Host) reachable(p peer.ID) bool {
+	return h.partitions == nil || h.partitions.Reachable(h.ID(), p)
+}
+
+// Connect refuses peers across a partition
+func (h *Host) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	if !h.reachable(pi.ID) {
+		return fmt.Errorf("%w: %s", ErrPartitioned, pi.ID)
+	}
+	return h.Host.Connect(ctx, pi)
+}
+
+// NewStream opens a stream that suffers the host's faults
+func (h *Host) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if !h.reachable(p) {
+		return nil, fmt.Errorf("%w: %s", ErrPartitioned, p)
+	}
+	s, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return &stream{Stream: s, host: h}, nil
+}
+
+// SetStreamHandler hands the handler streams that suffer the host's faults
+func (h *Host) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, h.wrapHandler(handler))
+}
+
+// SetStreamHandlerMatch hands the handler streams that suffer the host's
+// faults
+func (h *Host) SetStreamHandlerMatch(pid protocol.ID, match func(protocol.ID) bool, handler network.StreamHandler) {
+	h.Host.SetStreamHandlerMatch(pid, match, h.wrapHandler(handler))
+}
+
+// wrapHandler resets incoming streams from across a partition and wraps
+// the rest
+func (h *Host) wrapHandler(handler network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		if !h.reachable(s.Conn().RemotePeer()) {
+			s.Reset()
+			return
+		}
+		handler(&stream{Stream: s, host: h})
+	}
+}
+
+// beforeWrite delays a write of n bytes to p as the faults dictate and
+// reports whether it is dropped or partitioned
+func (h *Host) beforeWrite(p peer.ID, n int) error {
+	if !h.reachable(p) {
+		return fmt.Errorf("%w: %s", ErrPartitioned, p)
+	}
+
+	h.mu.Lock()
+	f := h.faults
+	dropped := f.DropRate > 0 && h.rng.Float64() < f.DropRate
+	delay := f.Latency
+	if f.Jitter > 0 {
+		delay += time.Duration(h.rng.Int63n(int64(f.Jitter)))
+	}
+	h.mu.Unlock()
+
+	if f.Bandwidth > 0 {
+		delay += time.Duration(float64(n) / float64(f.Bandwidth) * float64(time.Second))
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if dropped {
+		return ErrDropped
+	}
+	return nil
+}
+
+// stream is a stream suffering its host's faults
+type stream struct {
+	network.Stream
+	host *Host
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	if !s.host.reachable(s.Conn().RemotePeer()) {
+		s.Stream.Reset()
+		return 0, fmt.Errorf("%w: %s", ErrPartitioned, s.Conn().RemotePeer())
+	}
+	return s.Stream.Read(p)
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	if err := s.host.beforeWrite(s.Conn().RemotePeer(), len(p)); err != nil {
+		s.Stream.Reset()
+		return 0, err
+	}
+	return s.Stream.Write(p)
+}
@@ -0,0 +1,139 @@
+package chaos
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const echoProtocol = "/chaos-test/echo/1.0.0"
+
+// newPair returns two connected wrapped hosts sharing partitions. The
+// second echoes whatever it is sent.
+func newPair(t *testing.T) (*Host, *Host, *Partitions) {
+	t.Helper()
+	partitions := NewPartitions()
+	var hosts []*Host
+	for i := 0; i < 2; i++ {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		hosts = append(hosts, Wrap(h, partitions))
+	}
+	a, b := hosts[0], hosts[1]
+	b.SetStreamHandler(echoProtocol, func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	require.NoError(t, a.Connect(context.Background(), peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}))
+	return a, b, partitions
+}
+
+// echo sends msg to b and reads it back
+func echo(a, b *Host, msg []byte) ([]byte, error) {
+	s, err := a.NewStream(context.Background(), b.ID(), echoProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	if _, err := s.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := s.CloseWrite(); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(s)
+}
+
+func TestNoFaults(t *testing.T) {
+	a, b, _ := newPair(t)
+	got, err := echo(a, b, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+}
+
+func TestLatency(t *testing.T) {
+	a, b, _ := newPair(t)
+	a.SetFaults(Faults{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	b.SetFaults(Faults{Latency: 50 * time.Millisecond})
+
+	start := time.Now()
+	got, err := echo(a, b, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestBandwidth(t *testing.T) {
+	a, b, _ := newPair(t)
+	a.SetFaults(Faults{Bandwidth: 10 * 1024})
+
+	start := time.Now()
+	got, err := echo(a, b, make([]byte, 2*1024))
+	require.NoError(t, err)
+	assert.Len(t, got, 2*1024)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestDropRate(t *testing.T) {
+	a, b, _ := newPair(t)
+	a.SetFaults(Faults{DropRate: 1})
+	_, err := echo(a, b, []byte("hello"))
+	assert.ErrorIs(t, err, ErrDropped)
+
+	a.SetFaults(Faults{})
+	got, err := echo(a, b, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+}
+
+func TestPartition(t *testing.T) {
+	a, b, partitions := newPair(t)
+	partitions.Split([]peer.ID{a.ID()}, []peer.ID{b.ID()})
+
+	assert.False(t, partitions.Reachable(a.ID(), b.ID()))
+	assert.Empty(t, a.Network().ConnsToPeer(b.ID()))
+	_, err := echo(a, b, []byte("hello"))
+	assert.ErrorIs(t, err, ErrPartitioned)
+	err = a.Connect(context.Background(), peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()})
+	assert.ErrorIs(t, err, ErrPartitioned)
+
+	partitions.Heal()
+	require.NoError(t, a.Connect(context.Background(), peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}))
+	got, err := echo(a, b, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+}
+
+func TestPartitionLeavesOthersReachable(t *testing.T) {
+	partitions := NewPartitions()
+	a, b, c := peer.ID("a"), peer.ID("b"), peer.ID("c")
+	partitions.Split([]peer.ID{a}, []peer.ID{b})
+
+	assert.False(t, partitions.Reachable(a, b))
+	assert.True(t, partitions.Reachable(a, a))
+	assert.True(t, partitions.Reachable(a, c))
+	assert.True(t, partitions.Reachable(c, b))
+}
+
+func TestSchedule(t *testing.T) {
+	partitions := NewPartitions()
+	clk := clock.NewMock()
+	a, b := peer.ID("a"), peer.ID("b")
+	partitions.Schedule(clk, time.Minute, time.Minute, []peer.ID{a}, []peer.ID{b})
+
+	// The mock clock runs timer functions in the background
+	assert.True(t, partitions.Reachable(a, b))
+	clk.Add(time.Minute)
+	assert.Eventually(t, func() bool { return !partitions.Reachable(a, b) }, time.Second, time.Millisecond)
+	clk.Add(time.Minute)
+	assert.Eventually(t, func() bool { return partitions.Reachable(a, b) }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,84 @@
+package chaos
+
+import (
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Partitions splits a set of wrapped hosts into groups that cannot reach
+// each other. Peers not placed in any group reach everyone.
+type Partitions struct {
+	mu     sync.RWMutex
+	groups map[peer.ID]int
+	hosts  []*Host
+}
+
+// NewPartitions returns partitions with every peer reachable
+func NewPartitions() *Partitions {
+	return &Partitions{}
+}
+
+// register adds a host whose connections are cut when a split separates
+// it from its peers
+func (p *Partitions) register(h *Host) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts = append(p.hosts, h)
+}
+
+// Split places each list of peers in a group of its own. Streams and
+// connections between groups are cut until Heal.
+func (p *Partitions) Split(groups ...[]peer.ID) {
+	p.mu.Lock()
+	p.groups = make(map[peer.ID]int)
+	for i, group := range groups {
+		for _, id := range group {
+			p.groups[id] = i
+		}
+	}
+	hosts := append([]*Host(nil), p.hosts...)
+	p.mu.Unlock()
+
+	for _, h := range hosts {
+		for _, remote := range h.Network().Peers() {
+			if !p.Reachable(h.ID(), remote) {
+				h.Network().ClosePeer(remote)
+			}
+		}
+	}
+}
+
+// Heal makes every peer reachable again. Peers reconnect the next time they
+// dial each other.
+func (p *Partitions) Heal() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.groups = nil
+}
+
+// Reachable reports whether a and b are on the same side of the partition
+func (p *Partitions) Reachable(a, b peer.ID) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ga, okA := p.groups[a]
+	gb, okB := p.groups[b]
+	return !okA || !okB || ga == gb
+}
+
+// Schedule splits the peers into groups once after has passed on clk and
+// heals the split duration later. Both timers are set now, so advancing a
+// mock clock past either is enough to trigger it.
+func (p *Partitions) Schedule(clk clock.Clock, after, duration time.Duration, groups ...[]peer.ID) {
+	split := make(chan struct{})
+	clk.AfterFunc(after, func() {
+		p.Split(groups...)
+		close(split)
+	})
+	clk.AfterFunc(after+duration, func() {
+		<-split
+		p.Heal()
+	})
+}
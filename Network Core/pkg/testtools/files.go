@@ -0,0 +1,154 @@
+package testtools
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Split cuts data into chunks of chunkSize bytes, named by the hex SHA-256
+// of their content, and returns the chunk hashes in file order
+func Split(data []byte, chunkSize int) ([]string, map[string][]byte) {
+	var hashes []string
+	chunks := make(map[string][]byte)
+	for start := 0; start < len(data); start += chunkSize {
+		end := start + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[start:end]
+		hash := chunkHash(chunk)
+		hashes = append(hashes, hash)
+		chunks[hash] = chunk
+	}
+	return hashes, chunks
+}
+
+// Reconstruct joins chunks back into the file, checking each against its
+// hash
+func Reconstruct(hashes []string, chunks map[string][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, hash := range hashes {
+		chunk, ok := chunks[hash]
+		if !ok {
+			return nil, fmt.Errorf("missing chunk %s", hash)
+		}
+		if chunkHash(chunk) != hash {
+			return nil, fmt.Errorf("chunk %s is corrupt", hash)
+		}
+		buf.Write(chunk)
+	}
+	return buf.Bytes(), nil
+}
+
+// chunkHash names a chunk after its content
+func chunkHash(chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+	return hex.EncodeToString(sum[:])
+}
+
+// Upload splits data into chunks, places goal replicas of each on storage
+// nodes and publishes the file's manifest. The node keeps its own copy of
+// the chunks, as an uploading client does.
+func (n *Node) Upload(ctx context.Context, name string, data []byte, chunkSize, goal int) (*network.ManifestInfo, *network.UploadResult, error) {
+	hashes, chunks := Split(data, chunkSize)
+	for hash, chunk := range chunks {
+		if !n.Chunks.Store(hash, chunk) {
+			return nil, nil, fmt.Errorf("failed to store chunk %s", hash)
+		}
+	}
+
+	scheduler := network.NewUploadScheduler(n.transfers, n.Gossip, n.ID(), network.DefaultUploadWorkers)
+	result, err := scheduler.Place(ctx, goal, chunks)
+	if err != nil {
+		return nil, result, err
+	}
+
+	manifest := &network.ManifestInfo{
+		Name:            name,
+		Owner:           n.ID().String(),
+		ChunkHashes:     hashes,
+		Size:            int64(len(data)),
+		Created:         n.clock.Now(),
+		Modified:        n.clock.Now(),
+		ReplicationGoal: goal,
+	}
+	if err := n.Manifests.AddManifest(manifest); err != nil {
+		return nil, result, fmt.Errorf("failed to publish manifest: %w", err)
+	}
+	return manifest, result, nil
+}
+
+// Download looks the file's manifest up in the DHT, walks its chunk list
+// pages if it has any, asks the connected peers which of its chunks they
+// hold, fetches each chunk it does not hold from a peer that has it, those
+// held by the fewest peers first, and reconstructs the file
+func (n *Node) Download(ctx context.Context, name string) ([]byte, error) {
+	manifest, err := n.Manifests.GetManifest(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find manifest: %w", err)
+	}
+
+	hashes, err := n.Manifests.ChunkHashes(ctx, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+
+	var missing []string
+	for _, hash := range hashes {
+		if _, ok := n.Chunks.Get(hash); !ok {
+			missing = append(missing, hash)
+		}
+	}
+	located := n.transfers.LocateChunks(ctx, n.Host.Network().Peers(), missing)
+	sort.SliceStable(missing, func(i, j int) bool {
+		return len(located[missing[i]]) < len(located[missing[j]])
+	})
+
+	chunks := make(map[string][]byte)
+	for _, hash := range append(missing, hashes...) {
+		if _, ok := chunks[hash]; ok {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		chunk, err := n.fetch(hash, located[hash])
+		if err != nil {
+			return nil, err
+		}
+		chunks[hash] = chunk
+	}
+	return Reconstruct(hashes, chunks)
+}
+
+// fetch returns a chunk from the local store or the first peer serving an
+// intact copy, trying the peers known to hold it before the others
+func (n *Node) fetch(hash string, holders []peer.ID) ([]byte, error) {
+	if chunk, ok := n.Chunks.Get(hash); ok {
+		return chunk, nil
+	}
+	tried := make(map[peer.ID]bool)
+	for _, p := range append(holders, n.Host.Network().Peers()...) {
+		if tried[p] {
+			continue
+		}
+		tried[p] = true
+		chunk, err := n.transfers.Download(p, hash)
+		if err == nil && chunkHash(chunk) == hash {
+			return chunk, nil
+		}
+	}
+	return nil, fmt.Errorf("no peer serves chunk %s", hash)
+}
+
+// storageConstraints accepts any storage node
+func storageConstraints() network.StorageConstraints {
+	return network.StorageConstraints{}
+}
@@ -0,0 +1,191 @@
+package testtools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/network"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/testtools/chaos"
+)
+
+// Node is one member of a test network. Its components share a host that
+// injects the network's faults and partitions.
+type Node struct {
+	Host      host.Host
+	DHT       *dht.IpfsDHT
+	PubSub    *pubsub.PubSub
+	Gossip    network.GossipManager
+	Chunks    *network.ChunkStore
+	Manifests *network.ManifestManager
+
+	chaos     *chaos.Host
+	clock     *clock.Mock
+	transfers *network.TransferManager
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	storing   bool
+	stopped   bool
+}
+
+// newNode creates a node listening on loopback. Its services are started
+// by start once it is connected.
+func newNode(ctx context.Context, clk *clock.Mock, partitions *chaos.Partitions, faults chaos.Faults) (*Node, error) {
+	raw, err := libp2p.New(
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.DefaultTransports,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host: %w", err)
+	}
+	h := chaos.Wrap(raw, partitions)
+	h.SetFaults(faults)
+
+	ctx, cancel := context.WithCancel(ctx)
+	kdht, err := dht.New(ctx, h, dht.Mode(dht.ModeServer), dht.ProtocolPrefix("/filezap"))
+	if err != nil {
+		cancel()
+		h.Close()
+		return nil, fmt.Errorf("failed to create DHT: %w", err)
+	}
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		cancel()
+		kdht.Close()
+		h.Close()
+		return nil, fmt.Errorf("failed to create pubsub: %w", err)
+	}
+
+	return &Node{
+		Host:      h,
+		DHT:       kdht,
+		PubSub:    ps,
+		chaos:     h,
+		Chunks:    network.NewChunkStore(h),
+		clock:     clk,
+		transfers: network.NewTransferManager(h),
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// start brings up gossip and manifests the way the engine wires them
+func (n *Node) start() error {
+	if err := n.DHT.Bootstrap(n.ctx); err != nil {
+		return fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+
+	gm, err := network.NewGossipManager(n.ctx, n.Host, n.PubSub)
+	if err != nil {
+		return fmt.Errorf("failed to create gossip manager: %w", err)
+	}
+	n.Gossip = gm
+	n.Chunks.RegisterGossip(gm)
+
+	mm, err := network.NewManifestManager(n.ctx, n.Host, n.DHT, n.PubSub)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest manager: %w", err)
+	}
+	mm.RegisterGossip(gm)
+	mm.RegisterChunkStore(n.Chunks)
+	n.Manifests = mm
+	return nil
+}
+
+// ID returns the node's peer ID
+func (n *Node) ID() peer.ID {
+	return n.Host.ID()
+}
+
+// Connect dials another node
+func (n *Node) Connect(ctx context.Context, other *Node) error {
+	return n.Host.Connect(ctx, peer.AddrInfo{ID: other.ID(), Addrs: other.Host.Addrs()})
+}
+
+// SetFaults changes the faults injected into the node's streams
+func (n *Node) SetFaults(f chaos.Faults) {
+	n.chaos.SetFaults(f)
+}
+
+// StartStorage makes the node a storage node. On every StorageInterval of
+// the mock clock it stores the chunks queued with it and re-announces its
+// offer.
+func (n *Node) StartStorage() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return errors.New("node is stopped")
+	}
+	if n.storing {
+		return nil
+	}
+	if err := n.announce(); err != nil {
+		return err
+	}
+	n.storing = true
+
+	ticker := n.clock.Ticker(StorageInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.ctx.Done():
+				return
+			case <-ticker.C:
+				n.Chunks.ProcessPending()
+				n.announce()
+			}
+		}
+	}()
+	return nil
+}
+
+// announce gossips the node's storage offer
+func (n *Node) announce() error {
+	offer := network.DefaultStorageConfig()
+	return n.Gossip.AnnounceStorageNode(&network.StorageNodeInfo{
+		ID:             n.ID().String(),
+		AvailableSpace: n.Chunks.FreeSpace(),
+		TotalSpace:     offer.Quota,
+		Uptime:         100,
+		Quota:          offer.Quota,
+		MinChunkSize:   offer.MinChunkSize,
+		MaxChunkSize:   offer.MaxChunkSize,
+	})
+}
+
+// Holds reports whether the node stores a chunk
+func (n *Node) Holds(hash string) bool {
+	_, ok := n.Chunks.Get(hash)
+	return ok
+}
+
+// Stop shuts the node down. Its peers notice the way they would notice a
+// crash: connections drop and requests fail.
+func (n *Node) Stop() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil
+	}
+	n.stopped = true
+	n.cancel()
+	n.DHT.Close()
+	return n.Host.Close()
+}
+
+// Stopped reports whether the node has been stopped
+func (n *Node) Stopped() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stopped
+}
@@ -0,0 +1,251 @@
+// Package testtools runs networks of FileZap nodes in-process for
+// integration tests.
+//
+// Each Node is built from the same components as a NetworkEngine: a libp2p
+// host, a DHT and pubsub, the gossip manager, a chunk store and a manifest
+// manager. Nodes listen on loopback and are dialled according to a
+// Topology. The work storage nodes do in the background, storing queued
+// chunks and re-announcing their offer, runs on a mock clock, so tests
+// decide when it happens by advancing the clock.
+//
+// Every node's host is wrapped by the chaos package, so tests can slow,
+// drop and partition the network to see how replication and transfers
+// cope.
+package testtools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/testtools/chaos"
+)
+
+const (
+	// StorageInterval is how often, on the mock clock, storage nodes store
+	// the chunks queued with them and re-announce their offer
+	StorageInterval = time.Second
+
+	// settleTimeout bounds waiting, in real time, for the network to react
+	settleTimeout = 20 * time.Second
+)
+
+// Topology reports whether node i dials node j in a network of n nodes
+type Topology func(i, j, n int) bool
+
+// FullMesh connects every node to every other node
+func FullMesh(i, j, n int) bool {
+	return i < j
+}
+
+// Ring connects each node to the next, and the last to the first
+func Ring(i, j, n int) bool {
+	return j == (i+1)%n && i != j
+}
+
+// Star connects every node to the first
+func Star(i, j, n int) bool {
+	return i == 0 && j > 0
+}
+
+// Line connects each node to the next
+func Line(i, j, n int) bool {
+	return j == i+1
+}
+
+// Options configures a test network
+type Options struct {
+	Nodes        int      // Nodes started with the network
+	StorageNodes []int    // Nodes serving storage from the start
+	Topology     Topology // FullMesh if nil
+	Clock        *clock.Mock
+	Faults       chaos.Faults // Faults injected into every node's streams
+}
+
+// Network is a set of in-process nodes sharing a mock clock
+type Network struct {
+	Clock      *clock.Mock
+	Nodes      []*Node
+	Partitions *chaos.Partitions
+
+	faults chaos.Faults
+	t      testing.TB
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+}
+
+// NewNetwork starts a network and connects its nodes. Everything is shut
+// down when the test ends.
+func NewNetwork(t testing.TB, opts Options) *Network {
+	t.Helper()
+	if opts.Topology == nil {
+		opts.Topology = FullMesh
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.NewMock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	net := &Network{
+		Clock:      opts.Clock,
+		Partitions: chaos.NewPartitions(),
+		faults:     opts.Faults,
+		t:          t,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	t.Cleanup(net.Close)
+
+	for i := 0; i < opts.Nodes; i++ {
+		node, err := newNode(ctx, net.Clock, net.Partitions, net.faults)
+		require.NoError(t, err, "failed to start node %d", i)
+		net.Nodes = append(net.Nodes, node)
+	}
+	for i, a := range net.Nodes {
+		for j, b := range net.Nodes {
+			if opts.Topology(i, j, len(net.Nodes)) {
+				require.NoError(t, a.Connect(ctx, b), "failed to connect node %d to node %d", i, j)
+			}
+		}
+	}
+
+	// The manifest manager waits for the DHT to find peers, so it is only
+	// started once the nodes are connected
+	var wg sync.WaitGroup
+	errs := make([]error, len(net.Nodes))
+	for i, node := range net.Nodes {
+		wg.Add(1)
+		go func(i int, node *Node) {
+			defer wg.Done()
+			errs[i] = node.start()
+		}(i, node)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		require.NoError(t, err, "failed to start services on node %d", i)
+	}
+
+	for _, i := range opts.StorageNodes {
+		require.NoError(t, net.Nodes[i].StartStorage())
+	}
+	return net
+}
+
+// AddNode starts a node that joins the network by dialling every running
+// node, as a node joining after churn would
+func (n *Network) AddNode() *Node {
+	n.t.Helper()
+	node, err := newNode(n.ctx, n.Clock, n.Partitions, n.faults)
+	require.NoError(n.t, err, "failed to start node")
+	for _, peer := range n.Running() {
+		require.NoError(n.t, node.Connect(n.ctx, peer), "failed to join network")
+	}
+	require.NoError(n.t, node.start(), "failed to start services")
+
+	n.mu.Lock()
+	n.Nodes = append(n.Nodes, node)
+	n.mu.Unlock()
+	return node
+}
+
+// Stop shuts a node down abruptly, as a node dropping out would
+func (n *Network) Stop(i int) {
+	n.t.Helper()
+	require.NoError(n.t, n.Nodes[i].Stop())
+}
+
+// Running returns the nodes that have not been stopped
+func (n *Network) Running() []*Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var running []*Node
+	for _, node := range n.Nodes {
+		if !node.Stopped() {
+			running = append(running, node)
+		}
+	}
+	return running
+}
+
+// Partition splits the nodes, by index, into groups that cannot reach each
+// other until Heal. Nodes left out of every group reach everyone.
+func (n *Network) Partition(groups ...[]int) {
+	n.Partitions.Split(n.peerGroups(groups)...)
+}
+
+// SchedulePartition partitions the nodes once after has passed on the mock
+// clock and heals the partition duration later
+func (n *Network) SchedulePartition(after, duration time.Duration, groups ...[]int) {
+	n.Partitions.Schedule(n.Clock, after, duration, n.peerGroups(groups)...)
+}
+
+// Heal ends any partition. Nodes reconnect when they next dial each other,
+// or straight away with Reconnect.
+func (n *Network) Heal() {
+	n.Partitions.Heal()
+}
+
+// Reconnect dials every pair of running nodes that are not connected, as
+// peer discovery would after a partition heals
+func (n *Network) Reconnect() {
+	n.t.Helper()
+	running := n.Running()
+	for i, a := range running {
+		for _, b := range running[i+1:] {
+			if len(a.Host.Network().ConnsToPeer(b.ID())) == 0 {
+				require.NoError(n.t, a.Connect(n.ctx, b), "failed to reconnect %s to %s", a.ID(), b.ID())
+			}
+		}
+	}
+}
+
+// peerGroups maps groups of node indexes to groups of peer IDs
+func (n *Network) peerGroups(groups [][]int) [][]peer.ID {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ids := make([][]peer.ID, len(groups))
+	for g, group := range groups {
+		for _, i := range group {
+			ids[g] = append(ids[g], n.Nodes[i].ID())
+		}
+	}
+	return ids
+}
+
+// Advance moves the mock clock forward, running the background work that
+// falls due
+func (n *Network) Advance(d time.Duration) {
+	n.Clock.Add(d)
+}
+
+// WaitForStorageNodes advances the clock until node knows of at least count
+// storage nodes
+func (n *Network) WaitForStorageNodes(node *Node, count int) {
+	n.t.Helper()
+	n.Eventually(func() bool {
+		n.Advance(StorageInterval)
+		return len(node.Gossip.SelectStorageNodes(count, storageConstraints())) >= count
+	}, "node %s knows fewer than %d storage nodes", node.ID(), count)
+}
+
+// Eventually waits in real time for a condition, failing the test with msg
+// if it does not hold in time
+func (n *Network) Eventually(condition func() bool, msg string, args ...interface{}) {
+	n.t.Helper()
+	require.Eventually(n.t, condition, settleTimeout, 50*time.Millisecond, fmt.Sprintf(msg, args...))
+}
+
+// Close stops every node
+func (n *Network) Close() {
+	for _, node := range n.Nodes {
+		node.Stop()
+	}
+	n.cancel()
+}
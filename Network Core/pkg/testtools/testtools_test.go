@@ -0,0 +1,55 @@
+package testtools
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitReconstruct(t *testing.T) {
+	data := bytes.Repeat([]byte("filezap "), 100)
+	hashes, chunks := Split(data, 64)
+	require.Len(t, hashes, 13)
+
+	joined, err := Reconstruct(hashes, chunks)
+	require.NoError(t, err)
+	assert.Equal(t, data, joined)
+
+	// A corrupt chunk is caught rather than joined
+	chunks[hashes[0]] = []byte("tampered")
+	_, err = Reconstruct(hashes, chunks)
+	assert.Error(t, err)
+	delete(chunks, hashes[0])
+	_, err = Reconstruct(hashes, chunks)
+	assert.Error(t, err)
+}
+
+func TestTopologies(t *testing.T) {
+	links := func(topology Topology, n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if topology(i, j, n) {
+					count++
+				}
+			}
+		}
+		return count
+	}
+	assert.Equal(t, 10, links(FullMesh, 5))
+	assert.Equal(t, 5, links(Ring, 5))
+	assert.Equal(t, 4, links(Star, 5))
+	assert.Equal(t, 4, links(Line, 5))
+}
+
+func TestNetworkStorage(t *testing.T) {
+	net := NewNetwork(t, Options{Nodes: 3, StorageNodes: []int{1, 2}, Topology: Line})
+	net.WaitForStorageNodes(net.Nodes[0], 2)
+
+	// Stopped nodes are no longer running
+	net.Stop(2)
+	assert.Len(t, net.Running(), 2)
+	assert.Error(t, net.Nodes[2].StartStorage())
+}
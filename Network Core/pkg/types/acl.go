@@ -0,0 +1,37 @@
+package types
+
+import (
+	"bytes"
+	"time"
+)
+
+// AccessList restricts a file to a group of clients. Validators refuse key
+// requests from clients it does not allow and storage nodes refuse to serve
+// them the file's chunks. Files without an access list are world-readable.
+type AccessList struct {
+	ClientIDs  []string `json:"client_ids,omitempty"`
+	PublicKeys [][]byte `json:"public_keys,omitempty"` // Marshalled public keys of allowed clients
+	Expires    int64    `json:"expires,omitempty"`     // Unix time access ends for everyone, zero for never
+}
+
+// Allows reports whether a client, identified by its ID or public key, may
+// access the file at now. A nil list allows everyone.
+func (a *AccessList) Allows(clientID string, publicKey []byte, now time.Time) bool {
+	if a == nil {
+		return true
+	}
+	if a.Expires != 0 && now.Unix() >= a.Expires {
+		return false
+	}
+	for _, id := range a.ClientIDs {
+		if clientID != "" && id == clientID {
+			return true
+		}
+	}
+	for _, key := range a.PublicKeys {
+		if len(publicKey) > 0 && bytes.Equal(key, publicKey) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessListAllows(t *testing.T) {
+	now := time.Unix(1000, 0)
+	acl := &AccessList{
+		ClientIDs:  []string{"alice"},
+		PublicKeys: [][]byte{[]byte("bob-key")},
+	}
+
+	tests := []struct {
+		name      string
+		acl       *AccessList
+		clientID  string
+		publicKey []byte
+		want      bool
+	}{
+		{name: "No list", acl: nil, clientID: "anyone", want: true},
+		{name: "Listed ID", acl: acl, clientID: "alice", want: true},
+		{name: "Listed key", acl: acl, clientID: "bob", publicKey: []byte("bob-key"), want: true},
+		{name: "Not listed", acl: acl, clientID: "carol", publicKey: []byte("carol-key"), want: false},
+		{name: "Empty identity", acl: &AccessList{ClientIDs: []string{""}}, want: false},
+		{name: "Expired", acl: &AccessList{ClientIDs: []string{"alice"}, Expires: 1000}, clientID: "alice", want: false},
+		{name: "Not yet expired", acl: &AccessList{ClientIDs: []string{"alice"}, Expires: 1001}, clientID: "alice", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.acl.Allows(tt.clientID, tt.publicKey, now); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.clientID, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package types
+
+import "github.com/VetheonGames/FileZap/NetworkCore/pkg/bloom"
+
+// Peers announce the chunks they host to validators incrementally. Every
+// announcement carries a sequence number that grows by one per change:
+//
+//   - A full sync (PeerChunkInfo with Seq set) replaces the peer's chunk
+//     set and sets its sequence.
+//   - A ChunkDelta lists the chunks added and removed since BaseSeq. A
+//     validator whose sequence for the peer is not BaseSeq refuses it, and
+//     the peer falls back to a full sync.
+//   - A ChunkSetSummary lets the validator reconcile its view without a
+//     full list: chunks the filter lacks are dropped, and a count or
+//     sequence mismatch asks the peer for a full sync.
+
+// BloomFalsePositive is the false positive rate of chunk set summaries
+const BloomFalsePositive = 0.01
+
+// ChunkDelta announces the chunks a peer gained and lost since BaseSeq
+type ChunkDelta struct {
+	PeerID  string   `json:"peer_id"`
+	Address string   `json:"address"`
+	BaseSeq uint64   `json:"base_seq"` // Sequence the delta applies on top of
+	Seq     uint64   `json:"seq"`      // Sequence after the delta
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ChunkSetSummary summarizes the chunks a peer hosts as of Seq
+type ChunkSetSummary struct {
+	PeerID string        `json:"peer_id"`
+	Seq    uint64        `json:"seq"`
+	Count  int           `json:"count"` // Number of chunks in the set
+	Filter *bloom.Filter `json:"filter"`
+}
+
+// SyncStatus answers an announcement the validator could not apply. The
+// peer should send a full sync.
+type SyncStatus struct {
+	Error string `json:"error"`
+	Seq   uint64 `json:"seq"` // Validator's sequence for the peer
+}
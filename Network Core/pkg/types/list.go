@@ -0,0 +1,126 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Listing page sizes
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// ListOptions selects a page of a registry listing
+type ListOptions struct {
+	Prefix string `json:"prefix,omitempty"` // Only entries whose name or ID starts with Prefix
+	Sort   string `json:"sort,omitempty"`   // Field to sort by, empty for the listing's default
+	Desc   bool   `json:"desc,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Limit  int    `json:"limit,omitempty"` // Zero means DefaultListLimit
+}
+
+// ChunkSummary describes a chunk in a chunk listing
+type ChunkSummary struct {
+	ID    string `json:"id"`
+	Peers int    `json:"peers"` // Number of peers hosting the chunk
+}
+
+// FileList is a page of registered files
+type FileList struct {
+	Files      []FileInfo `json:"files"`
+	Total      int        `json:"total"`                 // Matching files across all pages
+	NextOffset int        `json:"next_offset,omitempty"` // Zero on the last page
+}
+
+// ChunkList is a page of known chunks
+type ChunkList struct {
+	Chunks     []ChunkSummary `json:"chunks"`
+	Total      int            `json:"total"`
+	NextOffset int            `json:"next_offset,omitempty"`
+}
+
+// ParseListOptions reads list options from query parameters, as returned by
+// a request's QueryParam
+func ParseListOptions(param func(name string) string) (ListOptions, error) {
+	opts := ListOptions{
+		Prefix: param("prefix"),
+		Sort:   param("sort"),
+	}
+
+	switch order := param("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("invalid order %q", order)
+	}
+
+	var err error
+	if opts.Offset, err = parseCount(param("offset"), "offset"); err != nil {
+		return opts, err
+	}
+	if opts.Limit, err = parseCount(param("limit"), "limit"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// parseCount parses an optional non-negative query parameter
+func parseCount(value string, name string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// Query encodes the options as a query string, without the leading '?'
+func (o ListOptions) Query() string {
+	values := url.Values{}
+	if o.Prefix != "" {
+		values.Set("prefix", o.Prefix)
+	}
+	if o.Sort != "" {
+		values.Set("sort", o.Sort)
+	}
+	if o.Desc {
+		values.Set("order", "desc")
+	}
+	if o.Offset > 0 {
+		values.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if o.Limit > 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return values.Encode()
+}
+
+// Page returns the bounds of the selected page in a sorted listing of total
+// entries, and the offset of the following page or zero if there is none
+func (o ListOptions) Page(total int) (start, end, next int) {
+	limit := o.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	start = o.Offset
+	if start > total {
+		start = total
+	}
+	end = start + limit
+	if end > total {
+		end = total
+	}
+	if end < total {
+		next = end
+	}
+	return start, end, next
+}
@@ -0,0 +1,56 @@
+package types
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestListOptionsPage(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             ListOptions
+		total            int
+		start, end, next int
+	}{
+		{name: "Default limit", opts: ListOptions{}, total: 250, start: 0, end: 100, next: 100},
+		{name: "Last page", opts: ListOptions{Offset: 200, Limit: 100}, total: 250, start: 200, end: 250, next: 0},
+		{name: "Offset past end", opts: ListOptions{Offset: 300}, total: 250, start: 250, end: 250, next: 0},
+		{name: "Limit capped", opts: ListOptions{Limit: 5000}, total: 2000, start: 0, end: MaxListLimit, next: MaxListLimit},
+		{name: "Empty listing", opts: ListOptions{}, total: 0, start: 0, end: 0, next: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, next := tt.opts.Page(tt.total)
+			if start != tt.start || end != tt.end || next != tt.next {
+				t.Errorf("Page(%d) = %d, %d, %d, want %d, %d, %d", tt.total, start, end, next, tt.start, tt.end, tt.next)
+			}
+		})
+	}
+}
+
+func TestListOptionsQueryRoundTrip(t *testing.T) {
+	opts := ListOptions{Prefix: "movies/a b", Sort: "chunks", Desc: true, Offset: 20, Limit: 10}
+
+	values, err := url.ParseQuery(opts.Query())
+	if err != nil {
+		t.Fatalf("url.ParseQuery() error = %v", err)
+	}
+	got, err := ParseListOptions(values.Get)
+	if err != nil {
+		t.Fatalf("ParseListOptions() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, opts) {
+		t.Errorf("ParseListOptions() = %+v, want %+v", got, opts)
+	}
+}
+
+func TestParseListOptionsInvalid(t *testing.T) {
+	for _, query := range []string{"limit=-1", "offset=abc", "order=sideways"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseListOptions(values.Get); err == nil {
+			t.Errorf("ParseListOptions(%q) succeeded, want error", query)
+		}
+	}
+}
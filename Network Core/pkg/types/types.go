@@ -6,6 +6,11 @@ type PeerChunkInfo struct {
 	ChunkIDs  []string `json:"chunk_ids"`
 	Address   string   `json:"address"`
 	Available bool     `json:"available"`
+	Seq       uint64   `json:"seq,omitempty"` // Announcement sequence ChunkIDs is complete as of
+
+	// IdempotencyKey is shared by every delivery of the same registration,
+	// so validators apply it once
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 // FileInfo represents a registered .zap file
@@ -0,0 +1,196 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/bloom"
+	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
+)
+
+// The client announces the chunks it hosts as deltas against the last
+// announcement the validator accepted. The full list is sent on the first
+// announcement, every FullSyncInterval, and whenever the validator refuses
+// a delta. Between changes, a Bloom filter summary lets the validator find
+// and drop chunks it wrongly thinks we host.
+//
+// Full syncs are numbered from the wall clock, so a restarted client's
+// announcements still follow on from the ones before the restart.
+
+// Announcement intervals
+const (
+	FullSyncInterval = 30 * time.Minute
+	SummaryInterval  = 5 * time.Minute
+)
+
+// RegisterAvailableChunks informs the validator of chunks we have available.
+// Only the changes since the last announcement are sent, unless the
+// validator needs the full list.
+func (c *Client) RegisterAvailableChunks(chunks []string) error {
+	c.announceMu.Lock()
+	defer c.announceMu.Unlock()
+
+	if c.announced == nil || c.fullOnly || time.Since(c.lastSync) >= FullSyncInterval {
+		return c.syncChunks(chunks, 0)
+	}
+
+	added, removed := diffChunks(c.announced, chunks)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	delta := types.ChunkDelta{
+		PeerID:  c.network.GetNodeID(),
+		BaseSeq: c.chunkSeq,
+		Seq:     c.chunkSeq + 1,
+		Added:   added,
+		Removed: removed,
+	}
+
+	resp, err := c.network.SendRequest(c.validatorID, "POST", "/chunks/delta", delta)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		c.chunkSeq = delta.Seq
+		c.announced = chunkSet(chunks)
+		return nil
+	case 404:
+		// The validator only takes full lists
+		c.fullOnly = true
+		return c.syncChunks(chunks, 0)
+	case 409:
+		return c.syncChunks(chunks, resyncSeq(resp.Body))
+	default:
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+}
+
+// ReconcileChunks announces any changes to the chunks we host, then sends
+// the validator a summary of them so it can drop chunks it wrongly
+// associates with us. The full list is sent if the validator is behind.
+func (c *Client) ReconcileChunks(chunks []string) error {
+	if err := c.RegisterAvailableChunks(chunks); err != nil {
+		return err
+	}
+
+	c.announceMu.Lock()
+	defer c.announceMu.Unlock()
+
+	if c.fullOnly {
+		return nil
+	}
+	summary := types.ChunkSetSummary{
+		PeerID: c.network.GetNodeID(),
+		Seq:    c.chunkSeq,
+		Count:  len(chunks),
+		Filter: bloom.FromItems(chunks, types.BloomFalsePositive),
+	}
+
+	resp, err := c.network.SendRequest(c.validatorID, "POST", "/chunks/summary", summary)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 409:
+		return c.syncChunks(chunks, resyncSeq(resp.Body))
+	default:
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+}
+
+// MaintainChunkAnnouncements reconciles the chunks returned by list with
+// the validator every SummaryInterval until the client is closed
+func (c *Client) MaintainChunkAnnouncements(list func() []string) {
+	ticker := time.NewTicker(SummaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.ReconcileChunks(list()); err != nil {
+				log.Printf("Failed to announce chunks: %v", err)
+			}
+		}
+	}
+}
+
+// syncChunks sends the full chunk list under a sequence above both ours
+// and validatorSeq. c.announceMu must be held.
+func (c *Client) syncChunks(chunks []string, validatorSeq uint64) error {
+	seq := uint64(time.Now().UnixNano())
+	if seq <= c.chunkSeq {
+		seq = c.chunkSeq + 1
+	}
+	if seq <= validatorSeq {
+		seq = validatorSeq + 1
+	}
+
+	data := types.PeerChunkInfo{
+		PeerID:         c.network.GetNodeID(),
+		ChunkIDs:       chunks,
+		Available:      true,
+		Seq:            seq,
+		IdempotencyKey: fmt.Sprintf("sync-%d", seq),
+	}
+
+	resp, err := c.network.SendRequest(c.validatorID, "POST", "/chunks/register", data)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	c.chunkSeq = seq
+	c.announced = chunkSet(chunks)
+	c.lastSync = time.Now()
+	return nil
+}
+
+// resyncSeq reads the validator's sequence from a refused announcement
+func resyncSeq(body []byte) uint64 {
+	var status types.SyncStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return 0
+	}
+	return status.Seq
+}
+
+// chunkSet returns chunks as a set
+func chunkSet(chunks []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(chunks))
+	for _, chunk := range chunks {
+		set[chunk] = struct{}{}
+	}
+	return set
+}
+
+// diffChunks returns the chunks in current but not announced, and those
+// announced but no longer in current, both sorted
+func diffChunks(announced map[string]struct{}, current []string) ([]string, []string) {
+	now := chunkSet(current)
+	var added, removed []string
+	for chunk := range now {
+		if _, ok := announced[chunk]; !ok {
+			added = append(added, chunk)
+		}
+	}
+	for chunk := range announced {
+		if _, ok := now[chunk]; !ok {
+			removed = append(removed, chunk)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
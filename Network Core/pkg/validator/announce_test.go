@@ -0,0 +1,24 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffChunks(t *testing.T) {
+	announced := chunkSet([]string{"a", "b", "c"})
+
+	added, removed := diffChunks(announced, []string{"b", "d", "c", "e"})
+	assert.Equal(t, []string{"d", "e"}, added)
+	assert.Equal(t, []string{"a"}, removed)
+
+	added, removed = diffChunks(announced, []string{"c", "b", "a"})
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+func TestResyncSeq(t *testing.T) {
+	assert.Equal(t, uint64(42), resyncSeq([]byte(`{"error":"peer chunk set out of sync","seq":42}`)))
+	assert.Zero(t, resyncSeq([]byte(`not json`)))
+}
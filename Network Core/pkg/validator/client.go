@@ -2,10 +2,10 @@ package validator
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/VetheonGames/FileZap/NetworkCore/pkg/overlay"
@@ -14,14 +14,20 @@ import (
 
 // Client represents a validator network client
 type Client struct {
-	network         *overlay.NetworkAdapter
-	validatorID     string
-	clientID        string
-	connected       bool
-	storageDir      string
-	availableChunks []string
-	ctx             context.Context
-	cancel          context.CancelFunc
+	network     *overlay.NetworkAdapter
+	validatorID string
+	clientID    string
+	connected   bool
+	storageDir  string
+	ctx         context.Context
+	cancel      context.CancelFunc
+
+	// Chunk announcements, see announce.go
+	announceMu sync.Mutex
+	chunkSeq   uint64              // Sequence of the last announcement accepted
+	announced  map[string]struct{} // Chunks the validator knows we host
+	lastSync   time.Time           // When the full list was last sent
+	fullOnly   bool                // The validator does not take deltas
 }
 
 // ZapFileInfo represents detailed information about a .zap file
@@ -43,11 +49,12 @@ func NewClient(validatorID string) (*Client, error) {
 		return nil, fmt.Errorf("failed to create network adapter: %v", err)
 	}
 
-	clientID := generateClientID()
+	// Validators only accept requests on behalf of the peer that signed
+	// them, so the client is identified by its node ID
 	return &Client{
 		network:     network,
 		validatorID: validatorID,
-		clientID:    clientID,
+		clientID:    network.GetNodeID(),
 		connected:   false,
 		ctx:         ctx,
 		cancel:      cancel,
@@ -60,15 +67,6 @@ func (c *Client) Close() error {
 	return c.network.Close()
 }
 
-// generateClientID creates a unique client identifier
-func generateClientID() string {
-	id := make([]byte, 16)
-	if _, err := rand.Read(id); err != nil {
-		return fmt.Sprintf("client-%d", time.Now().UnixNano())
-	}
-	return fmt.Sprintf("client-%x", id)
-}
-
 // RequestZapFile requests information about a .zap file from the validator
 func (c *Client) RequestZapFile(fileName string) (*types.FileInfo, error) {
 	resp, err := c.network.SendRequest(c.validatorID, "GET", fmt.Sprintf("/file/info/%s", fileName), nil)
@@ -88,6 +86,45 @@ func (c *Client) RequestZapFile(fileName string) (*types.FileInfo, error) {
 	return &fileInfo, nil
 }
 
+// ListFiles requests a page of the files registered with the validator
+func (c *Client) ListFiles(opts types.ListOptions) (*types.FileList, error) {
+	var list types.FileList
+	if err := c.list("/file/list", opts, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ListChunks requests a page of the chunks known to the validator
+func (c *Client) ListChunks(opts types.ListOptions) (*types.ChunkList, error) {
+	var list types.ChunkList
+	if err := c.list("/chunks/list", opts, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (c *Client) list(path string, opts types.ListOptions, list interface{}) error {
+	if query := opts.Query(); query != "" {
+		path += "?" + query
+	}
+
+	resp, err := c.network.SendRequest(c.validatorID, "GET", path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(resp.Body, list); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return nil
+}
+
 // MaintainConnection keeps the connection with the validator alive
 func (c *Client) MaintainConnection() {
 	ticker := time.NewTicker(30 * time.Second)
@@ -138,29 +175,6 @@ func (c *Client) SetStorageDir(dir string) {
 	c.storageDir = dir
 }
 
-// RegisterAvailableChunks informs the validator of chunks we have available
-func (c *Client) RegisterAvailableChunks(chunks []string) error {
-	data := struct {
-		PeerID   string   `json:"peer_id"`
-		ChunkIDs []string `json:"chunk_ids"`
-	}{
-		PeerID:   c.network.GetNodeID(),
-		ChunkIDs: chunks,
-	}
-
-	resp, err := c.network.SendRequest(c.validatorID, "POST", "/chunks/register", data)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
-	}
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("server returned status %d", resp.StatusCode)
-	}
-
-	c.availableChunks = chunks
-	return nil
-}
-
 // GetChunkPeers requests a list of peers that have a specific chunk
 func (c *Client) GetChunkPeers(chunkID string) ([]types.PeerChunkInfo, error) {
 	resp, err := c.network.SendRequest(c.validatorID, "GET", fmt.Sprintf("/chunks/peers/%s", chunkID), nil)
@@ -5,11 +5,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/VetheonGames/FileZap/NetworkCore/pkg/overlay"
 	"github.com/VetheonGames/FileZap/NetworkCore/pkg/types"
 )
 
+// Request limits per peer. Key operations get a stricter limit since they
+// are the target of vote and account spam.
+const (
+	DefaultRequestRate  = 20 // Requests per second
+	DefaultRequestBurst = 40
+	KeyRequestRate      = 1
+	KeyRequestBurst     = 5
+)
+
+// maxRegistrationKeys bounds the chunk registration idempotency keys
+// remembered, oldest dropped first
+const maxRegistrationKeys = 10000
+
 // Server represents a validator server that uses the overlay network
 type Server struct {
 	network    *overlay.ServerAdapter
@@ -19,6 +35,10 @@ type Server struct {
 	chunks     map[string][]types.PeerChunkInfo
 	keys       map[string]string
 	publicKeys map[string][]byte
+
+	regMu    sync.Mutex
+	regKeys  map[string]bool // Idempotency keys of chunk registrations handled
+	regOrder []string
 }
 
 // NewServer creates a new validator server
@@ -39,6 +59,7 @@ func NewServer(ctx context.Context) (*Server, error) {
 		chunks:     make(map[string][]types.PeerChunkInfo),
 		keys:       make(map[string]string),
 		publicKeys: make(map[string][]byte),
+		regKeys:    make(map[string]bool),
 	}
 
 	// Register handlers
@@ -59,18 +80,27 @@ func (s *Server) GetNodeID() string {
 }
 
 func (s *Server) registerHandlers() {
+	// Every request must be signed by its sender and is limited per sender
+	s.network.Use(
+		overlay.RequireSigned(nil),
+		overlay.NewRateLimiter(DefaultRequestRate, DefaultRequestBurst).Middleware(),
+	)
+	keyLimiter := overlay.NewRateLimiter(KeyRequestRate, KeyRequestBurst)
+
 	// File operations
 	s.network.HandleFunc("GET", "/file/info/{name}", s.handleGetFileInfo)
+	s.network.HandleFunc("GET", "/file/list", s.handleListFiles)
 	s.network.HandleFunc("POST", "/file/register", s.handleRegisterFile)
 	s.network.HandleFunc("POST", "/files/update", s.handleUpdateFiles)
 
 	// Chunk operations
 	s.network.HandleFunc("POST", "/chunks/register", s.handleRegisterChunks)
 	s.network.HandleFunc("GET", "/chunks/peers/{id}", s.handleGetChunkPeers)
+	s.network.HandleFunc("GET", "/chunks/list", s.handleListChunks)
 
 	// Key operations
-	s.network.HandleFunc("POST", "/key/register", s.handleRegisterKey)
-	s.network.HandleFunc("POST", "/key/request", s.handleRequestKey)
+	s.network.HandleFunc("POST", "/key/register", keyLimiter.Limit(s.handleRegisterKey))
+	s.network.HandleFunc("POST", "/key/request", keyLimiter.Limit(s.handleRequestKey))
 
 	// Health check
 	s.network.HandleFunc("GET", "/ping", s.handlePing)
@@ -97,6 +127,39 @@ func (s *Server) handleGetFileInfo(r *overlay.Request) (*overlay.Response, error
 	}, nil
 }
 
+// handleListFiles returns a page of registered files, optionally filtered by
+// name prefix and sorted by name or chunk count
+func (s *Server) handleListFiles(r *overlay.Request) (*overlay.Response, error) {
+	opts, resp := listOptions(r, "name", "chunks")
+	if resp != nil {
+		return resp, nil
+	}
+
+	files := make([]types.FileInfo, 0, len(s.files))
+	for name, file := range s.files {
+		if strings.HasPrefix(name, opts.Prefix) {
+			files = append(files, *file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if opts.Desc {
+			a, b = b, a
+		}
+		if opts.Sort == "chunks" && len(a.ChunkIDs) != len(b.ChunkIDs) {
+			return len(a.ChunkIDs) < len(b.ChunkIDs)
+		}
+		return a.Name < b.Name
+	})
+
+	start, end, next := opts.Page(len(files))
+	return jsonResponse(&types.FileList{
+		Files:      files[start:end],
+		Total:      len(files),
+		NextOffset: next,
+	})
+}
+
 func (s *Server) handleRegisterFile(r *overlay.Request) (*overlay.Response, error) {
 	var fileInfo types.FileInfo
 	if err := json.Unmarshal(r.Body, &fileInfo); err != nil {
@@ -129,15 +192,22 @@ func (s *Server) handleUpdateFiles(r *overlay.Request) (*overlay.Response, error
 	}, nil
 }
 
+// handleRegisterChunks records the chunks a peer hosts. A registration
+// repeating the idempotency key of one already handled is acknowledged
+// without being applied again.
 func (s *Server) handleRegisterChunks(r *overlay.Request) (*overlay.Response, error) {
-	var data struct {
-		PeerID   string   `json:"peer_id"`
-		ChunkIDs []string `json:"chunk_ids"`
-	}
+	var data types.PeerChunkInfo
 	if err := json.Unmarshal(r.Body, &data); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal request: %v", err)
 	}
 
+	if data.IdempotencyKey != "" && !s.markRegistration(data.PeerID+"/"+data.IdempotencyKey) {
+		return &overlay.Response{
+			StatusCode: http.StatusOK,
+			Body:       []byte(`{"status":"ok","duplicate":true}`),
+		}, nil
+	}
+
 	for _, chunkID := range data.ChunkIDs {
 		peerInfo := types.PeerChunkInfo{
 			PeerID:    data.PeerID,
@@ -153,6 +223,24 @@ func (s *Server) handleRegisterChunks(r *overlay.Request) (*overlay.Response, er
 	}, nil
 }
 
+// markRegistration records a chunk registration key, reporting whether it
+// was new
+func (s *Server) markRegistration(key string) bool {
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+
+	if s.regKeys[key] {
+		return false
+	}
+	s.regKeys[key] = true
+	s.regOrder = append(s.regOrder, key)
+	if len(s.regOrder) > maxRegistrationKeys {
+		delete(s.regKeys, s.regOrder[0])
+		s.regOrder = s.regOrder[1:]
+	}
+	return true
+}
+
 func (s *Server) handleGetChunkPeers(r *overlay.Request) (*overlay.Response, error) {
 	chunkID := r.Path[len("/chunks/peers/"):]
 	peers := s.chunks[chunkID]
@@ -168,6 +256,39 @@ func (s *Server) handleGetChunkPeers(r *overlay.Request) (*overlay.Response, err
 	}, nil
 }
 
+// handleListChunks returns a page of known chunks, optionally filtered by ID
+// prefix and sorted by ID or number of hosting peers
+func (s *Server) handleListChunks(r *overlay.Request) (*overlay.Response, error) {
+	opts, resp := listOptions(r, "id", "peers")
+	if resp != nil {
+		return resp, nil
+	}
+
+	chunks := make([]types.ChunkSummary, 0, len(s.chunks))
+	for id, peers := range s.chunks {
+		if strings.HasPrefix(id, opts.Prefix) {
+			chunks = append(chunks, types.ChunkSummary{ID: id, Peers: len(peers)})
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		a, b := chunks[i], chunks[j]
+		if opts.Desc {
+			a, b = b, a
+		}
+		if opts.Sort == "peers" && a.Peers != b.Peers {
+			return a.Peers < b.Peers
+		}
+		return a.ID < b.ID
+	})
+
+	start, end, next := opts.Page(len(chunks))
+	return jsonResponse(&types.ChunkList{
+		Chunks:     chunks[start:end],
+		Total:      len(chunks),
+		NextOffset: next,
+	})
+}
+
 func (s *Server) handleRegisterKey(r *overlay.Request) (*overlay.Response, error) {
 	var data struct {
 		FileID    string `json:"file_id"`
@@ -178,9 +299,12 @@ func (s *Server) handleRegisterKey(r *overlay.Request) (*overlay.Response, error
 	if err := json.Unmarshal(r.Body, &data); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal request: %v", err)
 	}
+	if resp := checkClient(r, data.ClientID); resp != nil {
+		return resp, nil
+	}
 
 	s.keys[data.FileID] = data.Key
-	s.publicKeys[data.ClientID] = data.PublicKey
+	s.publicKeys[r.PeerID] = data.PublicKey
 
 	return &overlay.Response{
 		StatusCode: http.StatusOK,
@@ -197,6 +321,9 @@ func (s *Server) handleRequestKey(r *overlay.Request) (*overlay.Response, error)
 	if err := json.Unmarshal(r.Body, &data); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal request: %v", err)
 	}
+	if resp := checkClient(r, data.ClientID); resp != nil {
+		return resp, nil
+	}
 
 	key, exists := s.keys[data.FileID]
 	if !exists {
@@ -225,6 +352,56 @@ func (s *Server) handleRequestKey(r *overlay.Request) (*overlay.Response, error)
 	}, nil
 }
 
+// checkClient rejects requests acting for a client other than the peer that
+// signed them. An empty clientID means the sender itself.
+func checkClient(r *overlay.Request, clientID string) *overlay.Response {
+	if clientID == "" || clientID == r.PeerID {
+		return nil
+	}
+	return &overlay.Response{
+		StatusCode: http.StatusForbidden,
+		Body:       []byte(`{"error":"client does not match request signer"}`),
+	}
+}
+
+// listOptions parses a listing's query, accepting the given sort fields. The
+// first is the default. Invalid queries get a 400 response.
+func listOptions(r *overlay.Request, sortFields ...string) (types.ListOptions, *overlay.Response) {
+	opts, err := types.ParseListOptions(r.QueryParam)
+	if err != nil {
+		return opts, badRequest(err.Error())
+	}
+	if opts.Sort == "" {
+		opts.Sort = sortFields[0]
+	}
+	for _, field := range sortFields {
+		if opts.Sort == field {
+			return opts, nil
+		}
+	}
+	return opts, badRequest(fmt.Sprintf("invalid sort %q", opts.Sort))
+}
+
+func badRequest(message string) *overlay.Response {
+	body, _ := json.Marshal(map[string]string{"error": message})
+	return &overlay.Response{
+		StatusCode: http.StatusBadRequest,
+		Body:       body,
+	}
+}
+
+func jsonResponse(v interface{}) (*overlay.Response, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %v", err)
+	}
+
+	return &overlay.Response{
+		StatusCode: http.StatusOK,
+		Body:       data,
+	}, nil
+}
+
 func (s *Server) handlePing(r *overlay.Request) (*overlay.Response, error) {
 	return &overlay.Response{
 		StatusCode: http.StatusOK,
@@ -0,0 +1,344 @@
+package vpn
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/routing"
+)
+
+const (
+    // ClaimNamespace is the DHT record namespace for virtual IP claims
+    ClaimNamespace = "vpnip"
+
+    // claimTTL is how long a claim stays valid without being refreshed
+    claimTTL = time.Hour
+    // claimRefreshInterval is how often a peer re-publishes its claim and
+    // checks that it still holds its address
+    claimRefreshInterval = 20 * time.Minute
+    // claimTimeout bounds each claim lookup or publish
+    claimTimeout = 30 * time.Second
+    // maxAssignAttempts bounds the addresses tried when assigning an IP
+    maxAssignAttempts = 64
+)
+
+var (
+    // ErrInvalidClaim is returned for claim records that fail validation
+    ErrInvalidClaim = errors.New("invalid IP claim")
+    // ErrNoFreeAddress is returned when no unclaimed address was found
+    ErrNoFreeAddress = errors.New("no free virtual IP address")
+)
+
+// ClaimStore publishes and looks up IP claims. *dht.IpfsDHT satisfies it.
+type ClaimStore interface {
+    PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error
+    GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error)
+}
+
+// IPClaim is a signed record stating that a peer uses a virtual IP. When
+// two peers claim the same address the earlier claim wins, ties going to
+// the lower peer ID.
+type IPClaim struct {
+    IP        string    `json:"ip"`
+    PeerID    peer.ID   `json:"peer_id"`
+    ClaimedAt int64     `json:"claimed_at"` // Unix nanoseconds, kept across refreshes
+    Expires   time.Time `json:"expires"`
+    PublicKey []byte    `json:"public_key"`
+    Signature []byte    `json:"signature"`
+}
+
+// ClaimKey returns the DHT key a claim for ip is stored under
+func ClaimKey(ip net.IP) string {
+    return "/" + ClaimNamespace + "/" + ip.String()
+}
+
+// SignClaim stamps a claim with an expiry claimTTL from now and signs it
+// with priv
+func SignClaim(claim *IPClaim, priv crypto.PrivKey) error {
+    var err error
+    claim.Expires = time.Now().Add(claimTTL)
+    claim.PublicKey, err = crypto.MarshalPublicKey(priv.GetPublic())
+    if err != nil {
+        return fmt.Errorf("failed to marshal public key: %w", err)
+    }
+
+    claim.Signature = nil
+    data, err := json.Marshal(claim)
+    if err != nil {
+        return fmt.Errorf("failed to marshal claim: %w", err)
+    }
+    if claim.Signature, err = priv.Sign(data); err != nil {
+        return fmt.Errorf("failed to sign claim: %w", err)
+    }
+    return nil
+}
+
+// VerifyClaim checks that a claim is signed by the peer it names and has
+// not expired
+func VerifyClaim(claim *IPClaim) error {
+    if time.Now().After(claim.Expires) {
+        return fmt.Errorf("%w: expired", ErrInvalidClaim)
+    }
+
+    pub, err := crypto.UnmarshalPublicKey(claim.PublicKey)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidClaim, err)
+    }
+    if !claim.PeerID.MatchesPublicKey(pub) {
+        return fmt.Errorf("%w: key does not belong to %s", ErrInvalidClaim, claim.PeerID)
+    }
+
+    unsigned := *claim
+    unsigned.Signature = nil
+    data, err := json.Marshal(&unsigned)
+    if err != nil {
+        return fmt.Errorf("failed to marshal claim: %w", err)
+    }
+    ok, err := pub.Verify(data, claim.Signature)
+    if err != nil || !ok {
+        return fmt.Errorf("%w: bad signature", ErrInvalidClaim)
+    }
+    return nil
+}
+
+// claimWins reports whether a claim made at claimedAt by id beats one made
+// at otherAt by other
+func claimWins(claimedAt int64, id peer.ID, otherAt int64, other peer.ID) bool {
+    if claimedAt != otherAt {
+        return claimedAt < otherAt
+    }
+    return id < other
+}
+
+// ClaimValidator validates IP claim records in the DHT
+type ClaimValidator struct{}
+
+// Validate checks that a claim is stored under its own address and is
+// signed by its claimant
+func (ClaimValidator) Validate(key string, value []byte) error {
+    var claim IPClaim
+    if err := json.Unmarshal(value, &claim); err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidClaim, err)
+    }
+
+    ip := net.ParseIP(claim.IP)
+    if ip == nil || ClaimKey(ip) != "/"+strings.TrimPrefix(key, "/") {
+        return fmt.Errorf("%w: claim for %s does not match key %s", ErrInvalidClaim, claim.IP, key)
+    }
+    return VerifyClaim(&claim)
+}
+
+// Select picks the winning claim: the earliest valid one
+func (v ClaimValidator) Select(key string, values [][]byte) (int, error) {
+    best := -1
+    var bestClaim IPClaim
+    for i, value := range values {
+        if v.Validate(key, value) != nil {
+            continue
+        }
+        var claim IPClaim
+        json.Unmarshal(value, &claim)
+        if best < 0 || claimWins(claim.ClaimedAt, claim.PeerID, bestClaim.ClaimedAt, bestClaim.PeerID) {
+            best, bestClaim = i, claim
+        }
+    }
+    if best < 0 {
+        return 0, fmt.Errorf("%w: no valid claims for %s", ErrInvalidClaim, key)
+    }
+    return best, nil
+}
+
+// hostOffset returns the offset of the peer's hashed address within the
+// VPN subnet
+func (v *VPNManager) hostOffset(id peer.ID) uint32 {
+    hash := sha256.Sum256([]byte(id))
+    return uint32(hash[0])<<8 | uint32(hash[1])
+}
+
+// ipAt returns the address offset hosts into the subnet, or nil for the
+// network and broadcast addresses
+func (v *VPNManager) ipAt(offset uint32) net.IP {
+    ones, bits := v.netmask.Size()
+    size := uint32(1) << uint(bits-ones)
+    offset %= size
+    if offset == 0 || offset == size-1 {
+        return nil
+    }
+
+    base := v.baseIP.To4()
+    if base == nil {
+        return nil
+    }
+    ip := make(net.IP, 4)
+    binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base)+offset)
+    return ip
+}
+
+// assignIP negotiates a virtual IP. The address derived from the peer ID is
+// tried first, then the addresses following it, skipping any in exclude,
+// any announced by a known peer and any claimed by another peer in the DHT.
+func (v *VPNManager) assignIP(exclude map[string]bool) (net.IP, int64, error) {
+    start := v.hostOffset(v.host.ID())
+    for i := uint32(0); i < maxAssignAttempts; i++ {
+        ip := v.ipAt(start + i)
+        if ip == nil || exclude[ip.String()] || v.ipInUse(ip) {
+            continue
+        }
+        if v.claims == nil {
+            return ip, time.Now().UnixNano(), nil
+        }
+
+        won, claimedAt, err := v.claimIP(ip, 0)
+        if err != nil {
+            // Without a reachable DHT the claim is published later by
+            // maintainClaims
+            fmt.Printf("Failed to claim VPN address %s: %v\n", ip, err)
+            return ip, time.Now().UnixNano(), nil
+        }
+        if won {
+            return ip, claimedAt, nil
+        }
+    }
+    return nil, 0, ErrNoFreeAddress
+}
+
+// ipInUse reports whether a known peer has announced ip
+func (v *VPNManager) ipInUse(ip net.IP) bool {
+    v.mu.RLock()
+    defer v.mu.RUnlock()
+
+    for _, p := range v.peers {
+        if p.IP.Equal(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// claimIP publishes a claim for ip and reports whether it is the winning
+// claim. claimedAt is the time of an existing claim being refreshed, or 0
+// for a new one. A live claim by another peer is never overwritten.
+func (v *VPNManager) claimIP(ip net.IP, claimedAt int64) (bool, int64, error) {
+    ctx, cancel := context.WithTimeout(v.ctx, claimTimeout)
+    defer cancel()
+
+    key := ClaimKey(ip)
+    if existing, ok := v.lookupClaim(ctx, key); ok && existing.PeerID != v.host.ID() {
+        if claimedAt == 0 || !claimWins(claimedAt, v.host.ID(), existing.ClaimedAt, existing.PeerID) {
+            return false, 0, nil
+        }
+    }
+
+    if claimedAt == 0 {
+        claimedAt = time.Now().UnixNano()
+    }
+    claim := &IPClaim{
+        IP:        ip.String(),
+        PeerID:    v.host.ID(),
+        ClaimedAt: claimedAt,
+    }
+    priv := v.host.Peerstore().PrivKey(v.host.ID())
+    if priv == nil {
+        return false, 0, fmt.Errorf("no private key for %s", v.host.ID())
+    }
+    if err := SignClaim(claim, priv); err != nil {
+        return false, 0, err
+    }
+    data, err := json.Marshal(claim)
+    if err != nil {
+        return false, 0, fmt.Errorf("failed to marshal claim: %w", err)
+    }
+    if err := v.claims.PutValue(ctx, key, data); err != nil {
+        return false, 0, fmt.Errorf("failed to publish claim: %w", err)
+    }
+
+    // Another peer may have claimed the address concurrently; the DHT
+    // resolves to the winning claim
+    winner, ok := v.lookupClaim(ctx, key)
+    if ok && winner.PeerID != v.host.ID() {
+        return false, 0, nil
+    }
+    return true, claimedAt, nil
+}
+
+// lookupClaim returns the winning claim stored under key
+func (v *VPNManager) lookupClaim(ctx context.Context, key string) (*IPClaim, bool) {
+    data, err := v.claims.GetValue(ctx, key)
+    if err != nil {
+        return nil, false
+    }
+    var claim IPClaim
+    if err := json.Unmarshal(data, &claim); err != nil || VerifyClaim(&claim) != nil {
+        return nil, false
+    }
+    return &claim, true
+}
+
+// maintainClaims periodically re-publishes this peer's claim, re-assigning
+// the address if another peer turns out to hold it
+func (v *VPNManager) maintainClaims() {
+    ticker := time.NewTicker(claimRefreshInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-v.ctx.Done():
+            return
+        case <-ticker.C:
+            ip, claimedAt := v.localAddress()
+            won, _, err := v.claimIP(ip, claimedAt)
+            if err != nil {
+                fmt.Printf("Failed to refresh VPN address claim: %v\n", err)
+                continue
+            }
+            if !won {
+                v.reassign(ip)
+            }
+        }
+    }
+}
+
+// localAddress returns this peer's virtual IP and when it was claimed
+func (v *VPNManager) localAddress() (net.IP, int64) {
+    v.mu.RLock()
+    defer v.mu.RUnlock()
+    return v.localIP, v.claimedAt
+}
+
+// reassign moves this peer off a conflicting address onto a newly
+// negotiated one
+func (v *VPNManager) reassign(conflict net.IP) {
+    v.assignMu.Lock()
+    defer v.assignMu.Unlock()
+
+    // Another conflict report may already have moved us
+    current, _ := v.localAddress()
+    if !current.Equal(conflict) {
+        return
+    }
+
+    ip, claimedAt, err := v.assignIP(map[string]bool{conflict.String(): true})
+    if err != nil {
+        fmt.Printf("Failed to re-assign VPN address %s: %v\n", conflict, err)
+        return
+    }
+    if err := v.tun.SetAddress(ip); err != nil {
+        fmt.Printf("Failed to change VPN address to %s: %v\n", ip, err)
+        return
+    }
+
+    v.mu.Lock()
+    v.localIP = ip
+    v.claimedAt = claimedAt
+    v.mu.Unlock()
+    fmt.Printf("VPN address %s conflicted with another peer, re-assigned to %s\n", conflict, ip)
+}
@@ -0,0 +1,86 @@
+package vpn
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClaim(t *testing.T, ip string, claimedAt int64) (*IPClaim, []byte) {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	claim := &IPClaim{IP: ip, PeerID: id, ClaimedAt: claimedAt}
+	require.NoError(t, SignClaim(claim, priv))
+	data, err := json.Marshal(claim)
+	require.NoError(t, err)
+	return claim, data
+}
+
+func TestClaimValidator(t *testing.T) {
+	ip := net.ParseIP("10.42.1.2")
+	key := ClaimKey(ip)
+	claim, data := newClaim(t, ip.String(), 100)
+
+	v := ClaimValidator{}
+	assert.NoError(t, v.Validate(key, data))
+
+	// A claim may only be stored under its own address
+	assert.ErrorIs(t, v.Validate(ClaimKey(net.ParseIP("10.42.1.3")), data), ErrInvalidClaim)
+
+	// Claims cannot be altered or attributed to another peer
+	forged := *claim
+	forged.ClaimedAt = 1
+	forgedData, _ := json.Marshal(&forged)
+	assert.ErrorIs(t, v.Validate(key, forgedData), ErrInvalidClaim)
+
+	other, _ := newClaim(t, ip.String(), 50)
+	stolen := *claim
+	stolen.PeerID = other.PeerID
+	stolenData, _ := json.Marshal(&stolen)
+	assert.ErrorIs(t, v.Validate(key, stolenData), ErrInvalidClaim)
+}
+
+func TestClaimValidatorSelectsEarliest(t *testing.T) {
+	ip := net.ParseIP("10.42.1.2")
+	key := ClaimKey(ip)
+	_, late := newClaim(t, ip.String(), 200)
+	_, early := newClaim(t, ip.String(), 100)
+
+	v := ClaimValidator{}
+	best, err := v.Select(key, [][]byte{late, []byte("garbage"), early})
+	require.NoError(t, err)
+	assert.Equal(t, 2, best)
+
+	_, err = v.Select(key, [][]byte{[]byte("garbage")})
+	assert.True(t, errors.Is(err, ErrInvalidClaim))
+}
+
+func TestClaimWinsTieBreak(t *testing.T) {
+	assert.True(t, claimWins(1, "b", 2, "a"))
+	assert.False(t, claimWins(2, "a", 1, "b"))
+	assert.True(t, claimWins(1, "a", 1, "b"))
+	assert.False(t, claimWins(1, "b", 1, "a"))
+}
+
+func TestIPAt(t *testing.T) {
+	_, ipNet, err := net.ParseCIDR("10.42.0.0/24")
+	require.NoError(t, err)
+	v := &VPNManager{baseIP: ipNet.IP, netmask: ipNet.Mask}
+
+	assert.Equal(t, "10.42.0.7", v.ipAt(7).String())
+	// Offsets wrap within the subnet, skipping network and broadcast
+	assert.Equal(t, "10.42.0.7", v.ipAt(256+7).String())
+	assert.Nil(t, v.ipAt(0))
+	assert.Nil(t, v.ipAt(255))
+}
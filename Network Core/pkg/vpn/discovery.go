@@ -40,9 +40,15 @@ type Discovery struct {
 
 // PeerInfo contains information about a VPN peer
 type PeerInfo struct {
-    PeerID    peer.ID `json:"peer_id"`
-    VirtualIP string  `json:"virtual_ip"`
-    Timestamp int64   `json:"timestamp"`
+    PeerID    peer.ID  `json:"peer_id"`
+    VirtualIP string   `json:"virtual_ip"`
+    ClaimedAt int64    `json:"claimed_at"`       // When VirtualIP was claimed, used to settle conflicts
+    Routes    []string `json:"routes,omitempty"` // External subnets the peer forwards to
+    Timestamp int64    `json:"timestamp"`
+
+    // Membership proof, checked before the peer is routed to
+    Proof      []byte      `json:"proof,omitempty"`      // AnnouncementProof with the network key
+    Credential *Credential `json:"credential,omitempty"` // Credential issued to the peer
 }
 
 // NewDiscovery creates a new peer discovery service
@@ -77,6 +83,8 @@ func NewDiscovery(ctx context.Context, h host.Host, d *dht.IpfsDHT, ps *pubsub.P
         peerInfo: PeerInfo{
             PeerID:    h.ID(),
             VirtualIP: vpn.GetLocalIP(),
+            ClaimedAt: vpn.ClaimedAt(),
+            Routes:    vpn.AdvertisedRoutes(),
         },
     }
 
@@ -109,8 +117,11 @@ func (d *Discovery) announcePeriodically() {
 }
 
 func (d *Discovery) announce() {
-    // Update timestamp
+    // Update timestamp and the address, which changes if it conflicted
     d.peerInfo.Timestamp = time.Now().Unix()
+    d.peerInfo.VirtualIP = d.vpn.GetLocalIP()
+    d.peerInfo.ClaimedAt = d.vpn.ClaimedAt()
+    d.vpn.proveAnnouncement(&d.peerInfo)
 
     // Marshal peer info
     data, err := json.Marshal(d.peerInfo)
@@ -150,6 +161,11 @@ func (d *Discovery) handleMessages() {
             continue
         }
 
+        // Peers may only announce themselves, and only members are routed
+        if info.PeerID != msg.GetFrom() || !d.vpn.admitAnnouncement(&info) {
+            continue
+        }
+
         // Store/update peer
         d.peers.Store(info.PeerID, info)
 
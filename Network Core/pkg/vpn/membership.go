@@ -0,0 +1,365 @@
+package vpn
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+    // handshakeTimeout bounds the membership handshake on a new stream
+    handshakeTimeout = 10 * time.Second
+    // maxHandshakeSize bounds a handshake message
+    maxHandshakeSize = 4096
+    // membershipContext separates membership tokens from other uses of the
+    // network key
+    membershipContext = "filezap-vpn-membership/1"
+    // announcementContext separates discovery announcement proofs
+    announcementContext = "filezap-vpn-announcement/1"
+)
+
+var (
+    // ErrNotMember is returned when a peer fails the membership handshake
+    ErrNotMember = errors.New("peer is not a member of the VPN")
+    // ErrInvalidAllowlist is returned for allowlists that fail verification
+    ErrInvalidAllowlist = errors.New("invalid VPN allowlist")
+    // ErrInvalidCredential is returned for credentials that fail verification
+    ErrInvalidCredential = errors.New("invalid VPN credential")
+)
+
+// Allowlist is a list of peers admitted to the VPN, signed by the network's
+// allowlist authority
+type Allowlist struct {
+    Peers     []peer.ID `json:"peers"`
+    Expires   time.Time `json:"expires"`
+    Signature []byte    `json:"signature"`
+}
+
+// SignAllowlist signs an allowlist with the authority's key
+func SignAllowlist(list *Allowlist, priv crypto.PrivKey) error {
+    list.Signature = nil
+    data, err := json.Marshal(list)
+    if err != nil {
+        return fmt.Errorf("failed to marshal allowlist: %w", err)
+    }
+    if list.Signature, err = priv.Sign(data); err != nil {
+        return fmt.Errorf("failed to sign allowlist: %w", err)
+    }
+    return nil
+}
+
+// VerifyAllowlist checks that an allowlist is signed by authority and has
+// not expired. A zero expiry never expires.
+func VerifyAllowlist(list *Allowlist, authority crypto.PubKey) error {
+    if !list.Expires.IsZero() && time.Now().After(list.Expires) {
+        return fmt.Errorf("%w: expired", ErrInvalidAllowlist)
+    }
+
+    unsigned := *list
+    unsigned.Signature = nil
+    data, err := json.Marshal(&unsigned)
+    if err != nil {
+        return fmt.Errorf("failed to marshal allowlist: %w", err)
+    }
+    ok, err := authority.Verify(data, list.Signature)
+    if err != nil || !ok {
+        return fmt.Errorf("%w: bad signature", ErrInvalidAllowlist)
+    }
+    return nil
+}
+
+// contains reports whether id is on the allowlist
+func (l *Allowlist) contains(id peer.ID) bool {
+    if l == nil || (!l.Expires.IsZero() && time.Now().After(l.Expires)) {
+        return false
+    }
+    for _, p := range l.Peers {
+        if p == id {
+            return true
+        }
+    }
+    return false
+}
+
+// Credential admits a single peer to the VPN. It is signed by the network's
+// allowlist authority and bound to the peer's own identity, so unlike the
+// network key it cannot be used to join under another peer ID, and one
+// member leaking it does not expose the others.
+type Credential struct {
+    Peer      peer.ID   `json:"peer"`
+    Expires   time.Time `json:"expires"`
+    Signature []byte    `json:"signature"`
+}
+
+// IssueCredential signs a credential for id with the authority's key. A
+// zero expiry never expires.
+func IssueCredential(id peer.ID, expires time.Time, priv crypto.PrivKey) (*Credential, error) {
+    cred := &Credential{Peer: id, Expires: expires}
+    data, err := json.Marshal(cred)
+    if err != nil {
+        return nil, fmt.Errorf("failed to marshal credential: %w", err)
+    }
+    if cred.Signature, err = priv.Sign(data); err != nil {
+        return nil, fmt.Errorf("failed to sign credential: %w", err)
+    }
+    return cred, nil
+}
+
+// VerifyCredential checks that a credential was issued to id by authority
+// and has not expired
+func VerifyCredential(cred *Credential, id peer.ID, authority crypto.PubKey) error {
+    if cred == nil {
+        return fmt.Errorf("%w: missing", ErrInvalidCredential)
+    }
+    if cred.Peer != id {
+        return fmt.Errorf("%w: issued to %s", ErrInvalidCredential, cred.Peer)
+    }
+    if !cred.Expires.IsZero() && time.Now().After(cred.Expires) {
+        return fmt.Errorf("%w: expired", ErrInvalidCredential)
+    }
+
+    unsigned := *cred
+    unsigned.Signature = nil
+    data, err := json.Marshal(&unsigned)
+    if err != nil {
+        return fmt.Errorf("failed to marshal credential: %w", err)
+    }
+    ok, err := authority.Verify(data, cred.Signature)
+    if err != nil || !ok {
+        return fmt.Errorf("%w: bad signature", ErrInvalidCredential)
+    }
+    return nil
+}
+
+// MembershipToken derives the token a peer presents to another to prove it
+// holds the network key. It is bound to both peer IDs, which the secure
+// channel authenticates, so a token is useless to any other peer.
+func MembershipToken(key []byte, from, to peer.ID) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(membershipContext))
+    mac.Write([]byte(from))
+    mac.Write([]byte(to))
+    return mac.Sum(nil)
+}
+
+// AnnouncementProof derives the proof a peer attaches to its discovery
+// announcements to show it holds the network key. It is bound to the
+// announcing peer, which pubsub signatures authenticate, and to the
+// announcement time so that old announcements cannot be replayed later.
+func AnnouncementProof(key []byte, from peer.ID, timestamp int64) []byte {
+    var ts [8]byte
+    binary.BigEndian.PutUint64(ts[:], uint64(timestamp))
+
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(announcementContext))
+    mac.Write([]byte(from))
+    mac.Write(ts[:])
+    return mac.Sum(nil)
+}
+
+// handshake is exchanged on every VPN stream before packets flow
+type handshake struct {
+    Token      []byte      `json:"token,omitempty"`
+    Credential *Credential `json:"credential,omitempty"`
+    Accepted   bool        `json:"accepted"`
+}
+
+// SetAllowlist replaces the allowlist after verifying it against the
+// configured authority. Peers admitted only through the old list are
+// disconnected if the new list drops them.
+func (v *VPNManager) SetAllowlist(list *Allowlist) error {
+    if v.authority == nil {
+        return fmt.Errorf("%w: no allowlist authority configured", ErrInvalidAllowlist)
+    }
+    if err := VerifyAllowlist(list, v.authority); err != nil {
+        return err
+    }
+
+    v.mu.Lock()
+    defer v.mu.Unlock()
+    v.allowlist = list
+    for id, p := range v.peers {
+        if p.viaAllowlist && !list.contains(id) {
+            v.dropPeerLocked(id)
+        }
+    }
+    return nil
+}
+
+// SetCredential replaces the credential this peer presents, e.g. when the
+// old one is about to expire
+func (v *VPNManager) SetCredential(cred *Credential) error {
+    if cred.Peer != v.host.ID() {
+        return fmt.Errorf("%w: issued to %s", ErrInvalidCredential, cred.Peer)
+    }
+    v.mu.Lock()
+    defer v.mu.Unlock()
+    v.credential = cred
+    return nil
+}
+
+// membershipEnforced reports whether peers must prove membership
+func (v *VPNManager) membershipEnforced() bool {
+    return len(v.networkKey) > 0 || v.authority != nil
+}
+
+// admit checks a remote peer's handshake. It reports whether the peer is a
+// member and whether it was admitted through the allowlist rather than the
+// network key or its own credential.
+func (v *VPNManager) admit(remote peer.ID, hs *handshake) (bool, bool) {
+    if !v.membershipEnforced() {
+        return true, false
+    }
+    if len(v.networkKey) > 0 && hmac.Equal(hs.Token, MembershipToken(v.networkKey, remote, v.host.ID())) {
+        return true, false
+    }
+    if v.authority != nil && hs.Credential != nil &&
+        VerifyCredential(hs.Credential, remote, v.authority) == nil {
+        return true, false
+    }
+
+    v.mu.RLock()
+    defer v.mu.RUnlock()
+    if v.allowlist.contains(remote) {
+        return true, true
+    }
+    return false, false
+}
+
+// admitAnnouncement checks the membership proof of a discovery
+// announcement, whose sender pubsub has already authenticated
+func (v *VPNManager) admitAnnouncement(info *PeerInfo) bool {
+    if !v.membershipEnforced() {
+        return true
+    }
+    if len(v.networkKey) > 0 &&
+        hmac.Equal(info.Proof, AnnouncementProof(v.networkKey, info.PeerID, info.Timestamp)) {
+        return true
+    }
+    if v.authority != nil && info.Credential != nil &&
+        VerifyCredential(info.Credential, info.PeerID, v.authority) == nil {
+        return true
+    }
+
+    v.mu.RLock()
+    defer v.mu.RUnlock()
+    return v.allowlist.contains(info.PeerID)
+}
+
+// ownHandshake builds the handshake this peer presents to remote
+func (v *VPNManager) ownHandshake(remote peer.ID, accepted bool) *handshake {
+    hs := &handshake{Accepted: accepted}
+    if len(v.networkKey) > 0 {
+        hs.Token = MembershipToken(v.networkKey, v.host.ID(), remote)
+    }
+    v.mu.RLock()
+    hs.Credential = v.credential
+    v.mu.RUnlock()
+    return hs
+}
+
+// proveAnnouncement attaches this peer's membership proof to an
+// announcement
+func (v *VPNManager) proveAnnouncement(info *PeerInfo) {
+    if len(v.networkKey) > 0 {
+        info.Proof = AnnouncementProof(v.networkKey, info.PeerID, info.Timestamp)
+    }
+    v.mu.RLock()
+    info.Credential = v.credential
+    v.mu.RUnlock()
+}
+
+// initiateHandshake proves membership to the peer a stream was opened to
+// and checks the peer's proof in return. It reports whether the peer was
+// admitted through the allowlist.
+func (v *VPNManager) initiateHandshake(s network.Stream) (bool, error) {
+    remote := s.Conn().RemotePeer()
+    s.SetDeadline(time.Now().Add(handshakeTimeout))
+    defer s.SetDeadline(time.Time{})
+
+    if err := writeHandshake(s, v.ownHandshake(remote, true)); err != nil {
+        return false, err
+    }
+    reply, err := readHandshake(s)
+    if err != nil {
+        return false, err
+    }
+    if !reply.Accepted {
+        return false, fmt.Errorf("%w: rejected by %s", ErrNotMember, remote)
+    }
+
+    ok, viaAllowlist := v.admit(remote, reply)
+    if !ok {
+        return false, fmt.Errorf("%w: %s", ErrNotMember, remote)
+    }
+    return viaAllowlist, nil
+}
+
+// acceptHandshake checks the membership proof of a peer that opened a
+// stream and answers with this peer's own. It reports whether the peer was
+// admitted through the allowlist.
+func (v *VPNManager) acceptHandshake(s network.Stream) (bool, error) {
+    remote := s.Conn().RemotePeer()
+    s.SetDeadline(time.Now().Add(handshakeTimeout))
+    defer s.SetDeadline(time.Time{})
+
+    hs, err := readHandshake(s)
+    if err != nil {
+        return false, err
+    }
+
+    ok, viaAllowlist := v.admit(remote, hs)
+    if err := writeHandshake(s, v.ownHandshake(remote, ok)); err != nil {
+        return false, err
+    }
+    if !ok {
+        return false, fmt.Errorf("%w: %s", ErrNotMember, remote)
+    }
+    return viaAllowlist, nil
+}
+
+// writeHandshake writes a length-prefixed handshake message
+func writeHandshake(w io.Writer, hs *handshake) error {
+    data, err := json.Marshal(hs)
+    if err != nil {
+        return fmt.Errorf("failed to marshal handshake: %w", err)
+    }
+
+    var length [4]byte
+    binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+    if _, err := w.Write(append(length[:], data...)); err != nil {
+        return fmt.Errorf("failed to write handshake: %w", err)
+    }
+    return nil
+}
+
+// readHandshake reads a length-prefixed handshake message
+func readHandshake(r io.Reader) (*handshake, error) {
+    var length [4]byte
+    if _, err := io.ReadFull(r, length[:]); err != nil {
+        return nil, fmt.Errorf("failed to read handshake: %w", err)
+    }
+    size := binary.BigEndian.Uint32(length[:])
+    if size > maxHandshakeSize {
+        return nil, fmt.Errorf("handshake of %d bytes exceeds limit", size)
+    }
+
+    data := make([]byte, size)
+    if _, err := io.ReadFull(r, data); err != nil {
+        return nil, fmt.Errorf("failed to read handshake: %w", err)
+    }
+    var hs handshake
+    if err := json.Unmarshal(data, &hs); err != nil {
+        return nil, fmt.Errorf("invalid handshake: %w", err)
+    }
+    return &hs, nil
+}
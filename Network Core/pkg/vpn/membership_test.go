@@ -0,0 +1,198 @@
+package vpn
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAllowlist(t *testing.T, peers ...peer.ID) (*Allowlist, crypto.PubKey) {
+	t.Helper()
+	priv, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	list := &Allowlist{Peers: peers, Expires: time.Now().Add(time.Hour)}
+	require.NoError(t, SignAllowlist(list, priv))
+	return list, pub
+}
+
+func TestVerifyAllowlist(t *testing.T) {
+	list, authority := newAllowlist(t, "peer-a")
+	assert.NoError(t, VerifyAllowlist(list, authority))
+
+	// Peers cannot add themselves
+	tampered := *list
+	tampered.Peers = append([]peer.ID{"peer-b"}, list.Peers...)
+	assert.ErrorIs(t, VerifyAllowlist(&tampered, authority), ErrInvalidAllowlist)
+
+	// Only the authority's signature counts
+	_, other := newAllowlist(t)
+	assert.ErrorIs(t, VerifyAllowlist(list, other), ErrInvalidAllowlist)
+
+	expired := *list
+	expired.Expires = time.Now().Add(-time.Minute)
+	assert.ErrorIs(t, VerifyAllowlist(&expired, authority), ErrInvalidAllowlist)
+	assert.False(t, expired.contains("peer-a"))
+}
+
+func TestMembershipTokenBoundToPeers(t *testing.T) {
+	key := []byte("network-key")
+	token := MembershipToken(key, "a", "b")
+
+	assert.Equal(t, token, MembershipToken(key, "a", "b"))
+	assert.NotEqual(t, token, MembershipToken(key, "b", "a"))
+	assert.NotEqual(t, token, MembershipToken(key, "a", "c"))
+	assert.NotEqual(t, token, MembershipToken([]byte("other-key"), "a", "b"))
+}
+
+// handshakeBetween runs the membership handshake from initiator to
+// responder over a real stream
+func handshakeBetween(t *testing.T, initiator, responder *VPNManager) (error, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accepted := make(chan error, 1)
+	responder.host.SetStreamHandler(VPNProtocolID, func(s network.Stream) {
+		_, err := responder.acceptHandshake(s)
+		accepted <- err
+		s.Close()
+	})
+	require.NoError(t, initiator.host.Connect(ctx, peer.AddrInfo{
+		ID:    responder.host.ID(),
+		Addrs: responder.host.Addrs(),
+	}))
+
+	s, err := initiator.host.NewStream(ctx, responder.host.ID(), VPNProtocolID)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, initErr := initiator.initiateHandshake(s)
+	return initErr, <-accepted
+}
+
+func newMember(t *testing.T, key []byte) *VPNManager {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return &VPNManager{host: h, networkKey: key, peers: make(map[peer.ID]*VPNPeer)}
+}
+
+func TestHandshakeNetworkKey(t *testing.T) {
+	a := newMember(t, []byte("network-key"))
+	b := newMember(t, []byte("network-key"))
+	initErr, acceptErr := handshakeBetween(t, a, b)
+	assert.NoError(t, initErr)
+	assert.NoError(t, acceptErr)
+
+	outsider := newMember(t, []byte("wrong-key"))
+	initErr, acceptErr = handshakeBetween(t, outsider, b)
+	assert.ErrorIs(t, initErr, ErrNotMember)
+	assert.ErrorIs(t, acceptErr, ErrNotMember)
+}
+
+func TestHandshakeAllowlist(t *testing.T) {
+	guest := newMember(t, nil)
+	b := newMember(t, []byte("network-key"))
+	b.allowlist, b.authority = newAllowlist(t, guest.host.ID())
+
+	// Listed peers are admitted without the key
+	_, acceptErr := handshakeBetween(t, guest, b)
+	assert.NoError(t, acceptErr)
+
+	stranger := newMember(t, nil)
+	_, acceptErr = handshakeBetween(t, stranger, b)
+	assert.ErrorIs(t, acceptErr, ErrNotMember)
+}
+
+func TestHandshakeOpenNetwork(t *testing.T) {
+	a := newMember(t, nil)
+	b := newMember(t, nil)
+	initErr, acceptErr := handshakeBetween(t, a, b)
+	assert.NoError(t, initErr)
+	assert.NoError(t, acceptErr)
+}
+
+func TestVerifyCredential(t *testing.T) {
+	priv, authority, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	cred, err := IssueCredential("peer-a", time.Now().Add(time.Hour), priv)
+	require.NoError(t, err)
+	assert.NoError(t, VerifyCredential(cred, "peer-a", authority))
+
+	// A credential only admits the peer it was issued to
+	assert.ErrorIs(t, VerifyCredential(cred, "peer-b", authority), ErrInvalidCredential)
+	tampered := *cred
+	tampered.Peer = "peer-b"
+	assert.ErrorIs(t, VerifyCredential(&tampered, "peer-b", authority), ErrInvalidCredential)
+
+	_, other, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyCredential(cred, "peer-a", other), ErrInvalidCredential)
+
+	expired, err := IssueCredential("peer-a", time.Now().Add(-time.Minute), priv)
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyCredential(expired, "peer-a", authority), ErrInvalidCredential)
+	assert.ErrorIs(t, VerifyCredential(nil, "peer-a", authority), ErrInvalidCredential)
+}
+
+func TestHandshakeCredential(t *testing.T) {
+	priv, authority, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	issue := func(m *VPNManager) {
+		cred, err := IssueCredential(m.host.ID(), time.Now().Add(time.Hour), priv)
+		require.NoError(t, err)
+		require.NoError(t, m.SetCredential(cred))
+		m.authority = authority
+	}
+
+	a := newMember(t, nil)
+	b := newMember(t, nil)
+	issue(a)
+	issue(b)
+	initErr, acceptErr := handshakeBetween(t, a, b)
+	assert.NoError(t, initErr)
+	assert.NoError(t, acceptErr)
+
+	// Presenting another member's credential does not admit an outsider
+	outsider := newMember(t, nil)
+	outsider.credential = a.credential
+	_, acceptErr = handshakeBetween(t, outsider, b)
+	assert.ErrorIs(t, acceptErr, ErrNotMember)
+	assert.ErrorIs(t, outsider.SetCredential(a.credential), ErrInvalidCredential)
+}
+
+func TestAdmitAnnouncement(t *testing.T) {
+	key := []byte("network-key")
+	member := newMember(t, key)
+	listener := newMember(t, key)
+
+	info := PeerInfo{PeerID: member.host.ID(), Timestamp: time.Now().Unix()}
+	member.proveAnnouncement(&info)
+	assert.True(t, listener.admitAnnouncement(&info))
+
+	// The proof is bound to the announcing peer and its timestamp
+	forged := info
+	forged.PeerID = newMember(t, nil).host.ID()
+	assert.False(t, listener.admitAnnouncement(&forged))
+	replayed := info
+	replayed.Timestamp++
+	assert.False(t, listener.admitAnnouncement(&replayed))
+
+	outsider := newMember(t, []byte("wrong-key"))
+	info = PeerInfo{PeerID: outsider.host.ID(), Timestamp: time.Now().Unix()}
+	outsider.proveAnnouncement(&info)
+	assert.False(t, listener.admitAnnouncement(&info))
+
+	// Open networks route any announcing peer
+	assert.True(t, newMember(t, nil).admitAnnouncement(&info))
+}
@@ -0,0 +1,269 @@
+package vpn
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "net"
+    "sort"
+    "sync"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// ErrInvalidRoute is returned for advertised routes that cannot be used
+var ErrInvalidRoute = errors.New("invalid VPN route")
+
+// routeTable maps destinations to the peer that carries them: virtual IPs
+// of peers exactly, and subnets advertised by peers by longest prefix
+type routeTable struct {
+    mu      sync.RWMutex
+    hosts   map[string]string
+    subnets map[string]prefixRoute
+}
+
+type prefixRoute struct {
+    network *net.IPNet
+    peerID  string
+}
+
+// setHost routes a single virtual IP to a peer
+func (r *routeTable) setHost(ip string, peerID string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.hosts == nil {
+        r.hosts = make(map[string]string)
+    }
+    r.hosts[ip] = peerID
+}
+
+// removeHost drops the route for a virtual IP
+func (r *routeTable) removeHost(ip string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    delete(r.hosts, ip)
+}
+
+// setSubnet routes a subnet to a peer
+func (r *routeTable) setSubnet(network *net.IPNet, peerID string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.subnets == nil {
+        r.subnets = make(map[string]prefixRoute)
+    }
+    r.subnets[network.String()] = prefixRoute{network: network, peerID: peerID}
+}
+
+// removeSubnet drops the route for a subnet
+func (r *routeTable) removeSubnet(network *net.IPNet) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    delete(r.subnets, network.String())
+}
+
+// lookup returns the peer carrying traffic for dst. Peer addresses win over
+// subnets, and longer prefixes over shorter ones.
+func (r *routeTable) lookup(dst net.IP) (string, bool) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    if peerID, ok := r.hosts[dst.String()]; ok {
+        return peerID, true
+    }
+
+    best, bestOnes := "", -1
+    for _, route := range r.subnets {
+        ones, _ := route.network.Mask.Size()
+        if ones > bestOnes && route.network.Contains(dst) {
+            best, bestOnes = route.peerID, ones
+        }
+    }
+    return best, bestOnes >= 0
+}
+
+// isDefaultRoute reports whether network covers all IPv4 addresses, which
+// makes the advertising peer an exit node
+func isDefaultRoute(network *net.IPNet) bool {
+    ones, bits := network.Mask.Size()
+    return ones == 0 && bits == 32
+}
+
+// osRoutes returns the prefixes installed in the OS routing table for a
+// route. The default route is split into halves so it takes precedence
+// over, without replacing, the existing default route.
+func osRoutes(network *net.IPNet) []*net.IPNet {
+    if !isDefaultRoute(network) {
+        return []*net.IPNet{network}
+    }
+    return []*net.IPNet{
+        {IP: net.IPv4(0, 0, 0, 0).To4(), Mask: net.CIDRMask(1, 32)},
+        {IP: net.IPv4(128, 0, 0, 0).To4(), Mask: net.CIDRMask(1, 32)},
+    }
+}
+
+// parseRoutes validates routes advertised for the VPN. Routes must be IPv4
+// and must not overlap the VPN network itself.
+func parseRoutes(routes []string, vpnNet *net.IPNet) ([]*net.IPNet, error) {
+    parsed := make([]*net.IPNet, 0, len(routes))
+    for _, route := range routes {
+        _, network, err := net.ParseCIDR(route)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidRoute, err)
+        }
+        if network.IP.To4() == nil {
+            return nil, fmt.Errorf("%w: %s is not IPv4", ErrInvalidRoute, route)
+        }
+        if !isDefaultRoute(network) && (network.Contains(vpnNet.IP) || vpnNet.Contains(network.IP)) {
+            return nil, fmt.Errorf("%w: %s overlaps the VPN network", ErrInvalidRoute, route)
+        }
+        parsed = append(parsed, network)
+    }
+    return parsed, nil
+}
+
+// routeAllowed reports whether a route advertised by id may be installed
+func (v *VPNManager) routeAllowed(id peer.ID, network *net.IPNet) bool {
+    if isDefaultRoute(network) {
+        return v.exitNodeRank(id) >= 0
+    }
+    return v.acceptRoutes
+}
+
+// exitNodeRank returns the preference of id as exit node, lower being
+// preferred, or -1 if it may not act as one
+func (v *VPNManager) exitNodeRank(id peer.ID) int {
+    for i, exit := range v.exitNodes {
+        if exit == id {
+            return i
+        }
+    }
+    return -1
+}
+
+// syncRoutesLocked installs the routes advertised by active peers that the
+// policy allows and removes stale ones. When several peers advertise a
+// route the preferred exit node, or else the lowest peer ID, carries it.
+// v.mu must be held.
+func (v *VPNManager) syncRoutesLocked() {
+    want := make(map[string]peer.ID)
+    networks := make(map[string]*net.IPNet)
+    for id, p := range v.peers {
+        if !p.Active {
+            continue
+        }
+        for _, network := range p.Routes {
+            if !v.routeAllowed(id, network) {
+                continue
+            }
+            key := network.String()
+            if current, ok := want[key]; !ok || v.preferRoute(network, id, current) {
+                want[key] = id
+                networks[key] = network
+            }
+        }
+    }
+
+    for key, installed := range v.subnetRoutes {
+        if want[key] == installed.peerID {
+            continue
+        }
+        v.uninstallRouteLocked(installed)
+        delete(v.subnetRoutes, key)
+    }
+
+    keys := make([]string, 0, len(want))
+    for key := range want {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        if _, ok := v.subnetRoutes[key]; ok {
+            continue
+        }
+        route := v.installRouteLocked(networks[key], want[key])
+        if route != nil {
+            v.subnetRoutes[key] = route
+        }
+    }
+}
+
+// preferRoute reports whether id should carry network over current
+func (v *VPNManager) preferRoute(network *net.IPNet, id, current peer.ID) bool {
+    if isDefaultRoute(network) {
+        return v.exitNodeRank(id) < v.exitNodeRank(current)
+    }
+    return id < current
+}
+
+// installedRoute is a subnet route installed through a peer, along with
+// the bypass routes that keep the peer's own connections off the VPN
+type installedRoute struct {
+    network *net.IPNet
+    peerID  peer.ID
+    bypass  []net.IP
+}
+
+// installRouteLocked routes network through the peer id. v.mu must be held.
+func (v *VPNManager) installRouteLocked(network *net.IPNet, id peer.ID) *installedRoute {
+    route := &installedRoute{network: network, peerID: id}
+
+    if isDefaultRoute(network) {
+        // Traffic to the exit node itself must keep using the underlying
+        // network, or the tunnel would route into itself
+        for _, ip := range v.underlayIPs(id) {
+            if err := v.tun.AddBypassRoute(ip); err != nil {
+                fmt.Printf("Failed to add bypass route for %s: %v\n", ip, err)
+                continue
+            }
+            route.bypass = append(route.bypass, ip)
+        }
+    }
+
+    if err := v.tun.AddSubnetRoute(network, id.String()); err != nil {
+        fmt.Printf("Failed to add route %s via %s: %v\n", network, id, err)
+        for _, ip := range route.bypass {
+            v.tun.RemoveBypassRoute(ip)
+        }
+        return nil
+    }
+    return route
+}
+
+// uninstallRouteLocked removes a subnet route. v.mu must be held.
+func (v *VPNManager) uninstallRouteLocked(route *installedRoute) {
+    if err := v.tun.RemoveSubnetRoute(route.network); err != nil {
+        fmt.Printf("Failed to remove route %s: %v\n", route.network, err)
+    }
+    for _, ip := range route.bypass {
+        v.tun.RemoveBypassRoute(ip)
+    }
+}
+
+// underlayIPs returns the public IPv4 addresses this host is connected to
+// a peer on
+func (v *VPNManager) underlayIPs(id peer.ID) []net.IP {
+    var ips []net.IP
+    for _, conn := range v.host.Network().ConnsToPeer(id) {
+        ip, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_IP4)
+        if err != nil {
+            continue
+        }
+        parsed := net.ParseIP(ip).To4()
+        if parsed == nil || parsed.IsLoopback() || containsIP(ips, parsed) {
+            continue
+        }
+        ips = append(ips, parsed)
+    }
+    return ips
+}
+
+// containsIP reports whether ips contains ip
+func containsIP(ips []net.IP, ip net.IP) bool {
+    for _, other := range ips {
+        if bytes.Equal(other, ip) {
+            return true
+        }
+    }
+    return false
+}
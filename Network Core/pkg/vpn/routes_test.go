@@ -0,0 +1,135 @@
+package vpn
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTun records the subnet routes installed through it
+type fakeTun struct {
+	subnets map[string]string
+}
+
+func (f *fakeTun) start(int, func([]byte, string) error) error { return nil }
+func (f *fakeTun) close() error                                { return nil }
+func (f *fakeTun) write([]byte) error                          { return nil }
+func (f *fakeTun) setAddress(net.IP, net.IP, net.IPMask) error { return nil }
+func (f *fakeTun) updateRoute(string, string) error            { return nil }
+func (f *fakeTun) removeRoute(string) error                    { return nil }
+func (f *fakeTun) addBypassRoute(net.IP) error                 { return nil }
+func (f *fakeTun) removeBypassRoute(net.IP) error              { return nil }
+func (f *fakeTun) enableForwarding(string) error               { return nil }
+
+func (f *fakeTun) addSubnetRoute(network *net.IPNet, peerID string) error {
+	f.subnets[network.String()] = peerID
+	return nil
+}
+
+func (f *fakeTun) removeSubnetRoute(network *net.IPNet) error {
+	delete(f.subnets, network.String())
+	return nil
+}
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, network, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return network
+}
+
+func TestRouteTableLookup(t *testing.T) {
+	var table routeTable
+	table.setHost("10.42.0.5", "peer-a")
+	table.setSubnet(mustCIDR(t, "0.0.0.0/0"), "exit")
+	table.setSubnet(mustCIDR(t, "192.168.1.0/24"), "lan")
+	table.setSubnet(mustCIDR(t, "192.168.1.128/25"), "lan-upper")
+
+	lookup := func(ip string) string {
+		peerID, ok := table.lookup(net.ParseIP(ip))
+		if !ok {
+			return ""
+		}
+		return peerID
+	}
+	assert.Equal(t, "peer-a", lookup("10.42.0.5"))
+	assert.Equal(t, "lan", lookup("192.168.1.10"))
+	assert.Equal(t, "lan-upper", lookup("192.168.1.200"))
+	assert.Equal(t, "exit", lookup("8.8.8.8"))
+
+	table.removeSubnet(mustCIDR(t, "0.0.0.0/0"))
+	assert.Equal(t, "", lookup("8.8.8.8"))
+}
+
+func TestParseRoutes(t *testing.T) {
+	vpnNet := mustCIDR(t, DefaultNetworkCIDR)
+
+	routes, err := parseRoutes([]string{"0.0.0.0/0", "192.168.1.0/24"}, vpnNet)
+	require.NoError(t, err)
+	assert.Len(t, routes, 2)
+
+	for _, bad := range []string{"not-a-cidr", "fd00::/8", "10.42.5.0/24", "10.0.0.0/8"} {
+		_, err := parseRoutes([]string{bad}, vpnNet)
+		assert.ErrorIs(t, err, ErrInvalidRoute, bad)
+	}
+}
+
+func TestOSRoutesSplitsDefault(t *testing.T) {
+	var prefixes []string
+	for _, prefix := range osRoutes(mustCIDR(t, "0.0.0.0/0")) {
+		prefixes = append(prefixes, prefix.String())
+	}
+	assert.Equal(t, []string{"0.0.0.0/1", "128.0.0.0/1"}, prefixes)
+}
+
+func TestSyncRoutesPolicy(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer h.Close()
+
+	tun := &fakeTun{subnets: make(map[string]string)}
+	v := &VPNManager{
+		host:         h,
+		tun:          &TUNDevice{handle: tun},
+		peers:        make(map[peer.ID]*VPNPeer),
+		acceptRoutes: true,
+		exitNodes:    []peer.ID{"exit-b", "exit-a"},
+		subnetRoutes: make(map[string]*installedRoute),
+	}
+	addPeer := func(id peer.ID, routes ...string) {
+		p := &VPNPeer{ID: id, Active: true}
+		for _, route := range routes {
+			p.Routes = append(p.Routes, mustCIDR(t, route))
+		}
+		v.peers[id] = p
+	}
+
+	addPeer("exit-a", "0.0.0.0/0")
+	addPeer("exit-b", "0.0.0.0/0")
+	addPeer("rogue", "0.0.0.0/0", "192.168.1.0/24")
+	v.syncRoutesLocked()
+
+	// Only listed exit nodes carry the default route, the preferred first
+	assert.Equal(t, peer.ID("exit-b").String(), tun.subnets["0.0.0.0/0"])
+	assert.Equal(t, peer.ID("rogue").String(), tun.subnets["192.168.1.0/24"])
+
+	// Failover to the next exit node when the preferred one disconnects
+	v.peers["exit-b"].Active = false
+	v.syncRoutesLocked()
+	assert.Equal(t, peer.ID("exit-a").String(), tun.subnets["0.0.0.0/0"])
+
+	// Subnet routes need AcceptRoutes
+	v.acceptRoutes = false
+	v.syncRoutesLocked()
+	keys := make([]string, 0, len(tun.subnets))
+	for key := range tun.subnets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"0.0.0.0/0"}, keys)
+}
@@ -50,6 +50,16 @@ func (t *TUNDevice) WritePacket(packet []byte) error {
     return t.handle.write(packet)
 }
 
+// SetAddress moves the device to a new address, e.g. after an address
+// conflict
+func (t *TUNDevice) SetAddress(ip net.IP) error {
+    if err := t.handle.setAddress(t.config.PeerIP, ip, t.config.NetMask); err != nil {
+        return err
+    }
+    t.config.PeerIP = ip
+    return nil
+}
+
 // UpdateRoute adds or updates a route for a peer
 func (t *TUNDevice) UpdateRoute(ip string, peerID string) error {
     return t.handle.updateRoute(ip, peerID)
@@ -59,3 +69,30 @@ func (t *TUNDevice) UpdateRoute(ip string, peerID string) error {
 func (t *TUNDevice) RemoveRoute(ip string) error {
     return t.handle.removeRoute(ip)
 }
+
+// AddSubnetRoute routes an external subnet through a peer
+func (t *TUNDevice) AddSubnetRoute(network *net.IPNet, peerID string) error {
+    return t.handle.addSubnetRoute(network, peerID)
+}
+
+// RemoveSubnetRoute removes the route for an external subnet
+func (t *TUNDevice) RemoveSubnetRoute(network *net.IPNet) error {
+    return t.handle.removeSubnetRoute(network)
+}
+
+// AddBypassRoute keeps traffic to ip on the current default gateway, so
+// connections to an exit node do not loop through the VPN
+func (t *TUNDevice) AddBypassRoute(ip net.IP) error {
+    return t.handle.addBypassRoute(ip)
+}
+
+// RemoveBypassRoute removes a bypass route
+func (t *TUNDevice) RemoveBypassRoute(ip net.IP) error {
+    return t.handle.removeBypassRoute(ip)
+}
+
+// EnableForwarding lets the host forward VPN traffic to the networks it
+// advertises routes for
+func (t *TUNDevice) EnableForwarding() error {
+    return t.handle.enableForwarding(t.config.Network)
+}
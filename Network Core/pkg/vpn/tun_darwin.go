@@ -0,0 +1,289 @@
+//go:build darwin
+
+package vpn
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+
+    "golang.org/x/sys/unix"
+)
+
+const (
+    // utun kernel control, see <net/if_utun.h>
+    utunControlName = "com.apple.net.utun_control"
+    sysprotoControl = 2
+    utunOptIfName   = 2
+
+    // utun frames every packet with a 4-byte address family header
+    utunHeaderSize = 4
+)
+
+type darwinTun struct {
+    fd        *os.File
+    device    string
+    network   string
+    routes    routeTable
+    stopChan  chan struct{}
+}
+
+func newTunDevice(cfg TUNConfig) (tunHandle, error) {
+    // utun devices are always named utunN; honour a requested unit number
+    // and let the kernel pick one otherwise
+    var unit uint32
+    if n, err := strconv.Atoi(strings.TrimPrefix(cfg.Name, "utun")); err == nil && strings.HasPrefix(cfg.Name, "utun") {
+        unit = uint32(n) + 1
+    }
+
+    fd, err := unix.Socket(unix.AF_SYSTEM, unix.SOCK_DGRAM, sysprotoControl)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open utun control socket: %w", err)
+    }
+
+    info := &unix.CtlInfo{}
+    copy(info.Name[:], utunControlName)
+    if err := unix.IoctlCtlInfo(fd, info); err != nil {
+        unix.Close(fd)
+        return nil, fmt.Errorf("failed to look up utun control: %w", err)
+    }
+
+    if err := unix.Connect(fd, &unix.SockaddrCtl{ID: info.Id, Unit: unit}); err != nil {
+        unix.Close(fd)
+        return nil, fmt.Errorf("failed to create utun device: %w", err)
+    }
+
+    device, err := unix.GetsockoptString(fd, sysprotoControl, utunOptIfName)
+    if err != nil {
+        unix.Close(fd)
+        return nil, fmt.Errorf("failed to get utun device name: %w", err)
+    }
+
+    tun := &darwinTun{
+        fd:       os.NewFile(uintptr(fd), device),
+        device:   device,
+        network:  cfg.Network,
+        stopChan: make(chan struct{}),
+    }
+
+    // Configure interface address and routes
+    if err := configureInterface(device, cfg.PeerIP, cfg.NetMask, cfg.MTU); err != nil {
+        tun.close()
+        return nil, fmt.Errorf("failed to configure interface: %w", err)
+    }
+
+    return tun, nil
+}
+
+func (t *darwinTun) start(mtu int, handler func([]byte, string) error) error {
+    go t.readPackets(mtu, handler)
+    return nil
+}
+
+func (t *darwinTun) close() error {
+    close(t.stopChan)
+    return t.fd.Close()
+}
+
+func (t *darwinTun) write(packet []byte) error {
+    if len(packet) == 0 {
+        return nil
+    }
+
+    family := uint32(unix.AF_INET)
+    if packet[0]>>4 == 6 {
+        family = unix.AF_INET6
+    }
+    frame := make([]byte, utunHeaderSize+len(packet))
+    binary.BigEndian.PutUint32(frame, family)
+    copy(frame[utunHeaderSize:], packet)
+
+    _, err := t.fd.Write(frame)
+    return err
+}
+
+func (t *darwinTun) setAddress(old, ip net.IP, mask net.IPMask) error {
+    if old != nil {
+        // The old address may already be gone; adding the new one is what
+        // matters
+        runCommand("ifconfig", t.device, "inet", old.String(), "-alias")
+    }
+
+    // utun is point-to-point, so the address doubles as the destination
+    if err := runCommand("ifconfig", t.device, "inet", ip.String(), ip.String(),
+        "netmask", net.IP(mask).String(), "alias"); err != nil {
+        return fmt.Errorf("failed to set interface address: %w", err)
+    }
+    return nil
+}
+
+func (t *darwinTun) updateRoute(ip string, peerID string) error {
+    parsedIP := net.ParseIP(ip)
+    if parsedIP == nil {
+        return fmt.Errorf("invalid IP address: %s", ip)
+    }
+
+    // Add host route through the utun interface
+    args := []string{
+        "-n", "add",
+        "-host", ip,
+        "-interface", t.device,
+    }
+
+    if err := runCommand("route", args...); err != nil {
+        return fmt.Errorf("failed to add route: %w", err)
+    }
+
+    t.routes.setHost(ip, peerID)
+    return nil
+}
+
+func (t *darwinTun) removeRoute(ip string) error {
+    args := []string{
+        "-n", "delete",
+        "-host", ip,
+    }
+
+    if err := runCommand("route", args...); err != nil {
+        return fmt.Errorf("failed to remove route: %w", err)
+    }
+
+    t.routes.removeHost(ip)
+    return nil
+}
+
+func (t *darwinTun) addSubnetRoute(network *net.IPNet, peerID string) error {
+    for _, prefix := range osRoutes(network) {
+        if err := runCommand("route", "-n", "add", "-net", prefix.String(), "-interface", t.device); err != nil {
+            return fmt.Errorf("failed to add route %s: %w", prefix, err)
+        }
+    }
+
+    t.routes.setSubnet(network, peerID)
+    return nil
+}
+
+func (t *darwinTun) removeSubnetRoute(network *net.IPNet) error {
+    t.routes.removeSubnet(network)
+
+    var firstErr error
+    for _, prefix := range osRoutes(network) {
+        if err := runCommand("route", "-n", "delete", "-net", prefix.String(), "-interface", t.device); err != nil && firstErr == nil {
+            firstErr = fmt.Errorf("failed to remove route %s: %w", prefix, err)
+        }
+    }
+    return firstErr
+}
+
+func (t *darwinTun) addBypassRoute(ip net.IP) error {
+    gateway, err := defaultGateway()
+    if err != nil {
+        return err
+    }
+
+    if err := runCommand("route", "-n", "add", "-host", ip.String(), gateway); err != nil {
+        return fmt.Errorf("failed to add bypass route: %w", err)
+    }
+    return nil
+}
+
+func (t *darwinTun) removeBypassRoute(ip net.IP) error {
+    if err := runCommand("route", "-n", "delete", "-host", ip.String()); err != nil {
+        return fmt.Errorf("failed to remove bypass route: %w", err)
+    }
+    return nil
+}
+
+func (t *darwinTun) enableForwarding(network string) error {
+    // Translating the forwarded traffic needs a pf NAT rule, which is left
+    // to the host's firewall configuration
+    if err := runCommand("sysctl", "-w", "net.inet.ip.forwarding=1"); err != nil {
+        return fmt.Errorf("failed to enable IP forwarding: %w", err)
+    }
+    return nil
+}
+
+func (t *darwinTun) readPackets(mtu int, handler func([]byte, string) error) {
+    buffer := make([]byte, mtu+utunHeaderSize)
+    for {
+        select {
+        case <-t.stopChan:
+            return
+        default:
+            n, err := t.fd.Read(buffer)
+            if err != nil || n <= utunHeaderSize {
+                continue
+            }
+
+            packet := make([]byte, n-utunHeaderSize)
+            copy(packet, buffer[utunHeaderSize:n])
+
+            // Extract destination IP from packet
+            if len(packet) < 20 {
+                continue
+            }
+            dstIP := net.IP(packet[16:20])
+
+            // Find peer ID for destination
+            if peerID, ok := t.routes.lookup(dstIP); ok {
+                if err := handler(packet, peerID); err != nil {
+                    fmt.Printf("Error handling packet: %v\n", err)
+                }
+            }
+        }
+    }
+}
+
+// macOS-specific helper functions
+
+func configureInterface(name string, ip net.IP, mask net.IPMask, mtu int) error {
+    // Configure IP address and bring interface up
+    args := []string{
+        name, "inet", ip.String(), ip.String(),
+        "netmask", net.IP(mask).String(),
+        "mtu", strconv.Itoa(mtu),
+        "up",
+    }
+    if err := runCommand("ifconfig", args...); err != nil {
+        return fmt.Errorf("failed to set interface address: %w", err)
+    }
+
+    // A point-to-point address carries no subnet route of its own
+    subnet := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+    if err := runCommand("route", "-n", "add", "-net", subnet.String(), "-interface", name); err != nil {
+        return fmt.Errorf("failed to add subnet route: %w", err)
+    }
+
+    return nil
+}
+
+// defaultGateway returns the gateway of the IPv4 default route
+func defaultGateway() (string, error) {
+    out, err := commandOutput("route", "-n", "get", "default")
+    if err != nil {
+        return "", err
+    }
+    for _, line := range strings.Split(out, "\n") {
+        if value, ok := strings.CutPrefix(strings.TrimSpace(line), "gateway:"); ok {
+            return strings.TrimSpace(value), nil
+        }
+    }
+    return "", fmt.Errorf("no default route")
+}
+
+func runCommand(name string, args ...string) error {
+    _, err := commandOutput(name, args...)
+    return err
+}
+
+func commandOutput(name string, args ...string) (string, error) {
+    out, err := exec.Command(name, args...).CombinedOutput()
+    if err != nil {
+        return "", fmt.Errorf("command %s failed: %w: %s", name, err, strings.TrimSpace(string(out)))
+    }
+    return string(out), nil
+}
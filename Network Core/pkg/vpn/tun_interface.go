@@ -1,5 +1,7 @@
 package vpn
 
+import "net"
+
 // tunHandle represents the platform-specific TUN implementation
 type tunHandle interface {
     // start begins reading packets from the TUN device
@@ -11,11 +13,30 @@ type tunHandle interface {
     // write sends a packet to the TUN device
     write(packet []byte) error
     
+    // setAddress replaces the device address old with ip
+    setAddress(old, ip net.IP, mask net.IPMask) error
+    
     // updateRoute adds or updates a route for a peer
     updateRoute(ip string, peerID string) error
     
     // removeRoute removes a route for a peer
     removeRoute(ip string) error
+    
+    // addSubnetRoute routes an external subnet through a peer
+    addSubnetRoute(network *net.IPNet, peerID string) error
+    
+    // removeSubnetRoute removes the route for an external subnet
+    removeSubnetRoute(network *net.IPNet) error
+    
+    // addBypassRoute routes ip through the current default gateway
+    addBypassRoute(ip net.IP) error
+    
+    // removeBypassRoute removes a bypass route
+    removeBypassRoute(ip net.IP) error
+    
+    // enableForwarding forwards and translates traffic from the VPN
+    // network to other networks
+    enableForwarding(network string) error
 }
 
 // createTunDevice creates a platform-specific TUN device
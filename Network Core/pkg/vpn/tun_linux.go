@@ -5,8 +5,10 @@ package vpn
 import (
     "fmt"
     "net"
+    "encoding/binary"
+    "encoding/hex"
     "os"
-    "sync"
+    "strings"
     "syscall"
     "unsafe"
 
@@ -22,7 +24,7 @@ type linuxTun struct {
     fd        *os.File
     device    string
     network   string
-    routes    sync.Map
+    routes    routeTable
     stopChan  chan struct{}
 }
 
@@ -87,6 +89,21 @@ func (t *linuxTun) write(packet []byte) error {
     return err
 }
 
+func (t *linuxTun) setAddress(old, ip net.IP, mask net.IPMask) error {
+    cidr := networkMaskToCIDR(mask)
+    if old != nil {
+        // The old address may already be gone; adding the new one is what
+        // matters
+        runCommand("ip", "addr", "del", fmt.Sprintf("%s/%d", old, cidr), "dev", t.device)
+    }
+
+    addr := fmt.Sprintf("%s/%d", ip, cidr)
+    if err := runCommand("ip", "addr", "add", addr, "dev", t.device); err != nil {
+        return fmt.Errorf("failed to set interface address: %w", err)
+    }
+    return nil
+}
+
 func (t *linuxTun) updateRoute(ip string, peerID string) error {
     parsedIP := net.ParseIP(ip)
     if parsedIP == nil {
@@ -104,7 +121,7 @@ func (t *linuxTun) updateRoute(ip string, peerID string) error {
         return fmt.Errorf("failed to add route: %w", err)
     }
 
-    t.routes.Store(ip, peerID)
+    t.routes.setHost(ip, peerID)
     return nil
 }
 
@@ -119,7 +136,72 @@ func (t *linuxTun) removeRoute(ip string) error {
         return fmt.Errorf("failed to remove route: %w", err)
     }
 
-    t.routes.Delete(ip)
+    t.routes.removeHost(ip)
+    return nil
+}
+
+func (t *linuxTun) addSubnetRoute(network *net.IPNet, peerID string) error {
+    for _, prefix := range osRoutes(network) {
+        if err := runCommand("ip", "route", "add", prefix.String(), "dev", t.device); err != nil {
+            return fmt.Errorf("failed to add route %s: %w", prefix, err)
+        }
+    }
+
+    t.routes.setSubnet(network, peerID)
+    return nil
+}
+
+func (t *linuxTun) removeSubnetRoute(network *net.IPNet) error {
+    t.routes.removeSubnet(network)
+
+    var firstErr error
+    for _, prefix := range osRoutes(network) {
+        if err := runCommand("ip", "route", "del", prefix.String(), "dev", t.device); err != nil && firstErr == nil {
+            firstErr = fmt.Errorf("failed to remove route %s: %w", prefix, err)
+        }
+    }
+    return firstErr
+}
+
+func (t *linuxTun) addBypassRoute(ip net.IP) error {
+    gateway, iface, err := defaultGateway()
+    if err != nil {
+        return err
+    }
+
+    args := []string{
+        "route", "add",
+        ip.String() + "/32",
+        "via", gateway.String(),
+        "dev", iface,
+    }
+    if err := runCommand("ip", args...); err != nil {
+        return fmt.Errorf("failed to add bypass route: %w", err)
+    }
+    return nil
+}
+
+func (t *linuxTun) removeBypassRoute(ip net.IP) error {
+    if err := runCommand("ip", "route", "del", ip.String()+"/32"); err != nil {
+        return fmt.Errorf("failed to remove bypass route: %w", err)
+    }
+    return nil
+}
+
+func (t *linuxTun) enableForwarding(network string) error {
+    if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+        return fmt.Errorf("failed to enable IP forwarding: %w", err)
+    }
+
+    // Masquerade VPN traffic leaving for other networks, unless a previous
+    // run already added the rule
+    rule := []string{"POSTROUTING", "-s", network, "!", "-d", network, "-j", "MASQUERADE"}
+    if runCommand("iptables", append([]string{"-t", "nat", "-C"}, rule...)...) == nil {
+        return nil
+    }
+    if err := runCommand("iptables", append([]string{"-t", "nat", "-A"}, rule...)...); err != nil {
+        return fmt.Errorf("failed to add NAT rule: %w", err)
+    }
     return nil
 }
 
@@ -142,14 +224,12 @@ func (t *linuxTun) readPackets(mtu int, handler func([]byte, string) error) {
             if len(packet) < 20 {
                 continue
             }
-            dstIP := net.IP(packet[16:20]).String()
+            dstIP := net.IP(packet[16:20])
 
             // Find peer ID for destination
-            if val, ok := t.routes.Load(dstIP); ok {
-                if peerID, ok := val.(string); ok {
-                    if err := handler(packet, peerID); err != nil {
-                        fmt.Printf("Error handling packet: %v\n", err)
-                    }
+            if peerID, ok := t.routes.lookup(dstIP); ok {
+                if err := handler(packet, peerID); err != nil {
+                    fmt.Printf("Error handling packet: %v\n", err)
                 }
             }
         }
@@ -173,6 +253,32 @@ func configureInterface(name string, ip net.IP, mask net.IPMask) error {
     return nil
 }
 
+// defaultGateway returns the gateway and interface of the IPv4 default
+// route
+func defaultGateway() (net.IP, string, error) {
+    data, err := os.ReadFile("/proc/net/route")
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to read routing table: %w", err)
+    }
+
+    // Columns: Iface Destination Gateway Flags ..., addresses in host
+    // byte order
+    for _, line := range strings.Split(string(data), "\n")[1:] {
+        fields := strings.Fields(line)
+        if len(fields) < 3 || fields[1] != "00000000" {
+            continue
+        }
+        raw, err := hex.DecodeString(fields[2])
+        if err != nil || len(raw) != 4 {
+            continue
+        }
+        gateway := make(net.IP, 4)
+        binary.BigEndian.PutUint32(gateway, binary.LittleEndian.Uint32(raw))
+        return gateway, fields[0], nil
+    }
+    return nil, "", fmt.Errorf("no default route")
+}
+
 func networkMaskToCIDR(mask net.IPMask) int {
     ones, _ := mask.Size()
     return ones
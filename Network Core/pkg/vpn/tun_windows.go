@@ -3,45 +3,56 @@
 package vpn
 
 import (
+    "encoding/binary"
     "fmt"
     "net"
+    "strconv"
     "sync"
     "syscall"
+    "unsafe"
 
     "golang.org/x/sys/windows"
 )
 
 type winTun struct {
-    handle    windows.Handle
+    adapter   wintunAdapter
+    session   wintunSession
     device    string
     network   string
-    adapter   string
     mtu       int
-    routes    sync.Map
-    readChan  chan []byte
+    routes    routeTable
     stopChan  chan struct{}
+
+    // sessionMu keeps the session alive while packets are exchanged
+    sessionMu sync.RWMutex
+    closed    bool
 }
 
 func newTunDevice(cfg TUNConfig) (tunHandle, error) {
-    // Create Windows TUN adapter using WinTun driver
+    // Create Windows TUN adapter using the WinTun driver
     device := fmt.Sprintf("FileZap-%s", cfg.Name)
-    handle, err := createWinTunAdapter(device)
+    adapter, err := createWintunAdapter(device)
     if err != nil {
         return nil, fmt.Errorf("failed to create WinTun adapter: %w", err)
     }
 
+    session, err := adapter.startSession()
+    if err != nil {
+        adapter.close()
+        return nil, fmt.Errorf("failed to start WinTun session: %w", err)
+    }
+
     tun := &winTun{
-        handle:   handle,
+        adapter:  adapter,
+        session:  session,
         device:   device,
         network:  cfg.Network,
-        adapter:  cfg.Name,
         mtu:      cfg.MTU,
-        readChan: make(chan []byte, 100),
         stopChan: make(chan struct{}),
     }
 
     // Configure adapter
-    if err := configureAdapter(tun.adapter, cfg.PeerIP, cfg.NetMask); err != nil {
+    if err := configureAdapter(tun.device, cfg.PeerIP, cfg.NetMask, cfg.MTU); err != nil {
         tun.close()
         return nil, fmt.Errorf("failed to configure adapter: %w", err)
     }
@@ -55,88 +66,199 @@ func (t *winTun) start(mtu int, handler func([]byte, string) error) error {
 }
 
 func (t *winTun) close() error {
-    close(t.stopChan)
-    if t.handle != 0 {
-        windows.CloseHandle(t.handle)
-        t.handle = 0
+    t.sessionMu.Lock()
+    defer t.sessionMu.Unlock()
+    if t.closed {
+        return nil
     }
+    t.closed = true
+    close(t.stopChan)
+
+    // Removing the adapter also drops its routes
+    t.session.end()
+    t.adapter.close()
     return nil
 }
 
 func (t *winTun) write(packet []byte) error {
-    var written uint32
-    err := windows.WriteFile(t.handle, packet, &written, nil)
-    if err != nil {
+    t.sessionMu.RLock()
+    defer t.sessionMu.RUnlock()
+    if t.closed {
+        return fmt.Errorf("write failed: device closed")
+    }
+    if err := t.session.send(packet); err != nil {
         return fmt.Errorf("write failed: %w", err)
     }
     return nil
 }
 
+func (t *winTun) setAddress(old, ip net.IP, mask net.IPMask) error {
+    // Setting a static address replaces the previous one
+    if err := configureAdapter(t.device, ip, mask, t.mtu); err != nil {
+        return fmt.Errorf("failed to set adapter address: %w", err)
+    }
+    return nil
+}
+
 func (t *winTun) updateRoute(ip string, peerID string) error {
     parsedIP := net.ParseIP(ip)
     if parsedIP == nil {
         return fmt.Errorf("invalid IP address: %s", ip)
     }
 
-    // Add route to Windows routing table
+    // Add route to Windows routing table; active routes vanish with the
+    // adapter
     args := []string{
-        "route", "add",
-        ip, "mask", "255.255.255.255",
-        t.adapter,
+        "interface", "ipv4", "add", "route",
+        ip + "/32", t.device,
+        "store=active",
     }
     
     if err := runCommand("netsh", args...); err != nil {
         return fmt.Errorf("failed to add route: %w", err)
     }
 
-    t.routes.Store(ip, peerID)
+    t.routes.setHost(ip, peerID)
     return nil
 }
 
 func (t *winTun) removeRoute(ip string) error {
     // Remove route from Windows routing table
     args := []string{
-        "route", "delete",
-        ip,
+        "interface", "ipv4", "delete", "route",
+        ip + "/32", t.device,
+        "store=active",
     }
     
     if err := runCommand("netsh", args...); err != nil {
         return fmt.Errorf("failed to remove route: %w", err)
     }
 
-    t.routes.Delete(ip)
+    t.routes.removeHost(ip)
+    return nil
+}
+
+func (t *winTun) addSubnetRoute(network *net.IPNet, peerID string) error {
+    for _, prefix := range osRoutes(network) {
+        args := []string{
+            "interface", "ipv4", "add", "route",
+            prefix.String(), t.device,
+            "store=active",
+        }
+        if err := runCommand("netsh", args...); err != nil {
+            return fmt.Errorf("failed to add route %s: %w", prefix, err)
+        }
+    }
+
+    t.routes.setSubnet(network, peerID)
+    return nil
+}
+
+func (t *winTun) removeSubnetRoute(network *net.IPNet) error {
+    t.routes.removeSubnet(network)
+
+    var firstErr error
+    for _, prefix := range osRoutes(network) {
+        args := []string{
+            "interface", "ipv4", "delete", "route",
+            prefix.String(), t.device,
+            "store=active",
+        }
+        if err := runCommand("netsh", args...); err != nil && firstErr == nil {
+            firstErr = fmt.Errorf("failed to remove route %s: %w", prefix, err)
+        }
+    }
+    return firstErr
+}
+
+func (t *winTun) addBypassRoute(ip net.IP) error {
+    // Pin the route the address currently takes, before the VPN's default
+    // route is installed
+    row, err := bestRoute(ip)
+    if err != nil {
+        return err
+    }
+
+    nextHop := make(net.IP, 4)
+    binary.LittleEndian.PutUint32(nextHop, row.ForwardNextHop)
+    args := []string{
+        "interface", "ipv4", "add", "route",
+        ip.String() + "/32", strconv.Itoa(int(row.ForwardIfIndex)),
+        nextHop.String(),
+        "store=active",
+    }
+    if err := runCommand("netsh", args...); err != nil {
+        return fmt.Errorf("failed to add bypass route: %w", err)
+    }
+    return nil
+}
+
+func (t *winTun) removeBypassRoute(ip net.IP) error {
+    row, err := bestRoute(ip)
+    if err != nil {
+        return err
+    }
+
+    args := []string{
+        "interface", "ipv4", "delete", "route",
+        ip.String() + "/32", strconv.Itoa(int(row.ForwardIfIndex)),
+        "store=active",
+    }
+    if err := runCommand("netsh", args...); err != nil {
+        return fmt.Errorf("failed to remove bypass route: %w", err)
+    }
+    return nil
+}
+
+func (t *winTun) enableForwarding(network string) error {
+    // Translating the forwarded traffic needs a NAT configured on the
+    // host, which is left to its administrator
+    args := []string{
+        "interface", "ipv4", "set",
+        "interface", t.device,
+        "forwarding=enabled",
+        "store=active",
+    }
+    if err := runCommand("netsh", args...); err != nil {
+        return fmt.Errorf("failed to enable IP forwarding: %w", err)
+    }
     return nil
 }
 
 func (t *winTun) readPackets(handler func([]byte, string) error) {
-    buffer := make([]byte, t.mtu)
+    readEvent := t.session.readWaitEvent()
     for {
         select {
         case <-t.stopChan:
             return
         default:
-            var read uint32
-            err := windows.ReadFile(t.handle, buffer, &read, nil)
+            t.sessionMu.RLock()
+            if t.closed {
+                t.sessionMu.RUnlock()
+                return
+            }
+            packet, err := t.session.receive()
+            t.sessionMu.RUnlock()
+            if err == errNoPacket {
+                // Wake periodically to notice the device closing
+                windows.WaitForSingleObject(readEvent, 250)
+                continue
+            }
             if err != nil {
                 continue
             }
 
-            packet := make([]byte, read)
-            copy(packet, buffer[:read])
-
             // Extract destination IP from packet
             if len(packet) < 20 {
                 continue
             }
-            dstIP := net.IP(packet[16:20]).String()
+            dstIP := net.IP(packet[16:20])
 
             // Find peer ID for destination
-            if val, ok := t.routes.Load(dstIP); ok {
-                if peerID, ok := val.(string); ok {
-                    if err := handler(packet, peerID); err != nil {
-                        // Log error but continue processing packets
-                        fmt.Printf("Error handling packet: %v\n", err)
-                    }
+            if peerID, ok := t.routes.lookup(dstIP); ok {
+                if err := handler(packet, peerID); err != nil {
+                    // Log error but continue processing packets
+                    fmt.Printf("Error handling packet: %v\n", err)
                 }
             }
         }
@@ -145,21 +267,66 @@ func (t *winTun) readPackets(handler func([]byte, string) error) {
 
 // Windows-specific helper functions
 
-func createWinTunAdapter(name string) (windows.Handle, error) {
-    // Load WinTun driver DLL and create adapter
-    // Implementation depends on WinTun driver API
-    return 0, fmt.Errorf("WinTun implementation not included")
+var procGetBestRoute = windows.NewLazySystemDLL("iphlpapi.dll").NewProc("GetBestRoute")
+
+// mibIPForwardRow is MIB_IPFORWARDROW; addresses are in network byte order
+type mibIPForwardRow struct {
+    ForwardDest      uint32
+    ForwardMask      uint32
+    ForwardPolicy    uint32
+    ForwardNextHop   uint32
+    ForwardIfIndex   uint32
+    ForwardType      uint32
+    ForwardProto     uint32
+    ForwardAge       uint32
+    ForwardNextHopAS uint32
+    ForwardMetric1   uint32
+    ForwardMetric2   uint32
+    ForwardMetric3   uint32
+    ForwardMetric4   uint32
+    ForwardMetric5   uint32
+}
+
+// bestRoute returns the route the system uses to reach ip
+func bestRoute(ip net.IP) (*mibIPForwardRow, error) {
+    ip4 := ip.To4()
+    if ip4 == nil {
+        return nil, fmt.Errorf("invalid IPv4 address: %s", ip)
+    }
+
+    var row mibIPForwardRow
+    r, _, _ := procGetBestRoute.Call(
+        uintptr(binary.LittleEndian.Uint32(ip4)),
+        0,
+        uintptr(unsafe.Pointer(&row)),
+    )
+    if r != 0 {
+        return nil, fmt.Errorf("GetBestRoute: %w", syscall.Errno(r))
+    }
+    return &row, nil
 }
 
-func configureAdapter(name string, ip net.IP, mask net.IPMask) error {
+func configureAdapter(name string, ip net.IP, mask net.IPMask, mtu int) error {
     args := []string{
         "interface", "ipv4", "set",
         "address", name,
         "static", ip.String(),
         fmt.Sprintf("%d.%d.%d.%d", mask[0], mask[1], mask[2], mask[3]),
     }
-    
-    return runCommand("netsh", args...)
+    if err := runCommand("netsh", args...); err != nil {
+        return fmt.Errorf("failed to set adapter address: %w", err)
+    }
+
+    args = []string{
+        "interface", "ipv4", "set",
+        "subinterface", name,
+        fmt.Sprintf("mtu=%d", mtu),
+        "store=active",
+    }
+    if err := runCommand("netsh", args...); err != nil {
+        return fmt.Errorf("failed to set adapter MTU: %w", err)
+    }
+    return nil
 }
 
 func runCommand(name string, args ...string) error {
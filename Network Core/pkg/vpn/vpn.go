@@ -2,12 +2,12 @@ package vpn
 
 import (
     "context"
-    "crypto/sha256"
     "fmt"
     "net"
     "sync"
     "time"
 
+    "github.com/libp2p/go-libp2p/core/crypto"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/network"
     "github.com/libp2p/go-libp2p/core/peer"
@@ -25,23 +25,39 @@ const (
 
 // VPNManager handles the virtual network overlay
 type VPNManager struct {
-    host     host.Host
-    tun      *TUNDevice
-    peers    map[peer.ID]*VPNPeer
-    streams  map[peer.ID]network.Stream
-    baseIP   net.IP
-    netmask  net.IPMask
-    ctx      context.Context
-    cancel   context.CancelFunc
-    mu       sync.RWMutex
+    host      host.Host
+    tun       *TUNDevice
+    peers     map[peer.ID]*VPNPeer
+    streams   map[peer.ID]network.Stream
+    baseIP    net.IP
+    netmask   net.IPMask
+    claims    ClaimStore
+    localIP   net.IP
+    claimedAt int64 // When localIP was claimed, Unix nanoseconds
+    networkKey []byte
+    authority  crypto.PubKey
+    allowlist  *Allowlist
+    credential *Credential // Presented to peers that verify credentials
+    advertised   []*net.IPNet // Subnets this peer forwards traffic to
+    acceptRoutes bool
+    exitNodes    []peer.ID
+    subnetRoutes map[string]*installedRoute // Installed routes by subnet
+    ctx       context.Context
+    cancel    context.CancelFunc
+    mu        sync.RWMutex
+    assignMu  sync.Mutex // Serializes address re-assignment
 }
 
 // VPNPeer represents a connected peer in the VPN
 type VPNPeer struct {
-    ID       peer.ID
-    IP       net.IP
-    Stream   network.Stream
-    Active   bool
+    ID        peer.ID
+    IP        net.IP
+    ClaimedAt int64
+    Stream    network.Stream
+    Active    bool
+    Routes    []*net.IPNet // Subnets the peer advertises forwarding to
+
+    viaAllowlist bool // Admitted through the allowlist rather than the network key
 }
 
 // Config holds VPN configuration
@@ -49,6 +65,24 @@ type Config struct {
     NetworkCIDR string  // Network CIDR (e.g. "10.42.0.0/16")
     InterfaceName string // TUN interface name
     MTU          int    // Maximum transmission unit
+    Claims       ClaimStore // Where IP claims are negotiated, e.g. the DHT; nil to only check announced peers
+
+    // Membership. Peers must hold NetworkKey, a Credential issued to them
+    // or be on Allowlist, both signed by AllowlistAuthority, before routes
+    // to them are installed. With neither key nor authority set any peer
+    // may join. Credential is the one this peer presents.
+    NetworkKey         []byte
+    Allowlist          *Allowlist
+    AllowlistAuthority crypto.PubKey
+    Credential         *Credential
+
+    // Routing. AdvertiseRoutes are external subnets this peer forwards
+    // traffic to, such as a LAN prefix, or 0.0.0.0/0 to act as an exit
+    // node. Subnet routes from peers are installed if AcceptRoutes is set;
+    // default routes only from ExitNodes, in order of preference.
+    AdvertiseRoutes []string
+    AcceptRoutes    bool
+    ExitNodes       []peer.ID
 }
 
 // DefaultConfig returns default VPN configuration
@@ -68,6 +102,24 @@ func NewVPNManager(ctx context.Context, h host.Host, cfg *Config) (*VPNManager,
         return nil, fmt.Errorf("invalid network CIDR: %w", err)
     }
 
+    if cfg.Allowlist != nil {
+        if cfg.AllowlistAuthority == nil {
+            return nil, fmt.Errorf("%w: no allowlist authority configured", ErrInvalidAllowlist)
+        }
+        if err := VerifyAllowlist(cfg.Allowlist, cfg.AllowlistAuthority); err != nil {
+            return nil, err
+        }
+    }
+
+    if cfg.Credential != nil && cfg.Credential.Peer != h.ID() {
+        return nil, fmt.Errorf("%w: issued to %s", ErrInvalidCredential, cfg.Credential.Peer)
+    }
+
+    advertised, err := parseRoutes(cfg.AdvertiseRoutes, ipNet)
+    if err != nil {
+        return nil, err
+    }
+
     // Create VPN manager
     ctx, cancel := context.WithCancel(ctx)
     vpn := &VPNManager{
@@ -76,16 +128,27 @@ func NewVPNManager(ctx context.Context, h host.Host, cfg *Config) (*VPNManager,
         streams:  make(map[peer.ID]network.Stream),
         baseIP:   ipNet.IP,
         netmask:  ipNet.Mask,
+        claims:   cfg.Claims,
+        networkKey: cfg.NetworkKey,
+        authority:  cfg.AllowlistAuthority,
+        allowlist:  cfg.Allowlist,
+        credential: cfg.Credential,
+        advertised:   advertised,
+        acceptRoutes: cfg.AcceptRoutes,
+        exitNodes:    cfg.ExitNodes,
+        subnetRoutes: make(map[string]*installedRoute),
         ctx:      ctx,
         cancel:   cancel,
     }
 
-    // Calculate this peer's IP based on peer ID
-    peerIP, err := vpn.calculatePeerIP(h.ID())
+    // Negotiate this peer's IP, starting from the one derived from its ID
+    peerIP, claimedAt, err := vpn.assignIP(nil)
     if err != nil {
         cancel()
         return nil, err
     }
+    vpn.localIP = peerIP
+    vpn.claimedAt = claimedAt
 
     // Create TUN device
     tunCfg := TUNConfig{
@@ -104,6 +167,15 @@ func NewVPNManager(ctx context.Context, h host.Host, cfg *Config) (*VPNManager,
     }
     vpn.tun = tun
 
+    // Peers send traffic for advertised subnets here to be forwarded
+    if len(advertised) > 0 {
+        if err := tun.EnableForwarding(); err != nil {
+            cancel()
+            tun.Stop()
+            return nil, err
+        }
+    }
+
     // Set up stream handler
     h.SetStreamHandler(VPNProtocolID, vpn.handleStream)
 
@@ -113,6 +185,10 @@ func NewVPNManager(ctx context.Context, h host.Host, cfg *Config) (*VPNManager,
         return nil, fmt.Errorf("failed to start TUN device: %w", err)
     }
 
+    if vpn.claims != nil {
+        go vpn.maintainClaims()
+    }
+
     return vpn, nil
 }
 
@@ -127,6 +203,12 @@ func (v *VPNManager) Close() error {
         stream.Close()
     }
 
+    // Remove routes through peers, restoring the default route
+    for key, route := range v.subnetRoutes {
+        v.uninstallRouteLocked(route)
+        delete(v.subnetRoutes, key)
+    }
+
     // Stop TUN device
     if v.tun != nil {
         return v.tun.Stop()
@@ -136,7 +218,24 @@ func (v *VPNManager) Close() error {
 
 // GetLocalIP returns this peer's virtual IP address
 func (v *VPNManager) GetLocalIP() string {
-    return v.tun.config.PeerIP.String()
+    ip, _ := v.localAddress()
+    return ip.String()
+}
+
+// ClaimedAt returns when this peer claimed its virtual IP, in Unix
+// nanoseconds
+func (v *VPNManager) ClaimedAt() int64 {
+    _, claimedAt := v.localAddress()
+    return claimedAt
+}
+
+// AdvertisedRoutes returns the subnets this peer forwards traffic to
+func (v *VPNManager) AdvertisedRoutes() []string {
+    routes := make([]string, len(v.advertised))
+    for i, network := range v.advertised {
+        routes[i] = network.String()
+    }
+    return routes
 }
 
 // GetPeers returns a list of all known peer IDs
@@ -174,27 +273,27 @@ type VPNPeerInfo struct {
     IP string
 }
 
+// calculatePeerIP returns the address derived from a peer ID, used for
+// peers that have not announced a negotiated one
 func (v *VPNManager) calculatePeerIP(id peer.ID) (net.IP, error) {
-    // Hash the peer ID to get a deterministic value
-    hash := sha256.Sum256([]byte(id))
-    
-    // Use the first 2 bytes of the hash to generate the last two octets
-    // This gives us up to 65536 possible peer IPs
-    ip := make(net.IP, len(v.baseIP))
-    copy(ip, v.baseIP)
-    ip[2] = hash[0]
-    ip[3] = hash[1]
-
-    if ip[2] == 0 && ip[3] == 0 {
+    ip := v.ipAt(v.hostOffset(id))
+    if ip == nil {
         return nil, fmt.Errorf("invalid IP generated for peer %s", id)
     }
-
     return ip, nil
 }
 
 // handleStream processes incoming VPN streams
 func (v *VPNManager) handleStream(s network.Stream) {
     peer := s.Conn().RemotePeer()
+
+    // Only members get routes
+    viaAllowlist, err := v.acceptHandshake(s)
+    if err != nil {
+        fmt.Printf("Rejected VPN stream: %v\n", err)
+        s.Reset()
+        return
+    }
     
     v.mu.Lock()
     // Close existing stream if any
@@ -203,24 +302,26 @@ func (v *VPNManager) handleStream(s network.Stream) {
     }
     v.streams[peer] = s
     
-    // Calculate peer's IP
-    peerIP, err := v.calculatePeerIP(peer)
-    if err != nil {
-        v.mu.Unlock()
-        s.Close()
-        return
-    }
-    
-    // Create or update peer info
-    v.peers[peer] = &VPNPeer{
-        ID:     peer,
-        IP:     peerIP,
-        Stream: s,
-        Active: true,
+    // Prefer the address the peer announced over the derived one
+    info, known := v.peers[peer]
+    if !known || info.IP == nil {
+        peerIP, err := v.calculatePeerIP(peer)
+        if err != nil {
+            v.mu.Unlock()
+            s.Close()
+            return
+        }
+        info = &VPNPeer{ID: peer, IP: peerIP}
+        v.peers[peer] = info
     }
+    info.Stream = s
+    info.Active = true
+    info.viaAllowlist = viaAllowlist
+    peerIP := info.IP
     
     // Update TUN routing
     v.tun.UpdateRoute(peerIP.String(), peer.String())
+    v.syncRoutesLocked()
     v.mu.Unlock()
 
     // Handle stream data
@@ -235,6 +336,7 @@ func (v *VPNManager) streamReader(s network.Stream, peer peer.ID) {
             p.Active = false
         }
         delete(v.streams, peer)
+        v.syncRoutesLocked()
         v.mu.Unlock()
         s.Close()
     }()
@@ -276,31 +378,63 @@ func (v *VPNManager) handlePeerAnnouncement(info PeerInfo) {
         return
     }
 
+    announcedIP := net.ParseIP(info.VirtualIP)
+    if announcedIP == nil {
+        return
+    }
+
+    // Two peers on one address blackhole each other's traffic. The earlier
+    // claim keeps the address and the other peer re-assigns itself.
+    if announcedIP.Equal(v.localIP) {
+        if !claimWins(v.claimedAt, v.host.ID(), info.ClaimedAt, info.PeerID) {
+            go v.reassign(v.localIP)
+        }
+        return
+    }
+    for id, other := range v.peers {
+        if id != info.PeerID && other.IP.Equal(announcedIP) &&
+            claimWins(other.ClaimedAt, id, info.ClaimedAt, info.PeerID) {
+            // The current holder keeps the route until the newcomer moves
+            return
+        }
+    }
+
     // Create or update peer info
     peer, exists := v.peers[info.PeerID]
     if !exists {
         // New peer - create entry
         peer = &VPNPeer{
-            ID:     info.PeerID,
-            IP:     net.ParseIP(info.VirtualIP),
-            Active: false,
+            ID:        info.PeerID,
+            IP:        announcedIP,
+            ClaimedAt: info.ClaimedAt,
+            Active:    false,
         }
         v.peers[info.PeerID] = peer
         
         // Open stream to new peer
         go v.connectToPeer(info.PeerID)
     }
+    peer.ClaimedAt = info.ClaimedAt
+
+    // Routes failing validation are ignored rather than the whole peer
+    routes, err := parseRoutes(info.Routes, &net.IPNet{IP: v.baseIP, Mask: v.netmask})
+    if err != nil {
+        fmt.Printf("Ignoring routes from %s: %v\n", info.PeerID, err)
+        routes = nil
+    }
+    peer.Routes = routes
 
     // Update routing if IP changed
-    if peer.IP.String() != info.VirtualIP {
+    if !peer.IP.Equal(announcedIP) {
         if peer.Active {
             v.tun.RemoveRoute(peer.IP.String())
         }
-        peer.IP = net.ParseIP(info.VirtualIP)
+        peer.IP = announcedIP
         if peer.Active {
             v.tun.UpdateRoute(peer.IP.String(), info.PeerID.String())
         }
     }
+    v.syncRoutesLocked()
 }
 
 // connectToPeer attempts to establish a VPN stream with a peer
@@ -314,6 +448,13 @@ func (v *VPNManager) connectToPeer(id peer.ID) {
         return
     }
 
+    viaAllowlist, err := v.initiateHandshake(stream)
+    if err != nil {
+        fmt.Printf("Failed VPN handshake: %v\n", err)
+        stream.Reset()
+        return
+    }
+
     // Update peer info
     v.mu.Lock()
     if peer, exists := v.peers[id]; exists {
@@ -325,12 +466,32 @@ func (v *VPNManager) connectToPeer(id peer.ID) {
         v.streams[id] = stream
         peer.Stream = stream
         peer.Active = true
+        peer.viaAllowlist = viaAllowlist
         
         // Update routing
         v.tun.UpdateRoute(peer.IP.String(), id.String())
+        v.syncRoutesLocked()
     }
     v.mu.Unlock()
 
     // Start reading from stream
     go v.streamReader(stream, id)
 }
+
+// dropPeerLocked disconnects a peer and removes its route. v.mu must be
+// held.
+func (v *VPNManager) dropPeerLocked(id peer.ID) {
+    p, exists := v.peers[id]
+    if !exists {
+        return
+    }
+    if p.Active {
+        v.tun.RemoveRoute(p.IP.String())
+    }
+    if stream := v.streams[id]; stream != nil {
+        stream.Reset()
+    }
+    delete(v.streams, id)
+    delete(v.peers, id)
+    v.syncRoutesLocked()
+}
@@ -0,0 +1,131 @@
+//go:build windows
+
+package vpn
+
+import (
+    "errors"
+    "fmt"
+    "unsafe"
+
+    "golang.org/x/sys/windows"
+)
+
+const (
+    // wintunRingCapacity is the session ring size; must be a power of two
+    // between 128 KiB and 64 MiB
+    wintunRingCapacity = 0x400000
+    // wintunTunnelType groups FileZap adapters in the Windows UI
+    wintunTunnelType = "FileZap"
+)
+
+// wintun.dll ships alongside the executable; it is not a system library
+var (
+    modWintun = windows.NewLazyDLL("wintun.dll")
+
+    procWintunCreateAdapter        = modWintun.NewProc("WintunCreateAdapter")
+    procWintunCloseAdapter         = modWintun.NewProc("WintunCloseAdapter")
+    procWintunStartSession         = modWintun.NewProc("WintunStartSession")
+    procWintunEndSession           = modWintun.NewProc("WintunEndSession")
+    procWintunGetReadWaitEvent     = modWintun.NewProc("WintunGetReadWaitEvent")
+    procWintunReceivePacket        = modWintun.NewProc("WintunReceivePacket")
+    procWintunReleaseReceivePacket = modWintun.NewProc("WintunReleaseReceivePacket")
+    procWintunAllocateSendPacket   = modWintun.NewProc("WintunAllocateSendPacket")
+    procWintunSendPacket           = modWintun.NewProc("WintunSendPacket")
+)
+
+// errNoPacket is returned by receive when the ring is empty
+var errNoPacket = errors.New("no packet available")
+
+// wintunAdapter is a handle returned by WintunCreateAdapter
+type wintunAdapter uintptr
+
+// wintunSession is a handle returned by WintunStartSession
+type wintunSession uintptr
+
+// createWintunAdapter creates a wintun adapter named name
+func createWintunAdapter(name string) (wintunAdapter, error) {
+    if err := modWintun.Load(); err != nil {
+        return 0, fmt.Errorf("failed to load wintun.dll: %w", err)
+    }
+
+    name16, err := windows.UTF16PtrFromString(name)
+    if err != nil {
+        return 0, err
+    }
+    type16, err := windows.UTF16PtrFromString(wintunTunnelType)
+    if err != nil {
+        return 0, err
+    }
+
+    r, _, err := procWintunCreateAdapter.Call(
+        uintptr(unsafe.Pointer(name16)),
+        uintptr(unsafe.Pointer(type16)),
+        0, // let wintun pick the adapter GUID
+    )
+    if r == 0 {
+        return 0, fmt.Errorf("WintunCreateAdapter: %w", err)
+    }
+    return wintunAdapter(r), nil
+}
+
+// close removes the adapter
+func (a wintunAdapter) close() {
+    procWintunCloseAdapter.Call(uintptr(a))
+}
+
+// startSession opens the packet rings of the adapter
+func (a wintunAdapter) startSession() (wintunSession, error) {
+    r, _, err := procWintunStartSession.Call(uintptr(a), wintunRingCapacity)
+    if r == 0 {
+        return 0, fmt.Errorf("WintunStartSession: %w", err)
+    }
+    return wintunSession(r), nil
+}
+
+// end closes the session
+func (s wintunSession) end() {
+    procWintunEndSession.Call(uintptr(s))
+}
+
+// readWaitEvent returns the event signalled when packets are available
+func (s wintunSession) readWaitEvent() windows.Handle {
+    r, _, _ := procWintunGetReadWaitEvent.Call(uintptr(s))
+    return windows.Handle(r)
+}
+
+// receive copies the next packet out of the receive ring, returning
+// errNoPacket when the ring is empty
+func (s wintunSession) receive() ([]byte, error) {
+    var size uint32
+    r, _, err := procWintunReceivePacket.Call(uintptr(s), uintptr(unsafe.Pointer(&size)))
+    if r == 0 {
+        if err == windows.ERROR_NO_MORE_ITEMS {
+            return nil, errNoPacket
+        }
+        return nil, fmt.Errorf("WintunReceivePacket: %w", err)
+    }
+
+    packet := make([]byte, size)
+    copy(packet, ringSlice(r, int(size)))
+    procWintunReleaseReceivePacket.Call(uintptr(s), r)
+    return packet, nil
+}
+
+// send copies a packet into the send ring
+func (s wintunSession) send(packet []byte) error {
+    r, _, err := procWintunAllocateSendPacket.Call(uintptr(s), uintptr(len(packet)))
+    if r == 0 {
+        return fmt.Errorf("WintunAllocateSendPacket: %w", err)
+    }
+
+    copy(ringSlice(r, len(packet)), packet)
+    procWintunSendPacket.Call(uintptr(s), r)
+    return nil
+}
+
+// ringSlice views size bytes of wintun ring memory at addr. The ring is
+// allocated by the driver, outside the Go heap, so the address stays valid
+// until the packet is released or sent.
+func ringSlice(addr uintptr, size int) []byte {
+    return unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&addr))), size)
+}
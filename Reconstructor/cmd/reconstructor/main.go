@@ -15,6 +15,7 @@ func main() {
 	// Command line flags
 	zapFile := flag.String("zap", "", "Path to .zap file containing chunk metadata")
 	outputPath := flag.String("output", "", "Output path for reconstructed file")
+	workers := flag.Int("workers", 1, "Chunks written at once; above 1 the output is preallocated and written in parallel")
 
 	flag.Parse()
 
@@ -38,7 +39,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := reconstruct(*zapFile, *outputPath); err != nil {
+	if err := reconstruct(*zapFile, *outputPath, *workers); err != nil {
 		fmt.Printf("Error during reconstruction: %v\n", err)
 		os.Exit(1)
 	}
@@ -46,7 +47,7 @@ func main() {
 	fmt.Println("File successfully reconstructed!")
 }
 
-func reconstruct(zapPath, outputPath string) error {
+func reconstruct(zapPath, outputPath string, workers int) error {
 	// Read and validate zap file
 	metadata, err := zap.ReadZapFile(zapPath)
 	if err != nil {
@@ -88,7 +89,7 @@ func reconstruct(zapPath, outputPath string) error {
 		}
 
 		// Validate decrypted chunk
-		if err := zap.ValidateChunk(chunk, tempPath, decrypted); err != nil {
+		if err := zap.ValidateChunk(chunk, tempPath, decrypted, metadata.HashAlgorithm); err != nil {
 			return fmt.Errorf("chunk validation failed: %v", err)
 		}
 
@@ -101,7 +102,13 @@ func reconstruct(zapPath, outputPath string) error {
 	}
 
 	// Reassemble file
-	if err := chunking.ReassembleFile(chunkInfos, outputPath); err != nil {
+	reassemble := chunking.ReassembleFile
+	if workers > 1 {
+		reassemble = func(chunks []chunking.ChunkInfo, outputPath string) error {
+			return chunking.ReassembleFileParallel(chunks, outputPath, workers)
+		}
+	}
+	if err := reassemble(chunkInfos, outputPath); err != nil {
 		return fmt.Errorf("failed to reassemble file: %v", err)
 	}
 
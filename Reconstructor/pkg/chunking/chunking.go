@@ -18,37 +18,10 @@ type ChunkInfo struct {
 
 // ReassembleFile reassembles chunks back into the original file with enhanced validation
 func ReassembleFile(chunks []ChunkInfo, outputPath string) error {
-	// Validate chunks are present
-	if len(chunks) == 0 {
-		return fmt.Errorf("no chunks provided for reassembly")
-	}
-
-	// Sort chunks by index to ensure correct order
-	sort.Slice(chunks, func(i, j int) bool {
-		return chunks[i].Index < chunks[j].Index
-	})
-
-	// Validate chunk indexes are sequential
-	for i, chunk := range chunks {
-		if chunk.Index != i {
-			return fmt.Errorf("non-sequential chunk index detected: expected %d, got %d", i, chunk.Index)
-		}
+	if err := prepareReassembly(chunks, outputPath); err != nil {
+		return err
 	}
 
-// Check if path is valid
-if strings.HasPrefix(outputPath, "/") || // Unix absolute path
-   (len(outputPath) > 2 && outputPath[1] == ':') { // Windows absolute path
-    if !isWithinDirectory(outputPath, os.Getenv("USERPROFILE")) {
-        return fmt.Errorf("invalid output path: must be within user directory")
-    }
-}
-
-// Ensure output directory exists
-outputDir := filepath.Dir(outputPath)
-if err := os.MkdirAll(outputDir, 0755); err != nil {
-    return fmt.Errorf("failed to create output directory: %v", err)
-}
-
 // Create output file
 outFile, err := os.Create(outputPath)
 if err != nil {
@@ -98,6 +71,42 @@ defer outFile.Close()
 	return nil
 }
 
+// prepareReassembly sorts chunks by index, checks the indexes are
+// sequential and the output path is allowed, and creates its directory
+func prepareReassembly(chunks []ChunkInfo, outputPath string) error {
+	// Validate chunks are present
+	if len(chunks) == 0 {
+		return fmt.Errorf("no chunks provided for reassembly")
+	}
+
+	// Sort chunks by index to ensure correct order
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Index < chunks[j].Index
+	})
+
+	// Validate chunk indexes are sequential
+	for i, chunk := range chunks {
+		if chunk.Index != i {
+			return fmt.Errorf("non-sequential chunk index detected: expected %d, got %d", i, chunk.Index)
+		}
+	}
+
+	// Check if path is valid
+	if strings.HasPrefix(outputPath, "/") || // Unix absolute path
+		(len(outputPath) > 2 && outputPath[1] == ':') { // Windows absolute path
+		if !isWithinDirectory(outputPath, os.Getenv("USERPROFILE")) {
+			return fmt.Errorf("invalid output path: must be within user directory")
+		}
+	}
+
+	// Ensure output directory exists
+	outputDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	return nil
+}
+
 // CleanupTempFiles removes temporary decrypted chunk files
 func CleanupTempFiles(chunks []ChunkInfo) {
 	for _, chunk := range chunks {
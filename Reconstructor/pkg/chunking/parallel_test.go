@@ -0,0 +1,36 @@
+package chunking
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReassembleFileParallel(t *testing.T) {
+	tempDir := t.TempDir()
+	outputDir := t.TempDir()
+	t.Setenv("USERPROFILE", outputDir)
+
+	chunks, originalData := createTestChunks(t, tempDir, 17, 1000)
+	// Reverse the order; chunks are placed by index
+	for i, j := 0, len(chunks)-1; i < j; i, j = i+1, j-1 {
+		chunks[i], chunks[j] = chunks[j], chunks[i]
+	}
+
+	outputPath := filepath.Join(outputDir, "out.dat")
+	require.NoError(t, ReassembleFileParallel(chunks, outputPath, 4))
+	got, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(originalData, got))
+
+	// A bad chunk fails the reassembly and leaves no output
+	require.NoError(t, os.WriteFile(chunks[3].Filename, []byte("short"), 0644))
+	failedPath := filepath.Join(outputDir, "failed.dat")
+	assert.Error(t, ReassembleFileParallel(chunks, failedPath, 4))
+	_, err = os.Stat(failedPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,39 @@
+package zap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzReadZapFile feeds arbitrary .zap contents to ReadZapFile, which must
+// reject malformed metadata with an error and only accept files whose
+// chunk indexes cover the declared chunk count exactly once
+func FuzzReadZapFile(f *testing.F) {
+	f.Add([]byte(`{"id":"test123","original_name":"test.txt","chunk_count":2,"total_size":2048,"encryption_key":"testkey","chunks":[{"index":0,"hash":"a","size":1024,"encrypted_hash":"b"},{"index":1,"hash":"c","size":1024,"encrypted_hash":"d"}]}`))
+	f.Add([]byte(`{"chunk_count":1,"chunks":[{"index":5}]}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte{})
+
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		zapPath := filepath.Join(dir, "fuzz.zap")
+		if err := os.WriteFile(zapPath, data, 0644); err != nil {
+			t.Fatalf("failed to write zap file: %v", err)
+		}
+		metadata, err := ReadZapFile(zapPath)
+		if err != nil {
+			return
+		}
+		if len(metadata.Chunks) != metadata.ChunkCount {
+			t.Fatalf("accepted %d chunks for a chunk count of %d", len(metadata.Chunks), metadata.ChunkCount)
+		}
+		seen := make(map[int]bool)
+		for _, chunk := range metadata.Chunks {
+			if chunk.Index < 0 || chunk.Index >= metadata.ChunkCount || seen[chunk.Index] {
+				t.Fatalf("accepted chunk index %d", chunk.Index)
+			}
+			seen[chunk.Index] = true
+		}
+	})
+}
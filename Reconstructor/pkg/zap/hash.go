@@ -0,0 +1,31 @@
+package zap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"lukechampine.com/blake3"
+)
+
+// HashAlgorithm names the hash a file's chunks are verified by
+type HashAlgorithm string
+
+const (
+	HashSHA256 HashAlgorithm = "sha256" // Also used by files recording no algorithm
+	HashBLAKE3 HashAlgorithm = "blake3"
+)
+
+// Sum returns the hex hash of data. The empty algorithm is SHA-256.
+func (a HashAlgorithm) Sum(data []byte) (string, error) {
+	switch a {
+	case "", HashSHA256:
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	case HashBLAKE3:
+		sum := blake3.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", string(a))
+	}
+}
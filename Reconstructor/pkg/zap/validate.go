@@ -0,0 +1,115 @@
+package zap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultValidationWorkers is the number of chunks checked at once
+var DefaultValidationWorkers = runtime.NumCPU()
+
+// ChunkReport lists the chunks that failed validation, so that exactly
+// those can be fetched again
+type ChunkReport struct {
+	Missing []ChunkMetadata // Chunk files not found
+	Corrupt []ChunkMetadata // Chunk files of the wrong size
+}
+
+// OK reports whether every chunk passed
+func (r *ChunkReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0
+}
+
+// Error summarises the failed chunks
+func (r *ChunkReport) Error() string {
+	var parts []string
+	if len(r.Missing) > 0 {
+		parts = append(parts, fmt.Sprintf("%d chunk files missing: %s", len(r.Missing), chunkNames(r.Missing)))
+	}
+	if len(r.Corrupt) > 0 {
+		parts = append(parts, fmt.Sprintf("%d chunk files of the wrong size: %s", len(r.Corrupt), chunkNames(r.Corrupt)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// chunkNames lists the encrypted names of chunks
+func chunkNames(chunks []ChunkMetadata) string {
+	names := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		names[i] = chunk.EncryptedHash
+	}
+	return strings.Join(names, ", ")
+}
+
+// CheckChunks checks with up to workers goroutines that every chunk file
+// exists in chunksDir and has the size its metadata records. Missing and
+// corrupt chunks are collected in the report, ordered by index; any other
+// failure, such as an unreadable chunks directory, stops the check and is
+// returned as the error.
+func CheckChunks(metadata *FileMetadata, chunksDir string, workers int) (*ChunkReport, error) {
+	if _, err := os.Stat(chunksDir); err != nil {
+		return nil, fmt.Errorf("failed to access chunks directory: %v", err)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		report ChunkReport
+		fatal  error
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	jobs := make(chan ChunkMetadata)
+	stop := make(chan struct{})
+	var once sync.Once
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				info, err := os.Stat(filepath.Join(chunksDir, chunk.EncryptedHash))
+				mu.Lock()
+				switch {
+				case os.IsNotExist(err):
+					report.Missing = append(report.Missing, chunk)
+				case err != nil:
+					once.Do(func() {
+						fatal = fmt.Errorf("failed to access chunk %s: %v", chunk.EncryptedHash, err)
+						close(stop)
+					})
+				case info.Size() != chunk.Size:
+					report.Corrupt = append(report.Corrupt, chunk)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, chunk := range metadata.Chunks {
+		select {
+		case jobs <- chunk:
+		case <-stop:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if fatal != nil {
+		return nil, fatal
+	}
+	byIndex := func(chunks []ChunkMetadata) {
+		sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+	}
+	byIndex(report.Missing)
+	byIndex(report.Corrupt)
+	return &report, nil
+}
@@ -0,0 +1,68 @@
+package zap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckChunksReportsAllFailures(t *testing.T) {
+	chunksDir := t.TempDir()
+	metadata := &FileMetadata{ChunkCount: 8}
+	for i := 0; i < metadata.ChunkCount; i++ {
+		chunk := ChunkMetadata{Index: i, Hash: fmt.Sprintf("hash%d", i), Size: 16, EncryptedHash: fmt.Sprintf("enc%d", i)}
+		metadata.Chunks = append(metadata.Chunks, chunk)
+		metadata.TotalSize += chunk.Size
+
+		size := chunk.Size
+		switch i {
+		case 2, 5:
+			continue // Missing
+		case 6:
+			size-- // Corrupt
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(chunksDir, chunk.EncryptedHash), make([]byte, size), 0644))
+	}
+
+	report, err := CheckChunks(metadata, chunksDir, 3)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	require.Len(t, report.Missing, 2)
+	assert.Equal(t, 2, report.Missing[0].Index)
+	assert.Equal(t, 5, report.Missing[1].Index)
+	require.Len(t, report.Corrupt, 1)
+	assert.Equal(t, 6, report.Corrupt[0].Index)
+
+	err = ValidateChunks(metadata, chunksDir)
+	var got *ChunkReport
+	require.True(t, errors.As(err, &got))
+	assert.Contains(t, err.Error(), "enc2, enc5")
+
+	_, err = CheckChunks(metadata, filepath.Join(chunksDir, "absent"), 3)
+	assert.Error(t, err)
+}
+
+func TestValidateChunkHashAlgorithms(t *testing.T) {
+	chunkPath := filepath.Join(t.TempDir(), "chunk")
+	data := []byte("chunk data")
+	require.NoError(t, os.WriteFile(chunkPath, data, 0644))
+
+	for _, algorithm := range []HashAlgorithm{"", HashSHA256, HashBLAKE3} {
+		hash, err := algorithm.Sum(data)
+		require.NoError(t, err)
+		chunk := ChunkMetadata{Hash: hash, Size: int64(len(data))}
+		assert.NoError(t, ValidateChunk(chunk, chunkPath, data, algorithm), "algorithm %q", algorithm)
+	}
+
+	// SHA-256 and BLAKE3 names do not verify under the other algorithm
+	hash, err := HashBLAKE3.Sum(data)
+	require.NoError(t, err)
+	chunk := ChunkMetadata{Hash: hash, Size: int64(len(data))}
+	assert.Error(t, ValidateChunk(chunk, chunkPath, data, HashSHA256))
+	assert.Error(t, ValidateChunk(chunk, chunkPath, data, "md5"))
+}
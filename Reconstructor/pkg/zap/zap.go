@@ -1,12 +1,9 @@
 package zap
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 )
 
 // FileMetadata represents the metadata stored in a .zap file
@@ -16,6 +13,7 @@ type FileMetadata struct {
 	ChunkCount    int             `json:"chunk_count"`
 	TotalSize     int64           `json:"total_size"`
 	EncryptionKey string          `json:"encryption_key"`
+	HashAlgorithm HashAlgorithm   `json:"hash_algorithm,omitempty"` // Hash of the chunks, SHA-256 if empty
 	Chunks        []ChunkMetadata `json:"chunks"`
 }
 
@@ -45,6 +43,10 @@ func ReadZapFile(zapPath string) (*FileMetadata, error) {
 		return nil, fmt.Errorf("invalid zap file: missing required fields")
 	}
 
+	if _, err := metadata.HashAlgorithm.Sum(nil); err != nil {
+		return nil, fmt.Errorf("invalid zap file: %v", err)
+	}
+
 	// Validate chunk count matches actual chunks
 	if len(metadata.Chunks) != metadata.ChunkCount {
 		return nil, fmt.Errorf("chunk count mismatch: expected %d, got %d",
@@ -66,8 +68,9 @@ func ReadZapFile(zapPath string) (*FileMetadata, error) {
 	return &metadata, nil
 }
 
-// ValidateChunk performs comprehensive validation of a single chunk
-func ValidateChunk(chunk ChunkMetadata, chunkPath string, decryptedData []byte) error {
+// ValidateChunk performs comprehensive validation of a single chunk,
+// hashed with algorithm
+func ValidateChunk(chunk ChunkMetadata, chunkPath string, decryptedData []byte, algorithm HashAlgorithm) error {
 	// Check if chunk exists
 	if _, err := os.Stat(chunkPath); err != nil {
 		return fmt.Errorf("chunk file missing or inaccessible: %s", chunk.EncryptedHash)
@@ -80,38 +83,33 @@ func ValidateChunk(chunk ChunkMetadata, chunkPath string, decryptedData []byte)
 	}
 
 	// Verify chunk hash
-	hash := sha256.Sum256(decryptedData)
-	if hex.EncodeToString(hash[:]) != chunk.Hash {
+	hash, err := algorithm.Sum(decryptedData)
+	if err != nil {
+		return err
+	}
+	if hash != chunk.Hash {
 		return fmt.Errorf("chunk hash mismatch: possible tampering detected")
 	}
 
 	return nil
 }
 
-// ValidateChunks verifies all chunks exist and have correct sizes
+// ValidateChunks verifies all chunks exist and have correct sizes. If
+// any do not, the error is a *ChunkReport listing every such chunk.
 func ValidateChunks(metadata *FileMetadata, chunksDir string) error {
+	report, err := CheckChunks(metadata, chunksDir, DefaultValidationWorkers)
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return report
+	}
+
+	// Validate total size
 	var totalSize int64
 	for _, chunk := range metadata.Chunks {
-		chunkPath := filepath.Join(chunksDir, chunk.EncryptedHash)
-		info, err := os.Stat(chunkPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return fmt.Errorf("chunk file missing: %s", chunk.EncryptedHash)
-			}
-			return fmt.Errorf("failed to access chunk: %v", err)
-		}
-
-// Verify encrypted chunk size
-if info.Size() != chunk.Size {
-    return fmt.Errorf("chunk size mismatch for %s: expected %d, got %d",
-        chunk.EncryptedHash, chunk.Size, info.Size())
-}
-
-		// Track total size for final validation
 		totalSize += chunk.Size
 	}
-
-	// Validate total size
 	if totalSize != metadata.TotalSize {
 		return fmt.Errorf("total size mismatch: expected %d, got %d",
 			metadata.TotalSize, totalSize)